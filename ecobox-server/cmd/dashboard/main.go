@@ -11,19 +11,30 @@ import (
 	"syscall"
 	"time"
 
+	"ecobox-server/internal/alerts"
+	"ecobox-server/internal/audit"
 	"ecobox-server/internal/auth"
+	"ecobox-server/internal/certs"
 	"ecobox-server/internal/config"
 	"ecobox-server/internal/control"
+	"ecobox-server/internal/discovery"
+	"ecobox-server/internal/homeassistant"
 	"ecobox-server/internal/models"
 	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/proxy"
+	"ecobox-server/internal/registry"
+	"ecobox-server/internal/reload"
+	"ecobox-server/internal/secrets"
 	"ecobox-server/internal/storage"
 	"ecobox-server/internal/web"
+	"ecobox-server/internal/webhooks"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
 	// Parse command-line flags
 	configPath := flag.String("config", "config.toml", "Path to configuration file")
+	rotateMasterKey := flag.Bool("rotate-master-key", false, "Encrypt the secret vault under a new master key and exit")
 	flag.Parse()
 
 	// Load configuration
@@ -38,6 +49,25 @@ func main() {
 	logger.Info("Starting Network Dashboard")
 	logger.Infof("Configuration loaded from: %s", *configPath)
 
+	// Open the encrypted vault credentials are kept in
+	vault := secrets.NewVault(cfg)
+	vault.SetLogger(logger)
+	if err := vault.Open(); err != nil {
+		logger.Fatalf("Failed to open secret vault: %v", err)
+	}
+	if *rotateMasterKey {
+		if err := rotateVaultKey(cfg, vault); err != nil {
+			logger.Fatalf("Failed to rotate master key: %v", err)
+		}
+		logger.Info("Master key rotated")
+		return
+	}
+	for name, fields := range cfg.SecretRefs() {
+		if _, err := vault.Get(name); err != nil {
+			logger.Warnf("Secret %s used by %v is not in the vault", name, fields)
+		}
+	}
+
 	// Initialize storage
 	storage := storage.NewMemoryStorage()
 	logger.Info("Initialized memory storage")
@@ -47,37 +77,107 @@ func main() {
 		logger.Fatalf("Failed to load servers from configuration: %v", err)
 	}
 
+	// Load servers added through the API; the configuration file takes precedence
+	serverRegistry := registry.NewRegistry(cfg, storage)
+	serverRegistry.SetLogger(logger)
+	if err := serverRegistry.Load(); err != nil {
+		logger.Fatalf("Failed to load API servers: %v", err)
+	}
+
 	// Create power manager
 	powerManager := control.NewPowerManager(storage)
 	powerManager.SetLogger(logger)
+	powerManager.SetSecrets(vault)
 	logger.Info("Initialized power manager")
 
 	// Initialize authentication
 	authManager := auth.NewManager(cfg)
 	authManager.SetLogger(logger)
+	authManager.SetSecrets(vault)
 	if err := authManager.Initialize(); err != nil {
 		logger.Fatalf("Failed to initialize authentication: %v", err)
 	}
 	logger.Info("Initialized authentication system")
 
+	// Create alert manager for the configured rules
+	alertManager := alerts.NewManager(cfg)
+	alertManager.SetLogger(logger)
+	alertManager.SetSecrets(vault)
+
 	// Create monitor
 	monitor := monitor.NewMonitor(cfg, storage, powerManager)
 	monitor.SetLogger(logger)
+	monitor.SetAlertManager(alertManager)
+	monitor.SetSecrets(vault)
 	logger.Info("Initialized server monitor")
 
+	// Reload the configuration on SIGHUP, through the API and, with watch_config, on file changes
+	reloader := reload.NewReloader(*configPath, cfg, serverRegistry, monitor)
+	reloader.SetLogger(logger)
+
+	// Sweep the discovery networks for machines that are not servers yet
+	discoveryScanner := discovery.NewScanner(cfg, storage, serverRegistry, monitor.GetPortScanner())
+	discoveryScanner.SetLogger(logger)
+
+	// Record API changes and server actions in the audit log
+	auditLog := audit.NewLog(cfg)
+	auditLog.SetLogger(logger)
+	storage.AddActionListener(auditLog.HandleAction)
+
+	// Send server updates and actions to the registered webhooks
+	webhookDispatcher := webhooks.NewDispatcher(cfg)
+	webhookDispatcher.SetLogger(logger)
+	if err := webhookDispatcher.Load(); err != nil {
+		logger.Fatalf("Failed to load webhooks: %v", err)
+	}
+	storage.AddActionListener(webhookDispatcher.HandleAction)
+	webhookDispatcher.Start(monitor.Subscribe())
+
+	// Publish servers to Home Assistant over MQTT when a broker is configured
+	mqttBridge := homeassistant.NewBridge(cfg, storage, monitor)
+	mqttBridge.SetLogger(logger)
+	mqttBridge.SetAuditLog(auditLog)
+	mqttBridge.SetSecrets(vault)
+
 	// Create web server
-	webServer := web.NewWebServer(cfg, storage, monitor, powerManager, authManager)
+	webServer := web.NewWebServer(cfg, storage, monitor, powerManager, serverRegistry, reloader, discoveryScanner, alertManager, webhookDispatcher, auditLog, authManager)
 	webServer.SetLogger(logger)
+	webServer.SetSecrets(vault)
 	logger.Info("Initialized web server")
 
+	// Load or create the HTTPS certificate, and renew it in the background
+	var certManager *certs.Manager
+	if cfg.TLS.Enabled {
+		certManager = certs.NewManager(cfg)
+		certManager.SetLogger(logger)
+		certManager.SetSecrets(vault)
+		if err := certManager.Load(); err != nil {
+			logger.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		certManager.Start()
+		webServer.SetCertManager(certManager)
+		logger.Infof("Initialized %s TLS certificate", cfg.TLS.Mode())
+	}
+
 	// Start monitor
 	monitor.Start()
 	logger.Info("Server monitor started")
 
+	// Start wake-on-demand proxies for services with a proxy port
+	proxyManager := proxy.NewManager(cfg, storage, monitor)
+	proxyManager.SetLogger(logger)
+	proxyManager.Start()
+	serverRegistry.AddChangeListener(proxyManager.Sync)
+
+	// Start periodic discovery sweeps
+	discoveryScanner.Start()
+
+	// Start the MQTT bridge
+	mqttBridge.Start()
+
 	// Start web server in goroutine with error handling
 	webServerErr := make(chan error, 1)
 	go func() {
-		logger.Infof("Starting web server on port %d", cfg.Dashboard.Port)
 		if err := webServer.Start(); err != nil {
 			if err != http.ErrServerClosed {
 				webServerErr <- err
@@ -87,6 +187,24 @@ func main() {
 		webServerErr <- nil
 	}()
 
+	// Reload configuration on SIGHUP or file changes until shutdown
+	stopReload := make(chan struct{})
+	go reloader.Watch(stopReload)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			logger.Info("Received SIGHUP, reloading configuration")
+			entry := audit.Entry{Actor: "SIGHUP", ActorType: audit.ActorSystem, Action: "config.reload", Success: true}
+			if _, err := reloader.Reload(); err != nil {
+				logger.Errorf("Failed to reload configuration, keeping the running configuration: %v", err)
+				entry.Success = false
+				entry.Error = err.Error()
+			}
+			auditLog.Record(entry)
+		}
+	}()
+
 	// Wait for either interrupt signal or web server error
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -113,12 +231,36 @@ func main() {
 		logger.Errorf("Failed to shutdown web server: %v", err)
 	}
 
-	// Stop monitor
+	// Stop reloading, discovery, MQTT, proxies, monitor and webhooks
+	signal.Stop(hangup)
+	close(stopReload)
+	discoveryScanner.Stop()
+	mqttBridge.Stop()
+	proxyManager.Stop()
 	monitor.Stop()
+	webhookDispatcher.Stop()
+	if certManager != nil {
+		certManager.Stop()
+	}
+	auditLog.Close()
 
 	logger.Info("Network Dashboard stopped")
 }
 
+// rotateVaultKey encrypts the vault under a new master key. A key from the
+// environment is replaced by the one in the same variable suffixed _NEW, which
+// has to be set in its place afterwards; a key file gets a generated key.
+func rotateVaultKey(cfg *config.Config, vault *secrets.Vault) error {
+	if !vault.KeyFromEnv() {
+		return vault.RotateKey("")
+	}
+	newKey := os.Getenv(cfg.Secrets.KeyEnv + "_NEW")
+	if newKey == "" {
+		return fmt.Errorf("set the new master key in $%s_NEW", cfg.Secrets.KeyEnv)
+	}
+	return vault.RotateKey(newKey)
+}
+
 // setupLogging configures the logger based on the specified log level and optional log file
 func setupLogging(logLevel string, logFile string) *logrus.Logger {
 	logger := logrus.New()
@@ -164,42 +306,7 @@ func setupLogging(logLevel string, logFile string) *logrus.Logger {
 // loadServersFromConfig loads servers from configuration into storage
 func loadServersFromConfig(cfg *config.Config, storage storage.Storage, logger *logrus.Logger) error {
 	for _, serverConfig := range cfg.Servers {
-		// Create services from configuration
-		services := make([]models.Service, len(serverConfig.Services))
-		for i, serviceConfig := range serverConfig.Services {
-			serviceType := models.ServiceType(serviceConfig.Type)
-			if serviceType == "" {
-				serviceType = models.DetectServiceType(serviceConfig.Port)
-			}
-
-			services[i] = models.Service{
-				ID:       fmt.Sprintf("%s-%s", serverConfig.ID, serviceConfig.Name),
-				ServerID: serverConfig.ID,
-				Name:     serviceConfig.Name,
-				Port:     serviceConfig.Port,
-				Type:     serviceType,
-				Status:   models.ServiceStatusDown, // Will be updated by monitor
-				Source:   models.SourceConfig,
-			}
-		}
-
-		// Create server
-		server := &models.Server{
-			ID:             serverConfig.ID,
-			Name:           serverConfig.Name,
-			Hostname:       serverConfig.Hostname,
-			MACAddress:     serverConfig.MACAddress,
-			CurrentState:   models.PowerStateUnknown,
-			DesiredState:   models.PowerStateUnknown,
-			ParentServerID: serverConfig.ParentServerID,
-			Services:       services,
-			Source:         models.SourceConfig,
-			SSHUser:        serverConfig.SSHUser,
-			SSHPort:        serverConfig.SSHPort,
-			SSHKeyPath:     serverConfig.SSHKeyPath,
-			RecentActions:  make([]models.ServerAction, 0),
-			LastStateChange: time.Now(),
-		}
+		server := serverConfig.ToServer(models.SourceConfig)
 
 		// Add server to storage
 		if err := storage.AddServer(server); err != nil {
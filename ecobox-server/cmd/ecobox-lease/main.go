@@ -0,0 +1,360 @@
+// Command ecobox-lease holds a server on through the dashboard's keep-awake lease API.
+//
+// Usage:
+//
+//	ecobox-lease -url http://dashboard:8080 -server nas -duration 30m acquire
+//	ecobox-lease -url http://dashboard:8080 -server nas renew <lease-id>
+//	ecobox-lease -url http://dashboard:8080 -server nas release <lease-id>
+//	ecobox-lease -url http://dashboard:8080 -server nas list
+//	ecobox-lease -url http://dashboard:8080 -server nas -duration 10m run -- restic backup /data
+//
+// The run command acquires a lease, renews it while the command runs and releases
+// it afterwards. Credentials come from -user with ECOBOX_PASSWORD, or from an
+// API token in ECOBOX_TOKEN. Logins are refreshed while a long command runs.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type client struct {
+	baseURL  string
+	serverID string
+	token    string // API token, or the access token of a login
+	refresh  string // Refresh token of a login
+	csrf     string // CSRF token of a login, sent back as cookie and header
+	http     *http.Client
+}
+
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type lease struct {
+	ID        string    `json:"id"`
+	Holder    string    `json:"holder"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "Dashboard URL")
+	serverID := flag.String("server", "", "Server ID to hold on")
+	duration := flag.Duration("duration", 30*time.Minute, "Lease duration")
+	holder := flag.String("holder", "", "Lease holder name (defaults to the user)")
+	reason := flag.String("reason", "", "Reason shown on the dashboard")
+	username := flag.String("user", "", "Dashboard user (password from ECOBOX_PASSWORD)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] acquire|renew <id>|release <id>|list|run -- <command>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *serverID == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		baseURL:  strings.TrimRight(*baseURL, "/"),
+		serverID: *serverID,
+		token:    os.Getenv("ECOBOX_TOKEN"),
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}
+	if c.token == "" {
+		if err := c.login(*username, os.Getenv("ECOBOX_PASSWORD")); err != nil {
+			fatalf("Login failed: %v", err)
+		}
+	}
+
+	args := flag.Args()
+	switch args[0] {
+	case "acquire":
+		l, err := c.acquire(*holder, *reason, *duration)
+		if err != nil {
+			fatalf("Failed to acquire lease: %v", err)
+		}
+		fmt.Println(l.ID)
+
+	case "renew":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		l, err := c.renew(args[1], *duration)
+		if err != nil {
+			fatalf("Failed to renew lease: %v", err)
+		}
+		fmt.Printf("%s held until %s\n", l.ID, l.ExpiresAt.Local().Format(time.RFC3339))
+
+	case "release":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := c.release(args[1]); err != nil {
+			fatalf("Failed to release lease: %v", err)
+		}
+
+	case "list":
+		var leases []lease
+		if err := c.do("GET", c.leasesPath(""), nil, &leases); err != nil {
+			fatalf("Failed to list leases: %v", err)
+		}
+		for _, l := range leases {
+			fmt.Printf("%s\t%s\t%s\t%s\n", l.ID, l.Holder, l.ExpiresAt.Local().Format(time.RFC3339), l.Reason)
+		}
+
+	case "run":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(c.run(args[1:], *holder, *reason, *duration))
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// run holds a lease for as long as the command runs and returns its exit code
+func (c *client) run(command []string, holder, reason string, duration time.Duration) int {
+	if reason == "" {
+		reason = strings.Join(command, " ")
+	}
+
+	l, err := c.acquire(holder, reason, duration)
+	if err != nil {
+		fatalf("Failed to acquire lease: %v", err)
+	}
+	defer func() {
+		if err := c.release(l.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to release lease %s: %v\n", l.ID, err)
+		}
+	}()
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start command: %v\n", err)
+		return 1
+	}
+
+	// Forward signals so the lease is still released when the command is interrupted
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	// Renew well before the lease runs out
+	ticker := time.NewTicker(duration / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return exitErr.ExitCode()
+			}
+			if err != nil {
+				return 1
+			}
+			return 0
+		case sig := <-signals:
+			cmd.Process.Signal(sig)
+		case <-ticker.C:
+			if _, err := c.renew(l.ID, duration); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to renew lease %s: %v\n", l.ID, err)
+			}
+		}
+	}
+}
+
+func (c *client) acquire(holder, reason string, duration time.Duration) (*lease, error) {
+	var l lease
+	body := map[string]string{"holder": holder, "reason": reason, "duration": duration.String()}
+	if err := c.do("POST", c.leasesPath(""), body, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (c *client) renew(id string, duration time.Duration) (*lease, error) {
+	var l lease
+	body := map[string]string{"duration": duration.String()}
+	if err := c.do("PUT", c.leasesPath(id), body, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (c *client) release(id string) error {
+	return c.do("DELETE", c.leasesPath(id), nil, nil)
+}
+
+func (c *client) leasesPath(id string) string {
+	path := "/api/servers/" + url.PathEscape(c.serverID) + "/leases"
+	if id != "" {
+		path += "/" + url.PathEscape(id)
+	}
+	return path
+}
+
+// login posts the login form and keeps the auth cookie from the response
+func (c *client) login(username, password string) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("set ECOBOX_TOKEN, or -user and ECOBOX_PASSWORD")
+	}
+
+	noRedirect := *c.http
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := noRedirect.PostForm(c.baseURL+"/login", url.Values{
+		"username": {username},
+		"password": {password},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	c.keepCookies(resp)
+	if c.token == "" {
+		return fmt.Errorf("invalid username or password")
+	}
+	return nil
+}
+
+// refreshLogin exchanges the refresh token of a login for new tokens once the
+// access token has expired
+func (c *client) refreshLogin() error {
+	body, _ := json.Marshal(map[string]string{"refresh_token": c.refresh})
+	resp, err := c.http.Post(c.baseURL+"/api/auth/refresh", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Data    struct {
+			AccessToken  string `json:"access_token"`
+			RefreshToken string `json:"refresh_token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("unexpected response (HTTP %d)", resp.StatusCode)
+	}
+	if !result.Success {
+		return fmt.Errorf("%s (HTTP %d)", result.Message, resp.StatusCode)
+	}
+	c.token = result.Data.AccessToken
+	c.refresh = result.Data.RefreshToken
+	c.keepCookies(resp) // The new session comes with a new CSRF token
+	return nil
+}
+
+// keepCookies keeps the session cookies the dashboard sets on a response
+func (c *client) keepCookies(resp *http.Response) {
+	for _, cookie := range resp.Cookies() {
+		switch {
+		case cookie.Value == "":
+		case cookie.Name == "auth_token":
+			c.token = cookie.Value
+		case cookie.Name == "refresh_token":
+			c.refresh = cookie.Value
+		case cookie.Name == "XSRF-TOKEN":
+			c.csrf = cookie.Value
+		}
+	}
+}
+
+// do sends an API request and decodes the data field of the response into out.
+// A login whose access token expired is refreshed and the request sent again.
+func (c *client) do(method, path string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.send(method, path, payload)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && c.refresh != "" {
+		resp.Body.Close()
+		if err := c.refreshLogin(); err != nil {
+			return fmt.Errorf("session expired: %v", err)
+		}
+		if resp, err = c.send(method, path, payload); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	var result apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("unexpected response (HTTP %d)", resp.StatusCode)
+	}
+	if !result.Success {
+		return fmt.Errorf("%s (HTTP %d)", result.Message, resp.StatusCode)
+	}
+	if out != nil && len(result.Data) > 0 {
+		return json.Unmarshal(result.Data, out)
+	}
+	return nil
+}
+
+// send sends one API request with the client's credentials
+func (c *client) send(method, path string, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if strings.HasPrefix(c.token, "ebx_") {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else {
+		// Changes sent with the session cookie must repeat its CSRF token
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: c.token})
+		if c.csrf != "" {
+			req.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: c.csrf})
+			req.Header.Set("X-XSRF-TOKEN", c.csrf)
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err == nil && !strings.HasPrefix(c.token, "ebx_") {
+		c.keepCookies(resp)
+	}
+	return resp, err
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testDashboard stands in for the lease API behind a login. Like the
+// dashboard, it refuses changes sent with the session cookie unless the CSRF
+// cookie and header match, and issues a new CSRF token on every refresh.
+type testDashboard struct {
+	server  *httptest.Server
+	access  string // Access token the API accepts
+	csrf    string // CSRF token of the current session
+	renewed int
+}
+
+func newTestDashboard(t *testing.T) *testDashboard {
+	d := &testDashboard{access: "access-1", csrf: "csrf-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("username") != "alice" || r.PostFormValue("password") != "secret" {
+			http.Redirect(w, r, "/login?error=1", http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "auth_token", Value: d.access})
+		http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "refresh-1"})
+		http.SetCookie(w, &http.Cookie{Name: "XSRF-TOKEN", Value: d.csrf})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/api/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		d.access, d.csrf = "access-2", "csrf-2"
+		http.SetCookie(w, &http.Cookie{Name: "XSRF-TOKEN", Value: d.csrf})
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    map[string]string{"access_token": d.access, "refresh_token": "refresh-2"},
+		})
+	})
+	leases := func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("auth_token"); err != nil || cookie.Value != d.access {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Authentication required"})
+			return
+		}
+		if cookie, err := r.Cookie("XSRF-TOKEN"); err != nil || cookie.Value != d.csrf || r.Header.Get("X-XSRF-TOKEN") != d.csrf {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Missing or invalid CSRF token"})
+			return
+		}
+		d.renewed++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"data":    lease{ID: "lease-1", Holder: "alice", ExpiresAt: time.Now().Add(time.Hour)},
+		})
+	}
+	mux.HandleFunc("/api/servers/nas/leases", leases)
+	mux.HandleFunc("/api/servers/nas/leases/", leases)
+	d.server = httptest.NewServer(mux)
+	t.Cleanup(d.server.Close)
+	return d
+}
+
+func TestLoginSendsCSRFToken(t *testing.T) {
+	d := newTestDashboard(t)
+	c := &client{baseURL: d.server.URL, serverID: "nas", http: &http.Client{Timeout: 5 * time.Second}}
+
+	if err := c.login("alice", "wrong"); err == nil {
+		t.Error("Expected a wrong password to be refused")
+	}
+	if err := c.login("alice", "secret"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, err := c.acquire("alice", "backup", time.Hour); err != nil {
+		t.Fatalf("Expected a change with the login's CSRF token to be accepted, got %v", err)
+	}
+
+	// An expired access token is refreshed, and the retry carries the CSRF
+	// token of the new session
+	d.access = "expired"
+	if _, err := c.renew("lease-1", time.Hour); err != nil {
+		t.Fatalf("Expected the renewal to succeed after a refresh, got %v", err)
+	}
+	if c.csrf != "csrf-2" || d.renewed != 2 {
+		t.Errorf("Expected the refreshed CSRF token and two changes, got %q and %d", c.csrf, d.renewed)
+	}
+}
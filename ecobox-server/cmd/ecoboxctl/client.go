@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// client calls the dashboard's versioned API. Logins keep their cookies in a
+// jar, so the dashboard refreshes expired access tokens on the way.
+type client struct {
+	baseURL string
+	token   string // API token
+	http    *http.Client
+	tls     *tls.Config
+}
+
+// apiError is the error body of the versioned API
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// errNotAuthorized is returned when the dashboard refuses the credentials
+var errNotAuthorized = errors.New("not authorized")
+
+// loginToken finds the pending login of the second-factor page
+var loginToken = regexp.MustCompile(`name="login_token" value="([^"]+)"`)
+
+func newClient(baseURL, caFile string) (*client, error) {
+	c := &client{baseURL: strings.TrimRight(baseURL, "/")}
+
+	// Dashboards serving HTTPS with their own CA pass its certificate
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		c.tls = &tls.Config{RootCAs: pool}
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c.http = &http.Client{
+		Timeout:   30 * time.Second,
+		Jar:       jar,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: c.tls},
+	}
+	return c, nil
+}
+
+// login posts the login form, and the code of a second factor when the user
+// has one. The jar keeps the session cookies.
+func (c *client) login(username, password, code string) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("set ECOBOX_TOKEN, or -user and ECOBOX_PASSWORD")
+	}
+
+	noRedirect := *c.http
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	page, err := c.postLogin(&noRedirect, url.Values{"username": {username}, "password": {password}})
+	if err != nil {
+		return err
+	}
+	if !c.loggedIn() {
+		match := loginToken.FindSubmatch(page)
+		if match == nil {
+			return fmt.Errorf("invalid username or password")
+		}
+		if code == "" {
+			return fmt.Errorf("%s has two-factor authentication: pass -otp, or use an API token", username)
+		}
+		if _, err := c.postLogin(&noRedirect, url.Values{"login_token": {string(match[1])}, "code": {code}}); err != nil {
+			return err
+		}
+		if !c.loggedIn() {
+			return fmt.Errorf("invalid authentication code")
+		}
+	}
+	return nil
+}
+
+// postLogin posts a login form and returns the page it answers with
+func (c *client) postLogin(httpClient *http.Client, form url.Values) ([]byte, error) {
+	resp, err := httpClient.PostForm(c.baseURL+"/login", form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// loggedIn reports whether the jar holds a session
+func (c *client) loggedIn() bool {
+	return c.cookie("auth_token") != "" || c.cookie("refresh_token") != ""
+}
+
+// cookie returns the value of a cookie the dashboard set
+func (c *client) cookie(name string) string {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return ""
+	}
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// do sends a request to the versioned API and returns the response body.
+// Errors carry the message and code of the API.
+func (c *client) do(method, path string, body interface{}) ([]byte, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+"/api/v1"+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req.Header)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		var result apiError
+		if err := json.Unmarshal(data, &result); err != nil || result.Error.Code == "" {
+			return nil, fmt.Errorf("unexpected response (HTTP %d)", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("%s (%s)", result.Error.Message, result.Error.Code)
+	}
+	return data, nil
+}
+
+// get sends a GET request and decodes the response into out
+func (c *client) get(path string, query url.Values, out interface{}) ([]byte, error) {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	data, err := c.do("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// authorize adds the API token, or the CSRF token changes made with the
+// session cookies need
+func (c *client) authorize(header http.Header) {
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	} else if csrf := c.cookie("XSRF-TOKEN"); csrf != "" {
+		header.Set("X-XSRF-TOKEN", csrf)
+	}
+}
+
+// dial opens the WebSocket the dashboard streams updates on
+func (c *client) dial() (*websocket.Conn, error) {
+	u, err := url.Parse(c.baseURL + "/ws")
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+		TLSClientConfig:  c.tls,
+		Jar:              c.http.Jar,
+	}
+	header := make(http.Header)
+	c.authorize(header)
+
+	conn, resp, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		if resp != nil {
+			// Unauthenticated requests are answered 401, or sent to the login page
+			switch resp.StatusCode {
+			case http.StatusUnauthorized, http.StatusForbidden, http.StatusFound:
+				return nil, fmt.Errorf("%w (HTTP %d)", errNotAuthorized, resp.StatusCode)
+			}
+			return nil, fmt.Errorf("%v (HTTP %d)", err, resp.StatusCode)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDashboard stands in for the login form and the versioned API. Like the
+// dashboard, it refuses changes sent with the session cookie unless the CSRF
+// cookie and header match.
+type testDashboard struct {
+	server *httptest.Server
+	polls  int // Requests for the operation
+}
+
+func newTestDashboard(t *testing.T) *testDashboard {
+	d := &testDashboard{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch {
+		case r.PostForm.Get("username") == "alice" && r.PostForm.Get("password") == "secret":
+		case r.PostForm.Get("username") == "bob" && r.PostForm.Get("password") == "secret":
+			// Bob has a second factor, asked for on the next page
+			fmt.Fprint(w, `<form><input type="hidden" name="login_token" value="pending-1"><input name="code"></form>`)
+			return
+		case r.PostForm.Get("login_token") == "pending-1" && r.PostForm.Get("code") == "123456":
+		default:
+			fmt.Fprint(w, `<p>Invalid username or password</p>`)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "auth_token", Value: "access-1", Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "refresh-1", Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "XSRF-TOKEN", Value: "csrf-1", Path: "/"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/api/v1/servers/nas/wake", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("XSRF-TOKEN")
+		if err != nil || r.Header.Get("X-XSRF-TOKEN") != cookie.Value {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": {"code": "forbidden", "message": "Missing or invalid CSRF token"}}`)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(operation{ID: "op-1", ServerID: "nas", Action: "wake", TargetState: "on", Status: "pending"})
+	})
+	mux.HandleFunc("/api/v1/operations/op-1", func(w http.ResponseWriter, r *http.Request) {
+		d.polls++
+		op := operation{ID: "op-1", ServerID: "nas", Action: "wake", TargetState: "on", Status: "running"}
+		if d.polls >= 3 {
+			op.Status = "succeeded"
+		}
+		json.NewEncoder(w).Encode(op)
+	})
+	d.server = httptest.NewServer(mux)
+	t.Cleanup(d.server.Close)
+	return d
+}
+
+func TestLogin(t *testing.T) {
+	d := newTestDashboard(t)
+
+	tests := []struct {
+		username, password, code string
+		wantErr                  string
+	}{
+		{"alice", "secret", "", ""},
+		{"alice", "wrong", "", "invalid username or password"},
+		{"bob", "secret", "", "two-factor authentication"},
+		{"bob", "secret", "000000", "invalid authentication code"},
+		{"bob", "secret", "123456", ""},
+	}
+	for _, test := range tests {
+		c, err := newClient(d.server.URL, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.login(test.username, test.password, test.code)
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("Expected %s to log in with code %q, got %v", test.username, test.code, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("Expected the login of %s with code %q to fail with %q, got %v", test.username, test.code, test.wantErr, err)
+		case test.wantErr == "" && c.cookie("XSRF-TOKEN") != "csrf-1":
+			t.Errorf("Expected the login of %s to keep the CSRF cookie", test.username)
+		}
+	}
+}
+
+func TestActionWaits(t *testing.T) {
+	d := newTestDashboard(t)
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+
+	c, err := newClient(d.server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made with the session cookies must repeat the CSRF token
+	if err := c.login("alice", "secret", ""); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	resp, err := c.http.Post(d.server.URL+"/api/v1/servers/nas/wake", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a change without the CSRF header to be refused, got HTTP %d", resp.StatusCode)
+	}
+	if _, err := c.do("POST", "/servers/nas/wake", nil); err != nil {
+		t.Fatalf("Expected the change to carry the CSRF token, got %v", err)
+	}
+
+	// -wait polls the operation until it finishes and prints it
+	var out bytes.Buffer
+	ctl := &cli{client: c, printer: &printer{format: formatJSON, out: &out}}
+	if err := ctl.action("wake", []string{"nas", "-wait"}); err != nil {
+		t.Fatalf("Wake failed: %v", err)
+	}
+	var op operation
+	if err := json.Unmarshal(out.Bytes(), &op); err != nil {
+		t.Fatalf("Expected the operation as JSON, got %q", out.String())
+	}
+	if op.Status != "succeeded" || d.polls != 3 {
+		t.Errorf("Expected the operation to be polled until it succeeded, got %s after %d polls", op.Status, d.polls)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// bashCompletion completes commands, their flags and server IDs, which it asks
+// the dashboard for with the credentials in the environment
+const bashCompletion = `# bash completion for ecoboxctl
+# Load with: source <(ecoboxctl completion bash)
+_ecoboxctl() {
+    local cur=${COMP_WORDS[COMP_CWORD]} command="" sub="" i
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case ${COMP_WORDS[i]} in
+            -url|-user|-otp|-cacert|-o) ((i++)) ;;
+            -*) ;;
+            *) if [[ -z $command ]]; then command=${COMP_WORDS[i]}; elif [[ -z $sub ]]; then sub=${COMP_WORDS[i]}; fi ;;
+        esac
+    done
+
+    if [[ ${COMP_WORDS[COMP_CWORD-1]} == -o ]]; then
+        COMPREPLY=($(compgen -W "table json yaml" -- "$cur"))
+        return
+    fi
+    if [[ $cur == -* ]]; then
+        local flags="-o"
+        case $command in
+            "") flags="-url -user -otp -cacert -o" ;;
+            servers) flags="-o -state -group -source -q" ;;
+            ACTIONS_CASE) flags="-o -reason -cascade -wait -timeout" ;;
+            metrics) flags="-o -since -start -end" ;;
+            users) flags="-o -role" ;;
+        esac
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+        return
+    fi
+
+    case $command in
+        "") COMPREPLY=($(compgen -W "COMMANDS" -- "$cur")) ;;
+        servers)
+            if [[ -z $sub ]]; then
+                COMPREPLY=($(compgen -W "list get" -- "$cur"))
+            elif [[ $sub == get ]]; then
+                COMPREPLY=($(compgen -W "$(ecoboxctl __servers 2>/dev/null)" -- "$cur"))
+            fi ;;
+        metrics)
+            if [[ -z $sub ]]; then
+                COMPREPLY=($(compgen -W "query" -- "$cur"))
+            else
+                COMPREPLY=($(compgen -W "$(ecoboxctl __servers 2>/dev/null)" -- "$cur"))
+            fi ;;
+        users) [[ -z $sub ]] && COMPREPLY=($(compgen -W "list me" -- "$cur")) ;;
+        completion) [[ -z $sub ]] && COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")) ;;
+        ACTIONS_CASE|watch) COMPREPLY=($(compgen -W "$(ecoboxctl __servers 2>/dev/null)" -- "$cur")) ;;
+    esac
+}
+complete -F _ecoboxctl ecoboxctl
+`
+
+// zshCompletion reuses the bash completion through bashcompinit
+const zshCompletion = `#compdef ecoboxctl
+# zsh completion for ecoboxctl
+# Load with: source <(ecoboxctl completion zsh)
+autoload -U +X bashcompinit && bashcompinit
+`
+
+const fishCompletion = `# fish completion for ecoboxctl
+# Load with: ecoboxctl completion fish | source
+function __ecoboxctl_servers
+    ecoboxctl __servers 2>/dev/null
+end
+
+complete -c ecoboxctl -f
+complete -c ecoboxctl -o url -r -d 'Dashboard URL'
+complete -c ecoboxctl -o user -r -d 'Dashboard user'
+complete -c ecoboxctl -o otp -r -d 'Authentication code'
+complete -c ecoboxctl -o cacert -r -F -d 'CA certificate'
+complete -c ecoboxctl -o o -x -a 'table json yaml' -d 'Output format'
+
+complete -c ecoboxctl -n __fish_use_subcommand -a servers -d 'List servers or show one'
+complete -c ecoboxctl -n __fish_use_subcommand -a 'ACTIONS' -d 'Power action'
+complete -c ecoboxctl -n __fish_use_subcommand -a metrics -d 'Query server metrics'
+complete -c ecoboxctl -n __fish_use_subcommand -a users -d 'List users'
+complete -c ecoboxctl -n __fish_use_subcommand -a watch -d 'Stream live updates'
+complete -c ecoboxctl -n __fish_use_subcommand -a completion -d 'Print a shell completion script'
+
+complete -c ecoboxctl -n '__fish_seen_subcommand_from servers; and not __fish_seen_subcommand_from list get' -a 'list get'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from get' -a '(__ecoboxctl_servers)'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from list' -o state -x -d 'Power state'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from list' -o group -x -d 'Group'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from list' -o source -x -a 'config api discovered' -d 'Source'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from list' -o q -x -d 'Text search'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from ACTIONS watch' -a '(__ecoboxctl_servers)'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from ACTIONS' -o reason -x -d 'Reason'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from ACTIONS' -o cascade -d 'Take dependents down first'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from ACTIONS' -o wait -d 'Wait for the state'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from ACTIONS' -o timeout -x -d 'How long to wait'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from metrics; and not __fish_seen_subcommand_from query' -a query
+complete -c ecoboxctl -n '__fish_seen_subcommand_from query' -a '(__ecoboxctl_servers)'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from query' -o since -x -d 'Time range ending now'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from query' -o start -x -d 'RFC 3339 start time'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from query' -o end -x -d 'RFC 3339 end time'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from users; and not __fish_seen_subcommand_from list me' -a 'list me'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from users' -o role -x -a 'viewer operator admin' -d 'Role'
+complete -c ecoboxctl -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+`
+
+// printCompletion prints the completion script of a shell
+func printCompletion(shell string) error {
+	commands := append([]string{"servers", "metrics", "users", "watch", "completion"}, actions...)
+	bash := strings.NewReplacer("ACTIONS_CASE", strings.Join(actions, "|"), "COMMANDS", strings.Join(commands, " ")).Replace(bashCompletion)
+
+	switch shell {
+	case "bash":
+		fmt.Print(bash)
+	case "zsh":
+		fmt.Print(zshCompletion + bash)
+	case "fish":
+		fmt.Print(strings.ReplaceAll(fishCompletion, "ACTIONS", strings.Join(actions, " ")))
+	default:
+		return fmt.Errorf("unknown shell %q, use bash, zsh or fish", shell)
+	}
+	return nil
+}
@@ -0,0 +1,687 @@
+// Command ecoboxctl manages servers through the dashboard's versioned API.
+//
+// Usage:
+//
+//	ecoboxctl servers list -state on -group lab
+//	ecoboxctl servers get nas
+//	ecoboxctl wake nas -wait
+//	ecoboxctl suspend|shutdown|stop|hibernate|restart|reset <server>... [-wait]
+//	ecoboxctl metrics query nas -since 6h
+//	ecoboxctl users list -role admin
+//	ecoboxctl users me
+//	ecoboxctl watch [<server>...]
+//	ecoboxctl completion bash|zsh|fish
+//
+// Output is a table, or the API's JSON or YAML with -o json or -o yaml. The
+// dashboard is -url or ECOBOX_URL. Credentials come from an API token in
+// ECOBOX_TOKEN, or from -user (or ECOBOX_USER) with ECOBOX_PASSWORD, and -otp
+// for users with two-factor authentication.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// errUsage has the usage printed
+var errUsage = errors.New("usage")
+
+// actions are the power actions, in the order usage lists them
+var actions = []string{"wake", "suspend", "hibernate", "shutdown", "stop", "restart", "reset"}
+
+// pollInterval is how often -wait asks for the state of an operation
+var pollInterval = 2 * time.Second
+
+type cli struct {
+	client  *client
+	printer *printer
+}
+
+type server struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Hostname        string    `json:"hostname"`
+	MACAddress      string    `json:"mac_address"`
+	CurrentState    string    `json:"current_state"`
+	DesiredState    string    `json:"desired_state"`
+	Intent          *intent   `json:"intent"`
+	Leases          []lease   `json:"leases"`
+	ParentServerID  string    `json:"parent_server_id"`
+	Groups          []string  `json:"groups"`
+	Services        []service `json:"services"`
+	Source          string    `json:"source"`
+	LastStateChange time.Time `json:"last_state_change"`
+}
+
+type intent struct {
+	State       string     `json:"state"`
+	Reason      string     `json:"reason"`
+	RequestedBy string     `json:"requested_by"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type lease struct {
+	ID        string    `json:"id"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type service struct {
+	Name   string `json:"name"`
+	Port   int    `json:"port"`
+	Status string `json:"status"`
+}
+
+type operation struct {
+	ID          string    `json:"id"`
+	ServerID    string    `json:"server_id"`
+	Action      string    `json:"action"`
+	TargetState string    `json:"target_state"`
+	Status      string    `json:"status"`
+	Message     string    `json:"message"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type user struct {
+	Username         string    `json:"username"`
+	Role             string    `json:"role"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	LastLogin        time.Time `json:"last_login"`
+}
+
+type metricPoint struct {
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type metrics struct {
+	Memory  []metricPoint `json:"memory"`
+	CPU     []metricPoint `json:"cpu"`
+	Network []metricPoint `json:"network"`
+	Wattage []metricPoint `json:"wattage"`
+}
+
+// page is a page of a list endpoint
+type page struct {
+	Items  []json.RawMessage `json:"items"`
+	Total  int               `json:"total"`
+	Limit  int               `json:"limit"`
+	Offset int               `json:"offset"`
+}
+
+// update is a message of the WebSocket
+type update struct {
+	ServerID  string             `json:"server_id"`
+	State     string             `json:"state"`
+	Metrics   map[string]float64 `json:"metrics"`
+	Operation *operation         `json:"operation"`
+}
+
+func main() {
+	baseURL := flag.String("url", envOr("ECOBOX_URL", "http://localhost:8080"), "Dashboard URL (or ECOBOX_URL)")
+	username := flag.String("user", os.Getenv("ECOBOX_USER"), "Dashboard user, password from ECOBOX_PASSWORD (or ECOBOX_USER)")
+	code := flag.String("otp", "", "Authentication code of a user with two-factor authentication")
+	caFile := flag.String("cacert", os.Getenv("ECOBOX_CA_CERT"), "CA certificate of a dashboard with its own CA (or ECOBOX_CA_CERT)")
+	p := &printer{format: formatTable, out: os.Stdout}
+	flag.StringVar(&p.format, "o", formatTable, "Output format: table, json or yaml")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	// Completion needs no dashboard
+	switch args[0] {
+	case "completion":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if err := printCompletion(args[1]); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	case "help":
+		usage()
+		return
+	case "servers", "metrics", "users", "watch", "__servers":
+	default:
+		if !isAction(args[0]) {
+			usage()
+			os.Exit(2)
+		}
+	}
+
+	c, err := newClient(*baseURL, *caFile)
+	if err != nil {
+		fatalf("Invalid settings: %v", err)
+	}
+	c.token = os.Getenv("ECOBOX_TOKEN")
+	if c.token == "" {
+		if err := c.login(*username, os.Getenv("ECOBOX_PASSWORD"), *code); err != nil {
+			fatalf("Login failed: %v", err)
+		}
+	}
+	ctl := &cli{client: c, printer: p}
+
+	switch args[0] {
+	case "servers":
+		err = ctl.servers(args[1:])
+	case "metrics":
+		err = ctl.metrics(args[1:])
+	case "users":
+		err = ctl.users(args[1:])
+	case "watch":
+		err = ctl.watch(args[1:])
+	case "__servers":
+		err = ctl.serverIDs()
+	default:
+		err = ctl.action(args[0], args[1:])
+	}
+	if errors.Is(err, errUsage) {
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [flags] <command>
+
+Commands:
+  servers list [-state s] [-group g] [-source s] [-q text]
+  servers get <server>
+  %s <server>... [-reason r] [-cascade] [-wait] [-timeout d]
+  metrics query <server> [-since d | -start t -end t]
+  users list [-role r]
+  users me
+  watch [<server>...]
+  completion bash|zsh|fish
+
+Flags:
+`, os.Args[0], strings.Join(actions, "|"))
+	flag.PrintDefaults()
+}
+
+// flagSet returns the flags of a command, which take -o as well
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&c.printer.format, "o", c.printer.format, "Output format: table, json or yaml")
+	fs.Usage = usage
+	return fs
+}
+
+// parseArgs parses flags wherever they are among the arguments and returns the
+// other arguments
+func (c *cli) parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	switch c.printer.format {
+	case formatTable, formatJSON, formatYAML:
+		return positional, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, use table, json or yaml", c.printer.format)
+}
+
+func (c *cli) servers(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		fs := c.flagSet("servers list")
+		state := fs.String("state", "", "Only servers in this power state")
+		group := fs.String("group", "", "Only servers in this group")
+		source := fs.String("source", "", "Only servers from this source: config, api or discovered")
+		text := fs.String("q", "", "Only servers whose ID, name or hostname contains this")
+		if rest, err := c.parseArgs(fs, args[1:]); err != nil || len(rest) > 0 {
+			return usageOr(err)
+		}
+
+		query := url.Values{}
+		for name, value := range map[string]string{"state": *state, "group": *group, "source": *source, "q": *text} {
+			if value != "" {
+				query.Set(name, value)
+			}
+		}
+		items, err := c.list("/servers", query)
+		if err != nil {
+			return fmt.Errorf("failed to list servers: %v", err)
+		}
+		return c.printer.print(items, func() (*table, error) {
+			var servers []server
+			if err := json.Unmarshal(items, &servers); err != nil {
+				return nil, err
+			}
+			t := &table{header: []string{"ID", "NAME", "STATE", "DESIRED", "HOSTNAME", "GROUPS"}}
+			for _, s := range servers {
+				t.rows = append(t.rows, []string{s.ID, s.Name, s.CurrentState, orDash(s.DesiredState), s.Hostname, orDash(strings.Join(s.Groups, ","))})
+			}
+			return t, nil
+		})
+
+	case "get":
+		rest, err := c.parseArgs(c.flagSet("servers get"), args[1:])
+		if err != nil || len(rest) != 1 {
+			return usageOr(err)
+		}
+		var s server
+		data, err := c.client.get("/servers/"+url.PathEscape(rest[0]), nil, &s)
+		if err != nil {
+			return fmt.Errorf("failed to get server %s: %v", rest[0], err)
+		}
+		return c.printer.print(data, func() (*table, error) {
+			return serverTable(&s), nil
+		})
+	}
+	return errUsage
+}
+
+// serverTable lists the fields of a server
+func serverTable(s *server) *table {
+	t := &table{header: []string{"FIELD", "VALUE"}}
+	add := func(field, value string) {
+		t.rows = append(t.rows, []string{field, orDash(value)})
+	}
+	add("ID", s.ID)
+	add("Name", s.Name)
+	add("Hostname", s.Hostname)
+	add("MAC address", s.MACAddress)
+	add("State", s.CurrentState)
+	add("Since", formatTime(s.LastStateChange))
+	add("Desired state", s.DesiredState)
+	if s.Intent != nil {
+		requested := s.Intent.RequestedBy
+		if s.Intent.Reason != "" {
+			requested += ": " + s.Intent.Reason
+		}
+		if s.Intent.ExpiresAt != nil {
+			requested += " (until " + formatTime(*s.Intent.ExpiresAt) + ")"
+		}
+		add("Requested by", requested)
+	}
+	add("Parent", s.ParentServerID)
+	add("Groups", strings.Join(s.Groups, ", "))
+	add("Source", s.Source)
+	for _, l := range s.Leases {
+		add("Lease", fmt.Sprintf("%s held by %s until %s", l.ID, l.Holder, formatTime(l.ExpiresAt)))
+	}
+	for _, svc := range s.Services {
+		add("Service", fmt.Sprintf("%s (%d) %s", svc.Name, svc.Port, svc.Status))
+	}
+	return t
+}
+
+// list fetches every page of a list endpoint and returns the items as a JSON
+// array
+func (c *cli) list(path string, query url.Values) ([]byte, error) {
+	items := make([]json.RawMessage, 0)
+	query.Set("limit", "1000")
+	for {
+		query.Set("offset", strconv.Itoa(len(items)))
+		var p page
+		if _, err := c.client.get(path, query, &p); err != nil {
+			return nil, err
+		}
+		items = append(items, p.Items...)
+		if len(p.Items) == 0 || len(items) >= p.Total {
+			break
+		}
+	}
+	return json.Marshal(items)
+}
+
+// serverIDs prints the IDs of the servers, for shell completion
+func (c *cli) serverIDs() error {
+	var p page
+	if _, err := c.client.get("/servers", url.Values{"limit": {"1000"}}, &p); err != nil {
+		return err
+	}
+	for _, item := range p.Items {
+		var s server
+		if err := json.Unmarshal(item, &s); err == nil {
+			fmt.Println(s.ID)
+		}
+	}
+	return nil
+}
+
+func isAction(name string) bool {
+	for _, action := range actions {
+		if action == name {
+			return true
+		}
+	}
+	return false
+}
+
+// action carries out a power action on servers, and waits for it with -wait
+func (c *cli) action(name string, args []string) error {
+	fs := c.flagSet(name)
+	reason := fs.String("reason", "", "Reason shown on the dashboard and in the audit log")
+	cascade := fs.Bool("cascade", false, "Take running dependents down first")
+	wait := fs.Bool("wait", false, "Wait until the servers reach the state")
+	timeout := fs.Duration("timeout", 10*time.Minute, "How long -wait waits")
+	serverIDs, err := c.parseArgs(fs, args)
+	if err != nil || len(serverIDs) == 0 {
+		return usageOr(err)
+	}
+
+	var body interface{}
+	if *reason != "" || *cascade {
+		body = map[string]interface{}{"reason": *reason, "cascade": *cascade}
+	}
+
+	ops := make([]json.RawMessage, 0, len(serverIDs))
+	for _, serverID := range serverIDs {
+		data, err := c.client.do("POST", "/servers/"+url.PathEscape(serverID)+"/"+name, body)
+		if err != nil {
+			return fmt.Errorf("failed to %s %s: %v", name, serverID, err)
+		}
+		ops = append(ops, data)
+	}
+
+	var failed []string
+	if *wait {
+		deadline := time.Now().Add(*timeout)
+		for i, data := range ops {
+			var op operation
+			if err := json.Unmarshal(data, &op); err != nil {
+				return err
+			}
+			if ops[i], err = c.waitOperation(op, name, deadline); err != nil {
+				return err
+			}
+		}
+	}
+	for _, data := range ops {
+		var op operation
+		if err := json.Unmarshal(data, &op); err == nil && (op.Status == "failed" || op.Status == "superseded") {
+			failed = append(failed, fmt.Sprintf("%s %s: %s", op.ServerID, op.Status, op.Message))
+		}
+	}
+
+	output := ops[0]
+	if len(ops) > 1 {
+		if output, err = json.Marshal(ops); err != nil {
+			return err
+		}
+	}
+	err = c.printer.print(output, func() (*table, error) {
+		t := &table{header: []string{"OPERATION", "SERVER", "ACTION", "TARGET", "STATUS", "MESSAGE"}}
+		for _, data := range ops {
+			var op operation
+			if err := json.Unmarshal(data, &op); err != nil {
+				return nil, err
+			}
+			t.rows = append(t.rows, []string{op.ID, op.ServerID, op.Action, orDash(op.TargetState), op.Status, orDash(op.Message)})
+		}
+		return t, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s failed: %s", name, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// waitOperation polls an operation until it finishes and returns it. Progress
+// goes to stderr.
+func (c *cli) waitOperation(op operation, action string, deadline time.Time) (json.RawMessage, error) {
+	status := ""
+	for {
+		data, err := c.client.get("/operations/"+url.PathEscape(op.ID), nil, &op)
+		if err != nil {
+			return nil, fmt.Errorf("failed to follow operation %s: %v", op.ID, err)
+		}
+		if op.Status != status {
+			status = op.Status
+			fmt.Fprintf(os.Stderr, "%s: %s %s\n", op.ServerID, op.Status, op.Message)
+		}
+		switch op.Status {
+		case "succeeded", "failed", "superseded":
+			return data, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s of %s, operation %s is %s", action, op.ServerID, op.ID, op.Status)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func (c *cli) metrics(args []string) error {
+	if len(args) == 0 || args[0] != "query" {
+		return errUsage
+	}
+	fs := c.flagSet("metrics query")
+	since := fs.Duration("since", time.Hour, "Time range ending now")
+	start := fs.String("start", "", "RFC 3339 start time, instead of -since")
+	end := fs.String("end", "", "RFC 3339 end time (default: now)")
+	rest, err := c.parseArgs(fs, args[1:])
+	if err != nil || len(rest) != 1 {
+		return usageOr(err)
+	}
+
+	query := url.Values{}
+	if *end != "" {
+		query.Set("end", *end)
+	}
+	switch {
+	case *start != "":
+		query.Set("start", *start)
+	case *end == "":
+		query.Set("start", time.Now().Add(-*since).UTC().Format(time.RFC3339))
+	}
+
+	var m metrics
+	data, err := c.client.get("/servers/"+url.PathEscape(rest[0])+"/metrics", query, &m)
+	if err != nil {
+		return fmt.Errorf("failed to query metrics of %s: %v", rest[0], err)
+	}
+	return c.printer.print(data, func() (*table, error) {
+		return metricsTable(&m), nil
+	})
+}
+
+// metricsTable lines the metrics up by time, one row per sample
+func metricsTable(m *metrics) *table {
+	rows := make(map[string][]string)
+	series := [][]metricPoint{m.CPU, m.Memory, m.Network, m.Wattage}
+	for i, points := range series {
+		for _, point := range points {
+			if rows[point.Timestamp] == nil {
+				rows[point.Timestamp] = []string{point.Timestamp, "-", "-", "-", "-"}
+			}
+			rows[point.Timestamp][i+1] = strconv.FormatFloat(point.Value, 'f', 1, 64)
+		}
+	}
+
+	t := &table{header: []string{"TIME", "CPU %", "MEMORY %", "NETWORK", "WATTS"}}
+	for _, row := range rows {
+		if parsed, err := time.Parse(time.RFC3339, row[0]); err == nil {
+			row[0] = formatTime(parsed)
+		}
+		t.rows = append(t.rows, row)
+	}
+	sort.Slice(t.rows, func(i, j int) bool { return t.rows[i][0] < t.rows[j][0] })
+	return t
+}
+
+func (c *cli) users(args []string) error {
+	command := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "list", "ls":
+		fs := c.flagSet("users list")
+		role := fs.String("role", "", "Only users with this role: viewer, operator or admin")
+		if rest, err := c.parseArgs(fs, args); err != nil || len(rest) > 0 {
+			return usageOr(err)
+		}
+		query := url.Values{}
+		if *role != "" {
+			query.Set("role", *role)
+		}
+		items, err := c.list("/users", query)
+		if err != nil {
+			return fmt.Errorf("failed to list users: %v", err)
+		}
+		return c.printer.print(items, func() (*table, error) {
+			var users []user
+			if err := json.Unmarshal(items, &users); err != nil {
+				return nil, err
+			}
+			t := &table{header: []string{"USERNAME", "ROLE", "2FA", "CREATED", "LAST LOGIN"}}
+			for _, u := range users {
+				t.rows = append(t.rows, []string{u.Username, u.Role, strconv.FormatBool(u.TwoFactorEnabled), formatTime(u.CreatedAt), formatTime(u.LastLogin)})
+			}
+			return t, nil
+		})
+
+	case "me":
+		if rest, err := c.parseArgs(c.flagSet("users me"), args); err != nil || len(rest) > 0 {
+			return usageOr(err)
+		}
+		var u user
+		data, err := c.client.get("/users/me", nil, &u)
+		if err != nil {
+			return fmt.Errorf("failed to get the current user: %v", err)
+		}
+		return c.printer.print(data, func() (*table, error) {
+			return &table{
+				header: []string{"USERNAME", "ROLE", "2FA", "LAST LOGIN"},
+				rows:   [][]string{{u.Username, u.Role, strconv.FormatBool(u.TwoFactorEnabled), formatTime(u.LastLogin)}},
+			}, nil
+		})
+	}
+	return errUsage
+}
+
+// watch streams the WebSocket updates of all servers, or the ones named, and
+// reconnects when the connection drops
+func (c *cli) watch(args []string) error {
+	serverIDs, err := c.parseArgs(c.flagSet("watch"), args)
+	if err != nil {
+		return err
+	}
+	only := make(map[string]bool)
+	for _, id := range serverIDs {
+		only[id] = true
+	}
+
+	backoff := time.Second
+	for {
+		conn, err := c.client.dial()
+		if errors.Is(err, errNotAuthorized) {
+			return fmt.Errorf("failed to connect: %v", err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect: %v, retrying in %s\n", err, backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+
+		err = c.stream(conn, only)
+		conn.Close()
+		fmt.Fprintf(os.Stderr, "Connection lost: %v, reconnecting\n", err)
+	}
+}
+
+// stream prints the updates of one connection until it drops
+func (c *cli) stream(conn *websocket.Conn, only map[string]bool) error {
+	// The dashboard drops connections it hears nothing from for a minute, and
+	// takes unsolicited pongs as a heartbeat
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				conn.WriteControl(websocket.PongMessage, nil, time.Now().Add(10*time.Second))
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var u update
+		if err := json.Unmarshal(data, &u); err != nil {
+			continue
+		}
+		if len(only) > 0 && !only[u.ServerID] {
+			continue
+		}
+
+		err = c.printer.printStream(data, func() (string, error) {
+			line := fmt.Sprintf("%s  %-16s %-12s", time.Now().Format("15:04:05"), u.ServerID, u.State)
+			if u.Operation != nil {
+				line += fmt.Sprintf(" %s %s %s", u.Operation.Action, u.Operation.Status, u.Operation.Message)
+			} else if cpu, ok := u.Metrics["cpu"]; ok {
+				line += fmt.Sprintf(" cpu %.1f%%", cpu)
+			}
+			return strings.TrimRight(line, " "), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// usageOr returns err, or errUsage when there is none
+func usageOr(err error) error {
+	if err != nil {
+		return err
+	}
+	return errUsage
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
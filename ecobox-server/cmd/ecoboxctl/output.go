@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+// table is the table view of a response
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// printer writes responses in the chosen format
+type printer struct {
+	format string
+	out    io.Writer
+}
+
+// print writes a response body. Tables are built from it only when asked for.
+func (p *printer) print(data []byte, view func() (*table, error)) error {
+	switch p.format {
+	case formatJSON:
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, bytes.TrimSpace(data), "", "  "); err != nil {
+			return err
+		}
+		indented.WriteByte('\n')
+		_, err := p.out.Write(indented.Bytes())
+		return err
+	case formatYAML:
+		value, err := decodeGeneric(data)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(p.out, strings.Join(yamlLines(value), "\n")+"\n")
+		return err
+	}
+
+	t, err := view()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// printStream writes one message of a stream: a line of JSON, a YAML document
+// or the line a table view returns
+func (p *printer) printStream(data []byte, line func() (string, error)) error {
+	switch p.format {
+	case formatJSON:
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err != nil {
+			return err
+		}
+		compact.WriteByte('\n')
+		_, err := p.out.Write(compact.Bytes())
+		return err
+	case formatYAML:
+		value, err := decodeGeneric(data)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(p.out, "---\n"+strings.Join(yamlLines(value), "\n")+"\n")
+		return err
+	}
+
+	text, err := line()
+	if err != nil || text == "" {
+		return err
+	}
+	_, err = fmt.Fprintln(p.out, text)
+	return err
+}
+
+// decodeGeneric decodes JSON keeping numbers as they were written
+func decodeGeneric(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// yamlLines returns the YAML lines of a decoded JSON value, keys sorted
+func yamlLines(value interface{}) []string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return []string{"{}"}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var lines []string
+		for _, key := range keys {
+			nested := yamlLines(v[key])
+			if isYAMLScalar(v[key]) {
+				lines = append(lines, yamlString(key)+": "+nested[0])
+				continue
+			}
+			lines = append(lines, yamlString(key)+":")
+			for _, line := range nested {
+				lines = append(lines, "  "+line)
+			}
+		}
+		return lines
+
+	case []interface{}:
+		if len(v) == 0 {
+			return []string{"[]"}
+		}
+		var lines []string
+		for _, item := range v {
+			for i, line := range yamlLines(item) {
+				if i == 0 {
+					lines = append(lines, "- "+line)
+				} else {
+					lines = append(lines, "  "+line)
+				}
+			}
+		}
+		return lines
+
+	case nil:
+		return []string{"null"}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case json.Number:
+		return []string{v.String()}
+	case string:
+		return []string{yamlString(v)}
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// isYAMLScalar reports whether a value is written on the line of its key
+func isYAMLScalar(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return true
+}
+
+// plainYAML matches strings YAML reads back as the same string without quotes.
+// A leading dot is left out, as .inf, .nan and .5 read as numbers.
+var plainYAML = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9 _./@+-]*(:[A-Za-z0-9_./@+-]+)*$`)
+
+// yamlString writes a string plain when that is safe, or double-quoted. Words
+// YAML reads as booleans or null, such as the "on" power state, are quoted.
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "y", "yes", "n", "no", "on", "off", "true", "false", "null", "~":
+		return strconv.Quote(s)
+	}
+	if plainYAML.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// formatTime writes a time for tables, or "-" when it is unset
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// orDash writes "-" for empty table cells
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYAMLString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"nas", "nas"},
+		{"Living room NAS", "Living room NAS"},
+		{"/dev/sda1", "/dev/sda1"},
+		{"alice@example.com", "alice@example.com"},
+		{"http://nas:8080", "http://nas:8080"},
+		{"nas:8080", "nas:8080"},
+		{"", `""`},
+
+		// Words and numbers YAML would read as something else
+		{"on", `"on"`},
+		{"Off", `"Off"`},
+		{"yes", `"yes"`},
+		{"null", `"null"`},
+		{"~", `"~"`},
+		{"42", `"42"`},
+		{"1.5", `"1.5"`},
+		{".inf", `".inf"`},
+		{".NaN", `".NaN"`},
+		{".5", `".5"`},
+		{"-1", `"-1"`},
+
+		// Syntax YAML would not read back as written
+		{"trailing ", `"trailing "`},
+		{"key: value", `"key: value"`},
+		{"# comment", `"# comment"`},
+		{"- item", `"- item"`},
+		{"line\nbreak", `"line\nbreak"`},
+		{`say "hi"`, `"say \"hi\""`},
+	}
+	for _, test := range tests {
+		if got := yamlString(test.in); got != test.want {
+			t.Errorf("Expected %q to be written as %s, got %s", test.in, test.want, got)
+		}
+	}
+}
+
+func TestYAMLLines(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"scalars", `{"b": true, "a": 1.50, "c": null, "d": "on"}`, "a: 1.50\nb: true\nc: null\nd: \"on\""},
+		{"empty", `{"items": [], "labels": {}}`, "items: []\nlabels: {}"},
+		{"nested", `{"server": {"id": "nas", "groups": ["lab", "storage"]}}`, "server:\n  groups:\n    - lab\n    - storage\n  id: nas"},
+		{"list of objects", `[{"id": "nas", "port": 22}, {"id": ".inf"}]`, "- id: nas\n  port: 22\n- id: \".inf\""},
+		{"nested lists", `[[1, 2], []]`, "- - 1\n  - 2\n- []"},
+		{"top-level scalar", `"off"`, `"off"`},
+	}
+	for _, test := range tests {
+		value, err := decodeGeneric([]byte(test.json))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := strings.Join(yamlLines(value), "\n"); got != test.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.name, test.want, got)
+		}
+	}
+}
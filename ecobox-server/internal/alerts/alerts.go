@@ -0,0 +1,303 @@
+// Package alerts turns failures observed by the monitor into alerts and sends
+// notifications about them.
+//
+// The monitor reports each observation of a condition for a server, or for one of
+// its services, as failing or not. A rule fires once its condition failed
+// threshold times in a row and resolves once it cleared recovery times in a row,
+// so a flapping service does not send a notification on every check. While an
+// alert fires, further failures only notify again after the repeat interval.
+// Rules and notifiers are read from the running configuration on every
+// observation, so reloads apply to the next one.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/secrets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// historySize is the number of resolved alerts kept
+	historySize = 100
+	// notifyTimeout bounds sending one notification
+	notifyTimeout = 30 * time.Second
+)
+
+// Observation is one check of a condition
+type Observation struct {
+	Condition models.AlertCondition
+	Server    *models.Server
+	Service   string // Service name for service_down
+	Failing   bool
+	Message   string // What failed, or what succeeded
+}
+
+// Status holds the firing alerts and the most recently resolved ones
+type Status struct {
+	Firing   []models.Alert `json:"firing"`
+	Resolved []models.Alert `json:"resolved"`
+}
+
+// track counts consecutive observations for a rule and subject
+type track struct {
+	failures  int
+	successes int
+	alert     *models.Alert // Set while firing
+}
+
+// Manager evaluates observations against the alert rules
+type Manager struct {
+	config   *config.Config
+	tracks   map[string]*track // By alert ID
+	resolved []models.Alert    // Newest last
+	secrets  *secrets.Vault    // Optional, see SetSecrets
+	mu       sync.Mutex
+	logger   *logrus.Logger
+}
+
+// NewManager creates an alert manager for the rules in the configuration
+func NewManager(cfg *config.Config) *Manager {
+	return &Manager{
+		config: cfg,
+		tracks: make(map[string]*track),
+		logger: logrus.New(),
+	}
+}
+
+// SetLogger sets the logger for the alert manager
+func (m *Manager) SetLogger(logger *logrus.Logger) {
+	m.logger = logger
+}
+
+// SetSecrets sets the vault notifier credentials are read from
+func (m *Manager) SetSecrets(vault *secrets.Vault) {
+	m.secrets = vault
+}
+
+// Observe records an observation for every matching rule, firing or resolving
+// alerts as their counts reach the rule's threshold or recovery
+func (m *Manager) Observe(o Observation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, rule := range m.config.LiveAlerts().Rules {
+		if !ruleMatches(rule, o) {
+			continue
+		}
+
+		id := alertID(rule.Name, o.Server.ID, o.Service)
+		t, exists := m.tracks[id]
+		if !exists {
+			if !o.Failing {
+				continue
+			}
+			t = &track{}
+			m.tracks[id] = t
+		}
+
+		if o.Failing {
+			t.failures++
+			t.successes = 0
+			if t.alert == nil {
+				if t.failures >= rule.Threshold {
+					t.alert = &models.Alert{
+						ID:         id,
+						Rule:       rule.Name,
+						Condition:  o.Condition,
+						Severity:   models.AlertSeverity(rule.Severity),
+						Status:     models.AlertStatusFiring,
+						ServerID:   o.Server.ID,
+						ServerName: o.Server.Name,
+						Service:    o.Service,
+						StartedAt:  now,
+					}
+					m.logger.Warnf("Alert %s firing for %s: %s", rule.Name, subject(o.Server.Name, o.Service), o.Message)
+					m.notify(rule, t.alert, now)
+				}
+			} else if rule.RepeatInterval > 0 && now.Sub(*t.alert.LastNotified) >= time.Duration(rule.RepeatInterval)*time.Second {
+				m.notify(rule, t.alert, now)
+			}
+			if t.alert != nil {
+				t.alert.Failures = t.failures
+				t.alert.Message = o.Message
+			}
+			continue
+		}
+
+		t.successes++
+		if t.alert == nil {
+			delete(m.tracks, id) // Pending failures cleared before firing
+			continue
+		}
+		t.failures = 0
+		if t.successes < rule.Recovery {
+			continue
+		}
+
+		t.alert.Status = models.AlertStatusResolved
+		t.alert.ResolvedAt = &now
+		t.alert.Message = o.Message
+		m.logger.Infof("Alert %s resolved for %s", rule.Name, subject(o.Server.Name, o.Service))
+		m.notify(rule, t.alert, now)
+
+		m.resolved = append(m.resolved, *t.alert)
+		if len(m.resolved) > historySize {
+			m.resolved = m.resolved[len(m.resolved)-historySize:]
+		}
+		delete(m.tracks, id)
+	}
+}
+
+// notify sends the alert to the rule's notifiers in the background. The caller
+// holds the lock.
+func (m *Manager) notify(rule config.AlertRuleConfig, alert *models.Alert, now time.Time) {
+	alert.LastNotified = &now
+	notification := newNotification(*alert)
+
+	for _, name := range rule.Notifiers {
+		notifierConfig, ok := m.notifierConfig(name)
+		if !ok {
+			continue
+		}
+		go func(cfg config.NotifierConfig) {
+			if err := m.send(cfg, notification); err != nil {
+				m.logger.Errorf("Failed to send alert %s to notifier %s: %v", notification.Alert.ID, cfg.Name, err)
+			}
+		}(notifierConfig)
+	}
+}
+
+// Test sends a test notification through a notifier and waits for the result
+func (m *Manager) Test(name string) error {
+	m.mu.Lock()
+	notifierConfig, ok := m.notifierConfig(name)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotifierNotFound, name)
+	}
+
+	now := time.Now()
+	notification := newNotification(models.Alert{
+		ID:         "test",
+		Rule:       "test",
+		Severity:   models.AlertSeverityInfo,
+		Status:     models.AlertStatusFiring,
+		ServerName: "EcoBox",
+		Message:    "Test notification from EcoBox",
+		StartedAt:  now,
+	})
+	return m.send(notifierConfig, notification)
+}
+
+// Status returns the firing alerts, oldest first, and the resolved ones, newest
+// first. With serverID set only that server's alerts are included.
+func (m *Manager) Status(serverID string) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := Status{Firing: []models.Alert{}, Resolved: []models.Alert{}}
+	for _, t := range m.tracks {
+		if t.alert != nil && (serverID == "" || t.alert.ServerID == serverID) {
+			status.Firing = append(status.Firing, *t.alert)
+		}
+	}
+	sort.Slice(status.Firing, func(i, j int) bool {
+		return status.Firing[i].StartedAt.Before(status.Firing[j].StartedAt)
+	})
+
+	for i := len(m.resolved) - 1; i >= 0; i-- {
+		if serverID == "" || m.resolved[i].ServerID == serverID {
+			status.Resolved = append(status.Resolved, m.resolved[i])
+		}
+	}
+	return status
+}
+
+// notifierConfig finds a notifier by name. The caller holds the lock.
+func (m *Manager) notifierConfig(name string) (config.NotifierConfig, bool) {
+	for _, notifier := range m.config.LiveAlerts().Notifiers {
+		if notifier.Name == name {
+			return notifier, true
+		}
+	}
+	return config.NotifierConfig{}, false
+}
+
+// send delivers a notification through a notifier, with the secrets its
+// credentials refer to
+func (m *Manager) send(cfg config.NotifierConfig, notification Notification) error {
+	var err error
+	if cfg.Token, err = m.secrets.Resolve(cfg.Token); err != nil {
+		return err
+	}
+	if cfg.Password, err = m.secrets.Resolve(cfg.Password); err != nil {
+		return err
+	}
+	if cfg.Headers, err = m.secrets.ResolveHeaders(cfg.Headers); err != nil {
+		return err
+	}
+
+	notifier, err := NewNotifier(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	return notifier.Notify(ctx, notification)
+}
+
+// ruleMatches reports whether a rule covers an observation
+func ruleMatches(rule config.AlertRuleConfig, o Observation) bool {
+	if rule.Condition != string(o.Condition) {
+		return false
+	}
+	if len(rule.Services) > 0 && !contains(rule.Services, o.Service) {
+		return false
+	}
+	if len(rule.Servers) == 0 && len(rule.Groups) == 0 {
+		return true
+	}
+	if contains(rule.Servers, o.Server.ID) {
+		return true
+	}
+	for _, group := range rule.Groups {
+		if o.Server.InGroup(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// alertID identifies the alert of a rule for a server or service
+func alertID(rule, serverID, service string) string {
+	parts := []string{rule, serverID}
+	if service != "" {
+		parts = append(parts, service)
+	}
+	return strings.Join(parts, ":")
+}
+
+func subject(serverName, service string) string {
+	if service == "" {
+		return serverName
+	}
+	return fmt.Sprintf("%s on %s", service, serverName)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package alerts
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/mqtt/mqtttest"
+)
+
+// receiver is a stand-in HTTP notifier endpoint that captures requests
+func receiver(t *testing.T) (*httptest.Server, <-chan *http.Request, <-chan []byte) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	t.Cleanup(server.Close)
+	return server, requests, bodies
+}
+
+func receive(t *testing.T, bodies <-chan []byte) []byte {
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a notification")
+		return nil
+	}
+}
+
+func TestRuleHysteresis(t *testing.T) {
+	server, _, bodies := receiver(t)
+	cfg := &config.Config{Alerts: config.AlertsConfig{
+		Notifiers: []config.NotifierConfig{{Name: "hook", Type: "webhook", URL: server.URL}},
+		Rules: []config.AlertRuleConfig{
+			{Name: "smb", Condition: "service_down", Services: []string{"SMB"}, Threshold: 2, Recovery: 2, Severity: "critical", Notifiers: []string{"hook"}},
+		},
+	}}
+	manager := NewManager(cfg)
+	nas := &models.Server{ID: "nas", Name: "NAS"}
+	observe := func(service string, failing bool) {
+		manager.Observe(Observation{Condition: models.AlertConditionServiceDown, Server: nas, Service: service, Failing: failing, Message: "check"})
+	}
+
+	observe("SMB", true)
+	observe("SSH", true) // Not covered by the rule
+	if firing := manager.Status("").Firing; len(firing) != 0 {
+		t.Fatalf("Expected no alert after one failure, got %+v", firing)
+	}
+
+	observe("SMB", true)
+	var notification Notification
+	if err := json.Unmarshal(receive(t, bodies), &notification); err != nil {
+		t.Fatalf("Invalid notification: %v", err)
+	}
+	if notification.Alert.Status != models.AlertStatusFiring || notification.Alert.ID != "smb:nas:SMB" || !strings.Contains(notification.Title, "SMB on NAS") {
+		t.Errorf("Unexpected firing notification: %+v", notification)
+	}
+
+	// A failure between successes restarts the recovery count
+	observe("SMB", false)
+	observe("SMB", true)
+	observe("SMB", false)
+	if firing := manager.Status("nas").Firing; len(firing) != 1 || firing[0].Failures != 1 {
+		t.Fatalf("Expected the alert to keep firing, got %+v", firing)
+	}
+	select {
+	case body := <-bodies:
+		t.Fatalf("Expected no repeated notification, got %s", body)
+	default:
+	}
+
+	observe("SMB", false)
+	if err := json.Unmarshal(receive(t, bodies), &notification); err != nil {
+		t.Fatalf("Invalid notification: %v", err)
+	}
+	if notification.Alert.Status != models.AlertStatusResolved {
+		t.Errorf("Expected a resolved notification, got %+v", notification.Alert)
+	}
+
+	status := manager.Status("")
+	if len(status.Firing) != 0 || len(status.Resolved) != 1 || status.Resolved[0].ResolvedAt == nil {
+		t.Errorf("Expected one resolved alert, got %+v", status)
+	}
+	if len(manager.Status("other").Resolved) != 0 {
+		t.Error("Expected the server filter to exclude other servers")
+	}
+}
+
+func TestRuleServerFilter(t *testing.T) {
+	cfg := &config.Config{Alerts: config.AlertsConfig{
+		Rules: []config.AlertRuleConfig{
+			{Name: "lab", Condition: "wake_failure", Groups: []string{"lab"}, Threshold: 1, Recovery: 1},
+		},
+	}}
+	manager := NewManager(cfg)
+
+	manager.Observe(Observation{Condition: models.AlertConditionWakeFailure, Server: &models.Server{ID: "nas", Groups: []string{"media"}}, Failing: true})
+	manager.Observe(Observation{Condition: models.AlertConditionWakeTimeout, Server: &models.Server{ID: "pve", Groups: []string{"lab"}}, Failing: true})
+	manager.Observe(Observation{Condition: models.AlertConditionWakeFailure, Server: &models.Server{ID: "pve", Groups: []string{"lab"}}, Failing: true})
+
+	firing := manager.Status("").Firing
+	if len(firing) != 1 || firing[0].ServerID != "pve" {
+		t.Errorf("Expected only pve to fire, got %+v", firing)
+	}
+}
+
+func TestHTTPNotifiers(t *testing.T) {
+	server, requests, bodies := receiver(t)
+	cfg := &config.Config{Alerts: config.AlertsConfig{
+		Notifiers: []config.NotifierConfig{
+			{Name: "hook", Type: "webhook", URL: server.URL + "/hook", Headers: map[string]string{"X-Token": "secret"}},
+			{Name: "chat", Type: "slack", URL: server.URL + "/slack"},
+			{Name: "phone", Type: "ntfy", URL: server.URL + "/ecobox", Token: "tk_123"},
+			{Name: "gotify", Type: "gotify", URL: server.URL + "/", Token: "app-token"},
+		},
+	}}
+	manager := NewManager(cfg)
+
+	tests := []struct {
+		notifier string
+		path     string
+		header   string
+		value    string
+		body     string
+	}{
+		{"hook", "/hook", "X-Token", "secret", `"title":"[FIRING] EcoBox"`},
+		{"chat", "/slack", "Content-Type", "application/json", `"text":"*[FIRING] EcoBox*`},
+		{"phone", "/ecobox", "Authorization", "Bearer tk_123", "Test notification from EcoBox"},
+		{"gotify", "/message", "X-Gotify-Key", "app-token", `"priority":2`},
+	}
+	for _, tt := range tests {
+		if err := manager.Test(tt.notifier); err != nil {
+			t.Errorf("%s: test notification failed: %v", tt.notifier, err)
+			continue
+		}
+		req := <-requests
+		body := string(<-bodies)
+		if req.URL.Path != tt.path || req.Header.Get(tt.header) != tt.value || !strings.Contains(body, tt.body) {
+			t.Errorf("%s: unexpected request %s %s=%q body %s", tt.notifier, req.URL.Path, tt.header, req.Header.Get(tt.header), body)
+		}
+	}
+
+	if err := manager.Test("missing"); err == nil {
+		t.Error("Expected an error for an unknown notifier")
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// A minimal SMTP server without STARTTLS or authentication
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+			case "EHLO", "HELO":
+				conn.Write([]byte("250 localhost\r\n"))
+			case "DATA":
+				conn.Write([]byte("354 Go ahead\r\n"))
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				messages <- data.String()
+				conn.Write([]byte("250 Queued\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 Bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	cfg := &config.Config{Alerts: config.AlertsConfig{
+		Notifiers: []config.NotifierConfig{
+			{Name: "mail", Type: "email", SMTPHost: host, From: "ecobox@lan", To: []string{"admin@lan"}},
+		},
+	}}
+	cfg.Alerts.Notifiers[0].SMTPPort, _ = strconv.Atoi(port)
+	manager := NewManager(cfg)
+
+	if err := manager.Test("mail"); err != nil {
+		t.Fatalf("Test notification failed: %v", err)
+	}
+	message := <-messages
+	if !strings.Contains(message, "Subject: [FIRING] EcoBox") || !strings.Contains(message, "To: admin@lan") {
+		t.Errorf("Unexpected message:\n%s", message)
+	}
+}
+
+func TestMQTTNotifier(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+
+	cfg := &config.Config{Alerts: config.AlertsConfig{
+		Notifiers: []config.NotifierConfig{
+			{Name: "broker", Type: "mqtt", URL: broker.URL(), Topic: "home/ecobox/alerts", Username: "ecobox", Password: "secret"},
+		},
+	}}
+	manager := NewManager(cfg)
+
+	if err := manager.Test("broker"); err != nil {
+		t.Fatalf("Test notification failed: %v", err)
+	}
+	if connect := <-broker.Connect; !strings.Contains(string(connect), "ecobox") || !strings.Contains(string(connect), "secret") {
+		t.Errorf("Expected credentials in CONNECT, got %q", connect)
+	}
+	m := broker.Collect(t, make(map[string]mqtttest.Message), "home/ecobox/alerts")
+	if !strings.Contains(m.Payload, "Test notification from EcoBox") {
+		t.Errorf("Unexpected publish: %s", m.Payload)
+	}
+}
@@ -0,0 +1,280 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/mqtt"
+)
+
+// ErrNotifierNotFound is returned when no notifier has the requested name
+var ErrNotifierNotFound = errors.New("notifier not found")
+
+// Notification is what notifiers send about an alert
+type Notification struct {
+	Title   string       `json:"title"`
+	Message string       `json:"message"`
+	Alert   models.Alert `json:"alert"`
+}
+
+// newNotification describes an alert for people
+func newNotification(alert models.Alert) Notification {
+	title := fmt.Sprintf("[%s] %s", strings.ToUpper(string(alert.Status)), subject(alert.ServerName, alert.Service))
+	if alert.Condition != "" {
+		title += ": " + strings.ReplaceAll(string(alert.Condition), "_", " ")
+	}
+	return Notification{
+		Title:   title,
+		Message: fmt.Sprintf("%s (rule %s, severity %s)", alert.Message, alert.Rule, alert.Severity),
+		Alert:   alert,
+	}
+}
+
+// Notifier delivers notifications to one destination
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NewNotifier creates the notifier for a configured destination
+func NewNotifier(cfg config.NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "webhook":
+		return &webhookNotifier{cfg: cfg}, nil
+	case "slack":
+		return &slackNotifier{cfg: cfg}, nil
+	case "ntfy":
+		return &ntfyNotifier{cfg: cfg}, nil
+	case "gotify":
+		return &gotifyNotifier{cfg: cfg}, nil
+	case "email":
+		return &emailNotifier{cfg: cfg}, nil
+	case "mqtt":
+		return &mqttNotifier{cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type '%s'", cfg.Type)
+}
+
+// webhookNotifier POSTs the notification as JSON
+type webhookNotifier struct {
+	cfg config.NotifierConfig
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	for key, value := range n.cfg.Headers {
+		headers[key] = value
+	}
+	return post(ctx, n.cfg.URL, headers, body)
+}
+
+// slackNotifier posts to a Slack-compatible incoming webhook, as used by Slack,
+// Mattermost, Rocket.Chat and Discord's /slack endpoint
+type slackNotifier struct {
+	cfg config.NotifierConfig
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", notification.Title, notification.Message),
+	})
+	if err != nil {
+		return err
+	}
+	return post(ctx, n.cfg.URL, map[string]string{"Content-Type": "application/json"}, body)
+}
+
+// ntfyNotifier publishes to an ntfy topic URL
+type ntfyNotifier struct {
+	cfg config.NotifierConfig
+}
+
+func (n *ntfyNotifier) Notify(ctx context.Context, notification Notification) error {
+	priority, tag := "3", "white_check_mark"
+	if notification.Alert.Status == models.AlertStatusFiring {
+		switch notification.Alert.Severity {
+		case models.AlertSeverityCritical:
+			priority, tag = "5", "rotating_light"
+		case models.AlertSeverityWarning:
+			priority, tag = "4", "warning"
+		default:
+			tag = "information_source"
+		}
+	}
+
+	headers := map[string]string{
+		"Title":    notification.Title,
+		"Priority": priority,
+		"Tags":     tag,
+	}
+	if n.cfg.Token != "" {
+		headers["Authorization"] = "Bearer " + n.cfg.Token
+	}
+	return post(ctx, n.cfg.URL, headers, []byte(notification.Message))
+}
+
+// gotifyNotifier sends a message to a Gotify server
+type gotifyNotifier struct {
+	cfg config.NotifierConfig
+}
+
+func (n *gotifyNotifier) Notify(ctx context.Context, notification Notification) error {
+	priority := 2
+	if notification.Alert.Status == models.AlertStatusFiring {
+		switch notification.Alert.Severity {
+		case models.AlertSeverityCritical:
+			priority = 8
+		case models.AlertSeverityWarning:
+			priority = 5
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    notification.Title,
+		"message":  notification.Message,
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"X-Gotify-Key": n.cfg.Token,
+	}
+	return post(ctx, strings.TrimSuffix(n.cfg.URL, "/")+"/message", headers, body)
+}
+
+// emailNotifier sends a plain text email over SMTP. Port 465 uses implicit TLS;
+// other ports upgrade with STARTTLS when the server offers it.
+type emailNotifier struct {
+	cfg config.NotifierConfig
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, notification Notification) error {
+	address := net.JoinHostPort(n.cfg.SMTPHost, strconv.Itoa(n.cfg.SMTPPort))
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	tlsConfig := &tls.Config{ServerName: n.cfg.SMTPHost}
+	if n.cfg.SMTPPort == 465 {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, n.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && n.cfg.SMTPPort != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if n.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.SMTPHost)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", notification.Title)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", notification.Message)
+	if _, err := writer.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// mqttNotifier publishes the notification as JSON to an MQTT topic
+type mqttNotifier struct {
+	cfg config.NotifierConfig
+}
+
+func (n *mqttNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	opts := mqtt.Options{
+		Broker:   n.cfg.URL,
+		ClientID: fmt.Sprintf("ecobox-alerts-%d", time.Now().UnixNano()),
+		Username: n.cfg.Username,
+		Password: n.cfg.Password,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		opts.Timeout = time.Until(deadline)
+	}
+	client, err := mqtt.Dial(opts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Publish(n.cfg.Topic, body, false)
+}
+
+// post sends a request body and treats any non-2xx response as a failure
+func post(ctx context.Context, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notifier returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+// Package audit keeps an append-only record of who changed what.
+//
+// Entries are written as JSON lines to the configured file, which is rotated to
+// file.1, file.2, ... once it reaches the configured size. Queries read the
+// current and rotated files, so the history survives restarts for as long as
+// the rotated files are kept.
+package audit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Actor types
+const (
+	ActorUser       = "user"       // Logged in with a password
+	ActorIAP        = "iap"        // Identified by the identity-aware proxy
+	ActorToken      = "token"      // API token
+	ActorAnonymous  = "anonymous"  // Before authentication is set up
+	ActorScheduler  = "scheduler"  // Scheduled actions
+	ActorReconciler = "reconciler" // Actions that bring servers to their desired state
+	ActorMQTT       = "mqtt"       // Home Assistant commands
+	ActorSystem     = "system"     // The dashboard itself
+)
+
+// Entry is one audited event
+type Entry struct {
+	ID         string          `json:"id"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Actor      string          `json:"actor"`      // Username, or the component that acted
+	ActorType  string          `json:"actor_type"` // One of the Actor constants
+	Action     string          `json:"action"`     // e.g. "server.wake", "auth.login" or "POST /api/servers/{id}/wake"
+	Target     string          `json:"target,omitempty"`
+	Success    bool            `json:"success"`
+	Status     int             `json:"status,omitempty"` // HTTP status of API requests
+	Error      string          `json:"error,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"` // Target before the change
+	After      json.RawMessage `json:"after,omitempty"`  // Target after the change
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+}
+
+// Filter selects entries. Empty fields match everything.
+type Filter struct {
+	Actor     string
+	ActorType string
+	Action    string // Matches actions containing it, ignoring case
+	Target    string
+	Since     time.Time
+	Until     time.Time
+	Success   *bool
+	Offset    int
+	Limit     int // 0 returns every entry after Offset
+}
+
+// matches reports whether an entry passes the filter
+func (f *Filter) matches(e *Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.ActorType == "" || e.ActorType == f.ActorType) &&
+		(f.Action == "" || strings.Contains(strings.ToLower(e.Action), strings.ToLower(f.Action))) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.Since.IsZero() || !e.Timestamp.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Timestamp.Before(f.Until)) &&
+		(f.Success == nil || e.Success == *f.Success)
+}
+
+// Log appends entries to the audit file
+type Log struct {
+	path     string
+	maxSize  int64 // Bytes
+	maxFiles int
+	file     *os.File
+	size     int64
+	mu       sync.Mutex
+	logger   *logrus.Logger
+}
+
+// NewLog creates a log writing to the configured file. The file is opened on
+// the first entry.
+func NewLog(cfg *config.Config) *Log {
+	return &Log{
+		path:     cfg.Dashboard.AuditFile,
+		maxSize:  int64(cfg.Dashboard.AuditMaxSize) * 1024 * 1024,
+		maxFiles: cfg.Dashboard.AuditMaxFiles,
+		logger:   logrus.New(),
+	}
+}
+
+// SetLogger sets the logger for the audit log
+func (l *Log) SetLogger(logger *logrus.Logger) {
+	l.logger = logger
+}
+
+// Snapshot encodes a value for an entry's Before or After. It returns nil for
+// nil values and values that cannot be encoded.
+func Snapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	return data
+}
+
+// ActorType classifies the InitiatedBy of a server action
+func ActorType(initiatedBy string) string {
+	switch initiatedBy {
+	case ActorReconciler, ActorScheduler, ActorMQTT, ActorSystem:
+		return initiatedBy
+	case "system_monitor":
+		return ActorSystem
+	default:
+		return ActorUser
+	}
+}
+
+// Record appends an entry, filling in its ID and timestamp when missing.
+// Failures are logged; auditing never fails the audited operation. Recording to
+// a nil log does nothing.
+func (l *Log) Record(entry Entry) {
+	if l == nil {
+		return
+	}
+	if entry.ID == "" {
+		entry.ID = newEntryID()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.Errorf("Failed to encode audit entry: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.write(line); err != nil {
+		l.logger.Errorf("Failed to write audit entry %s %s by %s: %v", entry.Action, entry.Target, entry.Actor, err)
+	}
+}
+
+// HandleAction records an action carried out on a server. It is registered as
+// a storage action listener.
+func (l *Log) HandleAction(serverID string, action models.ServerAction) {
+	l.Record(Entry{
+		Timestamp: action.Timestamp,
+		Actor:     action.InitiatedBy,
+		ActorType: ActorType(action.InitiatedBy),
+		Action:    "server." + string(action.Action),
+		Target:    serverID,
+		Success:   action.Success,
+		Error:     action.ErrorMsg,
+	})
+}
+
+// write appends a line, rotating the file first when the line would take it
+// past the maximum size
+func (l *Log) write(line []byte) error {
+	if l.file == nil {
+		file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		l.file = file
+		l.size = info.Size()
+	}
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+		return l.write(line)
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts file.N-1 to file.N, ..., file to file.1, dropping the oldest,
+// and closes the current file so the next write starts a new one
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.maxFiles < 1 {
+		return os.Remove(l.path)
+	}
+	if err := os.Remove(l.rotatedPath(l.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(l.rotatedPath(i), l.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.rotatedPath(1))
+}
+
+func (l *Log) rotatedPath(n int) string {
+	return l.path + "." + strconv.Itoa(n)
+}
+
+// Close closes the audit file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Query returns the entries matching the filter, newest first, and the number
+// of matching entries before Offset and Limit were applied
+func (l *Log) Query(filter Filter) ([]Entry, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var matched []Entry
+	paths := make([]string, 0, l.maxFiles+1)
+	for i := l.maxFiles; i >= 1; i-- {
+		paths = append(paths, l.rotatedPath(i))
+	}
+	paths = append(paths, l.path)
+
+	for _, path := range paths {
+		entries, err := readEntries(path, &filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		matched = append(matched, entries...)
+	}
+
+	// Oldest first on disk, newest first in results
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+
+	total := len(matched)
+	if filter.Offset >= total {
+		return []Entry{}, total, nil
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(matched) {
+		matched = matched[:filter.Limit]
+	}
+	return matched, total, nil
+}
+
+// readEntries reads the entries of one file that match the filter. A missing
+// file has none; lines that cannot be decoded are skipped.
+func readEntries(path string, filter *Filter) ([]Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.matches(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// csvHeader names the columns written by WriteCSV
+var csvHeader = []string{"id", "timestamp", "actor", "actor_type", "action", "target", "success", "status", "error", "remote_addr", "before", "after"}
+
+// WriteCSV writes entries as CSV with a header row. Before and after are
+// written as JSON.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		status := ""
+		if e.Status != 0 {
+			status = strconv.Itoa(e.Status)
+		}
+		record := []string{
+			e.ID,
+			e.Timestamp.UTC().Format(time.RFC3339),
+			e.Actor,
+			e.ActorType,
+			e.Action,
+			e.Target,
+			strconv.FormatBool(e.Success),
+			status,
+			e.Error,
+			e.RemoteAddr,
+			string(e.Before),
+			string(e.After),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// newEntryID returns a random entry ID
+func newEntryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "aud_" + time.Now().Format("20060102150405.000000000")
+	}
+	return "aud_" + hex.EncodeToString(b)
+}
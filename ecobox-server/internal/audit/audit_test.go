@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+)
+
+func newTestLog(t *testing.T) *Log {
+	cfg := &config.Config{}
+	cfg.Dashboard.AuditFile = filepath.Join(t.TempDir(), "audit.log")
+	cfg.SetDefaults()
+	log := NewLog(cfg)
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func TestQueryFiltersAndPages(t *testing.T) {
+	log := newTestLog(t)
+	start := time.Now().Add(-time.Hour)
+
+	log.Record(Entry{Timestamp: start, Actor: "admin", ActorType: ActorUser, Action: "auth.login", Target: "admin", Success: true})
+	log.Record(Entry{Timestamp: start.Add(time.Minute), Actor: "alice", ActorType: ActorIAP, Action: "POST /api/servers/{id}/wake", Target: "nas", Success: true})
+	log.HandleAction("nas", models.ServerAction{Timestamp: start.Add(2 * time.Minute), Action: models.ActionTypeWakeUp, Success: false, ErrorMsg: "timed out", InitiatedBy: "reconciler"})
+
+	entries, total, err := log.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if total != 3 || len(entries) != 3 || entries[0].Action != "server.wake" || entries[2].Action != "auth.login" {
+		t.Fatalf("Expected all entries newest first, got %d: %+v", total, entries)
+	}
+	if entries[0].ActorType != ActorReconciler || entries[0].Error != "timed out" || entries[0].ID == "" {
+		t.Errorf("Unexpected action entry: %+v", entries[0])
+	}
+
+	failed := false
+	filters := map[string]Filter{
+		"target":     {Target: "nas"},
+		"actor_type": {ActorType: ActorIAP},
+		"action":     {Action: "WAKE"},
+		"since":      {Since: start.Add(time.Minute)},
+		"until":      {Until: start.Add(time.Minute)},
+		"success":    {Success: &failed},
+	}
+	want := map[string]int{"target": 2, "actor_type": 1, "action": 2, "since": 2, "until": 1, "success": 1}
+	for name, filter := range filters {
+		if _, total, _ := log.Query(filter); total != want[name] {
+			t.Errorf("%s: expected %d entries, got %d", name, want[name], total)
+		}
+	}
+
+	page, total, _ := log.Query(Filter{Offset: 1, Limit: 1})
+	if total != 3 || len(page) != 1 || page[0].Actor != "alice" {
+		t.Errorf("Expected the second entry of three, got %d: %+v", total, page)
+	}
+	if page, _, _ := log.Query(Filter{Offset: 5}); len(page) != 0 {
+		t.Errorf("Expected no entries past the end, got %+v", page)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	log := newTestLog(t)
+	log.maxSize = 300
+	log.maxFiles = 2
+
+	for i := 0; i < 20; i++ {
+		log.Record(Entry{Actor: "admin", ActorType: ActorUser, Action: "auth.login", Success: true})
+	}
+
+	for _, path := range []string{log.path, log.path + ".1", log.path + ".2"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", path, err)
+		}
+		if info.Size() > log.maxSize {
+			t.Errorf("%s is larger than the maximum size: %d", path, info.Size())
+		}
+	}
+	if _, err := os.Stat(log.path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only two rotated files to be kept")
+	}
+
+	// Queries read the rotated files too
+	entries, total, err := log.Query(Filter{})
+	if err != nil || total < 3 || total >= 20 {
+		t.Fatalf("Expected the entries of the kept files, got %d (%v)", total, err)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Timestamp.After(entries[i-1].Timestamp) {
+			t.Fatalf("Entries are not newest first at %d", i)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	entries := []Entry{{
+		ID:        "aud_1",
+		Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Actor:     "admin",
+		ActorType: ActorUser,
+		Action:    "PUT /api/servers/{id}/groups",
+		Target:    "nas",
+		Success:   true,
+		Status:    200,
+		Before:    Snapshot(map[string][]string{"groups": {"lab"}}),
+		After:     Snapshot(map[string][]string{"groups": {"lab", "media"}}),
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, entries); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV: %v", err)
+	}
+	if len(records) != 2 || len(records[1]) != len(csvHeader) {
+		t.Fatalf("Expected a header and one row, got %v", records)
+	}
+	if records[1][1] != "2024-05-01T12:00:00Z" || records[1][7] != "200" || records[1][11] != `{"groups":["lab","media"]}` {
+		t.Errorf("Unexpected row: %v", records[1])
+	}
+}
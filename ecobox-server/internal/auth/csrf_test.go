@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	am := newTestManager(t)
+	if err := am.CompleteFirstTimeSetup("Secret123!x"); err != nil {
+		t.Fatal(err)
+	}
+	tokens, _, err := am.Login("admin", "Secret123!x", "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret, err := am.CreateToken("admin", "script", []Permission{PermissionWake}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewMiddleware(am).RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	post := func(configure func(*http.Request)) int {
+		req := httptest.NewRequest("POST", "/api/servers/nas/wake", strings.NewReader(CSRFFormField+"=token-1"))
+		configure(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	session := &http.Cookie{Name: AccessCookieName, Value: tokens.AccessToken}
+	csrf := &http.Cookie{Name: CSRFCookieName, Value: "token-1"}
+
+	for name, test := range map[string]struct {
+		configure func(*http.Request)
+		expected  int
+	}{
+		"session without token": {func(r *http.Request) {
+			r.AddCookie(session)
+			r.AddCookie(csrf)
+		}, http.StatusForbidden},
+		"session with wrong token": {func(r *http.Request) {
+			r.AddCookie(session)
+			r.AddCookie(csrf)
+			r.Header.Set(CSRFHeaderName, "token-2")
+		}, http.StatusForbidden},
+		"session with token": {func(r *http.Request) {
+			r.AddCookie(session)
+			r.AddCookie(csrf)
+			r.Header.Set(CSRFHeaderName, "token-1")
+		}, http.StatusOK},
+		"session with form token": {func(r *http.Request) {
+			r.AddCookie(session)
+			r.AddCookie(csrf)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}, http.StatusOK},
+		"bearer token": {func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+secret)
+		}, http.StatusOK},
+	} {
+		if code := post(test.configure); code != test.expected {
+			t.Errorf("%s: expected %d, got %d", name, test.expected, code)
+		}
+	}
+
+	// Sessions from before CSRF tokens get one to retry with
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/servers/nas/wake", nil)
+	req.AddCookie(session)
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Header().Get("Set-Cookie"), CSRFCookieName+"=") {
+		t.Errorf("Expected 403 with a new CSRF cookie, got %d %q", rec.Code, rec.Header().Get("Set-Cookie"))
+	}
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Headers identity-aware proxies identify users with
+const (
+	tailscaleUserHeader = "Tailscale-User-Login"    // Set by tailscale serve
+	authentikUserHeader = "X-Authentik-Username"    // Set by the Authentik proxy outpost
+	forwardedUserHeader = "X-Forwarded-User"        // Set by Authentik forward auth and most other proxies
+	cloudflareJWTHeader = "Cf-Access-Jwt-Assertion" // Signed by Cloudflare Access
+	cloudflareJWTCookie = "CF_Authorization"
+)
+
+// checkIAPAuthentication returns the user an identity-aware proxy vouches for.
+// Tailscale and Authentik headers are only believed from trusted_proxies, as
+// anyone else can set them; Cloudflare Access tokens are verified against the
+// team's signing keys.
+func (am *Manager) checkIAPAuthentication(r *http.Request) *User {
+	var username string
+
+	switch AuthMethod(am.config.LiveDashboard().IAPAuth) {
+	case AuthMethodTailscale:
+		if !am.fromTrustedProxy(r) {
+			return nil
+		}
+		username = r.Header.Get(tailscaleUserHeader)
+	case AuthMethodAuthentik:
+		if !am.fromTrustedProxy(r) {
+			return nil
+		}
+		username = r.Header.Get(authentikUserHeader)
+		if username == "" {
+			username = r.Header.Get(forwardedUserHeader)
+		}
+	case AuthMethodCloudflare:
+		username = am.verifyCloudflareAccess(r)
+	default:
+		return nil
+	}
+
+	if username == "" {
+		return nil
+	}
+
+	user, err := am.provisionIAPUser(am.iapIssuer(), username)
+	if err != nil {
+		am.logger.Warnf("Refused IAP identity %s: %v", username, err)
+		return nil
+	}
+	return user
+}
+
+// iapIssuer names the identity-aware proxy in the links of its users
+func (am *Manager) iapIssuer() string {
+	return "iap:" + am.config.LiveDashboard().IAPAuth
+}
+
+// fromTrustedProxy reports whether a request came straight from one of
+// trusted_proxies, whose identity and forwarding headers are believed
+func (am *Manager) fromTrustedProxy(r *http.Request) bool {
+	ip := net.ParseIP(ClientAddr(r))
+	if ip == nil || !am.isTrustedProxy(ip) {
+		am.logger.Debugf("Ignoring proxy headers from untrusted address %s", ClientAddr(r))
+		return false
+	}
+	return true
+}
+
+// isTrustedProxy reports whether an address is one of trusted_proxies
+func (am *Manager) isTrustedProxy(ip net.IP) bool {
+	for _, proxy := range am.config.LiveDashboard().TrustedProxies {
+		if trusted := net.ParseIP(proxy); trusted != nil {
+			if trusted.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteIP returns the address of the client behind a request: the direct
+// peer, or for requests from trusted_proxies the nearest address in
+// X-Forwarded-For that is not a trusted proxy. Other peers cannot pick the
+// address logins are throttled and audited under.
+func (am *Manager) RemoteIP(r *http.Request) string {
+	peer := ClientAddr(r)
+	if ip := net.ParseIP(peer); ip == nil || !am.isTrustedProxy(ip) {
+		return peer
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		if !am.isTrustedProxy(hop) {
+			return hop.String()
+		}
+	}
+	return peer
+}
+
+// verifyCloudflareAccess returns the email of a valid Cloudflare Access token
+// issued for this application, or ""
+func (am *Manager) verifyCloudflareAccess(r *http.Request) string {
+	token := r.Header.Get(cloudflareJWTHeader)
+	if token == "" {
+		if cookie, err := r.Cookie(cloudflareJWTCookie); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return ""
+	}
+
+	dashboard := am.config.LiveDashboard()
+	issuer := "https://" + dashboard.CloudflareTeamDomain
+	am.loginMu.Lock()
+	if am.cloudflareKeys == nil {
+		am.cloudflareKeys = newKeySet(issuer+"/cdn-cgi/access/certs", &http.Client{Timeout: 10 * time.Second})
+	}
+	keys := am.cloudflareKeys
+	am.loginMu.Unlock()
+
+	claims, err := verifyJWT(token, keys)
+	if err == nil {
+		err = claims.validate(issuer, dashboard.CloudflareAudience, time.Now())
+	}
+	if err != nil {
+		am.logger.Warnf("Rejected Cloudflare Access token from %s: %v", ClientAddr(r), err)
+		return ""
+	}
+	return claims.string("email")
+}
+
+// provisionIAPUser returns the user linked to an identity the proxy vouched for,
+// creating one on first sight. The domain of email identities is dropped from
+// the new username, so alice@example.com becomes alice. A user of that name not
+// linked to the identity is never taken over, as the proxy's users may come from
+// any domain. New users get a random password nobody knows, so they can only
+// log in through the proxy until an admin resets it.
+func (am *Manager) provisionIAPUser(issuer, identity string) (*User, error) {
+	if user, linked := am.userStore.GetOIDCUser(issuer, identity); linked {
+		return user, nil
+	}
+
+	username := identity
+	if idx := strings.Index(username, "@"); idx != -1 {
+		username = username[:idx]
+	}
+	if _, taken := am.userStore.GetUser(username); taken {
+		return nil, fmt.Errorf("%s is taken by a user not linked to this identity", username)
+	}
+
+	am.logger.Infof("Creating user %s on first login", username)
+	if err := am.userStore.CreateOIDCUser(username, am.defaultRole(), issuer, identity); err != nil {
+		// Another request of the same identity may have created it first
+		if user, linked := am.userStore.GetOIDCUser(issuer, identity); linked {
+			return user, nil
+		}
+		return nil, err
+	}
+	user, _ := am.userStore.GetUser(username)
+	return user, nil
+}
+
+// LinkIAP links a user to an identity reported by the identity-aware proxy,
+// such as alice@example.com, so that requests with it are the user's. An empty
+// identity removes the link.
+func (am *Manager) LinkIAP(username, identity string) (*User, error) {
+	if AuthMethod(am.config.LiveDashboard().IAPAuth) == AuthMethodNone && identity != "" {
+		return nil, fmt.Errorf("no identity-aware proxy is configured")
+	}
+	if err := am.userStore.SetOIDCLink(username, am.iapIssuer(), identity); err != nil {
+		return nil, err
+	}
+
+	user, _ := am.userStore.GetUser(username)
+	return user, nil
+}
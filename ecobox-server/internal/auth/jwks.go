@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// keySetLifetime is how long fetched signing keys are used before they are
+	// fetched again
+	keySetLifetime = time.Hour
+	// keySetMinRefresh limits refetches for tokens signed by an unknown key
+	keySetMinRefresh = time.Minute
+	// jwtClockSkew is how far the clocks of token issuers may be off
+	jwtClockSkew = time.Minute
+)
+
+// ErrInvalidJWT is returned for tokens that are malformed, badly signed,
+// expired or meant for someone else
+var ErrInvalidJWT = errors.New("invalid token")
+
+// keySet is a JSON Web Key Set fetched from an issuer and cached
+type keySet struct {
+	url       string
+	client    *http.Client
+	keys      map[string]crypto.PublicKey // By key ID
+	fetchedAt time.Time
+	mu        sync.Mutex
+}
+
+// newKeySet creates a key set fetched from url when first needed
+func newKeySet(url string, client *http.Client) *keySet {
+	return &keySet{url: url, client: client}
+}
+
+// key returns the public key with an ID, fetching the set again when the key
+// is unknown so rotated keys are picked up
+func (ks *keySet) key(kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.keys[kid]; ok && time.Since(ks.fetchedAt) < keySetLifetime {
+		return key, nil
+	}
+	if time.Since(ks.fetchedAt) < keySetMinRefresh && ks.keys != nil {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidJWT, kid)
+	}
+	if err := ks.fetchLocked(); err != nil {
+		return nil, err
+	}
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidJWT, kid)
+}
+
+// fetchLocked downloads the key set, keeping the RSA and EC signing keys
+func (ks *keySet) fetchLocked() error {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch signing keys: %s returned %s", ks.url, resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // Key types we cannot verify with
+		}
+		keys[jwk.Kid] = key
+	}
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return nil
+}
+
+// jsonWebKey is one key of a JWKS (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC curve
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes an RSA or EC public key
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("unsupported RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC key is not on its curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// jwtClaims are the claims of a verified JWT
+type jwtClaims map[string]interface{}
+
+// verifyJWT checks the signature of a compact JWS against the issuer's keys
+// and returns its claims. The claims still need checking with validate.
+func verifyJWT(token string, keys *keySet) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidJWT)
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidJWT)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidJWT)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidJWT)
+	}
+
+	key, err := keys.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidJWT)
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidJWT)
+	}
+	return claims, nil
+}
+
+// verifyJWS checks an RS256/384/512 or ES256/384/512 signature. The algorithm
+// must match the key, so a token cannot pick a weaker check.
+func verifyJWS(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidJWT, alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidJWT, alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" || rsa.VerifyPKCS1v15(key, hash, digest, signature) != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidJWT)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			return fmt.Errorf("%w: bad signature", ErrInvalidJWT)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidJWT)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key", ErrInvalidJWT)
+	}
+	return nil
+}
+
+// validate checks the issuer, audience and lifetime of the claims
+func (c jwtClaims) validate(issuer, audience string, now time.Time) error {
+	if c.string("iss") != issuer {
+		return fmt.Errorf("%w: issued by %q", ErrInvalidJWT, c.string("iss"))
+	}
+	if !containsString(c.strings("aud"), audience) {
+		return fmt.Errorf("%w: not meant for this dashboard", ErrInvalidJWT)
+	}
+	exp, ok := c.time("exp")
+	if !ok || now.After(exp.Add(jwtClockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidJWT)
+	}
+	if nbf, ok := c.time("nbf"); ok && now.Add(jwtClockSkew).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidJWT)
+	}
+	return nil
+}
+
+// string returns a string claim, or "" when it is missing or not a string
+func (c jwtClaims) string(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// strings returns a claim that is a string or a list of strings, such as aud
+// or groups
+func (c jwtClaims) strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// time returns a NumericDate claim such as exp
+func (c jwtClaims) time(name string) (time.Time, bool) {
+	value, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// bool returns a boolean claim. Some providers send email_verified as a string.
+func (c jwtClaims) bool(name string) bool {
+	switch value := c[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
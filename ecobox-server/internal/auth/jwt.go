@@ -83,18 +83,19 @@ func (jm *JWTManager) loadSessionKey() error {
 	return nil
 }
 
-// GenerateToken creates a JWT token for the given user
-func (jm *JWTManager) GenerateToken(user *User) (string, error) {
+// GenerateToken creates a JWT access token for the given user and session. It
+// is only accepted while the session is active, for at most lifetime.
+func (jm *JWTManager) GenerateToken(user *User, sessionID string, lifetime time.Duration) (string, error) {
 	if jm.sessionKey == nil {
 		return "", fmt.Errorf("session key not loaded")
 	}
 	
-	// Token expires in 365 days
-	expiresAt := time.Now().Add(365 * 24 * time.Hour).Unix()
+	expiresAt := time.Now().Add(lifetime).Unix()
 	
 	claims := Claims{
 		Username:  user.Username,
 		IsAdmin:   user.IsAdmin,
+		SessionID: sessionID,
 		IssuedAt:  time.Now().Unix(),
 		ExpiresAt: expiresAt,
 	}
@@ -184,19 +185,3 @@ func (jm *JWTManager) sign(message string) []byte {
 	h.Write([]byte(message))
 	return h.Sum(nil)
 }
-
-// RefreshToken creates a new token with extended expiration
-func (jm *JWTManager) RefreshToken(oldTokenString string) (string, error) {
-	claims, err := jm.ValidateToken(oldTokenString)
-	if err != nil {
-		return "", fmt.Errorf("cannot refresh invalid token: %w", err)
-	}
-	
-	// Create new token with same claims but new expiration
-	user := &User{
-		Username: claims.Username,
-		IsAdmin:  claims.IsAdmin,
-	}
-	
-	return jm.GenerateToken(user)
-}
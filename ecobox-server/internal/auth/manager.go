@@ -1,11 +1,20 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 	
 	"ecobox-server/internal/config"
+	"ecobox-server/internal/secrets"
 	"github.com/sirupsen/logrus"
 )
 
@@ -14,17 +23,39 @@ type Manager struct {
 	config     *config.Config
 	userStore  *UserStore
 	jwtManager *JWTManager
+	tokenStore *TokenStore
+	sessions   *SessionStore
+	twoFactor  *TwoFactorStore
 	logger     *logrus.Logger
+	
+	// Logins waiting for a second factor and passkey ceremonies in progress
+	pendingLogins map[string]*PendingLogin
+	challenges    map[string]*webauthnChallenge
+	loginMu       sync.Mutex
+	
+	throttle       *loginThrottle
+	oidc           *OIDCProvider // nil unless [oidc] is configured
+	cloudflareKeys *keySet       // Cloudflare Access signing keys, fetched on first use
 }
 
 // NewManager creates a new authentication manager
 func NewManager(cfg *config.Config) *Manager {
-	return &Manager{
+	am := &Manager{
 		config:     cfg,
 		userStore:  NewUserStore(cfg.Dashboard.PasswordFile),
 		jwtManager: NewJWTManager(cfg.Dashboard.SessionKeyFile),
+		tokenStore: NewTokenStore(cfg.Dashboard.TokensFile),
+		sessions:   NewSessionStore(cfg.Dashboard.SessionsFile, time.Duration(cfg.Dashboard.SessionLifetime)*24*time.Hour),
+		twoFactor:  NewTwoFactorStore(cfg.Dashboard.TwoFactorFile),
 		logger:     logrus.New(),
+		pendingLogins: make(map[string]*PendingLogin),
+		challenges:    make(map[string]*webauthnChallenge),
+		throttle:      newLoginThrottle(),
+	}
+	if cfg.OIDC.Enabled() {
+		am.oidc = NewOIDCProvider(cfg.OIDC)
 	}
+	return am
 }
 
 // Initialize sets up the authentication system
@@ -39,24 +70,66 @@ func (am *Manager) Initialize() error {
 		return fmt.Errorf("failed to initialize JWT manager: %w", err)
 	}
 	
+	// Load API tokens
+	if err := am.tokenStore.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize token store: %w", err)
+	}
+	
+	// Load login sessions
+	if err := am.sessions.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize session store: %w", err)
+	}
+	
+	// Load second factors
+	if err := am.twoFactor.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize two-factor store: %w", err)
+	}
+	
 	am.logger.Info("Authentication system initialized")
 	return nil
 }
 
+// identity is who made a request and how they were authenticated
+type identity struct {
+	user      *User
+	source    string // One of the AuthSource constants
+	sessionID string // Login session, for session cookies
+}
+
 // AuthenticateRequest authenticates an HTTP request
 func (am *Manager) AuthenticateRequest(r *http.Request) (*User, error) {
+	id, err := am.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	return id.user, nil
+}
+
+// authenticate authenticates an HTTP request and reports how the user was
+// identified
+func (am *Manager) authenticate(r *http.Request) (*identity, error) {
 	// Check for Identity-Aware Proxy authentication first
-	if am.config.Dashboard.IAPAuth != "none" {
+	if am.config.LiveDashboard().IAPAuth != "none" {
 		if user := am.checkIAPAuthentication(r); user != nil {
-			return user, nil
+			return &identity{user: user, source: AuthSourceIAP}, nil
 		}
 		
 		// If IAP is configured but header is missing, fall through to standard auth
 		am.logger.Debugf("IAP authentication failed, falling back to standard auth")
 	}
 	
+	// Check for an API token; a presented token that is not valid is an error
+	// rather than a reason to try the cookie
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		user, err := am.authenticateToken(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), am.RemoteIP(r))
+		if err != nil {
+			return nil, err
+		}
+		return &identity{user: user, source: AuthSourceToken}, nil
+	}
+	
 	// Check for JWT token in cookie
-	cookie, err := r.Cookie("auth_token")
+	cookie, err := r.Cookie(AccessCookieName)
 	if err != nil {
 		return nil, fmt.Errorf("authentication required")
 	}
@@ -66,79 +139,644 @@ func (am *Manager) AuthenticateRequest(r *http.Request) (*User, error) {
 		return nil, fmt.Errorf("invalid authentication token: %w", err)
 	}
 	
+	// The session must still be active, so revoking it takes effect at once.
+	// Tokens from before sessions existed have none and are refused.
+	session, err := am.sessions.Touch(claims.SessionID)
+	if err != nil || session.Username != claims.Username {
+		return nil, fmt.Errorf("session expired or revoked")
+	}
+	
 	// Get user from store to ensure it still exists
 	user, exists := am.userStore.GetUser(claims.Username)
 	if !exists {
 		return nil, fmt.Errorf("user no longer exists")
 	}
 	
+	return &identity{user: user, source: AuthSourceSession, sessionID: session.ID}, nil
+}
+
+// authenticateToken returns the user of an API token, limited to the token's
+// scopes
+func (am *Manager) authenticateToken(secret, remoteAddr string) (*User, error) {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	
+	token, err := am.tokenStore.Authenticate(secret, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	
+	user, exists := am.userStore.GetUser(token.Username)
+	if !exists {
+		return nil, fmt.Errorf("user no longer exists")
+	}
+	user.scopes = token.Scopes
 	return user, nil
 }
 
-// checkIAPAuthentication checks for Identity-Aware Proxy headers
-func (am *Manager) checkIAPAuthentication(r *http.Request) *User {
-	var username string
-	
-	switch AuthMethod(am.config.Dashboard.IAPAuth) {
-	case AuthMethodTailscale:
-		// Tailscale sets X-Forwarded-User header
-		username = r.Header.Get("X-Forwarded-User")
-	case AuthMethodAuthentik:
-		// Authentik sets X-Forwarded-User header
-		username = r.Header.Get("X-Forwarded-User")
-	case AuthMethodCloudflare:
-		// Cloudflare Access sets X-Forwarded-User header
-		username = r.Header.Get("X-Forwarded-User")
+// Login authenticates a user with username/password and starts a session on
+// the device described by remoteAddr and userAgent. A user with a second
+// factor, or who must enroll one, gets a *SecondFactorRequiredError instead,
+// whose pending login is completed with CompleteLogin or FinishPasskeyLogin.
+func (am *Manager) Login(username, password, remoteAddr, userAgent string) (*SessionTokens, *User, error) {
+	keys := throttleKeys(username, remoteAddr)
+	if wait := am.throttle.wait(keys, time.Now()); wait > 0 {
+		return nil, nil, &LoginThrottledError{RetryAfter: wait}
+	}
+	
+	user, err := am.userStore.AuthenticateUser(username, password)
+	if err != nil {
+		am.failLogin(keys)
+		return nil, nil, fmt.Errorf("authentication failed: %w", err)
+	}
+	am.throttle.reset(keys[0])
+	
+	if am.twoFactor.Enrolled(user.Username) || user.TwoFactorRequired {
+		pending, err := am.newPendingLogin(user.Username)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, user, &SecondFactorRequiredError{Pending: pending}
+	}
+	
+	return am.startSession(user, remoteAddr, userAgent)
+}
+
+// failLogin counts a failed password or code against the user and address
+func (am *Manager) failLogin(keys []string) {
+	dashboard := am.config.LiveDashboard()
+	lockout := time.Duration(dashboard.LoginLockout) * time.Minute
+	am.throttle.fail(keys, dashboard.LoginMaxFailures, lockout, time.Now())
+}
+
+// CompleteLogin finishes a pending login with a TOTP or recovery code, or with
+// the first code of the TOTP enrollment the login asked for
+func (am *Manager) CompleteLogin(loginToken, code, remoteAddr, userAgent string) (*SessionTokens, *User, error) {
+	pending, exists := am.PendingLogin(loginToken)
+	if !exists {
+		return nil, nil, ErrLoginExpired
+	}
+	keys := throttleKeys(pending.Username, remoteAddr)
+	if wait := am.throttle.wait(keys, time.Now()); wait > 0 {
+		return nil, nil, &LoginThrottledError{RetryAfter: wait}
+	}
+	pending, err := am.attemptPendingLogin(loginToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	switch {
+	case pending.Enrollment != nil:
+		err = am.twoFactor.ConfirmTOTP(pending.Username, code)
+	case isRecoveryCode(code):
+		if err = am.twoFactor.UseRecoveryCode(pending.Username, code); err == nil {
+			am.logger.Warnf("User %s logged in with a recovery code; %d left", pending.Username, am.twoFactor.Status(pending.Username).RecoveryCodesLeft)
+		}
 	default:
-		return nil
+		err = am.twoFactor.VerifyTOTP(pending.Username, code)
+	}
+	if err != nil {
+		am.failLogin(keys)
+		return nil, nil, err
 	}
+	am.throttle.reset(keys[0])
 	
-	if username == "" {
-		return nil
+	if !am.takePendingLogin(loginToken) {
+		return nil, nil, ErrLoginExpired
+	}
+	user, exists := am.userStore.GetUser(pending.Username)
+	if !exists {
+		return nil, nil, fmt.Errorf("user no longer exists")
+	}
+	return am.startSession(user, remoteAddr, userAgent)
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get. With a
+// login token the passkey completes that password login; without one it is a
+// login of its own, which needs an authenticator that verifies the user.
+func (am *Manager) BeginPasskeyLogin(loginToken string, rp RelyingParty) (*CredentialRequestOptions, error) {
+	options := &CredentialRequestOptions{
+		RPID:             rp.ID,
+		Timeout:          int(webauthnChallengeLifetime.Milliseconds()),
+		UserVerification: "required",
+		AllowCredentials: []CredentialDescriptor{},
 	}
+	challenge := &webauthnChallenge{loginToken: loginToken, rp: rp}
 	
-	// Clean up username (remove domain part if present)
-	if idx := strings.Index(username, "@"); idx != -1 {
-		username = username[:idx]
+	if loginToken != "" {
+		am.loginMu.Lock()
+		pending, exists := am.pendingLogins[loginToken]
+		am.loginMu.Unlock()
+		if !exists || time.Now().After(pending.ExpiresAt) {
+			return nil, ErrLoginExpired
+		}
+		ids := am.twoFactor.PasskeyIDs(pending.Username)
+		if len(ids) == 0 {
+			return nil, ErrPasskeyNotFound
+		}
+		challenge.username = pending.Username
+		options.UserVerification = "preferred"
+		options.AllowCredentials = credentialDescriptors(ids)
+		options.LoginToken = loginToken
 	}
 	
-	// Get or create user
+	id, err := am.newChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+	options.Challenge = id
+	return options, nil
+}
+
+// FinishPasskeyLogin checks the result of navigator.credentials.get and starts a
+// session
+func (am *Manager) FinishPasskeyLogin(response *CredentialAssertionResponse, remoteAddr, userAgent string) (*SessionTokens, *User, error) {
+	challenge, ok := am.takeChallenge(clientDataChallenge(response.Response.ClientDataJSON), false)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown or expired challenge", ErrInvalidPasskey)
+	}
+	
+	username, handle, passkey, found := am.twoFactor.FindPasskey(response.ID)
+	if !found || (challenge.username != "" && challenge.username != username) {
+		return nil, nil, fmt.Errorf("%w: unknown passkey", ErrInvalidPasskey)
+	}
+	if userHandle := strings.TrimRight(response.Response.UserHandle, "="); userHandle != "" && userHandle != handle {
+		return nil, nil, fmt.Errorf("%w: passkey belongs to another user", ErrInvalidPasskey)
+	}
+	
+	data, err := verifyAssertion(challenge.rp, response, passkey.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if challenge.loginToken == "" && data.flags&authFlagUserVerified == 0 {
+		return nil, nil, fmt.Errorf("%w: the authenticator did not verify the user", ErrInvalidPasskey)
+	}
+	if err := am.twoFactor.RecordPasskeyUse(passkey.ID, data.signCount); err != nil {
+		am.logger.Warnf("Passkey %s of %s refused: %v", passkey.ID, username, err)
+		return nil, nil, err
+	}
+	
+	if challenge.loginToken != "" && !am.takePendingLogin(challenge.loginToken) {
+		return nil, nil, ErrLoginExpired
+	}
 	user, exists := am.userStore.GetUser(username)
 	if !exists {
-		// Create user automatically for IAP
-		am.logger.Infof("Creating new user for IAP authentication: %s", username)
-		_, err := am.userStore.CreateUser(username, false)
+		return nil, nil, fmt.Errorf("user no longer exists")
+	}
+	return am.startSession(user, remoteAddr, userAgent)
+}
+
+// startSession starts a session for an authenticated user and issues its first
+// tokens
+func (am *Manager) startSession(user *User, remoteAddr, userAgent string) (*SessionTokens, *User, error) {
+	session, refresh, err := am.sessions.Create(user.Username, remoteAddr, userAgent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start session: %w", err)
+	}
+	
+	tokens, err := am.issueTokens(user, session, refresh)
+	if err != nil {
+		am.sessions.Revoke(session.ID)
+		return nil, nil, err
+	}
+	
+	return tokens, user, nil
+}
+
+// newPendingLogin holds a password login until its second factor arrives. A
+// user who must have a second factor but has none is asked to enroll TOTP.
+func (am *Manager) newPendingLogin(username string) (*PendingLogin, error) {
+	pending := &PendingLogin{
+		Username:  username,
+		ExpiresAt: time.Now().Add(pendingLoginLifetime),
+	}
+	
+	if am.twoFactor.Enrolled(username) {
+		status := am.twoFactor.Status(username)
+		if status.TOTPEnabled {
+			pending.Methods = append(pending.Methods, "totp")
+		}
+		if len(status.Passkeys) > 0 {
+			pending.Methods = append(pending.Methods, "passkey")
+		}
+		if status.RecoveryCodesLeft > 0 {
+			pending.Methods = append(pending.Methods, "recovery")
+		}
+	} else {
+		enrollment, err := am.twoFactor.BeginTOTP(username)
 		if err != nil {
-			am.logger.Errorf("Failed to create IAP user %s: %v", username, err)
-			return nil
+			return nil, err
 		}
-		
-		// Set empty password for IAP users
-		if err := am.userStore.SetPassword(username, ""); err != nil {
-			am.logger.Errorf("Failed to set empty password for IAP user %s: %v", username, err)
-			return nil
+		pending.Enrollment = enrollment
+		pending.Methods = []string{"totp"}
+	}
+	
+	token, err := randomHex(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate login token: %w", err)
+	}
+	pending.Token = token
+	
+	am.loginMu.Lock()
+	defer am.loginMu.Unlock()
+	am.pruneLoginsLocked()
+	am.pendingLogins[token] = pending
+	
+	found := *pending
+	return &found, nil
+}
+
+// PendingLogin returns a copy of a pending login that has not expired
+func (am *Manager) PendingLogin(token string) (*PendingLogin, bool) {
+	am.loginMu.Lock()
+	defer am.loginMu.Unlock()
+	
+	pending, exists := am.pendingLogins[token]
+	if !exists || time.Now().After(pending.ExpiresAt) {
+		return nil, false
+	}
+	found := *pending
+	return &found, true
+}
+
+// attemptPendingLogin counts an attempt at a pending login and returns a copy of
+// it. A login out of attempts is dropped.
+func (am *Manager) attemptPendingLogin(token string) (*PendingLogin, error) {
+	am.loginMu.Lock()
+	defer am.loginMu.Unlock()
+	
+	pending, exists := am.pendingLogins[token]
+	if !exists || time.Now().After(pending.ExpiresAt) {
+		delete(am.pendingLogins, token)
+		return nil, ErrLoginExpired
+	}
+	pending.attempts++
+	if pending.attempts > pendingLoginAttempts {
+		delete(am.pendingLogins, token)
+		am.logger.Warnf("Login of %s dropped after %d wrong codes", pending.Username, pendingLoginAttempts)
+		return nil, ErrLoginExpired
+	}
+	
+	found := *pending
+	return &found, nil
+}
+
+// takePendingLogin removes a pending login once it is complete, reporting
+// whether it was still there
+func (am *Manager) takePendingLogin(token string) bool {
+	am.loginMu.Lock()
+	defer am.loginMu.Unlock()
+	
+	pending, exists := am.pendingLogins[token]
+	delete(am.pendingLogins, token)
+	return exists && time.Now().Before(pending.ExpiresAt)
+}
+
+// newChallenge registers a passkey ceremony and returns its challenge
+func (am *Manager) newChallenge(challenge *webauthnChallenge) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	challenge.expiresAt = time.Now().Add(webauthnChallengeLifetime)
+	
+	am.loginMu.Lock()
+	defer am.loginMu.Unlock()
+	am.pruneLoginsLocked()
+	am.challenges[id] = challenge
+	return id, nil
+}
+
+// takeChallenge removes and returns a passkey ceremony of the given kind. Each
+// challenge can be answered once.
+func (am *Manager) takeChallenge(id string, registering bool) (*webauthnChallenge, bool) {
+	am.loginMu.Lock()
+	defer am.loginMu.Unlock()
+	
+	challenge, exists := am.challenges[id]
+	delete(am.challenges, id)
+	if !exists || challenge.registering != registering || time.Now().After(challenge.expiresAt) {
+		return nil, false
+	}
+	return challenge, true
+}
+
+// pruneLoginsLocked drops expired pending logins and challenges (assumes
+// loginMu is already held)
+func (am *Manager) pruneLoginsLocked() {
+	now := time.Now()
+	for token, pending := range am.pendingLogins {
+		if now.After(pending.ExpiresAt) {
+			delete(am.pendingLogins, token)
+		}
+	}
+	for id, challenge := range am.challenges {
+		if now.After(challenge.expiresAt) {
+			delete(am.challenges, id)
+		}
+	}
+}
+
+// RelyingParty returns the WebAuthn relying party for a request. The
+// configured ID and origins win; otherwise passkeys are bound to the host the
+// dashboard was reached on.
+func (am *Manager) RelyingParty(r *http.Request) RelyingParty {
+	dashboard := am.config.LiveDashboard()
+	rp := RelyingParty{
+		ID:   dashboard.WebAuthnRPID,
+		Name: totpIssuer,
+	}
+	if rp.ID == "" {
+		rp.ID = r.Host
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			rp.ID = host
+		}
+	}
+	
+	for _, origin := range dashboard.WebAuthnOrigins {
+		rp.Origins = append(rp.Origins, strings.TrimSuffix(origin, "/"))
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{am.requestOrigin(r)}
+	}
+	return rp
+}
+
+// requestOrigin returns the scheme and host the browser reached the dashboard
+// on
+func (am *Manager) requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if am.IsHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// OIDCEnabled reports whether users can log in with an OpenID Connect provider
+func (am *Manager) OIDCEnabled() bool {
+	return am.oidc != nil
+}
+
+// BeginOIDCLogin starts a login at the OIDC provider and returns the URL to
+// send the browser to and the state that ties the callback to this browser
+func (am *Manager) BeginOIDCLogin(r *http.Request) (string, string, error) {
+	if am.oidc == nil {
+		return "", "", fmt.Errorf("OIDC login is not configured")
+	}
+	redirectURL := am.config.OIDC.RedirectURL
+	if redirectURL == "" {
+		redirectURL = am.requestOrigin(r) + "/login/oidc/callback"
+	}
+	return am.oidc.AuthCodeURL(redirectURL)
+}
+
+// FinishOIDCLogin redeems the authorization code of an OIDC login and starts a
+// session as the user linked to the provider's (issuer, sub) pair. The
+// provider's groups set the user's role on every login. When auto_provision is
+// on, an unlinked identity gets a new linked user, unless its username is taken.
+// Second factors are left to the provider.
+func (am *Manager) FinishOIDCLogin(ctx context.Context, state, code, remoteAddr, userAgent string) (*SessionTokens, *User, error) {
+	if am.oidc == nil {
+		return nil, nil, fmt.Errorf("OIDC login is not configured")
+	}
+	identity, err := am.oidc.Exchange(ctx, state, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !am.oidc.allowed(identity.Groups) {
+		return nil, nil, fmt.Errorf("%w: %s is in none of the allowed groups", ErrOIDCDenied, identity.Username)
+	}
+	
+	role, mapped := am.oidc.role(identity.Groups)
+	user, linked := am.userStore.GetOIDCUser(identity.Issuer, identity.Subject)
+	if !linked {
+		// A matching username is not proof of identity: the provider's users
+		// may pick their own, so only a link an admin or provisioning made counts
+		if _, taken := am.userStore.GetUser(identity.Username); taken {
+			return nil, nil, fmt.Errorf("%w: %s is taken by a user not linked to this OIDC account", ErrOIDCDenied, identity.Username)
+		}
+		if !am.config.OIDC.AutoProvision {
+			return nil, nil, fmt.Errorf("%w: %s has no account", ErrOIDCDenied, identity.Username)
+		}
+		if !mapped {
+			role = am.defaultRole()
 		}
 		
-		user, _ = am.userStore.GetUser(username)
+		am.logger.Infof("Creating user %s on first OIDC login", identity.Username)
+		if err := am.userStore.CreateOIDCUser(identity.Username, role, identity.Issuer, identity.Subject); err != nil {
+			// Another login of the same identity may have created it first
+			if user, linked = am.userStore.GetOIDCUser(identity.Issuer, identity.Subject); !linked {
+				return nil, nil, fmt.Errorf("%w: failed to create user %s: %v", ErrOIDCDenied, identity.Username, err)
+			}
+		} else {
+			user, _ = am.userStore.GetUser(identity.Username)
+		}
+	} else if mapped && user.Role != role {
+		updated, err := am.SetUserAccess(user.Username, role, user.Grants)
+		if err != nil {
+			am.logger.Warnf("Keeping role %s of %s instead of %s from OIDC groups: %v", user.Role, user.Username, role, err)
+		} else {
+			am.logger.Infof("Role of %s changed from %s to %s by OIDC groups", user.Username, user.Role, role)
+			user = updated
+		}
 	}
 	
-	return user
+	return am.startSession(user, remoteAddr, userAgent)
 }
 
-// Login authenticates a user with username/password
-func (am *Manager) Login(username, password string) (string, *User, error) {
-	user, err := am.userStore.AuthenticateUser(username, password)
+// LinkOIDC links a user to a subject of the configured OIDC provider, so that
+// logging in there logs in as the user. An empty subject removes the link.
+func (am *Manager) LinkOIDC(username, subject string) (*User, error) {
+	if am.oidc == nil && subject != "" {
+		return nil, fmt.Errorf("OIDC login is not configured")
+	}
+	issuer := strings.TrimSuffix(am.config.OIDC.Issuer, "/")
+	if err := am.userStore.SetOIDCLink(username, issuer, subject); err != nil {
+		return nil, err
+	}
+	
+	user, _ := am.userStore.GetUser(username)
+	return user, nil
+}
+
+// TwoFactorStatus describes a user's second factors
+func (am *Manager) TwoFactorStatus(username string) TwoFactorStatus {
+	status := am.twoFactor.Status(username)
+	if user, exists := am.userStore.GetUser(username); exists {
+		status.Required = user.TwoFactorRequired
+	}
+	return status
+}
+
+// BeginTOTPEnrollment creates a TOTP secret and recovery codes for a user. They
+// replace the current ones once confirmed with ConfirmTOTPEnrollment.
+func (am *Manager) BeginTOTPEnrollment(username string) (*TOTPEnrollment, error) {
+	return am.twoFactor.BeginTOTP(username)
+}
+
+// ConfirmTOTPEnrollment enables the pending TOTP secret of a user with its first
+// code
+func (am *Manager) ConfirmTOTPEnrollment(username, code string) error {
+	return am.twoFactor.ConfirmTOTP(username, code)
+}
+
+// DisableTOTP removes a user's TOTP secret. A user required to have a second
+// factor cannot remove the last one.
+func (am *Manager) DisableTOTP(username string) error {
+	if err := am.checkKeepsSecondFactor(username); err != nil {
+		return err
+	}
+	return am.twoFactor.DisableTOTP(username)
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes
+func (am *Manager) RegenerateRecoveryCodes(username string) ([]string, error) {
+	return am.twoFactor.RegenerateRecoveryCodes(username)
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create
+func (am *Manager) BeginPasskeyRegistration(username string, rp RelyingParty) (*CredentialCreationOptions, error) {
+	handle, err := am.twoFactor.UserHandle(username)
+	if err != nil {
+		return nil, err
+	}
+	
+	challenge, err := am.newChallenge(&webauthnChallenge{username: username, registering: true, rp: rp})
+	if err != nil {
+		return nil, err
+	}
+	return newCreationOptions(rp, challenge, handle, username, am.twoFactor.PasskeyIDs(username)), nil
+}
+
+// FinishPasskeyRegistration checks the result of navigator.credentials.create
+// and saves the passkey. Recovery codes are returned with a user's first factor.
+func (am *Manager) FinishPasskeyRegistration(username, name string, response *CredentialCreationResponse) (*Passkey, []string, error) {
+	challenge, ok := am.takeChallenge(clientDataChallenge(response.Response.ClientDataJSON), true)
+	if !ok || challenge.username != username {
+		return nil, nil, fmt.Errorf("%w: unknown or expired challenge", ErrInvalidPasskey)
+	}
+	
+	data, err := verifyRegistration(challenge.rp, response)
+	if err != nil {
+		return nil, nil, err
+	}
+	return am.twoFactor.AddPasskey(username, name, data.credentialID, data.publicKey, data.signCount)
+}
+
+// DeletePasskey removes one of a user's passkeys. A user required to have a
+// second factor cannot remove the last one.
+func (am *Manager) DeletePasskey(username, id string) error {
+	if err := am.checkKeepsSecondFactor(username); err != nil {
+		return err
+	}
+	return am.twoFactor.DeletePasskey(username, id)
+}
+
+// checkKeepsSecondFactor refuses to remove a factor from a user who is
+// required to have one and has only one
+func (am *Manager) checkKeepsSecondFactor(username string) error {
+	status := am.TwoFactorStatus(username)
+	factors := len(status.Passkeys)
+	if status.TOTPEnabled {
+		factors++
+	}
+	if status.Required && factors <= 1 {
+		return fmt.Errorf("a second factor is required for this account; add another before removing this one")
+	}
+	return nil
+}
+
+// SetTwoFactorRequired sets whether a user must present a second factor to log
+// in. Requiring one from a user who has none ends their sessions, so they enroll
+// at their next login.
+func (am *Manager) SetTwoFactorRequired(username string, required bool) (*User, error) {
+	if err := am.userStore.SetTwoFactorRequired(username, required); err != nil {
+		return nil, err
+	}
+	
+	if required && !am.twoFactor.Enrolled(username) {
+		if _, err := am.sessions.RevokeUser(username, ""); err != nil {
+			am.logger.Errorf("Failed to revoke sessions of %s after requiring a second factor: %v", username, err)
+		}
+	}
+	
+	user, _ := am.GetUser(username)
+	return user, nil
+}
+
+// ResetTwoFactor removes every second factor of a user, for one who lost them
+func (am *Manager) ResetTwoFactor(username string) error {
+	if _, exists := am.userStore.GetUser(username); !exists {
+		return fmt.Errorf("user not found")
+	}
+	return am.twoFactor.Reset(username)
+}
+
+// RefreshToken exchanges a session's refresh token for a new access token and
+// a new refresh token. Each refresh token works once; presenting one again
+// revokes the session.
+func (am *Manager) RefreshToken(refresh, remoteAddr, userAgent string) (*SessionTokens, *User, error) {
+	session, next, err := am.sessions.Refresh(refresh, remoteAddr, userAgent)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		am.logger.Warnf("Refresh token of session %s (%s) was reused from %s; session revoked", session.ID, session.Username, remoteAddr)
+		return nil, nil, fmt.Errorf("%w (session %s of %s)", err, session.ID, session.Username)
+	}
 	if err != nil {
-		return "", nil, fmt.Errorf("authentication failed: %w", err)
+		return nil, nil, err
+	}
+	
+	user, exists := am.userStore.GetUser(session.Username)
+	if !exists {
+		am.sessions.Revoke(session.ID)
+		return nil, nil, fmt.Errorf("user no longer exists")
 	}
 	
-	// Generate JWT token
-	token, err := am.jwtManager.GenerateToken(user)
+	tokens, err := am.issueTokens(user, session, next)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, nil, err
+	}
+	return tokens, user, nil
+}
+
+// issueTokens creates an access token for a session
+func (am *Manager) issueTokens(user *User, session *Session, refresh string) (*SessionTokens, error) {
+	lifetime := time.Duration(am.config.LiveDashboard().AccessTokenLifetime) * time.Minute
+	access, err := am.jwtManager.GenerateToken(user, session.ID, lifetime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 	
-	return token, user, nil
+	return &SessionTokens{
+		SessionID:        session.ID,
+		AccessToken:      access,
+		AccessExpiresAt:  time.Now().Add(lifetime),
+		RefreshToken:     refresh,
+		SessionExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// ListSessions returns a user's active sessions, or every active session when
+// username is empty
+func (am *Manager) ListSessions(username string) []Session {
+	return am.sessions.List(username)
+}
+
+// GetSession returns an active session by ID
+func (am *Manager) GetSession(id string) (*Session, bool) {
+	return am.sessions.Get(id)
+}
+
+// RevokeSession ends a session
+func (am *Manager) RevokeSession(id string) error {
+	return am.sessions.Revoke(id)
+}
+
+// RevokeUserSessions ends every session of a user except the one with ID
+// except, and returns how many were ended
+func (am *Manager) RevokeUserSessions(username, except string) (int, error) {
+	return am.sessions.RevokeUser(username, except)
 }
 
 // CompleteFirstTimeSetup completes the first-time setup process
@@ -152,6 +790,7 @@ func (am *Manager) ListUsers() []User {
 	result := make([]User, len(users))
 	for i, user := range users {
 		result[i] = *user
+		result[i].TwoFactorEnabled = am.twoFactor.Enrolled(user.Username)
 	}
 	return result
 }
@@ -161,6 +800,16 @@ func (am *Manager) UpdateUser(user *User) error {
 	return am.userStore.UpdateUser(user)
 }
 
+// CheckPassword verifies a user's password without logging them in, before
+// changes that need it entered again
+func (am *Manager) CheckPassword(username, password string) error {
+	user, exists := am.userStore.GetUser(username)
+	if !exists || VerifyPassword(password, user.PasswordHash) != nil {
+		return fmt.Errorf("password is incorrect")
+	}
+	return nil
+}
+
 // ChangePassword changes a user's password with optional current password requirement
 func (am *Manager) ChangePassword(username, currentPassword, newPassword string, requireCurrentPassword bool) error {
 	if requireCurrentPassword {
@@ -183,9 +832,13 @@ func (am *Manager) ChangePassword(username, currentPassword, newPassword string,
 	return nil
 }
 
-// CreateUser creates a new user and returns the user and initial password
-func (am *Manager) CreateUser(username string, isAdmin bool) (*User, string, error) {
-	initialPassword, err := am.userStore.CreateUser(username, isAdmin)
+// CreateUser creates a new user and returns the user and initial password. An
+// empty role means the configured default role.
+func (am *Manager) CreateUser(username string, role Role, grants []Grant) (*User, string, error) {
+	if role == "" {
+		role = am.defaultRole()
+	}
+	initialPassword, err := am.userStore.CreateUser(username, role, grants)
 	if err != nil {
 		return nil, "", err
 	}
@@ -198,9 +851,70 @@ func (am *Manager) CreateUser(username string, isAdmin bool) (*User, string, err
 	return user, initialPassword, nil
 }
 
-// DeleteUser removes a user
+// defaultRole returns the role of new users created without one
+func (am *Manager) defaultRole() Role {
+	if role := am.config.LiveDashboard().DefaultRole; role != "" {
+		return Role(role)
+	}
+	return RoleOperator
+}
+
+// SetUserAccess replaces a user's role and grants. The admin user always keeps
+// the admin role, so there is always someone who can manage users.
+func (am *Manager) SetUserAccess(username string, role Role, grants []Grant) (*User, error) {
+	if username == "admin" && role != RoleAdmin {
+		return nil, fmt.Errorf("the admin user must keep the admin role")
+	}
+	
+	if err := am.userStore.SetAccess(username, role, grants); err != nil {
+		return nil, err
+	}
+	
+	user, _ := am.userStore.GetUser(username)
+	return user, nil
+}
+
+// DeleteUser removes a user, their second factors, and revokes their sessions
+// and API tokens
 func (am *Manager) DeleteUser(username string) error {
-	return am.userStore.DeleteUser(username)
+	if err := am.userStore.DeleteUser(username); err != nil {
+		return err
+	}
+	
+	if _, err := am.sessions.RevokeUser(username, ""); err != nil {
+		am.logger.Errorf("Failed to revoke sessions of deleted user %s: %v", username, err)
+	}
+	if err := am.twoFactor.Reset(username); err != nil {
+		am.logger.Errorf("Failed to remove second factors of deleted user %s: %v", username, err)
+	}
+	if err := am.tokenStore.DeleteUserTokens(username); err != nil {
+		am.logger.Errorf("Failed to revoke API tokens of deleted user %s: %v", username, err)
+	}
+	return nil
+}
+
+// CreateToken creates an API token for a user and returns it with the token
+// itself, which cannot be shown again
+func (am *Manager) CreateToken(username, name string, scopes []Permission, expiresAt *time.Time) (*APIToken, string, error) {
+	if _, exists := am.userStore.GetUser(username); !exists {
+		return nil, "", fmt.Errorf("user not found")
+	}
+	return am.tokenStore.Create(username, name, scopes, expiresAt)
+}
+
+// ListTokens returns a user's API tokens, or every token when username is empty
+func (am *Manager) ListTokens(username string) []APIToken {
+	return am.tokenStore.List(username)
+}
+
+// GetToken returns an API token by ID
+func (am *Manager) GetToken(id string) (*APIToken, bool) {
+	return am.tokenStore.Get(id)
+}
+
+// RevokeToken deletes an API token
+func (am *Manager) RevokeToken(id string) error {
+	return am.tokenStore.Delete(id)
 }
 
 // GetAllUsers returns all users
@@ -210,13 +924,17 @@ func (am *Manager) GetAllUsers() []*User {
 
 // GetUser returns a specific user
 func (am *Manager) GetUser(username string) (*User, bool) {
-	return am.userStore.GetUser(username)
+	user, exists := am.userStore.GetUser(username)
+	if exists {
+		user.TwoFactorEnabled = am.twoFactor.Enrolled(username)
+	}
+	return user, exists
 }
 
 // IsFirstTimeSetup checks if this is the first time setup
 func (am *Manager) IsFirstTimeSetup() bool {
 	// If IAP is enabled and not "none", first-time setup is not required
-	if am.config.Dashboard.IAPAuth != "none" {
+	if am.config.LiveDashboard().IAPAuth != "none" {
 		return false
 	}
 	
@@ -226,7 +944,7 @@ func (am *Manager) IsFirstTimeSetup() bool {
 // RequiresAuthentication checks if authentication is required for the system
 func (am *Manager) RequiresAuthentication() bool {
 	// If IAP is enabled, authentication is handled by proxy
-	if am.config.Dashboard.IAPAuth != "none" {
+	if am.config.LiveDashboard().IAPAuth != "none" {
 		return false // No login screen needed
 	}
 	
@@ -234,7 +952,23 @@ func (am *Manager) RequiresAuthentication() bool {
 	return am.userStore.HasUsersWithPasswords()
 }
 
+// randomHex returns n random bytes as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // SetLogger sets a custom logger
 func (am *Manager) SetLogger(logger *logrus.Logger) {
 	am.logger = logger
 }
+
+// SetSecrets sets the vault the OIDC client secret is read from
+func (am *Manager) SetSecrets(vault *secrets.Vault) {
+	if am.oidc != nil {
+		am.oidc.secrets = vault
+	}
+}
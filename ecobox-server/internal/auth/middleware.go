@@ -2,6 +2,8 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -12,6 +14,23 @@ type contextKey string
 const (
 	// UserContextKey is the key for storing user in request context
 	UserContextKey contextKey = "user"
+	// SourceContextKey is the key for storing how the user was authenticated
+	SourceContextKey contextKey = "auth_source"
+	// SessionContextKey is the key for storing the login session of the request
+	SessionContextKey contextKey = "session"
+)
+
+// Cookie names
+const (
+	AccessCookieName  = "auth_token"    // Short-lived access token
+	RefreshCookieName = "refresh_token" // Renews the access token
+)
+
+// How a request's user was authenticated
+const (
+	AuthSourceSession = "session" // Login cookie
+	AuthSourceIAP     = "iap"     // Identity-aware proxy header
+	AuthSourceToken   = "token"   // API token in the Authorization header
 )
 
 // Middleware provides authentication middleware for HTTP requests
@@ -30,7 +49,7 @@ func NewMiddleware(authManager *Manager) *Middleware {
 func (am *Middleware) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if this is first-time setup
-		if am.authManager.IsFirstTimeSetup() && am.authManager.config.Dashboard.IAPAuth == "none" {
+		if am.authManager.IsFirstTimeSetup() && am.authManager.config.LiveDashboard().IAPAuth == "none" {
 			// During first-time setup, only allow /setup and static assets
 			if r.URL.Path == "/setup" || strings.HasPrefix(r.URL.Path, "/static/") || r.URL.Path == "/favicon.ico" {
 				next.ServeHTTP(w, r)
@@ -48,25 +67,63 @@ func (am *Middleware) RequireAuth(next http.Handler) http.Handler {
 		}
 		
 		// Check if authentication is required
-		if !am.authManager.RequiresAuthentication() && am.authManager.config.Dashboard.IAPAuth == "none" {
+		if !am.authManager.RequiresAuthentication() && am.authManager.config.LiveDashboard().IAPAuth == "none" {
 			// No authentication required yet 
 			next.ServeHTTP(w, r)
 			return
 		}
 		
-		// Authenticate the request
-		user, err := am.authManager.AuthenticateRequest(r)
+		// Authenticate the request, renewing an expired access token from the
+		// refresh cookie so browsers never see it expire
+		id, err := am.authManager.authenticate(r)
+		if err != nil && r.Header.Get("Authorization") == "" {
+			if refreshed, refreshErr := am.refreshSession(w, r); refreshErr == nil {
+				id, err = refreshed, nil
+			}
+		}
 		if err != nil {
 			am.handleAuthenticationError(w, r, err)
 			return
 		}
 		
-		// Add user to request context
-		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		// Browsers send cookies, and proxies add identities, to requests other
+		// sites make them send, so changes must carry the CSRF token
+		if id.source == AuthSourceSession || id.source == AuthSourceIAP {
+			if _, err := r.Cookie(CSRFCookieName); err != nil {
+				am.authManager.setCSRFCookie(w, r)
+			}
+			if err := checkCSRF(r); err != nil {
+				am.handleCSRFError(w, r)
+				return
+			}
+		}
+		
+		// Add user and how they were authenticated to request context
+		ctx := context.WithValue(r.Context(), UserContextKey, id.user)
+		ctx = context.WithValue(ctx, SourceContextKey, id.source)
+		ctx = context.WithValue(ctx, SessionContextKey, id.sessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// refreshSession renews the session in the refresh cookie and sets the new
+// cookies on the response
+func (am *Middleware) refreshSession(w http.ResponseWriter, r *http.Request) (*identity, error) {
+	cookie, err := r.Cookie(RefreshCookieName)
+	if err != nil {
+		return nil, err
+	}
+	
+	tokens, user, err := am.authManager.RefreshToken(cookie.Value, am.authManager.RemoteIP(r), r.UserAgent())
+	if err != nil {
+		am.authManager.ClearAuthCookie(w, r)
+		return nil, err
+	}
+	
+	am.authManager.SetSessionCookies(w, r, tokens)
+	return &identity{user: user, source: AuthSourceSession, sessionID: tokens.SessionID}, nil
+}
+
 // RequireAdmin is middleware that requires admin privileges
 func (am *Middleware) RequireAdmin(next http.Handler) http.Handler {
 	return am.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -76,7 +133,7 @@ func (am *Middleware) RequireAdmin(next http.Handler) http.Handler {
 			return
 		}
 		
-		if !user.IsAdmin {
+		if !user.HasRole(RoleAdmin) {
 			http.Error(w, "Admin privileges required", http.StatusForbidden)
 			return
 		}
@@ -90,11 +147,14 @@ func (am *Middleware) isPublicPath(path string) bool {
 	publicPaths := []string{
 		"/login",
 		"/setup",
+		"/api/auth/refresh",
 		"/static/",
 		"/static-vue/",
 		"/css/",
 		"/js/",
 		"/favicon.ico",
+		"/tls/ca.pem",
+		"/api/v1/openapi.json",
 	}
 	
 	for _, publicPath := range publicPaths {
@@ -110,9 +170,7 @@ func (am *Middleware) isPublicPath(path string) bool {
 func (am *Middleware) handleAuthenticationError(w http.ResponseWriter, r *http.Request, err error) {
 	// For API requests, return JSON error
 	if strings.HasPrefix(r.URL.Path, "/api/") {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`{"success": false, "message": "Authentication required"}`))
+		writeAPIError(w, r, http.StatusUnauthorized, "unauthorized", "Authentication required")
 		return
 	}
 	
@@ -132,6 +190,27 @@ func (am *Middleware) handleAuthenticationError(w http.ResponseWriter, r *http.R
 	http.Error(w, "Authentication required", http.StatusUnauthorized)
 }
 
+// handleCSRFError refuses a change without a valid CSRF token
+func (am *Middleware) handleCSRFError(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, r, http.StatusForbidden, "forbidden", "Missing or invalid CSRF token")
+		return
+	}
+	http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+}
+
+// writeAPIError answers an API request with an error in the format of its API
+// version: a code and message for /api/v1, success and message otherwise
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	var body interface{} = map[string]interface{}{"success": false, "message": message}
+	if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+		body = map[string]interface{}{"error": map[string]string{"code": code, "message": message}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
 // GetUserFromContext retrieves the user from request context
 func GetUserFromContext(ctx context.Context) *User {
 	user, ok := ctx.Value(UserContextKey).(*User)
@@ -141,32 +220,84 @@ func GetUserFromContext(ctx context.Context) *User {
 	return user
 }
 
-// SetAuthCookie sets the authentication cookie
-func SetAuthCookie(w http.ResponseWriter, token string) {
-	cookie := &http.Cookie{
-		Name:     "auth_token",
-		Value:    token,
+// GetAuthSourceFromContext returns how the request's user was authenticated,
+// or "" when the request has no user
+func GetAuthSourceFromContext(ctx context.Context) string {
+	source, _ := ctx.Value(SourceContextKey).(string)
+	return source
+}
+
+// GetSessionIDFromContext returns the login session of the request, or "" when
+// the user was not authenticated with a session cookie
+func GetSessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(SessionContextKey).(string)
+	return sessionID
+}
+
+// ClientAddr returns the IP address of the client that sent a request
+func ClientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// SetSessionCookies sets the access and refresh cookies of a session, and a
+// new CSRF token for it. Each cookie expires with its token.
+func (am *Manager) SetSessionCookies(w http.ResponseWriter, r *http.Request, tokens *SessionTokens) {
+	access := &http.Cookie{
+		Name:     AccessCookieName,
+		Value:    tokens.AccessToken,
 		Path:     "/",
-		MaxAge:   365 * 24 * 60 * 60, // 365 days
+		Expires:  tokens.AccessExpiresAt,
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   am.SecureCookies(r),
 		SameSite: http.SameSiteStrictMode,
 	}
-	
-	http.SetCookie(w, cookie)
-}
-
-// ClearAuthCookie clears the authentication cookie
-func ClearAuthCookie(w http.ResponseWriter) {
-	cookie := &http.Cookie{
-		Name:     "auth_token",
-		Value:    "",
+	refresh := &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    tokens.RefreshToken,
 		Path:     "/",
-		MaxAge:   -1,
+		Expires:  tokens.SessionExpiresAt,
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   am.SecureCookies(r),
 		SameSite: http.SameSiteStrictMode,
 	}
 	
-	http.SetCookie(w, cookie)
+	http.SetCookie(w, access)
+	http.SetCookie(w, refresh)
+	am.setCSRFCookie(w, r)
+}
+
+// ClearAuthCookie clears the access, refresh and CSRF cookies
+func (am *Manager) ClearAuthCookie(w http.ResponseWriter, r *http.Request) {
+	for _, name := range []string{AccessCookieName, RefreshCookieName, CSRFCookieName} {
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name != CSRFCookieName,
+			Secure:   am.SecureCookies(r),
+			SameSite: http.SameSiteStrictMode,
+		}
+		
+		http.SetCookie(w, cookie)
+	}
+}
+
+// SecureCookies reports whether cookies set in response to a request are
+// marked Secure: always with secure_cookies, otherwise when the request came
+// over HTTPS
+func (am *Manager) SecureCookies(r *http.Request) bool {
+	return am.config.LiveDashboard().SecureCookies || am.IsHTTPS(r)
+}
+
+// IsHTTPS reports whether a request came over HTTPS, to the dashboard or to one
+// of trusted_proxies that says so with X-Forwarded-Proto
+func (am *Manager) IsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return r.Header.Get("X-Forwarded-Proto") == "https" && am.fromTrustedProxy(r)
 }
@@ -8,19 +8,42 @@ import (
 type User struct {
 	Username    string    `json:"username"`
 	PasswordHash string   `json:"-"` // Never serialize password hash
+	Role        Role      `json:"role"`
+	Grants      []Grant   `json:"grants,omitempty"` // Per-server permissions that replace the role's
 	CreatedAt   time.Time `json:"created_at"`
 	LastLogin   time.Time `json:"last_login"`
-	IsAdmin     bool      `json:"is_admin"`
+	IsAdmin     bool      `json:"is_admin"` // Role is admin; kept for clients that predate roles
+	TwoFactorRequired bool `json:"two_factor_required"` // Set by an admin; the user must enroll a second factor to log in
+	TwoFactorEnabled  bool `json:"two_factor_enabled"`  // The user has TOTP or a passkey; filled in user listings
+	OIDCIssuer  string    `json:"oidc_issuer,omitempty"`  // OIDC provider the user logs in with
+	OIDCSubject string    `json:"oidc_subject,omitempty"` // The user's sub claim at OIDCIssuer
+	
+	scopes      []Permission // Scopes of the API token the request used; empty for other requests
 }
 
 // Claims represents the JWT token claims
 type Claims struct {
 	Username string `json:"username"`
 	IsAdmin  bool   `json:"is_admin"`
+	SessionID string `json:"sid"` // Session the token belongs to
 	IssuedAt int64  `json:"iat"`
 	ExpiresAt int64  `json:"exp"`
 }
 
+// SessionTokens are the tokens of a new or refreshed session
+type SessionTokens struct {
+	SessionID        string    `json:"session_id"`
+	AccessToken      string    `json:"access_token"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	SessionExpiresAt time.Time `json:"session_expires_at"` // Unless refreshed before then
+}
+
+// RefreshRequest represents a request to refresh a session
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"` // Default: the refresh_token cookie
+}
+
 // AuthMethod represents the authentication method being used
 type AuthMethod string
 
@@ -37,6 +60,50 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// SecondFactorRequest represents the second step of a login
+type SecondFactorRequest struct {
+	LoginToken string `json:"login_token"`
+	Code       string `json:"code"` // TOTP or recovery code
+}
+
+// TOTPConfirmRequest represents the first code from a newly enrolled
+// authenticator app
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorChangeRequest represents a request that removes or replaces a second
+// factor, which needs the password again
+type TwoFactorChangeRequest struct {
+	Password string `json:"password"`
+}
+
+// PasskeyRegisterRequest represents the result of registering a passkey
+type PasskeyRegisterRequest struct {
+	Name       string                     `json:"name"`
+	Credential CredentialCreationResponse `json:"credential"`
+}
+
+// PasskeyLoginRequest represents the start of a passkey login. Without a login
+// token the passkey logs in on its own; with one it completes a password login.
+type PasskeyLoginRequest struct {
+	LoginToken string `json:"login_token,omitempty"`
+}
+
+// OIDCLinkRequest represents an admin linking a user to the sub claim of an
+// OIDC provider account, or to an identity of the identity-aware proxy
+type OIDCLinkRequest struct {
+	Subject  string `json:"subject"`            // Empty removes the link
+	Provider string `json:"provider,omitempty"` // "iap" links a proxy identity; default "oidc"
+}
+
+// TwoFactorPolicyRequest represents an admin's change to a user's second
+// factors
+type TwoFactorPolicyRequest struct {
+	Required *bool `json:"required,omitempty"` // Require a second factor to log in
+	Reset    bool  `json:"reset,omitempty"`    // Remove every second factor, for a user who lost them
+}
+
 // PasswordChangeRequest represents a password change request
 type PasswordChangeRequest struct {
 	CurrentPassword string `json:"current_password,omitempty"`
@@ -46,8 +113,24 @@ type PasswordChangeRequest struct {
 
 // UserCreateRequest represents a user creation request
 type UserCreateRequest struct {
-	Username string `json:"username"`
-	IsAdmin  bool   `json:"is_admin"`
+	Username string  `json:"username"`
+	Role     Role    `json:"role,omitempty"`     // Default: the configured default role
+	Grants   []Grant `json:"grants,omitempty"`
+	IsAdmin  bool    `json:"is_admin"`           // Same as role "admin"
+}
+
+// UserAccessRequest represents a change to a user's role and grants
+type UserAccessRequest struct {
+	Role   Role    `json:"role"`
+	Grants []Grant `json:"grants"`
+}
+
+// TokenCreateRequest represents an API token creation request
+type TokenCreateRequest struct {
+	Name      string       `json:"name"`
+	Scopes    []Permission `json:"scopes,omitempty"`     // Default: everything the user may do
+	Duration  string       `json:"duration,omitempty"`   // Go duration such as "720h"
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"` // Alternative to duration; never expires without either
 }
 
 // UserListResponse represents the response for listing users
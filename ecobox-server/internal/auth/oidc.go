@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/secrets"
+)
+
+const (
+	// oidcLoginLifetime is how long a user has to log in at the provider
+	oidcLoginLifetime = 10 * time.Minute
+	// oidcDiscoveryLifetime is how long the provider's metadata is cached
+	oidcDiscoveryLifetime = time.Hour
+)
+
+var (
+	// ErrOIDCState is returned for callbacks without a login in progress
+	ErrOIDCState = errors.New("login expired or was not started here")
+	// ErrOIDCDenied is returned for users the configuration does not let in
+	ErrOIDCDenied = errors.New("not allowed to use this dashboard")
+)
+
+// oidcDiscovery is the part of the provider metadata the dashboard uses
+// (OpenID Connect Discovery 1.0)
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin is an authorization request waiting for its callback
+type oidcLogin struct {
+	nonce       string
+	verifier    string // PKCE code verifier
+	redirectURL string
+	expiresAt   time.Time
+}
+
+// OIDCIdentity is who the provider says logged in
+type OIDCIdentity struct {
+	Issuer   string // Without a trailing slash
+	Subject  string // Identifies the user at Issuer; the username may change or be reused
+	Username string
+	Groups   []string
+}
+
+// OIDCProvider logs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE
+type OIDCProvider struct {
+	config  config.OIDCConfig
+	client  *http.Client
+	secrets *secrets.Vault // Holds client_secret when it is a reference
+
+	discovery    *oidcDiscovery
+	keys         *keySet
+	discoveredAt time.Time
+	logins       map[string]*oidcLogin // By state
+	mu           sync.Mutex
+}
+
+// NewOIDCProvider creates a provider from the [oidc] configuration. The
+// provider's metadata is fetched on first use.
+func NewOIDCProvider(cfg config.OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logins: make(map[string]*oidcLogin),
+	}
+}
+
+// AuthCodeURL starts a login and returns the provider URL to send the browser
+// to and the state that identifies the login in the callback
+func (p *OIDCProvider) AuthCodeURL(redirectURL string) (string, string, error) {
+	discovery, _, err := p.metadata()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	p.mu.Lock()
+	now := time.Now()
+	for s, login := range p.logins {
+		if now.After(login.expiresAt) {
+			delete(p.logins, s)
+		}
+	}
+	p.logins[state] = &oidcLogin{nonce: nonce, verifier: verifier, redirectURL: redirectURL, expiresAt: now.Add(oidcLoginLifetime)}
+	p.mu.Unlock()
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", redirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Exchange finishes the login of state: it redeems the authorization code and
+// verifies the ID token the provider returns
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (*OIDCIdentity, error) {
+	p.mu.Lock()
+	login, exists := p.logins[state]
+	delete(p.logins, state)
+	p.mu.Unlock()
+	if !exists || time.Now().After(login.expiresAt) {
+		return nil, ErrOIDCState
+	}
+
+	discovery, keys, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", login.redirectURL)
+	form.Set("code_verifier", login.verifier)
+	form.Set("client_id", p.config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		clientSecret, err := p.secrets.Resolve(p.config.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token request refused: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	claims, err := verifyJWT(tokens.IDToken, keys)
+	if err != nil {
+		return nil, err
+	}
+	return p.identity(claims, discovery.Issuer, login.nonce)
+}
+
+// identity checks the claims of an ID token and picks out the user
+func (p *OIDCProvider) identity(claims jwtClaims, issuer, nonce string) (*OIDCIdentity, error) {
+	if err := claims.validate(issuer, p.config.ClientID, time.Now()); err != nil {
+		return nil, err
+	}
+	if claims.string("nonce") != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidJWT)
+	}
+	if azp := claims.string("azp"); azp != "" && azp != p.config.ClientID {
+		return nil, fmt.Errorf("%w: issued to %q", ErrInvalidJWT, azp)
+	}
+
+	identity := &OIDCIdentity{
+		Issuer:  strings.TrimSuffix(issuer, "/"),
+		Subject: claims.string("sub"),
+		Groups:  claims.strings(p.config.GroupsClaim),
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidJWT)
+	}
+
+	// Email addresses only identify users once the provider has verified them
+	identity.Username = claims.string(p.config.UsernameClaim)
+	if p.config.UsernameClaim == "email" && !claims.bool("email_verified") {
+		identity.Username = ""
+	}
+	if identity.Username == "" && p.config.UsernameClaim == "preferred_username" && claims.bool("email_verified") {
+		identity.Username = claims.string("email")
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("ID token has no %s claim to use as the username", p.config.UsernameClaim)
+	}
+	return identity, nil
+}
+
+// metadata returns the provider's endpoints and signing keys, fetching them
+// when they are not cached
+func (p *OIDCProvider) metadata() (*oidcDiscovery, *keySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryLifetime {
+		return p.discovery, p.keys, nil
+	}
+
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	resp, err := p.client.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch OIDC provider metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch OIDC provider metadata: %s", resp.Status)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&discovery); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode OIDC provider metadata: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, nil, fmt.Errorf("OIDC provider metadata is for issuer %q, not %q", discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("OIDC provider metadata lacks the authorization, token or JWKS endpoint")
+	}
+
+	if p.keys == nil || p.discovery.JWKSURI != discovery.JWKSURI {
+		p.keys = newKeySet(discovery.JWKSURI, p.client)
+	}
+	p.discovery = &discovery
+	p.discoveredAt = time.Now()
+	return p.discovery, p.keys, nil
+}
+
+// role returns the role the user's groups map to, if any
+func (p *OIDCProvider) role(groups []string) (Role, bool) {
+	for _, mapping := range []struct {
+		role   Role
+		groups []string
+	}{
+		{RoleAdmin, p.config.AdminGroups},
+		{RoleOperator, p.config.OperatorGroups},
+		{RoleViewer, p.config.ViewerGroups},
+	} {
+		for _, group := range groups {
+			if containsString(mapping.groups, group) {
+				return mapping.role, true
+			}
+		}
+	}
+	return "", false
+}
+
+// allowed reports whether the user's groups let them in
+func (p *OIDCProvider) allowed(groups []string) bool {
+	if len(p.config.AllowedGroups) == 0 {
+		return true
+	}
+	if _, mapped := p.role(groups); mapped {
+		return true
+	}
+	for _, group := range groups {
+		if containsString(p.config.AllowedGroups, group) {
+			return true
+		}
+	}
+	return false
+}
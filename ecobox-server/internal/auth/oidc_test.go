@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+)
+
+// testIssuer is an OIDC provider that signs ID tokens with an ES256 key
+type testIssuer struct {
+	server    *httptest.Server
+	key       *ecdsa.PrivateKey
+	claims    map[string]interface{} // Added to the ID token
+	challenge string                 // PKCE challenge of the last authorization request
+	nonce     string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key, claims: map[string]interface{}{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{issuer.jwk("k1")}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":   issuer.server.URL,
+			"aud":   "ecobox",
+			"sub":   "user-1",
+			"nonce": issuer.nonce,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+		for name, value := range issuer.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.sign(t, "k1", claims)})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (ti *testIssuer) jwk(kid string) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"kid": kid,
+		"x":   base64.RawURLEncoding.EncodeToString(ti.key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(ti.key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func (ti *testIssuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, ti.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login runs the authorization request the browser would make and returns
+// the state for the callback
+func (ti *testIssuer) login(t *testing.T, am *Manager) string {
+	r := httptest.NewRequest("GET", "http://dashboard.local/login/oidc", nil)
+	authURL, state, err := am.BeginOIDCLogin(r)
+	if err != nil {
+		t.Fatalf("BeginOIDCLogin failed: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	query := u.Query()
+	if query.Get("state") != state || query.Get("code_challenge_method") != "S256" ||
+		query.Get("redirect_uri") != "http://dashboard.local/login/oidc/callback" {
+		t.Fatalf("Unexpected authorization URL %s", authURL)
+	}
+	ti.challenge = query.Get("code_challenge")
+	ti.nonce = query.Get("nonce")
+	return state
+}
+
+func newOIDCTestManager(t *testing.T, issuer *testIssuer, configure func(*config.OIDCConfig)) *Manager {
+	am := newTestManager(t)
+	am.config.OIDC = config.OIDCConfig{Issuer: issuer.server.URL, ClientID: "ecobox"}
+	configure(&am.config.OIDC)
+	am.config.OIDC.SetDefaults()
+	am.oidc = NewOIDCProvider(am.config.OIDC)
+	return am
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newTestIssuer(t)
+	am := newOIDCTestManager(t, issuer, func(c *config.OIDCConfig) {
+		c.AutoProvision = true
+		c.AdminGroups = []string{"ecobox-admins"}
+		c.ViewerGroups = []string{"family"}
+	})
+
+	// A new user is created with the role of their groups
+	issuer.claims = map[string]interface{}{"preferred_username": "alice", "groups": []string{"family"}}
+	tokens, user, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("FinishOIDCLogin failed: %v", err)
+	}
+	if user.Username != "alice" || user.Role != RoleViewer || tokens.AccessToken == "" {
+		t.Errorf("Unexpected login of %s with role %s", user.Username, user.Role)
+	}
+
+	// Group changes apply on the next login
+	issuer.claims["groups"] = []string{"family", "ecobox-admins"}
+	if _, user, err = am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", ""); err != nil || user.Role != RoleAdmin {
+		t.Errorf("Expected alice to become admin, got %v, %v", user, err)
+	}
+
+	// A state works once, and a bad code is refused
+	state := issuer.login(t, am)
+	if _, _, err := am.FinishOIDCLogin(context.Background(), state, "bad-code", "", ""); err == nil {
+		t.Error("Expected a bad code to be refused")
+	}
+	if _, _, err := am.FinishOIDCLogin(context.Background(), state, "good-code", "", ""); !errors.Is(err, ErrOIDCState) {
+		t.Errorf("Expected a used state to be refused, got %v", err)
+	}
+
+	// ID tokens for another client or login are refused
+	for name, claim := range map[string]interface{}{"aud": "other-client", "nonce": "replayed"} {
+		issuer.claims = map[string]interface{}{"preferred_username": "alice", name: claim}
+		state := issuer.login(t, am)
+		if _, _, err := am.FinishOIDCLogin(context.Background(), state, "good-code", "", ""); !errors.Is(err, ErrInvalidJWT) {
+			t.Errorf("Expected an ID token with a wrong %s to be refused, got %v", name, err)
+		}
+	}
+}
+
+func TestOIDCLoginRestrictions(t *testing.T) {
+	issuer := newTestIssuer(t)
+	am := newOIDCTestManager(t, issuer, func(c *config.OIDCConfig) {
+		c.AllowedGroups = []string{"staff"}
+	})
+
+	// Users outside the allowed groups, and unknown users without
+	// auto_provision, are refused
+	issuer.claims = map[string]interface{}{"preferred_username": "mallory", "groups": []string{"guests"}}
+	if _, _, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", ""); !errors.Is(err, ErrOIDCDenied) {
+		t.Errorf("Expected a user outside the allowed groups to be refused, got %v", err)
+	}
+	issuer.claims = map[string]interface{}{"preferred_username": "bob", "groups": []string{"staff"}}
+	if _, _, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", ""); !errors.Is(err, ErrOIDCDenied) {
+		t.Errorf("Expected an unknown user to be refused, got %v", err)
+	}
+
+	// An unverified email is not a username
+	am.config.OIDC.UsernameClaim = "email"
+	am.oidc = NewOIDCProvider(am.config.OIDC)
+	issuer.claims = map[string]interface{}{"email": "admin", "email_verified": false, "groups": []string{"staff"}}
+	if _, _, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", ""); err == nil {
+		t.Error("Expected an unverified email to be refused")
+	}
+}
+
+func TestIAPAuthentication(t *testing.T) {
+	am := newTestManager(t)
+	am.config.Dashboard.IAPAuth = string(AuthMethodTailscale)
+	am.config.Dashboard.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+
+	request := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Tailscale-User-Login", "carol@example.com")
+		return r
+	}
+	if user := am.checkIAPAuthentication(request("10.1.2.3:4567")); user == nil || user.Username != "carol" {
+		t.Errorf("Expected carol from a trusted proxy, got %v", user)
+	}
+	if user := am.checkIAPAuthentication(request("192.0.2.7:4567")); user != nil {
+		t.Errorf("Expected headers from an untrusted address to be ignored, got %s", user.Username)
+	}
+
+	// Cloudflare Access tokens must be signed by the team and meant for the
+	// application
+	issuer := newTestIssuer(t)
+	am.config.Dashboard.IAPAuth = string(AuthMethodCloudflare)
+	am.config.Dashboard.CloudflareAudience = "aud-tag"
+	am.cloudflareKeys = newKeySet(issuer.server.URL+"/jwks", issuer.server.Client())
+	am.config.Dashboard.CloudflareTeamDomain = "team.cloudflareaccess.com"
+
+	token := func(aud string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Cf-Access-Jwt-Assertion", issuer.sign(t, "k1", map[string]interface{}{
+			"iss":   "https://team.cloudflareaccess.com",
+			"aud":   []string{aud},
+			"email": "dave@example.com",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}))
+		return r
+	}
+	if user := am.checkIAPAuthentication(token("aud-tag")); user == nil || user.Username != "dave" {
+		t.Errorf("Expected dave from a valid Access token, got %v", user)
+	}
+	if user := am.checkIAPAuthentication(token("other-app")); user != nil {
+		t.Errorf("Expected a token for another application to be refused, got %s", user.Username)
+	}
+	forged := request("203.0.113.9:443")
+	forged.Header.Set("Cf-Access-Jwt-Assertion", "eyJhbGciOiJub25lIn0.eyJlbWFpbCI6ImRhdmVAZXhhbXBsZS5jb20ifQ.")
+	if user := am.checkIAPAuthentication(forged); user != nil {
+		t.Errorf("Expected an unsigned token to be refused, got %s", user.Username)
+	}
+}
+
+func TestIAPLinksByIdentity(t *testing.T) {
+	am := newTestManager(t)
+	am.config.Dashboard.IAPAuth = string(AuthMethodTailscale)
+	am.config.Dashboard.TrustedProxies = []string{"127.0.0.1"}
+	if _, err := am.userStore.CreateUser("alice", RoleAdmin, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(identity string) *User {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:4567"
+		r.Header.Set("Tailscale-User-Login", identity)
+		return am.checkIAPAuthentication(r)
+	}
+
+	// An identity of the same name from any domain is not alice
+	if user := login("alice@evil.example"); user != nil {
+		t.Errorf("Expected an identity not linked to alice to be refused, got %s", user.Username)
+	}
+
+	// Once linked, her identity logs in as her, and only hers does
+	if _, err := am.LinkIAP("alice", "alice@example.com"); err != nil {
+		t.Fatalf("LinkIAP failed: %v", err)
+	}
+	if user := login("alice@example.com"); user == nil || user.Username != "alice" || user.Role != RoleAdmin {
+		t.Errorf("Expected the linked identity to log in as alice, got %v", user)
+	}
+	if user := login("alice@evil.example"); user != nil {
+		t.Errorf("Expected another identity of the same name to be refused, got %s", user.Username)
+	}
+
+	// A new identity gets a user created for it and linked to it
+	first := login("carol@example.com")
+	if first == nil || first.Username != "carol" || first.Role != am.defaultRole() {
+		t.Fatalf("Expected carol to be created with the default role, got %v", first)
+	}
+	if first.OIDCIssuer != "iap:tailscale" || first.OIDCSubject != "carol@example.com" {
+		t.Errorf("Expected carol to be linked to her identity, got %s %s", first.OIDCIssuer, first.OIDCSubject)
+	}
+	if again := login("carol@example.com"); again == nil || again.Username != "carol" {
+		t.Errorf("Expected carol's identity to log in as carol again, got %v", again)
+	}
+	if user := login("carol@other.example"); user != nil {
+		t.Errorf("Expected an identity not linked to carol to be refused, got %s", user.Username)
+	}
+}
+
+func TestForwardedProtoFromTrustedProxies(t *testing.T) {
+	am := newTestManager(t)
+	am.config.Dashboard.TrustedProxies = []string{"10.0.0.0/8"}
+
+	request := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "http://dashboard.local/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-Proto", "https")
+		return r
+	}
+
+	// Only a trusted proxy can say the browser used HTTPS
+	trusted := request("10.1.2.3:4567")
+	if !am.IsHTTPS(trusted) || !am.SecureCookies(trusted) || am.requestOrigin(trusted) != "https://dashboard.local" {
+		t.Error("Expected X-Forwarded-Proto from a trusted proxy to count")
+	}
+	untrusted := request("192.0.2.7:4567")
+	if am.IsHTTPS(untrusted) || am.SecureCookies(untrusted) || am.requestOrigin(untrusted) != "http://dashboard.local" {
+		t.Error("Expected X-Forwarded-Proto from an untrusted address to be ignored")
+	}
+
+	// secure_cookies marks cookies Secure regardless
+	am.config.Dashboard.SecureCookies = true
+	if !am.SecureCookies(untrusted) {
+		t.Error("Expected secure_cookies to mark cookies Secure")
+	}
+}
+
+func TestOIDCLoginLinksBySubject(t *testing.T) {
+	issuer := newTestIssuer(t)
+	am := newOIDCTestManager(t, issuer, func(c *config.OIDCConfig) {
+		c.AutoProvision = true
+	})
+	if _, err := am.userStore.CreateUser("bob", RoleOperator, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A provider user who picks the name of an unlinked local user is neither
+	// logged in as them nor given a new account
+	issuer.claims = map[string]interface{}{"sub": "mallory-1", "preferred_username": "bob"}
+	if _, _, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", ""); !errors.Is(err, ErrOIDCDenied) {
+		t.Errorf("Expected a name taken by a local user to be refused, got %v", err)
+	}
+
+	// Once an admin links the account, its subject logs in whatever its name
+	if _, err := am.LinkOIDC("bob", "bob-1"); err != nil {
+		t.Fatalf("LinkOIDC failed: %v", err)
+	}
+	issuer.claims = map[string]interface{}{"sub": "bob-1", "preferred_username": "robert"}
+	_, user, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", "")
+	if err != nil || user.Username != "bob" || user.Role != RoleOperator {
+		t.Errorf("Expected the linked subject to log in as bob, got %v, %v", user, err)
+	}
+
+	// A provisioned user is linked, so another subject cannot take the name over
+	issuer.claims = map[string]interface{}{"sub": "alice-1", "preferred_username": "alice"}
+	if _, _, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", ""); err != nil {
+		t.Fatalf("FinishOIDCLogin failed: %v", err)
+	}
+	issuer.claims = map[string]interface{}{"sub": "mallory-1", "preferred_username": "alice"}
+	if _, _, err := am.FinishOIDCLogin(context.Background(), issuer.login(t, am), "good-code", "", ""); !errors.Is(err, ErrOIDCDenied) {
+		t.Errorf("Expected a name linked to another subject to be refused, got %v", err)
+	}
+	if _, err := am.LinkOIDC("bob", "alice-1"); err == nil {
+		t.Error("Expected a subject to link to one user at most")
+	}
+
+	// Links are saved with the users
+	store := NewUserStore(am.userStore.passwordFile)
+	if err := store.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if linked, exists := store.GetOIDCUser(issuer.server.URL, "alice-1"); !exists || linked.Username != "alice" {
+		t.Errorf("Expected the link of alice to be saved, got %v", linked)
+	}
+}
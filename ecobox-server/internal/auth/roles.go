@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"fmt"
+)
+
+// Role is a user's level of access to every server
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // Sees servers, services and metrics
+	RoleOperator Role = "operator" // Also wakes, suspends, shuts down and restarts servers and holds leases
+	RoleAdmin    Role = "admin"    // Everything, including users, server definitions and settings
+)
+
+// Roles lists the roles from least to most access
+var Roles = []Role{RoleViewer, RoleOperator, RoleAdmin}
+
+// Valid reports whether the role is one of Roles
+func (r Role) Valid() bool {
+	return r.rank() >= 0
+}
+
+// rank orders roles by access; unknown roles rank below every role
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Permission is something a user may do to a server
+type Permission string
+
+const (
+	PermissionView      Permission = "view"      // See the server, its services, metrics and alerts
+	PermissionWake      Permission = "wake"      // Turn the server on
+	PermissionSuspend   Permission = "suspend"   // Suspend or hibernate the server
+	PermissionShutdown  Permission = "shutdown"  // Shut down or stop the server
+	PermissionRestart   Permission = "restart"   // Restart or reset the server
+	PermissionLease     Permission = "lease"     // Acquire, renew and release keep-awake leases
+	PermissionConfigure Permission = "configure" // Change the server's definition, services and groups
+)
+
+// Permissions lists every permission
+var Permissions = []Permission{PermissionView, PermissionWake, PermissionSuspend, PermissionShutdown, PermissionRestart, PermissionLease, PermissionConfigure}
+
+// rolePermissions are what each role may do to servers it has no grant for
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:   {PermissionView},
+	RoleOperator: {PermissionView, PermissionWake, PermissionSuspend, PermissionShutdown, PermissionRestart, PermissionLease},
+	RoleAdmin:    Permissions,
+}
+
+// Grant sets what a user may do to one server, replacing what their role
+// allows for it. Any permission implies view; a grant without permissions hides
+// the server.
+type Grant struct {
+	Server      string       `json:"server"` // Server ID
+	Permissions []Permission `json:"permissions"`
+}
+
+// ValidateAccess checks a role and its grants
+func ValidateAccess(role Role, grants []Grant) error {
+	if !role.Valid() {
+		return fmt.Errorf("invalid role '%s', must be one of: viewer, operator, admin", role)
+	}
+
+	seen := make(map[string]bool)
+	for _, grant := range grants {
+		if grant.Server == "" {
+			return fmt.Errorf("grant without a server")
+		}
+		if seen[grant.Server] {
+			return fmt.Errorf("more than one grant for server %s", grant.Server)
+		}
+		seen[grant.Server] = true
+
+		for _, permission := range grant.Permissions {
+			if !containsPermission(Permissions, permission) {
+				return fmt.Errorf("invalid permission '%s' for server %s, must be one of: view, wake, suspend, shutdown, restart, lease, configure", permission, grant.Server)
+			}
+		}
+	}
+	return nil
+}
+
+// HasRole reports whether the user's role gives at least the access of role. A
+// scoped API token only has the admin role with the admin scope.
+func (u *User) HasRole(role Role) bool {
+	if role == RoleAdmin && !u.scopeAllows(ScopeAdmin) {
+		return false
+	}
+	return u.Role.rank() >= role.rank() && role.Valid()
+}
+
+// Can reports whether the user may do something to a server. Admins may do
+// everything; otherwise a grant for the server decides, and without one the
+// role does. A scoped API token also needs the permission among its scopes.
+func (u *User) Can(serverID string, permission Permission) bool {
+	if !u.scopeAllows(permission) {
+		return false
+	}
+	if u.Role == RoleAdmin {
+		return true
+	}
+	for _, grant := range u.Grants {
+		if grant.Server == serverID {
+			if permission == PermissionView && len(grant.Permissions) > 0 {
+				return true
+			}
+			return containsPermission(grant.Permissions, permission)
+		}
+	}
+	return containsPermission(rolePermissions[u.Role], permission)
+}
+
+// CanAny reports whether the user may do something to at least one server,
+// through their role or a grant
+func (u *User) CanAny(permission Permission) bool {
+	if !u.scopeAllows(permission) {
+		return false
+	}
+	if u.Role == RoleAdmin || containsPermission(rolePermissions[u.Role], permission) {
+		return true
+	}
+	for _, grant := range u.Grants {
+		if containsPermission(grant.Permissions, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeAllows reports whether the scopes of the request's API token include a
+// permission. Requests without a scoped token allow everything; like grants,
+// any scope implies view.
+func (u *User) scopeAllows(permission Permission) bool {
+	if len(u.scopes) == 0 {
+		return true
+	}
+	return permission == PermissionView || containsPermission(u.scopes, permission)
+}
+
+// clone returns a copy of the user that shares no grants with it
+func (u *User) clone() *User {
+	userCopy := *u
+	userCopy.Grants = nil
+	for _, grant := range u.Grants {
+		userCopy.Grants = append(userCopy.Grants, Grant{Server: grant.Server, Permissions: append([]Permission(nil), grant.Permissions...)})
+	}
+	return &userCopy
+}
+
+func containsPermission(permissions []Permission, permission Permission) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
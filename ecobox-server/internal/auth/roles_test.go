@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCan(t *testing.T) {
+	viewer := &User{Username: "alice", Role: RoleViewer, Grants: []Grant{
+		{Server: "build", Permissions: []Permission{PermissionWake, PermissionLease}},
+		{Server: "nas"},
+	}}
+	operator := &User{Username: "bob", Role: RoleOperator}
+	admin := &User{Username: "admin", Role: RoleAdmin}
+
+	cases := []struct {
+		user       *User
+		server     string
+		permission Permission
+		want       bool
+	}{
+		{viewer, "pve", PermissionView, true},
+		{viewer, "pve", PermissionWake, false},
+		{viewer, "build", PermissionWake, true},
+		{viewer, "build", PermissionView, true}, // Implied by the grant
+		{viewer, "build", PermissionShutdown, false},
+		{viewer, "nas", PermissionView, false}, // Hidden by an empty grant
+		{operator, "nas", PermissionRestart, true},
+		{operator, "nas", PermissionConfigure, false},
+		{admin, "nas", PermissionConfigure, true},
+	}
+	for _, c := range cases {
+		if got := c.user.Can(c.server, c.permission); got != c.want {
+			t.Errorf("%s %s on %s: expected %v, got %v", c.user.Username, c.permission, c.server, c.want, got)
+		}
+	}
+
+	configurer := &User{Username: "carol", Role: RoleViewer, Grants: []Grant{{Server: "build", Permissions: []Permission{PermissionConfigure}}}}
+	if !configurer.CanAny(PermissionConfigure) || viewer.CanAny(PermissionConfigure) || operator.CanAny(PermissionConfigure) || !admin.CanAny(PermissionConfigure) {
+		t.Errorf("Unexpected configure permission on any server")
+	}
+
+	if !operator.HasRole(RoleViewer) || operator.HasRole(RoleAdmin) || !admin.HasRole(RoleAdmin) || operator.HasRole("root") {
+		t.Errorf("Unexpected role ordering")
+	}
+}
+
+func TestValidateAccess(t *testing.T) {
+	if err := ValidateAccess(RoleViewer, []Grant{{Server: "nas", Permissions: []Permission{PermissionWake}}}); err != nil {
+		t.Errorf("Expected valid access, got %v", err)
+	}
+
+	invalid := map[string]struct {
+		role   Role
+		grants []Grant
+	}{
+		"role":       {"root", nil},
+		"permission": {RoleViewer, []Grant{{Server: "nas", Permissions: []Permission{"reboot"}}}},
+		"server":     {RoleViewer, []Grant{{Permissions: []Permission{PermissionView}}}},
+		"duplicate":  {RoleViewer, []Grant{{Server: "nas"}, {Server: "nas"}}},
+	}
+	for name, c := range invalid {
+		if err := ValidateAccess(c.role, c.grants); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLegacyPasswordFileMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd.conf")
+	if err := os.WriteFile(path, []byte("# users\nadmin:$2a$10$hash\nalice:$2a$10$other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewUserStore(path)
+	if err := store.Initialize(); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if err := store.SetAccess("alice", RoleViewer, []Grant{{Server: "nas", Permissions: []Permission{PermissionWake}}}); err != nil {
+		t.Fatalf("SetAccess failed: %v", err)
+	}
+
+	// The converted file keeps roles and grants across restarts
+	reloaded := NewUserStore(path)
+	if err := reloaded.Initialize(); err != nil {
+		t.Fatalf("Reloading failed: %v", err)
+	}
+	admin, _ := reloaded.GetUser("admin")
+	alice, _ := reloaded.GetUser("alice")
+	if admin == nil || admin.Role != RoleAdmin || !admin.IsAdmin || admin.PasswordHash != "$2a$10$hash" {
+		t.Errorf("Unexpected admin: %+v", admin)
+	}
+	if alice == nil || alice.Role != RoleViewer || !alice.Can("nas", PermissionWake) || alice.Can("pve", PermissionWake) {
+		t.Errorf("Unexpected alice: %+v", alice)
+	}
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RefreshTokenPrefix starts every refresh token
+	RefreshTokenPrefix = "ebr_"
+	// sessionSeenSaveInterval limits how often last-seen times are written to disk
+	sessionSeenSaveInterval = time.Minute
+)
+
+var (
+	// ErrSessionNotFound is returned for a session that does not exist, was
+	// revoked or expired
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidRefreshToken is returned for a refresh token that is unknown
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// exchanged is presented again. Its session is revoked, since either the
+	// client or someone who stole the token is using an old copy.
+	ErrRefreshTokenReused = errors.New("refresh token was already used; session revoked")
+)
+
+// Session is a login on one device. It lasts until it is revoked or goes
+// unused for the session lifetime; its access tokens are short-lived and
+// renewed with its refresh token.
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // Unless refreshed before then
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Current    bool      `json:"current,omitempty"` // The session of the request listing sessions
+}
+
+// storedSession is how a session is saved in the sessions file
+type storedSession struct {
+	Session
+	RefreshHash  string `json:"refresh_hash"`                    // Hex SHA-256 of the current refresh token
+	PreviousHash string `json:"previous_refresh_hash,omitempty"` // The refresh token it replaced, to detect reuse
+}
+
+// SessionStore keeps sessions in a JSON file so they survive restarts
+type SessionStore struct {
+	path      string
+	lifetime  time.Duration
+	sessions  map[string]*storedSession // By ID
+	lastSaved time.Time
+	mu        sync.Mutex
+}
+
+// NewSessionStore creates a session store saving to path. Sessions expire after
+// lifetime without a refresh.
+func NewSessionStore(path string, lifetime time.Duration) *SessionStore {
+	return &SessionStore{
+		path:     path,
+		lifetime: lifetime,
+		sessions: make(map[string]*storedSession),
+	}
+}
+
+// Initialize loads the saved sessions, dropping expired ones. A missing file
+// means there are none.
+func (ss *SessionStore) Initialize() error {
+	data, err := os.ReadFile(ss.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sessions file: %w", err)
+	}
+
+	var stored []*storedSession
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("failed to parse sessions file %s: %w", ss.path, err)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sessions = make(map[string]*storedSession)
+	now := time.Now()
+	for _, session := range stored {
+		if now.Before(session.ExpiresAt) {
+			ss.sessions[session.ID] = session
+		}
+	}
+	return nil
+}
+
+// Create starts a session and returns it with its first refresh token
+func (ss *SessionStore) Create(username, remoteAddr, userAgent string) (*Session, string, error) {
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &storedSession{
+		Session: Session{
+			ID:         newSessionID(),
+			Username:   username,
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(ss.lifetime),
+			RemoteAddr: remoteAddr,
+			UserAgent:  userAgent,
+		},
+		RefreshHash: hashToken(refresh),
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.sessions[session.ID] = session
+	if err := ss.saveLocked(); err != nil {
+		delete(ss.sessions, session.ID) // Rollback
+		return nil, "", err
+	}
+
+	created := session.Session
+	return &created, refresh, nil
+}
+
+// Touch checks that a session is still active and records that it was used
+func (ss *SessionStore) Touch(id string) (*Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, err := ss.activeLocked(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.LastSeenAt = now
+	if now.Sub(ss.lastSaved) >= sessionSeenSaveInterval {
+		ss.saveLocked() // Failing to record use does not fail the request
+	}
+
+	found := session.Session
+	return &found, nil
+}
+
+// Refresh exchanges a refresh token for a new one, extending its session.
+// Presenting a refresh token that was already exchanged revokes the session.
+func (ss *SessionStore) Refresh(refresh, remoteAddr, userAgent string) (*Session, string, error) {
+	if !strings.HasPrefix(refresh, RefreshTokenPrefix) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	hash := hashToken(refresh)
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for id, session := range ss.sessions {
+		if session.PreviousHash != "" && subtle.ConstantTimeCompare([]byte(session.PreviousHash), []byte(hash)) == 1 {
+			delete(ss.sessions, id)
+			ss.saveLocked()
+			return &session.Session, "", ErrRefreshTokenReused
+		}
+		if subtle.ConstantTimeCompare([]byte(session.RefreshHash), []byte(hash)) != 1 {
+			continue
+		}
+		if _, err := ss.activeLocked(id); err != nil {
+			return nil, "", err
+		}
+
+		next, err := newRefreshToken()
+		if err != nil {
+			return nil, "", err
+		}
+
+		previous := *session
+		now := time.Now()
+		session.PreviousHash = session.RefreshHash
+		session.RefreshHash = hashToken(next)
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(ss.lifetime)
+		session.RemoteAddr = remoteAddr
+		session.UserAgent = userAgent
+		if err := ss.saveLocked(); err != nil {
+			*session = previous // Rollback
+			return nil, "", err
+		}
+
+		refreshed := session.Session
+		return &refreshed, next, nil
+	}
+	return nil, "", ErrInvalidRefreshToken
+}
+
+// List returns a user's active sessions, or every active session when username
+// is empty, most recently seen first
+func (ss *SessionStore) List(username string) []Session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	now := time.Now()
+	sessions := make([]Session, 0, len(ss.sessions))
+	for _, session := range ss.sessions {
+		if (username == "" || session.Username == username) && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session.Session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions
+}
+
+// Get returns an active session by ID
+func (ss *SessionStore) Get(id string) (*Session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, err := ss.activeLocked(id)
+	if err != nil {
+		return nil, false
+	}
+	found := session.Session
+	return &found, true
+}
+
+// Revoke ends a session; its access and refresh tokens stop working at once
+func (ss *SessionStore) Revoke(id string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	session, exists := ss.sessions[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	delete(ss.sessions, id)
+	if err := ss.saveLocked(); err != nil {
+		ss.sessions[id] = session // Rollback
+		return err
+	}
+	return nil
+}
+
+// RevokeUser ends every session of a user except the one with ID except, and
+// returns how many were ended
+func (ss *SessionStore) RevokeUser(username, except string) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	revoked := 0
+	for id, session := range ss.sessions {
+		if session.Username == username && id != except {
+			delete(ss.sessions, id)
+			revoked++
+		}
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+	return revoked, ss.saveLocked()
+}
+
+// activeLocked returns a session that exists and has not expired (assumes lock
+// is already held)
+func (ss *SessionStore) activeLocked(id string) (*storedSession, error) {
+	session, exists := ss.sessions[id]
+	if !exists || !time.Now().Before(session.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// saveLocked writes the active sessions to the sessions file (assumes lock is
+// already held)
+func (ss *SessionStore) saveLocked() error {
+	now := time.Now()
+	stored := make([]*storedSession, 0, len(ss.sessions))
+	for id, session := range ss.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(ss.sessions, id)
+			continue
+		}
+		stored = append(stored, session)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sessions: %w", err)
+	}
+
+	tmpFile := ss.path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write temporary sessions file: %w", err)
+	}
+	if err := os.Rename(tmpFile, ss.path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace sessions file: %w", err)
+	}
+	ss.lastSaved = now
+	return nil
+}
+
+// newRefreshToken returns a random refresh token
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return RefreshTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// newSessionID returns a random session ID
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "ses_" + time.Now().Format("20060102150405.000000000")
+	}
+	return "ses_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func cookieRequest(cookies ...*http.Cookie) *http.Request {
+	req := httptest.NewRequest("GET", "/api/servers", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestSessionRefreshAndRevocation(t *testing.T) {
+	am := newTestManager(t)
+	if err := am.CompleteFirstTimeSetup("Secret123!x"); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, _, err := am.Login("admin", "Secret123!x", "192.0.2.10", "curl/8.0")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	access := &http.Cookie{Name: AccessCookieName, Value: tokens.AccessToken}
+	id, err := am.authenticate(cookieRequest(access))
+	if err != nil || id.sessionID != tokens.SessionID || id.source != AuthSourceSession {
+		t.Fatalf("Expected the session to authenticate, got %+v %v", id, err)
+	}
+
+	// Refresh tokens rotate, and a reused one revokes the session
+	refreshed, _, err := am.RefreshToken(tokens.RefreshToken, "192.0.2.11", "curl/8.1")
+	if err != nil || refreshed.RefreshToken == tokens.RefreshToken || refreshed.SessionID != tokens.SessionID {
+		t.Fatalf("Expected a new refresh token for the same session, got %+v %v", refreshed, err)
+	}
+	if session, _ := am.GetSession(tokens.SessionID); session.RemoteAddr != "192.0.2.11" || session.UserAgent != "curl/8.1" {
+		t.Errorf("Expected the session to record the refreshing client, got %+v", session)
+	}
+	if _, _, err := am.RefreshToken(tokens.RefreshToken, "198.51.100.1", "evil"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Expected reuse to be detected, got %v", err)
+	}
+	if _, err := am.authenticate(cookieRequest(access)); err == nil {
+		t.Errorf("Expected the access token of a revoked session to fail")
+	}
+	if _, _, err := am.RefreshToken(refreshed.RefreshToken, "192.0.2.11", "curl/8.1"); err == nil {
+		t.Errorf("Expected the refresh token of a revoked session to fail")
+	}
+
+	// Revoking everywhere can keep the current session
+	first, _, _ := am.Login("admin", "Secret123!x", "192.0.2.10", "firefox")
+	am.Login("admin", "Secret123!x", "192.0.2.12", "chrome")
+	if revoked, err := am.RevokeUserSessions("admin", first.SessionID); err != nil || revoked != 1 {
+		t.Fatalf("Expected one session to be revoked, got %d (%v)", revoked, err)
+	}
+	if sessions := am.ListSessions("admin"); len(sessions) != 1 || sessions[0].ID != first.SessionID {
+		t.Errorf("Expected only the kept session, got %+v", sessions)
+	}
+
+	// Sessions survive a restart
+	reloaded := NewSessionStore(am.config.Dashboard.SessionsFile, time.Hour)
+	if err := reloaded.Initialize(); err != nil || len(reloaded.List("")) != 1 {
+		t.Errorf("Expected the saved session to load, got %d (%v)", len(reloaded.List("")), err)
+	}
+}
+
+func TestMiddlewareRefreshesExpiredAccessToken(t *testing.T) {
+	am := newTestManager(t)
+	if err := am.CompleteFirstTimeSetup("Secret123!x"); err != nil {
+		t.Fatal(err)
+	}
+	tokens, _, err := am.Login("admin", "Secret123!x", "192.0.2.10", "firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sessionID string
+	handler := NewMiddleware(am).RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID = GetSessionIDFromContext(r.Context())
+	}))
+
+	// The browser dropped the expired access cookie but still has the refresh cookie
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, cookieRequest(&http.Cookie{Name: RefreshCookieName, Value: tokens.RefreshToken}))
+	if rec.Code != http.StatusOK || sessionID != tokens.SessionID {
+		t.Fatalf("Expected the request to pass with a refreshed session, got %d %q", rec.Code, sessionID)
+	}
+
+	set := map[string]string{}
+	for _, cookie := range rec.Result().Cookies() {
+		set[cookie.Name] = cookie.Value
+	}
+	if set[AccessCookieName] == "" || set[RefreshCookieName] == "" || set[RefreshCookieName] == tokens.RefreshToken {
+		t.Errorf("Expected new access and refresh cookies, got %v", set)
+	}
+
+	// Without a valid refresh cookie the API answers 401
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, cookieRequest(&http.Cookie{Name: RefreshCookieName, Value: RefreshTokenPrefix + "nope"}))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rec.Code)
+	}
+}
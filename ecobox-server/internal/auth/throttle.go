@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// loginFreeFailures is how many failed logins are allowed before each further
+// failure makes the next attempt wait
+const loginFreeFailures = 3
+
+// LoginThrottledError is returned for logins of a user or from an address with
+// too many recent failures
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed logins, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// loginFailures are the recent failed logins of a user or address
+type loginFailures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// loginThrottle slows down password and code guessing. Each failure after
+// loginFreeFailures doubles the wait before the next attempt, from one second
+// up to the lockout, which starts at maxFailures. Failures are forgotten after
+// a lockout's length without any.
+type loginThrottle struct {
+	failures map[string]*loginFailures // By "user:" or "ip:" key
+	mu       sync.Mutex
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{failures: make(map[string]*loginFailures)}
+}
+
+// throttleKeys returns the keys failures of a login are counted under
+func throttleKeys(username, remoteAddr string) []string {
+	keys := []string{"user:" + username}
+	if remoteAddr != "" {
+		keys = append(keys, "ip:"+remoteAddr)
+	}
+	return keys
+}
+
+// wait returns how long until the keys may try again, or 0
+func (lt *loginThrottle) wait(keys []string, now time.Time) time.Duration {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	var longest time.Duration
+	for _, key := range keys {
+		if f, ok := lt.failures[key]; ok && f.blockedUntil.After(now) {
+			if wait := f.blockedUntil.Sub(now); wait > longest {
+				longest = wait
+			}
+		}
+	}
+	return longest
+}
+
+// fail records a failed login for the keys
+func (lt *loginThrottle) fail(keys []string, maxFailures int, lockout time.Duration, now time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	for key, f := range lt.failures {
+		if now.Sub(f.last) > lockout && now.After(f.blockedUntil) {
+			delete(lt.failures, key)
+		}
+	}
+
+	for _, key := range keys {
+		f, ok := lt.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			lt.failures[key] = f
+		}
+		f.count++
+		f.last = now
+
+		switch {
+		case f.count >= maxFailures:
+			f.blockedUntil = now.Add(lockout)
+		case f.count > loginFreeFailures:
+			backoff := lockout
+			if shift := f.count - loginFreeFailures - 1; shift < 30 && time.Second<<uint(shift) < lockout {
+				backoff = time.Second << uint(shift)
+			}
+			f.blockedUntil = now.Add(backoff)
+		}
+	}
+}
+
+// reset forgets the failures of the keys after a successful login
+func (lt *loginThrottle) reset(keys ...string) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	for _, key := range keys {
+		delete(lt.failures, key)
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	lt := newLoginThrottle()
+	keys := throttleKeys("admin", "192.0.2.1")
+	now := time.Now()
+
+	// The first failures are free, then each one doubles the wait
+	for i := 0; i < loginFreeFailures; i++ {
+		lt.fail(keys, 10, 15*time.Minute, now)
+	}
+	if wait := lt.wait(keys, now); wait != 0 {
+		t.Errorf("Expected no wait after %d failures, got %s", loginFreeFailures, wait)
+	}
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		lt.fail(keys, 10, 15*time.Minute, now)
+		if wait := lt.wait(keys, now); wait != expected {
+			t.Errorf("Expected a wait of %s, got %s", expected, wait)
+		}
+	}
+
+	// Reaching the maximum locks out, and another address is not affected
+	for i := 0; i < 4; i++ {
+		lt.fail(keys, 10, 15*time.Minute, now)
+	}
+	if wait := lt.wait(keys, now); wait != 15*time.Minute {
+		t.Errorf("Expected a lockout of 15m, got %s", wait)
+	}
+	if wait := lt.wait([]string{"ip:192.0.2.2"}, now); wait != 0 {
+		t.Errorf("Expected another address not to wait, got %s", wait)
+	}
+
+	// Failures are forgotten a lockout after the last one
+	later := now.Add(31 * time.Minute)
+	lt.fail([]string{"ip:192.0.2.2"}, 10, 15*time.Minute, later)
+	if _, ok := lt.failures["user:admin"]; ok {
+		t.Error("Expected old failures to be pruned")
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	am := newTestManager(t)
+	if err := am.CompleteFirstTimeSetup("Secret123!x"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= loginFreeFailures; i++ {
+		if _, _, err := am.Login("admin", "wrong", "192.0.2.1", "test"); err == nil {
+			t.Fatal("Expected a wrong password to fail")
+		}
+	}
+
+	// Even the right password waits, so guesses cannot be checked meanwhile
+	var throttled *LoginThrottledError
+	if _, _, err := am.Login("admin", "Secret123!x", "192.0.2.1", "test"); !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("Expected the login to be throttled, got %v", err)
+	}
+
+	// Once the wait is over a good login clears the user's failures
+	am.throttle.reset("ip:192.0.2.1")
+	am.throttle.failures["user:admin"].blockedUntil = time.Time{}
+	if _, _, err := am.Login("admin", "Secret123!x", "192.0.2.1", "test"); err != nil {
+		t.Fatalf("Expected the login to succeed, got %v", err)
+	}
+	if _, ok := am.throttle.failures["user:admin"]; ok {
+		t.Error("Expected a good login to reset the user's failures")
+	}
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenPrefix starts every API token, so leaked tokens are easy to search for
+	TokenPrefix = "ebx_"
+	// ScopeAdmin lets a scoped token use admin-only endpoints
+	ScopeAdmin Permission = "admin"
+	// tokenUseSaveInterval limits how often last-use times are written to disk
+	tokenUseSaveInterval = time.Minute
+	// maxTokenNameLength bounds the name of a token
+	maxTokenNameLength = 64
+)
+
+var (
+	// ErrTokenNotFound is returned for a token ID that does not exist
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidToken is returned for a token that is unknown, revoked or expired
+	ErrInvalidToken = errors.New("invalid API token")
+)
+
+// APIToken is a personal access token that authenticates as its user. The token
+// itself is only shown when it is created; the store keeps its SHA-256 hash.
+type APIToken struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Username     string       `json:"username"`
+	Hint         string       `json:"hint"`             // Start of the token, to tell tokens apart
+	Scopes       []Permission `json:"scopes,omitempty"` // Empty means everything the user may do
+	CreatedAt    time.Time    `json:"created_at"`
+	ExpiresAt    *time.Time   `json:"expires_at,omitempty"` // Never expires when empty
+	LastUsedAt   *time.Time   `json:"last_used_at,omitempty"`
+	LastUsedFrom string       `json:"last_used_from,omitempty"` // Remote address of the last request
+}
+
+// Expired reports whether the token has expired
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && !time.Now().Before(*t.ExpiresAt)
+}
+
+// storedToken is how a token is saved in the tokens file
+type storedToken struct {
+	APIToken
+	Hash string `json:"hash"` // Hex SHA-256 of the token
+}
+
+// TokenStore keeps API tokens in a JSON file
+type TokenStore struct {
+	path      string
+	tokens    map[string]*storedToken // By ID
+	lastSaved time.Time               // Last time the file was written
+	mu        sync.Mutex
+}
+
+// NewTokenStore creates a token store saving to path
+func NewTokenStore(path string) *TokenStore {
+	return &TokenStore{
+		path:   path,
+		tokens: make(map[string]*storedToken),
+	}
+}
+
+// Initialize loads the saved tokens. A missing file means there are none.
+func (ts *TokenStore) Initialize() error {
+	data, err := os.ReadFile(ts.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tokens file: %w", err)
+	}
+
+	var stored []*storedToken
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("failed to parse tokens file %s: %w", ts.path, err)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.tokens = make(map[string]*storedToken)
+	for _, token := range stored {
+		ts.tokens[token.ID] = token
+	}
+	return nil
+}
+
+// ValidateScopes checks the scopes of a new token
+func ValidateScopes(scopes []Permission) error {
+	for _, scope := range scopes {
+		if scope != ScopeAdmin && !containsPermission(Permissions, scope) {
+			return fmt.Errorf("invalid scope '%s', must be one of: view, wake, suspend, shutdown, restart, lease, configure, admin", scope)
+		}
+	}
+	return nil
+}
+
+// Create saves a new token for a user and returns it with the token itself,
+// which is not stored and cannot be shown again
+func (ts *TokenStore) Create(username, name string, scopes []Permission, expiresAt *time.Time) (*APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTokenNameLength {
+		return nil, "", fmt.Errorf("token name must be 1 to %d characters", maxTokenNameLength)
+	}
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("expires_at must be in the future")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	secret := TokenPrefix + hex.EncodeToString(b)
+
+	token := &storedToken{
+		APIToken: APIToken{
+			ID:        newTokenID(),
+			Name:      name,
+			Username:  username,
+			Hint:      secret[:len(TokenPrefix)+6],
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		},
+		Hash: hashToken(secret),
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.tokens[token.ID] = token
+	if err := ts.saveLocked(); err != nil {
+		delete(ts.tokens, token.ID) // Rollback
+		return nil, "", err
+	}
+
+	created := token.APIToken
+	return &created, secret, nil
+}
+
+// List returns a user's tokens, or every token when username is empty, oldest
+// first
+func (ts *TokenStore) List(username string) []APIToken {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	tokens := make([]APIToken, 0, len(ts.tokens))
+	for _, token := range ts.tokens {
+		if username == "" || token.Username == username {
+			tokens = append(tokens, token.APIToken)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// Get returns a token by ID
+func (ts *TokenStore) Get(id string) (*APIToken, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	token, exists := ts.tokens[id]
+	if !exists {
+		return nil, false
+	}
+	found := token.APIToken
+	return &found, true
+}
+
+// Delete revokes a token
+func (ts *TokenStore) Delete(id string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	token, exists := ts.tokens[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+	}
+	delete(ts.tokens, id)
+	if err := ts.saveLocked(); err != nil {
+		ts.tokens[id] = token // Rollback
+		return err
+	}
+	return nil
+}
+
+// DeleteUserTokens revokes every token of a user
+func (ts *TokenStore) DeleteUserTokens(username string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	removed := false
+	for id, token := range ts.tokens {
+		if token.Username == username {
+			delete(ts.tokens, id)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return ts.saveLocked()
+}
+
+// Authenticate finds the token a request presented and records its use. Use is
+// saved to disk at most once a minute.
+func (ts *TokenStore) Authenticate(secret, remoteAddr string) (*APIToken, error) {
+	if !strings.HasPrefix(secret, TokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	hash := hashToken(secret)
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, token := range ts.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if token.Expired() {
+			return nil, ErrInvalidToken
+		}
+
+		now := time.Now()
+		token.LastUsedAt = &now
+		token.LastUsedFrom = remoteAddr
+		if now.Sub(ts.lastSaved) >= tokenUseSaveInterval {
+			ts.saveLocked() // Failing to record use does not fail the request
+		}
+
+		found := token.APIToken
+		return &found, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+// saveLocked writes every token to the tokens file (assumes lock is already held)
+func (ts *TokenStore) saveLocked() error {
+	stored := make([]*storedToken, 0, len(ts.tokens))
+	for _, token := range ts.tokens {
+		stored = append(stored, token)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tokens: %w", err)
+	}
+
+	tmpFile := ts.path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write temporary tokens file: %w", err)
+	}
+	if err := os.Rename(tmpFile, ts.path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace tokens file: %w", err)
+	}
+	ts.lastSaved = time.Now()
+	return nil
+}
+
+// hashToken returns the hex SHA-256 of a token. Tokens are random, so a fast
+// hash is enough to make a leaked tokens file useless.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTokenID returns a random token ID
+func newTokenID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "tok_" + time.Now().Format("20060102150405.000000000")
+	}
+	return "tok_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Dashboard.PasswordFile = filepath.Join(dir, "passwd.conf")
+	cfg.Dashboard.SessionKeyFile = filepath.Join(dir, "sessionkey.conf")
+	cfg.Dashboard.TokensFile = filepath.Join(dir, "api-tokens.json")
+	cfg.Dashboard.SessionsFile = filepath.Join(dir, "sessions.json")
+	cfg.Dashboard.TwoFactorFile = filepath.Join(dir, "two-factor.json")
+	cfg.SetDefaults()
+
+	am := NewManager(cfg)
+	if err := am.Initialize(); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	return am
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	am := newTestManager(t)
+
+	token, secret, err := am.CreateToken("admin", "backup script", []Permission{PermissionWake}, nil)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, TokenPrefix) || !strings.HasPrefix(secret, token.Hint) {
+		t.Errorf("Unexpected token %s with hint %s", secret, token.Hint)
+	}
+
+	// Only the hash is saved
+	data, _ := os.ReadFile(am.config.Dashboard.TokensFile)
+	if strings.Contains(string(data), secret) || !strings.Contains(string(data), hashToken(secret)) {
+		t.Errorf("Expected the tokens file to hold the hash and not the token: %s", data)
+	}
+
+	req := httptest.NewRequest("GET", "/api/servers", nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	req.RemoteAddr = "192.0.2.10:51000"
+	id, err := am.authenticate(req)
+	if err != nil || id.source != AuthSourceToken || id.user.Username != "admin" {
+		t.Fatalf("Expected admin through a token, got %+v %v", id, err)
+	}
+	user := id.user
+
+	// Scopes narrow what the admin may do
+	if !user.Can("nas", PermissionWake) || !user.Can("nas", PermissionView) || user.Can("nas", PermissionShutdown) || user.HasRole(RoleAdmin) {
+		t.Errorf("Expected the token to be limited to wake and view")
+	}
+
+	used, _ := am.GetToken(token.ID)
+	if used.LastUsedAt == nil || used.LastUsedFrom != "192.0.2.10" {
+		t.Errorf("Expected last use to be recorded, got %+v", used)
+	}
+
+	// Invalid tokens do not fall back to the cookie
+	req.Header.Set("Authorization", "Bearer "+TokenPrefix+"nope")
+	if _, err := am.authenticate(req); err == nil {
+		t.Errorf("Expected an unknown token to fail")
+	}
+
+	if err := am.RevokeToken(token.ID); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	if _, err := am.authenticate(req); err == nil {
+		t.Errorf("Expected a revoked token to fail")
+	}
+}
+
+func TestTokenExpiryAndValidation(t *testing.T) {
+	am := newTestManager(t)
+
+	if _, _, err := am.CreateToken("admin", "", nil, nil); err == nil {
+		t.Errorf("Expected a token without a name to fail")
+	}
+	if _, _, err := am.CreateToken("admin", "ci", []Permission{"reboot"}, nil); err == nil {
+		t.Errorf("Expected an unknown scope to fail")
+	}
+	if _, _, err := am.CreateToken("nobody", "ci", nil, nil); err == nil {
+		t.Errorf("Expected a token for an unknown user to fail")
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	token, secret, err := am.CreateToken("admin", "ci", nil, &expiresAt)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	past := time.Now().Add(-time.Second)
+	am.tokenStore.tokens[token.ID].ExpiresAt = &past
+	if _, err := am.tokenStore.Authenticate(secret, ""); err != ErrInvalidToken {
+		t.Errorf("Expected an expired token to fail, got %v", err)
+	}
+
+	// Tokens are loaded on start and removed with their user
+	if _, err := am.userStore.CreateUser("bob", RoleOperator, nil); err != nil {
+		t.Fatal(err)
+	}
+	am.CreateToken("bob", "laptop", nil, nil)
+	reloaded := NewTokenStore(am.config.Dashboard.TokensFile)
+	if err := reloaded.Initialize(); err != nil || len(reloaded.List("")) != 2 {
+		t.Fatalf("Expected two saved tokens, got %d (%v)", len(reloaded.List("")), err)
+	}
+	if err := am.DeleteUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if tokens := am.ListTokens("bob"); len(tokens) != 0 {
+		t.Errorf("Expected the tokens of a deleted user to be revoked, got %+v", tokens)
+	}
+}
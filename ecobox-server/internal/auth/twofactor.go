@@ -0,0 +1,526 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// pendingLoginLifetime is how long a password login waits for its second
+	// factor
+	pendingLoginLifetime = 5 * time.Minute
+	// pendingLoginAttempts is how many codes a pending login accepts before the
+	// password has to be entered again
+	pendingLoginAttempts = 5
+	// webauthnChallengeLifetime is how long a passkey ceremony may take
+	webauthnChallengeLifetime = 5 * time.Minute
+)
+
+var (
+	// ErrLoginExpired is returned for a pending login that is unknown, expired
+	// or out of attempts
+	ErrLoginExpired = errors.New("login expired; enter your password again")
+	// ErrInvalidSecondFactor is returned for a wrong, reused or expired TOTP or
+	// recovery code
+	ErrInvalidSecondFactor = errors.New("invalid authentication code")
+	// ErrNoEnrollment is returned when confirming TOTP without starting
+	// enrollment first
+	ErrNoEnrollment = errors.New("no TOTP enrollment in progress")
+	// ErrPasskeyNotFound is returned for a passkey that does not exist
+	ErrPasskeyNotFound = errors.New("passkey not found")
+)
+
+// Passkey is a WebAuthn credential registered to a user
+type Passkey struct {
+	ID         string     `json:"id"` // Base64url credential ID
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// storedPasskey is how a passkey is saved in the two-factor file
+type storedPasskey struct {
+	Passkey
+	PublicKey []byte `json:"public_key"` // COSE key
+	SignCount uint32 `json:"sign_count"`
+}
+
+// TwoFactorStatus describes the second factors of a user
+type TwoFactorStatus struct {
+	Required          bool      `json:"required"` // Set by an admin; a factor must be enrolled to log in
+	TOTPEnabled       bool      `json:"totp_enabled"`
+	RecoveryCodesLeft int       `json:"recovery_codes_left"`
+	Passkeys          []Passkey `json:"passkeys"`
+}
+
+// PendingLogin is a password login waiting for a second factor. If the user
+// must enroll one first, Enrollment holds a new TOTP secret the code is checked
+// against.
+type PendingLogin struct {
+	Token      string          `json:"login_token"`
+	Username   string          `json:"username"`
+	Methods    []string        `json:"methods"` // "totp", "recovery" and "passkey"
+	Enrollment *TOTPEnrollment `json:"enrollment,omitempty"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+
+	attempts int
+}
+
+// SecondFactorRequiredError is returned by Login when the password was right
+// but the user must also present a second factor
+type SecondFactorRequiredError struct {
+	Pending *PendingLogin
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "second factor required"
+}
+
+// webauthnChallenge is a passkey ceremony in progress
+type webauthnChallenge struct {
+	username    string // Empty for a passkey login on its own
+	loginToken  string // Pending password login a passkey login completes
+	registering bool
+	rp          RelyingParty
+	expiresAt   time.Time
+}
+
+// storedTwoFactor is how a user's second factors are saved
+type storedTwoFactor struct {
+	Username       string           `json:"username"`
+	UserHandle     string           `json:"user_handle,omitempty"` // Base64url WebAuthn user ID
+	TOTPSecret     string           `json:"totp_secret,omitempty"`
+	TOTPLastStep   int64            `json:"totp_last_step,omitempty"`
+	RecoveryHashes []string         `json:"recovery_code_hashes,omitempty"`
+	Passkeys       []*storedPasskey `json:"passkeys,omitempty"`
+}
+
+// enrolled reports whether the user has a second factor
+func (f *storedTwoFactor) enrolled() bool {
+	return f.TOTPSecret != "" || len(f.Passkeys) > 0
+}
+
+// TwoFactorStore keeps TOTP secrets, recovery codes and passkeys in a JSON file
+type TwoFactorStore struct {
+	path    string
+	users   map[string]*storedTwoFactor
+	pending map[string]*TOTPEnrollment // Unconfirmed TOTP enrollments by username
+	mu      sync.Mutex
+}
+
+// NewTwoFactorStore creates a two-factor store saving to path
+func NewTwoFactorStore(path string) *TwoFactorStore {
+	return &TwoFactorStore{
+		path:    path,
+		users:   make(map[string]*storedTwoFactor),
+		pending: make(map[string]*TOTPEnrollment),
+	}
+}
+
+// Initialize loads the saved second factors. A missing file means there are
+// none.
+func (ts *TwoFactorStore) Initialize() error {
+	data, err := os.ReadFile(ts.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read two-factor file: %w", err)
+	}
+
+	var stored []*storedTwoFactor
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("failed to parse two-factor file %s: %w", ts.path, err)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.users = make(map[string]*storedTwoFactor)
+	for _, factors := range stored {
+		ts.users[factors.Username] = factors
+	}
+	return nil
+}
+
+// Enrolled reports whether a user has TOTP or a passkey
+func (ts *TwoFactorStore) Enrolled(username string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, exists := ts.users[username]
+	return exists && factors.enrolled()
+}
+
+// Status describes a user's second factors
+func (ts *TwoFactorStore) Status(username string) TwoFactorStatus {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	status := TwoFactorStatus{Passkeys: []Passkey{}}
+	if factors, exists := ts.users[username]; exists {
+		status.TOTPEnabled = factors.TOTPSecret != ""
+		status.RecoveryCodesLeft = len(factors.RecoveryHashes)
+		for _, passkey := range factors.Passkeys {
+			status.Passkeys = append(status.Passkeys, passkey.Passkey)
+		}
+	}
+	return status
+}
+
+// BeginTOTP starts TOTP enrollment. Nothing changes until ConfirmTOTP is called
+// with a code from the new secret.
+func (ts *TwoFactorStore) BeginTOTP(username string) (*TOTPEnrollment, error) {
+	enrollment, err := newTOTPEnrollment(username)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.pending[username] = enrollment
+	return enrollment, nil
+}
+
+// ConfirmTOTP enables the pending TOTP secret of a user if code matches it,
+// replacing any previous secret and recovery codes
+func (ts *TwoFactorStore) ConfirmTOTP(username, code string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	enrollment, exists := ts.pending[username]
+	if !exists {
+		return ErrNoEnrollment
+	}
+	step, ok := validateTOTP(enrollment.Secret, code, 0, time.Now())
+	if !ok {
+		return ErrInvalidSecondFactor
+	}
+
+	factors := ts.userLocked(username)
+	previous := *factors
+	factors.TOTPSecret = enrollment.Secret
+	factors.TOTPLastStep = step
+	factors.RecoveryHashes = hashRecoveryCodes(enrollment.RecoveryCodes)
+	if err := ts.saveLocked(); err != nil {
+		*factors = previous // Rollback
+		return err
+	}
+	delete(ts.pending, username)
+	return nil
+}
+
+// VerifyTOTP checks a TOTP code. Each code works once.
+func (ts *TwoFactorStore) VerifyTOTP(username, code string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, exists := ts.users[username]
+	if !exists || factors.TOTPSecret == "" {
+		return ErrInvalidSecondFactor
+	}
+	step, ok := validateTOTP(factors.TOTPSecret, code, factors.TOTPLastStep, time.Now())
+	if !ok {
+		return ErrInvalidSecondFactor
+	}
+
+	factors.TOTPLastStep = step
+	return ts.saveLocked()
+}
+
+// UseRecoveryCode checks a recovery code and uses it up
+func (ts *TwoFactorStore) UseRecoveryCode(username, code string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, exists := ts.users[username]
+	if !exists {
+		return ErrInvalidSecondFactor
+	}
+	hash := hashToken(normalizeRecoveryCode(code))
+	for i, stored := range factors.RecoveryHashes {
+		if stored != hash {
+			continue
+		}
+		previous := factors.RecoveryHashes
+		factors.RecoveryHashes = append(previous[:i:i], previous[i+1:]...)
+		if err := ts.saveLocked(); err != nil {
+			factors.RecoveryHashes = previous // Rollback
+			return err
+		}
+		return nil
+	}
+	return ErrInvalidSecondFactor
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes
+func (ts *TwoFactorStore) RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, exists := ts.users[username]
+	if !exists || !factors.enrolled() {
+		return nil, fmt.Errorf("no second factor is enrolled")
+	}
+	previous := factors.RecoveryHashes
+	factors.RecoveryHashes = hashRecoveryCodes(codes)
+	if err := ts.saveLocked(); err != nil {
+		factors.RecoveryHashes = previous // Rollback
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes a user's TOTP secret
+func (ts *TwoFactorStore) DisableTOTP(username string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, exists := ts.users[username]
+	if !exists || factors.TOTPSecret == "" {
+		return fmt.Errorf("TOTP is not enabled")
+	}
+	previous := *factors
+	factors.TOTPSecret = ""
+	factors.TOTPLastStep = 0
+	ts.dropRecoveryCodesLocked(factors)
+	if err := ts.saveLocked(); err != nil {
+		*factors = previous // Rollback
+		return err
+	}
+	return nil
+}
+
+// UserHandle returns the WebAuthn user ID of a user, creating it on first use
+func (ts *TwoFactorStore) UserHandle(username string) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors := ts.userLocked(username)
+	if factors.UserHandle != "" {
+		return factors.UserHandle, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate user handle: %w", err)
+	}
+	factors.UserHandle = base64.RawURLEncoding.EncodeToString(b)
+	if err := ts.saveLocked(); err != nil {
+		factors.UserHandle = "" // Rollback
+		return "", err
+	}
+	return factors.UserHandle, nil
+}
+
+// PasskeyIDs returns the credential IDs of a user's passkeys
+func (ts *TwoFactorStore) PasskeyIDs(username string) []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var ids []string
+	if factors, exists := ts.users[username]; exists {
+		for _, passkey := range factors.Passkeys {
+			ids = append(ids, passkey.ID)
+		}
+	}
+	return ids
+}
+
+// AddPasskey registers a passkey to a user. Credential IDs are unique across
+// users. A user without recovery codes gets them with their first factor; they
+// are returned only then.
+func (ts *TwoFactorStore) AddPasskey(username, name string, credentialID, publicKey []byte, signCount uint32) (*Passkey, []string, error) {
+	id := base64.RawURLEncoding.EncodeToString(credentialID)
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if owner, _ := ts.findPasskeyLocked(id); owner != nil {
+		return nil, nil, fmt.Errorf("passkey is already registered")
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+
+	factors := ts.userLocked(username)
+	previous := *factors
+	passkey := &storedPasskey{
+		Passkey:   Passkey{ID: id, Name: name, CreatedAt: time.Now()},
+		PublicKey: publicKey,
+		SignCount: signCount,
+	}
+	factors.Passkeys = append(factors.Passkeys[:len(factors.Passkeys):len(factors.Passkeys)], passkey)
+	if len(factors.RecoveryHashes) == 0 {
+		factors.RecoveryHashes = hashRecoveryCodes(codes)
+	} else {
+		codes = nil
+	}
+	if err := ts.saveLocked(); err != nil {
+		*factors = previous // Rollback
+		return nil, nil, err
+	}
+
+	added := passkey.Passkey
+	return &added, codes, nil
+}
+
+// FindPasskey returns the owner, user handle and a copy of a passkey by
+// credential ID
+func (ts *TwoFactorStore) FindPasskey(id string) (string, string, *storedPasskey, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, passkey := ts.findPasskeyLocked(id)
+	if factors == nil {
+		return "", "", nil, false
+	}
+	found := *passkey
+	return factors.Username, factors.UserHandle, &found, true
+}
+
+// RecordPasskeyUse stores the signature counter of a passkey after a login and
+// refuses counters that went backwards, which suggests a cloned authenticator.
+// Authenticators that do not count always report zero.
+func (ts *TwoFactorStore) RecordPasskeyUse(id string, signCount uint32) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, passkey := ts.findPasskeyLocked(id)
+	if factors == nil {
+		return ErrPasskeyNotFound
+	}
+	if (signCount != 0 || passkey.SignCount != 0) && signCount <= passkey.SignCount {
+		return fmt.Errorf("%w: signature counter went backwards", ErrInvalidPasskey)
+	}
+
+	now := time.Now()
+	passkey.SignCount = signCount
+	passkey.LastUsedAt = &now
+	return ts.saveLocked()
+}
+
+// DeletePasskey removes one of a user's passkeys
+func (ts *TwoFactorStore) DeletePasskey(username, id string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	factors, exists := ts.users[username]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrPasskeyNotFound, id)
+	}
+	for i, passkey := range factors.Passkeys {
+		if passkey.ID != id {
+			continue
+		}
+		previous := *factors
+		factors.Passkeys = append(factors.Passkeys[:i:i], factors.Passkeys[i+1:]...)
+		ts.dropRecoveryCodesLocked(factors)
+		if err := ts.saveLocked(); err != nil {
+			*factors = previous // Rollback
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrPasskeyNotFound, id)
+}
+
+// Reset removes every second factor of a user
+func (ts *TwoFactorStore) Reset(username string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	delete(ts.pending, username)
+	factors, exists := ts.users[username]
+	if !exists {
+		return nil
+	}
+	delete(ts.users, username)
+	if err := ts.saveLocked(); err != nil {
+		ts.users[username] = factors // Rollback
+		return err
+	}
+	return nil
+}
+
+// userLocked returns the factors of a user, creating an empty entry (assumes
+// lock is already held)
+func (ts *TwoFactorStore) userLocked(username string) *storedTwoFactor {
+	factors, exists := ts.users[username]
+	if !exists {
+		factors = &storedTwoFactor{Username: username}
+		ts.users[username] = factors
+	}
+	return factors
+}
+
+// findPasskeyLocked finds a passkey by credential ID (assumes lock is already
+// held)
+func (ts *TwoFactorStore) findPasskeyLocked(id string) (*storedTwoFactor, *storedPasskey) {
+	for _, factors := range ts.users {
+		for _, passkey := range factors.Passkeys {
+			if passkey.ID == id {
+				return factors, passkey
+			}
+		}
+	}
+	return nil, nil
+}
+
+// dropRecoveryCodesLocked removes the recovery codes of a user who has no
+// second factor left, since there is nothing for them to stand in for
+// (assumes lock is already held)
+func (ts *TwoFactorStore) dropRecoveryCodesLocked(factors *storedTwoFactor) {
+	if !factors.enrolled() {
+		factors.RecoveryHashes = nil
+	}
+}
+
+// saveLocked writes the second factors to the two-factor file (assumes lock is
+// already held)
+func (ts *TwoFactorStore) saveLocked() error {
+	stored := make([]*storedTwoFactor, 0, len(ts.users))
+	for _, factors := range ts.users {
+		stored = append(stored, factors)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Username < stored[j].Username })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode second factors: %w", err)
+	}
+
+	tmpFile := ts.path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write temporary two-factor file: %w", err)
+	}
+	if err := os.Rename(tmpFile, ts.path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace two-factor file: %w", err)
+	}
+	return nil
+}
+
+// hashRecoveryCodes hashes recovery codes for storage
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+	return hashes
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func currentTOTP(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/totpPeriod)
+}
+
+func TestTOTPCodes(t *testing.T) {
+	// RFC 6238 SHA-1 test vectors, truncated to six digits
+	key := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		if got := totpCode(key, unix/totpPeriod); got != want {
+			t.Errorf("Code at %d: expected %s, got %s", unix, want, got)
+		}
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+	step, ok := validateTOTP(secret, "081804", 0, now)
+	if !ok {
+		t.Fatal("Expected the current code to be accepted")
+	}
+	if _, ok := validateTOTP(secret, "081804", step, now); ok {
+		t.Errorf("Expected a used code to be refused")
+	}
+	if _, ok := validateTOTP(secret, "081804", 0, now.Add(5*time.Minute)); ok {
+		t.Errorf("Expected an old code to be refused")
+	}
+}
+
+func TestSecondFactorLogin(t *testing.T) {
+	am := newTestManager(t)
+	if err := am.CompleteFirstTimeSetup("Secret123!x"); err != nil {
+		t.Fatal(err)
+	}
+
+	enrollment, err := am.BeginTOTPEnrollment("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || len(enrollment.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Unexpected enrollment %+v", enrollment)
+	}
+	first := currentTOTP(t, enrollment.Secret)
+	wrong := "000000"
+	if first == wrong {
+		wrong = "111111"
+	}
+	if err := am.ConfirmTOTPEnrollment("admin", wrong); !errors.Is(err, ErrInvalidSecondFactor) {
+		t.Errorf("Expected a wrong code to be refused, got %v", err)
+	}
+	if err := am.ConfirmTOTPEnrollment("admin", first); err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment failed: %v", err)
+	}
+
+	// The password alone no longer starts a session
+	tokens, _, err := am.Login("admin", "Secret123!x", "192.0.2.10", "firefox")
+	var required *SecondFactorRequiredError
+	if !errors.As(err, &required) || tokens != nil {
+		t.Fatalf("Expected a second factor to be required, got %v", err)
+	}
+	pending := required.Pending
+	if pending.Enrollment != nil || strings.Join(pending.Methods, ",") != "totp,recovery" {
+		t.Errorf("Unexpected pending login %+v", pending)
+	}
+
+	// The code that enabled TOTP cannot be replayed
+	if _, _, err := am.CompleteLogin(pending.Token, first, "192.0.2.10", "firefox"); !errors.Is(err, ErrInvalidSecondFactor) {
+		t.Errorf("Expected a used TOTP code to be refused, got %v", err)
+	}
+
+	// Recovery codes work once, typed loosely
+	code := strings.ToUpper(enrollment.RecoveryCodes[0])
+	tokens, user, err := am.CompleteLogin(pending.Token, code, "192.0.2.10", "firefox")
+	if err != nil || tokens == nil || user.Username != "admin" {
+		t.Fatalf("Expected the recovery code to log in, got %v", err)
+	}
+	if _, _, err := am.CompleteLogin(pending.Token, enrollment.RecoveryCodes[1], "", ""); !errors.Is(err, ErrLoginExpired) {
+		t.Errorf("Expected a completed login to be gone, got %v", err)
+	}
+	_, _, err = am.Login("admin", "Secret123!x", "", "")
+	errors.As(err, &required)
+	if _, _, err := am.CompleteLogin(required.Pending.Token, code, "", ""); !errors.Is(err, ErrInvalidSecondFactor) {
+		t.Errorf("Expected a used recovery code to be refused, got %v", err)
+	}
+
+	// A pending login gives up after too many wrong codes, even when they are
+	// slow enough not to be throttled
+	for i := 0; i < pendingLoginAttempts; i++ {
+		am.throttle.reset("user:admin")
+		am.CompleteLogin(required.Pending.Token, "000000", "", "")
+	}
+	if _, _, err := am.CompleteLogin(required.Pending.Token, enrollment.RecoveryCodes[2], "", ""); !errors.Is(err, ErrLoginExpired) {
+		t.Errorf("Expected the login to be dropped after %d attempts, got %v", pendingLoginAttempts, err)
+	}
+}
+
+func TestRequiredSecondFactorEnrollment(t *testing.T) {
+	am := newTestManager(t)
+	if _, err := am.userStore.CreateUser("bob", RoleOperator, nil); err != nil {
+		t.Fatal(err)
+	}
+	am.userStore.SetPassword("bob", "Secret123!x")
+	first, _, err := am.Login("bob", "Secret123!x", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requiring a second factor signs bob out, and the next login enrolls one
+	if _, err := am.SetTwoFactorRequired("bob", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := am.GetSession(first.SessionID); exists {
+		t.Errorf("Expected bob's sessions to be revoked")
+	}
+
+	_, _, err = am.Login("bob", "Secret123!x", "", "")
+	var required *SecondFactorRequiredError
+	if !errors.As(err, &required) || required.Pending.Enrollment == nil {
+		t.Fatalf("Expected TOTP enrollment at login, got %v", err)
+	}
+	code := currentTOTP(t, required.Pending.Enrollment.Secret)
+	if _, _, err := am.CompleteLogin(required.Pending.Token, code, "", ""); err != nil {
+		t.Fatalf("Expected the enrollment code to log in, got %v", err)
+	}
+
+	status := am.TwoFactorStatus("bob")
+	if !status.Required || !status.TOTPEnabled || status.RecoveryCodesLeft != recoveryCodeCount {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if err := am.DisableTOTP("bob"); err == nil {
+		t.Errorf("Expected the only required factor to stay")
+	}
+	if user, _ := am.GetUser("bob"); !user.TwoFactorEnabled || !user.TwoFactorRequired {
+		t.Errorf("Expected the user to show two-factor state, got %+v", user)
+	}
+
+	// Factors are saved, and an admin reset removes them
+	reloaded := NewTwoFactorStore(am.config.Dashboard.TwoFactorFile)
+	if err := reloaded.Initialize(); err != nil || !reloaded.Enrolled("bob") {
+		t.Errorf("Expected saved factors to load (%v)", err)
+	}
+	if err := am.ResetTwoFactor("bob"); err != nil || am.twoFactor.Enrolled("bob") {
+		t.Errorf("Expected the reset to remove bob's factors (%v)", err)
+	}
+}
+
+// testAuthenticator is a software passkey authenticator
+type testAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+}
+
+func (a *testAuthenticator) clientData(typ, challenge, origin string) string {
+	data, _ := json.Marshal(clientData{Type: typ, Challenge: challenge, Origin: origin})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *testAuthenticator) authData(rpID string, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *testAuthenticator) create(options *CredentialCreationOptions, origin string) *CredentialCreationResponse {
+	coseKey := testCBOR(map[interface{}]interface{}{
+		int64(1): int64(2), int64(3): int64(coseAlgES256), int64(-1): int64(1),
+		int64(-2): a.key.X.FillBytes(make([]byte, 32)), int64(-3): a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	attested := append(make([]byte, 16), byte(len(a.id)>>8), byte(len(a.id)))
+	attested = append(append(attested, a.id...), coseKey...)
+
+	response := &CredentialCreationResponse{ID: base64.RawURLEncoding.EncodeToString(a.id)}
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", options.Challenge, origin)
+	response.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(testCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(options.RP.ID, authFlagUserPresent|authFlagUserVerified|authFlagAttested, attested),
+	}))
+	return response
+}
+
+func (a *testAuthenticator) get(options *CredentialRequestOptions, origin string, flags byte) *CredentialAssertionResponse {
+	a.signCount++
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge, origin)
+	authData := a.authData(options.RPID, flags, nil)
+
+	raw, _ := base64.RawURLEncoding.DecodeString(clientDataJSON)
+	clientDataHash := sha256.Sum256(raw)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, _ := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+
+	response := &CredentialAssertionResponse{ID: base64.RawURLEncoding.EncodeToString(a.id)}
+	response.Response.ClientDataJSON = clientDataJSON
+	response.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	response.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	return response
+}
+
+// testCBOR encodes the CBOR subset authenticators produce
+func testCBOR(value interface{}) []byte {
+	header := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+	}
+
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		encoded := map[string][]byte{}
+		for key, item := range v {
+			k := string(testCBOR(key))
+			keys = append(keys, k)
+			encoded[k] = testCBOR(item)
+		}
+		sort.Strings(keys)
+		out := header(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(append(out, k...), encoded[k]...)
+		}
+		return out
+	}
+	panic("unsupported CBOR value")
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	am := newTestManager(t)
+	if err := am.CompleteFirstTimeSetup("Secret123!x"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "http://ecobox.test:8080/api/auth/2fa/passkeys/begin", nil)
+	rp := am.RelyingParty(req)
+	if rp.ID != "ecobox.test" || rp.Origins[0] != "http://ecobox.test:8080" {
+		t.Fatalf("Unexpected relying party %+v", rp)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	authenticator := &testAuthenticator{key: key, id: []byte("credential-1")}
+
+	options, err := am.BeginPasskeyRegistration("admin", rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkey, codes, err := am.FinishPasskeyRegistration("admin", "laptop", authenticator.create(options, rp.Origins[0]))
+	if err != nil {
+		t.Fatalf("FinishPasskeyRegistration failed: %v", err)
+	}
+	if passkey.Name != "laptop" || len(codes) != recoveryCodeCount {
+		t.Errorf("Expected a named passkey and first recovery codes, got %+v %d", passkey, len(codes))
+	}
+
+	// A passkey logs in on its own when the authenticator verified the user
+	request, err := am.BeginPasskeyLogin("", rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := am.FinishPasskeyLogin(authenticator.get(request, "https://evil.test", authFlagUserPresent|authFlagUserVerified), "", ""); err == nil {
+		t.Errorf("Expected another origin to be refused")
+	}
+	request, _ = am.BeginPasskeyLogin("", rp)
+	if _, _, err := am.FinishPasskeyLogin(authenticator.get(request, rp.Origins[0], authFlagUserPresent), "", ""); err == nil {
+		t.Errorf("Expected a passkey login without user verification to be refused")
+	}
+	request, _ = am.BeginPasskeyLogin("", rp)
+	response := authenticator.get(request, rp.Origins[0], authFlagUserPresent|authFlagUserVerified)
+	tokens, user, err := am.FinishPasskeyLogin(response, "", "")
+	if err != nil || tokens == nil || user.Username != "admin" {
+		t.Fatalf("Expected the passkey to log in, got %v", err)
+	}
+	if _, _, err := am.FinishPasskeyLogin(response, "", ""); err == nil {
+		t.Errorf("Expected a replayed assertion to be refused")
+	}
+
+	// As a second factor, presence is enough
+	_, _, err = am.Login("admin", "Secret123!x", "", "")
+	var required *SecondFactorRequiredError
+	if !errors.As(err, &required) || strings.Join(required.Pending.Methods, ",") != "passkey,recovery" {
+		t.Fatalf("Expected a passkey to be asked for, got %v", err)
+	}
+	request, err = am.BeginPasskeyLogin(required.Pending.Token, rp)
+	if err != nil || len(request.AllowCredentials) != 1 {
+		t.Fatalf("Expected the user's passkey to be allowed, got %+v %v", request, err)
+	}
+	if _, _, err := am.FinishPasskeyLogin(authenticator.get(request, rp.Origins[0], authFlagUserPresent), "", ""); err != nil {
+		t.Fatalf("Expected the passkey to complete the login, got %v", err)
+	}
+
+	if err := am.DeletePasskey("admin", passkey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status := am.TwoFactorStatus("admin"); len(status.Passkeys) != 0 || status.RecoveryCodesLeft != 0 {
+		t.Errorf("Expected no factors or recovery codes left, got %+v", status)
+	}
+}
@@ -2,13 +2,29 @@ package auth
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// storedUser is a user as saved in the password file
+type storedUser struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	Role         Role      `json:"role"`
+	Grants       []Grant   `json:"grants,omitempty"`
+	TwoFactorRequired bool `json:"two_factor_required,omitempty"`
+	OIDCIssuer   string    `json:"oidc_issuer,omitempty"`
+	OIDCSubject  string    `json:"oidc_subject,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastLogin    time.Time `json:"last_login"`
+}
+
 // UserStore manages user authentication data
 type UserStore struct {
 	passwordFile string
@@ -44,28 +60,25 @@ func (us *UserStore) Initialize() error {
 
 // createInitialPasswordFile creates the initial password file with admin user
 func (us *UserStore) createInitialPasswordFile() error {
-	file, err := os.Create(us.passwordFile)
-	if err != nil {
-		return fmt.Errorf("failed to create password file: %w", err)
-	}
-	defer file.Close()
+	us.mu.Lock()
+	defer us.mu.Unlock()
 	
 	// Write initial admin user with empty password
-	_, err = file.WriteString("admin:\n")
-	if err != nil {
-		return fmt.Errorf("failed to write initial admin user: %w", err)
+	us.users = map[string]*User{
+		"admin": {Username: "admin", Role: RoleAdmin, IsAdmin: true, CreatedAt: time.Now()},
 	}
 	
-	return nil
+	return us.saveUsersLocked()
 }
 
-// loadUsers loads users from the password file
+// loadUsers loads users from the password file. Files in the original
+// "username:hash" format are converted, with the admin user as admin and every
+// other user as operator, which is what they could do before roles.
 func (us *UserStore) loadUsers() error {
-	file, err := os.Open(us.passwordFile)
+	data, err := os.ReadFile(us.passwordFile)
 	if err != nil {
 		return fmt.Errorf("failed to open password file: %w", err)
 	}
-	defer file.Close()
 	
 	us.mu.Lock()
 	defer us.mu.Unlock()
@@ -73,7 +86,46 @@ func (us *UserStore) loadUsers() error {
 	// Clear existing users
 	us.users = make(map[string]*User)
 	
-	scanner := bufio.NewScanner(file)
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := us.loadLegacyUsersLocked(data); err != nil {
+			return err
+		}
+		return us.saveUsersLocked()
+	}
+	
+	var stored []storedUser
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("invalid password file: %w", err)
+	}
+	
+	for _, s := range stored {
+		if s.Username == "" {
+			return fmt.Errorf("user without a username in password file")
+		}
+		if err := ValidateAccess(s.Role, s.Grants); err != nil {
+			return fmt.Errorf("user %s: %w", s.Username, err)
+		}
+		us.users[s.Username] = &User{
+			Username:     s.Username,
+			PasswordHash: s.PasswordHash,
+			Role:         s.Role,
+			Grants:       s.Grants,
+			CreatedAt:    s.CreatedAt,
+			LastLogin:    s.LastLogin,
+			IsAdmin:      s.Role == RoleAdmin,
+			TwoFactorRequired: s.TwoFactorRequired,
+			OIDCIssuer:   s.OIDCIssuer,
+			OIDCSubject:  s.OIDCSubject,
+		}
+	}
+	
+	return nil
+}
+
+// loadLegacyUsersLocked reads a password file of "username:hash" lines
+// (assumes lock is already held)
+func (us *UserStore) loadLegacyUsersLocked(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	lineNum := 0
 	
 	for scanner.Scan() {
@@ -97,11 +149,17 @@ func (us *UserStore) loadUsers() error {
 			return fmt.Errorf("empty username at line %d", lineNum)
 		}
 		
+		role := RoleOperator
+		if username == "admin" {
+			role = RoleAdmin
+		}
+		
 		user := &User{
 			Username:     username,
 			PasswordHash: passwordHash,
-			CreatedAt:    time.Now(), // We don't store creation time in file, use current time
-			IsAdmin:      username == "admin", // Admin user is always admin
+			Role:         role,
+			CreatedAt:    time.Now(), // The original format has no creation time
+			IsAdmin:      role == RoleAdmin,
 		}
 		
 		us.users[username] = user
@@ -123,21 +181,39 @@ func (us *UserStore) saveUsers() error {
 
 // saveUsersLocked saves users to the password file (assumes lock is already held)
 func (us *UserStore) saveUsersLocked() error {
+	stored := make([]storedUser, 0, len(us.users))
+	for _, user := range us.users {
+		stored = append(stored, storedUser{
+			Username:     user.Username,
+			PasswordHash: user.PasswordHash,
+			Role:         user.Role,
+			Grants:       user.Grants,
+			TwoFactorRequired: user.TwoFactorRequired,
+			OIDCIssuer:   user.OIDCIssuer,
+			OIDCSubject:  user.OIDCSubject,
+			CreatedAt:    user.CreatedAt,
+			LastLogin:    user.LastLogin,
+		})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Username < stored[j].Username })
+	
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode user data: %w", err)
+	}
+	
 	// Create temporary file
 	tmpFile := us.passwordFile + ".tmp"
-	file, err := os.Create(tmpFile)
+	file, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to create temporary password file: %w", err)
 	}
 	
 	// Write users to temporary file
-	for _, user := range us.users {
-		line := fmt.Sprintf("%s:%s\n", user.Username, user.PasswordHash)
-		if _, err := file.WriteString(line); err != nil {
-			file.Close()
-			os.Remove(tmpFile)
-			return fmt.Errorf("failed to write user data: %w", err)
-		}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to write user data: %w", err)
 	}
 	
 	if err := file.Close(); err != nil {
@@ -165,8 +241,31 @@ func (us *UserStore) GetUser(username string) (*User, bool) {
 	}
 	
 	// Return a copy to prevent external modifications
-	userCopy := *user
-	return &userCopy, true
+	return user.clone(), true
+}
+
+// GetOIDCUser retrieves the user linked to a subject of an OIDC issuer
+func (us *UserStore) GetOIDCUser(issuer, subject string) (*User, bool) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+	
+	user := us.oidcUserLocked(issuer, subject)
+	if user == nil {
+		return nil, false
+	}
+	return user.clone(), true
+}
+
+func (us *UserStore) oidcUserLocked(issuer, subject string) *User {
+	if subject == "" {
+		return nil
+	}
+	for _, user := range us.users {
+		if user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			return user
+		}
+	}
+	return nil
 }
 
 // GetAllUsers returns all users (without password hashes)
@@ -176,10 +275,11 @@ func (us *UserStore) GetAllUsers() []*User {
 	
 	users := make([]*User, 0, len(us.users))
 	for _, user := range us.users {
-		userCopy := *user
+		userCopy := user.clone()
 		userCopy.PasswordHash = "" // Never expose password hash
-		users = append(users, &userCopy)
+		users = append(users, userCopy)
 	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
 	
 	return users
 }
@@ -233,11 +333,24 @@ func (us *UserStore) SetPassword(username, password string) error {
 	return us.saveUsersLocked()
 }
 
-// CreateUser creates a new user
-func (us *UserStore) CreateUser(username string, isAdmin bool) (string, error) {
+// CreateUser creates a new user with a role and per-server grants
+func (us *UserStore) CreateUser(username string, role Role, grants []Grant) (string, error) {
+	return us.createUser(username, role, grants, "", "")
+}
+
+// CreateOIDCUser creates a new user linked to a subject of an OIDC issuer
+func (us *UserStore) CreateOIDCUser(username string, role Role, issuer, subject string) error {
+	_, err := us.createUser(username, role, nil, issuer, subject)
+	return err
+}
+
+func (us *UserStore) createUser(username string, role Role, grants []Grant, issuer, subject string) (string, error) {
 	if username == "" {
 		return "", fmt.Errorf("username cannot be empty")
 	}
+	if err := ValidateAccess(role, grants); err != nil {
+		return "", err
+	}
 	
 	us.mu.Lock()
 	defer us.mu.Unlock()
@@ -246,6 +359,9 @@ func (us *UserStore) CreateUser(username string, isAdmin bool) (string, error) {
 	if _, exists := us.users[username]; exists {
 		return "", fmt.Errorf("user already exists")
 	}
+	if us.oidcUserLocked(issuer, subject) != nil {
+		return "", fmt.Errorf("OIDC subject is already linked to a user")
+	}
 	
 	// Generate a strong initial password
 	initialPassword, err := GenerateStrongPassword(16)
@@ -263,8 +379,12 @@ func (us *UserStore) CreateUser(username string, isAdmin bool) (string, error) {
 	user := &User{
 		Username:     username,
 		PasswordHash: hashedPassword,
+		Role:         role,
+		Grants:       grants,
 		CreatedAt:    time.Now(),
-		IsAdmin:      isAdmin,
+		IsAdmin:      role == RoleAdmin,
+		OIDCIssuer:   issuer,
+		OIDCSubject:  subject,
 	}
 	
 	us.users[username] = user
@@ -349,30 +469,99 @@ func (us *UserStore) CompleteFirstTimeSetup(password string) error {
 	return us.saveUsersLocked()
 }
 
-// UpdateUser updates user information
+// UpdateUser updates a user's last login time. The password, creation time,
+// role and grants are changed through their own methods.
 func (us *UserStore) UpdateUser(user *User) error {
 	us.mu.Lock()
 	defer us.mu.Unlock()
 	
-	if _, exists := us.users[user.Username]; !exists {
+	existing, exists := us.users[user.Username]
+	if !exists {
 		return fmt.Errorf("user does not exist: %s", user.Username)
 	}
 	
-	us.users[user.Username] = user
+	existing.LastLogin = user.LastLogin
+	return us.saveUsersLocked()
+}
+
+// SetAccess replaces a user's role and grants
+func (us *UserStore) SetAccess(username string, role Role, grants []Grant) error {
+	if err := ValidateAccess(role, grants); err != nil {
+		return err
+	}
+	
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	
+	user, exists := us.users[username]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
 	
-	// For non-password updates, we can skip saving to disk
-	// The password hash and created time are the only persistent fields
+	previous := *user
+	user.Role = role
+	user.Grants = grants
+	user.IsAdmin = role == RoleAdmin
+	if err := us.saveUsersLocked(); err != nil {
+		*user = previous // Rollback
+		return err
+	}
+	return nil
+}
+
+// SetTwoFactorRequired sets whether a user must present a second factor to log
+// in
+func (us *UserStore) SetTwoFactorRequired(username string, required bool) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	
+	user, exists := us.users[username]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	
+	user.TwoFactorRequired = required
+	if err := us.saveUsersLocked(); err != nil {
+		user.TwoFactorRequired = !required // Rollback
+		return err
+	}
+	return nil
+}
+
+// SetOIDCLink links a user to a subject of an OIDC issuer, or removes the link
+// when subject is empty. A subject links to one user at most.
+func (us *UserStore) SetOIDCLink(username, issuer, subject string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	
+	user, exists := us.users[username]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	if linked := us.oidcUserLocked(issuer, subject); linked != nil && linked != user {
+		return fmt.Errorf("OIDC subject is already linked to user %s", linked.Username)
+	}
+	if subject == "" {
+		issuer = ""
+	}
+	
+	previous := *user
+	user.OIDCIssuer = issuer
+	user.OIDCSubject = subject
+	if err := us.saveUsersLocked(); err != nil {
+		*user = previous // Rollback
+		return err
+	}
 	return nil
 }
 
-// updateLastLogin updates the last login time for a user
+// updateLastLogin updates and saves the last login time for a user
 func (us *UserStore) updateLastLogin(username string) {
 	us.mu.Lock()
 	defer us.mu.Unlock()
 	
 	if user, exists := us.users[username]; exists {
 		user.LastLogin = time.Now()
-		// Note: We don't save to file for performance reasons
-		// Last login time is not persisted across restarts
+		us.saveUsersLocked()
 	}
 }
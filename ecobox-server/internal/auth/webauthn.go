@@ -0,0 +1,392 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithms accepted for passkeys
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	authFlagUserPresent  = 0x01
+	authFlagUserVerified = 0x04
+	authFlagAttested     = 0x40
+)
+
+// ErrInvalidPasskey is returned for a WebAuthn response that does not verify
+var ErrInvalidPasskey = errors.New("invalid passkey response")
+
+// RelyingParty identifies the dashboard to authenticators. ID is the domain
+// passkeys are bound to; Origins are the page origins allowed to use them.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// CredentialDescriptor names a credential in WebAuthn options
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"` // Base64url
+}
+
+// CredentialCreationOptions are the publicKey options for
+// navigator.credentials.create. Binary values are base64url strings.
+type CredentialCreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+}
+
+// CredentialRequestOptions are the publicKey options for
+// navigator.credentials.get. Binary values are base64url strings.
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	LoginToken       string                 `json:"login_token,omitempty"` // Pending password login the passkey completes
+}
+
+// CredentialCreationResponse is the result of navigator.credentials.create as
+// sent by the dashboard, with binary values as base64url strings
+type CredentialCreationResponse struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// CredentialAssertionResponse is the result of navigator.credentials.get as sent
+// by the dashboard, with binary values as base64url strings
+type CredentialAssertionResponse struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// clientData is the part of the collected client data that is checked
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is parsed authenticator data
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte // Only when attested
+	publicKey    []byte // COSE key, only when attested
+}
+
+// newCreationOptions returns the options to register a passkey
+func newCreationOptions(rp RelyingParty, challenge, userHandle, username string, exclude []string) *CredentialCreationOptions {
+	options := &CredentialCreationOptions{
+		Challenge:          challenge,
+		Timeout:            int(webauthnChallengeLifetime.Milliseconds()),
+		Attestation:        "none",
+		ExcludeCredentials: credentialDescriptors(exclude),
+	}
+	options.RP.ID = rp.ID
+	options.RP.Name = rp.Name
+	options.User.ID = userHandle
+	options.User.Name = username
+	options.User.DisplayName = username
+	for _, alg := range []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = "preferred"
+	return options
+}
+
+// credentialDescriptors describes credentials by their base64url IDs
+func credentialDescriptors(ids []string) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		descriptors = append(descriptors, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return descriptors
+}
+
+// parseClientData decodes client data and checks its type and origin. Its
+// challenge was already matched when the ceremony was looked up with
+// clientDataChallenge.
+func parseClientData(encoded, wantType string, origins []string) ([]byte, *clientData, error) {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: client data: %v", ErrInvalidPasskey, err)
+	}
+
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, nil, fmt.Errorf("%w: client data: %v", ErrInvalidPasskey, err)
+	}
+	if data.Type != wantType {
+		return nil, nil, fmt.Errorf("%w: unexpected type %q", ErrInvalidPasskey, data.Type)
+	}
+	if !containsString(origins, data.Origin) {
+		return nil, nil, fmt.Errorf("%w: origin %s is not allowed", ErrInvalidPasskey, data.Origin)
+	}
+	return raw, &data, nil
+}
+
+// clientDataChallenge returns the challenge in encoded client data without
+// checking anything else, to find the ceremony it belongs to
+func clientDataChallenge(encoded string) string {
+	raw, err := decodeBase64URL(encoded)
+	if err != nil {
+		return ""
+	}
+	var data clientData
+	if json.Unmarshal(raw, &data) != nil {
+		return ""
+	}
+	return data.Challenge
+}
+
+// verifyRegistration checks a passkey registration and returns its
+// authenticator data, which holds the new credential's ID and COSE public key
+func verifyRegistration(rp RelyingParty, response *CredentialCreationResponse) (*authenticatorData, error) {
+	if _, _, err := parseClientData(response.Response.ClientDataJSON, "webauthn.create", rp.Origins); err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeBase64URL(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object: %v", ErrInvalidPasskey, err)
+	}
+	decoded, _, err := cborDecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object: %v", ErrInvalidPasskey, err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object is not a map", ErrInvalidPasskey)
+	}
+	// The attestation statement is not checked: options ask for none, since a
+	// self-hosted dashboard has no list of trusted authenticator vendors
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object has no authenticator data", ErrInvalidPasskey)
+	}
+
+	data, err := parseAuthenticatorData(authData, rp.ID)
+	if err != nil {
+		return nil, err
+	}
+	if data.flags&authFlagAttested == 0 || len(data.credentialID) == 0 {
+		return nil, fmt.Errorf("%w: no credential in authenticator data", ErrInvalidPasskey)
+	}
+	if id := base64.RawURLEncoding.EncodeToString(data.credentialID); response.ID != "" && response.ID != id {
+		return nil, fmt.Errorf("%w: credential ID mismatch", ErrInvalidPasskey)
+	}
+	if _, err := parseCOSEKey(data.publicKey); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyAssertion checks a passkey login against the credential's COSE public
+// key and returns the authenticator data
+func verifyAssertion(rp RelyingParty, response *CredentialAssertionResponse, publicKey []byte) (*authenticatorData, error) {
+	clientDataJSON, _, err := parseClientData(response.Response.ClientDataJSON, "webauthn.get", rp.Origins)
+	if err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := decodeBase64URL(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: authenticator data: %v", ErrInvalidPasskey, err)
+	}
+	data, err := parseAuthenticatorData(rawAuthData, rp.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := decodeBase64URL(response.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidPasskey, err)
+	}
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if !key.verify(signed, signature) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidPasskey)
+	}
+	return data, nil
+}
+
+// parseAuthenticatorData parses authenticator data and checks that it is for
+// this relying party and the user was present
+func parseAuthenticatorData(raw []byte, rpID string) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrInvalidPasskey)
+	}
+
+	data := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: credential is for another site", ErrInvalidPasskey)
+	}
+	if data.flags&authFlagUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user was not present", ErrInvalidPasskey)
+	}
+
+	if data.flags&authFlagAttested != 0 {
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrInvalidPasskey)
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18])) // After the 16-byte AAGUID
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, fmt.Errorf("%w: credential ID truncated", ErrInvalidPasskey)
+		}
+		data.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		// The key is followed by extensions, so its length comes from decoding it
+		_, used, err := cborDecode(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: credential public key: %v", ErrInvalidPasskey, err)
+		}
+		data.publicKey = rest[:used]
+	}
+	return data, nil
+}
+
+// coseKey is a credential public key
+type coseKey struct {
+	alg int64
+	key interface{}
+}
+
+// parseCOSEKey parses a COSE public key of a supported algorithm
+func parseCOSEKey(raw []byte) (*coseKey, error) {
+	decoded, _, err := cborDecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: public key: %v", ErrInvalidPasskey, err)
+	}
+	fields, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not a map", ErrInvalidPasskey)
+	}
+	alg, _ := fields[int64(3)].(int64)
+	param := func(label int64) []byte {
+		value, _ := fields[label].([]byte)
+		return value
+	}
+
+	switch alg {
+	case coseAlgES256:
+		x, y := param(-2), param(-3)
+		if crv, _ := fields[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: unsupported EC2 key", ErrInvalidPasskey)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("%w: EC2 key is not on the curve", ErrInvalidPasskey)
+		}
+		return &coseKey{alg: alg, key: key}, nil
+	case coseAlgEdDSA:
+		x := param(-2)
+		if crv, _ := fields[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: unsupported OKP key", ErrInvalidPasskey)
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case coseAlgRS256:
+		n, e := param(-1), param(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: unsupported RSA key", ErrInvalidPasskey)
+		}
+		return &coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %d", ErrInvalidPasskey, alg)
+	}
+}
+
+// verify checks a signature over message
+func (k *coseKey) verify(message, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// decodeBase64URL decodes base64url with or without padding
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
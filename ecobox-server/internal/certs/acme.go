@@ -0,0 +1,264 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ecobox-server/internal/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+)
+
+// acmeOrderTimeout bounds one certificate order, including DNS propagation
+const acmeOrderTimeout = 10 * time.Minute
+
+// acmeIssuer obtains certificates from an ACME CA with DNS-01 challenges
+type acmeIssuer struct {
+	config config.ACMEConfig
+	dir    string // Account key, certificate and key
+	dns    DNSProvider
+	client *http.Client
+	logger *logrus.Logger
+}
+
+func newACMEIssuer(cfg config.ACMEConfig, dir string) (*acmeIssuer, error) {
+	provider, err := NewDNSProvider(cfg.DNS)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	if cfg.CAFile != "" {
+		roots, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read acme ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(roots) {
+			return nil, fmt.Errorf("acme ca_file %s has no PEM certificates", cfg.CAFile)
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	return &acmeIssuer{config: cfg, dir: dir, dns: provider, client: client, logger: logrus.New()}, nil
+}
+
+func (ai *acmeIssuer) certPath() string { return filepath.Join(ai.dir, "cert.pem") }
+func (ai *acmeIssuer) keyPath() string  { return filepath.Join(ai.dir, "key.pem") }
+
+// needsRenewal reports whether a certificate is missing, expires within
+// renew_before or is not for the configured domains
+func (ai *acmeIssuer) needsRenewal(cert *tls.Certificate, now time.Time) bool {
+	if cert == nil || cert.Leaf == nil {
+		return true
+	}
+	renewBefore := time.Duration(ai.config.RenewBefore) * 24 * time.Hour
+	return cert.Leaf.NotAfter.Sub(now) < renewBefore || !sameNames(cert.Leaf.DNSNames, ai.config.Domains)
+}
+
+// obtain orders a certificate for the domains and saves it
+func (ai *acmeIssuer) obtain(ctx context.Context) (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, acmeOrderTimeout)
+	defer cancel()
+
+	accountKey, err := ai.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          accountKey,
+		HTTPClient:   ai.client,
+		DirectoryURL: ai.config.DirectoryURL,
+		UserAgent:    "ecobox",
+	}
+
+	account := &acme.Account{}
+	if ai.config.Email != "" {
+		account.Contact = []string{"mailto:" + ai.config.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register acme account: %w", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(ai.config.Domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create acme order: %w", err)
+	}
+	if err := ai.authorize(ctx, client, order.AuthzURLs); err != nil {
+		return nil, err
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("acme order failed: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: ai.config.Domains[0]},
+		DNSNames: ai.config.Domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize acme order: %w", err)
+	}
+	return ai.save(chain, key)
+}
+
+// authorize proves control of the domains of pending authorizations. All
+// records are published before any challenge is accepted, so propagation is
+// waited for once.
+func (ai *acmeIssuer) authorize(ctx context.Context, client *acme.Client, authzURLs []string) error {
+	type pending struct {
+		authz     *acme.Authorization
+		challenge *acme.Challenge
+		fqdn      string
+		value     string
+	}
+	var challenges []pending
+	defer func() {
+		for _, p := range challenges {
+			if err := ai.dns.CleanUp(context.Background(), p.fqdn, p.value); err != nil {
+				ai.logger.Warnf("Failed to remove DNS challenge record %s: %v", p.fqdn, err)
+			}
+		}
+	}()
+
+	for _, authzURL := range authzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return fmt.Errorf("failed to get acme authorization: %w", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+			}
+		}
+		if challenge == nil {
+			return fmt.Errorf("acme CA offers no dns-01 challenge for %s", authz.Identifier.Value)
+		}
+		value, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+		if err := ai.dns.Present(ctx, fqdn, value); err != nil {
+			return fmt.Errorf("failed to publish DNS challenge record %s: %w", fqdn, err)
+		}
+		challenges = append(challenges, pending{authz: authz, challenge: challenge, fqdn: fqdn, value: value})
+	}
+	if len(challenges) == 0 {
+		return nil
+	}
+
+	ai.logger.Infof("Published %d DNS challenge records, waiting %ds for propagation", len(challenges), ai.config.DNS.PropagationWait)
+	select {
+	case <-time.After(time.Duration(ai.config.DNS.PropagationWait) * time.Second):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, p := range challenges {
+		if _, err := client.Accept(ctx, p.challenge); err != nil {
+			return fmt.Errorf("failed to accept acme challenge for %s: %w", p.authz.Identifier.Value, err)
+		}
+	}
+	for _, p := range challenges {
+		if _, err := client.WaitAuthorization(ctx, p.authz.URI); err != nil {
+			return fmt.Errorf("acme authorization for %s failed: %w", p.authz.Identifier.Value, err)
+		}
+	}
+	return nil
+}
+
+// save writes a certificate chain and its key, and returns them as a
+// certificate to serve
+func (ai *acmeIssuer) save(chain [][]byte, key crypto.Signer) (*tls.Certificate, error) {
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("acme CA returned an unusable certificate: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(ai.keyPath(), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFile(ai.certPath(), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// accountKey loads the ACME account key, creating it on first use
+func (ai *acmeIssuer) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(ai.dir, "account-key.pem")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s is not a PEM key", path)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a signing key", path)
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(path, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
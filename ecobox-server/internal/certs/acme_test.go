@@ -0,0 +1,288 @@
+package certs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+	"golang.org/x/crypto/acme"
+)
+
+// testCA is a minimal ACME server in the spirit of Pebble: it validates a
+// dns-01 challenge by looking at the records of a testDNS and issues
+// certificates with a CA of its own
+type testCA struct {
+	server *httptest.Server
+	ca     *authority
+	dns    *testDNS
+
+	mu         sync.Mutex
+	domain     string
+	authzValid bool
+	cert       []byte
+}
+
+func newTestCA(t *testing.T, dns *testDNS) *testCA {
+	ca, err := loadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testCA{ca: ca, dns: dns}
+
+	mux := http.NewServeMux()
+	url := func(path string) string { return tc.server.URL + path }
+	mux.HandleFunc("/dir", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   url("/nonce"),
+			"newAccount": url("/account"),
+			"newOrder":   url("/order"),
+			"revokeCert": url("/revoke"),
+			"keyChange":  url("/key-change"),
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", url("/accounts/1"))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+	})
+	mux.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		jwsPayload(t, r, &req)
+		tc.mu.Lock()
+		tc.domain = req.Identifiers[0].Value
+		tc.mu.Unlock()
+		w.Header().Set("Location", url("/orders/1"))
+		w.WriteHeader(http.StatusCreated)
+		tc.writeOrder(w)
+	})
+	mux.HandleFunc("/orders/1", func(w http.ResponseWriter, r *http.Request) { tc.writeOrder(w) })
+	mux.HandleFunc("/authz/1", func(w http.ResponseWriter, r *http.Request) {
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		status := "pending"
+		if tc.authzValid {
+			status = "valid"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": tc.domain},
+			"challenges": []map[string]string{
+				{"type": "http-01", "url": url("/chal/2"), "token": "http-token", "status": "pending"},
+				{"type": "dns-01", "url": url("/chal/1"), "token": "dns-token", "status": "pending"},
+			},
+		})
+	})
+	mux.HandleFunc("/chal/1", func(w http.ResponseWriter, r *http.Request) {
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		if tc.dns.record("_acme-challenge."+tc.domain+".") == "" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:unauthorized", "detail": "no TXT record"})
+			return
+		}
+		tc.authzValid = true
+		json.NewEncoder(w).Encode(map[string]string{"type": "dns-01", "url": url("/chal/1"), "token": "dns-token", "status": "valid"})
+	})
+	mux.HandleFunc("/finalize/1", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ CSR string }
+		jwsPayload(t, r, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Errorf("Bad CSR: %v", err)
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, template, tc.ca.cert, csr.PublicKey, tc.ca.key)
+		if err != nil {
+			t.Errorf("Failed to issue: %v", err)
+			return
+		}
+		tc.mu.Lock()
+		tc.cert = cert
+		tc.mu.Unlock()
+		tc.writeOrder(w)
+	})
+	mux.HandleFunc("/cert/1", func(w http.ResponseWriter, r *http.Request) {
+		tc.mu.Lock()
+		defer tc.mu.Unlock()
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: tc.cert})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: tc.ca.cert.Raw})
+	})
+
+	tc.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+		w.Header().Set("Cache-Control", "no-store")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(tc.server.Close)
+	return tc
+}
+
+func (tc *testCA) writeOrder(w http.ResponseWriter) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	order := map[string]interface{}{
+		"status":         "pending",
+		"identifiers":    []map[string]string{{"type": "dns", "value": tc.domain}},
+		"authorizations": []string{tc.server.URL + "/authz/1"},
+		"finalize":       tc.server.URL + "/finalize/1",
+	}
+	switch {
+	case tc.cert != nil:
+		order["status"] = "valid"
+		order["certificate"] = tc.server.URL + "/cert/1"
+	case tc.authzValid:
+		order["status"] = "ready"
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+// caFile writes the test server's certificate, for ca_file
+func (tc *testCA) caFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "acme-ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// jwsPayload decodes the payload of a JWS request, without checking it
+func jwsPayload(t *testing.T, r *http.Request, v interface{}) {
+	var jws struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		t.Errorf("Bad JWS: %v", err)
+		return
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	json.Unmarshal(payload, v)
+}
+
+// testDNS records the TXT records its webhook is asked to publish
+type testDNS struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	records   map[string]string
+	presented []string
+	cleaned   []string
+}
+
+func newTestDNS(t *testing.T) *testDNS {
+	d := &testDNS{records: make(map[string]string)}
+	d.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer dns-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct{ FQDN, Value string }
+		json.NewDecoder(r.Body).Decode(&req)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		switch r.URL.Path {
+		case "/present":
+			d.records[req.FQDN] = req.Value
+			d.presented = append(d.presented, req.Value)
+		case "/cleanup":
+			delete(d.records, req.FQDN)
+			d.cleaned = append(d.cleaned, req.FQDN)
+		}
+	}))
+	t.Cleanup(d.server.Close)
+	return d
+}
+
+func (d *testDNS) record(fqdn string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.records[fqdn]
+}
+
+func TestACMECertificate(t *testing.T) {
+	dns := newTestDNS(t)
+	ca := newTestCA(t, dns)
+	m := newTestManager(t, func(c *config.TLSConfig) {
+		c.ACME = config.ACMEConfig{
+			Domains:      []string{"ecobox.example.com"},
+			DirectoryURL: ca.server.URL + "/dir",
+			CAFile:       ca.caFile(t),
+			DNS: config.DNSProviderConfig{
+				Type:    "webhook",
+				URL:     dns.server.URL,
+				Headers: map[string]string{"Authorization": "Bearer dns-secret"},
+			},
+		}
+	})
+	m.acme.config.DNS.PropagationWait = 0 // Nothing to wait for
+
+	// A self-signed certificate is served until the first order completes
+	if !m.ca.issued(m.cert) {
+		t.Fatal("Expected a self-signed certificate before the ACME order")
+	}
+	m.renew(context.Background())
+	if m.ca.issued(m.cert) || m.cert.Leaf.DNSNames[0] != "ecobox.example.com" {
+		t.Fatalf("Expected the ACME certificate, got %v", m.cert.Leaf.DNSNames)
+	}
+	if _, err := m.cert.Leaf.Verify(x509.VerifyOptions{DNSName: "ecobox.example.com", Roots: certPool(ca.ca.cert)}); err != nil {
+		t.Errorf("Expected a certificate from the test CA: %v", err)
+	}
+
+	// The challenge record was the account's key authorization, and was
+	// removed afterwards
+	key, err := m.acme.accountKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := (&acme.Client{Key: key}).DNS01ChallengeRecord("dns-token")
+	if len(dns.presented) != 1 || dns.presented[0] != expected {
+		t.Errorf("Expected the record %q, got %v", expected, dns.presented)
+	}
+	if len(dns.cleaned) != 1 || dns.cleaned[0] != "_acme-challenge.ecobox.example.com." || dns.record(dns.cleaned[0]) != "" {
+		t.Errorf("Expected the challenge record to be cleaned up, got %v", dns.cleaned)
+	}
+
+	// The certificate is loaded on the next start, and not renewed until it
+	// nears expiry
+	again := NewManager(&config.Config{TLS: m.config})
+	if err := again.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !again.cert.Leaf.Equal(m.cert.Leaf) || again.acme.needsRenewal(again.cert, time.Now()) {
+		t.Error("Expected the saved ACME certificate to be used")
+	}
+	if !again.acme.needsRenewal(again.cert, time.Now().Add(61*24*time.Hour)) {
+		t.Error("Expected renewal 30 days before expiry")
+	}
+}
+
+func certPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
@@ -0,0 +1,281 @@
+// Package certs provides the certificate the dashboard serves HTTPS with.
+//
+// The certificate comes from one of three sources, picked by the [tls]
+// configuration: PEM files, reloaded when they change on disk; an ACME CA such
+// as Let's Encrypt, proving control of the domains with DNS-01 challenges; or a
+// CA the dashboard creates on first run, which issues a certificate for the
+// machine's names and addresses. ACME and self-signed certificates are renewed
+// before they expire. Until ACME issues the first certificate, a self-signed one
+// is served.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/secrets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fileCheckInterval is how often cert_file and key_file are checked for
+	// changes, at most
+	fileCheckInterval = 10 * time.Second
+	// renewCheckInterval is how often certificates are checked for renewal
+	renewCheckInterval = time.Hour
+	// renewRetryInterval is how long to wait after a failed ACME order
+	renewRetryInterval = 6 * time.Hour
+)
+
+// Manager loads, issues and renews the dashboard's certificate
+type Manager struct {
+	config config.TLSConfig
+
+	cert      *tls.Certificate
+	certMod   time.Time // Modification times of cert_file and key_file
+	keyMod    time.Time
+	checkedAt time.Time
+	retryAt   time.Time // Earliest next ACME order after a failure
+
+	ca      *authority
+	acme    *acmeIssuer
+	secrets *secrets.Vault // Holds DNS provider credentials that are references
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	logger *logrus.Logger
+}
+
+// NewManager creates a certificate manager for the [tls] configuration
+func NewManager(cfg *config.Config) *Manager {
+	return &Manager{
+		config: cfg.TLS,
+		logger: logrus.New(),
+	}
+}
+
+// SetLogger sets a custom logger
+func (m *Manager) SetLogger(logger *logrus.Logger) {
+	m.logger = logger
+}
+
+// SetSecrets sets the vault DNS provider credentials are read from. It must be
+// called before Load.
+func (m *Manager) SetSecrets(vault *secrets.Vault) {
+	m.secrets = vault
+}
+
+// Load loads the certificate, creating the self-signed CA and certificate
+// when they do not exist yet
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.config.Mode() {
+	case "files":
+		return m.loadFilesLocked()
+	case "acme":
+		acmeConfig := m.config.ACME
+		var err error
+		if acmeConfig.DNS.APIToken, err = m.secrets.Resolve(acmeConfig.DNS.APIToken); err != nil {
+			return err
+		}
+		if acmeConfig.DNS.Headers, err = m.secrets.ResolveHeaders(acmeConfig.DNS.Headers); err != nil {
+			return err
+		}
+		issuer, err := newACMEIssuer(acmeConfig, filepath.Join(m.config.Dir, "acme"))
+		if err != nil {
+			return err
+		}
+		issuer.logger = m.logger
+		m.acme = issuer
+
+		cert, err := loadKeyPair(issuer.certPath(), issuer.keyPath())
+		if err == nil {
+			m.cert = cert
+			m.logger.Infof("Loaded ACME certificate for %v, valid until %s", m.config.ACME.Domains, cert.Leaf.NotAfter.Format(time.RFC3339))
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			m.logger.Warnf("Ignoring saved ACME certificate: %v", err)
+		}
+		m.logger.Info("No ACME certificate yet, serving a self-signed one until it is issued")
+	}
+
+	return m.loadSelfSignedLocked()
+}
+
+// Start renews ACME and self-signed certificates in the background
+func (m *Manager) Start() {
+	if m.config.Mode() == "files" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(renewCheckInterval)
+		defer ticker.Stop()
+		for {
+			m.renew(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops renewing certificates, abandoning an ACME order in progress
+func (m *Manager) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+}
+
+// TLSConfig returns the server configuration that serves the certificate
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate, reloading cert_file and
+// key_file when they changed
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.config.Mode() == "files" && time.Since(m.checkedAt) >= fileCheckInterval {
+		if err := m.loadFilesLocked(); err != nil {
+			m.logger.Errorf("Failed to reload TLS certificate, serving the previous one: %v", err)
+		}
+	}
+	if m.cert == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded")
+	}
+	return m.cert, nil
+}
+
+// CACertificate returns the PEM certificate of the self-signed CA, for
+// clients to trust, or nil when the dashboard has none
+func (m *Manager) CACertificate() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ca == nil {
+		return nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.cert.Raw})
+}
+
+// loadFilesLocked loads cert_file and key_file when either changed since they
+// were last loaded
+func (m *Manager) loadFilesLocked() error {
+	m.checkedAt = time.Now()
+	certInfo, err := os.Stat(m.config.CertFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(m.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	if m.cert != nil && certInfo.ModTime().Equal(m.certMod) && keyInfo.ModTime().Equal(m.keyMod) {
+		return nil
+	}
+
+	cert, err := loadKeyPair(m.config.CertFile, m.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	if m.cert != nil {
+		m.logger.Infof("Reloaded TLS certificate from %s", m.config.CertFile)
+	}
+	m.cert = cert
+	m.certMod = certInfo.ModTime()
+	m.keyMod = keyInfo.ModTime()
+	if time.Until(cert.Leaf.NotAfter) < 0 {
+		m.logger.Warnf("TLS certificate %s expired on %s", m.config.CertFile, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// renew replaces certificates that expire soon
+func (m *Manager) renew(ctx context.Context) {
+	m.mu.Lock()
+	current, acme := m.cert, m.acme
+	issuedByCA := m.ca != nil && current != nil && m.ca.issued(current)
+	retryAt := m.retryAt
+	m.mu.Unlock()
+
+	if acme != nil && (issuedByCA || acme.needsRenewal(current, time.Now())) && time.Now().After(retryAt) {
+		cert, err := acme.obtain(ctx)
+		m.mu.Lock()
+		if err != nil && ctx.Err() != nil {
+			m.logger.Info("Abandoned ACME order on shutdown")
+		} else if err != nil {
+			m.logger.Errorf("Failed to obtain ACME certificate for %v, retrying in %s: %v", m.config.ACME.Domains, renewRetryInterval, err)
+			m.retryAt = time.Now().Add(renewRetryInterval)
+		} else {
+			m.logger.Infof("Obtained ACME certificate for %v, valid until %s", m.config.ACME.Domains, cert.Leaf.NotAfter.Format(time.RFC3339))
+			m.cert = cert
+		}
+		m.mu.Unlock()
+		return
+	}
+
+	if issuedByCA && time.Until(current.Leaf.NotAfter) < selfSignedRenewBefore {
+		m.mu.Lock()
+		if err := m.issueSelfSignedLocked(); err != nil {
+			m.logger.Errorf("Failed to renew self-signed certificate: %v", err)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// loadKeyPair loads a PEM certificate chain and key
+func loadKeyPair(certPath, keyPath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// writeFile replaces a file with data, so readers never see part of it
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpFile, err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+)
+
+func newTestManager(t *testing.T, configure func(*config.TLSConfig)) *Manager {
+	cfg := &config.Config{}
+	cfg.TLS.Enabled = true
+	cfg.TLS.Dir = t.TempDir()
+	cfg.TLS.Hostnames = []string{"ecobox.lan", "192.168.1.10"}
+	configure(&cfg.TLS)
+	cfg.TLS.SetDefaults()
+
+	m := NewManager(cfg)
+	if err := m.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return m
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	m := newTestManager(t, func(*config.TLSConfig) {})
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "ecobox.lan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients that trust the CA trust the certificate for every hostname
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(m.CACertificate()) {
+		t.Fatal("Expected the CA certificate as PEM")
+	}
+	for _, name := range []string{"ecobox.lan", "192.168.1.10"} {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			t.Errorf("Expected the certificate to be valid for %s: %v", name, err)
+		}
+	}
+
+	// A restart keeps the CA and certificate, and new hostnames get a new
+	// certificate from the same CA
+	again := NewManager(&config.Config{TLS: m.config})
+	if err := again.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if string(again.CACertificate()) != string(m.CACertificate()) || !again.cert.Leaf.Equal(cert.Leaf) {
+		t.Error("Expected the saved CA and certificate to be loaded")
+	}
+	renamed := m.config
+	renamed.Hostnames = []string{"nas.lan"}
+	again = NewManager(&config.Config{TLS: renamed})
+	if err := again.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := again.cert.Leaf.Verify(x509.VerifyOptions{DNSName: "nas.lan", Roots: roots}); err != nil {
+		t.Errorf("Expected a certificate for the new hostname from the same CA: %v", err)
+	}
+}
+
+func TestCertificateFilesReload(t *testing.T) {
+	// Certificates issued by a throwaway CA stand in for the user's files
+	issuer := newTestManager(t, func(*config.TLSConfig) {})
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	copyFile := func(from, to string) {
+		data, err := os.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(to, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyFile(filepath.Join(issuer.config.Dir, "cert.pem"), certFile)
+	copyFile(filepath.Join(issuer.config.Dir, "key.pem"), keyFile)
+
+	m := newTestManager(t, func(c *config.TLSConfig) {
+		c.CertFile = certFile
+		c.KeyFile = keyFile
+	})
+	first, _ := m.GetCertificate(&tls.ClientHelloInfo{})
+	if m.CACertificate() != nil {
+		t.Error("Expected no CA with certificate files")
+	}
+
+	// A renewed certificate is served once the files change
+	issuer.mu.Lock()
+	issuer.config.Hostnames = []string{"renewed.lan"}
+	if err := issuer.issueSelfSignedLocked(); err != nil {
+		t.Fatal(err)
+	}
+	issuer.mu.Unlock()
+	copyFile(filepath.Join(issuer.config.Dir, "cert.pem"), certFile)
+	copyFile(filepath.Join(issuer.config.Dir, "key.pem"), keyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	m.checkedAt = time.Time{}
+	second, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || second.Leaf.Equal(first.Leaf) || second.Leaf.DNSNames[0] != "renewed.lan" {
+		t.Errorf("Expected the renewed certificate, got %v (%v)", second.Leaf.DNSNames, err)
+	}
+
+	// A broken file keeps the previous certificate
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute))
+	m.checkedAt = time.Time{}
+	if third, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != nil || !third.Leaf.Equal(second.Leaf) {
+		t.Errorf("Expected the previous certificate after a bad reload, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"ecobox-server/internal/config"
+)
+
+// DNSProvider publishes the TXT records of DNS-01 challenges
+type DNSProvider interface {
+	// Present creates a TXT record with the value at the fully qualified name,
+	// which ends with a dot
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the record Present created
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// NewDNSProvider creates the DNS provider of the ACME configuration
+func NewDNSProvider(cfg config.DNSProviderConfig) (DNSProvider, error) {
+	switch cfg.Type {
+	case "cloudflare":
+		return &cloudflareProvider{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}, baseURL: "https://api.cloudflare.com/client/v4", records: make(map[string]string)}, nil
+	case "exec":
+		return &execProvider{cfg: cfg}, nil
+	case "webhook":
+		return &webhookProvider{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown acme dns type '%s'", cfg.Type)
+}
+
+// execProvider runs a command, as "command present|cleanup <fqdn> <value>",
+// for DNS servers without an API the dashboard knows, and for test servers
+// such as Pebble's challtestsrv
+type execProvider struct {
+	cfg config.DNSProviderConfig
+}
+
+func (p *execProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *execProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+func (p *execProvider) run(ctx context.Context, action, fqdn, value string) error {
+	output, err := exec.CommandContext(ctx, p.cfg.Command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", p.cfg.Command, action, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// webhookProvider POSTs {"fqdn": ..., "value": ...} to url/present and
+// url/cleanup, as lego's httpreq provider does
+type webhookProvider struct {
+	cfg    config.DNSProviderConfig
+	client *http.Client
+}
+
+func (p *webhookProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.post(ctx, "present", fqdn, value)
+}
+
+func (p *webhookProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.post(ctx, "cleanup", fqdn, value)
+}
+
+func (p *webhookProvider) post(ctx context.Context, action, fqdn, value string) error {
+	body, _ := json.Marshal(map[string]string{"fqdn": fqdn, "value": value})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.cfg.URL, "/")+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range p.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("dns webhook %s failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("dns webhook %s failed: %s", action, resp.Status)
+	}
+	return nil
+}
+
+// cloudflareProvider manages records through the Cloudflare API
+type cloudflareProvider struct {
+	cfg     config.DNSProviderConfig
+	client  *http.Client
+	baseURL string
+	records map[string]string // Record IDs by fqdn and value
+}
+
+func (p *cloudflareProvider) Present(ctx context.Context, fqdn, value string) error {
+	zoneID, err := p.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	var record struct {
+		ID string `json:"id"`
+	}
+	err = p.call(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", map[string]interface{}{
+		"type":    "TXT",
+		"name":    strings.TrimSuffix(fqdn, "."),
+		"content": value,
+		"ttl":     120,
+	}, &record)
+	if err != nil {
+		return err
+	}
+	p.records[fqdn+" "+value] = zoneID + "/dns_records/" + record.ID
+	return nil
+}
+
+func (p *cloudflareProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	record, exists := p.records[fqdn+" "+value]
+	if !exists {
+		return nil
+	}
+	delete(p.records, fqdn+" "+value)
+	return p.call(ctx, http.MethodDelete, "/zones/"+record, nil, nil)
+}
+
+// zone returns the configured zone, or the closest zone containing fqdn
+func (p *cloudflareProvider) zone(ctx context.Context, fqdn string) (string, error) {
+	if p.cfg.ZoneID != "" {
+		return p.cfg.ZoneID, nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		name := strings.Join(labels[i:], ".")
+		if err := p.call(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(name), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no cloudflare zone found for %s", fqdn)
+}
+
+// call makes a Cloudflare API request and decodes the result
+func (p *cloudflareProvider) call(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.cfg.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool                       `json:"success"`
+		Errors  []struct{ Message string } `json:"errors"`
+		Result  json.RawMessage            `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("failed to decode cloudflare response (%s): %w", resp.Status, err)
+	}
+	if !envelope.Success {
+		messages := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("cloudflare request refused (%s): %s", resp.Status, strings.Join(messages, "; "))
+	}
+	if result != nil {
+		return json.Unmarshal(envelope.Result, result)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// caLifetime is how long the self-signed CA is valid
+	caLifetime = 10 * 365 * 24 * time.Hour
+	// selfSignedLifetime stays under the 398 days browsers accept
+	selfSignedLifetime = 397 * 24 * time.Hour
+	// selfSignedRenewBefore is how long before expiry self-signed certificates
+	// are replaced
+	selfSignedRenewBefore = 30 * 24 * time.Hour
+)
+
+// authority is the CA that issues self-signed certificates
+type authority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// issued reports whether the CA issued a certificate
+func (a *authority) issued(cert *tls.Certificate) bool {
+	return cert.Leaf != nil && cert.Leaf.CheckSignatureFrom(a.cert) == nil
+}
+
+// loadSelfSignedLocked loads the CA and its certificate, creating them when
+// they do not exist, and reissues the certificate when it expires soon or
+// hostnames changed
+func (m *Manager) loadSelfSignedLocked() error {
+	if m.ca == nil {
+		ca, err := loadOrCreateCA(m.config.Dir)
+		if err != nil {
+			return err
+		}
+		m.ca = ca
+		fingerprint := sha256.Sum256(ca.cert.Raw)
+		m.logger.Infof("Using self-signed CA %s (SHA-256 %s)", filepath.Join(m.config.Dir, "ca.pem"), hex.EncodeToString(fingerprint[:]))
+	}
+
+	cert, err := loadKeyPair(filepath.Join(m.config.Dir, "cert.pem"), filepath.Join(m.config.Dir, "key.pem"))
+	if err == nil && m.ca.issued(cert) && time.Until(cert.Leaf.NotAfter) > selfSignedRenewBefore &&
+		sameNames(certificateNames(cert.Leaf), m.hostnames()) {
+		m.cert = cert
+		return nil
+	}
+	return m.issueSelfSignedLocked()
+}
+
+// issueSelfSignedLocked issues a certificate for hostnames with the CA
+func (m *Manager) issueSelfSignedLocked() error {
+	names := m.hostnames()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(selfSignedLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, m.ca.cert, &key.PublicKey, m.ca.key)
+	if err != nil {
+		return fmt.Errorf("failed to issue self-signed certificate: %w", err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.cert.Raw})...)
+
+	if err := writeFile(filepath.Join(m.config.Dir, "key.pem"), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(m.config.Dir, "cert.pem"), certPEM, 0644); err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	m.cert = &cert
+	m.logger.Infof("Issued self-signed certificate for %s", strings.Join(names, ", "))
+	return nil
+}
+
+// hostnames returns the names the self-signed certificate is for: hostnames,
+// or this machine's name and addresses
+func (m *Manager) hostnames() []string {
+	if len(m.config.Hostnames) > 0 {
+		return m.config.Hostnames
+	}
+
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		names = append([]string{hostname}, names...)
+	}
+	names = append(names, "127.0.0.1", "::1")
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+				names = append(names, ipNet.IP.String())
+			}
+		}
+	}
+	return names
+}
+
+// loadOrCreateCA loads the CA from dir, creating it on first run
+func loadOrCreateCA(dir string) (*authority, error) {
+	certPath, keyPath := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	pair, err := loadKeyPair(certPath, keyPath)
+	if err == nil {
+		key, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok || !pair.Leaf.IsCA {
+			return nil, fmt.Errorf("%s is not a CA certificate", certPath)
+		}
+		return &authority{cert: pair.Leaf, key: key}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "EcoBox CA " + hostname, Organization: []string{"EcoBox"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writeFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	return &authority{cert: cert, key: key}, nil
+}
+
+// certificateNames returns the DNS names and addresses of a certificate
+func certificateNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// sameNames reports whether two lists hold the same names in any order
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	for i := range b {
+		if ip := net.ParseIP(b[i]); ip != nil {
+			b[i] = ip.String()
+		}
+	}
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeKey encodes a private key as PKCS #8 PEM
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// randomSerial returns a random 128-bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
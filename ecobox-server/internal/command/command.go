@@ -787,22 +787,20 @@ func (c *Commander) CreateProxmoxAPIKey(host string, port int, user string, keyP
 		return nil, c.handleSSHError(err, cmd, output)
 	}
 
-	c.logger.WithField("host", host).WithField("output", output).Debug("Raw API token creation output")
+	// The output holds the secret, so it is never logged
+	c.logger.WithField("host", host).Debug("Parsing API token creation output")
 
 	// Parse the output to extract the secret
 	lines := strings.Split(output, "\n")
 	var secret string
 	for _, line := range lines {
-		c.logger.WithField("line", line).Debug("Parsing token output line")
 		// Look for the actual data line that contains the secret value
 		// The line should have the pattern: │ value        │ <actual-uuid-here> │
 		if strings.Contains(line, "│ value") && strings.Count(line, "│") >= 3 {
 			parts := strings.Split(line, "│")
-			c.logger.WithField("parts", parts).Debug("Split line parts")
 			// parts[0] = "", parts[1] = " value        ", parts[2] = " <uuid> ", parts[3] = ""
 			if len(parts) >= 3 {
 				secret = strings.TrimSpace(parts[2])
-				c.logger.WithField("secret", secret).Debug("Extracted secret")
 				// Make sure we got a UUID-like string, not just "value"
 				if len(secret) > 10 && secret != "value" {
 					break
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"ecobox-server/internal/models"
+)
+
+// AlertsConfig defines alert rules and where their notifications go
+type AlertsConfig struct {
+	Notifiers []NotifierConfig  `toml:"notifiers"`
+	Rules     []AlertRuleConfig `toml:"rules"`
+}
+
+// NotifierConfig defines a destination for alert notifications
+type NotifierConfig struct {
+	Name     string            `toml:"name"`
+	Type     string            `toml:"type"`                // "webhook", "slack", "ntfy", "gotify", "email" or "mqtt"
+	URL      string            `toml:"url,omitempty"`       // Webhook URL, ntfy topic URL, Gotify server URL or MQTT broker (tcp:// or tls://)
+	Token    string            `toml:"token,omitempty"`     // ntfy access token or Gotify application token
+	Headers  map[string]string `toml:"headers,omitempty"`   // Extra HTTP headers (webhook)
+	SMTPHost string            `toml:"smtp_host,omitempty"` // Email
+	SMTPPort int               `toml:"smtp_port,omitzero"`  // Email (default: 587)
+	Username string            `toml:"username,omitempty"`  // SMTP or MQTT user
+	Password string            `toml:"password,omitempty"`  // SMTP or MQTT password
+	From     string            `toml:"from,omitempty"`      // Email sender
+	To       []string          `toml:"to,omitempty"`        // Email recipients
+	Topic    string            `toml:"topic,omitempty"`     // MQTT topic (default: "ecobox/alerts")
+}
+
+// AlertRuleConfig fires an alert when a condition holds for a number of
+// consecutive observations, and resolves it once the condition has cleared as
+// many times in a row
+type AlertRuleConfig struct {
+	Name           string   `toml:"name"`
+	Condition      string   `toml:"condition"`                // "service_down", "wake_failure", "wake_timeout" or "init_failed"
+	Servers        []string `toml:"servers,omitempty"`        // Server IDs the rule applies to (default: all)
+	Groups         []string `toml:"groups,omitempty"`         // Or servers in these groups
+	Services       []string `toml:"services,omitempty"`       // Service names for service_down (default: all)
+	Threshold      int      `toml:"threshold"`                // Consecutive failures before firing (default: 1)
+	Recovery       int      `toml:"recovery"`                 // Consecutive successes before resolving (default: 1)
+	RepeatInterval int      `toml:"repeat_interval,omitzero"` // Seconds between repeated notifications while firing (0 = notify once)
+	Severity       string   `toml:"severity"`                 // "info", "warning" (default) or "critical"
+	Notifiers      []string `toml:"notifiers"`                // Names of the notifiers to send to
+}
+
+// SetDefaults sets default values for missing alert fields
+func (a *AlertsConfig) SetDefaults() {
+	for i := range a.Notifiers {
+		notifier := &a.Notifiers[i]
+		if notifier.Type == "email" && notifier.SMTPPort == 0 {
+			notifier.SMTPPort = 587
+		}
+		if notifier.Type == "mqtt" && notifier.Topic == "" {
+			notifier.Topic = "ecobox/alerts"
+		}
+	}
+	for i := range a.Rules {
+		rule := &a.Rules[i]
+		if rule.Threshold == 0 {
+			rule.Threshold = 1
+		}
+		if rule.Recovery == 0 {
+			rule.Recovery = 1
+		}
+		if rule.Severity == "" {
+			rule.Severity = string(models.AlertSeverityWarning)
+		}
+	}
+}
+
+// Validate checks the notifiers and rules
+func (a *AlertsConfig) Validate() error {
+	notifiers := make(map[string]bool)
+	for _, notifier := range a.Notifiers {
+		if notifier.Name == "" {
+			return fmt.Errorf("notifier name cannot be empty")
+		}
+		if notifiers[notifier.Name] {
+			return fmt.Errorf("duplicate notifier name: %s", notifier.Name)
+		}
+		notifiers[notifier.Name] = true
+
+		if err := validateNotifier(notifier); err != nil {
+			return err
+		}
+	}
+
+	rules := make(map[string]bool)
+	for _, rule := range a.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("alert rule name cannot be empty")
+		}
+		if rules[rule.Name] {
+			return fmt.Errorf("duplicate alert rule name: %s", rule.Name)
+		}
+		rules[rule.Name] = true
+
+		if !validAlertCondition(rule.Condition) {
+			return fmt.Errorf("invalid condition '%s' for alert rule %s, must be one of: service_down, wake_failure, wake_timeout, init_failed", rule.Condition, rule.Name)
+		}
+		if len(rule.Services) > 0 && rule.Condition != string(models.AlertConditionServiceDown) {
+			return fmt.Errorf("alert rule %s can only filter services for the service_down condition", rule.Name)
+		}
+		if rule.Threshold < 1 || rule.Recovery < 1 {
+			return fmt.Errorf("alert rule %s threshold and recovery must be at least 1", rule.Name)
+		}
+		if rule.RepeatInterval < 0 {
+			return fmt.Errorf("alert rule %s repeat interval cannot be negative", rule.Name)
+		}
+		switch models.AlertSeverity(rule.Severity) {
+		case models.AlertSeverityInfo, models.AlertSeverityWarning, models.AlertSeverityCritical:
+		default:
+			return fmt.Errorf("invalid severity '%s' for alert rule %s, must be one of: info, warning, critical", rule.Severity, rule.Name)
+		}
+		if _, err := models.NormalizeGroups(rule.Groups); err != nil {
+			return fmt.Errorf("alert rule %s: %w", rule.Name, err)
+		}
+
+		if len(rule.Notifiers) == 0 {
+			return fmt.Errorf("alert rule %s needs at least one notifier", rule.Name)
+		}
+		for _, name := range rule.Notifiers {
+			if !notifiers[name] {
+				return fmt.Errorf("alert rule %s references unknown notifier '%s'", rule.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateNotifier checks the settings a notifier type needs
+func validateNotifier(notifier NotifierConfig) error {
+	switch notifier.Type {
+	case "webhook", "slack", "ntfy", "gotify":
+		if err := validateNotifierURL(notifier, "http", "https"); err != nil {
+			return err
+		}
+		if notifier.Type == "gotify" && notifier.Token == "" {
+			return fmt.Errorf("gotify notifier %s requires a token", notifier.Name)
+		}
+	case "email":
+		if notifier.SMTPHost == "" || notifier.From == "" || len(notifier.To) == 0 {
+			return fmt.Errorf("email notifier %s requires smtp_host, from and to", notifier.Name)
+		}
+		if notifier.SMTPPort < 1 || notifier.SMTPPort > 65535 {
+			return fmt.Errorf("invalid smtp_port %d for notifier %s", notifier.SMTPPort, notifier.Name)
+		}
+	case "mqtt":
+		if err := validateNotifierURL(notifier, "tcp", "tls", "mqtt", "mqtts"); err != nil {
+			return err
+		}
+		if strings.ContainsAny(notifier.Topic, "+#") {
+			return fmt.Errorf("mqtt notifier %s topic cannot contain wildcards", notifier.Name)
+		}
+	default:
+		return fmt.Errorf("invalid type '%s' for notifier %s, must be one of: webhook, slack, ntfy, gotify, email, mqtt", notifier.Type, notifier.Name)
+	}
+	return nil
+}
+
+// validateNotifierURL checks that the notifier URL has a host and one of the schemes
+func validateNotifierURL(notifier NotifierConfig, schemes ...string) error {
+	u, err := url.Parse(notifier.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s notifier %s requires a valid url", notifier.Type, notifier.Name)
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s notifier %s url must use one of: %s", notifier.Type, notifier.Name, strings.Join(schemes, ", "))
+}
+
+func validAlertCondition(condition string) bool {
+	for _, c := range models.AlertConditions {
+		if string(c) == condition {
+			return true
+		}
+	}
+	return false
+}
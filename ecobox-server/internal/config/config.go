@@ -1,10 +1,68 @@
 package config
 
+import "sync"
+
 type Config struct {
 	Dashboard DashboardConfig `toml:"dashboard"`
 	Servers   []ServerConfig  `toml:"servers"`
+	Alerts    AlertsConfig    `toml:"alerts"`
+	MQTT      MQTTConfig      `toml:"mqtt"`
+	OIDC      OIDCConfig      `toml:"oidc"`
+	TLS       TLSConfig       `toml:"tls"`
+	Secrets   SecretsConfig   `toml:"secrets"`
+
+	// liveMu guards the settings a reload changes in a running configuration:
+	// the dashboard settings and the alerts. Components running alongside
+	// reloads read them through LiveDashboard and LiveAlerts.
+	liveMu sync.RWMutex
+}
+
+// Copy returns a copy of the configuration, with the live settings read under
+// their lock. Slices and maps are shared with the original.
+func (c *Config) Copy() *Config {
+	c.liveMu.RLock()
+	defer c.liveMu.RUnlock()
+	return &Config{
+		Dashboard: c.Dashboard,
+		Servers:   c.Servers,
+		Alerts:    c.Alerts,
+		MQTT:      c.MQTT,
+		OIDC:      c.OIDC,
+		TLS:       c.TLS,
+		Secrets:   c.Secrets,
+	}
+}
+
+// LiveDashboard returns a copy of the dashboard settings
+func (c *Config) LiveDashboard() DashboardConfig {
+	c.liveMu.RLock()
+	defer c.liveMu.RUnlock()
+	return c.Dashboard
 }
 
+// LiveAlerts returns a copy of the alert rules and notifiers
+func (c *Config) LiveAlerts() AlertsConfig {
+	c.liveMu.RLock()
+	defer c.liveMu.RUnlock()
+	return c.Alerts
+}
+
+// SetLive replaces the dashboard settings and alerts of a running configuration.
+// Slices are replaced, never changed in place, so earlier copies stay valid.
+func (c *Config) SetLive(dashboard DashboardConfig, alerts AlertsConfig) {
+	c.liveMu.Lock()
+	defer c.liveMu.Unlock()
+	c.Dashboard = dashboard
+	c.Alerts = alerts
+}
+
+// DefaultContentSecurityPolicy allows the dashboard's own scripts, styles and
+// WebSocket, the inline scripts of its pages and the D3 library of the legacy
+// dashboard, and forbids framing
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline' https://cdnjs.cloudflare.com; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; object-src 'none'; " +
+	"base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
 type DashboardConfig struct {
 	Port             int    `toml:"port"`              // Web interface port (default: 8080)
 	UpdateInterval   int    `toml:"update_interval"`   // Status check interval in seconds (default: 30)
@@ -27,26 +85,107 @@ type DashboardConfig struct {
 
 	// Authentication settings
 	IAPAuth          string `toml:"iap_auth"`          // Identity-aware proxy: "tailscale", "authentik", "cloudflare", "none"
+	TrustedProxies   []string `toml:"trusted_proxies"` // IPs or CIDRs whose Tailscale and Authentik identity headers and X-Forwarded-Proto are believed (default: ["127.0.0.1", "::1"])
+	CloudflareTeamDomain string `toml:"cloudflare_team_domain"` // Cloudflare Access team domain, such as "example.cloudflareaccess.com"
+	CloudflareAudience   string `toml:"cloudflare_audience"`    // Application Audience (AUD) tag of the Cloudflare Access application
 	SessionKeyFile   string `toml:"session_key_file"`  // Path to session key file (default: "sessionkey.conf")
 	PasswordFile     string `toml:"password_file"`     // Path to password file (default: "passwd.conf")
+	DefaultRole      string `toml:"default_role"`      // Role of new users without one, including proxy users: "viewer", "operator" or "admin" (default: "operator")
+	TokensFile       string `toml:"tokens_file"`       // Path to API tokens, stored hashed (default: "api-tokens.json")
+	SessionsFile     string `toml:"sessions_file"`     // Path to active login sessions (default: "sessions.json")
+	AccessTokenLifetime int `toml:"access_token_lifetime"` // Minutes an access token is valid before it is refreshed (default: 15)
+	SessionLifetime  int    `toml:"session_lifetime"`  // Days a session lasts without being used (default: 30)
+	TwoFactorFile    string `toml:"two_factor_file"`   // Path to TOTP secrets, recovery codes and passkeys (default: "two-factor.json")
+	WebAuthnRPID     string `toml:"webauthn_rp_id"`    // Domain passkeys are bound to (default: the host the dashboard is reached on)
+	WebAuthnOrigins  []string `toml:"webauthn_origins"` // Origins passkeys may be used from, such as "https://ecobox.example.com" (default: the request's origin)
+
+	// Brute-force protection and browser security policy
+	LoginMaxFailures int    `toml:"login_max_failures"` // Failed logins per user or address before it is locked out (default: 10)
+	LoginLockout     int    `toml:"login_lockout"`      // Minutes a lockout lasts (default: 15)
+	CORSOrigins      []string `toml:"cors_origins"`     // Other origins whose pages may call the API, such as "https://home.example.com" (default: none)
+	ContentSecurityPolicy string `toml:"content_security_policy"` // Content-Security-Policy header of every response (default: same-origin resources only)
+	SecureCookies    bool   `toml:"secure_cookies"`     // Always mark cookies Secure, for HTTPS proxies that do not send X-Forwarded-Proto or are not trusted_proxies (default: only on HTTPS requests)
+
+	// Servers added through the API are saved here, in the same format as [[servers]]
+	APIServersFile   string `toml:"api_servers_file"`  // Path to API server definitions (default: "api-servers.toml")
+
+	// Wake-on-demand proxy settings
+	ProxyWakeTimeout int `toml:"proxy_wake_timeout"` // Seconds a proxied connection waits for the service to come up (default: 180)
+	ProxyIdleTimeout int `toml:"proxy_idle_timeout"` // Seconds the server is kept on after the last proxied connection (default: 1800)
+
+	// Group action settings
+	GroupConcurrency int `toml:"group_concurrency"` // Servers acted on at once by group wake/suspend/shutdown (default: 4)
+
+	// Reload the configuration when the file changes; SIGHUP always reloads
+	WatchConfig bool `toml:"watch_config"`
+
+	// Network discovery of machines that are not servers yet
+	DiscoveryNetworks []string `toml:"discovery_networks"` // IPv4 CIDRs to sweep, e.g. ["192.168.1.0/24"] (at most /22 each)
+	DiscoveryInterval int      `toml:"discovery_interval"` // Seconds between automatic sweeps (0 = only when requested)
+	DiscoveryOUIFile  string   `toml:"discovery_oui_file"` // IEEE oui.txt or Wireshark manuf file for vendor names (optional)
+
+	// Outbound webhook subscriptions registered through the API
+	WebhooksFile          string `toml:"webhooks_file"`            // Path to saved subscriptions (default: "webhooks.json")
+	WebhookDeadLetterFile string `toml:"webhook_dead_letter_file"` // Deliveries that failed every attempt are appended here (default: "webhook-dead-letters.log")
+
+	// Audit log of changes made through the API and of server actions
+	AuditFile     string `toml:"audit_file"`      // Path to the JSON lines audit log (default: "audit.log")
+	AuditMaxSize  int    `toml:"audit_max_size"`  // Megabytes before the log is rotated (default: 10)
+	AuditMaxFiles int    `toml:"audit_max_files"` // Rotated logs kept as audit.log.1, .2, ... (default: 5)
 }
 
+// ServerConfig defines a server. The JSON names match the TOML keys so the server
+// API accepts the same definitions as the configuration file.
 type ServerConfig struct {
-	ID             string          `toml:"id"`
-	Name           string          `toml:"name"`
-	Hostname       string          `toml:"hostname"`
-	MACAddress     string          `toml:"mac_address"`
-	ParentServerID string          `toml:"parent_server_id"`
-	SSHUser        string          `toml:"ssh_user"`
-	SSHPort        int             `toml:"ssh_port"`
-	SSHKeyPath     string          `toml:"ssh_key_path"`
-	Services       []ServiceConfig `toml:"services"`
+	ID             string          `toml:"id" json:"id"`
+	Name           string          `toml:"name" json:"name"`
+	Hostname       string          `toml:"hostname" json:"hostname"`
+	MACAddress     string          `toml:"mac_address" json:"mac_address"`
+	ParentServerID string          `toml:"parent_server_id,omitempty" json:"parent_server_id,omitempty"`
+	SSHUser        string          `toml:"ssh_user" json:"ssh_user"`
+	SSHPort        int             `toml:"ssh_port" json:"ssh_port"`
+	SSHKeyPath     string          `toml:"ssh_key_path,omitempty" json:"ssh_key_path,omitempty"` // Private key file, or a secret:<name> reference to a key in the vault
+	ProxmoxToken   string          `toml:"proxmox_token,omitempty" json:"proxmox_token,omitempty"` // secret:<name> reference to a "user@realm!tokenid=secret" API token (Proxmox hosts; default: one is created over SSH)
+	Services       []ServiceConfig `toml:"services" json:"services"`
+	VMPolicies     []VMPolicyConfig `toml:"vm_policies,omitempty" json:"vm_policies,omitempty"` // Per-VM power policies (Proxmox hosts only)
+	Dependencies   []DependencyConfig `toml:"depends_on,omitempty" json:"depends_on,omitempty"` // Servers or services that must be up before this one
+	Groups         []string        `toml:"groups,omitempty" json:"groups,omitempty"`      // Named groups such as "lab" or "media"
+}
+
+// DependencyConfig declares that a server needs another server, or a service on it
+type DependencyConfig struct {
+	Server string `toml:"server" json:"server"`                   // ID of the server depended on
+	Port   int    `toml:"port,omitzero" json:"port,omitempty"` // Service port that must accept connections (0 = server is on)
+	Type   string `toml:"type,omitempty" json:"type,omitempty"` // "hard" (default) or "soft"
 }
 
 type ServiceConfig struct {
-	Name string `toml:"name"`
-	Port int    `toml:"port"`
-	Type string `toml:"type"`
+	Name      string `toml:"name" json:"name"`
+	Port      int    `toml:"port" json:"port"`
+	Type      string `toml:"type,omitempty" json:"type,omitempty"`
+	ProxyPort int    `toml:"proxy_port,omitzero" json:"proxy_port,omitempty"` // Dashboard port that fronts this service and wakes the server on demand (0 = disabled)
+	ProxyMode string `toml:"proxy_mode,omitempty" json:"proxy_mode,omitempty"` // "tcp" or "http" (default: "http" for HTTP services, "tcp" otherwise)
+	Check     *HealthCheckConfig `toml:"check,omitempty" json:"check,omitempty"` // How to tell the service works (default: port accepts connections)
+}
+
+// HealthCheckConfig defines a service health check
+type HealthCheckConfig struct {
+	Type               string `toml:"type" json:"type"`                                                       // "tcp", "http", "https", "ssh", "dns", "smb", "nfs" or "command"
+	Path               string `toml:"path,omitempty" json:"path,omitempty"`                                   // HTTP(S) request path (default: "/")
+	ExpectStatus       int    `toml:"expect_status,omitzero" json:"expect_status,omitempty"`                  // HTTP(S) status required (default: any 2xx or 3xx)
+	ExpectBody         string `toml:"expect_body,omitempty" json:"expect_body,omitempty"`                     // Text the response body must contain
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"` // Accept self-signed HTTPS certificates
+	Query              string `toml:"query,omitempty" json:"query,omitempty"`                                 // Name a DNS check resolves
+	Command            string `toml:"command,omitempty" json:"command,omitempty"`                             // Command run over SSH; exit status 0 means up
+	Timeout            int    `toml:"timeout,omitzero" json:"timeout,omitempty"`                              // Seconds (default: 5)
+}
+
+// VMPolicyConfig sets power management options for a VM discovered on a Proxmox host
+type VMPolicyConfig struct {
+	VMID               int    `toml:"vmid" json:"vmid"`
+	SuspendMode        string `toml:"suspend_mode,omitempty" json:"suspend_mode,omitempty"`                 // "pause" (default), "hibernate", "shutdown", "stop"
+	SnapshotBeforeStop bool   `toml:"snapshot_before_stop,omitempty" json:"snapshot_before_stop,omitempty"` // Snapshot the VM before a forced stop
+	SnapshotKeep       int    `toml:"snapshot_keep,omitzero" json:"snapshot_keep,omitempty"`               // Automatic snapshots to retain (0 = keep all)
 }
 
 // SetDefaults sets default values for missing configuration fields
@@ -71,12 +210,60 @@ func (c *Config) SetDefaults() {
 	if c.Dashboard.IAPAuth == "" {
 		c.Dashboard.IAPAuth = "none"
 	}
+	if len(c.Dashboard.TrustedProxies) == 0 {
+		c.Dashboard.TrustedProxies = []string{"127.0.0.1", "::1"}
+	}
 	if c.Dashboard.SessionKeyFile == "" {
 		c.Dashboard.SessionKeyFile = "sessionkey.conf"
 	}
 	if c.Dashboard.PasswordFile == "" {
 		c.Dashboard.PasswordFile = "passwd.conf"
 	}
+	if c.Dashboard.DefaultRole == "" {
+		c.Dashboard.DefaultRole = "operator"
+	}
+	if c.Dashboard.TokensFile == "" {
+		c.Dashboard.TokensFile = "api-tokens.json"
+	}
+	if c.Dashboard.SessionsFile == "" {
+		c.Dashboard.SessionsFile = "sessions.json"
+	}
+	if c.Dashboard.AccessTokenLifetime == 0 {
+		c.Dashboard.AccessTokenLifetime = 15
+	}
+	if c.Dashboard.SessionLifetime == 0 {
+		c.Dashboard.SessionLifetime = 30
+	}
+	if c.Dashboard.LoginMaxFailures == 0 {
+		c.Dashboard.LoginMaxFailures = 10
+	}
+	if c.Dashboard.LoginLockout == 0 {
+		c.Dashboard.LoginLockout = 15
+	}
+	if c.Dashboard.ContentSecurityPolicy == "" {
+		c.Dashboard.ContentSecurityPolicy = DefaultContentSecurityPolicy
+	}
+	if c.Dashboard.TwoFactorFile == "" {
+		c.Dashboard.TwoFactorFile = "two-factor.json"
+	}
+	if c.Dashboard.APIServersFile == "" {
+		c.Dashboard.APIServersFile = "api-servers.toml"
+	}
+	if c.Dashboard.WebhooksFile == "" {
+		c.Dashboard.WebhooksFile = "webhooks.json"
+	}
+	if c.Dashboard.WebhookDeadLetterFile == "" {
+		c.Dashboard.WebhookDeadLetterFile = "webhook-dead-letters.log"
+	}
+	if c.Dashboard.AuditFile == "" {
+		c.Dashboard.AuditFile = "audit.log"
+	}
+	if c.Dashboard.AuditMaxSize == 0 {
+		c.Dashboard.AuditMaxSize = 10
+	}
+	if c.Dashboard.AuditMaxFiles == 0 {
+		c.Dashboard.AuditMaxFiles = 5
+	}
 
 	// Set system monitoring defaults
 	if c.Dashboard.SystemCheckInterval == 0 {
@@ -97,12 +284,35 @@ func (c *Config) SetDefaults() {
 		c.Dashboard.MetricsFlushInterval = 300 // 5 minutes
 	}
 
+	// Set wake-on-demand proxy defaults
+	if c.Dashboard.ProxyWakeTimeout == 0 {
+		c.Dashboard.ProxyWakeTimeout = 180 // 3 minutes
+	}
+	if c.Dashboard.ProxyIdleTimeout == 0 {
+		c.Dashboard.ProxyIdleTimeout = 1800 // 30 minutes
+	}
+
+	// Set group action defaults
+	if c.Dashboard.GroupConcurrency == 0 {
+		c.Dashboard.GroupConcurrency = 4
+	}
+
 	for i := range c.Servers {
-		if c.Servers[i].SSHUser == "" {
-			c.Servers[i].SSHUser = "root"
-		}
-		if c.Servers[i].SSHPort == 0 {
-			c.Servers[i].SSHPort = 22
-		}
+		c.Servers[i].SetDefaults()
+	}
+	c.Alerts.SetDefaults()
+	c.MQTT.SetDefaults()
+	c.OIDC.SetDefaults()
+	c.TLS.SetDefaults()
+	c.Secrets.SetDefaults()
+}
+
+// SetDefaults sets default values for missing server fields
+func (s *ServerConfig) SetDefaults() {
+	if s.SSHUser == "" {
+		s.SSHUser = "root"
+	}
+	if s.SSHPort == 0 {
+		s.SSHPort = 22
 	}
 }
@@ -68,6 +68,7 @@ func TestConfigValidation(t *testing.T) {
 			WoLRetryInterval: 10,
 			WoLMaxRetries:    5,
 			LogLevel:         "info",
+			IAPAuth:          "none",
 		},
 		Servers: []ServerConfig{
 			{
@@ -94,6 +95,7 @@ func TestInvalidMACAddress(t *testing.T) {
 			WoLRetryInterval: 10,
 			WoLMaxRetries:    5,
 			LogLevel:         "info",
+			IAPAuth:          "none",
 		},
 		Servers: []ServerConfig{
 			{
@@ -111,3 +113,399 @@ func TestInvalidMACAddress(t *testing.T) {
 		t.Error("Expected validation error for invalid MAC address")
 	}
 }
+
+func TestInvalidVMPolicy(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		Servers: []ServerConfig{
+			{
+				ID:         "proxmox",
+				Name:       "Proxmox Host",
+				Hostname:   "192.168.1.100",
+				MACAddress: "AA:BB:CC:DD:EE:FF",
+				SSHUser:    "root",
+				SSHPort:    22,
+				VMPolicies: []VMPolicyConfig{
+					{VMID: 101, SuspendMode: "hibernate", SnapshotBeforeStop: true},
+				},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid VM policy failed validation: %v", err)
+	}
+
+	cfg.Servers[0].VMPolicies = append(cfg.Servers[0].VMPolicies, VMPolicyConfig{VMID: 102, SuspendMode: "sleep"})
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid suspend mode")
+	}
+
+	cfg.Servers[0].VMPolicies[1] = VMPolicyConfig{VMID: 101}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for duplicate vmid")
+	}
+}
+
+func TestServiceProxyValidation(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		Servers: []ServerConfig{
+			{
+				ID:         "nas",
+				Name:       "NAS",
+				Hostname:   "192.168.1.100",
+				MACAddress: "AA:BB:CC:DD:EE:FF",
+				SSHUser:    "root",
+				SSHPort:    22,
+				Services: []ServiceConfig{
+					{Name: "Jellyfin", Port: 8096, ProxyPort: 18096, ProxyMode: "http"},
+					{Name: "SMB", Port: 445, ProxyPort: 1445},
+				},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid proxy configuration failed validation: %v", err)
+	}
+
+	cfg.Servers[0].Services[1].ProxyPort = 18096
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for duplicate proxy port")
+	}
+
+	cfg.Servers[0].Services[1].ProxyPort = 8080
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for proxy port used by the dashboard")
+	}
+
+	cfg.Servers[0].Services[1] = ServiceConfig{Name: "SMB", Port: 445, ProxyPort: 1445, ProxyMode: "udp"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid proxy mode")
+	}
+}
+
+func TestCircularDependency(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		Servers: []ServerConfig{
+			{
+				ID:         "nas",
+				Name:       "NAS",
+				Hostname:   "192.168.1.10",
+				MACAddress: "AA:BB:CC:DD:EE:01",
+				SSHUser:    "root",
+				SSHPort:    22,
+			},
+			{
+				ID:             "app",
+				Name:           "App",
+				Hostname:       "192.168.1.11",
+				MACAddress:     "AA:BB:CC:DD:EE:02",
+				ParentServerID: "nas",
+				SSHUser:        "root",
+				SSHPort:        22,
+				Dependencies:   []DependencyConfig{{Server: "nas", Port: 2049}},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid dependencies failed validation: %v", err)
+	}
+
+	// nas -> app -> nas through a declared dependency and a parent reference
+	cfg.Servers[0].Dependencies = []DependencyConfig{{Server: "app", Type: "soft"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for circular dependency")
+	}
+}
+
+func TestServerGroupValidation(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		Servers: []ServerConfig{
+			{
+				ID:         "nas",
+				Name:       "NAS",
+				Hostname:   "192.168.1.10",
+				MACAddress: "AA:BB:CC:DD:EE:01",
+				SSHUser:    "root",
+				SSHPort:    22,
+				Groups:     []string{"media", "k8s-workers"},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid groups failed validation: %v", err)
+	}
+
+	cfg.Servers[0].Groups = []string{"Media Servers"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid group name")
+	}
+	cfg.Servers[0].Groups = nil
+
+	// 0 leaves the concurrency to the default
+	for concurrency, valid := range map[int]bool{0: true, 64: true, 65: false, -1: false} {
+		cfg.Dashboard.GroupConcurrency = concurrency
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("Expected group concurrency %d valid=%t, got %v", concurrency, valid, err)
+		}
+	}
+}
+
+func TestHealthCheckValidation(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		Servers: []ServerConfig{
+			{
+				ID:         "nas",
+				Name:       "NAS",
+				Hostname:   "192.168.1.100",
+				MACAddress: "AA:BB:CC:DD:EE:FF",
+				SSHUser:    "root",
+				SSHPort:    22,
+				Services: []ServiceConfig{
+					{Name: "Jellyfin", Port: 8096, Check: &HealthCheckConfig{Type: "http", Path: "/health", ExpectBody: "Healthy"}},
+					{Name: "DNS", Port: 53, Check: &HealthCheckConfig{Type: "dns", Query: "nas.lan"}},
+				},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid health checks failed validation: %v", err)
+	}
+
+	cfg.Servers[0].Services[1].Check = &HealthCheckConfig{Type: "dns"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for dns check without a query")
+	}
+
+	cfg.Servers[0].Services[1].Check = &HealthCheckConfig{Type: "ping"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for invalid check type")
+	}
+
+	cfg.Servers[0].Services[1] = ServiceConfig{Name: "Jellyfin", Port: 8920}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for duplicate service name")
+	}
+}
+
+func TestAlertsValidation(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		Alerts: AlertsConfig{
+			Notifiers: []NotifierConfig{
+				{Name: "phone", Type: "ntfy", URL: "https://ntfy.sh/ecobox"},
+				{Name: "mail", Type: "email", SMTPHost: "mail.lan", From: "ecobox@lan", To: []string{"admin@lan"}},
+			},
+			Rules: []AlertRuleConfig{
+				{Name: "nas-smb", Condition: "service_down", Servers: []string{"nas"}, Services: []string{"SMB"}, Threshold: 3, Notifiers: []string{"phone", "mail"}},
+			},
+		},
+	}
+	cfg.SetDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid alerts failed validation: %v", err)
+	}
+	if cfg.Alerts.Notifiers[1].SMTPPort != 587 || cfg.Alerts.Rules[0].Recovery != 1 || cfg.Alerts.Rules[0].Severity != "warning" {
+		t.Errorf("Alert defaults not applied: %+v", cfg.Alerts)
+	}
+
+	cfg.Alerts.Rules[0].Notifiers = []string{"pager"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for unknown notifier")
+	}
+
+	cfg.Alerts.Rules[0].Notifiers = []string{"phone"}
+	cfg.Alerts.Rules[0].Condition = "wake_failure"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a service filter on a server condition")
+	}
+
+	cfg.Alerts.Rules[0].Condition = "service_down"
+	cfg.Alerts.Notifiers[0].URL = "ftp://ntfy.sh/ecobox"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for notifier URL scheme")
+	}
+}
+
+func TestAuthProviderValidation(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		OIDC: OIDCConfig{
+			Issuer:   "https://auth.example.com/application/o/ecobox/",
+			ClientID: "ecobox",
+		},
+	}
+	cfg.SetDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid OIDC settings failed validation: %v", err)
+	}
+	if cfg.OIDC.UsernameClaim != "preferred_username" || len(cfg.OIDC.Scopes) != 3 || len(cfg.Dashboard.TrustedProxies) != 2 {
+		t.Errorf("OIDC defaults not applied: %+v", cfg.OIDC)
+	}
+
+	cfg.OIDC.Scopes = []string{"profile"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for scopes without openid")
+	}
+
+	cfg.OIDC.Scopes = []string{"openid"}
+	cfg.Dashboard.TrustedProxies = []string{"proxy.lan"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a trusted proxy that is not an address")
+	}
+
+	cfg.Dashboard.TrustedProxies = []string{"100.64.0.0/10"}
+	cfg.Dashboard.IAPAuth = "cloudflare"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for Cloudflare Access without a team domain and audience")
+	}
+	cfg.Dashboard.CloudflareTeamDomain = "example.cloudflareaccess.com"
+	cfg.Dashboard.CloudflareAudience = "4714c1358e65fe4b408ad6d432a5f878f08194bdb4752441fd56faefa9b2b6f2"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid Cloudflare Access settings failed validation: %v", err)
+	}
+}
+
+func TestTLSValidation(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		TLS: TLSConfig{Enabled: true},
+	}
+	cfg.SetDefaults()
+
+	if err := cfg.Validate(); err != nil || cfg.TLS.Mode() != "self-signed" || cfg.TLS.Port != 8443 {
+		t.Errorf("Expected a valid self-signed setup on port 8443, got %s %d (%v)", cfg.TLS.Mode(), cfg.TLS.Port, err)
+	}
+
+	cfg.TLS.Port = 8080
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for HTTPS on the dashboard port")
+	}
+
+	cfg.TLS.Port = 8443
+	cfg.TLS.CertFile = "/etc/ecobox/cert.pem"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for cert_file without key_file")
+	}
+
+	cfg.TLS.CertFile = ""
+	cfg.TLS.ACME.Domains = []string{"ecobox.example.com"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for ACME without a DNS provider")
+	}
+	cfg.TLS.ACME.DNS = DNSProviderConfig{Type: "cloudflare", APIToken: "token"}
+	if err := cfg.Validate(); err != nil || cfg.TLS.Mode() != "acme" {
+		t.Errorf("Valid ACME settings failed validation: %v", err)
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	cfg := &Config{
+		Dashboard: DashboardConfig{
+			Port:             8080,
+			UpdateInterval:   30,
+			WoLRetryInterval: 10,
+			WoLMaxRetries:    5,
+			LogLevel:         "info",
+			IAPAuth:          "none",
+		},
+		Servers: []ServerConfig{
+			{ID: "pve", Name: "PVE", Hostname: "192.168.1.10", MACAddress: "AA:BB:CC:DD:EE:FF", ProxmoxToken: "secret:pve-token"},
+		},
+		MQTT: MQTTConfig{Password: "secret:mqtt-password"},
+		OIDC: OIDCConfig{ClientSecret: "plain-client-secret"},
+	}
+	cfg.SetDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Valid secret references failed validation: %v", err)
+	}
+	refs := cfg.SecretRefs()
+	if len(refs) != 2 || refs["pve-token"][0] != "servers.pve.proxmox_token" || refs["mqtt-password"][0] != "mqtt.password" {
+		t.Errorf("Unexpected secret references: %v", refs)
+	}
+
+	redacted := cfg.Redacted()
+	if redacted.OIDC.ClientSecret != RedactedValue || redacted.MQTT.Password != "secret:mqtt-password" {
+		t.Errorf("Expected literal credentials redacted and references kept, got %q and %q", redacted.OIDC.ClientSecret, redacted.MQTT.Password)
+	}
+	if cfg.OIDC.ClientSecret != "plain-client-secret" {
+		t.Error("Expected redaction to leave the configuration unchanged")
+	}
+
+	cfg.Servers[0].ProxmoxToken = "root@pam!ecobox=0123-4567"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for a literal proxmox_token")
+	}
+	cfg.Servers[0].ProxmoxToken = ""
+	cfg.MQTT.Password = "secret:bad name"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected validation error for an invalid secret name")
+	}
+}
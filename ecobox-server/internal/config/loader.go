@@ -1,12 +1,15 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
 
+	"ecobox-server/internal/models"
 	"github.com/BurntSushi/toml"
 )
 
@@ -35,8 +38,24 @@ func LoadConfig(filepath string) (*Config, error) {
 	return &config, nil
 }
 
+// Encode writes the configuration as TOML, including defaulted values
+func (c *Config) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
 // Validate validates the configuration for correctness
 func (c *Config) Validate() error {
+	return c.ValidateWithKnownServers(nil)
+}
+
+// ValidateWithKnownServers validates the configuration, also accepting parent and
+// dependency references to servers that exist outside it, such as discovered
+// Proxmox VMs. Servers in the configuration must not reuse their IDs.
+func (c *Config) ValidateWithKnownServers(known map[string]bool) error {
 	// Validate dashboard configuration
 	if c.Dashboard.Port < 1 || c.Dashboard.Port > 65535 {
 		return fmt.Errorf("dashboard port must be between 1 and 65535, got %d", c.Dashboard.Port)
@@ -68,9 +87,72 @@ func (c *Config) Validate() error {
 	if !validIAPAuth[c.Dashboard.IAPAuth] {
 		return fmt.Errorf("invalid iap_auth '%s', must be one of: none, tailscale, authentik, cloudflare", c.Dashboard.IAPAuth)
 	}
+	for _, proxy := range c.Dashboard.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("invalid trusted_proxies entry '%s', must be an IP address or CIDR", proxy)
+			}
+		}
+	}
+	if c.Dashboard.IAPAuth == "cloudflare" {
+		if c.Dashboard.CloudflareTeamDomain == "" || c.Dashboard.CloudflareAudience == "" {
+			return fmt.Errorf("iap_auth 'cloudflare' needs cloudflare_team_domain and cloudflare_audience to verify Access tokens")
+		}
+		if strings.Contains(c.Dashboard.CloudflareTeamDomain, "/") {
+			return fmt.Errorf("invalid cloudflare_team_domain '%s', must be a host name such as example.cloudflareaccess.com", c.Dashboard.CloudflareTeamDomain)
+		}
+	}
+
+	// Validate the default role of new users (empty is treated as "operator")
+	validRoles := map[string]bool{"": true, "viewer": true, "operator": true, "admin": true}
+	if !validRoles[c.Dashboard.DefaultRole] {
+		return fmt.Errorf("invalid default_role '%s', must be one of: viewer, operator, admin", c.Dashboard.DefaultRole)
+	}
+
+	if c.Dashboard.AccessTokenLifetime < 0 || c.Dashboard.SessionLifetime < 0 {
+		return fmt.Errorf("access_token_lifetime and session_lifetime cannot be negative")
+	}
+
+	if err := validateOrigins("webauthn_origins", c.Dashboard.WebAuthnOrigins); err != nil {
+		return err
+	}
+	if err := validateOrigins("cors_origins", c.Dashboard.CORSOrigins); err != nil {
+		return err
+	}
+	if c.Dashboard.LoginMaxFailures < 0 || c.Dashboard.LoginLockout < 0 {
+		return fmt.Errorf("login_max_failures and login_lockout cannot be negative")
+	}
+
+	if c.Dashboard.ProxyWakeTimeout < 0 || c.Dashboard.ProxyIdleTimeout < 0 {
+		return fmt.Errorf("proxy timeouts cannot be negative")
+	}
+
+	// The idle timeout becomes a keep-awake lease, which is capped at 24 hours
+	if c.Dashboard.ProxyIdleTimeout > 86400 {
+		return fmt.Errorf("proxy idle timeout cannot exceed 86400 seconds, got %d", c.Dashboard.ProxyIdleTimeout)
+	}
+
+	if err := validateDiscoveryNetworks(c.Dashboard.DiscoveryNetworks); err != nil {
+		return err
+	}
+	if c.Dashboard.DiscoveryInterval < 0 {
+		return fmt.Errorf("discovery interval cannot be negative, got %d", c.Dashboard.DiscoveryInterval)
+	}
+
+	if c.Dashboard.GroupConcurrency < 0 || c.Dashboard.GroupConcurrency > 64 {
+		return fmt.Errorf("group concurrency must be between 1 and 64, or 0 for the default, got %d", c.Dashboard.GroupConcurrency)
+	}
+
+	if c.Dashboard.AuditMaxSize < 0 || c.Dashboard.AuditMaxFiles < 0 {
+		return fmt.Errorf("audit_max_size and audit_max_files cannot be negative")
+	}
 
 	// Validate servers
 	serverIDs := make(map[string]bool)
+	for id := range known {
+		serverIDs[id] = true
+	}
+	proxyPorts := map[int]string{c.Dashboard.Port: "the dashboard"}
 	for _, server := range c.Servers {
 		if server.ID == "" {
 			return fmt.Errorf("server ID cannot be empty")
@@ -99,14 +181,39 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("SSH port must be between 1 and 65535 for server %s, got %d", server.ID, server.SSHPort)
 		}
 
+		// Credentials are only referred to by servers, and kept in the vault
+		if server.ProxmoxToken != "" && !IsSecretRef(server.ProxmoxToken) {
+			return fmt.Errorf("proxmox_token for server %s must be a secret reference such as secret:%s-token", server.ID, server.ID)
+		}
+
 		// Validate services
+		serviceNames := make(map[string]bool)
 		for _, service := range server.Services {
 			if service.Name == "" {
 				return fmt.Errorf("service name cannot be empty for server %s", server.ID)
 			}
+			if serviceNames[service.Name] {
+				return fmt.Errorf("duplicate service name %s for server %s", service.Name, server.ID)
+			}
+			serviceNames[service.Name] = true
 			if service.Port < 1 || service.Port > 65535 {
 				return fmt.Errorf("service port must be between 1 and 65535 for server %s service %s, got %d", server.ID, service.Name, service.Port)
 			}
+			if err := validateServiceProxy(server.ID, service, proxyPorts); err != nil {
+				return err
+			}
+			if err := validateHealthCheck(server.ID, service); err != nil {
+				return err
+			}
+		}
+
+		// Validate VM power policies
+		if err := validateVMPolicies(server); err != nil {
+			return err
+		}
+
+		if _, err := models.NormalizeGroups(server.Groups); err != nil {
+			return fmt.Errorf("server %s: %w", server.ID, err)
 		}
 	}
 
@@ -115,6 +222,157 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	// Validate dependencies, including cycles through parents
+	if err := c.validateDependencies(serverIDs); err != nil {
+		return err
+	}
+
+	// Validate alert rules and notifiers
+	if err := c.Alerts.Validate(); err != nil {
+		return err
+	}
+
+	// Validate the MQTT bridge
+	if err := c.MQTT.Validate(); err != nil {
+		return err
+	}
+
+	// Validate the OIDC provider
+	if err := c.OIDC.Validate(); err != nil {
+		return err
+	}
+
+	// Validate HTTPS, which needs its own port
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+	if c.TLS.Enabled && c.TLS.Port == c.Dashboard.Port {
+		return fmt.Errorf("tls port must differ from the dashboard port")
+	}
+
+	// Validate the secret vault and the references to its secrets
+	if err := c.Secrets.Validate(); err != nil {
+		return err
+	}
+	if err := c.validateSecretRefs(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateVMPolicies validates the per-VM power policies of a Proxmox host
+func validateVMPolicies(server ServerConfig) error {
+	validSuspendModes := map[string]bool{
+		"": true, "pause": true, "hibernate": true, "shutdown": true, "stop": true,
+	}
+	vmids := make(map[int]bool)
+	for _, policy := range server.VMPolicies {
+		if policy.VMID < 1 {
+			return fmt.Errorf("VM policy for server %s must have a positive vmid, got %d", server.ID, policy.VMID)
+		}
+		if vmids[policy.VMID] {
+			return fmt.Errorf("duplicate VM policy for vmid %d on server %s", policy.VMID, server.ID)
+		}
+		vmids[policy.VMID] = true
+
+		if !validSuspendModes[policy.SuspendMode] {
+			return fmt.Errorf("invalid suspend_mode '%s' for vmid %d on server %s, must be one of: pause, hibernate, shutdown, stop", policy.SuspendMode, policy.VMID, server.ID)
+		}
+		if policy.SnapshotKeep < 0 {
+			return fmt.Errorf("snapshot_keep cannot be negative for vmid %d on server %s", policy.VMID, server.ID)
+		}
+	}
+	return nil
+}
+
+// validateServiceProxy validates the wake-on-demand proxy settings of a service.
+// usedPorts tracks the listen ports already taken across the configuration.
+func validateServiceProxy(serverID string, service ServiceConfig, usedPorts map[int]string) error {
+	if service.ProxyPort == 0 {
+		if service.ProxyMode != "" {
+			return fmt.Errorf("proxy_mode requires proxy_port for server %s service %s", serverID, service.Name)
+		}
+		return nil
+	}
+
+	if service.ProxyPort < 1 || service.ProxyPort > 65535 {
+		return fmt.Errorf("proxy port must be between 1 and 65535 for server %s service %s, got %d", serverID, service.Name, service.ProxyPort)
+	}
+	if owner, exists := usedPorts[service.ProxyPort]; exists {
+		return fmt.Errorf("proxy port %d for server %s service %s is already used by %s", service.ProxyPort, serverID, service.Name, owner)
+	}
+	usedPorts[service.ProxyPort] = fmt.Sprintf("server %s service %s", serverID, service.Name)
+
+	if service.ProxyMode != "" && service.ProxyMode != "tcp" && service.ProxyMode != "http" {
+		return fmt.Errorf("invalid proxy_mode '%s' for server %s service %s, must be one of: tcp, http", service.ProxyMode, serverID, service.Name)
+	}
+
+	return nil
+}
+
+// validateHealthCheck validates the health check of a service
+func validateHealthCheck(serverID string, service ServiceConfig) error {
+	check := service.Check
+	if check == nil {
+		return nil
+	}
+
+	switch models.HealthCheckType(check.Type) {
+	case models.HealthCheckTCP, models.HealthCheckSSH, models.HealthCheckSMB, models.HealthCheckNFS:
+	case models.HealthCheckHTTP, models.HealthCheckHTTPS:
+		if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+			return fmt.Errorf("check path must start with / for server %s service %s", serverID, service.Name)
+		}
+		if check.ExpectStatus != 0 && (check.ExpectStatus < 100 || check.ExpectStatus > 599) {
+			return fmt.Errorf("check expect_status must be between 100 and 599 for server %s service %s, got %d", serverID, service.Name, check.ExpectStatus)
+		}
+	case models.HealthCheckDNS:
+		if check.Query == "" {
+			return fmt.Errorf("dns check requires a query for server %s service %s", serverID, service.Name)
+		}
+	case models.HealthCheckCommand:
+		if check.Command == "" {
+			return fmt.Errorf("command check requires a command for server %s service %s", serverID, service.Name)
+		}
+	default:
+		return fmt.Errorf("invalid check type '%s' for server %s service %s, must be one of: tcp, http, https, ssh, dns, smb, nfs, command", check.Type, serverID, service.Name)
+	}
+
+	if check.Timeout < 0 || check.Timeout > 60 {
+		return fmt.Errorf("check timeout must be between 0 and 60 seconds for server %s service %s, got %d", serverID, service.Name, check.Timeout)
+	}
+	return nil
+}
+
+// validateDiscoveryNetworks checks that each discovery network is an IPv4 CIDR of
+// at most 1024 addresses
+func validateDiscoveryNetworks(networks []string) error {
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return fmt.Errorf("invalid discovery network '%s': %w", network, err)
+		}
+		ones, bits := ipNet.Mask.Size()
+		if bits != 32 {
+			return fmt.Errorf("discovery network '%s' must be IPv4", network)
+		}
+		if ones < 22 {
+			return fmt.Errorf("discovery network '%s' is too large, use /22 or smaller", network)
+		}
+	}
+	return nil
+}
+
+// validateOrigins checks that each entry of an origin list is a scheme and host
+// without a path
+func validateOrigins(key string, origins []string) error {
+	for _, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid %s entry '%s', must be like https://ecobox.example.com", key, origin)
+		}
+	}
 	return nil
 }
 
@@ -181,3 +439,61 @@ func (c *Config) checkCircularReference(originalID, currentParentID string, visi
 
 	return nil
 }
+
+// validateDependencies checks dependency targets and rejects cycles across both
+// parent references and declared dependencies
+func (c *Config) validateDependencies(serverIDs map[string]bool) error {
+	edges := make(map[string][]string)
+	for _, server := range c.Servers {
+		if server.ParentServerID != "" {
+			edges[server.ID] = append(edges[server.ID], server.ParentServerID)
+		}
+
+		for _, dep := range server.Dependencies {
+			if !serverIDs[dep.Server] {
+				return fmt.Errorf("dependency '%s' not found for server '%s'", dep.Server, server.ID)
+			}
+			if dep.Server == server.ID {
+				return fmt.Errorf("server '%s' cannot depend on itself", server.ID)
+			}
+			if dep.Port < 0 || dep.Port > 65535 {
+				return fmt.Errorf("dependency port must be between 1 and 65535 for server %s, got %d", server.ID, dep.Port)
+			}
+			if dep.Type != "" && dep.Type != "hard" && dep.Type != "soft" {
+				return fmt.Errorf("invalid dependency type '%s' for server %s, must be one of: hard, soft", dep.Type, server.ID)
+			}
+			edges[server.ID] = append(edges[server.ID], dep.Server)
+		}
+	}
+
+	// Depth-first search; a server reached again while still on the stack closes a cycle
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("circular dependency detected involving server '%s'", id)
+		case done:
+			return nil
+		}
+		state[id] = visiting
+		for _, next := range edges[id] {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[id] = done
+		return nil
+	}
+
+	for _, server := range c.Servers {
+		if err := visit(server.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MQTTConfig defines the MQTT bridge that publishes servers to Home Assistant
+type MQTTConfig struct {
+	Broker          string `toml:"broker"`    // tcp://host:1883 or tls://host:8883 (empty disables the bridge)
+	ClientID        string `toml:"client_id"` // Default: "ecobox"
+	Username        string `toml:"username,omitempty"`
+	Password        string `toml:"password,omitempty"`
+	TopicPrefix     string `toml:"topic_prefix"`     // State and command topics (default: "ecobox")
+	DiscoveryPrefix string `toml:"discovery_prefix"` // Home Assistant discovery prefix (default: "homeassistant")
+	KeepAlive       int    `toml:"keep_alive"`       // Seconds (default: 60)
+	ReadOnly        bool   `toml:"read_only"`        // Publish only; ignore commands
+}
+
+// Enabled reports whether a broker is configured
+func (m *MQTTConfig) Enabled() bool {
+	return m.Broker != ""
+}
+
+// SetDefaults sets default values for missing MQTT fields
+func (m *MQTTConfig) SetDefaults() {
+	if m.ClientID == "" {
+		m.ClientID = "ecobox"
+	}
+	if m.TopicPrefix == "" {
+		m.TopicPrefix = "ecobox"
+	}
+	if m.DiscoveryPrefix == "" {
+		m.DiscoveryPrefix = "homeassistant"
+	}
+	if m.KeepAlive == 0 {
+		m.KeepAlive = 60
+	}
+}
+
+// Validate checks the broker address and topic prefixes
+func (m *MQTTConfig) Validate() error {
+	if !m.Enabled() {
+		return nil
+	}
+
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid mqtt broker '%s'", m.Broker)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "tls", "ssl", "mqtts":
+	default:
+		return fmt.Errorf("mqtt broker must use one of: tcp, tls, mqtt, mqtts")
+	}
+
+	for name, prefix := range map[string]string{"topic_prefix": m.TopicPrefix, "discovery_prefix": m.DiscoveryPrefix} {
+		if prefix == "" || strings.ContainsAny(prefix, "+#") || strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+			return fmt.Errorf("invalid mqtt %s '%s': must not be empty, contain wildcards or start or end with /", name, prefix)
+		}
+	}
+	if m.KeepAlive < 5 || m.KeepAlive > 65535 {
+		return fmt.Errorf("mqtt keep_alive must be between 5 and 65535 seconds")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OIDCConfig defines the OpenID Connect provider users can log in with
+type OIDCConfig struct {
+	Issuer        string   `toml:"issuer"`    // Issuer URL, such as "https://auth.example.com/application/o/ecobox/" (empty disables OIDC login)
+	ClientID      string   `toml:"client_id"` // Client registered with the provider
+	ClientSecret  string   `toml:"client_secret,omitempty"`
+	RedirectURL   string   `toml:"redirect_url,omitempty"` // Default: /login/oidc/callback on the host the dashboard is reached on
+	Scopes        []string `toml:"scopes"`                 // Default: ["openid", "profile", "email"]
+	UsernameClaim string   `toml:"username_claim"`         // Claim used as the username (default: "preferred_username", then "email")
+	GroupsClaim   string   `toml:"groups_claim"`           // Claim listing the user's groups (default: "groups")
+	ButtonLabel   string   `toml:"button_label"`           // Login page button (default: "Sign in with SSO")
+
+	// Users the provider vouches for are created on their first login. Without
+	// it, an admin creates them first.
+	AutoProvision bool `toml:"auto_provision"`
+
+	// Groups that set the role of a user on every login; the highest matching
+	// role wins. Users in none of them keep their role, or get default_role.
+	AdminGroups    []string `toml:"admin_groups,omitempty"`
+	OperatorGroups []string `toml:"operator_groups,omitempty"`
+	ViewerGroups   []string `toml:"viewer_groups,omitempty"`
+
+	// When set, only members of these groups or of the role groups may log in
+	AllowedGroups []string `toml:"allowed_groups,omitempty"`
+}
+
+// Enabled reports whether an OIDC provider is configured
+func (o *OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// SetDefaults sets default values for missing OIDC fields
+func (o *OIDCConfig) SetDefaults() {
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"openid", "profile", "email"}
+	}
+	if o.UsernameClaim == "" {
+		o.UsernameClaim = "preferred_username"
+	}
+	if o.GroupsClaim == "" {
+		o.GroupsClaim = "groups"
+	}
+	if o.ButtonLabel == "" {
+		o.ButtonLabel = "Sign in with SSO"
+	}
+}
+
+// Validate checks the issuer, client and redirect URL
+func (o *OIDCConfig) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+
+	u, err := url.Parse(o.Issuer)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("invalid oidc issuer '%s', must be an http(s) URL", o.Issuer)
+	}
+	if o.ClientID == "" {
+		return fmt.Errorf("oidc client_id is required")
+	}
+	if o.RedirectURL != "" {
+		u, err := url.Parse(o.RedirectURL)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("invalid oidc redirect_url '%s', must be an http(s) URL", o.RedirectURL)
+		}
+	}
+
+	hasOpenID := false
+	for _, scope := range o.Scopes {
+		if scope == "openid" {
+			hasOpenID = true
+		}
+		if scope == "" || strings.ContainsAny(scope, " \t") {
+			return fmt.Errorf("invalid oidc scope '%s'", scope)
+		}
+	}
+	if !hasOpenID {
+		return fmt.Errorf("oidc scopes must include openid")
+	}
+	return nil
+}
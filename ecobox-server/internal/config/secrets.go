@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SecretRefPrefix starts a reference to a secret in the vault, such as
+// "secret:mqtt-password". Credentials in the configuration may be references
+// instead of values.
+const SecretRefPrefix = "secret:"
+
+// RedactedValue replaces credentials in exported configurations
+const RedactedValue = "********"
+
+// secretNamePattern allows names such as "pve-token" or "smtp.password"
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// envNamePattern allows environment variable names
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretsConfig defines the encrypted vault credentials are kept in
+type SecretsConfig struct {
+	File    string `toml:"file"`     // Encrypted vault (default: "secrets.vault")
+	KeyFile string `toml:"key_file"` // Master key, created on first run unless key_env is set (default: "secrets.key")
+	KeyEnv  string `toml:"key_env"`  // Environment variable that holds the master key instead of key_file (default: "ECOBOX_MASTER_KEY")
+}
+
+// SetDefaults sets default values for missing vault fields
+func (s *SecretsConfig) SetDefaults() {
+	if s.File == "" {
+		s.File = "secrets.vault"
+	}
+	if s.KeyFile == "" {
+		s.KeyFile = "secrets.key"
+	}
+	if s.KeyEnv == "" {
+		s.KeyEnv = "ECOBOX_MASTER_KEY"
+	}
+}
+
+// Validate checks the master key variable name, when set
+func (s *SecretsConfig) Validate() error {
+	if s.KeyEnv != "" && !envNamePattern.MatchString(s.KeyEnv) {
+		return fmt.Errorf("invalid secrets key_env '%s', must be an environment variable name", s.KeyEnv)
+	}
+	return nil
+}
+
+// ValidSecretName reports whether a name can be used for a secret
+func ValidSecretName(name string) bool {
+	return secretNamePattern.MatchString(name)
+}
+
+// IsSecretRef reports whether a value refers to a secret in the vault
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretRefPrefix)
+}
+
+// SecretRef returns the reference to a secret
+func SecretRef(name string) string {
+	return SecretRefPrefix + name
+}
+
+// validateSecretRef checks that a value referring to a secret names one
+// properly. Values that are not references are not checked.
+func validateSecretRef(field, value string) error {
+	if !IsSecretRef(value) {
+		return nil
+	}
+	if name := strings.TrimPrefix(value, SecretRefPrefix); !ValidSecretName(name) {
+		return fmt.Errorf("invalid secret reference '%s' in %s: names use letters, digits, '.', '_' and '-'", value, field)
+	}
+	return nil
+}
+
+// credentials calls fn with every field of the configuration that may hold a
+// credential, named as in the TOML file, along with a pointer to its value
+func (c *Config) credentials(fn func(field string, value *string)) {
+	for i := range c.Servers {
+		server := &c.Servers[i]
+		fn(fmt.Sprintf("servers.%s.ssh_key_path", server.ID), &server.SSHKeyPath)
+		fn(fmt.Sprintf("servers.%s.proxmox_token", server.ID), &server.ProxmoxToken)
+	}
+	for i := range c.Alerts.Notifiers {
+		notifier := &c.Alerts.Notifiers[i]
+		fn(fmt.Sprintf("alerts.notifiers.%s.token", notifier.Name), &notifier.Token)
+		fn(fmt.Sprintf("alerts.notifiers.%s.password", notifier.Name), &notifier.Password)
+		eachHeader(notifier.Headers, func(name string, value *string) {
+			fn(fmt.Sprintf("alerts.notifiers.%s.headers.%s", notifier.Name, name), value)
+		})
+	}
+	fn("mqtt.password", &c.MQTT.Password)
+	fn("oidc.client_secret", &c.OIDC.ClientSecret)
+	fn("tls.acme.dns.api_token", &c.TLS.ACME.DNS.APIToken)
+	eachHeader(c.TLS.ACME.DNS.Headers, func(name string, value *string) {
+		fn("tls.acme.dns.headers."+name, value)
+	})
+}
+
+// eachHeader calls fn with every header of a map, in name order, and stores
+// the value fn leaves behind
+func eachHeader(headers map[string]string, fn func(name string, value *string)) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := headers[name]
+		fn(name, &value)
+		headers[name] = value
+	}
+}
+
+// validateSecretRefs checks every secret reference in the configuration
+func (c *Config) validateSecretRefs() error {
+	var err error
+	c.credentials(func(field string, value *string) {
+		if err == nil {
+			err = validateSecretRef(field, *value)
+		}
+	})
+	return err
+}
+
+// SecretRefs returns the names of the secrets the configuration refers to,
+// each with the fields that refer to it
+func (c *Config) SecretRefs() map[string][]string {
+	refs := make(map[string][]string)
+	c.credentials(func(field string, value *string) {
+		if IsSecretRef(*value) {
+			name := strings.TrimPrefix(*value, SecretRefPrefix)
+			refs[name] = append(refs[name], field)
+		}
+	})
+	return refs
+}
+
+// Redacted returns a copy of the configuration with credentials replaced by
+// RedactedValue. Secret references and SSH key paths are kept, as they reveal
+// nothing.
+func (c *Config) Redacted() *Config {
+	redacted := c.Copy()
+	redacted.Servers = append([]ServerConfig(nil), c.Servers...)
+	redacted.Alerts.Notifiers = append([]NotifierConfig(nil), c.Alerts.Notifiers...)
+	for i := range redacted.Alerts.Notifiers {
+		redacted.Alerts.Notifiers[i].Headers = copyHeaders(c.Alerts.Notifiers[i].Headers)
+	}
+	redacted.TLS.ACME.DNS.Headers = copyHeaders(c.TLS.ACME.DNS.Headers)
+
+	redacted.credentials(func(field string, value *string) {
+		if *value != "" && !IsSecretRef(*value) && !strings.HasSuffix(field, ".ssh_key_path") {
+			*value = RedactedValue
+		}
+	})
+	return redacted
+}
+
+func copyHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(headers))
+	for name, value := range headers {
+		copied[name] = value
+	}
+	return copied
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"ecobox-server/internal/models"
+	"github.com/BurntSushi/toml"
+)
+
+// serverFile is the layout of a file holding server definitions outside the main configuration
+type serverFile struct {
+	Servers []ServerConfig `toml:"servers"`
+}
+
+// ToServer creates the stored server for a definition. The definition must have
+// passed validation.
+func (s ServerConfig) ToServer(source models.Source) *models.Server {
+	services := make([]models.Service, len(s.Services))
+	for i, serviceConfig := range s.Services {
+		serviceType := models.ServiceType(serviceConfig.Type)
+		if serviceType == "" {
+			serviceType = models.DetectServiceType(serviceConfig.Port)
+		}
+
+		services[i] = models.Service{
+			ID:        fmt.Sprintf("%s-%s", s.ID, serviceConfig.Name),
+			ServerID:  s.ID,
+			Name:      serviceConfig.Name,
+			Port:      serviceConfig.Port,
+			Type:      serviceType,
+			Status:    models.ServiceStatusDown, // Will be updated by monitor
+			Source:    source,
+			ProxyPort: serviceConfig.ProxyPort,
+			ProxyMode: proxyMode(serviceConfig.ProxyMode, serviceType),
+			Check:     serviceConfig.Check.toHealthCheck(),
+		}
+	}
+
+	dependencies := make([]models.Dependency, len(s.Dependencies))
+	for i, dep := range s.Dependencies {
+		depType := models.DependencyType(dep.Type)
+		if depType == "" {
+			depType = models.DependencyTypeHard
+		}
+		dependencies[i] = models.Dependency{
+			ServerID: dep.Server,
+			Port:     dep.Port,
+			Type:     depType,
+		}
+	}
+
+	// Groups were checked by validation
+	groups, _ := models.NormalizeGroups(s.Groups)
+
+	return &models.Server{
+		ID:              s.ID,
+		Name:            s.Name,
+		Hostname:        s.Hostname,
+		MACAddress:      s.MACAddress,
+		CurrentState:    models.PowerStateUnknown,
+		DesiredState:    models.PowerStateUnknown,
+		ParentServerID:  s.ParentServerID,
+		Dependencies:    dependencies,
+		Groups:          groups,
+		Services:        services,
+		Source:          source,
+		SSHUser:         s.SSHUser,
+		SSHPort:         s.SSHPort,
+		SSHKeyPath:      s.SSHKeyPath,
+		ProxmoxTokenRef: s.ProxmoxToken,
+		RecentActions:   make([]models.ServerAction, 0),
+		LastStateChange: time.Now(),
+	}
+}
+
+// proxyMode returns the configured proxy mode of a service, defaulting to HTTP for plain HTTP services
+func proxyMode(mode string, serviceType models.ServiceType) models.ProxyMode {
+	if mode != "" {
+		return models.ProxyMode(mode)
+	}
+	if serviceType == models.ServiceTypeHTTP {
+		return models.ProxyModeHTTP
+	}
+	return models.ProxyModeTCP
+}
+
+// toHealthCheck converts a configured health check; nil stays nil
+func (c *HealthCheckConfig) toHealthCheck() *models.HealthCheck {
+	if c == nil {
+		return nil
+	}
+	return &models.HealthCheck{
+		Type:               models.HealthCheckType(c.Type),
+		Path:               c.Path,
+		ExpectStatus:       c.ExpectStatus,
+		ExpectBody:         c.ExpectBody,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		Query:              c.Query,
+		Command:            c.Command,
+		Timeout:            c.Timeout,
+	}
+}
+
+// LoadServerFile reads server definitions from a TOML file with [[servers]]
+// entries. A missing file holds no servers.
+func LoadServerFile(path string) ([]ServerConfig, error) {
+	var file serverFile
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to parse server file %s: %w", path, err)
+	}
+
+	for i := range file.Servers {
+		file.Servers[i].SetDefaults()
+	}
+	return file.Servers, nil
+}
+
+// SaveServerFile atomically replaces a server file with the given definitions
+func SaveServerFile(path string, servers []ServerConfig) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Servers added through the dashboard API. Changes here are read at startup.\n\n")
+	if err := toml.NewEncoder(&buf).Encode(serverFile{Servers: servers}); err != nil {
+		return fmt.Errorf("failed to encode servers: %w", err)
+	}
+
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write temporary server file: %w", err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace server file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultACMEDirectory is Let's Encrypt's production directory
+const DefaultACMEDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+// TLSConfig defines HTTPS for the dashboard. The certificate comes from
+// cert_file and key_file, from ACME when acme.domains is set, or otherwise from
+// a CA the dashboard creates on first run.
+type TLSConfig struct {
+	Enabled     bool     `toml:"enabled"`
+	Port        int      `toml:"port"`         // HTTPS port (default: 8443)
+	DisableHTTP bool     `toml:"disable_http"` // Do not listen on the dashboard port for redirects to HTTPS
+	CertFile    string   `toml:"cert_file"`    // PEM certificate chain, reloaded when it changes
+	KeyFile     string   `toml:"key_file"`     // PEM private key of cert_file
+	Dir         string   `toml:"dir"`          // Where the self-signed CA and ACME certificates are kept (default: "tls")
+	Hostnames   []string `toml:"hostnames"`    // Names and addresses of the self-signed certificate (default: this machine's hostname and localhost)
+
+	ACME ACMEConfig `toml:"acme"`
+}
+
+// ACMEConfig defines certificates issued by an ACME CA with DNS-01 challenges
+type ACMEConfig struct {
+	Domains      []string          `toml:"domains"`       // Names of the certificate (empty disables ACME)
+	Email        string            `toml:"email"`         // Contact for expiry notices
+	DirectoryURL string            `toml:"directory_url"` // Default: Let's Encrypt production
+	CAFile       string            `toml:"ca_file"`       // PEM roots the directory is trusted with, for test CAs such as Pebble
+	RenewBefore  int               `toml:"renew_before"`  // Days before expiry a certificate is renewed (default: 30)
+	DNS          DNSProviderConfig `toml:"dns"`
+}
+
+// DNSProviderConfig defines how DNS-01 challenge records are published
+type DNSProviderConfig struct {
+	Type            string            `toml:"type"`                // "cloudflare", "exec" or "webhook"
+	APIToken        string            `toml:"api_token,omitempty"` // Cloudflare API token with Zone.DNS edit permission
+	ZoneID          string            `toml:"zone_id,omitempty"`   // Cloudflare zone (default: looked up from the domain)
+	Command         string            `toml:"command,omitempty"`   // Run as: command present|cleanup <fqdn> <value>
+	URL             string            `toml:"url,omitempty"`       // Webhook base URL, POSTed {"fqdn", "value"} at /present and /cleanup
+	Headers         map[string]string `toml:"headers,omitempty"`   // Extra webhook headers, such as Authorization
+	PropagationWait int               `toml:"propagation_wait"`    // Seconds to wait for a record to reach the CA's resolvers (default: 30)
+}
+
+// Mode returns where the certificate comes from: "files", "acme" or
+// "self-signed"
+func (t *TLSConfig) Mode() string {
+	switch {
+	case t.CertFile != "":
+		return "files"
+	case len(t.ACME.Domains) > 0:
+		return "acme"
+	}
+	return "self-signed"
+}
+
+// SetDefaults sets default values for missing TLS fields
+func (t *TLSConfig) SetDefaults() {
+	if t.Port == 0 {
+		t.Port = 8443
+	}
+	if t.Dir == "" {
+		t.Dir = "tls"
+	}
+	if t.ACME.DirectoryURL == "" {
+		t.ACME.DirectoryURL = DefaultACMEDirectory
+	}
+	if t.ACME.RenewBefore == 0 {
+		t.ACME.RenewBefore = 30
+	}
+	if t.ACME.DNS.PropagationWait == 0 {
+		t.ACME.DNS.PropagationWait = 30
+	}
+}
+
+// Validate checks the certificate source and ACME settings
+func (t *TLSConfig) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+
+	if t.Port < 1 || t.Port > 65535 {
+		return fmt.Errorf("tls port must be between 1 and 65535")
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls cert_file and key_file must be set together")
+	}
+	if t.CertFile != "" && len(t.ACME.Domains) > 0 {
+		return fmt.Errorf("tls cert_file and acme domains cannot both be set")
+	}
+	for _, hostname := range t.Hostnames {
+		if hostname == "" || strings.ContainsAny(hostname, " /*") {
+			return fmt.Errorf("invalid tls hostname '%s'", hostname)
+		}
+	}
+	if len(t.ACME.Domains) == 0 {
+		return nil
+	}
+	return t.ACME.validate()
+}
+
+// validate checks the ACME directory, domains and DNS provider
+func (a *ACMEConfig) validate() error {
+	u, err := url.Parse(a.DirectoryURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("invalid acme directory_url '%s', must be an http(s) URL", a.DirectoryURL)
+	}
+	for _, domain := range a.Domains {
+		name := strings.TrimPrefix(domain, "*.")
+		if name == "" || !strings.Contains(name, ".") || strings.ContainsAny(name, " /:*") {
+			return fmt.Errorf("invalid acme domain '%s'", domain)
+		}
+	}
+	if a.RenewBefore < 1 {
+		return fmt.Errorf("acme renew_before must be at least 1 day")
+	}
+	if a.DNS.PropagationWait < 0 {
+		return fmt.Errorf("acme dns propagation_wait cannot be negative")
+	}
+
+	switch a.DNS.Type {
+	case "cloudflare":
+		if a.DNS.APIToken == "" {
+			return fmt.Errorf("acme dns type cloudflare needs api_token")
+		}
+	case "exec":
+		if a.DNS.Command == "" {
+			return fmt.Errorf("acme dns type exec needs command")
+		}
+	case "webhook":
+		u, err := url.Parse(a.DNS.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("invalid acme dns url '%s', must be an http(s) URL", a.DNS.URL)
+		}
+	default:
+		return fmt.Errorf("acme dns type must be one of: cloudflare, exec, webhook")
+	}
+	return nil
+}
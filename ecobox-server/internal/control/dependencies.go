@@ -0,0 +1,379 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecobox-server/internal/models"
+)
+
+const (
+	// dependencyReadyTimeout is how long a wake waits for each dependency to become ready
+	dependencyReadyTimeout = 5 * time.Minute
+	// proxmoxAPIPort is the port a Proxmox host must answer on before its VMs can be started
+	proxmoxAPIPort = 8006
+)
+
+// ErrActiveDependents is returned when a server cannot go down because servers
+// that hard-depend on it are still running
+var ErrActiveDependents = errors.New("server has active dependents")
+
+// WakeStep is a dependency to bring up before the server that needs it
+type WakeStep struct {
+	Server *models.Server
+	Ports  []int // Services that must accept connections; empty means the server itself
+	Hard   bool  // False if only reached through soft dependencies
+}
+
+// DependencyGraph answers ordering questions about a snapshot of all servers
+type DependencyGraph struct {
+	servers map[string]*models.Server
+}
+
+// NewDependencyGraph creates a graph over the given servers
+func NewDependencyGraph(servers map[string]*models.Server) *DependencyGraph {
+	return &DependencyGraph{servers: servers}
+}
+
+// WakeOrder returns the transitive dependencies of a server in the order they
+// must be woken, dependencies of dependencies first. The server itself is not included.
+func (g *DependencyGraph) WakeOrder(serverID string) ([]*WakeStep, error) {
+	var order []*WakeStep
+	steps := make(map[string]*WakeStep)
+	onStack := make(map[string]bool)
+
+	var visit func(id string, hard bool) error
+	visit = func(id string, hard bool) error {
+		server, ok := g.servers[id]
+		if !ok {
+			return fmt.Errorf("server %s not found", id)
+		}
+		if onStack[id] {
+			return fmt.Errorf("circular dependency detected involving server %s", server.Name)
+		}
+		onStack[id] = true
+		defer delete(onStack, id)
+
+		for _, dep := range server.GetDependencies() {
+			depHard := hard && dep.IsHard()
+			step, seen := steps[dep.ServerID]
+			if seen {
+				if dep.Port != 0 && !containsPort(step.Ports, dep.Port) {
+					step.Ports = append(step.Ports, dep.Port)
+				}
+				// Already ordered; revisit only to promote a soft path to a hard one
+				if step.Hard || !depHard {
+					continue
+				}
+				step.Hard = true
+			}
+
+			if err := visit(dep.ServerID, depHard); err != nil {
+				return err
+			}
+
+			if !seen {
+				step = &WakeStep{Server: g.servers[dep.ServerID], Hard: depHard}
+				if dep.Port != 0 {
+					step.Ports = []int{dep.Port}
+				}
+				steps[dep.ServerID] = step
+				order = append(order, step)
+			}
+		}
+		return nil
+	}
+
+	if err := visit(serverID, true); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// Batches splits a set of servers into batches for waking, where each server only
+// depends on servers in earlier batches. Dependencies outside the set are ignored,
+// since waking a server brings those up anyway. Servers are sorted by name within a batch.
+func (g *DependencyGraph) Batches(serverIDs []string) ([][]*models.Server, error) {
+	inSet := make(map[string]bool, len(serverIDs))
+	for _, id := range serverIDs {
+		if _, ok := g.servers[id]; !ok {
+			return nil, fmt.Errorf("server %s not found", id)
+		}
+		inSet[id] = true
+	}
+
+	// A server's level is one more than the deepest dependency it has in the set
+	levels := make(map[string]int)
+	onStack := make(map[string]bool)
+	var level func(id string) (int, error)
+	level = func(id string) (int, error) {
+		if l, ok := levels[id]; ok {
+			return l, nil
+		}
+		if onStack[id] {
+			return 0, fmt.Errorf("circular dependency detected involving server %s", g.servers[id].Name)
+		}
+		onStack[id] = true
+		defer delete(onStack, id)
+
+		l := 0
+		for _, dep := range g.servers[id].GetDependencies() {
+			if !inSet[dep.ServerID] {
+				continue
+			}
+			depLevel, err := level(dep.ServerID)
+			if err != nil {
+				return 0, err
+			}
+			if depLevel+1 > l {
+				l = depLevel + 1
+			}
+		}
+		levels[id] = l
+		return l, nil
+	}
+
+	var batches [][]*models.Server
+	for id := range inSet {
+		l, err := level(id)
+		if err != nil {
+			return nil, err
+		}
+		for len(batches) <= l {
+			batches = append(batches, nil)
+		}
+		batches[l] = append(batches[l], g.servers[id])
+	}
+
+	for _, batch := range batches {
+		sort.Slice(batch, func(i, j int) bool { return batch[i].Name < batch[j].Name })
+	}
+	return batches, nil
+}
+
+// HardDependsOn returns true if a server directly hard-depends on another
+func (g *DependencyGraph) HardDependsOn(serverID, dependencyID string) bool {
+	server, ok := g.servers[serverID]
+	if !ok {
+		return false
+	}
+	for _, dep := range server.GetDependencies() {
+		if dep.ServerID == dependencyID && dep.IsHard() {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveDependents returns the running servers that directly hard-depend on a server
+func (g *DependencyGraph) ActiveDependents(serverID string) []*models.Server {
+	var dependents []*models.Server
+	for _, server := range g.servers {
+		if server.ID == serverID || !server.IsActive() {
+			continue
+		}
+		for _, dep := range server.GetDependencies() {
+			if dep.ServerID == serverID && dep.IsHard() {
+				dependents = append(dependents, server)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// SoftDependents returns the running servers that only soft-depend on a server
+func (g *DependencyGraph) SoftDependents(serverID string) []*models.Server {
+	var dependents []*models.Server
+	for _, server := range g.servers {
+		if server.ID == serverID || !server.IsActive() {
+			continue
+		}
+		soft := false
+		for _, dep := range server.GetDependencies() {
+			if dep.ServerID == serverID {
+				soft = !dep.IsHard()
+				if !soft {
+					break
+				}
+			}
+		}
+		if soft {
+			dependents = append(dependents, server)
+		}
+	}
+	return dependents
+}
+
+// ActiveDependents returns the running servers that hard-depend on a server
+func (pm *PowerManager) ActiveDependents(server *models.Server) []*models.Server {
+	return NewDependencyGraph(pm.storage.GetAllServers()).ActiveDependents(server.ID)
+}
+
+// checkDependents refuses to take a server down while servers that need it are running
+func (pm *PowerManager) checkDependents(server *models.Server) error {
+	graph := NewDependencyGraph(pm.storage.GetAllServers())
+
+	if dependents := graph.ActiveDependents(server.ID); len(dependents) > 0 {
+		return fmt.Errorf("%w: %s", ErrActiveDependents, ServerNames(dependents))
+	}
+
+	if soft := graph.SoftDependents(server.ID); len(soft) > 0 {
+		pm.logger.Warnf("Taking %s down while soft dependents are running: %s", server.Name, ServerNames(soft))
+	}
+	return nil
+}
+
+// WakeDependencies brings up everything a server depends on, in dependency order,
+// without waiting for it. While a dependency is not ready yet, it is returned and
+// the caller checks back later; each dependency is woken once per wait. A hard
+// dependency that is not ready within dependencyReadyTimeout fails the wake,
+// while a soft one is given up on.
+func (pm *PowerManager) WakeDependencies(server *models.Server) (waiting *models.Server, err error) {
+	steps, err := NewDependencyGraph(pm.storage.GetAllServers()).WakeOrder(server.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range steps {
+		if pm.dependencyReady(step) {
+			continue
+		}
+
+		since, started := pm.waitingSince(server.ID, step.Server.ID)
+		if started {
+			pm.logger.Infof("Waking dependency %s of %s first", step.Server.Name, server.Name)
+			if err := pm.wakeDependency(step.Server); err != nil {
+				if step.Hard {
+					pm.clearWaits(server.ID)
+					return nil, fmt.Errorf("dependency %s is not available: %w", step.Server.Name, err)
+				}
+				pm.logger.Warnf("Soft dependency %s of %s is not available, continuing: %v", step.Server.Name, server.Name, err)
+				pm.giveUpWait(server.ID, step.Server.ID)
+				continue
+			}
+		}
+
+		if time.Since(since) < dependencyReadyTimeout {
+			return step.Server, nil
+		}
+		if step.Hard {
+			pm.clearWaits(server.ID)
+			return nil, fmt.Errorf("dependency %s is not available: not ready after %s", step.Server.Name, dependencyReadyTimeout)
+		}
+		pm.logger.Debugf("Soft dependency %s of %s is not ready after %s, continuing", step.Server.Name, server.Name, dependencyReadyTimeout)
+	}
+
+	pm.clearWaits(server.ID)
+	return nil, nil
+}
+
+// wakeDependency wakes a dependency unless it is already on its way up
+func (pm *PowerManager) wakeDependency(dependency *models.Server) error {
+	switch dependency.CurrentState {
+	case models.PowerStateOn, models.PowerStateWaking, models.PowerStateRestarting:
+		return nil
+	}
+	return pm.wakeSingle(dependency)
+}
+
+// dependencyWait is a wake waiting for a dependency
+type dependencyWait struct {
+	since   time.Time // When the wait began
+	checked time.Time // Last time the wake checked back
+}
+
+// waitingSince returns when the wake of a server began waiting for a dependency,
+// starting the wait if it had not. A wait nobody checked on for the ready
+// timeout belongs to an earlier wake and starts over.
+func (pm *PowerManager) waitingSince(serverID, dependencyID string) (since time.Time, started bool) {
+	pm.waitsMu.Lock()
+	defer pm.waitsMu.Unlock()
+
+	now := time.Now()
+	waits := pm.dependencyWaits[serverID]
+	if waits == nil {
+		waits = make(map[string]*dependencyWait)
+		pm.dependencyWaits[serverID] = waits
+	}
+	wait, ok := waits[dependencyID]
+	if ok && now.Sub(wait.checked) < dependencyReadyTimeout {
+		wait.checked = now
+		return wait.since, false
+	}
+	waits[dependencyID] = &dependencyWait{since: now, checked: now}
+	return now, true
+}
+
+// giveUpWait marks the wait for a dependency as timed out
+func (pm *PowerManager) giveUpWait(serverID, dependencyID string) {
+	pm.waitsMu.Lock()
+	defer pm.waitsMu.Unlock()
+
+	pm.dependencyWaits[serverID][dependencyID].since = time.Now().Add(-dependencyReadyTimeout)
+}
+
+// clearWaits forgets the dependency waits of a server once its wake goes ahead or fails
+func (pm *PowerManager) clearWaits(serverID string) {
+	pm.waitsMu.Lock()
+	defer pm.waitsMu.Unlock()
+
+	delete(pm.dependencyWaits, serverID)
+}
+
+// dependencyReady checks a dependency against the services it must provide. Without
+// explicit ports, a Proxmox host must answer on its API port, other physical hosts
+// on SSH, and VMs must be reported as on.
+func (pm *PowerManager) dependencyReady(step *WakeStep) bool {
+	server, err := pm.storage.GetServer(step.Server.ID)
+	if err != nil {
+		return false
+	}
+
+	ports := step.Ports
+	if len(ports) == 0 {
+		switch {
+		case server.IsProxmoxVM:
+			return server.CurrentState == models.PowerStateOn
+		case server.SystemInfo != nil && server.SystemInfo.Type == models.SystemTypeProxmox:
+			ports = []int{proxmoxAPIPort}
+		case server.CurrentState == models.PowerStateOn:
+			return true
+		default:
+			ports = []int{server.SSHPort}
+		}
+	}
+
+	for _, port := range ports {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(server.Hostname, strconv.Itoa(port)), 2*time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+	}
+	return true
+}
+
+// ServerNames joins server names for messages
+func ServerNames(servers []*models.Server) string {
+	names := make([]string, len(servers))
+	for i, server := range servers {
+		names[i] = server.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
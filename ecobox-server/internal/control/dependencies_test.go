@@ -0,0 +1,155 @@
+package control
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/storage"
+)
+
+func TestWakeOrder(t *testing.T) {
+	servers := map[string]*models.Server{
+		"pve": {ID: "pve", Name: "pve"},
+		"nas": {ID: "nas", Name: "nas"},
+		"dns": {ID: "dns", Name: "dns"},
+		"app": {ID: "app", Name: "app", ParentServerID: "pve", Dependencies: []models.Dependency{
+			{ServerID: "nas", Port: 2049, Type: models.DependencyTypeHard},
+			{ServerID: "dns", Type: models.DependencyTypeSoft},
+		}},
+		"web": {ID: "web", Name: "web", Dependencies: []models.Dependency{
+			{ServerID: "app", Type: models.DependencyTypeHard},
+			{ServerID: "nas", Port: 445, Type: models.DependencyTypeHard},
+		}},
+	}
+
+	steps, err := NewDependencyGraph(servers).WakeOrder("web")
+	if err != nil {
+		t.Fatalf("WakeOrder failed: %v", err)
+	}
+
+	position := make(map[string]int)
+	for i, step := range steps {
+		position[step.Server.ID] = i
+	}
+	if len(steps) != 4 {
+		t.Fatalf("Expected 4 dependencies, got %d", len(steps))
+	}
+	if position["pve"] > position["app"] || position["nas"] > position["app"] {
+		t.Errorf("Expected app's dependencies before app, got order %v", position)
+	}
+
+	nas := steps[position["nas"]]
+	if !nas.Hard || len(nas.Ports) != 2 {
+		t.Errorf("Expected nas to be hard with both ports, got hard=%t ports=%v", nas.Hard, nas.Ports)
+	}
+	if steps[position["dns"]].Hard {
+		t.Error("Expected dns to stay a soft dependency")
+	}
+
+	// A cycle is reported instead of recursing forever
+	servers["pve"].Dependencies = []models.Dependency{{ServerID: "web"}}
+	if _, err := NewDependencyGraph(servers).WakeOrder("web"); err == nil {
+		t.Error("Expected an error for a circular dependency")
+	}
+}
+
+func TestActiveDependents(t *testing.T) {
+	servers := map[string]*models.Server{
+		"nas": {ID: "nas", Name: "nas", CurrentState: models.PowerStateOn},
+		"vm1": {ID: "vm1", Name: "vm1", ParentServerID: "nas", CurrentState: models.PowerStateOn},
+		"vm2": {ID: "vm2", Name: "vm2", ParentServerID: "nas", CurrentState: models.PowerStateSuspended},
+		"tv": {ID: "tv", Name: "tv", CurrentState: models.PowerStateOn, Dependencies: []models.Dependency{
+			{ServerID: "nas", Type: models.DependencyTypeSoft},
+		}},
+	}
+
+	graph := NewDependencyGraph(servers)
+	dependents := graph.ActiveDependents("nas")
+	if len(dependents) != 1 || dependents[0].ID != "vm1" {
+		t.Errorf("Expected only vm1 to block nas, got %s", ServerNames(dependents))
+	}
+	if soft := graph.SoftDependents("nas"); len(soft) != 1 || soft[0].ID != "tv" {
+		t.Errorf("Expected tv as soft dependent, got %s", ServerNames(soft))
+	}
+}
+
+func TestBatches(t *testing.T) {
+	servers := map[string]*models.Server{
+		"nas":  {ID: "nas", Name: "nas"},
+		"pve":  {ID: "pve", Name: "pve"},
+		"db":   {ID: "db", Name: "db", ParentServerID: "pve", Dependencies: []models.Dependency{{ServerID: "nas"}}},
+		"app":  {ID: "app", Name: "app", ParentServerID: "pve", Dependencies: []models.Dependency{{ServerID: "db"}}},
+		"grid": {ID: "grid", Name: "grid"},
+	}
+
+	// pve is outside the set, so it doesn't add a batch
+	batches, err := NewDependencyGraph(servers).Batches([]string{"app", "db", "nas", "grid"})
+	if err != nil {
+		t.Fatalf("Batches failed: %v", err)
+	}
+
+	var got [][]string
+	for _, batch := range batches {
+		var names []string
+		for _, server := range batch {
+			names = append(names, server.Name)
+		}
+		got = append(got, names)
+	}
+	if len(got) != 3 || len(got[0]) != 2 || got[0][0] != "grid" || got[0][1] != "nas" || got[1][0] != "db" || got[2][0] != "app" {
+		t.Errorf("Expected [[grid nas] [db] [app]], got %v", got)
+	}
+}
+
+func TestWakeDependenciesDoesNotWait(t *testing.T) {
+	// Nothing listens on the port, so nas never becomes ready
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find free port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	store := storage.NewMemoryStorage()
+	servers := []*models.Server{
+		{ID: "nas", Name: "nas", Hostname: "127.0.0.1", SSHPort: port, CurrentState: models.PowerStateWaking},
+		{ID: "app", Name: "app", Dependencies: []models.Dependency{{ServerID: "nas", Type: models.DependencyTypeHard}}},
+	}
+	for _, server := range servers {
+		if err := store.AddServer(server); err != nil {
+			t.Fatalf("Failed to add server: %v", err)
+		}
+	}
+	pm := NewPowerManager(store)
+	app, _ := store.GetServer("app")
+
+	start := time.Now()
+	waiting, err := pm.WakeDependencies(app)
+	if err != nil || waiting == nil || waiting.ID != "nas" {
+		t.Fatalf("Expected to wait for nas, got %v (%v)", waiting, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected WakeDependencies to return without waiting, took %s", time.Since(start))
+	}
+
+	// A hard dependency that stays down fails the wake once the timeout passes
+	pm.dependencyWaits["app"]["nas"].since = time.Now().Add(-dependencyReadyTimeout)
+	if waiting, err := pm.WakeDependencies(app); err == nil || waiting != nil {
+		t.Errorf("Expected the wake to fail, got %v (%v)", waiting, err)
+	}
+
+	// A soft dependency is given up on instead
+	store.DeleteServer("app")
+	servers[1].Dependencies[0].Type = models.DependencyTypeSoft
+	store.AddServer(servers[1])
+	app, _ = store.GetServer("app")
+	if waiting, _ := pm.WakeDependencies(app); waiting == nil {
+		t.Fatal("Expected to wait for the soft dependency first")
+	}
+	pm.dependencyWaits["app"]["nas"].since = time.Now().Add(-dependencyReadyTimeout)
+	if waiting, err := pm.WakeDependencies(app); err != nil || waiting != nil {
+		t.Errorf("Expected the wake to go ahead without nas, got %v (%v)", waiting, err)
+	}
+}
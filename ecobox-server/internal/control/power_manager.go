@@ -2,60 +2,63 @@ package control
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"ecobox-server/internal/command"
 	"ecobox-server/internal/models"
 	"ecobox-server/internal/proxmox"
+	"ecobox-server/internal/secrets"
 	"ecobox-server/internal/storage"
 	"github.com/sirupsen/logrus"
 )
 
+// autoSnapshotPrefix marks snapshots created automatically before a forced stop
+const autoSnapshotPrefix = "ecobox-auto-"
+
 // PowerManager handles server power state management
 type PowerManager struct {
 	wolSender *WoLSender
 	sshClient *SSHClient
+	commander *command.Commander
 	storage   storage.Storage
 	logger    *logrus.Logger
+
+	// When each wait for a dependency began, by waking server, see WakeDependencies
+	dependencyWaits map[string]map[string]*dependencyWait
+	waitsMu         sync.Mutex
 }
 
 // NewPowerManager creates a new power manager instance
 func NewPowerManager(storage storage.Storage) *PowerManager {
+	sshClient := NewSSHClient()
 	return &PowerManager{
 		wolSender: NewWoLSender(),
-		sshClient: NewSSHClient(),
+		sshClient: sshClient,
+		commander: command.NewCommander(sshClient, logrus.New()),
 		storage:   storage,
 		logger:    logrus.New(),
+
+		dependencyWaits: make(map[string]map[string]*dependencyWait),
 	}
 }
 
-// WakeServer handles wake requests with parent server support
+// WakeServer handles wake requests. It does not look at the server's parent or
+// dependencies, which WakeDependencies brings up first.
 func (pm *PowerManager) WakeServer(server *models.Server) error {
 	pm.logger.Infof("Wake request for server: %s", server.Name)
+	return pm.wakeSingle(server)
+}
 
+// wakeSingle wakes a server without looking at its dependencies
+func (pm *PowerManager) wakeSingle(server *models.Server) error {
 	// Handle Proxmox VMs differently
 	if server.IsProxmoxVM {
 		return pm.wakeProxmoxVM(server)
 	}
 
-	// If server has a parent, wake parent first
-	if server.ParentServerID != "" {
-		parentServer, err := pm.storage.GetServer(server.ParentServerID)
-		if err != nil {
-			return fmt.Errorf("failed to get parent server %s: %w", server.ParentServerID, err)
-		}
-
-		// Recursively wake parent if it's not already on
-		if parentServer.CurrentState != models.PowerStateOn {
-			pm.logger.Infof("Waking parent server %s first", parentServer.Name)
-			if err := pm.WakeServer(parentServer); err != nil {
-				return fmt.Errorf("failed to wake parent server: %w", err)
-			}
-
-			// Wait a bit for parent to become available
-			time.Sleep(5 * time.Second)
-		}
-	}
-
 	// Send WoL packet to the server
 	err := pm.wolSender.SendMagicPacketMultiple(server.MACAddress, []string{
 		"255.255.255.255:9",
@@ -93,14 +96,31 @@ func (pm *PowerManager) WakeServer(server *models.Server) error {
 func (pm *PowerManager) SuspendServer(server *models.Server) error {
 	pm.logger.Infof("Suspend request for server: %s", server.Name)
 
-	// Check if server is in a state that can be suspended
-	if server.CurrentState != models.PowerStateOn && server.CurrentState != models.PowerStateWaking {
+	// Check if server is in a state that can be suspended (the reconciler sets the
+	// transitional state before calling)
+	if server.CurrentState != models.PowerStateOn &&
+		server.CurrentState != models.PowerStateWaking &&
+		server.CurrentState != models.PowerStateSuspending &&
+		server.CurrentState != models.PowerStateHibernating {
 		return fmt.Errorf("server %s cannot be suspended from current state: %s", server.Name, server.CurrentState)
 	}
 
-	// Handle Proxmox VMs differently
+	if err := pm.checkDependents(server); err != nil {
+		return err
+	}
+
+	// Handle Proxmox VMs according to their power policy
 	if server.IsProxmoxVM {
-		return pm.suspendProxmoxVM(server)
+		switch server.GetVMSuspendMode() {
+		case models.VMSuspendModeHibernate:
+			return pm.hibernateProxmoxVM(server)
+		case models.VMSuspendModeShutdown:
+			return pm.shutdownProxmoxVM(server)
+		case models.VMSuspendModeStop:
+			return pm.stopProxmoxVM(server)
+		default:
+			return pm.suspendProxmoxVM(server)
+		}
 	}
 
 	// Execute suspend command via SSH
@@ -152,10 +172,16 @@ func (pm *PowerManager) ShutdownServer(server *models.Server) error {
 	pm.logger.Infof("Shutdown request for server: %s", server.Name)
 
 	// Check if server is in a state that can be shut down
-	if server.CurrentState != models.PowerStateOn && server.CurrentState != models.PowerStateWaking {
+	if server.CurrentState != models.PowerStateOn &&
+		server.CurrentState != models.PowerStateWaking &&
+		server.CurrentState != models.PowerStateStopping {
 		return fmt.Errorf("server %s cannot be shut down from current state: %s", server.Name, server.CurrentState)
 	}
 
+	if err := pm.checkDependents(server); err != nil {
+		return err
+	}
+
 	// Handle Proxmox VMs differently
 	if server.IsProxmoxVM {
 		return pm.shutdownProxmoxVM(server)
@@ -178,13 +204,93 @@ func (pm *PowerManager) StopServer(server *models.Server) error {
 	// Check if server is in a state that can be stopped
 	if server.CurrentState != models.PowerStateOn && 
 	   server.CurrentState != models.PowerStateWaking && 
+	   server.CurrentState != models.PowerStateStopping &&
 	   server.CurrentState != models.PowerStateSuspended {
 		return fmt.Errorf("server %s cannot be stopped from current state: %s", server.Name, server.CurrentState)
 	}
 
+	if err := pm.checkDependents(server); err != nil {
+		return err
+	}
+
 	return pm.stopProxmoxVM(server)
 }
 
+// HibernateServer handles suspend-to-disk requests
+func (pm *PowerManager) HibernateServer(server *models.Server) error {
+	pm.logger.Infof("Hibernate request for server: %s", server.Name)
+
+	if !server.GetPowerCapabilities().Hibernate {
+		return fmt.Errorf("hibernate is not supported on server %s", server.Name)
+	}
+
+	// Check if server is in a state that can be hibernated
+	if server.CurrentState != models.PowerStateOn &&
+		server.CurrentState != models.PowerStateWaking &&
+		server.CurrentState != models.PowerStateHibernating &&
+		server.CurrentState != models.PowerStateSuspended {
+		return fmt.Errorf("server %s cannot be hibernated from current state: %s", server.Name, server.CurrentState)
+	}
+
+	if err := pm.checkDependents(server); err != nil {
+		return err
+	}
+
+	if server.IsProxmoxVM {
+		return pm.hibernateProxmoxVM(server)
+	}
+
+	// Writing RAM to disk takes a while, so the monitor confirms the hibernated
+	// state once the host is offline
+	err := pm.commander.Hibernate(server.Hostname, server.SSHPort, server.SSHUser, server.SSHKeyPath, server.SystemInfo.Type)
+	pm.logSSHPowerAction(server, models.ActionTypeHibernate, err)
+
+	return err
+}
+
+// RestartServer handles clean reboot requests
+func (pm *PowerManager) RestartServer(server *models.Server) error {
+	pm.logger.Infof("Restart request for server: %s", server.Name)
+
+	if !server.GetPowerCapabilities().Restart {
+		return fmt.Errorf("restart is not supported on server %s", server.Name)
+	}
+
+	// Check if server is in a state that can be restarted
+	if server.CurrentState != models.PowerStateOn && server.CurrentState != models.PowerStateRestarting {
+		return fmt.Errorf("server %s cannot be restarted from current state: %s", server.Name, server.CurrentState)
+	}
+
+	if server.IsProxmoxVM {
+		return pm.rebootProxmoxVM(server, false)
+	}
+
+	// SSH drops the session while the host goes down, so a state update is left to the monitor
+	err := pm.commander.Restart(server.Hostname, server.SSHPort, server.SSHUser, server.SSHKeyPath, server.SystemInfo.Type)
+	pm.logSSHPowerAction(server, models.ActionTypeRestart, err)
+
+	return err
+}
+
+// ResetServer handles hard reset requests (Proxmox VMs only)
+func (pm *PowerManager) ResetServer(server *models.Server) error {
+	pm.logger.Infof("Reset request for server: %s", server.Name)
+
+	if !server.GetPowerCapabilities().Reset {
+		return fmt.Errorf("reset is only supported for Proxmox VMs")
+	}
+
+	// A hung VM may still report as on, so reset is allowed from any running state
+	if server.CurrentState != models.PowerStateOn &&
+		server.CurrentState != models.PowerStateWaking &&
+		server.CurrentState != models.PowerStateRestarting &&
+		server.CurrentState != models.PowerStateSuspended {
+		return fmt.Errorf("server %s cannot be reset from current state: %s", server.Name, server.CurrentState)
+	}
+
+	return pm.rebootProxmoxVM(server, true)
+}
+
 // wakeProxmoxVM starts a Proxmox VM using the API
 func (pm *PowerManager) wakeProxmoxVM(server *models.Server) error {
 	pm.logger.Infof("Starting Proxmox VM: %s (VMID: %d)", server.Name, server.ProxmoxVMID)
@@ -195,15 +301,7 @@ func (pm *PowerManager) wakeProxmoxVM(server *models.Server) error {
 		return fmt.Errorf("failed to get parent Proxmox host %s: %w", server.ParentServerID, err)
 	}
 
-	// Ensure parent is awake first
-	if parentServer.CurrentState != models.PowerStateOn {
-		pm.logger.Infof("Waking parent Proxmox host %s first", parentServer.Name)
-		if err := pm.WakeServer(parentServer); err != nil {
-			return fmt.Errorf("failed to wake parent Proxmox host: %w", err)
-		}
-		// Wait for parent to become available
-		time.Sleep(10 * time.Second)
-	}
+	// The parent host is a hard dependency, so WakeServer has already brought it up
 
 	// Make sure parent has API key
 	if parentServer.ProxmoxAPIKey == nil {
@@ -226,7 +324,8 @@ func (pm *PowerManager) wakeProxmoxVM(server *models.Server) error {
 		pm.logger.Infof("Resuming suspended Proxmox VM %s", server.Name)
 		taskID, err = client.ResumeVM(server.ProxmoxVMID)
 	} else {
-		// Start from stopped state (or unknown)
+		// Start from stopped or hibernated state (or unknown); a hibernated
+		// VM resumes from its saved vmstate on start
 		pm.logger.Infof("Starting stopped Proxmox VM %s", server.Name)
 		taskID, err = client.StartVM(server.ProxmoxVMID)
 	}
@@ -399,6 +498,13 @@ func (pm *PowerManager) stopProxmoxVM(server *models.Server) error {
 		true, // Skip TLS verification
 	)
 
+	// Take a safety snapshot first if the VM's power policy asks for it
+	if server.ShouldSnapshotBeforeStop() {
+		if err := pm.snapshotProxmoxVM(server, client); err != nil {
+			return fmt.Errorf("refusing to stop %s, pre-stop snapshot failed: %w", server.Name, err)
+		}
+	}
+
 	// Force stop the VM
 	taskID, err := client.StopVM(server.ProxmoxVMID)
 	
@@ -429,6 +535,205 @@ func (pm *PowerManager) stopProxmoxVM(server *models.Server) error {
 	return err
 }
 
+// hibernateProxmoxVM suspends a Proxmox VM to disk using the API (frees RAM)
+func (pm *PowerManager) hibernateProxmoxVM(server *models.Server) error {
+	pm.logger.Infof("Hibernating Proxmox VM: %s (VMID: %d)", server.Name, server.ProxmoxVMID)
+
+	_, client, err := pm.proxmoxClientForVM(server)
+	if err != nil {
+		return err
+	}
+
+	// Suspend to disk (vmstate saved, VM stopped)
+	taskID, err := client.HibernateVM(server.ProxmoxVMID)
+
+	// Log the action
+	action := models.ServerAction{
+		Timestamp:   time.Now(),
+		Action:      models.ActionTypeHibernate,
+		Success:     err == nil,
+		InitiatedBy: "manual",
+	}
+
+	if err != nil {
+		action.ErrorMsg = err.Error()
+		pm.logger.Errorf("Failed to hibernate Proxmox VM %s: %v", server.Name, err)
+	} else {
+		// The VM stays hibernating until the monitor sees the API report it
+		// hibernated
+		pm.logger.Infof("Sent hibernate to Proxmox VM %s (Task: %s)", server.Name, taskID)
+	}
+
+	// Add action to server history
+	if actionErr := pm.storage.AddServerAction(server.ID, action); actionErr != nil {
+		pm.logger.Errorf("Failed to log server action: %v", actionErr)
+	}
+
+	return err
+}
+
+// rebootProxmoxVM reboots a Proxmox VM using the API, either cleanly (ACPI) or with a hard reset
+func (pm *PowerManager) rebootProxmoxVM(server *models.Server, hard bool) error {
+	actionType := models.ActionTypeRestart
+	if hard {
+		actionType = models.ActionTypeReset
+	}
+	pm.logger.Infof("Sending %s to Proxmox VM: %s (VMID: %d)", actionType, server.Name, server.ProxmoxVMID)
+
+	_, client, err := pm.proxmoxClientForVM(server)
+	if err != nil {
+		return err
+	}
+
+	var taskID string
+	if hard {
+		taskID, err = client.ResetVM(server.ProxmoxVMID)
+	} else {
+		taskID, err = client.RebootVM(server.ProxmoxVMID)
+	}
+
+	// Log the action
+	action := models.ServerAction{
+		Timestamp:   time.Now(),
+		Action:      actionType,
+		Success:     err == nil,
+		InitiatedBy: "manual",
+	}
+
+	if err != nil {
+		action.ErrorMsg = err.Error()
+		pm.logger.Errorf("Failed to %s Proxmox VM %s: %v", actionType, server.Name, err)
+	} else {
+		pm.logger.Infof("Successfully sent %s to Proxmox VM %s (Task: %s)", actionType, server.Name, taskID)
+	}
+
+	// Add action to server history
+	if actionErr := pm.storage.AddServerAction(server.ID, action); actionErr != nil {
+		pm.logger.Errorf("Failed to log server action: %v", actionErr)
+	}
+
+	return err
+}
+
+// logSSHPowerAction records the outcome of an SSH power command in the server history
+func (pm *PowerManager) logSSHPowerAction(server *models.Server, actionType models.ActionType, err error) {
+	action := models.ServerAction{
+		Timestamp:   time.Now(),
+		Action:      actionType,
+		Success:     err == nil,
+		InitiatedBy: "manual",
+	}
+
+	if err != nil {
+		action.ErrorMsg = err.Error()
+		pm.logger.Errorf("Failed to %s %s: %v", actionType, server.Name, err)
+	} else {
+		pm.logger.Infof("Successfully sent %s command to %s", actionType, server.Name)
+	}
+
+	if actionErr := pm.storage.AddServerAction(server.ID, action); actionErr != nil {
+		pm.logger.Errorf("Failed to log server action: %v", actionErr)
+	}
+}
+
+// snapshotProxmoxVM takes an automatic disk snapshot of a VM and waits for it to
+// finish, then prunes old automatic snapshots according to the VM's power policy
+func (pm *PowerManager) snapshotProxmoxVM(server *models.Server, client *proxmox.Client) error {
+	snapName := fmt.Sprintf("%s%s", autoSnapshotPrefix, time.Now().Format("20060102-150405"))
+	pm.logger.Infof("Creating pre-stop snapshot %s for Proxmox VM %s", snapName, server.Name)
+
+	taskID, err := client.CreateSnapshot(server.ProxmoxVMID, snapName, "Automatic snapshot before forced stop", false)
+	if err == nil {
+		var status *proxmox.TaskStatus
+		status, err = client.WaitForTask(taskID, 5*time.Minute)
+		if err == nil && status.ExitStatus != "OK" {
+			err = fmt.Errorf("snapshot task finished with status: %s", status.ExitStatus)
+		}
+	}
+
+	// Log the action
+	action := models.ServerAction{
+		Timestamp:   time.Now(),
+		Action:      models.ActionTypeSnapshot,
+		Success:     err == nil,
+		InitiatedBy: "system",
+	}
+	if err != nil {
+		action.ErrorMsg = err.Error()
+		pm.logger.Errorf("Failed to snapshot Proxmox VM %s: %v", server.Name, err)
+	} else {
+		pm.logger.Infof("Created snapshot %s for Proxmox VM %s", snapName, server.Name)
+	}
+	if actionErr := pm.storage.AddServerAction(server.ID, action); actionErr != nil {
+		pm.logger.Errorf("Failed to log server action: %v", actionErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	pm.pruneAutoSnapshots(server, client)
+	return nil
+}
+
+// pruneAutoSnapshots removes the oldest automatic snapshots beyond the policy's keep count
+func (pm *PowerManager) pruneAutoSnapshots(server *models.Server, client *proxmox.Client) {
+	keep := server.VMPowerPolicy.SnapshotKeep
+	if keep <= 0 {
+		return
+	}
+
+	snapshots, err := client.ListSnapshots(server.ProxmoxVMID)
+	if err != nil {
+		pm.logger.Warnf("Failed to list snapshots for Proxmox VM %s: %v", server.Name, err)
+		return
+	}
+
+	var auto []proxmox.Snapshot
+	for _, snap := range snapshots {
+		if strings.HasPrefix(snap.Name, autoSnapshotPrefix) {
+			auto = append(auto, snap)
+		}
+	}
+	if len(auto) <= keep {
+		return
+	}
+
+	sort.Slice(auto, func(i, j int) bool { return auto[i].SnapTime < auto[j].SnapTime })
+	for _, snap := range auto[:len(auto)-keep] {
+		if _, err := client.DeleteSnapshot(server.ProxmoxVMID, snap.Name); err != nil {
+			pm.logger.Warnf("Failed to delete old snapshot %s for Proxmox VM %s: %v", snap.Name, server.Name, err)
+			continue
+		}
+		pm.logger.Infof("Deleted old snapshot %s for Proxmox VM %s", snap.Name, server.Name)
+	}
+}
+
+// proxmoxClientForVM returns the online parent host of a VM and an API client for it
+func (pm *PowerManager) proxmoxClientForVM(server *models.Server) (*models.Server, *proxmox.Client, error) {
+	parentServer, err := pm.storage.GetServer(server.ParentServerID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get parent Proxmox host %s: %w", server.ParentServerID, err)
+	}
+
+	if parentServer.ProxmoxAPIKey == nil {
+		return nil, nil, fmt.Errorf("parent Proxmox host %s has no API key", parentServer.Name)
+	}
+
+	if parentServer.CurrentState != models.PowerStateOn {
+		return nil, nil, fmt.Errorf("parent Proxmox host %s is not online", parentServer.Name)
+	}
+
+	client := proxmox.NewClient(
+		parentServer.Hostname,
+		parentServer.ProxmoxNodeName,
+		parentServer.GetProxmoxAPIToken(),
+		true, // Skip TLS verification
+	)
+
+	return parentServer, client, nil
+}
+
 // GetRootServer finds the root server in a hierarchy
 func (pm *PowerManager) GetRootServer(server *models.Server) (*models.Server, error) {
 	current := server
@@ -461,4 +766,10 @@ func (pm *PowerManager) TestServerConnectivity(server *models.Server) error {
 // SetLogger allows setting a custom logger
 func (pm *PowerManager) SetLogger(logger *logrus.Logger) {
 	pm.logger = logger
+	pm.commander.SetLogger(logger)
+}
+
+// SetSecrets sets the vault SSH keys are read from
+func (pm *PowerManager) SetSecrets(vault *secrets.Vault) {
+	pm.sshClient.SetSecrets(vault)
 }
@@ -0,0 +1,66 @@
+package control
+
+import (
+	"strings"
+	"testing"
+
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/storage"
+)
+
+func TestPowerCapabilities(t *testing.T) {
+	all := models.PowerCapabilities{Wake: true, Suspend: true, Hibernate: true, Shutdown: true, Stop: true, Restart: true, Reset: true}
+
+	tests := []struct {
+		name   string
+		server models.Server
+		want   models.PowerCapabilities
+	}{
+		{"proxmox vm", models.Server{IsProxmoxVM: true}, all},
+		{"undetected", models.Server{MACAddress: "AA:BB:CC:DD:EE:01"},
+			models.PowerCapabilities{Wake: true, Suspend: true, Shutdown: true}},
+		{"linux", models.Server{MACAddress: "AA:BB:CC:DD:EE:01", SystemInfo: &models.SystemInfo{Type: models.SystemTypeLinux, SuspendSupport: true}},
+			models.PowerCapabilities{Wake: true, Suspend: true, Shutdown: true, Restart: true}},
+		{"linux with hibernate", models.Server{SystemInfo: &models.SystemInfo{Type: models.SystemTypeLinux, SuspendSupport: true, HibernateSupport: true}},
+			models.PowerCapabilities{Suspend: true, Shutdown: true, Hibernate: true, Restart: true}},
+		{"proxmox host", models.Server{SystemInfo: &models.SystemInfo{Type: models.SystemTypeProxmox, HibernateSupport: true}},
+			models.PowerCapabilities{Hibernate: true, Restart: true}},
+		{"windows", models.Server{SystemInfo: &models.SystemInfo{Type: models.SystemTypeWindows, SuspendSupport: true, HibernateSupport: true}},
+			models.PowerCapabilities{Suspend: true, Shutdown: true, Restart: true}},
+		{"unknown", models.Server{SystemInfo: &models.SystemInfo{Type: models.SystemTypeUnknown, SuspendSupport: true, HibernateSupport: true}},
+			models.PowerCapabilities{Suspend: true, Shutdown: true}},
+	}
+	for _, test := range tests {
+		if got := test.server.GetPowerCapabilities(); got != test.want {
+			t.Errorf("%s: expected capabilities %+v, got %+v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestPowerActionsNeedCapability(t *testing.T) {
+	pm := NewPowerManager(storage.NewMemoryStorage())
+
+	// Neither action is tried over SSH on hosts that cannot do it
+	undetected := &models.Server{ID: "nas", Name: "nas", Hostname: "127.0.0.1", CurrentState: models.PowerStateOn}
+	linux := &models.Server{ID: "web", Name: "web", Hostname: "127.0.0.1", CurrentState: models.PowerStateOn,
+		SystemInfo: &models.SystemInfo{Type: models.SystemTypeLinux, SuspendSupport: true}}
+
+	tests := []struct {
+		name    string
+		action  func(*models.Server) error
+		server  *models.Server
+		wantErr string
+	}{
+		{"restart", pm.RestartServer, undetected, "restart is not supported"},
+		{"reset", pm.ResetServer, undetected, "only supported for Proxmox VMs"},
+		{"reset", pm.ResetServer, linux, "only supported for Proxmox VMs"},
+		{"hibernate", pm.HibernateServer, undetected, "hibernate is not supported"},
+		{"hibernate", pm.HibernateServer, linux, "hibernate is not supported"},
+	}
+	for _, test := range tests {
+		err := test.action(test.server)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("Expected %s of %s to fail with %q, got %v", test.name, test.server.Name, test.wantErr, err)
+		}
+	}
+}
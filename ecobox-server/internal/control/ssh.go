@@ -1,24 +1,34 @@
 package control
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"strconv"
 	"time"
 
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/secrets"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
 
 // SSHClient handles SSH connections and command execution
-type SSHClient struct{}
+type SSHClient struct {
+	secrets *secrets.Vault // Holds keys referred to as secret:<name> instead of a key path
+}
 
 // NewSSHClient creates a new SSH client instance
 func NewSSHClient() *SSHClient {
 	return &SSHClient{}
 }
 
+// SetSecrets sets the vault private keys are read from
+func (s *SSHClient) SetSecrets(vault *secrets.Vault) {
+	s.secrets = vault
+}
+
 // ExecuteCommand establishes SSH connection and executes a command
 func (s *SSHClient) ExecuteCommand(host string, port int, user string, keyPath string, command string) error {
 	// Create SSH client configuration
@@ -50,6 +60,52 @@ func (s *SSHClient) ExecuteCommand(host string, port int, user string, keyPath s
 	return nil
 }
 
+// RunCommand executes a command and waits for it to exit. When the context ends
+// first the connection is closed, which ends the session and the command.
+func (s *SSHClient) RunCommand(ctx context.Context, host string, port int, user string, keyPath string, command string) error {
+	config, err := s.createSSHConfig(user, keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to create SSH config: %w", err)
+	}
+
+	address := host + ":" + strconv.Itoa(port)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SSH server %s: %w", address, err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to connect to SSH server %s: %w", address, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	if err := session.Run(command); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to execute command '%s': %w", command, err)
+	}
+	return nil
+}
+
 // createSSHConfig creates SSH client configuration
 func (s *SSHClient) createSSHConfig(user string, keyPath string) (*ssh.ClientConfig, error) {
 	var authMethods []ssh.AuthMethod
@@ -82,11 +138,22 @@ func (s *SSHClient) createSSHConfig(user string, keyPath string) (*ssh.ClientCon
 	return config, nil
 }
 
-// loadPrivateKey loads a private key from file
+// loadPrivateKey loads a private key from file, or from the vault for a
+// secret:<name> reference
 func (s *SSHClient) loadPrivateKey(keyPath string) (ssh.Signer, error) {
-	key, err := os.ReadFile(keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	var key []byte
+	if config.IsSecretRef(keyPath) {
+		value, err := s.secrets.Resolve(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		key = []byte(value)
+	} else {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key file: %w", err)
+		}
+		key = data
 	}
 
 	// Parse the private key
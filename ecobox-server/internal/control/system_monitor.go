@@ -8,6 +8,7 @@ import (
 
 	"ecobox-server/internal/command"
 	"ecobox-server/internal/models"
+	"ecobox-server/internal/secrets"
 	"ecobox-server/internal/storage"
 	"github.com/sirupsen/logrus"
 )
@@ -34,6 +35,11 @@ func NewSystemMonitor(storage storage.Storage, logger *logrus.Logger) *SystemMon
 	}
 }
 
+// SetSecrets sets the vault SSH keys are read from
+func (sm *SystemMonitor) SetSecrets(vault *secrets.Vault) {
+	sm.sshClient.SetSecrets(vault)
+}
+
 // PerformInitializationCheck performs comprehensive initialization check on a server
 // This includes detecting OS, system capabilities, network interfaces, and WoL setup
 func (sm *SystemMonitor) PerformInitializationCheck(server *models.Server) error {
@@ -347,6 +353,13 @@ func (sm *SystemMonitor) checkSystemCapabilities(server *models.Server, systemTy
 		server.SystemInfo.PowerMeterSupport = false
 	}
 
+	// Hibernate needs swap and platform support, so verify it rather than assuming it
+	if systemType == models.SystemTypeLinux || systemType == models.SystemTypeProxmox {
+		if supported, err := sm.commander.CheckHibernateSupport(server.Hostname, server.SSHPort, server.SSHUser, server.SSHKeyPath, systemType); err == nil {
+			server.SystemInfo.HibernateSupport = supported
+		}
+	}
+
 	// Now actually check and configure Wake-on-LAN for supported systems
 	if systemType == models.SystemTypeLinux || systemType == models.SystemTypeProxmox {
 		// Check actual WoL support on the system
@@ -0,0 +1,491 @@
+// Package discovery sweeps the configured networks for machines the dashboard does
+// not manage yet and proposes them as candidate servers.
+//
+// A sweep probes every address with a quick TCP scan and reads the kernel's
+// neighbor table, so hosts that answer ARP but have no common port open are found
+// too. Each host found is scanned for the common homelab ports and named through
+// mDNS, NetBIOS or reverse DNS; its MAC address prefix gives the vendor. Known
+// servers and dismissed candidates are left out. An admin adopts a candidate as an
+// API server, so it is saved with the other servers added at runtime.
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/registry"
+	"ecobox-server/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// probeConcurrency bounds the hosts probed at the same time
+	probeConcurrency = 64
+	// nameTimeout bounds each name lookup
+	nameTimeout = time.Second
+)
+
+var (
+	ErrCandidateNotFound = errors.New("candidate not found")
+	ErrScanRunning       = errors.New("a discovery scan is already running")
+	ErrNoNetworks        = errors.New("no discovery networks configured")
+)
+
+// Candidate is a machine found on the network that is not a known server
+type Candidate struct {
+	ID         string           `json:"id"`
+	IPAddress  string           `json:"ip_address"`
+	MACAddress string           `json:"mac_address,omitempty"` // Only known for hosts on a local network
+	Hostname   string           `json:"hostname,omitempty"`    // From mDNS, NetBIOS or reverse DNS
+	Vendor     string           `json:"vendor,omitempty"`
+	Services   []models.Service `json:"services"`
+	FirstSeen  time.Time        `json:"first_seen"`
+	LastSeen   time.Time        `json:"last_seen"`
+}
+
+// Status describes the discovery state
+type Status struct {
+	Networks   []string     `json:"networks"`
+	Scanning   bool         `json:"scanning"`
+	LastScan   *time.Time   `json:"last_scan,omitempty"`
+	Candidates []*Candidate `json:"candidates"`
+}
+
+// AdoptRequest holds the settings for a candidate becoming a server. Empty fields
+// are filled in from the candidate.
+type AdoptRequest struct {
+	ID         string   `json:"id,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	MACAddress string   `json:"mac_address,omitempty"`
+	SSHUser    string   `json:"ssh_user,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Services   []int    `json:"services,omitempty"` // Ports of the detected services to keep (default: all)
+}
+
+// Scanner runs discovery sweeps and keeps their candidates
+type Scanner struct {
+	config      *config.Config
+	storage     storage.Storage
+	registry    *registry.Registry
+	portScanner *monitor.PortScanner
+	vendors     Vendors
+
+	candidates map[string]*Candidate
+	dismissed  map[string]bool // Candidate IDs left out of later sweeps
+	scanning   bool
+	lastScan   time.Time
+	mu         sync.Mutex
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	logger   *logrus.Logger
+}
+
+// NewScanner creates a discovery scanner. Vendor names come from the built-in table
+// and the configured OUI file.
+func NewScanner(cfg *config.Config, storage storage.Storage, reg *registry.Registry, portScanner *monitor.PortScanner) *Scanner {
+	s := &Scanner{
+		config:      cfg,
+		storage:     storage,
+		registry:    reg,
+		portScanner: portScanner,
+		candidates:  make(map[string]*Candidate),
+		dismissed:   make(map[string]bool),
+		stopChan:    make(chan struct{}),
+		logger:      logrus.New(),
+	}
+
+	vendors, err := LoadVendors(cfg.Dashboard.DiscoveryOUIFile)
+	if err != nil {
+		s.logger.Warnf("Using built-in vendor names only: %v", err)
+	}
+	s.vendors = vendors
+	return s
+}
+
+// SetLogger sets the logger for the scanner
+func (s *Scanner) SetLogger(logger *logrus.Logger) {
+	s.logger = logger
+}
+
+// Start sweeps the configured networks every discovery_interval seconds until
+// Stop is called. The interval is read before each wait, so reloads apply.
+func (s *Scanner) Start() {
+	go func() {
+		for {
+			interval := time.Duration(s.config.LiveDashboard().DiscoveryInterval) * time.Second
+			if interval <= 0 {
+				interval = time.Minute // Check again later in case a reload enables sweeps
+			}
+
+			select {
+			case <-time.After(interval):
+				dashboard := s.config.LiveDashboard()
+				if dashboard.DiscoveryInterval <= 0 || len(dashboard.DiscoveryNetworks) == 0 {
+					continue
+				}
+				if err := s.Scan(nil); err != nil && !errors.Is(err, ErrScanRunning) {
+					s.logger.Errorf("Failed to start discovery scan: %v", err)
+				}
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic sweeps. A running sweep finishes in the background.
+func (s *Scanner) Stop() {
+	s.stopOnce.Do(func() { close(s.stopChan) })
+}
+
+// Scan starts a sweep of the networks, or of the configured networks when none are
+// given, and returns without waiting for it
+func (s *Scanner) Scan(networks []string) error {
+	if len(networks) == 0 {
+		networks = s.config.LiveDashboard().DiscoveryNetworks
+	}
+	if len(networks) == 0 {
+		return ErrNoNetworks
+	}
+
+	var hosts []string
+	for _, network := range networks {
+		expanded, err := expandNetwork(network)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, expanded...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scanning {
+		return ErrScanRunning
+	}
+	s.scanning = true
+
+	go s.sweep(networks, hosts)
+	return nil
+}
+
+// sweep finds the hosts that respond, inspects them and updates the candidates
+func (s *Scanner) sweep(networks, hosts []string) {
+	started := time.Now()
+	s.logger.Infof("Starting discovery scan of %s (%d addresses)", strings.Join(networks, ", "), len(hosts))
+
+	responding := make(map[string]bool)
+	var mu sync.Mutex
+	s.forEach(hosts, func(ip string) {
+		if s.portScanner.QuickScan(ip) {
+			mu.Lock()
+			responding[ip] = true
+			mu.Unlock()
+		}
+	})
+
+	// The probes fill the neighbor table for local hosts, whether or not a port answered
+	inRange := make(map[string]bool, len(hosts))
+	for _, ip := range hosts {
+		inRange[ip] = true
+	}
+	neighbors := readARPTable()
+	for ip := range neighbors {
+		if inRange[ip] {
+			responding[ip] = true
+		}
+	}
+
+	known := s.knownServers()
+	var found []*Candidate
+	s.forEach(sortedKeys(responding), func(ip string) {
+		mac := neighbors[ip]
+		if known.matches(ip, mac) {
+			return
+		}
+
+		candidate := &Candidate{
+			ID:         candidateID(ip, mac),
+			IPAddress:  ip,
+			MACAddress: mac,
+			Vendor:     s.vendors.Lookup(mac),
+		}
+		candidate.Hostname = lookupName(ip, nameTimeout)
+		if known.matches(candidate.Hostname, "") {
+			return
+		}
+		candidate.Services = s.portScanner.ComprehensiveScan(ip, candidate.ID)
+		if candidate.Services == nil {
+			candidate.Services = []models.Service{}
+		}
+
+		mu.Lock()
+		found = append(found, candidate)
+		mu.Unlock()
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	candidates := make(map[string]*Candidate, len(found))
+	for _, candidate := range found {
+		if s.dismissed[candidate.ID] {
+			continue
+		}
+		candidate.FirstSeen, candidate.LastSeen = now, now
+		if previous, exists := s.candidates[candidate.ID]; exists {
+			candidate.FirstSeen = previous.FirstSeen
+		}
+		candidates[candidate.ID] = candidate
+	}
+
+	// Candidates outside the swept networks are kept until their network is swept again
+	for id, candidate := range s.candidates {
+		if _, exists := candidates[id]; !exists && !inRange[candidate.IPAddress] {
+			candidates[id] = candidate
+		}
+	}
+
+	s.candidates = candidates
+	s.lastScan = now
+	s.scanning = false
+	s.logger.Infof("Discovery scan finished in %s: %d hosts responded, %d candidates", time.Since(started).Round(time.Second), len(responding), len(candidates))
+}
+
+// forEach calls fn for every host with bounded concurrency and waits for all calls
+func (s *Scanner) forEach(hosts []string, fn func(ip string)) {
+	slots := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for _, ip := range hosts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(ip string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(ip)
+		}(ip)
+	}
+	wg.Wait()
+}
+
+// Status returns the discovery state with the candidates ordered by IP address
+func (s *Scanner) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Networks:   append([]string{}, s.config.LiveDashboard().DiscoveryNetworks...),
+		Scanning:   s.scanning,
+		Candidates: make([]*Candidate, 0, len(s.candidates)),
+	}
+	if !s.lastScan.IsZero() {
+		lastScan := s.lastScan
+		status.LastScan = &lastScan
+	}
+	for _, candidate := range s.candidates {
+		copied := *candidate
+		status.Candidates = append(status.Candidates, &copied)
+	}
+	sort.Slice(status.Candidates, func(i, j int) bool {
+		return ipLess(status.Candidates[i].IPAddress, status.Candidates[j].IPAddress)
+	})
+	return status
+}
+
+// Adopt adds a candidate as a server through the registry and removes it from the
+// candidates
+func (s *Scanner) Adopt(id string, req AdoptRequest) (*models.Server, error) {
+	s.mu.Lock()
+	candidate, exists := s.candidates[id]
+	s.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrCandidateNotFound, id)
+	}
+
+	def := config.ServerConfig{
+		ID:         req.ID,
+		Name:       req.Name,
+		Hostname:   candidate.IPAddress,
+		MACAddress: req.MACAddress,
+		SSHUser:    req.SSHUser,
+		Groups:     req.Groups,
+		Services:   adoptedServices(candidate.Services, req.Services),
+	}
+	if def.Name == "" {
+		def.Name = candidate.Hostname
+	}
+	if def.Name == "" {
+		def.Name = candidate.IPAddress
+	}
+	if def.ID == "" {
+		def.ID = slug(def.Name)
+	}
+	if def.MACAddress == "" {
+		def.MACAddress = candidate.MACAddress
+	}
+
+	server, err := s.registry.Create(def)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	delete(s.candidates, id)
+	s.mu.Unlock()
+
+	s.logger.Infof("Adopted discovered host %s as server %s", candidate.IPAddress, def.ID)
+	return server, nil
+}
+
+// Dismiss removes a candidate and leaves it out of later sweeps
+func (s *Scanner) Dismiss(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.candidates[id]; !exists {
+		return fmt.Errorf("%w: %s", ErrCandidateNotFound, id)
+	}
+	delete(s.candidates, id)
+	s.dismissed[id] = true
+	return nil
+}
+
+// adoptedServices turns the detected services on the chosen ports into service
+// definitions. Names are made unique, since several common ports share one.
+func adoptedServices(detected []models.Service, ports []int) []config.ServiceConfig {
+	keep := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		keep[port] = true
+	}
+
+	services := []config.ServiceConfig{}
+	names := make(map[string]bool)
+	for _, service := range detected {
+		if len(ports) > 0 && !keep[service.Port] {
+			continue
+		}
+		name := service.Name
+		if names[name] {
+			name = fmt.Sprintf("%s (%d)", name, service.Port)
+		}
+		names[name] = true
+		services = append(services, config.ServiceConfig{
+			Name: name,
+			Port: service.Port,
+			Type: string(service.Type),
+		})
+	}
+	return services
+}
+
+// knownSet holds the addresses, names and MAC addresses of the servers in storage
+type knownSet map[string]bool
+
+// knownServers collects what identifies the servers already in storage
+func (s *Scanner) knownServers() knownSet {
+	known := make(knownSet)
+	for _, server := range s.storage.GetAllServers() {
+		known[strings.ToLower(server.Hostname)] = true
+		known[strings.ToLower(strings.Split(server.Hostname, ".")[0])] = true
+		if server.MACAddress != "" {
+			known[strings.ToUpper(strings.ReplaceAll(server.MACAddress, "-", ":"))] = true
+		}
+		// Hostnames resolve to the addresses seen by the sweep
+		if addrs, err := net.LookupHost(server.Hostname); err == nil {
+			for _, addr := range addrs {
+				known[addr] = true
+			}
+		}
+	}
+	return known
+}
+
+// matches reports whether an address or name, or a MAC address, belongs to a
+// known server
+func (k knownSet) matches(host, mac string) bool {
+	if host != "" && (k[strings.ToLower(host)] || k[strings.ToLower(strings.Split(host, ".")[0])]) {
+		return true
+	}
+	return mac != "" && k[mac]
+}
+
+// expandNetwork lists the host addresses of an IPv4 CIDR, without the network
+// and broadcast addresses of networks larger than /31
+func expandNetwork(network string) ([]string, error) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discovery network '%s': %w", network, err)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits != 32 {
+		return nil, fmt.Errorf("discovery network '%s' must be IPv4", network)
+	}
+	if ones < 22 {
+		return nil, fmt.Errorf("discovery network '%s' is too large, use /22 or smaller", network)
+	}
+
+	base := ipToUint(ipNet.IP)
+	size := uint32(1) << uint(32-ones)
+	first, last := base, base+size-1
+	if size > 2 {
+		first, last = first+1, last-1
+	}
+
+	hosts := make([]string, 0, last-first+1)
+	for addr := first; addr <= last; addr++ {
+		hosts = append(hosts, uintToIP(addr).String())
+	}
+	return hosts, nil
+}
+
+// candidateID identifies a candidate by MAC address, or by IP address without one
+func candidateID(ip, mac string) string {
+	if mac != "" {
+		return strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+	}
+	return strings.ReplaceAll(ip, ".", "-")
+}
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug turns a name into a server ID
+func slug(name string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func ipToUint(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uintToIP(addr uint32) net.IP {
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+// ipLess orders IPv4 addresses numerically
+func ipLess(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a < b
+	}
+	return ipToUint(ipA) < ipToUint(ipB)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return ipLess(keys[i], keys[j]) })
+	return keys
+}
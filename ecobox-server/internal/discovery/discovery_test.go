@@ -0,0 +1,159 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/registry"
+	"ecobox-server/internal/storage"
+)
+
+func TestExpandNetwork(t *testing.T) {
+	hosts, err := expandNetwork("192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("expandNetwork failed: %v", err)
+	}
+	if len(hosts) != 254 || hosts[0] != "192.168.1.1" || hosts[253] != "192.168.1.254" {
+		t.Errorf("Expected 192.168.1.1 to 192.168.1.254, got %d hosts from %s to %s", len(hosts), hosts[0], hosts[len(hosts)-1])
+	}
+
+	hosts, err = expandNetwork("10.0.0.4/31")
+	if err != nil || len(hosts) != 2 {
+		t.Errorf("Expected both addresses of a /31, got %v (%v)", hosts, err)
+	}
+
+	for _, network := range []string{"10.0.0.0/16", "fd00::/120", "not-a-network"} {
+		if _, err := expandNetwork(network); err == nil {
+			t.Errorf("Expected %s to be rejected", network)
+		}
+	}
+}
+
+func TestParseARPTable(t *testing.T) {
+	table := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.20     0x1         0x2         b8:27:eb:12:34:56     *        eth0
+192.168.1.21     0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.1.22     0x1         0x2         00:00:00:00:00:00     *        eth0
+192.168.1.23     0x1         0x6         00:11:32:ab:cd:ef     *        eth0
+`
+	entries := parseARPTable(strings.NewReader(table))
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 complete entries, got %v", entries)
+	}
+	if entries["192.168.1.20"] != "B8:27:EB:12:34:56" || entries["192.168.1.23"] != "00:11:32:AB:CD:EF" {
+		t.Errorf("Unexpected entries: %v", entries)
+	}
+}
+
+func TestVendors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oui.txt")
+	data := "OUI/MA-L                                                    Organization\n" +
+		"00-1B-63   (hex)\t\tApple, Inc.\n" +
+		"001B63     (base 16)\t\tApple, Inc.\n" +
+		"00:50:56\tVMware\tVMware, Inc.\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write OUI file: %v", err)
+	}
+
+	vendors, err := LoadVendors(path)
+	if err != nil {
+		t.Fatalf("LoadVendors failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"00:1b:63:00:00:01": "Apple, Inc.",
+		"00-50-56-00-00-01": "VMware, Inc.",
+		"B8:27:EB:12:34:56": "Raspberry Pi Foundation",
+		"02:00:00:00:00:01": "",
+		"":                  "",
+	}
+	for mac, expected := range tests {
+		if vendor := vendors.Lookup(mac); vendor != expected {
+			t.Errorf("Lookup(%q) = %q, expected %q", mac, vendor, expected)
+		}
+	}
+
+	if _, err := LoadVendors(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Expected an error for a missing OUI file")
+	}
+}
+
+func TestParseNetBIOSStatus(t *testing.T) {
+	reply := make([]byte, 12)
+	reply = append(reply, netbiosStatusQuery[12:46]...) // Question name
+	reply = append(reply, 0x00, 0x21, 0x00, 0x01, 0, 0, 0, 0, 0x00, 0x00)
+	reply = append(reply, 3)
+	for _, entry := range []struct {
+		name   string
+		suffix byte
+		flags  uint16
+	}{
+		{"WORKGROUP", 0x00, 0x8400}, // Group name
+		{"MEDIA-PC", 0x20, 0x0400},  // File server service
+		{"MEDIA-PC", 0x00, 0x0400},
+	} {
+		name := []byte(entry.name + strings.Repeat(" ", 15-len(entry.name)))
+		reply = append(reply, name...)
+		reply = append(reply, entry.suffix)
+		reply = binary.BigEndian.AppendUint16(reply, entry.flags)
+	}
+
+	name, err := parseNetBIOSStatus(reply)
+	if err != nil || name != "MEDIA-PC" {
+		t.Errorf("Expected MEDIA-PC, got %q (%v)", name, err)
+	}
+
+	if _, err := parseNetBIOSStatus(reply[:20]); err == nil {
+		t.Error("Expected an error for a truncated reply")
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Dashboard.APIServersFile = filepath.Join(t.TempDir(), "api-servers.toml")
+	cfg.SetDefaults()
+
+	store := storage.NewMemoryStorage()
+	reg := registry.NewRegistry(cfg, store)
+	scanner := NewScanner(cfg, store, reg, monitor.NewPortScanner())
+	scanner.candidates["b827eb123456"] = &Candidate{
+		ID:         "b827eb123456",
+		IPAddress:  "192.168.1.20",
+		MACAddress: "B8:27:EB:12:34:56",
+		Hostname:   "Media PC",
+		Services: []models.Service{
+			{Name: "SSH", Port: 22, Type: models.ServiceTypeSSH},
+			{Name: "Development Alt", Port: 9000},
+			{Name: "Development Alt", Port: 9001},
+		},
+	}
+
+	if _, err := scanner.Adopt("missing", AdoptRequest{}); !errors.Is(err, ErrCandidateNotFound) {
+		t.Errorf("Expected ErrCandidateNotFound, got %v", err)
+	}
+
+	server, err := scanner.Adopt("b827eb123456", AdoptRequest{Groups: []string{"media"}})
+	if err != nil {
+		t.Fatalf("Adopt failed: %v", err)
+	}
+	if server.ID != "media-pc" || server.Hostname != "192.168.1.20" || server.MACAddress != "B8:27:EB:12:34:56" || server.Source != models.SourceAPI {
+		t.Errorf("Unexpected server: %+v", server)
+	}
+	if len(server.Services) != 3 || server.Services[2].Name != "Development Alt (9001)" {
+		t.Errorf("Expected 3 uniquely named services, got %+v", server.Services)
+	}
+	if len(scanner.Status().Candidates) != 0 {
+		t.Error("Expected the adopted candidate to be removed")
+	}
+
+	if !scanner.knownServers().matches("192.168.1.20", "") || !scanner.knownServers().matches("", "B8:27:EB:12:34:56") {
+		t.Error("Expected the adopted server to be known to later sweeps")
+	}
+}
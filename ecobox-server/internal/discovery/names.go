@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// arpTablePath is the kernel's IPv4 neighbor table on Linux
+const arpTablePath = "/proc/net/arp"
+
+// arpFlagComplete marks a resolved neighbor entry
+const arpFlagComplete = 0x2
+
+// readARPTable returns the MAC address of each resolved neighbor by IP address.
+// Without a readable table, as on other systems, it is empty.
+func readARPTable() map[string]string {
+	file, err := os.Open(arpTablePath)
+	if err != nil {
+		return map[string]string{}
+	}
+	defer file.Close()
+	return parseARPTable(file)
+}
+
+// parseARPTable parses the /proc/net/arp format
+func parseARPTable(r io.Reader) map[string]string {
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		var flags int
+		if _, err := fmt.Sscanf(fields[2], "0x%x", &flags); err != nil || flags&arpFlagComplete == 0 {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil || bytes.Equal(mac, make(net.HardwareAddr, len(mac))) {
+			continue
+		}
+		entries[fields[0]] = strings.ToUpper(mac.String())
+	}
+	return entries
+}
+
+// lookupName finds a name for a host, trying mDNS, NetBIOS and reverse DNS in turn
+func lookupName(ip string, timeout time.Duration) string {
+	if name, err := lookupMDNS(ip, timeout); err == nil {
+		return name
+	}
+	if name, err := lookupNetBIOS(ip, timeout); err == nil {
+		return name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		return strings.TrimSuffix(names[0], ".")
+	}
+	return ""
+}
+
+// lookupMDNS asks the host's mDNS responder for the name of its address. Queries
+// sent straight to port 5353 get a unicast reply.
+func lookupMDNS(ip string, timeout time.Duration) (string, error) {
+	addr := net.ParseIP(ip).To4()
+	if addr == nil {
+		return "", errors.New("not an IPv4 address")
+	}
+	reverse, err := dnsmessage.NewName(fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", addr[3], addr[2], addr[1], addr[0]))
+	if err != nil {
+		return "", err
+	}
+
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16))},
+		Questions: []dnsmessage.Question{{Name: reverse, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := exchangeUDP(net.JoinHostPort(ip, "5353"), packet, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(reply); err != nil {
+		return "", err
+	}
+	for _, answer := range msg.Answers {
+		if ptr, ok := answer.Body.(*dnsmessage.PTRResource); ok {
+			name := strings.TrimSuffix(ptr.PTR.String(), ".")
+			return strings.TrimSuffix(name, ".local"), nil
+		}
+	}
+	return "", errors.New("no PTR record in reply")
+}
+
+// netbiosStatusQuery is a NetBIOS node status request for the wildcard name "*"
+var netbiosStatusQuery = func() []byte {
+	var msg bytes.Buffer
+	msg.Write([]byte{0x13, 0x37, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	// "*" padded with NULs to 16 bytes, each byte split into two nibbles offset from 'A'
+	msg.WriteByte(0x20)
+	msg.WriteString("CK")
+	msg.WriteString(strings.Repeat("AA", 15))
+	msg.WriteByte(0x00)
+	binary.Write(&msg, binary.BigEndian, uint16(0x0021)) // NBSTAT
+	binary.Write(&msg, binary.BigEndian, uint16(0x0001)) // IN
+	return msg.Bytes()
+}()
+
+// lookupNetBIOS asks the host for its NetBIOS names and returns the workstation name
+func lookupNetBIOS(ip string, timeout time.Duration) (string, error) {
+	reply, err := exchangeUDP(net.JoinHostPort(ip, "137"), netbiosStatusQuery, timeout)
+	if err != nil {
+		return "", err
+	}
+	return parseNetBIOSStatus(reply)
+}
+
+// parseNetBIOSStatus extracts the first unique name with the workstation suffix
+// from a node status response
+func parseNetBIOSStatus(reply []byte) (string, error) {
+	offset := 12
+	if len(reply) <= offset {
+		return "", errors.New("short NetBIOS reply")
+	}
+	// The answer repeats the question name, either in full or as a pointer
+	if reply[offset]&0xC0 == 0xC0 {
+		offset += 2
+	} else {
+		offset += int(reply[offset]) + 2
+	}
+	offset += 2 + 2 + 4 + 2 // Type, class, TTL, data length
+
+	if len(reply) <= offset {
+		return "", errors.New("short NetBIOS reply")
+	}
+	count := int(reply[offset])
+	offset++
+
+	for i := 0; i < count && offset+18 <= len(reply); i, offset = i+1, offset+18 {
+		name := strings.TrimSpace(string(reply[offset : offset+15]))
+		suffix := reply[offset+15]
+		group := binary.BigEndian.Uint16(reply[offset+16:offset+18])&0x8000 != 0
+		if suffix == 0x00 && !group && name != "" {
+			return name, nil
+		}
+	}
+	return "", errors.New("no workstation name in NetBIOS reply")
+}
+
+// exchangeUDP sends a datagram and waits for one reply
+func exchangeUDP(address string, packet []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 1500)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply[:n], nil
+}
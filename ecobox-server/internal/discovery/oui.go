@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// builtinVendors covers manufacturers common in homelabs. A full list can be
+// loaded with discovery_oui_file.
+var builtinVendors = map[string]string{
+	"B827EB": "Raspberry Pi Foundation",
+	"DCA632": "Raspberry Pi Trading",
+	"E45F01": "Raspberry Pi Trading",
+	"D83ADD": "Raspberry Pi Trading",
+	"001132": "Synology",
+	"9009D0": "Synology",
+	"245EBE": "QNAP Systems",
+	"BC2411": "Proxmox Server Solutions",
+	"005056": "VMware",
+	"000C29": "VMware",
+	"080027": "Oracle VirtualBox",
+	"00155D": "Microsoft Hyper-V",
+	"525400": "QEMU/KVM",
+	"002590": "Supermicro",
+	"AC1F6B": "Supermicro",
+	"00E04C": "Realtek",
+	"001B21": "Intel",
+	"3CFDFE": "Intel",
+	"A0369F": "Intel",
+	"001422": "Dell",
+	"F8BC12": "Dell",
+	"1866DA": "Dell",
+	"7085C2": "ASRock",
+	"245A4C": "Ubiquiti",
+	"7483C2": "Ubiquiti",
+	"788A20": "Ubiquiti",
+	"802AA8": "Ubiquiti",
+	"F09FC2": "Ubiquiti",
+	"FCECDA": "Ubiquiti",
+	"50C7BF": "TP-Link",
+	"240AC4": "Espressif",
+	"30AEA4": "Espressif",
+}
+
+// ouiLine matches "00-11-32   (hex)  Synology" in IEEE oui.txt and
+// "00:11:32  Synology  Synology Incorporated" in Wireshark manuf files
+var ouiLine = regexp.MustCompile(`^([0-9A-Fa-f]{2})[:-]([0-9A-Fa-f]{2})[:-]([0-9A-Fa-f]{2})\s+(?:\(hex\)\s+)?(.+)$`)
+
+// Vendors maps the first three bytes of MAC addresses to manufacturer names
+type Vendors map[string]string
+
+// LoadVendors returns the built-in vendor table, extended with the entries of an
+// OUI file when path is set
+func LoadVendors(path string) (Vendors, error) {
+	vendors := make(Vendors, len(builtinVendors))
+	for prefix, name := range builtinVendors {
+		vendors[prefix] = name
+	}
+	if path == "" {
+		return vendors, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return vendors, fmt.Errorf("failed to open OUI file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		match := ouiLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		// Wireshark lists a short and a long name separated by a tab; keep the long one
+		fields := strings.Split(match[4], "\t")
+		name := strings.TrimSpace(fields[len(fields)-1])
+		vendors[strings.ToUpper(match[1]+match[2]+match[3])] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return vendors, fmt.Errorf("failed to read OUI file: %w", err)
+	}
+	return vendors, nil
+}
+
+// Lookup returns the manufacturer of a MAC address, or "" if unknown
+func (v Vendors) Lookup(mac string) string {
+	prefix := strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+	if len(prefix) < 6 {
+		return ""
+	}
+	return v[prefix[:6]]
+}
@@ -0,0 +1,208 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ecobox-server/internal/metrics"
+	"ecobox-server/internal/models"
+)
+
+// sensor describes a metric published as a Home Assistant sensor
+type sensor struct {
+	metric      string
+	name        string
+	unit        string
+	deviceClass string
+}
+
+// sensors are the metrics published when a server reports them
+var sensors = []sensor{
+	{metric: metrics.StandardMetrics.CPU, name: "CPU", unit: "%"},
+	{metric: metrics.StandardMetrics.Memory, name: "Memory", unit: "%"},
+	{metric: metrics.StandardMetrics.Wattage, name: "Power", unit: "W", deviceClass: "power"},
+}
+
+// device groups a server's entities in Home Assistant
+type device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// entity is a Home Assistant MQTT discovery payload
+type entity struct {
+	Name              string `json:"name"`
+	UniqueID          string `json:"unique_id"`
+	ObjectID          string `json:"object_id"`
+	Device            device `json:"device"`
+	AvailabilityTopic string `json:"availability_topic"`
+	StateTopic        string `json:"state_topic"`
+	ValueTemplate     string `json:"value_template"`
+	CommandTopic      string `json:"command_topic,omitempty"`
+	PayloadOn         string `json:"payload_on,omitempty"`
+	PayloadOff        string `json:"payload_off,omitempty"`
+	DeviceClass       string `json:"device_class,omitempty"`
+	UnitOfMeasurement string `json:"unit_of_measurement,omitempty"`
+	StateClass        string `json:"state_class,omitempty"`
+	Icon              string `json:"icon,omitempty"`
+	Precision         *int   `json:"suggested_display_precision,omitempty"`
+}
+
+// state is the retained JSON published to a server's state topic. The entities
+// read their values from it with templates.
+type state struct {
+	Power        string             `json:"power"` // "ON" while the server is on, "OFF" otherwise
+	State        models.PowerState  `json:"state"`
+	DesiredState models.PowerState  `json:"desired_state"`
+	Metrics      map[string]float64 `json:"metrics"`
+	Services     map[string]string  `json:"services"` // "ON" or "OFF" by service key
+}
+
+// newState describes a server for its state topic
+func newState(server *models.Server, latest map[string]float64) state {
+	s := state{
+		Power:        "OFF",
+		State:        server.CurrentState,
+		DesiredState: server.DesiredState,
+		Metrics:      make(map[string]float64),
+		Services:     make(map[string]string),
+	}
+	if server.CurrentState == models.PowerStateOn {
+		s.Power = "ON"
+	}
+	for _, sensor := range sensors {
+		if value, ok := latest[sensor.metric]; ok {
+			s.Metrics[sensor.metric] = value
+		}
+	}
+	for _, service := range server.Services {
+		s.Services[serviceKey(service)] = "OFF"
+		if service.Status == models.ServiceStatusUp {
+			s.Services[serviceKey(service)] = "ON"
+		}
+	}
+	return s
+}
+
+// discoveryConfigs returns the discovery payloads of a server's entities by
+// config topic. Metric sensors are included once the server has reported the
+// metric.
+func (b *Bridge) discoveryConfigs(server *models.Server, latest map[string]float64) map[string]string {
+	cfg := b.config.MQTT
+	nodeID := "ecobox_" + slug(server.ID)
+	model := "Server"
+	if server.IsProxmoxVM {
+		model = "Proxmox VM"
+	}
+	base := entity{
+		Device: device{
+			Identifiers:  []string{nodeID},
+			Name:         server.Name,
+			Manufacturer: "EcoBox",
+			Model:        model,
+		},
+		AvailabilityTopic: b.availabilityTopic(),
+		StateTopic:        b.stateTopic(server.ID),
+	}
+
+	configs := make(map[string]string)
+	add := func(component, object string, e entity) {
+		e.UniqueID = nodeID + "_" + object
+		e.ObjectID = nodeID + "_" + object
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		configs[fmt.Sprintf("%s/%s/%s/%s/config", cfg.DiscoveryPrefix, component, nodeID, object)] = string(payload)
+	}
+
+	power := base
+	power.Name = "Power"
+	power.ValueTemplate = "{{ value_json.power }}"
+	power.PayloadOn = "ON"
+	power.PayloadOff = "OFF"
+	power.Icon = "mdi:server"
+	if cfg.ReadOnly {
+		power.DeviceClass = "power"
+		add("binary_sensor", "power", power)
+	} else {
+		power.CommandTopic = b.commandTopic(server.ID)
+		power.DeviceClass = "switch"
+		add("switch", "power", power)
+	}
+
+	powerState := base
+	powerState.Name = "State"
+	powerState.ValueTemplate = "{{ value_json.state }}"
+	powerState.Icon = "mdi:power-settings"
+	add("sensor", "state", powerState)
+
+	for _, sensor := range sensors {
+		if _, ok := latest[sensor.metric]; !ok {
+			continue
+		}
+		precision := 1
+		metric := base
+		metric.Name = sensor.name
+		metric.ValueTemplate = fmt.Sprintf("{{ value_json.metrics.%s }}", sensor.metric)
+		metric.UnitOfMeasurement = sensor.unit
+		metric.DeviceClass = sensor.deviceClass
+		metric.StateClass = "measurement"
+		metric.Precision = &precision
+		add("sensor", sensor.metric, metric)
+	}
+
+	for _, service := range server.Services {
+		key := serviceKey(service)
+		binary := base
+		binary.Name = service.Name
+		binary.ValueTemplate = fmt.Sprintf("{{ value_json.services['%s'] }}", key)
+		binary.PayloadOn = "ON"
+		binary.PayloadOff = "OFF"
+		binary.DeviceClass = "connectivity"
+		add("binary_sensor", "service_"+key, binary)
+	}
+
+	return configs
+}
+
+// serviceKey identifies a service within its server's state. Names are used
+// when they make a usable key, since ports can be shared by several checks.
+func serviceKey(service models.Service) string {
+	if key := slug(service.Name); key != "" {
+		return key
+	}
+	return fmt.Sprintf("port_%d", service.Port)
+}
+
+// slug lowercases a name and replaces everything but letters and digits with
+// underscores, as Home Assistant does for object IDs
+func slug(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// sortedTopics returns the keys of a topic map in order, so entities are
+// announced in a stable order
+func sortedTopics(configs map[string]string) []string {
+	topics := make([]string, 0, len(configs))
+	for topic := range configs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
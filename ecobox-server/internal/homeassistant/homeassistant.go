@@ -0,0 +1,365 @@
+// Package homeassistant bridges the dashboard to Home Assistant over MQTT.
+//
+// Each server is announced through MQTT discovery as a device with a power
+// switch, a state sensor, CPU, memory and power sensors once the server reports
+// them, and a connectivity binary sensor per service. The entities read a
+// retained JSON state topic per server that is republished on every monitor
+// update. Commands on a server's set topic request a desired state the same way
+// the HTTP API does, so the reconciler carries them out.
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/audit"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/mqtt"
+	"ecobox-server/internal/secrets"
+	"ecobox-server/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxReconnectDelay caps the wait between connection attempts
+	maxReconnectDelay = time.Minute
+	// commandRequester is recorded as the requester of commands
+	commandRequester = "mqtt"
+)
+
+// Bridge publishes servers to Home Assistant and applies its commands
+type Bridge struct {
+	config    *config.Config
+	storage   storage.Storage
+	monitor   *monitor.Monitor
+	audit     *audit.Log
+	secrets   *secrets.Vault                // Optional, see SetSecrets
+	client    *mqtt.Client                  // Current connection, nil while disconnected
+	published map[string]string             // Discovery payloads by config topic, for this connection
+	metrics   map[string]map[string]float64 // Latest metrics by server ID
+	mu        sync.Mutex
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+	logger    *logrus.Logger
+}
+
+// NewBridge creates a bridge for the configured broker
+func NewBridge(cfg *config.Config, storage storage.Storage, monitor *monitor.Monitor) *Bridge {
+	return &Bridge{
+		config:    cfg,
+		storage:   storage,
+		monitor:   monitor,
+		published: make(map[string]string),
+		metrics:   make(map[string]map[string]float64),
+		stopChan:  make(chan struct{}),
+		done:      make(chan struct{}),
+		logger:    logrus.New(),
+	}
+}
+
+// SetLogger sets the logger for the bridge
+func (b *Bridge) SetLogger(logger *logrus.Logger) {
+	b.logger = logger
+}
+
+// SetAuditLog records commands in the audit log
+func (b *Bridge) SetAuditLog(log *audit.Log) {
+	b.audit = log
+}
+
+// SetSecrets sets the vault the broker password is read from
+func (b *Bridge) SetSecrets(vault *secrets.Vault) {
+	b.secrets = vault
+}
+
+// Start connects to the broker in the background and keeps reconnecting until
+// Stop is called. It does nothing without a configured broker.
+func (b *Bridge) Start() {
+	if !b.config.MQTT.Enabled() {
+		close(b.done)
+		return
+	}
+	b.logger.Infof("Starting MQTT bridge to %s", b.config.MQTT.Broker)
+	go b.run(b.monitor.Subscribe())
+}
+
+// Stop marks the bridge offline and disconnects
+func (b *Bridge) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopChan)
+		<-b.done
+	})
+}
+
+// run connects, serves the connection until it fails and reconnects with a
+// growing delay
+func (b *Bridge) run(updates <-chan monitor.ServerUpdate) {
+	defer close(b.done)
+
+	delay := time.Second
+	for {
+		client, err := b.connect()
+		if err != nil {
+			b.logger.Warnf("MQTT bridge failed to connect, retrying in %v: %v", delay, err)
+			select {
+			case <-time.After(delay):
+			case <-b.stopChan:
+				return
+			}
+			if delay *= 2; delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+
+		delay = time.Second
+		if stopped := b.serve(client, updates); stopped {
+			return
+		}
+	}
+}
+
+// connect dials the broker with an offline last will, announces the bridge
+// online and subscribes to the command topics
+func (b *Bridge) connect() (*mqtt.Client, error) {
+	cfg := b.config.MQTT
+	password, err := b.secrets.Resolve(cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mqtt.Dial(mqtt.Options{
+		Broker:      cfg.Broker,
+		ClientID:    cfg.ClientID,
+		Username:    cfg.Username,
+		Password:    password,
+		KeepAlive:   time.Duration(cfg.KeepAlive) * time.Second,
+		WillTopic:   b.availabilityTopic(),
+		WillPayload: []byte("offline"),
+		WillRetain:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Publish(b.availabilityTopic(), []byte("online"), true); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if !cfg.ReadOnly {
+		if err := client.Subscribe(b.commandTopic("+")); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	b.client = client
+	b.published = make(map[string]string) // Announce everything again
+	b.mu.Unlock()
+
+	b.logger.Infof("MQTT bridge connected to %s", cfg.Broker)
+	return client, nil
+}
+
+// serve publishes every server, then each update, until the connection fails
+// or the bridge stops. It reports whether the bridge stopped.
+func (b *Bridge) serve(client *mqtt.Client, updates <-chan monitor.ServerUpdate) bool {
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- client.Listen(b.handleMessage)
+	}()
+
+	b.publishAll()
+
+	ping := time.NewTicker(time.Duration(b.config.MQTT.KeepAlive) * time.Second / 2)
+	defer ping.Stop()
+
+	for {
+		select {
+		case update := <-updates:
+			b.handleUpdate(update)
+		case <-ping.C:
+			if err := client.Ping(); err != nil {
+				b.disconnect(client)
+			}
+		case err := <-listenErr:
+			b.logger.Warnf("MQTT bridge lost its connection: %v", err)
+			b.disconnect(client)
+			return false
+		case <-b.stopChan:
+			client.Publish(b.availabilityTopic(), []byte("offline"), true)
+			b.disconnect(client)
+			return true
+		}
+	}
+}
+
+// disconnect closes the connection; serve notices through Listen returning
+func (b *Bridge) disconnect(client *mqtt.Client) {
+	b.mu.Lock()
+	if b.client == client {
+		b.client = nil
+	}
+	b.mu.Unlock()
+	client.Close()
+}
+
+// handleUpdate remembers reported metrics and publishes the server
+func (b *Bridge) handleUpdate(update monitor.ServerUpdate) {
+	if len(update.Metrics) > 0 {
+		b.mu.Lock()
+		b.metrics[update.ServerID] = update.Metrics
+		b.mu.Unlock()
+	}
+	b.publishAll()
+}
+
+// publishAll announces new or changed entities, removes those of deleted
+// servers and services, and publishes the state of every server
+func (b *Bridge) publishAll() {
+	servers := b.storage.GetAllServers()
+
+	b.mu.Lock()
+	client := b.client
+	if client == nil {
+		b.mu.Unlock()
+		return
+	}
+	configs := make(map[string]string)
+	states := make(map[string]state)
+	for id, server := range servers {
+		for topic, payload := range b.discoveryConfigs(server, b.metrics[id]) {
+			configs[topic] = payload
+		}
+		states[id] = newState(server, b.metrics[id])
+	}
+
+	var changed, removed []string
+	for _, topic := range sortedTopics(configs) {
+		if b.published[topic] != configs[topic] {
+			changed = append(changed, topic)
+		}
+	}
+	for _, topic := range sortedTopics(b.published) {
+		if _, ok := configs[topic]; !ok {
+			removed = append(removed, topic)
+		}
+	}
+	b.published = configs
+	b.mu.Unlock()
+
+	// Discovery comes first so new entities pick up the state that follows
+	for _, topic := range changed {
+		if !b.publish(client, topic, []byte(configs[topic])) {
+			return
+		}
+	}
+	for _, topic := range removed {
+		if !b.publish(client, topic, nil) { // An empty retained config removes the entity
+			return
+		}
+	}
+	for id, s := range states {
+		payload, err := json.Marshal(s)
+		if err != nil {
+			continue
+		}
+		if !b.publish(client, b.stateTopic(id), payload) {
+			return
+		}
+	}
+}
+
+// publish sends a retained message and drops the connection when that fails
+func (b *Bridge) publish(client *mqtt.Client, topic string, payload []byte) bool {
+	if err := client.Publish(topic, payload, true); err != nil {
+		b.logger.Warnf("MQTT bridge failed to publish to %s: %v", topic, err)
+		b.disconnect(client)
+		return false
+	}
+	return true
+}
+
+// handleMessage applies a command from a server's set topic. "ON" wakes the
+// server and "OFF" shuts it down as the shutdown endpoint does; any desired
+// state name is also accepted.
+func (b *Bridge) handleMessage(topic string, payload []byte) {
+	serverID, ok := b.parseCommandTopic(topic)
+	if !ok {
+		return
+	}
+
+	server, err := b.storage.GetServer(serverID)
+	if err != nil {
+		b.logger.Warnf("MQTT command for unknown server %s", serverID)
+		return
+	}
+
+	command := strings.TrimSpace(string(payload))
+	var state models.PowerState
+	switch strings.ToUpper(command) {
+	case "ON":
+		state = models.PowerStateOn
+	case "OFF":
+		state = models.PowerStateSuspended
+		if server.IsProxmoxVM {
+			state = models.PowerStateStopped
+		}
+	default:
+		state = models.PowerState(strings.ToLower(command))
+	}
+
+	entry := audit.Entry{
+		Actor:     commandRequester,
+		ActorType: audit.ActorMQTT,
+		Action:    "mqtt.set",
+		Target:    server.ID,
+		Before:    audit.Snapshot(map[string]models.PowerState{"desired_state": server.DesiredState}),
+	}
+	op, err := b.monitor.RequestPowerState(server.ID, monitor.PowerStateRequest{
+		State:       state,
+		Reason:      "Home Assistant",
+		RequestedBy: commandRequester,
+	})
+	if err != nil {
+		b.logger.Warnf("MQTT command %q for %s rejected: %v", command, server.Name, err)
+		entry.Error = err.Error()
+		b.audit.Record(entry)
+		return
+	}
+	entry.Success = true
+	entry.After = audit.Snapshot(map[string]models.PowerState{"desired_state": state})
+	b.audit.Record(entry)
+	b.logger.Infof("MQTT command set desired state of %s to %s (operation %s)", server.Name, state, op.ID)
+}
+
+// parseCommandTopic returns the server ID of a set topic
+func (b *Bridge) parseCommandTopic(topic string) (string, bool) {
+	prefix := b.config.MQTT.TopicPrefix + "/"
+	if !strings.HasPrefix(topic, prefix) || !strings.HasSuffix(topic, "/set") {
+		return "", false
+	}
+	serverID := strings.TrimSuffix(strings.TrimPrefix(topic, prefix), "/set")
+	if serverID == "" || strings.Contains(serverID, "/") {
+		return "", false
+	}
+	return serverID, true
+}
+
+func (b *Bridge) availabilityTopic() string {
+	return b.config.MQTT.TopicPrefix + "/status"
+}
+
+func (b *Bridge) stateTopic(serverID string) string {
+	return fmt.Sprintf("%s/%s/state", b.config.MQTT.TopicPrefix, serverID)
+}
+
+func (b *Bridge) commandTopic(serverID string) string {
+	return fmt.Sprintf("%s/%s/set", b.config.MQTT.TopicPrefix, serverID)
+}
@@ -0,0 +1,118 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/mqtt/mqtttest"
+	"ecobox-server/internal/storage"
+)
+
+func TestBridgeDiscoveryStateAndCommands(t *testing.T) {
+	mqttBroker := mqtttest.NewBroker(t)
+
+	cfg := &config.Config{}
+	cfg.Dashboard.MetricsDataDir = t.TempDir()
+	cfg.MQTT.Broker = mqttBroker.URL()
+	cfg.SetDefaults()
+
+	store := storage.NewMemoryStorage()
+	server := &models.Server{
+		ID:           "nas",
+		Name:         "NAS",
+		Hostname:     "127.0.0.1",
+		MACAddress:   "AA:BB:CC:DD:EE:FF",
+		CurrentState: models.PowerStateOn,
+		DesiredState: models.PowerStateSuspended,
+		Services: []models.Service{
+			{ID: "nas-smb", Name: "SMB Shares", Port: 445, Status: models.ServiceStatusUp},
+		},
+	}
+	if err := store.AddServer(server); err != nil {
+		t.Fatalf("Failed to add server: %v", err)
+	}
+
+	mon := monitor.NewMonitor(cfg, store, control.NewPowerManager(store))
+	bridge := NewBridge(cfg, store, mon)
+	updates := make(chan monitor.ServerUpdate, 1)
+	go bridge.run(updates)
+	t.Cleanup(bridge.Stop)
+
+	conn := <-mqttBroker.Conn
+	messages := make(map[string]mqtttest.Message)
+
+	if m := mqttBroker.Collect(t, messages, "ecobox/status"); m.Payload != "online" || !m.Retain {
+		t.Errorf("Expected a retained online status, got %+v", m)
+	}
+
+	var power entity
+	m := mqttBroker.Collect(t, messages, "homeassistant/switch/ecobox_nas/power/config")
+	if err := json.Unmarshal([]byte(m.Payload), &power); err != nil || !m.Retain {
+		t.Fatalf("Invalid switch config %q (%v)", m.Payload, err)
+	}
+	if power.CommandTopic != "ecobox/nas/set" || power.StateTopic != "ecobox/nas/state" || power.Device.Name != "NAS" || power.AvailabilityTopic != "ecobox/status" {
+		t.Errorf("Unexpected switch config: %+v", power)
+	}
+	if m := mqttBroker.Collect(t, messages, "homeassistant/binary_sensor/ecobox_nas/service_smb_shares/config"); !strings.Contains(m.Payload, `value_json.services['smb_shares']`) {
+		t.Errorf("Unexpected service config: %s", m.Payload)
+	}
+
+	var s state
+	if err := json.Unmarshal([]byte(mqttBroker.Collect(t, messages, "ecobox/nas/state").Payload), &s); err != nil {
+		t.Fatalf("Invalid state: %v", err)
+	}
+	if s.Power != "ON" || s.Services["smb_shares"] != "ON" {
+		t.Errorf("Unexpected state: %+v", s)
+	}
+
+	// Metrics add their sensors
+	updates <- monitor.ServerUpdate{ServerID: "nas", State: models.PowerStateOn, Metrics: map[string]float64{"cpu": 12.5, "wattage": 31}}
+	if m := mqttBroker.Collect(t, messages, "homeassistant/sensor/ecobox_nas/wattage/config"); !strings.Contains(m.Payload, `"unit_of_measurement":"W"`) {
+		t.Errorf("Unexpected wattage config: %s", m.Payload)
+	}
+
+	// Commands go through the desired state path
+	if topic := <-mqttBroker.Subscribed; topic != "ecobox/+/set" {
+		t.Fatalf("Expected a subscription to the command topics, got %s", topic)
+	}
+	mqttBroker.Send(conn, "ecobox/nas/set", "ON")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		updated, _ := store.GetServer("nas")
+		if updated.DesiredState == models.PowerStateOn {
+			if updated.Intent == nil || updated.Intent.RequestedBy != "mqtt" {
+				t.Errorf("Expected an intent requested over MQTT, got %+v", updated.Intent)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the command to set the desired state")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseCommandTopic(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.SetDefaults()
+	bridge := NewBridge(cfg, nil, nil)
+
+	tests := map[string]string{
+		"ecobox/nas/set":        "nas",
+		"ecobox/nas/state":      "",
+		"ecobox//set":           "",
+		"ecobox/a/b/set":        "",
+		"homeassistant/nas/set": "",
+	}
+	for topic, want := range tests {
+		if got, _ := bridge.parseCommandTopic(topic); got != want {
+			t.Errorf("%s: expected %q, got %q", topic, want, got)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package initializer
 import (
 	"ecobox-server/internal/control"
 	"ecobox-server/internal/models"
+	"ecobox-server/internal/secrets"
 	"ecobox-server/internal/storage"
 	"github.com/sirupsen/logrus"
 )
@@ -29,6 +30,11 @@ func (m *Manager) SetLogger(logger *logrus.Logger) {
 	m.logger = logger
 }
 
+// SetSecrets sets the vault SSH keys are read from
+func (m *Manager) SetSecrets(vault *secrets.Vault) {
+	m.systemMonitor.SetSecrets(vault)
+}
+
 // InitializeServer performs initial setup for a server
 func (m *Manager) InitializeServer(server *models.Server) error {
 	m.logger.Infof("Initializing server %s (%s)", server.Name, server.Hostname)
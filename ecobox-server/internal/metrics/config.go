@@ -1,5 +1,7 @@
 package metrics
 
+import "fmt"
+
 // StandardMetrics defines the consistent metric names used across the system
 // These names are shared between backend recording and frontend display
 var StandardMetrics = struct {
@@ -19,6 +21,9 @@ var StandardMetrics = struct {
 	PowerStateWaking     string  // New transitioning state
 	PowerStateSuspending string  // New transitioning state
 	PowerStateStopping   string  // New transitioning state
+	PowerStateHibernated  string
+	PowerStateHibernating string
+	PowerStateRestarting  string
 	
 	// Wake/suspend operations
 	WakeAttempt       string
@@ -29,6 +34,15 @@ var StandardMetrics = struct {
 	SuspendSuccess    string
 	SuspendFailure    string
 	SuspendDuration   string
+	HibernateAttempt  string
+	HibernateSuccess  string
+	HibernateFailure  string
+	RestartAttempt    string
+	RestartSuccess    string
+	RestartFailure    string
+	ResetAttempt      string
+	ResetSuccess      string
+	ResetFailure      string
 	
 	// Initialization metrics
 	InitAttempt         string
@@ -42,6 +56,15 @@ var StandardMetrics = struct {
 	// Service monitoring
 	ServiceAvailability string
 	StateUpdateError    string
+	ServiceResponseTime string // Per service, see ServiceMetric
+	ServiceCertDaysLeft string // Per HTTPS-checked service, see ServiceMetric
+	
+	// Wake-on-demand proxy (recorded per service, see ServiceMetric)
+	ProxyConnection    string
+	ProxyActiveConnections string
+	ProxyWakeTriggered string
+	ProxyWakeDuration  string
+	ProxyWakeTimeout   string
 	
 	// System checks
 	SystemCheckAttempt   string
@@ -73,6 +96,9 @@ var StandardMetrics = struct {
 	PowerStateWaking:     "power_state_waking",     // New transitioning state
 	PowerStateSuspending: "power_state_suspending", // New transitioning state
 	PowerStateStopping:   "power_state_stopping",   // New transitioning state
+	PowerStateHibernated:  "power_state_hibernated",
+	PowerStateHibernating: "power_state_hibernating",
+	PowerStateRestarting:  "power_state_restarting",
 	
 	// Wake/suspend operations
 	WakeAttempt:     "wake_attempt",
@@ -83,6 +109,15 @@ var StandardMetrics = struct {
 	SuspendSuccess:  "suspend_success",
 	SuspendFailure:  "suspend_failure", 
 	SuspendDuration: "suspend_duration_seconds",
+	HibernateAttempt: "hibernate_attempt",
+	HibernateSuccess: "hibernate_success",
+	HibernateFailure: "hibernate_failure",
+	RestartAttempt:   "restart_attempt",
+	RestartSuccess:   "restart_success",
+	RestartFailure:   "restart_failure",
+	ResetAttempt:     "reset_attempt",
+	ResetSuccess:     "reset_success",
+	ResetFailure:     "reset_failure",
 	
 	// Initialization metrics
 	InitAttempt:            "init_attempt",
@@ -96,6 +131,15 @@ var StandardMetrics = struct {
 	// Service monitoring  
 	ServiceAvailability: "service_availability_percent",
 	StateUpdateError:    "state_update_error",
+	ServiceResponseTime: "service_response_time_ms",
+	ServiceCertDaysLeft: "service_cert_days_left",
+	
+	// Wake-on-demand proxy
+	ProxyConnection:        "proxy_connection",
+	ProxyActiveConnections: "proxy_active_connections",
+	ProxyWakeTriggered:     "proxy_wake_triggered",
+	ProxyWakeDuration:      "proxy_wake_duration_seconds",
+	ProxyWakeTimeout:       "proxy_wake_timeout",
 	
 	// System checks
 	SystemCheckAttempt:  "system_check_attempt",
@@ -141,6 +185,9 @@ func AllMetrics() []string {
 		StandardMetrics.PowerStateWaking,     // New transitioning state
 		StandardMetrics.PowerStateSuspending, // New transitioning state
 		StandardMetrics.PowerStateStopping,   // New transitioning state
+		StandardMetrics.PowerStateHibernated,
+		StandardMetrics.PowerStateHibernating,
+		StandardMetrics.PowerStateRestarting,
 		
 		// Wake/suspend operations
 		StandardMetrics.WakeAttempt,
@@ -151,6 +198,15 @@ func AllMetrics() []string {
 		StandardMetrics.SuspendSuccess,
 		StandardMetrics.SuspendFailure,
 		StandardMetrics.SuspendDuration,
+		StandardMetrics.HibernateAttempt,
+		StandardMetrics.HibernateSuccess,
+		StandardMetrics.HibernateFailure,
+		StandardMetrics.RestartAttempt,
+		StandardMetrics.RestartSuccess,
+		StandardMetrics.RestartFailure,
+		StandardMetrics.ResetAttempt,
+		StandardMetrics.ResetSuccess,
+		StandardMetrics.ResetFailure,
 		
 		// Initialization
 		StandardMetrics.InitAttempt,
@@ -164,6 +220,15 @@ func AllMetrics() []string {
 		// Service monitoring
 		StandardMetrics.ServiceAvailability,
 		StandardMetrics.StateUpdateError,
+		StandardMetrics.ServiceResponseTime,
+		StandardMetrics.ServiceCertDaysLeft,
+		
+		// Wake-on-demand proxy
+		StandardMetrics.ProxyConnection,
+		StandardMetrics.ProxyActiveConnections,
+		StandardMetrics.ProxyWakeTriggered,
+		StandardMetrics.ProxyWakeDuration,
+		StandardMetrics.ProxyWakeTimeout,
 		
 		// System checks
 		StandardMetrics.SystemCheckAttempt,
@@ -180,3 +245,9 @@ func AllMetrics() []string {
 		StandardMetrics.CheckedServers,
 	}
 }
+
+// ServiceMetric returns the name of a metric recorded for a single service,
+// e.g. "proxy_connection_8096" for the service on port 8096
+func ServiceMetric(metricName string, port int) string {
+	return fmt.Sprintf("%s_%d", metricName, port)
+}
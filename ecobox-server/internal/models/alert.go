@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// AlertCondition is a failure an alert rule watches for
+type AlertCondition string
+
+const (
+	AlertConditionServiceDown AlertCondition = "service_down" // A service check fails while its server is on
+	AlertConditionWakeFailure AlertCondition = "wake_failure" // Sending a wake command fails
+	AlertConditionWakeTimeout AlertCondition = "wake_timeout" // A woken server does not come up in time
+	AlertConditionInitFailed  AlertCondition = "init_failed"  // Initialization gave up after its retries
+)
+
+// AlertConditions lists the supported conditions
+var AlertConditions = []AlertCondition{
+	AlertConditionServiceDown,
+	AlertConditionWakeFailure,
+	AlertConditionWakeTimeout,
+	AlertConditionInitFailed,
+}
+
+type AlertSeverity string
+
+const (
+	AlertSeverityInfo     AlertSeverity = "info"
+	AlertSeverityWarning  AlertSeverity = "warning"
+	AlertSeverityCritical AlertSeverity = "critical"
+)
+
+type AlertStatus string
+
+const (
+	AlertStatusFiring   AlertStatus = "firing"
+	AlertStatusResolved AlertStatus = "resolved"
+)
+
+// Alert is a rule firing for a server, or for one of its services
+type Alert struct {
+	ID           string         `json:"id"`
+	Rule         string         `json:"rule"`
+	Condition    AlertCondition `json:"condition"`
+	Severity     AlertSeverity  `json:"severity"`
+	Status       AlertStatus    `json:"status"`
+	ServerID     string         `json:"server_id"`
+	ServerName   string         `json:"server_name"`
+	Service      string         `json:"service,omitempty"`
+	Message      string         `json:"message"`
+	Failures     int            `json:"failures"` // Consecutive failed observations
+	StartedAt    time.Time      `json:"started_at"`
+	ResolvedAt   *time.Time     `json:"resolved_at,omitempty"`
+	LastNotified *time.Time     `json:"last_notified,omitempty"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// PowerIntent records who asked for a server's desired state, why, and for how long
+type PowerIntent struct {
+	State        PowerState `json:"state"`
+	Reason       string     `json:"reason,omitempty"`
+	RequestedBy  string     `json:"requested_by"`
+	RequestedAt  time.Time  `json:"requested_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`     // When set, the desired state reverts to RevertTo afterwards
+	RevertTo     PowerState `json:"revert_to,omitempty"`      // Desired state that was in effect before this intent
+	Force        bool       `json:"force,omitempty"`          // Use a forced stop instead of a clean shutdown (Proxmox VMs)
+	HeldByLeases bool       `json:"held_by_leases,omitempty"` // Intent is maintained by keep-awake leases
+	OperationID  string     `json:"operation_id,omitempty"`
+
+	// Intent the leases hold the server on over, restored once they end
+	Previous *PowerIntent `json:"previous,omitempty"`
+}
+
+// IsExpired returns true if the intent has an expiry that has passed
+func (i *PowerIntent) IsExpired(now time.Time) bool {
+	return i != nil && i.ExpiresAt != nil && !now.Before(*i.ExpiresAt)
+}
+
+// Lease keeps a server on for a limited time on behalf of a client or service
+type Lease struct {
+	ID        string    `json:"id"`
+	Holder    string    `json:"holder"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IsExpired returns true if the lease has run out
+func (l Lease) IsExpired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+type OperationStatus string
+
+const (
+	OperationStatusPending    OperationStatus = "pending" // Recorded, waiting for the reconciler
+	OperationStatusRunning    OperationStatus = "running" // Reconciler has acted and is waiting for the result
+	OperationStatusSucceeded  OperationStatus = "succeeded"
+	OperationStatusFailed     OperationStatus = "failed"
+	OperationStatusSuperseded OperationStatus = "superseded" // Replaced by a newer request for the same server
+)
+
+// Operation tracks the progress of a requested power change until it completes
+type Operation struct {
+	ID          string          `json:"id"`
+	ServerID    string          `json:"server_id"`
+	Action      ActionType      `json:"action"`
+	TargetState PowerState      `json:"target_state,omitempty"`
+	Status      OperationStatus `json:"status"`
+	Message     string          `json:"message,omitempty"`
+	Attempts    int             `json:"attempts"`
+	RequestedBy string          `json:"requested_by"`
+	Reason      string          `json:"reason,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// IsFinished returns true once the operation has reached a final outcome
+func (o *Operation) IsFinished() bool {
+	switch o.Status {
+	case OperationStatusSucceeded, OperationStatusFailed, OperationStatusSuperseded:
+		return true
+	}
+	return false
+}
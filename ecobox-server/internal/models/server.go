@@ -1,10 +1,17 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
+// groupNamePattern allows short lowercase names such as "lab" or "k8s-workers"
+var groupNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
 type Server struct {
 	ID             string       `json:"id"`
 	Name           string       `json:"name"`
@@ -12,7 +19,11 @@ type Server struct {
 	MACAddress     string       `json:"mac_address"`
 	CurrentState   PowerState   `json:"current_state"`
 	DesiredState   PowerState   `json:"desired_state"`
+	Intent         *PowerIntent `json:"intent,omitempty"` // Who requested the desired state and until when
+	Leases         []Lease      `json:"leases,omitempty"` // Keep-awake leases holding the server on
 	ParentServerID string       `json:"parent_server_id"`
+	Dependencies   []Dependency `json:"dependencies,omitempty"` // Servers and services that must be up first
+	Groups         []string     `json:"groups,omitempty"`       // Named groups for filtering and bulk actions
 	Initialized    bool         `json:"initialized"`
 
 	// Initialization tracking
@@ -42,10 +53,12 @@ type Server struct {
 
 	// Proxmox-specific fields
 	ProxmoxAPIKey    *ProxmoxAPIKey `json:"proxmox_api_key,omitempty"`    // Only set if this is a Proxmox host
+	ProxmoxTokenRef  string         `json:"proxmox_token,omitempty"`      // Vault reference of a configured API token, instead of creating one
 	IsProxmoxVM      bool           `json:"is_proxmox_vm"`                // True if this server is a Proxmox VM
 	ProxmoxVMID      int            `json:"proxmox_vm_id,omitempty"`      // VMID if this is a Proxmox VM
 	ProxmoxNodeName  string         `json:"proxmox_node_name,omitempty"`  // Node name for Proxmox operations
 	LastVMDiscovery  time.Time      `json:"last_vm_discovery"`            // Last time we discovered VMs (for Proxmox hosts)
+	VMPowerPolicy    *VMPowerPolicy `json:"vm_power_policy,omitempty"`    // Power policy if this is a Proxmox VM
 }
 
 type ServerAction struct {
@@ -90,6 +103,164 @@ func (s *Server) GetProxmoxAPIToken() string {
 		s.ProxmoxAPIKey.Secret)
 }
 
+// ParseProxmoxAPIToken parses a token in the "user@realm!tokenid=secret" form
+// Proxmox expects in the Authorization header
+func ParseProxmoxAPIToken(token string) (*ProxmoxAPIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimSpace(token), "=")
+	user, tokenID, hasTokenID := strings.Cut(id, "!")
+	username, realm, hasRealm := strings.Cut(user, "@")
+	if !ok || !hasTokenID || !hasRealm || username == "" || realm == "" || tokenID == "" || secret == "" {
+		return nil, fmt.Errorf("proxmox API token must look like user@realm!tokenid=secret")
+	}
+	return &ProxmoxAPIKey{Username: username, Realm: realm, TokenID: tokenID, Secret: secret}, nil
+}
+
+// GetVMSuspendMode returns the configured suspend mode for a Proxmox VM, defaulting to pause
+func (s *Server) GetVMSuspendMode() VMSuspendMode {
+	if s.VMPowerPolicy == nil || s.VMPowerPolicy.SuspendMode == "" {
+		return VMSuspendModePause
+	}
+	return s.VMPowerPolicy.SuspendMode
+}
+
+// ShouldSnapshotBeforeStop returns true if a snapshot should be taken before a forced stop
+func (s *Server) ShouldSnapshotBeforeStop() bool {
+	return s.IsProxmoxVM && s.VMPowerPolicy != nil && s.VMPowerPolicy.SnapshotBeforeStop
+}
+
+// InDesiredState reports whether the current state fulfils the desired state.
+// For Proxmox VMs a desired "suspended" state is also met by the state their
+// suspend mode produces (hibernated or stopped).
+func (s *Server) InDesiredState() bool {
+	if s.CurrentState == s.DesiredState {
+		return true
+	}
+	if s.DesiredState == PowerStateSuspended && s.IsProxmoxVM {
+		switch s.GetVMSuspendMode() {
+		case VMSuspendModeHibernate:
+			return s.CurrentState == PowerStateHibernated
+		case VMSuspendModeShutdown, VMSuspendModeStop:
+			return s.CurrentState == PowerStateStopped
+		}
+	}
+	return false
+}
+
+// GetPowerCapabilities returns the power actions this server supports.
+// Physical hosts rely on the capabilities detected over SSH during the
+// system check; Proxmox VMs support every action through the API.
+func (s *Server) GetPowerCapabilities() PowerCapabilities {
+	if s.IsProxmoxVM {
+		return PowerCapabilities{
+			Wake:      true,
+			Suspend:   true,
+			Hibernate: true,
+			Shutdown:  true,
+			Stop:      true,
+			Restart:   true,
+			Reset:     true,
+		}
+	}
+
+	caps := PowerCapabilities{
+		Wake: s.MACAddress != "",
+		// Suspend falls back to trying several commands, so allow it until detection says otherwise
+		Suspend: s.SystemInfo == nil || s.SystemInfo.SuspendSupport,
+	}
+	caps.Shutdown = caps.Suspend // Shutdown of physical hosts is currently a suspend
+
+	if s.SystemInfo != nil {
+		switch s.SystemInfo.Type {
+		case SystemTypeLinux, SystemTypeProxmox:
+			caps.Hibernate = s.SystemInfo.HibernateSupport
+			caps.Restart = true
+		case SystemTypeWindows:
+			caps.Restart = true
+		}
+	}
+
+	return caps
+}
+
+// MarshalJSON includes the derived power capabilities alongside the stored fields
+func (s Server) MarshalJSON() ([]byte, error) {
+	type serverFields Server
+	return json.Marshal(struct {
+		serverFields
+		Capabilities PowerCapabilities `json:"capabilities"`
+	}{
+		serverFields: serverFields(s),
+		Capabilities: s.GetPowerCapabilities(),
+	})
+}
+
+// ActiveLeases returns the leases that have not expired yet
+func (s *Server) ActiveLeases(now time.Time) []Lease {
+	active := make([]Lease, 0, len(s.Leases))
+	for _, lease := range s.Leases {
+		if !lease.IsExpired(now) {
+			active = append(active, lease)
+		}
+	}
+	return active
+}
+
+// GetDependencies returns everything this server depends on. The parent server
+// (physical host or Proxmox host) is always a hard dependency.
+func (s *Server) GetDependencies() []Dependency {
+	deps := make([]Dependency, 0, len(s.Dependencies)+1)
+	if s.ParentServerID != "" {
+		deps = append(deps, Dependency{ServerID: s.ParentServerID, Type: DependencyTypeHard})
+	}
+	return append(deps, s.Dependencies...)
+}
+
+// IsActive returns true if the server is running or on its way up, so servers it
+// depends on must stay available
+func (s *Server) IsActive() bool {
+	switch s.CurrentState {
+	case PowerStateOn, PowerStateWaking, PowerStateRestarting:
+		return true
+	}
+	return false
+}
+
+// IsGoingDown returns true if the server is meant to be suspended, hibernated or stopped
+func (s *Server) IsGoingDown() bool {
+	switch s.DesiredState {
+	case PowerStateSuspended, PowerStateHibernated, PowerStateStopped:
+		return true
+	}
+	return false
+}
+
+// InGroup returns true if the server is a member of the named group
+func (s *Server) InGroup(group string) bool {
+	for _, g := range s.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeGroups validates group names and returns them sorted without duplicates
+func NormalizeGroups(groups []string) ([]string, error) {
+	seen := make(map[string]bool, len(groups))
+	normalized := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if !groupNamePattern.MatchString(group) {
+			return nil, fmt.Errorf("invalid group name '%s', use lowercase letters, digits, '-' and '_'", group)
+		}
+		if !seen[group] {
+			seen[group] = true
+			normalized = append(normalized, group)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
 // ShouldDiscoverVMs returns true if this Proxmox host should discover VMs
 func (s *Server) ShouldDiscoverVMs(vmDiscoveryInterval time.Duration) bool {
 	return s.IsProxmoxHost() &&
@@ -11,8 +11,58 @@ type Service struct {
 	Status    ServiceStatus `json:"status"`
 	LastCheck time.Time     `json:"last_check"`
 	Source    Source        `json:"source"`
+	ProxyPort int           `json:"proxy_port,omitempty"` // Dashboard port fronting this service (wake-on-demand)
+	ProxyMode ProxyMode     `json:"proxy_mode,omitempty"`
+
+	// Health check; without one the service is up if its port accepts connections
+	Check         *HealthCheck `json:"check,omitempty"`
+	ResponseTime  float64      `json:"response_time_ms,omitempty"` // Duration of the last check in milliseconds
+	StatusMessage string       `json:"status_message,omitempty"`   // Why the last check failed, or a warning such as an expiring certificate
+	CertExpiresAt *time.Time   `json:"cert_expires_at,omitempty"`  // Expiry of the TLS certificate seen by HTTPS checks
+}
+
+type HealthCheckType string
+
+const (
+	HealthCheckTCP     HealthCheckType = "tcp"     // Port accepts connections
+	HealthCheckHTTP    HealthCheckType = "http"    // HTTP status and optional body match
+	HealthCheckHTTPS   HealthCheckType = "https"   // As HTTP, also tracking certificate expiry
+	HealthCheckSSH     HealthCheckType = "ssh"     // Server sends an SSH banner
+	HealthCheckDNS     HealthCheckType = "dns"     // Server answers a query for a name
+	HealthCheckSMB     HealthCheckType = "smb"     // Server answers an SMB2 negotiate request
+	HealthCheckNFS     HealthCheckType = "nfs"     // Server answers an NFS RPC null call
+	HealthCheckCommand HealthCheckType = "command" // Command run on the server over SSH exits with 0
+)
+
+// HealthCheck describes how to tell whether a service actually works
+type HealthCheck struct {
+	Type               HealthCheckType `json:"type"`
+	Path               string          `json:"path,omitempty"`                 // HTTP(S) request path (default: "/")
+	ExpectStatus       int             `json:"expect_status,omitempty"`        // HTTP(S) status required (default: any 2xx or 3xx)
+	ExpectBody         string          `json:"expect_body,omitempty"`          // Text the HTTP(S) response body must contain
+	InsecureSkipVerify bool            `json:"insecure_skip_verify,omitempty"` // Accept self-signed HTTPS certificates
+	Query              string          `json:"query,omitempty"`                // Name the DNS server must resolve
+	Command            string          `json:"command,omitempty"`              // Command run over SSH with the server's credentials
+	Timeout            int             `json:"timeout,omitempty"`              // Seconds (default: 5)
 }
 
+// CopyCheckResult copies the outcome of the last health check from another copy of the service
+func (s *Service) CopyCheckResult(from Service) {
+	s.Status = from.Status
+	s.LastCheck = from.LastCheck
+	s.ResponseTime = from.ResponseTime
+	s.StatusMessage = from.StatusMessage
+	s.CertExpiresAt = from.CertExpiresAt
+}
+
+// ProxyMode selects how the wake-on-demand proxy handles connections to a service
+type ProxyMode string
+
+const (
+	ProxyModeTCP  ProxyMode = "tcp"  // Hold connections until the service is up, then pipe bytes
+	ProxyModeHTTP ProxyMode = "http" // Serve a "waking up" page until the service is up, then reverse proxy
+)
+
 // KnownPorts maps common port numbers to their service types
 var KnownPorts = map[int]ServiceType{
 	// Remote Access & Shell
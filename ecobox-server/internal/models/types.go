@@ -9,12 +9,15 @@ const (
 	PowerStateOff          PowerState = "off"
 	PowerStateStopped      PowerState = "stopped"     // New: VM is stopped (can be started instantly)
 	PowerStateSuspended    PowerState = "suspended"   // VM is suspended/paused (RAM preserved)
+	PowerStateHibernated   PowerState = "hibernated"  // VM state saved to disk, RAM freed (Proxmox suspend --todisk)
 	PowerStateUnknown      PowerState = "unknown"
 	PowerStateInitFailed   PowerState = "init_failed"
 	// Transitioning states to indicate power management operations in progress
 	PowerStateWaking       PowerState = "waking"       // Wake operation in progress
 	PowerStateSuspending   PowerState = "suspending"   // Suspend operation in progress
 	PowerStateStopping     PowerState = "stopping"     // New: Stop operation in progress
+	PowerStateHibernating  PowerState = "hibernating"  // Hibernate (suspend-to-disk) operation in progress
+	PowerStateRestarting   PowerState = "restarting"   // Restart or reset operation in progress
 )
 
 type ServiceType string
@@ -59,10 +62,42 @@ const (
 	ActionTypeSuspend     ActionType = "suspend"
 	ActionTypeShutdown    ActionType = "shutdown"    // New: Clean shutdown (Proxmox VMs and regular servers)
 	ActionTypeStop        ActionType = "stop"        // New: Force stop (Proxmox VMs only)
+	ActionTypeHibernate   ActionType = "hibernate"   // Suspend to disk
+	ActionTypeRestart     ActionType = "restart"     // Clean reboot
+	ActionTypeReset       ActionType = "reset"       // Hard reset (Proxmox VMs only)
+	ActionTypeSnapshot    ActionType = "snapshot"    // Automatic snapshot before a forced stop
 	ActionTypeInitialize  ActionType = "initialize"
 	ActionTypeReconcile   ActionType = "reconcile"
 )
 
+// VMSuspendMode selects how a Proxmox VM is put to sleep when a suspend is requested
+type VMSuspendMode string
+
+const (
+	VMSuspendModePause     VMSuspendMode = "pause"     // Pause in RAM (default)
+	VMSuspendModeHibernate VMSuspendMode = "hibernate" // Save vmstate to disk and free RAM
+	VMSuspendModeShutdown  VMSuspendMode = "shutdown"  // Clean ACPI shutdown
+	VMSuspendModeStop      VMSuspendMode = "stop"      // Forced stop
+)
+
+// VMPowerPolicy holds per-VM power management options for Proxmox VMs
+type VMPowerPolicy struct {
+	SuspendMode        VMSuspendMode `json:"suspend_mode"`
+	SnapshotBeforeStop bool          `json:"snapshot_before_stop"` // Take a snapshot before any forced stop
+	SnapshotKeep       int           `json:"snapshot_keep"`        // Number of automatic snapshots to retain (0 = keep all)
+}
+
+// PowerCapabilities lists the power actions a server supports, so clients only offer valid actions
+type PowerCapabilities struct {
+	Wake      bool `json:"wake"`
+	Suspend   bool `json:"suspend"`
+	Hibernate bool `json:"hibernate"`
+	Shutdown  bool `json:"shutdown"`
+	Stop      bool `json:"stop"`
+	Restart   bool `json:"restart"`
+	Reset     bool `json:"reset"`
+}
+
 // SystemType represents the type of system
 type SystemType string
 
@@ -162,10 +197,30 @@ type VMInfo struct {
 	VMID      string `json:"vm_id,omitempty"`      // Proxmox VMID or similar
 }
 
-// ProxmoxAPIKey contains Proxmox API key information  
+// ProxmoxAPIKey contains Proxmox API key information. The secret is kept in
+// the secret vault and never serialized.
 type ProxmoxAPIKey struct {
 	Username string `json:"username"`
 	Realm    string `json:"realm"`
 	TokenID  string `json:"token_id"`
-	Secret   string `json:"secret"`
+	Secret   string `json:"-"`
+}
+
+type DependencyType string
+
+const (
+	DependencyTypeHard DependencyType = "hard" // Must be ready before waking; blocks suspending the dependency
+	DependencyTypeSoft DependencyType = "soft" // Woken first on a best-effort basis; never blocks
+)
+
+// Dependency is a server, or a service on it, that another server needs
+type Dependency struct {
+	ServerID string         `json:"server_id"`
+	Port     int            `json:"port,omitempty"` // Service that must accept connections, e.g. 2049 for NFS
+	Type     DependencyType `json:"type"`
+}
+
+// IsHard returns true unless the dependency is explicitly soft
+func (d Dependency) IsHard() bool {
+	return d.Type != DependencyTypeSoft
 }
@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"fmt"
+
+	"ecobox-server/internal/alerts"
+	"ecobox-server/internal/models"
+)
+
+// SetAlertManager sets the alert manager that failures are reported to
+func (m *Monitor) SetAlertManager(manager *alerts.Manager) {
+	m.alerts = manager
+}
+
+// observe reports a condition for a server, or one of its services, to the alert manager
+func (m *Monitor) observe(condition models.AlertCondition, server *models.Server, service string, failing bool, message string) {
+	if m.alerts == nil {
+		return
+	}
+	m.alerts.Observe(alerts.Observation{
+		Condition: condition,
+		Server:    server,
+		Service:   service,
+		Failing:   failing,
+		Message:   message,
+	})
+}
+
+// observeServices reports the check results of a server that is on. Services of
+// servers that are off or suspended are expected to be down and not reported, and
+// neither are discovered services, which come and go with the open ports.
+func (m *Monitor) observeServices(server *models.Server, services []models.Service) {
+	for _, service := range services {
+		if service.Source == models.SourceDiscovered {
+			continue
+		}
+		if service.Status == models.ServiceStatusUp {
+			m.observe(models.AlertConditionServiceDown, server, service.Name, false, fmt.Sprintf("%s is up", service.Name))
+			continue
+		}
+
+		message := fmt.Sprintf("%s (port %d) is down", service.Name, service.Port)
+		if service.StatusMessage != "" {
+			message += ": " + service.StatusMessage
+		}
+		m.observe(models.AlertConditionServiceDown, server, service.Name, true, message)
+	}
+}
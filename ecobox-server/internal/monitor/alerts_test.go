@@ -0,0 +1,32 @@
+package monitor
+
+import (
+	"testing"
+
+	"ecobox-server/internal/alerts"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+)
+
+func TestObserveServicesSkipsDiscovered(t *testing.T) {
+	nas := &models.Server{ID: "nas", Name: "nas", MACAddress: "AA:BB:CC:DD:EE:01", CurrentState: models.PowerStateOn}
+	m, _ := newTestMonitor(t, nas)
+	manager := alerts.NewManager(&config.Config{Alerts: config.AlertsConfig{
+		Rules: []config.AlertRuleConfig{{Name: "down", Condition: "service_down", Threshold: 1, Recovery: 1}},
+	}})
+	m.SetAlertManager(manager)
+
+	m.observeServices(nas, []models.Service{
+		{ID: "nas-smb", Name: "SMB", Port: 445, Source: models.SourceConfig, Status: models.ServiceStatusDown},
+		{ID: "nas-web", Name: "Web", Port: 80, Source: models.SourceAPI, Status: models.ServiceStatusDown},
+		{ID: "nas-port-8443", Name: "HTTPS-Alt", Port: 8443, Source: models.SourceDiscovered, Status: models.ServiceStatusDown},
+	})
+
+	firing := make(map[string]bool)
+	for _, alert := range manager.Status("").Firing {
+		firing[alert.Service] = true
+	}
+	if !firing["SMB"] || !firing["Web"] || len(firing) != 2 {
+		t.Errorf("Expected alerts for the defined SMB and Web services only, got %v", firing)
+	}
+}
@@ -0,0 +1,276 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+)
+
+// groupPollInterval is how often a waiting group action checks its operations
+const groupPollInterval = 1 * time.Second
+
+// ErrGroupNotFound is returned when no server belongs to the requested group
+var ErrGroupNotFound = errors.New("group not found")
+
+// GroupAction is a power action applied to every server in a group
+type GroupAction string
+
+const (
+	GroupActionWake     GroupAction = "wake"
+	GroupActionSuspend  GroupAction = "suspend"
+	GroupActionShutdown GroupAction = "shutdown"
+)
+
+// targetState returns the desired state the action requests for a server. Like the
+// per-server endpoint, shutdown stops Proxmox VMs and suspends physical hosts.
+func (a GroupAction) targetState(server *models.Server) (models.PowerState, error) {
+	switch a {
+	case GroupActionWake:
+		return models.PowerStateOn, nil
+	case GroupActionSuspend:
+		return models.PowerStateSuspended, nil
+	case GroupActionShutdown:
+		if server.IsProxmoxVM {
+			return models.PowerStateStopped, nil
+		}
+		return models.PowerStateSuspended, nil
+	}
+	return "", fmt.Errorf("unsupported group action: %s", a)
+}
+
+// GroupRequest describes a power action for all servers in a group
+type GroupRequest struct {
+	Action      GroupAction
+	Reason      string
+	RequestedBy string
+	ExpiresAt   *time.Time    // Optional; each server returns to its previous desired state afterwards
+	Cascade     bool          // Take running dependents outside the group down as well
+	Wait        time.Duration // Wait up to this long for the operations to finish; zero only records the intents
+}
+
+type GroupResultStatus string
+
+const (
+	GroupResultAccepted  GroupResultStatus = "accepted" // Desired state recorded, the reconciler carries it out
+	GroupResultSucceeded GroupResultStatus = "succeeded"
+	GroupResultFailed    GroupResultStatus = "failed"
+	GroupResultSkipped   GroupResultStatus = "skipped" // Not attempted because a server it relies on failed
+)
+
+// GroupResult is the outcome of a group action for one server
+type GroupResult struct {
+	ServerID    string            `json:"server_id"`
+	ServerName  string            `json:"server_name"`
+	Batch       int               `json:"batch"` // Servers in the same batch were acted on concurrently
+	TargetState models.PowerState `json:"target_state,omitempty"`
+	Status      GroupResultStatus `json:"status"`
+	Message     string            `json:"message,omitempty"`
+	Operation   *models.Operation `json:"operation,omitempty"`
+}
+
+// GroupReport summarizes a group action across all members
+type GroupReport struct {
+	Group     string        `json:"group"`
+	Action    GroupAction   `json:"action"`
+	Results   []GroupResult `json:"results"`
+	Succeeded int           `json:"succeeded"` // Including accepted requests
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+}
+
+// GroupMembers returns the servers in a group, sorted by name
+func (m *Monitor) GroupMembers(group string) []*models.Server {
+	var members []*models.Server
+	for _, server := range m.storage.GetAllServers() {
+		if server.InGroup(group) {
+			members = append(members, server)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// SetServerGroups replaces the groups of a server and publishes the change
+func (m *Monitor) SetServerGroups(serverID string, groups []string) (*models.Server, error) {
+	normalized, err := models.NormalizeGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.storage.SetServerGroups(serverID, normalized); err != nil {
+		return nil, err
+	}
+	m.publishServer(serverID)
+
+	return m.storage.GetServer(serverID)
+}
+
+// RunGroupAction requests a power state for every server in a group. Servers are
+// handled in dependency batches: dependencies first when waking, dependents first
+// when going down. Within a batch at most GroupConcurrency servers are handled at
+// once. A server is skipped when a member it relies on failed in an earlier batch.
+func (m *Monitor) RunGroupAction(group string, req GroupRequest) (*GroupReport, error) {
+	servers := m.storage.GetAllServers()
+
+	var memberIDs []string
+	for id, server := range servers {
+		if server.InGroup(group) {
+			memberIDs = append(memberIDs, id)
+		}
+	}
+	if len(memberIDs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, group)
+	}
+
+	graph := control.NewDependencyGraph(servers)
+	batches, err := graph.Batches(memberIDs)
+	if err != nil {
+		return nil, err
+	}
+	if req.Action != GroupActionWake {
+		for i, j := 0, len(batches)-1; i < j; i, j = i+1, j-1 {
+			batches[i], batches[j] = batches[j], batches[i]
+		}
+	}
+
+	concurrency := m.config.LiveDashboard().GroupConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var deadline time.Time
+	if req.Wait > 0 {
+		deadline = time.Now().Add(req.Wait)
+	}
+
+	m.logger.WithFields(map[string]interface{}{
+		"group":        group,
+		"action":       req.Action,
+		"servers":      len(memberIDs),
+		"batches":      len(batches),
+		"requested_by": req.RequestedBy,
+	}).Info("Running group action")
+
+	report := &GroupReport{Group: group, Action: req.Action, Results: make([]GroupResult, 0, len(memberIDs))}
+	failed := make(map[string]*models.Server)
+	sem := make(chan struct{}, concurrency)
+
+	for batchIndex, batch := range batches {
+		results := make([]GroupResult, len(batch))
+		var wg sync.WaitGroup
+
+		for i, server := range batch {
+			if blockers := groupBlockers(graph, server, failed, req.Action); len(blockers) > 0 {
+				results[i] = GroupResult{
+					ServerID:   server.ID,
+					ServerName: server.Name,
+					Batch:      batchIndex,
+					Status:     GroupResultSkipped,
+					Message:    fmt.Sprintf("Skipped because %s failed", control.ServerNames(blockers)),
+				}
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int, server *models.Server) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				results[i] = m.runGroupMember(server, batchIndex, req, deadline)
+			}(i, server)
+		}
+		wg.Wait()
+
+		for _, result := range results {
+			switch result.Status {
+			case GroupResultFailed:
+				report.Failed++
+				failed[result.ServerID] = servers[result.ServerID]
+			case GroupResultSkipped:
+				report.Skipped++
+				failed[result.ServerID] = servers[result.ServerID]
+			default:
+				report.Succeeded++
+			}
+		}
+		report.Results = append(report.Results, results...)
+	}
+
+	return report, nil
+}
+
+// runGroupMember requests the group action for one server and, with a deadline,
+// waits for the resulting operation to finish
+func (m *Monitor) runGroupMember(server *models.Server, batch int, req GroupRequest, deadline time.Time) GroupResult {
+	result := GroupResult{ServerID: server.ID, ServerName: server.Name, Batch: batch, Status: GroupResultFailed}
+
+	state, err := req.Action.targetState(server)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.TargetState = state
+
+	op, err := m.RequestPowerState(server.ID, PowerStateRequest{
+		State:       state,
+		Reason:      req.Reason,
+		RequestedBy: req.RequestedBy,
+		ExpiresAt:   req.ExpiresAt,
+		Cascade:     req.Cascade,
+	})
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	if !deadline.IsZero() {
+		op = m.waitForOperation(op, deadline)
+	}
+	result.Operation = op
+	result.Message = op.Message
+
+	switch op.Status {
+	case models.OperationStatusSucceeded:
+		result.Status = GroupResultSucceeded
+	case models.OperationStatusFailed, models.OperationStatusSuperseded:
+		result.Status = GroupResultFailed
+	default:
+		result.Status = GroupResultAccepted
+		if !deadline.IsZero() {
+			result.Message = fmt.Sprintf("Still %s when the wait ended: %s", op.Status, op.Message)
+		}
+	}
+	return result
+}
+
+// waitForOperation polls an operation until it finishes or the deadline passes
+func (m *Monitor) waitForOperation(op *models.Operation, deadline time.Time) *models.Operation {
+	for !op.IsFinished() && time.Now().Before(deadline) {
+		time.Sleep(groupPollInterval)
+		if updated, ok := m.operations.Get(op.ID); ok {
+			op = updated
+		}
+	}
+	return op
+}
+
+// groupBlockers returns the failed members a server relies on for the action: its
+// dependencies when waking, its dependents when going down
+func groupBlockers(graph *control.DependencyGraph, server *models.Server, failed map[string]*models.Server, action GroupAction) []*models.Server {
+	var blockers []*models.Server
+	for id, member := range failed {
+		relies := graph.HardDependsOn(server.ID, id)
+		if action != GroupActionWake {
+			relies = graph.HardDependsOn(id, server.ID)
+		}
+		if relies {
+			blockers = append(blockers, member)
+		}
+	}
+	return blockers
+}
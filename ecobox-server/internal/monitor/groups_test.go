@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/models"
+)
+
+func TestGroupSuspendTakesDependentsDownFirst(t *testing.T) {
+	m, store := newTestMonitor(t,
+		&models.Server{ID: "nas", Name: "nas", MACAddress: "AA:BB:CC:DD:EE:01", CurrentState: models.PowerStateOn, Groups: []string{"lab"}},
+		&models.Server{ID: "app", Name: "app", MACAddress: "AA:BB:CC:DD:EE:02", CurrentState: models.PowerStateOn, Groups: []string{"lab"},
+			Dependencies: []models.Dependency{{ServerID: "nas"}}},
+		&models.Server{ID: "tv", Name: "tv", MACAddress: "AA:BB:CC:DD:EE:03", CurrentState: models.PowerStateOn, Groups: []string{"media"}},
+	)
+
+	report, err := m.RunGroupAction("lab", GroupRequest{Action: GroupActionSuspend, RequestedBy: "test"})
+	if err != nil {
+		t.Fatalf("RunGroupAction failed: %v", err)
+	}
+
+	if report.Succeeded != 2 || report.Failed != 0 || len(report.Results) != 2 {
+		t.Fatalf("Expected both lab servers to be accepted, got %+v", report.Results)
+	}
+	if report.Results[0].ServerID != "app" || report.Results[1].ServerID != "nas" {
+		t.Errorf("Expected app before nas, got %s then %s", report.Results[0].ServerID, report.Results[1].ServerID)
+	}
+
+	tv, _ := store.GetServer("tv")
+	if tv.DesiredState != models.PowerStateUnknown {
+		t.Errorf("Expected servers outside the group to be untouched, tv wants %s", tv.DesiredState)
+	}
+
+	if _, err := m.RunGroupAction("missing", GroupRequest{Action: GroupActionWake}); err == nil {
+		t.Error("Expected an error for an unknown group")
+	}
+}
+
+func TestCascadeIsAllOrNothing(t *testing.T) {
+	m, store := newTestMonitor(t,
+		&models.Server{ID: "nas", Name: "nas", MACAddress: "AA:BB:CC:DD:EE:01", CurrentState: models.PowerStateOn},
+		&models.Server{ID: "app", Name: "app", MACAddress: "AA:BB:CC:DD:EE:02", CurrentState: models.PowerStateOn,
+			Dependencies: []models.Dependency{{ServerID: "nas"}}},
+		&models.Server{ID: "db", Name: "db", MACAddress: "AA:BB:CC:DD:EE:03", CurrentState: models.PowerStateOn,
+			Dependencies: []models.Dependency{{ServerID: "nas"}}},
+	)
+
+	// db is held on, so the cascade can't take it down
+	if _, err := m.AcquireLease("db", "backup", "", time.Hour); err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+
+	_, err := m.RequestPowerState("nas", PowerStateRequest{State: models.PowerStateSuspended, RequestedBy: "test", Cascade: true})
+	if !errors.Is(err, ErrServerLeased) {
+		t.Fatalf("Expected the cascade to fail on the leased dependent, got %v", err)
+	}
+
+	for _, id := range []string{"nas", "app"} {
+		server, _ := store.GetServer(id)
+		if server.DesiredState != models.PowerStateUnknown || server.Intent != nil {
+			t.Errorf("Expected %s to be left alone, got desired state %s", id, server.DesiredState)
+		}
+	}
+
+	// Without the lease, both dependents go down with the server
+	server, _ := store.GetServer("db")
+	if err := m.ReleaseLease("db", server.Leases[0].ID); err != nil {
+		t.Fatalf("ReleaseLease failed: %v", err)
+	}
+	if _, err := m.RequestPowerState("nas", PowerStateRequest{State: models.PowerStateSuspended, RequestedBy: "test", Cascade: true}); err != nil {
+		t.Fatalf("RequestPowerState failed: %v", err)
+	}
+	for _, id := range []string{"nas", "app", "db"} {
+		server, _ := store.GetServer(id)
+		if server.DesiredState != models.PowerStateSuspended {
+			t.Errorf("Expected %s to be going down, got desired state %s", id, server.DesiredState)
+		}
+	}
+}
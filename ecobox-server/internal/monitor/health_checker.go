@@ -0,0 +1,371 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCheckTimeout = 5 * time.Second
+	certExpiryWarning   = 14 * 24 * time.Hour // Certificates closer to expiry than this are flagged
+	maxCheckBodySize    = 1 << 20             // Bytes of an HTTP response searched for expect_body
+)
+
+// CommandRunner runs a command over SSH until it exits or the context ends
+type CommandRunner interface {
+	RunCommand(ctx context.Context, host string, port int, user string, keyPath string, command string) error
+}
+
+// HealthChecker runs the health checks of services. Services without a check are
+// up when their port accepts connections.
+type HealthChecker struct {
+	runner CommandRunner
+	logger *logrus.Logger
+}
+
+// NewHealthChecker creates a health checker running command checks through the runner
+func NewHealthChecker(runner CommandRunner) *HealthChecker {
+	return &HealthChecker{
+		runner: runner,
+		logger: logrus.New(),
+	}
+}
+
+// SetLogger sets the logger for the health checker
+func (hc *HealthChecker) SetLogger(logger *logrus.Logger) {
+	hc.logger = logger
+}
+
+// CheckServices checks all services of a server concurrently
+func (hc *HealthChecker) CheckServices(server *models.Server, services []models.Service) []models.Service {
+	checked := make([]models.Service, len(services))
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func(i int, service models.Service) {
+			defer wg.Done()
+			checked[i] = hc.CheckService(server, service)
+		}(i, service)
+	}
+	wg.Wait()
+	return checked
+}
+
+// CheckService runs the health check of a service and returns it with the result
+func (hc *HealthChecker) CheckService(server *models.Server, service models.Service) models.Service {
+	check := service.Check
+	if check == nil {
+		check = &models.HealthCheck{Type: models.HealthCheckTCP}
+	}
+
+	timeout := defaultCheckTimeout
+	if check.Timeout > 0 {
+		timeout = time.Duration(check.Timeout) * time.Second
+	}
+	address := net.JoinHostPort(server.Hostname, strconv.Itoa(service.Port))
+
+	var certExpiresAt *time.Time
+	var err error
+	start := time.Now()
+
+	switch check.Type {
+	case models.HealthCheckTCP, "":
+		err = checkTCP(address, timeout)
+	case models.HealthCheckHTTP, models.HealthCheckHTTPS:
+		certExpiresAt, err = checkHTTP(check, address, timeout)
+	case models.HealthCheckSSH:
+		err = checkSSHBanner(address, timeout)
+	case models.HealthCheckDNS:
+		err = checkDNS(address, check.Query, timeout)
+	case models.HealthCheckSMB:
+		err = checkSMB(address, timeout)
+	case models.HealthCheckNFS:
+		err = checkNFS(address, timeout)
+	case models.HealthCheckCommand:
+		err = hc.checkCommand(server, check.Command, timeout)
+	default:
+		err = fmt.Errorf("unsupported check type: %s", check.Type)
+	}
+
+	service.LastCheck = time.Now()
+	service.ResponseTime = float64(service.LastCheck.Sub(start).Microseconds()) / 1000
+	service.CertExpiresAt = certExpiresAt
+	service.StatusMessage = ""
+
+	if err != nil {
+		service.Status = models.ServiceStatusDown
+		service.StatusMessage = err.Error()
+		hc.logger.WithFields(logrus.Fields{
+			"server":  server.Name,
+			"service": service.Name,
+			"check":   check.Type,
+		}).Debugf("Service check failed: %v", err)
+		return service
+	}
+
+	service.Status = models.ServiceStatusUp
+	if certExpiresAt != nil {
+		if left := time.Until(*certExpiresAt); left < certExpiryWarning {
+			service.StatusMessage = fmt.Sprintf("Certificate expires in %d days", int(left.Hours()/24))
+		}
+	}
+	return service
+}
+
+// checkTCP succeeds when the address accepts a connection
+func checkTCP(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkHTTP requests the check path and matches the status and body. Redirects are
+// not followed. For HTTPS the expiry of the server certificate is returned.
+func checkHTTP(check *models.HealthCheck, address string, timeout time.Duration) (*time.Time, error) {
+	path := check.Path
+	if path == "" {
+		path = "/"
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: check.InsecureSkipVerify},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%s://%s%s", check.Type, address, path))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var certExpiresAt *time.Time
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		notAfter := resp.TLS.PeerCertificates[0].NotAfter
+		certExpiresAt = &notAfter
+		if time.Now().After(notAfter) {
+			return certExpiresAt, fmt.Errorf("certificate expired on %s", notAfter.Format("2006-01-02"))
+		}
+	}
+
+	if check.ExpectStatus != 0 {
+		if resp.StatusCode != check.ExpectStatus {
+			return certExpiresAt, fmt.Errorf("expected status %d, got %d", check.ExpectStatus, resp.StatusCode)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return certExpiresAt, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if check.ExpectBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBodySize))
+		if err != nil {
+			return certExpiresAt, fmt.Errorf("failed to read response: %w", err)
+		}
+		if !bytes.Contains(body, []byte(check.ExpectBody)) {
+			return certExpiresAt, fmt.Errorf("response does not contain %q", check.ExpectBody)
+		}
+	}
+
+	return certExpiresAt, nil
+}
+
+// checkSSHBanner succeeds when the server identifies itself as an SSH server
+func checkSSHBanner(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no SSH banner: %w", err)
+	}
+	if !strings.HasPrefix(banner, "SSH-") {
+		return fmt.Errorf("unexpected banner %q", strings.TrimSpace(banner))
+	}
+	return nil
+}
+
+// checkDNS resolves the query name using only the server at address
+func checkDNS(address, query string, timeout time.Duration) error {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	addrs, err := resolver.LookupHost(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", query, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no addresses for %s", query)
+	}
+	return nil
+}
+
+// smbNegotiateRequest is an SMB2 NEGOTIATE request offering dialects 2.0.2 to 3.0.2
+var smbNegotiateRequest = func() []byte {
+	dialects := []uint16{0x0202, 0x0210, 0x0300, 0x0302}
+
+	var msg bytes.Buffer
+	// SMB2 header
+	msg.WriteString("\xfeSMB")
+	binary.Write(&msg, binary.LittleEndian, uint16(64)) // Structure size
+	msg.Write(make([]byte, 2+4))                        // Credit charge, status
+	binary.Write(&msg, binary.LittleEndian, uint16(0))  // NEGOTIATE
+	binary.Write(&msg, binary.LittleEndian, uint16(1))  // Credits requested
+	msg.Write(make([]byte, 4+4+8+4+4+8+16))             // Flags, next command, message ID, process ID, tree ID, session ID, signature
+	// NEGOTIATE body
+	binary.Write(&msg, binary.LittleEndian, uint16(36)) // Structure size
+	binary.Write(&msg, binary.LittleEndian, uint16(len(dialects)))
+	binary.Write(&msg, binary.LittleEndian, uint16(1)) // Signing enabled
+	msg.Write(make([]byte, 2+4+16+8))                  // Reserved, capabilities, client GUID, start time
+	for _, dialect := range dialects {
+		binary.Write(&msg, binary.LittleEndian, dialect)
+	}
+
+	// NetBIOS session message with a 24-bit length
+	length := msg.Len()
+	return append([]byte{0, byte(length >> 16), byte(length >> 8), byte(length)}, msg.Bytes()...)
+}()
+
+// checkSMB succeeds when the server answers an SMB2 negotiate request with an SMB message
+func checkSMB(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(smbNegotiateRequest); err != nil {
+		return fmt.Errorf("failed to send negotiate request: %w", err)
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("no negotiate response: %w", err)
+	}
+	if protocol := string(reply[4:8]); protocol != "\xfeSMB" && protocol != "\xffSMB" {
+		return errors.New("response is not an SMB message")
+	}
+	return nil
+}
+
+// NFS program number and the ONC RPC values used by the null call
+const (
+	rpcProgramNFS     = 100003
+	rpcMsgAccepted    = 0
+	rpcSuccess        = 0
+	rpcProgMismatch   = 2
+	rpcLastFragment   = 0x80000000
+	rpcNullCallLength = 40
+)
+
+// checkNFS succeeds when the server answers an RPC null call to the NFS program.
+// A version mismatch still means NFS is running, e.g. on NFSv4-only servers.
+func checkNFS(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	xid := uint32(time.Now().UnixNano())
+	call := []uint32{
+		rpcLastFragment | rpcNullCallLength,
+		xid,
+		0,             // CALL
+		2,             // RPC version
+		rpcProgramNFS, // Program
+		3,             // Program version
+		0,             // NULL procedure
+		0,             // Credentials: AUTH_NONE
+		0,             // Credentials length
+		0,             // Verifier: AUTH_NONE
+		0,             // Verifier length
+	}
+	if err := binary.Write(conn, binary.BigEndian, call); err != nil {
+		return fmt.Errorf("failed to send RPC call: %w", err)
+	}
+
+	// Record marker, xid, message type, reply status, verifier flavor and length
+	var header [6]uint32
+	if err := binary.Read(conn, binary.BigEndian, &header); err != nil {
+		return fmt.Errorf("no RPC reply: %w", err)
+	}
+	if header[1] != xid || header[2] != 1 {
+		return errors.New("response is not an RPC reply")
+	}
+	if header[3] != rpcMsgAccepted {
+		return errors.New("RPC call was rejected")
+	}
+
+	// Skip the verifier body, padded to 4 bytes
+	verifierLength := (int64(header[5]) + 3) &^ 3
+	if _, err := io.CopyN(io.Discard, conn, verifierLength); err != nil {
+		return fmt.Errorf("truncated RPC reply: %w", err)
+	}
+
+	var acceptStatus uint32
+	if err := binary.Read(conn, binary.BigEndian, &acceptStatus); err != nil {
+		return fmt.Errorf("truncated RPC reply: %w", err)
+	}
+	if acceptStatus != rpcSuccess && acceptStatus != rpcProgMismatch {
+		return fmt.Errorf("NFS is not available (RPC status %d)", acceptStatus)
+	}
+	return nil
+}
+
+// checkCommand runs a command on the server over SSH; it succeeds when the command exits with 0
+func (hc *HealthChecker) checkCommand(server *models.Server, cmd string, timeout time.Duration) error {
+	if hc.runner == nil {
+		return errors.New("command checks are not available")
+	}
+	if server.SSHUser == "" {
+		return errors.New("server has no SSH user")
+	}
+
+	// The runner closes the session when the timeout ends the command
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := hc.runner.RunCommand(ctx, server.Hostname, server.SSHPort, server.SSHUser, server.SSHKeyPath, cmd); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("command timed out after %s", timeout)
+		}
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
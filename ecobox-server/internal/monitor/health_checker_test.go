@@ -0,0 +1,251 @@
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/models"
+)
+
+// serveOnce accepts one connection on a local listener and hands it to handle
+func serveOnce(t *testing.T, handle func(conn net.Conn)) (string, int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// checkAt runs a health check against a local host and port
+func checkAt(hc *HealthChecker, host string, port int, check *models.HealthCheck) models.Service {
+	server := &models.Server{ID: "test", Name: "Test", Hostname: host}
+	return hc.CheckService(server, models.Service{Name: "svc", Port: port, Check: check})
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("status: Healthy"))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	host, portStr, _ := net.SplitHostPort(u.Host)
+	port, _ := strconv.Atoi(portStr)
+	hc := NewHealthChecker(nil)
+
+	service := checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckHTTPS, Path: "/health", ExpectBody: "Healthy", InsecureSkipVerify: true})
+	if service.Status != models.ServiceStatusUp {
+		t.Fatalf("Expected service up, got %s: %s", service.Status, service.StatusMessage)
+	}
+	if service.CertExpiresAt == nil {
+		t.Error("Expected certificate expiry to be recorded")
+	}
+	if service.ResponseTime <= 0 {
+		t.Error("Expected response time to be recorded")
+	}
+
+	service = checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckHTTPS, Path: "/missing", InsecureSkipVerify: true})
+	if service.Status != models.ServiceStatusDown {
+		t.Error("Expected service down for a 404 response")
+	}
+
+	service = checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckHTTPS, Path: "/health", ExpectBody: "Degraded", InsecureSkipVerify: true})
+	if service.Status != models.ServiceStatusDown {
+		t.Error("Expected service down when the body does not match")
+	}
+
+	service = checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckHTTPS, Path: "/health"})
+	if service.Status != models.ServiceStatusDown {
+		t.Error("Expected service down for an untrusted certificate")
+	}
+}
+
+func TestProtocolHealthChecks(t *testing.T) {
+	hc := NewHealthChecker(nil)
+
+	host, port := serveOnce(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	})
+	if service := checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckSSH}); service.Status != models.ServiceStatusUp {
+		t.Errorf("Expected SSH check up, got %s", service.StatusMessage)
+	}
+
+	host, port = serveOnce(t, func(conn net.Conn) {
+		conn.Write([]byte("220 mail ESMTP\r\n"))
+	})
+	if service := checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckSSH}); service.Status != models.ServiceStatusDown {
+		t.Error("Expected SSH check down for a non-SSH banner")
+	}
+
+	host, port = serveOnce(t, func(conn net.Conn) {
+		request := make([]byte, len(smbNegotiateRequest))
+		if _, err := io.ReadFull(conn, request); err != nil || string(request[4:8]) != "\xfeSMB" {
+			return
+		}
+		conn.Write([]byte("\x00\x00\x00\x40\xfeSMB"))
+	})
+	if service := checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckSMB}); service.Status != models.ServiceStatusUp {
+		t.Errorf("Expected SMB check up, got %s", service.StatusMessage)
+	}
+
+	host, port = serveOnce(t, func(conn net.Conn) {
+		var call [11]uint32
+		if err := binary.Read(conn, binary.BigEndian, &call); err != nil || call[4] != rpcProgramNFS {
+			return
+		}
+		// Accepted reply with an empty verifier and PROG_MISMATCH, as from an NFSv4-only server
+		reply := []uint32{rpcLastFragment | 32, call[1], 1, rpcMsgAccepted, 0, 0, rpcProgMismatch, 4, 4}
+		binary.Write(conn, binary.BigEndian, reply)
+	})
+	if service := checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckNFS}); service.Status != models.ServiceStatusUp {
+		t.Errorf("Expected NFS check up, got %s", service.StatusMessage)
+	}
+
+	// Nothing listens on a closed listener's port
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	if service := checkAt(hc, "127.0.0.1", closedPort, nil); service.Status != models.ServiceStatusDown || service.StatusMessage == "" {
+		t.Error("Expected TCP check down with a message for a closed port")
+	}
+}
+
+// serveDNS answers A queries on a local UDP port with 127.0.0.1 for the name,
+// and other queries without answers
+func serveDNS(t *testing.T, name string) (string, int) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// The question of a query for the name, followed by its type and class
+	question := []byte{}
+	for _, label := range strings.Split(name, ".") {
+		question = append(question, byte(len(label)))
+		question = append(question, label...)
+	}
+	question = append(question, 0)
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			end := 12 + len(question)
+			if n < end+4 {
+				continue
+			}
+
+			reply := append([]byte{}, query[:end+4]...)
+			reply[2], reply[3] = 0x81, 0x80 // Response, recursion available
+			reply[6], reply[7] = 0, 0       // No answers
+			reply[8], reply[9], reply[10], reply[11] = 0, 0, 0, 0
+			isA := binary.BigEndian.Uint16(query[end:]) == 1
+			if string(query[12:end]) != string(question) {
+				reply[3] |= 3 // NXDOMAIN
+			} else if isA {
+				reply[7] = 1
+				reply = append(reply, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			conn.WriteTo(reply, addr)
+		}
+	}()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestDNSHealthCheck(t *testing.T) {
+	hc := NewHealthChecker(nil)
+	host, port := serveDNS(t, "nas.home.arpa")
+
+	if service := checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckDNS, Query: "nas.home.arpa"}); service.Status != models.ServiceStatusUp {
+		t.Errorf("Expected DNS check up, got %s", service.StatusMessage)
+	}
+	if service := checkAt(hc, host, port, &models.HealthCheck{Type: models.HealthCheckDNS, Query: "missing.home.arpa"}); service.Status != models.ServiceStatusDown {
+		t.Error("Expected DNS check down for a name that does not resolve")
+	}
+}
+
+// fakeRunner runs commands by calling run
+type fakeRunner struct {
+	run func(ctx context.Context, command string) error
+}
+
+func (r *fakeRunner) RunCommand(ctx context.Context, host string, port int, user string, keyPath string, command string) error {
+	return r.run(ctx, command)
+}
+
+func TestCommandHealthCheck(t *testing.T) {
+	ended := make(chan struct{})
+	hc := NewHealthChecker(&fakeRunner{run: func(ctx context.Context, command string) error {
+		switch command {
+		case "true":
+			return nil
+		case "sleep 60":
+			// Hangs until the check gives up on it
+			<-ctx.Done()
+			close(ended)
+			return ctx.Err()
+		}
+		return errors.New("exit status 1")
+	}})
+	server := &models.Server{ID: "test", Name: "Test", Hostname: "127.0.0.1", SSHUser: "ecobox"}
+	check := func(command string, timeout int) models.Service {
+		return hc.CheckService(server, models.Service{Name: "svc", Port: 22, Check: &models.HealthCheck{Type: models.HealthCheckCommand, Command: command, Timeout: timeout}})
+	}
+
+	if service := check("true", 0); service.Status != models.ServiceStatusUp {
+		t.Errorf("Expected command check up, got %s", service.StatusMessage)
+	}
+	if service := check("false", 0); service.Status != models.ServiceStatusDown {
+		t.Error("Expected command check down for a failing command")
+	}
+
+	service := check("sleep 60", 1)
+	if service.Status != models.ServiceStatusDown || !strings.Contains(service.StatusMessage, "timed out") {
+		t.Errorf("Expected command check to time out, got %s %q", service.Status, service.StatusMessage)
+	}
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Error("Expected the command to be ended when the check timed out")
+	}
+
+	server.SSHUser = ""
+	if service := check("true", 0); service.Status != models.ServiceStatusDown {
+		t.Error("Expected command check down for a server without an SSH user")
+	}
+}
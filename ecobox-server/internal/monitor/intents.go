@@ -0,0 +1,442 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+)
+
+// operationTimeout is how long a desired-state operation may run before it is reported as failed
+const operationTimeout = 15 * time.Minute
+
+// PowerStateRequest describes a requested desired state for a server
+type PowerStateRequest struct {
+	State       models.PowerState
+	Reason      string
+	RequestedBy string
+	ExpiresAt   *time.Time // Optional; the previous desired state is restored afterwards
+	Force       bool       // Force stop instead of clean shutdown (stopped state only)
+	Cascade     bool       // Take running dependents down first instead of refusing
+
+	heldByLeases bool // Set when the intent is maintained by keep-awake leases
+}
+
+// ValidateDesiredState checks that the reconciler can drive a server to the given state
+func ValidateDesiredState(server *models.Server, state models.PowerState, force bool) error {
+	caps := server.GetPowerCapabilities()
+
+	switch state {
+	case models.PowerStateOn:
+		if !caps.Wake {
+			return fmt.Errorf("server %s cannot be woken remotely", server.Name)
+		}
+	case models.PowerStateSuspended:
+		if !caps.Suspend {
+			return fmt.Errorf("suspend is not supported on server %s", server.Name)
+		}
+	case models.PowerStateHibernated:
+		if !caps.Hibernate {
+			return fmt.Errorf("hibernate is not supported on server %s", server.Name)
+		}
+	case models.PowerStateStopped:
+		if !server.IsProxmoxVM {
+			return fmt.Errorf("the stopped state is only supported for Proxmox VMs")
+		}
+	case models.PowerStateUnknown:
+		// Releases the server from power management
+	default:
+		return fmt.Errorf("unsupported desired state: %s", state)
+	}
+
+	if force && state != models.PowerStateStopped {
+		return fmt.Errorf("force only applies to the stopped state")
+	}
+
+	return nil
+}
+
+// RequestPowerState records a desired state and the intent behind it. The
+// reconciler is the only component that acts on it; the returned operation
+// reports progress until the server reaches the state.
+func (m *Monitor) RequestPowerState(serverID string, req PowerStateRequest) (*models.Operation, error) {
+	// Leases are checked and the intent recorded in one step, so a lease
+	// acquired in between cannot be overridden
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+
+	return m.requestPowerState(serverID, req)
+}
+
+// requestPowerState records a desired state. Requires leaseMu to be held.
+func (m *Monitor) requestPowerState(serverID string, req PowerStateRequest) (*models.Operation, error) {
+	server, err := m.storage.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateDesiredState(server, req.State, req.Force); err != nil {
+		return nil, err
+	}
+
+	// Leases hold the server on, so only "on" can be requested while any are active
+	if leases := server.ActiveLeases(time.Now()); len(leases) > 0 && req.State != models.PowerStateOn {
+		return nil, fmt.Errorf("%w (%d active)", ErrServerLeased, len(leases))
+	}
+
+	// Servers that others hard-depend on can only go down after their dependents.
+	// Dependents already asked to go down don't block; the reconciler waits for them.
+	if req.State != models.PowerStateOn && req.State != models.PowerStateUnknown {
+		if dependents := runningDependents(m.powerManager.ActiveDependents(server)); len(dependents) > 0 {
+			if !req.Cascade {
+				return nil, fmt.Errorf("%w: %s", control.ErrActiveDependents, control.ServerNames(dependents))
+			}
+			if err := m.cascadePowerState(server, dependents, req); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// A temporary intent falls back to whatever it replaced, so stacked temporary
+	// intents all return to the same underlying policy
+	revertTo := server.DesiredState
+	if server.Intent != nil && server.Intent.ExpiresAt != nil {
+		revertTo = server.Intent.RevertTo
+	}
+
+	// Leases keep the intent they replace, so it is back in effect once they end
+	var previous *models.PowerIntent
+	if req.heldByLeases && server.Intent != nil && !server.Intent.HeldByLeases {
+		intentCopy := *server.Intent
+		previous = &intentCopy
+	}
+
+	op, superseded := m.operations.Create(server.ID, models.ActionTypeReconcile, req.State, req.RequestedBy, req.Reason)
+
+	intent := &models.PowerIntent{
+		State:        req.State,
+		Reason:       req.Reason,
+		RequestedBy:  req.RequestedBy,
+		RequestedAt:  time.Now(),
+		ExpiresAt:    req.ExpiresAt,
+		RevertTo:     revertTo,
+		Force:        req.Force,
+		HeldByLeases: req.heldByLeases,
+		OperationID:  op.ID,
+		Previous:     previous,
+	}
+
+	if err := m.storage.SetDesiredState(server.ID, req.State, intent); err != nil {
+		m.operations.Update(op.ID, models.OperationStatusFailed, err.Error(), false)
+		return nil, fmt.Errorf("failed to record desired state: %w", err)
+	}
+
+	m.logger.WithFields(map[string]interface{}{
+		"server":       server.Name,
+		"desired":      req.State,
+		"requested_by": req.RequestedBy,
+		"reason":       req.Reason,
+		"operation":    op.ID,
+	}).Info("Desired state requested")
+
+	if superseded != nil {
+		m.publishOperation(superseded)
+	}
+
+	server.DesiredState = req.State
+	server.Intent = intent
+	if updated := m.checkOperationProgress(server, server.CurrentState); updated != nil {
+		op = updated
+	} else {
+		m.publishOperation(op)
+	}
+
+	m.ForceReconcile()
+	return op, nil
+}
+
+// cascadeStep is a request a cascade makes for a dependent. The server is the
+// dependent as it was before, to undo the request.
+type cascadeStep struct {
+	server *models.Server
+	req    PowerStateRequest
+}
+
+// cascadePowerState requests a matching "down" state for the running dependents of
+// a server, each after its own dependents. Every request is checked before any is
+// made, and those made are undone if a later one fails, so a cascade either
+// applies fully or leaves the dependents as they were.
+func (m *Monitor) cascadePowerState(server *models.Server, dependents []*models.Server, req PowerStateRequest) error {
+	steps, err := m.planCascade(server, dependents, req, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+
+	var applied []*models.Server
+	for _, step := range steps {
+		m.logger.Infof("Cascading %s of %s to dependent %s", req.State, server.Name, step.server.Name)
+		if _, err := m.requestPowerState(step.server.ID, step.req); err != nil {
+			m.undoCascade(applied)
+			return fmt.Errorf("cannot take dependent %s down: %w", step.server.Name, err)
+		}
+		applied = append(applied, step.server)
+	}
+	return nil
+}
+
+// planCascade lists the requests that take running dependents down, deepest first,
+// and checks that each can be made
+func (m *Monitor) planCascade(server *models.Server, dependents []*models.Server, req PowerStateRequest, planned map[string]bool) ([]cascadeStep, error) {
+	var steps []cascadeStep
+	for _, dependent := range dependents {
+		if planned[dependent.ID] {
+			continue
+		}
+		planned[dependent.ID] = true
+
+		// Use the same state where the dependent supports it, otherwise suspend it
+		state, force := req.State, req.Force
+		if ValidateDesiredState(dependent, state, force) != nil {
+			force = false
+			if ValidateDesiredState(dependent, state, force) != nil {
+				state = models.PowerStateSuspended
+			}
+		}
+		if err := ValidateDesiredState(dependent, state, force); err != nil {
+			return nil, fmt.Errorf("cannot take dependent %s down: %w", dependent.Name, err)
+		}
+		if leases := dependent.ActiveLeases(time.Now()); len(leases) > 0 {
+			return nil, fmt.Errorf("cannot take dependent %s down: %w (%d active)", dependent.Name, ErrServerLeased, len(leases))
+		}
+
+		dependentReq := PowerStateRequest{
+			State:       state,
+			Reason:      fmt.Sprintf("%s is going %s", server.Name, req.State),
+			RequestedBy: req.RequestedBy,
+			ExpiresAt:   req.ExpiresAt,
+			Force:       force,
+			Cascade:     true,
+		}
+		deeper, err := m.planCascade(dependent, runningDependents(m.powerManager.ActiveDependents(dependent)), dependentReq, planned)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(append(steps, deeper...), cascadeStep{server: dependent, req: dependentReq})
+	}
+	return steps, nil
+}
+
+// undoCascade gives dependents back the desired state and intent they had before
+// a cascade that failed part way, and fails the operations it started
+func (m *Monitor) undoCascade(applied []*models.Server) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		previous := applied[i]
+		if op, ok := m.operations.Active(previous.ID); ok {
+			if updated := m.operations.Update(op.ID, models.OperationStatusFailed, "Cascade failed, desired state restored", false); updated != nil {
+				m.publishOperation(updated)
+			}
+		}
+		if err := m.storage.SetDesiredState(previous.ID, previous.DesiredState, previous.Intent); err != nil {
+			m.logger.Errorf("Failed to undo cascade to %s: %v", previous.Name, err)
+		}
+	}
+}
+
+// runningDependents filters out dependents that are already meant to go down
+func runningDependents(dependents []*models.Server) []*models.Server {
+	var running []*models.Server
+	for _, dependent := range dependents {
+		if !dependent.IsGoingDown() {
+			running = append(running, dependent)
+		}
+	}
+	return running
+}
+
+// ClearPowerIntent drops the current intent of a server and restores the desired
+// state that was in effect before it
+func (m *Monitor) ClearPowerIntent(serverID string, requestedBy string) (*models.Operation, error) {
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+
+	server, err := m.storage.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	if server.Intent == nil {
+		return nil, fmt.Errorf("server %s has no active intent", server.Name)
+	}
+
+	if server.Intent.HeldByLeases {
+		return nil, fmt.Errorf("%w, release the leases instead", ErrServerLeased)
+	}
+
+	return m.revertIntent(server, requestedBy, fmt.Sprintf("Intent from %s cleared", server.Intent.RequestedBy))
+}
+
+// GetOperation returns a requested operation by ID
+func (m *Monitor) GetOperation(id string) (*models.Operation, bool) {
+	return m.operations.Get(id)
+}
+
+// expireIntents restores the previous desired state of servers whose intent has expired
+func (m *Monitor) expireIntents(servers map[string]*models.Server) {
+	now := time.Now()
+	for id, server := range servers {
+		if !server.Intent.IsExpired(now) {
+			continue
+		}
+
+		m.logger.Infof("Intent for %s from %s expired, returning to %s", server.Name, server.Intent.RequestedBy, server.Intent.RevertTo)
+		if _, err := m.revertIntent(server, "system", fmt.Sprintf("Intent from %s expired", server.Intent.RequestedBy)); err != nil {
+			m.logger.Errorf("Failed to expire intent for %s: %v", server.Name, err)
+			continue
+		}
+
+		if updated, err := m.storage.GetServer(id); err == nil {
+			servers[id] = updated
+		}
+	}
+}
+
+// revertIntent replaces the current intent with what it was recorded over: the
+// intent leases held the server on over while that is still in effect, otherwise
+// the desired state before it
+func (m *Monitor) revertIntent(server *models.Server, requestedBy string, reason string) (*models.Operation, error) {
+	revertTo := server.Intent.RevertTo
+	var restored *models.PowerIntent
+	if previous := server.Intent.Previous; previous != nil {
+		if previous.IsExpired(time.Now()) {
+			revertTo = previous.RevertTo
+		} else {
+			intentCopy := *previous
+			restored = &intentCopy
+			revertTo = restored.State
+		}
+	}
+	if revertTo == "" {
+		revertTo = models.PowerStateUnknown
+	}
+
+	op, superseded := m.operations.Create(server.ID, models.ActionTypeReconcile, revertTo, requestedBy, reason)
+	if restored != nil {
+		restored.OperationID = op.ID
+	}
+	if err := m.storage.SetDesiredState(server.ID, revertTo, restored); err != nil {
+		m.operations.Update(op.ID, models.OperationStatusFailed, err.Error(), false)
+		return nil, fmt.Errorf("failed to restore desired state: %w", err)
+	}
+
+	if superseded != nil {
+		m.publishOperation(superseded)
+	}
+
+	server.DesiredState = revertTo
+	server.Intent = restored
+	if updated := m.checkOperationProgress(server, server.CurrentState); updated != nil {
+		op = updated
+	} else {
+		m.publishOperation(op)
+	}
+
+	return op, nil
+}
+
+// recordOperationAttempt reports the outcome of a reconciler action on the server's
+// active desired-state operation. Failures are retried by the reconciler, so the
+// operation only fails once the configured retry limit is reached.
+func (m *Monitor) recordOperationAttempt(server *models.Server, description string, err error) {
+	op, ok := m.operations.Active(server.ID)
+	if !ok || op.Action != models.ActionTypeReconcile {
+		return
+	}
+
+	status := models.OperationStatusRunning
+	message := fmt.Sprintf("%s sent, waiting for %s", description, op.TargetState)
+	if err != nil {
+		message = fmt.Sprintf("%s failed (attempt %d): %v", description, op.Attempts+1, err)
+		if op.Attempts+1 >= m.config.LiveDashboard().WoLMaxRetries {
+			status = models.OperationStatusFailed
+		}
+	}
+
+	if updated := m.operations.Update(op.ID, status, message, true); updated != nil {
+		m.publishOperation(updated)
+	}
+}
+
+// recordOperationWaiting reports that the reconciler is holding back on a server's
+// active operation, without counting it as an attempt
+func (m *Monitor) recordOperationWaiting(server *models.Server, message string) {
+	op, ok := m.operations.Active(server.ID)
+	if !ok || op.Action != models.ActionTypeReconcile || op.Message == message {
+		return
+	}
+
+	if updated := m.operations.Update(op.ID, models.OperationStatusRunning, message, false); updated != nil {
+		m.publishOperation(updated)
+	}
+}
+
+// checkOperationProgress completes or times out the active operation of a server
+// based on its current state. It returns the updated operation if it changed.
+func (m *Monitor) checkOperationProgress(server *models.Server, oldState models.PowerState) *models.Operation {
+	op, ok := m.operations.Active(server.ID)
+	if !ok {
+		return nil
+	}
+
+	var updated *models.Operation
+	switch op.Action {
+	case models.ActionTypeRestart, models.ActionTypeReset:
+		// Restarts are tracked by the restarting transitional state
+		if oldState == models.PowerStateRestarting && server.CurrentState != models.PowerStateRestarting {
+			if server.CurrentState == models.PowerStateOn {
+				updated = m.operations.Update(op.ID, models.OperationStatusSucceeded, "Server is back online", false)
+			} else {
+				updated = m.operations.Update(op.ID, models.OperationStatusFailed,
+					fmt.Sprintf("Server did not come back online, state is %s", server.CurrentState), false)
+			}
+		}
+
+	default:
+		if op.TargetState != server.DesiredState {
+			// Desired state was changed without this operation (e.g. config reload)
+			updated = m.operations.Update(op.ID, models.OperationStatusSuperseded, "Desired state changed", false)
+		} else if op.TargetState == models.PowerStateUnknown {
+			updated = m.operations.Update(op.ID, models.OperationStatusSucceeded, "Server released from power management", false)
+		} else if server.InDesiredState() {
+			updated = m.operations.Update(op.ID, models.OperationStatusSucceeded,
+				fmt.Sprintf("Server is now %s", server.CurrentState), false)
+		}
+	}
+
+	if updated == nil && time.Since(op.CreatedAt) > operationTimeout {
+		updated = m.operations.Update(op.ID, models.OperationStatusFailed,
+			fmt.Sprintf("Timed out, server is still %s", server.CurrentState), false)
+	}
+
+	if updated != nil {
+		m.publishOperation(updated)
+	}
+	return updated
+}
+
+// publishOperation sends an operation update to subscribers together with the server state
+func (m *Monitor) publishOperation(op *models.Operation) {
+	server, err := m.storage.GetServer(op.ServerID)
+	if err != nil {
+		return
+	}
+
+	// When a channel is full, clients can still poll the operation
+	m.sendUpdate(ServerUpdate{
+		ServerID:  server.ID,
+		State:     server.CurrentState,
+		Services:  server.Services,
+		Server:    server,
+		Operation: op,
+	})
+}
@@ -0,0 +1,280 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"ecobox-server/internal/models"
+)
+
+// MaxLeaseDuration caps how long a single lease can hold a server on without renewal
+const MaxLeaseDuration = 24 * time.Hour
+
+var (
+	// ErrServerLeased is returned when a request conflicts with active keep-awake leases
+	ErrServerLeased = errors.New("server is held on by active leases")
+	// ErrLeaseNotFound is returned when a lease does not exist or has expired
+	ErrLeaseNotFound = errors.New("lease not found")
+)
+
+// AcquireLease keeps a server on for the given duration on behalf of a holder.
+// A holder that already has a lease on the server gets that lease extended.
+func (m *Monitor) AcquireLease(serverID string, holder string, reason string, duration time.Duration) (*models.Lease, error) {
+	if err := validateLeaseDuration(duration); err != nil {
+		return nil, err
+	}
+
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+
+	server, err := m.storage.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !server.GetPowerCapabilities().Wake {
+		return nil, fmt.Errorf("server %s cannot be woken remotely", server.Name)
+	}
+
+	now := time.Now()
+	leases := server.ActiveLeases(now)
+
+	var lease *models.Lease
+	for i := range leases {
+		if leases[i].Holder == holder {
+			leases[i].ExpiresAt = now.Add(duration)
+			if reason != "" {
+				leases[i].Reason = reason
+			}
+			lease = &leases[i]
+			break
+		}
+	}
+	if lease == nil {
+		leases = append(leases, models.Lease{
+			ID:        newLeaseID(),
+			Holder:    holder,
+			Reason:    reason,
+			CreatedAt: now,
+			ExpiresAt: now.Add(duration),
+		})
+		lease = &leases[len(leases)-1]
+	}
+
+	if err := m.storage.SetServerLeases(server.ID, leases); err != nil {
+		return nil, fmt.Errorf("failed to store lease: %w", err)
+	}
+	server.Leases = leases
+
+	m.logger.Infof("Lease %s for %s acquired by %s until %s", lease.ID, server.Name, holder, lease.ExpiresAt.Format(time.RFC3339))
+
+	result := *lease
+	if err := m.syncLeaseIntent(server); err != nil {
+		return nil, err
+	}
+	m.publishServer(server.ID)
+	return &result, nil
+}
+
+// RenewLease extends an existing lease by the given duration from now
+func (m *Monitor) RenewLease(serverID string, leaseID string, duration time.Duration) (*models.Lease, error) {
+	if err := validateLeaseDuration(duration); err != nil {
+		return nil, err
+	}
+
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+
+	server, err := m.storage.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	leases := server.ActiveLeases(now)
+
+	var lease *models.Lease
+	for i := range leases {
+		if leases[i].ID == leaseID {
+			leases[i].ExpiresAt = now.Add(duration)
+			lease = &leases[i]
+			break
+		}
+	}
+	if lease == nil {
+		return nil, ErrLeaseNotFound
+	}
+
+	if err := m.storage.SetServerLeases(server.ID, leases); err != nil {
+		return nil, fmt.Errorf("failed to store lease: %w", err)
+	}
+	server.Leases = leases
+
+	result := *lease
+	if err := m.syncLeaseIntent(server); err != nil {
+		return nil, err
+	}
+	m.publishServer(server.ID)
+	return &result, nil
+}
+
+// ReleaseLease drops a lease. When it was the last one, the server returns to the intent it had before.
+func (m *Monitor) ReleaseLease(serverID string, leaseID string) error {
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+
+	server, err := m.storage.GetServer(serverID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	found := false
+	leases := make([]models.Lease, 0, len(server.Leases))
+	for _, lease := range server.ActiveLeases(now) {
+		if lease.ID == leaseID {
+			found = true
+			m.logger.Infof("Lease %s for %s released by %s", lease.ID, server.Name, lease.Holder)
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	if !found {
+		return ErrLeaseNotFound
+	}
+
+	if err := m.storage.SetServerLeases(server.ID, leases); err != nil {
+		return fmt.Errorf("failed to store leases: %w", err)
+	}
+	server.Leases = leases
+
+	if err := m.syncLeaseIntent(server); err != nil {
+		return err
+	}
+	m.publishServer(server.ID)
+	return nil
+}
+
+// syncLeases drops expired leases and keeps the lease intent of every server in line
+// with its remaining leases. Called by the reconciler before it acts on servers.
+func (m *Monitor) syncLeases(servers map[string]*models.Server) {
+	m.leaseMu.Lock()
+	defer m.leaseMu.Unlock()
+
+	now := time.Now()
+	for id, server := range servers {
+		if len(server.Leases) == 0 && (server.Intent == nil || !server.Intent.HeldByLeases) {
+			continue
+		}
+
+		active := server.ActiveLeases(now)
+		if len(active) != len(server.Leases) {
+			for _, lease := range server.Leases {
+				if lease.IsExpired(now) {
+					m.logger.Infof("Lease %s for %s held by %s expired", lease.ID, server.Name, lease.Holder)
+				}
+			}
+			if err := m.storage.SetServerLeases(id, active); err != nil {
+				m.logger.Errorf("Failed to drop expired leases for %s: %v", server.Name, err)
+				continue
+			}
+			server.Leases = active
+			m.publishServer(id)
+		}
+
+		if err := m.syncLeaseIntent(server); err != nil {
+			m.logger.Errorf("Failed to update lease intent for %s: %v", server.Name, err)
+			continue
+		}
+
+		if updated, err := m.storage.GetServer(id); err == nil {
+			servers[id] = updated
+		}
+	}
+}
+
+// syncLeaseIntent makes the server's intent match its active leases: held on until
+// the last lease expires, and back to the intent it had before once none are left.
+// Requires leaseMu to be held.
+func (m *Monitor) syncLeaseIntent(server *models.Server) error {
+	now := time.Now()
+	active := server.ActiveLeases(now)
+	heldByLeases := server.Intent != nil && server.Intent.HeldByLeases
+
+	if len(active) == 0 {
+		if heldByLeases {
+			_, err := m.revertIntent(server, "system", "All keep-awake leases released or expired")
+			return err
+		}
+		return nil
+	}
+
+	expiresAt := active[0].ExpiresAt
+	for _, lease := range active[1:] {
+		if lease.ExpiresAt.After(expiresAt) {
+			expiresAt = lease.ExpiresAt
+		}
+	}
+	reason := fmt.Sprintf("Held on by %d keep-awake lease(s)", len(active))
+
+	if heldByLeases {
+		// Follow the latest lease expiry without creating a new operation
+		intent := *server.Intent
+		intent.ExpiresAt = &expiresAt
+		intent.Reason = reason
+		return m.storage.SetDesiredState(server.ID, server.DesiredState, &intent)
+	}
+
+	// A permanent "on" intent already covers the leases
+	if server.DesiredState == models.PowerStateOn && (server.Intent == nil || server.Intent.ExpiresAt == nil) {
+		return nil
+	}
+
+	_, err := m.requestPowerState(server.ID, PowerStateRequest{
+		State:        models.PowerStateOn,
+		Reason:       reason,
+		RequestedBy:  "leases",
+		ExpiresAt:    &expiresAt,
+		heldByLeases: true,
+	})
+	return err
+}
+
+// publishServer sends the stored server, with its leases and groups, to subscribers
+func (m *Monitor) publishServer(serverID string) {
+	server, err := m.storage.GetServer(serverID)
+	if err != nil {
+		return
+	}
+
+	// When a channel is full, the next status update carries the changes
+	m.sendUpdate(ServerUpdate{
+		ServerID: server.ID,
+		State:    server.CurrentState,
+		Services: server.Services,
+		Server:   server,
+	})
+}
+
+// validateLeaseDuration checks that a lease duration is positive and within the cap
+func validateLeaseDuration(duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("lease duration must be positive")
+	}
+	if duration > MaxLeaseDuration {
+		return fmt.Errorf("lease duration must not exceed %s", MaxLeaseDuration)
+	}
+	return nil
+}
+
+// newLeaseID returns a random lease identifier
+func newLeaseID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "lease_" + time.Now().Format("20060102150405.000000000")
+	}
+	return "lease_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,176 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/storage"
+)
+
+func TestLeaseLifecycle(t *testing.T) {
+	m, store := newTestMonitor(t, &models.Server{ID: "nas", Name: "nas", MACAddress: "AA:BB:CC:DD:EE:01", CurrentState: models.PowerStateOn})
+
+	if _, err := m.RequestPowerState("nas", PowerStateRequest{State: models.PowerStateSuspended, Reason: "night", RequestedBy: "alice"}); err != nil {
+		t.Fatalf("RequestPowerState failed: %v", err)
+	}
+
+	lease, err := m.AcquireLease("nas", "backup", "nightly backup", time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+
+	server, _ := store.GetServer("nas")
+	if server.DesiredState != models.PowerStateOn || server.Intent == nil || !server.Intent.HeldByLeases {
+		t.Fatalf("Expected server held on by leases, got %s %+v", server.DesiredState, server.Intent)
+	}
+	if server.Intent.Previous == nil || server.Intent.Previous.RequestedBy != "alice" {
+		t.Fatalf("Expected lease intent to keep the intent from alice, got %+v", server.Intent.Previous)
+	}
+
+	// Only "on" can be requested while leases are active
+	if _, err := m.RequestPowerState("nas", PowerStateRequest{State: models.PowerStateSuspended, RequestedBy: "bob"}); !errors.Is(err, ErrServerLeased) {
+		t.Errorf("Expected ErrServerLeased for suspend, got %v", err)
+	}
+	if _, err := m.ClearPowerIntent("nas", "bob"); !errors.Is(err, ErrServerLeased) {
+		t.Errorf("Expected ErrServerLeased when clearing the lease intent, got %v", err)
+	}
+
+	// The same holder extends its lease instead of adding one
+	again, err := m.AcquireLease("nas", "backup", "", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+	if again.ID != lease.ID || again.Reason != "nightly backup" || !again.ExpiresAt.After(lease.ExpiresAt) {
+		t.Errorf("Expected lease %s to be extended, got %+v", lease.ID, again)
+	}
+
+	renewed, err := m.RenewLease("nas", lease.ID, 3*time.Hour)
+	if err != nil {
+		t.Fatalf("RenewLease failed: %v", err)
+	}
+	server, _ = store.GetServer("nas")
+	if len(server.Leases) != 1 || server.Intent.ExpiresAt == nil || !server.Intent.ExpiresAt.Equal(renewed.ExpiresAt) {
+		t.Errorf("Expected lease intent to follow the renewed lease, got %+v", server.Intent)
+	}
+	if _, err := m.RenewLease("nas", "lease_missing", time.Hour); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("Expected ErrLeaseNotFound, got %v", err)
+	}
+	if _, err := m.RenewLease("nas", lease.ID, MaxLeaseDuration+time.Hour); err == nil {
+		t.Error("Expected an error for a lease longer than the maximum")
+	}
+
+	if err := m.ReleaseLease("nas", lease.ID); err != nil {
+		t.Fatalf("ReleaseLease failed: %v", err)
+	}
+	server, _ = store.GetServer("nas")
+	if server.DesiredState != models.PowerStateSuspended {
+		t.Errorf("Expected desired state suspended after release, got %s", server.DesiredState)
+	}
+	if server.Intent == nil || server.Intent.RequestedBy != "alice" || server.Intent.Reason != "night" || server.Intent.HeldByLeases {
+		t.Errorf("Expected the intent from alice to be restored, got %+v", server.Intent)
+	}
+	if len(server.Leases) != 0 {
+		t.Errorf("Expected no leases, got %d", len(server.Leases))
+	}
+
+	if err := m.ReleaseLease("nas", lease.ID); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("Expected ErrLeaseNotFound for a released lease, got %v", err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	m, store := newTestMonitor(t,
+		&models.Server{ID: "nas", Name: "nas", MACAddress: "AA:BB:CC:DD:EE:01", CurrentState: models.PowerStateOn},
+		&models.Server{ID: "app", Name: "app", MACAddress: "AA:BB:CC:DD:EE:02", CurrentState: models.PowerStateOn},
+	)
+
+	// nas has a configured desired state, app a temporary intent from alice
+	if err := store.SetDesiredState("nas", models.PowerStateSuspended, nil); err != nil {
+		t.Fatalf("Failed to set desired state: %v", err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if _, err := m.RequestPowerState("app", PowerStateRequest{State: models.PowerStateSuspended, RequestedBy: "alice", ExpiresAt: &expiresAt}); err != nil {
+		t.Fatalf("RequestPowerState failed: %v", err)
+	}
+
+	for _, id := range []string{"nas", "app"} {
+		if _, err := m.AcquireLease(id, "backup", "", time.Hour); err != nil {
+			t.Fatalf("AcquireLease failed: %v", err)
+		}
+	}
+
+	// The intent from alice runs out while the lease holds app on
+	app, _ := store.GetServer("app")
+	intent := *app.Intent
+	previous := *intent.Previous
+	past := time.Now().Add(-time.Minute)
+	previous.ExpiresAt = &past
+	intent.Previous = &previous
+	if err := store.SetDesiredState("app", app.DesiredState, &intent); err != nil {
+		t.Fatalf("Failed to set desired state: %v", err)
+	}
+
+	// Let every lease run out
+	for _, id := range []string{"nas", "app"} {
+		server, _ := store.GetServer(id)
+		leases := server.Leases
+		leases[0].ExpiresAt = past
+		if err := store.SetServerLeases(id, leases); err != nil {
+			t.Fatalf("Failed to store leases: %v", err)
+		}
+	}
+	m.syncLeases(store.GetAllServers())
+
+	expected := map[string]models.PowerState{"nas": models.PowerStateSuspended, "app": models.PowerStateUnknown}
+	for id, state := range expected {
+		server, _ := store.GetServer(id)
+		if len(server.Leases) != 0 {
+			t.Errorf("Expected expired leases of %s to be dropped, got %d", id, len(server.Leases))
+		}
+		if server.DesiredState != state || server.Intent != nil {
+			t.Errorf("Expected %s back at desired state %s without intent, got %s %+v", id, state, server.DesiredState, server.Intent)
+		}
+	}
+}
+
+// racingStorage runs a function just before a suspend is recorded, after the
+// request has checked the leases
+type racingStorage struct {
+	storage.Storage
+	beforeSuspend func()
+}
+
+func (s *racingStorage) SetDesiredState(id string, state models.PowerState, intent *models.PowerIntent) error {
+	if state == models.PowerStateSuspended && s.beforeSuspend != nil {
+		s.beforeSuspend()
+	}
+	return s.Storage.SetDesiredState(id, state, intent)
+}
+
+func TestLeaseWinsOverConcurrentSuspend(t *testing.T) {
+	m, store := newTestMonitor(t, &models.Server{ID: "nas", Name: "nas", MACAddress: "AA:BB:CC:DD:EE:01", CurrentState: models.PowerStateOn})
+
+	// A lease acquired while a suspend is being recorded waits for it, and
+	// then holds the server on
+	acquired := make(chan error, 1)
+	m.storage = &racingStorage{Storage: store, beforeSuspend: func() {
+		go func() {
+			_, err := m.AcquireLease("nas", "backup", "", time.Hour)
+			acquired <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}}
+	if _, err := m.RequestPowerState("nas", PowerStateRequest{State: models.PowerStateSuspended, RequestedBy: "alice"}); err != nil {
+		t.Fatalf("RequestPowerState failed: %v", err)
+	}
+	if err := <-acquired; err != nil {
+		t.Fatalf("AcquireLease failed: %v", err)
+	}
+
+	server, _ := store.GetServer("nas")
+	if server.DesiredState != models.PowerStateOn || server.Intent == nil || !server.Intent.HeldByLeases {
+		t.Errorf("Expected the lease to hold the server on, got %s %+v", server.DesiredState, server.Intent)
+	}
+}
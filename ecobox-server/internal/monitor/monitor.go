@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"ecobox-server/internal/alerts"
 	"ecobox-server/internal/command"
 	"ecobox-server/internal/config"
 	"ecobox-server/internal/control"
@@ -13,22 +14,33 @@ import (
 	"ecobox-server/internal/metrics"
 	"ecobox-server/internal/models"
 	"ecobox-server/internal/proxmox"
+	"ecobox-server/internal/secrets"
 	"ecobox-server/internal/storage"
 	"github.com/sirupsen/logrus"
 )
 
+// vmRestartSettleTime is how long a rebooting Proxmox VM stays in the restarting state
+// after the reboot was sent, since the API reports it as running throughout
+const vmRestartSettleTime = 30 * time.Second
+
 // Monitor handles server monitoring and power state reconciliation
 type Monitor struct {
 	config         *config.Config
 	storage        storage.Storage
 	pingChecker    *PingChecker
 	portScanner    *PortScanner
+	healthChecker  *HealthChecker
 	powerManager   *control.PowerManager
 	systemMonitor  *control.SystemMonitor
 	initManager    *initializer.Manager
 	metricsManager *metrics.Manager
 	commander      *command.Commander
+	sshClient      *control.SSHClient
+	alerts         *alerts.Manager // Optional, see SetAlertManager
+	secrets        *secrets.Vault  // Optional, see SetSecrets
 	updateChan     chan ServerUpdate
+	subscribers    []chan ServerUpdate // Additional receivers of updates, see Subscribe
+	subscribersMu  sync.RWMutex
 	stopChan       chan struct{}
 	logger         *logrus.Logger
 	running        bool
@@ -38,12 +50,26 @@ type Monitor struct {
 	lastSystemCheck  map[string]time.Time
 	lastInitCheck    map[string]time.Time
 	
+	// Physical servers seen offline since their restart began
+	restartSeenDown  map[string]bool
+	
+	// Whether a host answers, for confirming transitions; replaced in tests
+	reachable        func(hostname string) bool
+	
+	// Requested operations, and servers with a reconcile pass in flight
+	operations       *OperationTracker
+	reconciling      map[string]bool
+	leaseMu          sync.Mutex // Serializes lease changes
+	
 	// Initialization constants
 	maxInitRetries       int           // Maximum number of initialization attempts before giving up
 	reinitInterval       time.Duration // How often to clear init state and retry (even for failed servers)
 	
 	// Proxmox-specific settings
 	vmDiscoveryInterval  time.Duration // How often to discover VMs on Proxmox hosts
+	
+	// Closed and replaced when the configured intervals change, see UpdateIntervals
+	intervalReset        chan struct{}
 }
 
 // ServerUpdate represents a server state update
@@ -53,6 +79,7 @@ type ServerUpdate struct {
 	Services []models.Service     `json:"services"`
 	Server   *models.Server       `json:"server"`
 	Metrics  map[string]float64   `json:"metrics,omitempty"`
+	Operation *models.Operation   `json:"operation,omitempty"` // Active or just-finished operation for the server
 }
 
 // NewMonitor creates a new monitor instance
@@ -76,26 +103,39 @@ func NewMonitor(cfg *config.Config, storage storage.Storage, powerManager *contr
 		metricsManager = nil
 	}
 	
-	return &Monitor{
+	m := &Monitor{
 		config:              cfg,
 		storage:             storage,
 		pingChecker:         NewPingChecker(),
 		portScanner:         NewPortScanner(),
+		healthChecker:       NewHealthChecker(sshClient),
 		powerManager:        powerManager,
 		systemMonitor:       systemMonitor,
 		initManager:         initManager,
 		metricsManager:      metricsManager,
 		commander:           commander,
+		sshClient:           sshClient,
 		updateChan:          make(chan ServerUpdate, 100),
 		stopChan:            make(chan struct{}),
 		logger:              logrus.New(),
 		running:             false,
 		lastSystemCheck:     make(map[string]time.Time),
 		lastInitCheck:       make(map[string]time.Time),
+		restartSeenDown:     make(map[string]bool),
+		operations:          NewOperationTracker(),
+		reconciling:         make(map[string]bool),
 		maxInitRetries:      3,
 		reinitInterval:      1 * time.Hour, // Clear init state and retry every hour
 		vmDiscoveryInterval: time.Duration(cfg.Dashboard.VMDiscoveryInterval) * time.Second,
+		intervalReset:       make(chan struct{}),
 	}
+	m.reachable = m.hostReachable
+	return m
+}
+
+// hostReachable reports whether a host answers a ping or on a common port
+func (m *Monitor) hostReachable(hostname string) bool {
+	return m.pingChecker.PingHost(hostname, 5*time.Second) || m.portScanner.QuickScan(hostname)
 }
 
 // Start begins monitoring background processes
@@ -146,20 +186,81 @@ func (m *Monitor) Stop() {
 	}
 }
 
+// UpdateIntervals applies changed check intervals from the configuration to the
+// running loops. Each loop restarts its ticker with the new interval.
+func (m *Monitor) UpdateIntervals() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.vmDiscoveryInterval = time.Duration(m.config.LiveDashboard().VMDiscoveryInterval) * time.Second
+	close(m.intervalReset)
+	m.intervalReset = make(chan struct{})
+}
+
+// intervalResetChan returns the channel closed on the next interval change
+func (m *Monitor) intervalResetChan() <-chan struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.intervalReset
+}
+
+// discoveryInterval returns how often Proxmox hosts are searched for VMs
+func (m *Monitor) discoveryInterval() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.vmDiscoveryInterval
+}
+
 // GetUpdates returns the update channel for server state changes
 func (m *Monitor) GetUpdates() <-chan ServerUpdate {
 	return m.updateChan
 }
 
+// Subscribe returns a channel that receives a copy of every update sent on the
+// update channel. Updates are dropped while the channel is full.
+func (m *Monitor) Subscribe() <-chan ServerUpdate {
+	m.subscribersMu.Lock()
+	defer m.subscribersMu.Unlock()
+
+	updates := make(chan ServerUpdate, 100)
+	m.subscribers = append(m.subscribers, updates)
+	return updates
+}
+
+// sendUpdate passes an update to the update channel and the subscribers without
+// blocking
+func (m *Monitor) sendUpdate(update ServerUpdate) {
+	select {
+	case m.updateChan <- update:
+	default:
+		// Channel is full, skip this update
+	}
+
+	m.subscribersMu.RLock()
+	defer m.subscribersMu.RUnlock()
+	for _, updates := range m.subscribers {
+		select {
+		case updates <- update:
+		default:
+		}
+	}
+}
+
 // GetMetricsManager returns the metrics manager for external access
 func (m *Monitor) GetMetricsManager() *metrics.Manager {
 	return m.metricsManager
 }
 
+// GetPortScanner returns the port scanner used for service checks
+func (m *Monitor) GetPortScanner() *PortScanner {
+	return m.portScanner
+}
+
 // statusCheckLoop runs the main monitoring loop
 func (m *Monitor) statusCheckLoop() {
-	ticker := time.NewTicker(time.Duration(m.config.Dashboard.UpdateInterval) * time.Second)
+	ticker := time.NewTicker(time.Duration(m.config.LiveDashboard().UpdateInterval) * time.Second)
 	defer ticker.Stop()
+	reset := m.intervalResetChan()
 
 	// Perform initial check
 	m.checkAllServers()
@@ -168,6 +269,9 @@ func (m *Monitor) statusCheckLoop() {
 		select {
 		case <-ticker.C:
 			m.checkAllServers()
+		case <-reset:
+			ticker.Reset(time.Duration(m.config.LiveDashboard().UpdateInterval) * time.Second)
+			reset = m.intervalResetChan()
 		case <-m.stopChan:
 			m.logger.Info("Status check loop stopped")
 			return
@@ -177,13 +281,17 @@ func (m *Monitor) statusCheckLoop() {
 
 // reconcileLoop handles power state reconciliation
 func (m *Monitor) reconcileLoop() {
-	ticker := time.NewTicker(time.Duration(m.config.Dashboard.WoLRetryInterval) * time.Second)
+	ticker := time.NewTicker(time.Duration(m.config.LiveDashboard().WoLRetryInterval) * time.Second)
 	defer ticker.Stop()
+	reset := m.intervalResetChan()
 
 	for {
 		select {
 		case <-ticker.C:
 			m.reconcileAllServers()
+		case <-reset:
+			ticker.Reset(time.Duration(m.config.LiveDashboard().WoLRetryInterval) * time.Second)
+			reset = m.intervalResetChan()
 		case <-m.stopChan:
 			m.logger.Info("Reconcile loop stopped")
 			return
@@ -215,17 +323,14 @@ func (m *Monitor) checkServerStatus(server *models.Server) {
 		newState, updatedServices = m.checkProxmoxVMStatus(server)
 	} else {
 		newState = m.determineServerState(server)
-		// Update services status for regular servers - now includes discovery
-		if newState == models.PowerStateOn {
-			// Only perform comprehensive scanning if server is online
-			updatedServices = m.portScanner.ScanServicesWithDiscovery(server.Hostname, server.ID, server.Services)
-		} else {
-			// If server is offline, just scan configured services
-			updatedServices = m.portScanner.ScanServices(server.Hostname, server.Services)
-		}
+		// Check services; only perform comprehensive scanning if server is online
+		updatedServices = m.scanServices(server, newState == models.PowerStateOn)
 	}
 	
 	server.Services = updatedServices
+	if newState == models.PowerStateOn {
+		m.observeServices(server, updatedServices)
+	}
 
 	// Record metrics for state changes
 	if newState != oldState {
@@ -250,6 +355,12 @@ func (m *Monitor) checkServerStatus(server *models.Server) {
 			m.recordMetric(server.ID, metrics.StandardMetrics.PowerStateSuspending, 1)
 		case models.PowerStateStopping:
 			m.recordMetric(server.ID, metrics.StandardMetrics.PowerStateStopping, 1)
+		case models.PowerStateHibernated:
+			m.recordMetric(server.ID, metrics.StandardMetrics.PowerStateHibernated, 1)
+		case models.PowerStateHibernating:
+			m.recordMetric(server.ID, metrics.StandardMetrics.PowerStateHibernating, 1)
+		case models.PowerStateRestarting:
+			m.recordMetric(server.ID, metrics.StandardMetrics.PowerStateRestarting, 1)
 		}
 		
 		if err := m.storage.UpdateServerState(server.ID, newState); err != nil {
@@ -261,6 +372,14 @@ func (m *Monitor) checkServerStatus(server *models.Server) {
 		// Update the server object
 		server.CurrentState = newState
 	}
+	
+	// Complete any operation waiting for this state
+	operation := m.checkOperationProgress(server, oldState)
+	if operation == nil {
+		if active, ok := m.operations.Active(server.ID); ok {
+			operation = active
+		}
+	}
 
 	// Record service availability metrics
 	onlineServices := 0
@@ -273,11 +392,19 @@ func (m *Monitor) checkServerStatus(server *models.Server) {
 		serviceAvailabilityPct := (float64(onlineServices) / float64(len(updatedServices))) * 100
 		m.recordMetric(server.ID, metrics.StandardMetrics.ServiceAvailability, serviceAvailabilityPct)
 	}
+	m.recordServiceMetrics(server.ID, updatedServices)
 
-	// Update server services
+	// Update the server, then the check results of its services
 	if err := m.storage.UpdateServer(server); err != nil {
 		m.logger.Errorf("Failed to update server %s: %v", server.Name, err)
 	}
+	if err := m.storage.UpdateServiceResults(server.ID, updatedServices); err != nil {
+		m.logger.Errorf("Failed to update services of server %s: %v", server.Name, err)
+	} else if stored, err := m.storage.GetServer(server.ID); err == nil {
+		// Services changed through the API since this check began are kept
+		server = stored
+		updatedServices = stored.Services
+	}
 
 	// Get current metrics for this server
 	metrics, err := m.metricsManager.GetLatestValues(server.ID)
@@ -289,17 +416,14 @@ func (m *Monitor) checkServerStatus(server *models.Server) {
 	}
 
 	// Send update notification
-	select {
-	case m.updateChan <- ServerUpdate{
+	m.sendUpdate(ServerUpdate{
 		ServerID: server.ID,
 		State:    newState,
 		Services: updatedServices,
 		Server:   server,
 		Metrics:  metrics,
-	}:
-	default:
-		// Channel is full, skip this update
-	}
+		Operation: operation,
+	})
 }
 
 // determineServerState determines server state using ping and port scanning
@@ -318,13 +442,15 @@ func (m *Monitor) determineServerState(server *models.Server) models.PowerState
 	switch server.CurrentState {
 	case models.PowerStateWaking:
 		// Server was waking - check if it's now online
-		if m.pingChecker.PingHost(server.Hostname, timeout) || m.portScanner.QuickScan(server.Hostname) {
+		if m.reachable(server.Hostname) {
 			m.logger.Infof("Server %s successfully completed wake operation", server.Name)
+			m.observe(models.AlertConditionWakeTimeout, server, "", false, "Server came up after wake")
 			return models.PowerStateOn
 		}
 		// Still waking - check if we should timeout the wake operation
 		if !server.LastStateChange.IsZero() && time.Since(server.LastStateChange) > 5*time.Minute {
 			m.logger.Warnf("Server %s wake operation timed out, reverting to off state", server.Name)
+			m.observe(models.AlertConditionWakeTimeout, server, "", true, "Server did not come up within 5 minutes of the wake command")
 			return models.PowerStateOff
 		}
 		// Keep waking state
@@ -332,7 +458,7 @@ func (m *Monitor) determineServerState(server *models.Server) models.PowerState
 		
 	case models.PowerStateSuspending:
 		// Server was suspending - check if it's now offline
-		if !m.pingChecker.PingHost(server.Hostname, timeout) && !m.portScanner.QuickScan(server.Hostname) {
+		if !m.reachable(server.Hostname) {
 			m.logger.Infof("Server %s successfully completed suspend operation", server.Name)
 			return models.PowerStateSuspended
 		}
@@ -346,7 +472,7 @@ func (m *Monitor) determineServerState(server *models.Server) models.PowerState
 		
 	case models.PowerStateStopping:
 		// Server was stopping - check if it's now offline
-		if !m.pingChecker.PingHost(server.Hostname, timeout) && !m.portScanner.QuickScan(server.Hostname) {
+		if !m.reachable(server.Hostname) {
 			m.logger.Infof("Server %s successfully completed stop operation", server.Name)
 			return models.PowerStateStopped
 		}
@@ -357,6 +483,48 @@ func (m *Monitor) determineServerState(server *models.Server) models.PowerState
 		}
 		// Keep stopping state
 		return models.PowerStateStopping
+		
+	case models.PowerStateHibernating:
+		// Server was hibernating - check if it's now offline
+		if !m.reachable(server.Hostname) {
+			m.logger.Infof("Server %s successfully completed hibernate operation", server.Name)
+			return models.PowerStateHibernated
+		}
+		// Writing RAM to disk takes longer than a suspend
+		if !server.LastStateChange.IsZero() && time.Since(server.LastStateChange) > 5*time.Minute {
+			m.logger.Warnf("Server %s hibernate operation timed out, reverting to on state", server.Name)
+			return models.PowerStateOn
+		}
+		// Keep hibernating state
+		return models.PowerStateHibernating
+		
+	case models.PowerStateRestarting:
+		// Server was restarting - it must go offline and come back before the restart counts as done
+		online := m.reachable(server.Hostname)
+		m.mu.Lock()
+		if !online {
+			m.restartSeenDown[server.ID] = true
+		}
+		seenDown := m.restartSeenDown[server.ID]
+		m.mu.Unlock()
+		
+		if online && seenDown {
+			m.logger.Infof("Server %s successfully completed restart operation", server.Name)
+			m.clearRestartTracking(server.ID)
+			return models.PowerStateOn
+		}
+		// Still restarting - check if we should timeout the restart operation
+		if !server.LastStateChange.IsZero() && time.Since(server.LastStateChange) > 10*time.Minute {
+			m.clearRestartTracking(server.ID)
+			if online {
+				m.logger.Warnf("Server %s never went offline during restart, assuming it is still on", server.Name)
+				return models.PowerStateOn
+			}
+			m.logger.Warnf("Server %s restart operation timed out, reverting to off state", server.Name)
+			return models.PowerStateOff
+		}
+		// Keep restarting state
+		return models.PowerStateRestarting
 	}
 
 	// Normal state detection logic
@@ -386,8 +554,8 @@ func (m *Monitor) determineServerState(server *models.Server) models.PowerState
 	// If we can't detect the server, determine if it's off or suspended
 	// This is a best guess - in reality, we can't easily distinguish between off and suspended
 	// without additional information or wake-on-LAN testing
-	if server.CurrentState == models.PowerStateSuspended {
-		return models.PowerStateSuspended
+	if server.CurrentState == models.PowerStateSuspended || server.CurrentState == models.PowerStateHibernated {
+		return server.CurrentState
 	}
 
 	return models.PowerStateOff
@@ -397,25 +565,56 @@ func (m *Monitor) determineServerState(server *models.Server) models.PowerState
 func (m *Monitor) reconcileAllServers() {
 	servers := m.storage.GetAllServers()
 	
+	// Drop expired leases, then hand servers whose temporary intent ran out back
+	// to their previous desired state
+	m.syncLeases(servers)
+	m.expireIntents(servers)
+	
 	for _, server := range servers {
 		shouldReconcile := false
 		
 		// Always reconcile if desired state doesn't match current state
-		if server.CurrentState != server.DesiredState {
+		if !server.InDesiredState() {
 			shouldReconcile = true
 		}
 		
+		// Report operations that are already satisfied or have timed out
+		m.checkOperationProgress(server, server.CurrentState)
+		
 		// Also reconcile if server needs initialization (including periodic re-init)
 		if m.shouldAttemptInitialization(server) {
 			shouldReconcile = true
 		}
 		
-		if shouldReconcile {
-			go m.reconcileServerState(server)
+		if shouldReconcile && m.beginReconcile(server.ID) {
+			go func(server *models.Server) {
+				defer m.endReconcile(server.ID)
+				m.reconcileServerState(server)
+			}(server)
 		}
 	}
 }
 
+
+// beginReconcile marks a server as being reconciled, returning false if a pass is
+// already in flight so that only one actor drives a server at a time
+func (m *Monitor) beginReconcile(serverID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reconciling[serverID] {
+		return false
+	}
+	m.reconciling[serverID] = true
+	return true
+}
+
+// endReconcile clears the in-flight marker set by beginReconcile
+func (m *Monitor) endReconcile(serverID string) {
+	m.mu.Lock()
+	delete(m.reconciling, serverID)
+	m.mu.Unlock()
+}
 // reconcileServerState reconciles a single server's power state
 func (m *Monitor) reconcileServerState(server *models.Server) {
 	m.logger.Infof("Reconciling power state for %s: current=%s, desired=%s, initialized=%t", 
@@ -459,13 +658,32 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 		server = updatedServer
 	}
 
+	// Servers that others hard-depend on only go down once their dependents are down
+	if server.IsGoingDown() && !server.InDesiredState() {
+		if dependents := m.powerManager.ActiveDependents(server); len(dependents) > 0 {
+			m.logger.Infof("Not taking %s down yet, dependents still running: %s", server.Name, control.ServerNames(dependents))
+			m.recordOperationWaiting(server, fmt.Sprintf("Waiting for dependents to go down: %s", control.ServerNames(dependents)))
+			return
+		}
+	}
+
 	// Perform power state reconciliation
 	switch server.DesiredState {
 	case models.PowerStateOn:
 		if server.CurrentState == models.PowerStateOff || 
 		   server.CurrentState == models.PowerStateStopped ||
 		   server.CurrentState == models.PowerStateSuspended ||
+		   server.CurrentState == models.PowerStateHibernated ||
 		   server.CurrentState == models.PowerStateUnknown {
+			// Dependencies come up first. Rather than wait for them, the reconciler
+			// checks back on its next pass and the operation says what it waits for.
+			waiting, err := m.powerManager.WakeDependencies(server)
+			if waiting != nil {
+				m.logger.Infof("Not waking %s yet, waiting for dependency %s", server.Name, waiting.Name)
+				m.recordOperationWaiting(server, fmt.Sprintf("Waiting for dependency %s to be ready", waiting.Name))
+				return
+			}
+			
 			m.logger.Infof("Attempting to wake server %s (current: %s)", server.Name, server.CurrentState)
 			
 			// Set transitioning state to indicate wake operation in progress
@@ -486,8 +704,11 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 			}
 			
 			startTime := time.Now()
-			err := m.powerManager.WakeServer(server)
+			if err == nil {
+				err = m.powerManager.WakeServer(server)
+			}
 			wakeDuration := time.Since(startTime)
+			m.recordOperationAttempt(server, "Wake", err)
 			
 			// Record timing metrics
 			m.recordMetric(server.ID, metrics.StandardMetrics.WakeDuration, wakeDuration.Seconds())
@@ -495,6 +716,7 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 			if err != nil {
 				m.logger.Errorf("Failed to wake server %s: %v", server.Name, err)
 				m.recordMetric(server.ID, metrics.StandardMetrics.WakeFailure, 1)
+				m.observe(models.AlertConditionWakeFailure, server, "", true, fmt.Sprintf("Wake failed: %v", err))
 				action.ErrorMsg = err.Error()
 				
 				// Revert to previous state on failure (best guess)
@@ -515,6 +737,7 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 			} else {
 				m.logger.Infof("Successfully sent wake command to server %s", server.Name)
 				m.recordMetric(server.ID, metrics.StandardMetrics.WakeSuccess, 1)
+				m.observe(models.AlertConditionWakeFailure, server, "", false, "Wake command sent")
 				action.Success = true
 				
 				// Keep waking state - will be updated by status check when server comes online
@@ -531,10 +754,15 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 			m.logger.Infof("Attempting to suspend server %s", server.Name)
 			
 			// Set transitioning state to indicate suspend operation in progress
-			if err := m.storage.UpdateServerState(server.ID, models.PowerStateSuspending); err != nil {
-				m.logger.Errorf("Failed to set suspending state for server %s: %v", server.Name, err)
+			// (VMs configured to hibernate show the hibernating state instead)
+			transitionState := models.PowerStateSuspending
+			if server.IsProxmoxVM && server.GetVMSuspendMode() == models.VMSuspendModeHibernate {
+				transitionState = models.PowerStateHibernating
+			}
+			if err := m.storage.UpdateServerState(server.ID, transitionState); err != nil {
+				m.logger.Errorf("Failed to set %s state for server %s: %v", transitionState, server.Name, err)
 			} else {
-				server.CurrentState = models.PowerStateSuspending
+				server.CurrentState = transitionState
 			}
 			
 			// Record suspend attempt metric
@@ -550,6 +778,7 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 			startTime := time.Now()
 			err := m.powerManager.SuspendServer(server)
 			suspendDuration := time.Since(startTime)
+			m.recordOperationAttempt(server, "Suspend", err)
 			
 			// Record timing metrics
 			m.recordMetric(server.ID, metrics.StandardMetrics.SuspendDuration, suspendDuration.Seconds())
@@ -577,10 +806,61 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 			}
 		}
 		
+	case models.PowerStateHibernated:
+		// Suspend to disk - physical hosts must report hibernate support and be online
+		canHibernate := server.GetPowerCapabilities().Hibernate &&
+			(server.CurrentState == models.PowerStateOn ||
+				(server.IsProxmoxVM && server.CurrentState == models.PowerStateSuspended))
+		if canHibernate {
+			m.logger.Infof("Attempting to hibernate server %s", server.Name)
+
+			// Set transitioning state to indicate hibernate operation in progress
+			if err := m.storage.UpdateServerState(server.ID, models.PowerStateHibernating); err != nil {
+				m.logger.Errorf("Failed to set hibernating state for server %s: %v", server.Name, err)
+			} else {
+				server.CurrentState = models.PowerStateHibernating
+			}
+
+			// Record hibernate attempt metric
+			m.recordMetric(server.ID, metrics.StandardMetrics.HibernateAttempt, 1)
+
+			action := models.ServerAction{
+				Timestamp:   time.Now(),
+				Action:      models.ActionTypeReconcile,
+				Success:     false,
+				InitiatedBy: "reconciler",
+			}
+
+			err := m.powerManager.HibernateServer(server)
+			m.recordOperationAttempt(server, "Hibernate", err)
+			if err != nil {
+				m.logger.Errorf("Failed to hibernate server %s: %v", server.Name, err)
+				m.recordMetric(server.ID, metrics.StandardMetrics.HibernateFailure, 1)
+				action.ErrorMsg = err.Error()
+
+				// Revert to online state on failure
+				if updateErr := m.storage.UpdateServerState(server.ID, models.PowerStateOn); updateErr != nil {
+					m.logger.Errorf("Failed to revert server state after hibernate failure for %s: %v", server.Name, updateErr)
+				}
+			} else {
+				m.logger.Infof("Successfully sent hibernate command to server %s", server.Name)
+				m.recordMetric(server.ID, metrics.StandardMetrics.HibernateSuccess, 1)
+				action.Success = true
+			}
+
+			// Log the reconciliation action
+			if err := m.storage.AddServerAction(server.ID, action); err != nil {
+				m.logger.Errorf("Failed to log reconciliation action for %s: %v", server.Name, err)
+			}
+		}
+
 	case models.PowerStateStopped:
-		// Only meaningful for Proxmox VMs - clean shutdown (graceful)
-		if server.CurrentState == models.PowerStateOn && server.IsProxmoxVM {
-			m.logger.Infof("Attempting to shutdown Proxmox VM %s", server.Name)
+		// Only meaningful for Proxmox VMs - clean shutdown (graceful), or a forced stop if the intent asks for it
+		force := server.Intent != nil && server.Intent.Force
+		canStop := server.CurrentState == models.PowerStateOn ||
+			(force && server.CurrentState == models.PowerStateSuspended)
+		if canStop && server.IsProxmoxVM {
+			m.logger.Infof("Attempting to shutdown Proxmox VM %s (force: %t)", server.Name, force)
 			
 			// Set transitioning state to indicate shutdown operation in progress
 			if err := m.storage.UpdateServerState(server.ID, models.PowerStateStopping); err != nil {
@@ -600,7 +880,14 @@ func (m *Monitor) reconcileServerState(server *models.Server) {
 			}
 			
 			startTime := time.Now()
-			err := m.powerManager.ShutdownServer(server)
+			var err error
+			if force {
+				err = m.powerManager.StopServer(server)
+				m.recordOperationAttempt(server, "Stop", err)
+			} else {
+				err = m.powerManager.ShutdownServer(server)
+				m.recordOperationAttempt(server, "Shutdown", err)
+			}
 			shutdownDuration := time.Since(startTime)
 			
 			// Record timing metrics
@@ -708,6 +995,7 @@ func (m *Monitor) attemptServerInitialization(server *models.Server) bool {
 		
 		// Record metrics for max retries exceeded
 		m.recordMetric(server.ID, metrics.StandardMetrics.InitMaxRetriesExceeded, 1)
+		m.observe(models.AlertConditionInitFailed, server, "", true, fmt.Sprintf("Initialization failed %d times", server.InitRetryCount))
 		m.recordMetric(server.ID, metrics.StandardMetrics.InitRetryCount, float64(server.InitRetryCount))
 		
 		// Update server state to init failed
@@ -782,6 +1070,7 @@ func (m *Monitor) attemptServerInitialization(server *models.Server) bool {
 	
 	// Record success metrics
 	m.recordMetric(server.ID, metrics.StandardMetrics.InitSuccess, 1)
+	m.observe(models.AlertConditionInitFailed, server, "", false, "Initialization succeeded")
 	m.recordMetric(server.ID, metrics.StandardMetrics.InitDuration, initDuration.Seconds())
 	m.recordMetric(server.ID, metrics.StandardMetrics.InitRetryCount, float64(server.InitRetryCount))
 	
@@ -826,6 +1115,7 @@ func (m *Monitor) SetLogger(logger *logrus.Logger) {
 	m.logger = logger
 	m.initManager.SetLogger(logger)
 	m.commander.SetLogger(logger)
+	m.healthChecker.SetLogger(logger)
 }
 
 // IsRunning returns whether the monitor is currently running
@@ -840,19 +1130,86 @@ func (m *Monitor) ForceCheck() {
 	go m.checkAllServers()
 }
 
-// ForceReconcile triggers an immediate reconciliation of all servers
+// ForceReconcile triggers an immediate reconciliation of all servers. Before
+// Start, the first pass of the reconcile loop picks up the changes instead.
 func (m *Monitor) ForceReconcile() {
+	if !m.IsRunning() {
+		return
+	}
 	go m.reconcileAllServers()
 }
 
+// RestartServer reboots a server, cleanly or with a hard reset (Proxmox VMs only),
+// and tracks the restart through the restarting transitional state. The returned
+// operation completes once the server is back online.
+func (m *Monitor) RestartServer(server *models.Server, hard bool, requestedBy string) (*models.Operation, error) {
+	actionType := models.ActionTypeRestart
+	attemptMetric, successMetric, failureMetric := metrics.StandardMetrics.RestartAttempt,
+		metrics.StandardMetrics.RestartSuccess, metrics.StandardMetrics.RestartFailure
+	if hard {
+		actionType = models.ActionTypeReset
+		attemptMetric, successMetric, failureMetric = metrics.StandardMetrics.ResetAttempt,
+			metrics.StandardMetrics.ResetSuccess, metrics.StandardMetrics.ResetFailure
+	}
+
+	// Don't interleave with a reconcile pass acting on the same server
+	if !m.beginReconcile(server.ID) {
+		return nil, fmt.Errorf("server %s is busy with another power operation", server.Name)
+	}
+	defer m.endReconcile(server.ID)
+
+	m.recordMetric(server.ID, attemptMetric, 1)
+	op, superseded := m.operations.Create(server.ID, actionType, models.PowerStateOn, requestedBy, "")
+	if superseded != nil {
+		m.publishOperation(superseded)
+	}
+
+	var err error
+	if hard {
+		err = m.powerManager.ResetServer(server)
+	} else {
+		err = m.powerManager.RestartServer(server)
+	}
+
+	if err != nil {
+		m.recordMetric(server.ID, failureMetric, 1)
+		if updated := m.operations.Update(op.ID, models.OperationStatusFailed, err.Error(), true); updated != nil {
+			m.publishOperation(updated)
+		}
+		return nil, err
+	}
+	m.recordMetric(server.ID, successMetric, 1)
+
+	// Set transitioning state - the status check moves it back to on once the server is back
+	m.clearRestartTracking(server.ID)
+	if err := m.storage.UpdateServerState(server.ID, models.PowerStateRestarting); err != nil {
+		m.logger.Errorf("Failed to set restarting state for server %s: %v", server.Name, err)
+	}
+
+	if updated := m.operations.Update(op.ID, models.OperationStatusRunning, fmt.Sprintf("%s sent, waiting for the server to come back", actionType), true); updated != nil {
+		op = updated
+		m.publishOperation(op)
+	}
+	return op, nil
+}
+
+// clearRestartTracking forgets whether a restarting server has been seen offline
+func (m *Monitor) clearRestartTracking(serverID string) {
+	m.mu.Lock()
+	delete(m.restartSeenDown, serverID)
+	m.mu.Unlock()
+}
+
 // systemCheckLoop handles periodic system information gathering for online servers
 func (m *Monitor) systemCheckLoop() {
-	interval := time.Duration(m.config.Dashboard.SystemCheckInterval) * time.Second
+	interval := time.Duration(m.config.LiveDashboard().SystemCheckInterval) * time.Second
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	m.logger.WithField("interval", interval).Info("Starting system check loop")
 
+	reset := m.intervalResetChan()
+
 	// Perform initial system checks
 	m.performSystemChecks()
 
@@ -860,6 +1217,9 @@ func (m *Monitor) systemCheckLoop() {
 		select {
 		case <-ticker.C:
 			m.performSystemChecks()
+		case <-reset:
+			ticker.Reset(time.Duration(m.config.LiveDashboard().SystemCheckInterval) * time.Second)
+			reset = m.intervalResetChan()
 		case <-m.stopChan:
 			m.logger.Info("System check loop stopped")
 			return
@@ -869,12 +1229,14 @@ func (m *Monitor) systemCheckLoop() {
 
 // initializationCheckLoop handles periodic initialization checks and re-initialization
 func (m *Monitor) initializationCheckLoop() {
-	interval := time.Duration(m.config.Dashboard.InitCheckInterval) * time.Second
+	interval := time.Duration(m.config.LiveDashboard().InitCheckInterval) * time.Second
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	m.logger.WithField("interval", interval).Info("Starting initialization check loop")
 
+	reset := m.intervalResetChan()
+
 	// Perform initial initialization checks
 	m.performInitializationChecks()
 
@@ -882,6 +1244,9 @@ func (m *Monitor) initializationCheckLoop() {
 		select {
 		case <-ticker.C:
 			m.performInitializationChecks()
+		case <-reset:
+			ticker.Reset(time.Duration(m.config.LiveDashboard().InitCheckInterval) * time.Second)
+			reset = m.intervalResetChan()
 		case <-m.stopChan:
 			m.logger.Info("Initialization check loop stopped")
 			return
@@ -892,8 +1257,9 @@ func (m *Monitor) initializationCheckLoop() {
 // proxmoxDiscoveryLoop runs periodically to discover and manage Proxmox VMs
 func (m *Monitor) proxmoxDiscoveryLoop() {
 	m.logger.Info("Starting Proxmox VM discovery loop")
-	ticker := time.NewTicker(m.vmDiscoveryInterval)
+	ticker := time.NewTicker(m.discoveryInterval())
 	defer ticker.Stop()
+	reset := m.intervalResetChan()
 
 	// Perform initial discovery
 	m.performProxmoxDiscovery()
@@ -902,6 +1268,9 @@ func (m *Monitor) proxmoxDiscoveryLoop() {
 		select {
 		case <-ticker.C:
 			m.performProxmoxDiscovery()
+		case <-reset:
+			ticker.Reset(m.discoveryInterval())
+			reset = m.intervalResetChan()
 		case <-m.stopChan:
 			m.logger.Info("Proxmox discovery loop stopped")
 			return
@@ -939,7 +1308,7 @@ func (m *Monitor) performProxmoxDiscovery() {
 		}
 		
 		// Discover VMs if this is a Proxmox host that should discover VMs
-		if server.ShouldDiscoverVMs(m.vmDiscoveryInterval) {
+		if server.ShouldDiscoverVMs(m.discoveryInterval()) {
 			m.logger.Infof("Server %s should discover VMs", server.Name)
 			m.discoverProxmoxVMs(server)
 		}
@@ -950,22 +1319,40 @@ func (m *Monitor) performProxmoxDiscovery() {
 func (m *Monitor) setupProxmoxAPIKey(server *models.Server) {
 	m.logger.WithField("server", server.Name).Info("Setting up Proxmox API key")
 	
-	// Create API key using SSH
-	apiKey, err := m.commander.CreateProxmoxAPIKey(
-		server.Hostname,
-		server.SSHPort,
-		server.SSHUser,
-		server.SSHKeyPath,
-	)
+	// Use the configured token, or the one created for this host before
+	apiKey, err := m.loadProxmoxAPIKey(server)
 	if err != nil {
 		m.logger.WithField("server", server.Name).
 			WithError(err).
-			Error("Failed to create Proxmox API key")
+			Error("Failed to load Proxmox API key from the secret vault")
 		return
 	}
 	
+	if apiKey == nil {
+		// Create API key using SSH
+		apiKey, err = m.commander.CreateProxmoxAPIKey(
+			server.Hostname,
+			server.SSHPort,
+			server.SSHUser,
+			server.SSHKeyPath,
+		)
+		if err != nil {
+			m.logger.WithField("server", server.Name).
+				WithError(err).
+				Error("Failed to create Proxmox API key")
+			return
+		}
+		m.saveProxmoxAPIKey(server, apiKey)
+	}
+	
 	// Store API key in server
 	server.ProxmoxAPIKey = apiKey
+	if err := m.storage.SetProxmoxAPIKey(server.ID, server.ProxmoxTokenRef, apiKey); err != nil {
+		m.logger.WithField("server", server.Name).
+			WithError(err).
+			Error("Failed to store Proxmox API key")
+		return
+	}
 	
 	// Discover the actual node name by querying the API
 	client := proxmox.NewClient(
@@ -992,7 +1379,7 @@ func (m *Monitor) setupProxmoxAPIKey(server *models.Server) {
 	if err := m.storage.UpdateServer(server); err != nil {
 		m.logger.WithField("server", server.Name).
 			WithError(err).
-			Error("Failed to store Proxmox API key")
+			Error("Failed to store Proxmox node name")
 		return
 	}
 	
@@ -1095,17 +1482,18 @@ func (m *Monitor) createProxmoxVMServer(proxmoxHost *models.Server, vm proxmox.V
 		ID:             serverID,
 		Name:           vm.Name,
 		Hostname:       hostname,
-		CurrentState:   m.convertProxmoxStatusToPowerState(vm.Status),
+		CurrentState:   m.convertProxmoxStatusToPowerState(vm.Status, vm.Lock),
 		DesiredState:   models.PowerStateUnknown, // VMs don't need power management via SSH
 		ParentServerID: proxmoxHost.ID,
 		IsProxmoxVM:    true,
 		ProxmoxVMID:    vm.VMID,
 		ProxmoxNodeName: proxmoxHost.ProxmoxNodeName,
-		Source:         models.SourceAPI,
+		Source:         models.SourceDiscovered,
 		LastStateChange: time.Now(),
 		Services:       []models.Service{}, // VMs don't need SSH services
 		Initialized:    true, // VMs don't need SSH initialization
 		LastSuccessfulInit: time.Now(),
+		VMPowerPolicy:  m.lookupVMPowerPolicy(proxmoxHost.ID, vm.VMID),
 	}
 	
 	// Store the new VM server
@@ -1125,6 +1513,11 @@ func (m *Monitor) createProxmoxVMServer(proxmoxHost *models.Server, vm proxmox.V
 func (m *Monitor) updateProxmoxVMServer(vmServer *models.Server, vm proxmox.VM, client *proxmox.Client) {
 	// Update VM-specific information
 	vmServer.Name = vm.Name
+
+	// Apply power policy from configuration if one is defined for this VM
+	if policy := m.lookupVMPowerPolicy(vmServer.ParentServerID, vm.VMID); policy != nil {
+		vmServer.VMPowerPolicy = policy
+	}
 	
 	// Update hostname if we can get IP addresses
 	ips, err := client.GetVMIPAddress(vm.VMID)
@@ -1136,7 +1529,7 @@ func (m *Monitor) updateProxmoxVMServer(vmServer *models.Server, vm proxmox.VM,
 	}
 	
 	// Update power state based on VM status
-	newState := m.convertProxmoxStatusToPowerState(vm.Status)
+	newState := m.convertProxmoxStatusToPowerState(vm.Status, vm.Lock)
 	if newState != vmServer.CurrentState {
 		vmServer.CurrentState = newState
 		vmServer.LastStateChange = time.Now()
@@ -1155,12 +1548,17 @@ func (m *Monitor) updateProxmoxVMServer(vmServer *models.Server, vm proxmox.VM,
 	}
 }
 
-// convertProxmoxStatusToPowerState converts Proxmox VM status to our PowerState
-func (m *Monitor) convertProxmoxStatusToPowerState(proxmoxStatus string) models.PowerState {
+// convertProxmoxStatusToPowerState converts Proxmox VM status to our PowerState.
+// A stopped VM holding the "suspended" lock was suspended to disk and is
+// reported as hibernated.
+func (m *Monitor) convertProxmoxStatusToPowerState(proxmoxStatus string, lock string) models.PowerState {
 	switch strings.ToLower(proxmoxStatus) {
 	case "running":
 		return models.PowerStateOn
 	case "stopped":
+		if lock == "suspended" {
+			return models.PowerStateHibernated // vmstate saved to disk
+		}
 		return models.PowerStateStopped  // VM is stopped (full shutdown)
 	case "suspended", "paused":
 		return models.PowerStateSuspended // VM is suspended/paused (RAM preserved)
@@ -1169,6 +1567,25 @@ func (m *Monitor) convertProxmoxStatusToPowerState(proxmoxStatus string) models.
 	}
 }
 
+// lookupVMPowerPolicy returns the configured power policy for a VM on a Proxmox host, if any
+func (m *Monitor) lookupVMPowerPolicy(hostID string, vmid int) *models.VMPowerPolicy {
+	for _, serverConfig := range m.config.Servers {
+		if serverConfig.ID != hostID {
+			continue
+		}
+		for _, policy := range serverConfig.VMPolicies {
+			if policy.VMID == vmid {
+				return &models.VMPowerPolicy{
+					SuspendMode:        models.VMSuspendMode(policy.SuspendMode),
+					SnapshotBeforeStop: policy.SnapshotBeforeStop,
+					SnapshotKeep:       policy.SnapshotKeep,
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // performSystemChecks runs system information gathering for all appropriate servers
 func (m *Monitor) performSystemChecks() {
 	servers := m.storage.GetAllServers()
@@ -1208,7 +1625,7 @@ func (m *Monitor) performSystemChecks() {
 		
 		if exists {
 			timeSinceCheck := time.Since(lastCheck)
-			minInterval := time.Duration(m.config.Dashboard.SystemCheckInterval) * time.Second
+			minInterval := time.Duration(m.config.LiveDashboard().SystemCheckInterval) * time.Second
 			if timeSinceCheck < minInterval {
 				continue
 			}
@@ -1456,7 +1873,7 @@ func (m *Monitor) checkProxmoxVMStatus(server *models.Server) (models.PowerState
 	}
 	
 	// Convert status to power state - but handle transitioning states
-	apiState := m.convertProxmoxStatusToPowerState(vmStatus.Status)
+	apiState := m.convertProxmoxStatusToPowerState(vmStatus.Status, vmStatus.Lock)
 	var newState models.PowerState
 	
 	// Handle transitioning states
@@ -1476,7 +1893,8 @@ func (m *Monitor) checkProxmoxVMStatus(server *models.Server) (models.PowerState
 		}
 		
 	case models.PowerStateSuspending:
-		if apiState == models.PowerStateSuspended {
+		// Depending on the VM's suspend mode, a completed suspend may leave it paused or stopped
+		if apiState == models.PowerStateSuspended || apiState == models.PowerStateStopped {
 			m.logger.WithField("server", server.Name).Info("Proxmox VM successfully completed suspend operation")
 			newState = apiState
 		} else {
@@ -1503,6 +1921,35 @@ func (m *Monitor) checkProxmoxVMStatus(server *models.Server) (models.PowerState
 			}
 		}
 		
+	case models.PowerStateHibernating:
+		if apiState == models.PowerStateHibernated {
+			m.logger.WithField("server", server.Name).Info("Proxmox VM successfully completed hibernate operation")
+			newState = apiState
+		} else {
+			// Writing RAM to disk can take a while - allow the same time as a wake
+			if !server.LastStateChange.IsZero() && time.Since(server.LastStateChange) > 5*time.Minute {
+				m.logger.WithField("server", server.Name).Warn("Proxmox VM hibernate operation timed out")
+				newState = apiState // Use actual API state
+			} else {
+				newState = models.PowerStateHibernating // Keep transitioning
+			}
+		}
+		
+	case models.PowerStateRestarting:
+		// A rebooting VM keeps reporting running, so give the guest time to go through the reboot
+		if apiState == models.PowerStateOn && time.Since(server.LastStateChange) > vmRestartSettleTime {
+			m.logger.WithField("server", server.Name).Info("Proxmox VM successfully completed restart operation")
+			newState = apiState
+		} else {
+			// Still restarting or failed - check timeout
+			if !server.LastStateChange.IsZero() && time.Since(server.LastStateChange) > 5*time.Minute {
+				m.logger.WithField("server", server.Name).Warn("Proxmox VM restart operation timed out")
+				newState = apiState // Use actual API state
+			} else {
+				newState = models.PowerStateRestarting // Keep transitioning
+			}
+		}
+		
 	default:
 		// Not in transitioning state, use API state directly
 		newState = apiState
@@ -1514,7 +1961,7 @@ func (m *Monitor) checkProxmoxVMStatus(server *models.Server) (models.PowerState
 		m.logger.WithField("server", server.Name).WithField("hostname", server.Hostname).
 			Debug("Scanning VM services with discovery")
 		// Use comprehensive scanning with discovery for VMs too
-		updatedServices = m.portScanner.ScanServicesWithDiscovery(server.Hostname, server.ID, server.Services)
+		updatedServices = m.scanServices(server, true)
 		m.logger.WithField("server", server.Name).WithField("service_count", len(updatedServices)).
 			Debug("VM service scan completed")
 	} else {
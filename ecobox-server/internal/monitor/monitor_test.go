@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/storage"
+)
+
+// newTestMonitor returns a monitor that is not started, so requests are only
+// recorded and nothing acts on the servers
+func newTestMonitor(t *testing.T, servers ...*models.Server) (*Monitor, *storage.MemoryStorage) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Dashboard.MetricsDataDir = t.TempDir()
+	cfg.SetDefaults()
+
+	store := storage.NewMemoryStorage()
+	for _, server := range servers {
+		if err := store.AddServer(server); err != nil {
+			t.Fatalf("Failed to add server: %v", err)
+		}
+	}
+
+	m := NewMonitor(cfg, store, control.NewPowerManager(store))
+	t.Cleanup(func() {
+		if metrics := m.GetMetricsManager(); metrics != nil {
+			metrics.Close()
+		}
+	})
+	return m, store
+}
+
+// serveProxmox stands in for the Proxmox API of the host at 127.0.0.1, which
+// clients reach on port 8006. It answers every request with a task ID.
+func serveProxmox(t *testing.T) *[]string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:8006")
+	if err != nil {
+		t.Skipf("Port 8006 is taken: %v", err)
+	}
+
+	var paths []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]string{"data": "UPID:pve:test"})
+	}))
+	server.Listener = listener
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return &paths
+}
+
+func TestTransitionalStates(t *testing.T) {
+	m, _ := newTestMonitor(t)
+
+	tests := []struct {
+		state  models.PowerState
+		online bool
+		since  time.Duration // Time in the state
+		want   models.PowerState
+	}{
+		{models.PowerStateHibernating, false, time.Minute, models.PowerStateHibernated},
+		{models.PowerStateHibernating, true, time.Minute, models.PowerStateHibernating},
+		{models.PowerStateHibernating, true, 6 * time.Minute, models.PowerStateOn},
+		{models.PowerStateRestarting, true, time.Minute, models.PowerStateRestarting},
+		{models.PowerStateRestarting, false, time.Minute, models.PowerStateRestarting},
+		{models.PowerStateRestarting, true, 11 * time.Minute, models.PowerStateOn},
+		{models.PowerStateRestarting, false, 11 * time.Minute, models.PowerStateOff},
+	}
+	for _, test := range tests {
+		online := test.online
+		m.reachable = func(string) bool { return online }
+		m.clearRestartTracking("nas")
+		server := &models.Server{ID: "nas", Name: "nas", Hostname: "nas", CurrentState: test.state, LastStateChange: time.Now().Add(-test.since)}
+		if got := m.determineServerState(server); got != test.want {
+			t.Errorf("Expected %s after %s while online=%t to become %s, got %s", test.state, test.since, test.online, test.want, got)
+		}
+	}
+
+	// A restart is only done once the server went offline and came back
+	server := &models.Server{ID: "nas", Name: "nas", Hostname: "nas", CurrentState: models.PowerStateRestarting, LastStateChange: time.Now()}
+	for i, online := range []bool{true, false, true} {
+		online := online
+		m.reachable = func(string) bool { return online }
+		want := models.PowerStateRestarting
+		if i == 2 {
+			want = models.PowerStateOn
+		}
+		if got := m.determineServerState(server); got != want {
+			t.Errorf("Expected step %d of the restart to be %s, got %s", i, want, got)
+		}
+	}
+	if m.restartSeenDown["nas"] {
+		t.Error("Expected the restart tracking to be cleared once the server is back")
+	}
+}
+
+func TestRestartServer(t *testing.T) {
+	m, store := newTestMonitor(t,
+		&models.Server{ID: "pve", Name: "pve", Hostname: "127.0.0.1", CurrentState: models.PowerStateOn, ProxmoxNodeName: "pve",
+			ProxmoxAPIKey: &models.ProxmoxAPIKey{Username: "root", Realm: "pam", TokenID: "ecobox", Secret: "s"}},
+		&models.Server{ID: "vm", Name: "vm", IsProxmoxVM: true, ProxmoxVMID: 101, ParentServerID: "pve", CurrentState: models.PowerStateOn},
+		&models.Server{ID: "nas", Name: "nas", Hostname: "nas", MACAddress: "AA:BB:CC:DD:EE:01", CurrentState: models.PowerStateOn},
+	)
+
+	// Hosts whose system type is unknown cannot be restarted
+	nas, _ := store.GetServer("nas")
+	if _, err := m.RestartServer(nas, false, "alice"); err == nil {
+		t.Error("Expected a restart without the capability to fail")
+	}
+	if _, active := m.operations.Active("nas"); active {
+		t.Error("Expected the failed restart's operation to be finished")
+	}
+	if nas, _ = store.GetServer("nas"); nas.CurrentState != models.PowerStateOn {
+		t.Errorf("Expected a failed restart to keep the state, got %s", nas.CurrentState)
+	}
+
+	// A reset is sent to the Proxmox API, and followed through the restarting
+	// state until the VM is back
+	paths := serveProxmox(t)
+	vm, _ := store.GetServer("vm")
+	op, err := m.RestartServer(vm, true, "alice")
+	if err != nil {
+		t.Fatalf("RestartServer failed: %v", err)
+	}
+	if len(*paths) != 1 || (*paths)[0] != "POST /api2/json/nodes/pve/qemu/101/status/reset" {
+		t.Errorf("Expected a reset of VM 101, got %v", *paths)
+	}
+	if op.Action != models.ActionTypeReset || op.Status != models.OperationStatusRunning {
+		t.Errorf("Expected a running reset operation, got %s %s", op.Action, op.Status)
+	}
+	if vm, _ = store.GetServer("vm"); vm.CurrentState != models.PowerStateRestarting {
+		t.Errorf("Expected the VM to be restarting, got %s", vm.CurrentState)
+	}
+
+	// Another action waits for the one in flight
+	if !m.beginReconcile("vm") {
+		t.Fatal("Expected no reconcile pass in flight")
+	}
+	if _, err := m.RestartServer(vm, false, "bob"); err == nil {
+		t.Error("Expected a restart during another power operation to be refused")
+	}
+	m.endReconcile("vm")
+}
@@ -0,0 +1,142 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/models"
+)
+
+// operationRetention is how long finished operations stay available for polling
+const operationRetention = 1 * time.Hour
+
+// OperationTracker keeps requested power operations and the active one per server
+type OperationTracker struct {
+	operations map[string]*models.Operation
+	active     map[string]string // server ID -> operation ID
+	mu         sync.RWMutex
+}
+
+// NewOperationTracker creates an empty operation tracker
+func NewOperationTracker() *OperationTracker {
+	return &OperationTracker{
+		operations: make(map[string]*models.Operation),
+		active:     make(map[string]string),
+	}
+}
+
+// Create registers a new pending operation for a server. Any operation still
+// active for the same server is marked as superseded and returned.
+func (ot *OperationTracker) Create(serverID string, action models.ActionType, target models.PowerState, requestedBy, reason string) (*models.Operation, *models.Operation) {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+
+	ot.pruneLocked()
+
+	now := time.Now()
+	var superseded *models.Operation
+	if previousID, ok := ot.active[serverID]; ok {
+		if previous, exists := ot.operations[previousID]; exists && !previous.IsFinished() {
+			previous.Status = models.OperationStatusSuperseded
+			previous.Message = "Replaced by a newer request"
+			previous.UpdatedAt = now
+			previous.CompletedAt = &now
+			previousCopy := *previous
+			superseded = &previousCopy
+		}
+	}
+
+	op := &models.Operation{
+		ID:          newOperationID(),
+		ServerID:    serverID,
+		Action:      action,
+		TargetState: target,
+		Status:      models.OperationStatusPending,
+		RequestedBy: requestedBy,
+		Reason:      reason,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	ot.operations[op.ID] = op
+	ot.active[serverID] = op.ID
+
+	opCopy := *op
+	return &opCopy, superseded
+}
+
+// Get returns a copy of an operation by ID
+func (ot *OperationTracker) Get(id string) (*models.Operation, bool) {
+	ot.mu.RLock()
+	defer ot.mu.RUnlock()
+
+	op, exists := ot.operations[id]
+	if !exists {
+		return nil, false
+	}
+	opCopy := *op
+	return &opCopy, true
+}
+
+// Active returns a copy of the unfinished operation for a server, if any
+func (ot *OperationTracker) Active(serverID string) (*models.Operation, bool) {
+	ot.mu.RLock()
+	defer ot.mu.RUnlock()
+
+	op, exists := ot.operations[ot.active[serverID]]
+	if !exists || op.IsFinished() {
+		return nil, false
+	}
+	opCopy := *op
+	return &opCopy, true
+}
+
+// Update changes the status of an unfinished operation and returns the updated copy.
+// Finished operations are left untouched and nil is returned.
+func (ot *OperationTracker) Update(id string, status models.OperationStatus, message string, attempted bool) *models.Operation {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+
+	op, exists := ot.operations[id]
+	if !exists || op.IsFinished() {
+		return nil
+	}
+
+	now := time.Now()
+	op.Status = status
+	op.Message = message
+	op.UpdatedAt = now
+	if attempted {
+		op.Attempts++
+	}
+	if op.IsFinished() {
+		op.CompletedAt = &now
+		if ot.active[op.ServerID] == op.ID {
+			delete(ot.active, op.ServerID)
+		}
+	}
+
+	opCopy := *op
+	return &opCopy
+}
+
+// pruneLocked drops finished operations older than the retention period (requires lock to be held)
+func (ot *OperationTracker) pruneLocked() {
+	cutoff := time.Now().Add(-operationRetention)
+	for id, op := range ot.operations {
+		if op.IsFinished() && op.CompletedAt != nil && op.CompletedAt.Before(cutoff) {
+			delete(ot.operations, id)
+		}
+	}
+}
+
+// newOperationID returns a random operation identifier
+func newOperationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms; fall back to the clock just in case
+		return "op_" + time.Now().Format("20060102150405.000000000")
+	}
+	return "op_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"testing"
+
+	"ecobox-server/internal/models"
+)
+
+func TestOperationTrackerSupersede(t *testing.T) {
+	tracker := NewOperationTracker()
+
+	first, superseded := tracker.Create("server1", models.ActionTypeReconcile, models.PowerStateOn, "alice", "backup")
+	if superseded != nil {
+		t.Fatalf("Expected no superseded operation, got %s", superseded.ID)
+	}
+	if first.Status != models.OperationStatusPending {
+		t.Errorf("Expected pending status, got %s", first.Status)
+	}
+
+	second, superseded := tracker.Create("server1", models.ActionTypeReconcile, models.PowerStateSuspended, "bob", "")
+	if superseded == nil || superseded.ID != first.ID {
+		t.Fatalf("Expected first operation to be superseded")
+	}
+	if superseded.Status != models.OperationStatusSuperseded || superseded.CompletedAt == nil {
+		t.Errorf("Expected superseded operation to be finished, got %s", superseded.Status)
+	}
+
+	active, ok := tracker.Active("server1")
+	if !ok || active.ID != second.ID {
+		t.Fatalf("Expected second operation to be active")
+	}
+
+	// Finished operations are no longer active and can't be changed again
+	if updated := tracker.Update(second.ID, models.OperationStatusSucceeded, "done", false); updated == nil {
+		t.Fatal("Expected update of active operation to succeed")
+	}
+	if _, ok := tracker.Active("server1"); ok {
+		t.Error("Expected no active operation after completion")
+	}
+	if updated := tracker.Update(second.ID, models.OperationStatusFailed, "late", true); updated != nil {
+		t.Error("Expected finished operation to be left untouched")
+	}
+
+	op, ok := tracker.Get(second.ID)
+	if !ok || op.Status != models.OperationStatusSucceeded {
+		t.Errorf("Expected finished operation to remain available for polling")
+	}
+}
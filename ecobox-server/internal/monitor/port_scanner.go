@@ -39,25 +39,6 @@ func (ps *PortScanner) ScanPort(hostname string, port int, timeout time.Duration
 	return true
 }
 
-// ScanServices updates status for each service based on port availability
-func (ps *PortScanner) ScanServices(hostname string, services []models.Service) []models.Service {
-	updatedServices := make([]models.Service, len(services))
-	timeout := 3 * time.Second
-
-	for i, service := range services {
-		updatedServices[i] = service
-		updatedServices[i].LastCheck = time.Now()
-
-		if ps.ScanPort(hostname, service.Port, timeout) {
-			updatedServices[i].Status = models.ServiceStatusUp
-		} else {
-			updatedServices[i].Status = models.ServiceStatusDown
-		}
-	}
-
-	return updatedServices
-}
-
 // CommonHomelabPorts contains a comprehensive list of ports commonly found in homelab environments
 var CommonHomelabPorts = []PortInfo{
 	// Remote Access & Shell
@@ -227,22 +208,18 @@ func (ps *PortScanner) ComprehensiveScan(hostname string, serverID string) []mod
 	return discoveredServices
 }
 
-// ScanServicesWithDiscovery scans configured services and discovers additional ones
-func (ps *PortScanner) ScanServicesWithDiscovery(hostname string, serverID string, configuredServices []models.Service) []models.Service {
+// AddDiscoveredServices scans for services beyond the already checked configured ones
+// and appends those found on new ports
+func (ps *PortScanner) AddDiscoveredServices(hostname string, serverID string, configuredServices []models.Service) []models.Service {
 	ps.logger.WithFields(logrus.Fields{
 		"hostname": hostname,
 		"server_id": serverID,
 		"configured_service_count": len(configuredServices),
-	}).Debug("Starting service scan with discovery")
+	}).Debug("Starting service discovery")
 	
-	var allServices []models.Service
+	allServices := append([]models.Service(nil), configuredServices...)
 	configuredPorts := make(map[int]bool)
 	
-	// First, scan and update configured services
-	updatedConfigured := ps.ScanServices(hostname, configuredServices)
-	allServices = append(allServices, updatedConfigured...)
-	ps.logger.WithField("configured_services_scanned", len(updatedConfigured)).Debug("Configured services scan completed")
-	
 	// Track which ports are already configured
 	for _, service := range configuredServices {
 		configuredPorts[service.Port] = true
@@ -263,7 +240,7 @@ func (ps *PortScanner) ScanServicesWithDiscovery(hostname string, serverID strin
 	
 	ps.logger.WithFields(logrus.Fields{
 		"total_services": len(allServices),
-		"configured_services": len(updatedConfigured),
+		"configured_services": len(configuredServices),
 		"new_discovered_services": addedCount,
 	}).Debug("Service discovery completed")
 	
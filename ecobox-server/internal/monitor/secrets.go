@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"errors"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/secrets"
+)
+
+// SetSecrets sets the vault SSH keys and Proxmox API tokens are kept in.
+// Proxmox hosts load their token again when its secret changes.
+func (m *Monitor) SetSecrets(vault *secrets.Vault) {
+	m.secrets = vault
+	m.sshClient.SetSecrets(vault)
+	m.systemMonitor.SetSecrets(vault)
+	m.initManager.SetSecrets(vault)
+	vault.AddListener(m.handleSecretChange)
+}
+
+// ProxmoxKeySecret names the secret a Proxmox API token created for a host is
+// saved as. Deleting it has a new token created.
+func ProxmoxKeySecret(serverID string) string {
+	return "proxmox-" + serverID
+}
+
+// loadProxmoxAPIKey returns the API token of a Proxmox host from the vault:
+// the configured proxmox_token, or the token created for the host earlier. It
+// returns nil when a token has to be created.
+func (m *Monitor) loadProxmoxAPIKey(server *models.Server) (*models.ProxmoxAPIKey, error) {
+	if server.ProxmoxTokenRef != "" {
+		token, err := m.secrets.Resolve(server.ProxmoxTokenRef)
+		if err != nil {
+			return nil, err
+		}
+		return models.ParseProxmoxAPIToken(token)
+	}
+	if m.secrets == nil {
+		return nil, nil
+	}
+
+	token, err := m.secrets.Get(ProxmoxKeySecret(server.ID))
+	if errors.Is(err, secrets.ErrSecretNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return models.ParseProxmoxAPIToken(token)
+}
+
+// saveProxmoxAPIKey keeps a token created for a Proxmox host in the vault, so
+// it is not created again on every start
+func (m *Monitor) saveProxmoxAPIKey(server *models.Server, apiKey *models.ProxmoxAPIKey) {
+	if m.secrets == nil {
+		return
+	}
+	saved := *server
+	saved.ProxmoxAPIKey = apiKey
+	if _, err := m.secrets.Set(ProxmoxKeySecret(server.ID), saved.GetProxmoxAPIToken()); err != nil {
+		m.logger.WithField("server", server.Name).WithError(err).Warn("Failed to save Proxmox API key in the secret vault")
+	}
+}
+
+// handleSecretChange forgets the API token of the Proxmox hosts that use a
+// changed secret, so the next discovery pass loads it again
+func (m *Monitor) handleSecretChange(name string) {
+	for _, server := range m.storage.GetAllServers() {
+		if server.ProxmoxAPIKey == nil {
+			continue
+		}
+		if server.ProxmoxTokenRef == config.SecretRef(name) || (server.ProxmoxTokenRef == "" && ProxmoxKeySecret(server.ID) == name) {
+			if err := m.storage.SetProxmoxAPIKey(server.ID, server.ProxmoxTokenRef, nil); err != nil {
+				m.logger.WithField("server", server.Name).WithError(err).Error("Failed to reset Proxmox API key")
+				continue
+			}
+			m.logger.WithField("server", server.Name).Infof("Secret %s changed, reloading the Proxmox API key", name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"ecobox-server/internal/metrics"
+	"ecobox-server/internal/models"
+)
+
+// ErrServiceNotFound is returned when a server has no service with the requested name
+var ErrServiceNotFound = errors.New("service not found")
+
+// scanServices runs the health checks of a server's services and, when discover is
+// set, adds services found on other common ports
+func (m *Monitor) scanServices(server *models.Server, discover bool) []models.Service {
+	services := m.healthChecker.CheckServices(server, server.Services)
+	if discover {
+		services = m.portScanner.AddDiscoveredServices(server.Hostname, server.ID, services)
+	}
+	return services
+}
+
+// recordServiceMetrics records the response time of each service that is up and
+// the days left on certificates seen by HTTPS checks
+func (m *Monitor) recordServiceMetrics(serverID string, services []models.Service) {
+	for _, service := range services {
+		if service.Status == models.ServiceStatusUp {
+			m.recordMetric(serverID, metrics.ServiceMetric(metrics.StandardMetrics.ServiceResponseTime, service.Port), service.ResponseTime)
+		}
+		if service.CertExpiresAt != nil {
+			daysLeft := time.Until(*service.CertExpiresAt).Hours() / 24
+			m.recordMetric(serverID, metrics.ServiceMetric(metrics.StandardMetrics.ServiceCertDaysLeft, service.Port), daysLeft)
+		}
+	}
+}
+
+// CheckService runs the health check of a service now, stores the result and
+// publishes it
+func (m *Monitor) CheckService(serverID, name string) (*models.Service, error) {
+	server, err := m.storage.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range server.Services {
+		if service.Name != name {
+			continue
+		}
+
+		checked := m.healthChecker.CheckService(server, service)
+		if err := m.storage.UpdateServiceResult(serverID, checked); err != nil {
+			return nil, err
+		}
+		m.recordServiceMetrics(serverID, []models.Service{checked})
+		m.publishServer(serverID)
+		return &checked, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s on %s", ErrServiceNotFound, name, server.Name)
+}
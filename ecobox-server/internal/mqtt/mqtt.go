@@ -0,0 +1,331 @@
+// Package mqtt is a small MQTT 3.1.1 client covering what the dashboard needs:
+// connecting with credentials and a last will over TCP or TLS, publishing at
+// QoS 0 and receiving messages from QoS 0 subscriptions.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Packet types, shifted into the upper nibble of the fixed header
+const (
+	packetConnect    = 1 << 4
+	packetConnAck    = 2 << 4
+	packetPublish    = 3 << 4
+	packetPubAck     = 4 << 4
+	packetSubscribe  = 8 << 4
+	packetSubAck     = 9 << 4
+	packetPingReq    = 12 << 4
+	packetPingResp   = 13 << 4
+	packetDisconnect = 14 << 4
+)
+
+// connectReturnCodes explains the CONNACK refusal codes
+var connectReturnCodes = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Options holds the connection settings
+type Options struct {
+	Broker    string // tcp://host:1883 or tls://host:8883 (mqtt:// and mqtts:// also work)
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration // Sent to the broker; 0 disables keep-alive
+	Timeout   time.Duration // For connecting and each write (default: 10s)
+
+	// Last will, published by the broker when the connection is lost
+	WillTopic   string
+	WillPayload []byte
+	WillRetain  bool
+}
+
+// Handler receives the messages of subscribed topics
+type Handler func(topic string, payload []byte)
+
+// Client is a connection to a broker
+type Client struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	timeout   time.Duration
+	keepAlive time.Duration
+	packetID  uint16
+	mu        sync.Mutex
+}
+
+// Dial connects to the broker and completes the MQTT handshake
+func Dial(opts Options) (*Client, error) {
+	u, err := url.Parse(opts.Broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid broker address '%s'", opts.Broker)
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u, "1883"))
+	case "tls", "ssl", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "8883"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme '%s'", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker: %w", err)
+	}
+
+	c := &Client{conn: conn, reader: bufio.NewReader(conn), timeout: timeout, keepAlive: opts.KeepAlive}
+	if err := c.connect(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect sends CONNECT and waits for the broker to accept it
+func (c *Client) connect(opts Options) error {
+	var flags byte = 0x02 // Clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if opts.WillTopic != "" {
+		flags |= 0x04 // Will at QoS 0
+		if opts.WillRetain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, opts.WillTopic)
+		payload = appendString(payload, string(opts.WillPayload))
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+		if opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, opts.Password)
+		}
+	}
+
+	keepAlive := uint16(opts.KeepAlive / time.Second)
+	header := appendString(nil, "MQTT")
+	header = append(header, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+
+	if err := c.writePacket(packetConnect, append(header, payload...)); err != nil {
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	packetType, body, err := c.readPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+	if packetType != packetConnAck || len(body) != 2 {
+		return errors.New("broker did not answer with CONNACK")
+	}
+	if code := body[1]; code != 0 {
+		reason, known := connectReturnCodes[code]
+		if !known {
+			reason = fmt.Sprintf("return code %d", code)
+		}
+		return fmt.Errorf("broker refused connection: %s", reason)
+	}
+	return nil
+}
+
+// Publish sends a message at QoS 0
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	var packetType byte = packetPublish
+	if retain {
+		packetType |= 0x01
+	}
+	return c.writePacket(packetType, append(appendString(nil, topic), payload...))
+}
+
+// Subscribe asks the broker for the messages of topic filters at QoS 0. They
+// are passed to the handler given to Listen.
+func (c *Client) Subscribe(filters ...string) error {
+	c.mu.Lock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	id := c.packetID
+	c.mu.Unlock()
+
+	body := []byte{byte(id >> 8), byte(id)}
+	for _, filter := range filters {
+		body = append(appendString(body, filter), 0)
+	}
+	return c.writePacket(packetSubscribe|0x02, body)
+}
+
+// Ping sends a keep-alive request. Call it more often than the keep-alive
+// interval while nothing else is sent.
+func (c *Client) Ping() error {
+	return c.writePacket(packetPingReq, nil)
+}
+
+// Listen reads from the broker and passes received messages to the handler
+// until the connection fails or is closed. With a keep-alive, a broker that
+// stays silent for one and a half intervals counts as failed.
+func (c *Client) Listen(handler Handler) error {
+	for {
+		if c.keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		}
+		first, body, err := c.readRawPacket()
+		if err != nil {
+			return err
+		}
+
+		switch first & 0xF0 {
+		case packetPublish:
+			topic, payload, id, qos, err := parsePublish(first, body)
+			if err != nil {
+				return err
+			}
+			if qos == 1 {
+				if err := c.writePacket(packetPubAck, []byte{byte(id >> 8), byte(id)}); err != nil {
+					return err
+				}
+			}
+			handler(topic, payload)
+		case packetSubAck:
+			if len(body) > 2 {
+				for _, code := range body[2:] {
+					if code == 0x80 {
+						return errors.New("broker refused subscription")
+					}
+				}
+			}
+		}
+		// PINGRESP and other packets only show the connection is alive
+	}
+}
+
+// Close disconnects cleanly
+func (c *Client) Close() error {
+	c.writePacket(packetDisconnect, nil)
+	return c.conn.Close()
+}
+
+// writePacket writes a packet with its fixed header
+func (c *Client) writePacket(packetType byte, body []byte) error {
+	packet := append([]byte{packetType}, encodeLength(len(body))...)
+	packet = append(packet, body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// readPacket reads one packet and returns its type, with the flags cleared, and body
+func (c *Client) readPacket() (byte, []byte, error) {
+	first, body, err := c.readRawPacket()
+	return first & 0xF0, body, err
+}
+
+// readRawPacket reads one packet and returns its fixed header byte and body
+func (c *Client) readRawPacket() (byte, []byte, error) {
+	first, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := decodeLength(c.reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return first, body, nil
+}
+
+// parsePublish splits a PUBLISH body into topic, packet identifier (QoS 1 and 2
+// only) and payload
+func parsePublish(first byte, body []byte) (string, []byte, uint16, byte, error) {
+	qos := (first >> 1) & 0x03
+	if len(body) < 2 {
+		return "", nil, 0, 0, errors.New("malformed PUBLISH")
+	}
+	topicLength := int(body[0])<<8 | int(body[1])
+	rest := body[2:]
+	if len(rest) < topicLength {
+		return "", nil, 0, 0, errors.New("malformed PUBLISH")
+	}
+	topic, rest := string(rest[:topicLength]), rest[topicLength:]
+
+	var id uint16
+	if qos > 0 {
+		if len(rest) < 2 {
+			return "", nil, 0, 0, errors.New("malformed PUBLISH")
+		}
+		id, rest = uint16(rest[0])<<8|uint16(rest[1]), rest[2:]
+	}
+	return topic, rest, id, qos, nil
+}
+
+// encodeLength encodes the remaining length as a variable byte integer
+func encodeLength(length int) []byte {
+	var encoded []byte
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		encoded = append(encoded, digit)
+		if length == 0 {
+			return encoded
+		}
+	}
+}
+
+// decodeLength reads a variable byte integer
+func decodeLength(r io.ByteReader) (int, error) {
+	length, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length += int(digit&0x7F) * multiplier
+		if digit&0x80 == 0 {
+			return length, nil
+		}
+		multiplier *= 128
+	}
+	return 0, errors.New("malformed remaining length")
+}
+
+// appendString appends a length-prefixed UTF-8 string
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// hostPort adds the default port when the URL has none
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
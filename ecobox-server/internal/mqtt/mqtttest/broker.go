@@ -0,0 +1,139 @@
+// Package mqtttest provides a stand-in MQTT broker for tests of the components
+// that publish to one.
+package mqtttest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Message is a PUBLISH seen by the broker
+type Message struct {
+	Topic   string
+	Payload string
+	Retain  bool
+}
+
+// Broker is a stand-in MQTT broker for a single client. It records what the
+// client connects with, publishes and subscribes to, and can send it messages.
+type Broker struct {
+	listener   net.Listener
+	Conn       chan net.Conn // The client's connection, once it has connected
+	Connect    chan []byte   // Body of the client's CONNECT packet
+	Published  chan Message
+	Subscribed chan string
+}
+
+// NewBroker starts a broker on a local port. It is closed when the test ends.
+func NewBroker(t testing.TB) *Broker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	b := &Broker{
+		listener:   listener,
+		Conn:       make(chan net.Conn, 1),
+		Connect:    make(chan []byte, 1),
+		Published:  make(chan Message, 100),
+		Subscribed: make(chan string, 10),
+	}
+	go b.serve()
+	return b
+}
+
+// URL returns the address clients connect to
+func (b *Broker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *Broker) serve() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	header, connect := readPacket(reader)
+	if header>>4 != 1 {
+		return
+	}
+	b.Connect <- connect
+	conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+	b.Conn <- conn
+
+	for {
+		header, body := readPacket(reader)
+		switch header >> 4 {
+		case 3: // PUBLISH
+			if len(body) < 2 {
+				return
+			}
+			topicLength := int(body[0])<<8 | int(body[1])
+			b.Published <- Message{
+				Topic:   string(body[2 : 2+topicLength]),
+				Payload: string(body[2+topicLength:]),
+				Retain:  header&0x01 != 0,
+			}
+		case 8: // SUBSCRIBE
+			topicLength := int(body[2])<<8 | int(body[3])
+			b.Subscribed <- string(body[4 : 4+topicLength])
+			conn.Write([]byte{0x90, 0x03, body[0], body[1], 0x00})
+		case 12: // PINGREQ
+			conn.Write([]byte{0xD0, 0x00})
+		case 14, 0: // DISCONNECT or closed
+			return
+		}
+	}
+}
+
+// Send publishes a message to the client at QoS 0
+func (b *Broker) Send(conn net.Conn, topic, payload string) {
+	body := append([]byte{byte(len(topic) >> 8), byte(len(topic))}, topic...)
+	body = append(body, payload...)
+	conn.Write(append([]byte{0x30, byte(len(body))}, body...))
+}
+
+// Collect gathers published messages by topic until the topic is seen
+func (b *Broker) Collect(t testing.TB, messages map[string]Message, topic string) Message {
+	t.Helper()
+	for {
+		if m, ok := messages[topic]; ok {
+			return m
+		}
+		select {
+		case m := <-b.Published:
+			messages[m.Topic] = m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a publish to %s", topic)
+		}
+	}
+}
+
+// readPacket reads an MQTT packet, returning a zero header once the connection closes
+func readPacket(reader *bufio.Reader) (byte, []byte) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil
+	}
+	length := 0
+	for multiplier := 1; ; multiplier *= 128 {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil
+		}
+		length += int(digit&0x7F) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	io.ReadFull(reader, body)
+	return header, body
+}
@@ -190,11 +190,10 @@ func (c *Client) doRequest(method, path string, body interface{}) ([]byte, error
 	// Set authorization header for API token
 	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s", c.APIToken))
 	
-	// Debug logging for API token
+	// Debug logging of node requests, without the token
 	if strings.Contains(path, "/nodes") {
 		logrus.WithFields(logrus.Fields{
 			"path":       path,
-			"api_token":  c.APIToken,
 			"base_url":   c.BaseURL,
 			"node":       c.Node,
 		}).Debug("Making Proxmox API request")
@@ -462,6 +461,30 @@ func (c *Client) PauseVM(vmid int) (string, error) {
 	return response.Data, nil // Returns UPID (task ID)
 }
 
+// HibernateVM suspends a VM to disk (equivalent to "qm suspend --todisk").
+// The VM state is written to storage and the VM is stopped, freeing its RAM.
+// Starting the VM again with StartVM resumes from the saved state.
+func (c *Client) HibernateVM(vmid int) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/status/suspend", c.Node, vmid)
+
+	data := url.Values{}
+	data.Set("todisk", "1")
+
+	respBody, err := c.doRequest("POST", path, data)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return response.Data, nil // Returns UPID (task ID)
+}
+
 // ResumeVM resumes a paused/suspended VM
 func (c *Client) ResumeVM(vmid int) (string, error) {
 	path := fmt.Sprintf("/nodes/%s/qemu/%d/status/resume", c.Node, vmid)
@@ -481,6 +504,89 @@ func (c *Client) ResumeVM(vmid int) (string, error) {
 	return response.Data, nil // Returns UPID (task ID)
 }
 
+// Snapshot represents a VM snapshot
+type Snapshot struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parent      string      `json:"parent,omitempty"`
+	SnapTime    int64       `json:"snaptime,omitempty"`
+	VMState     ProxmoxBool `json:"vmstate,omitempty"`
+}
+
+// CreateSnapshot creates a snapshot of a VM, optionally including its RAM state
+func (c *Client) CreateSnapshot(vmid int, name string, description string, includeVMState bool) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/snapshot", c.Node, vmid)
+
+	data := url.Values{}
+	data.Set("snapname", name)
+	if description != "" {
+		data.Set("description", description)
+	}
+	if includeVMState {
+		data.Set("vmstate", "1")
+	}
+
+	respBody, err := c.doRequest("POST", path, data)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return response.Data, nil // Returns UPID (task ID)
+}
+
+// ListSnapshots returns all snapshots of a VM (excluding the "current" pseudo-snapshot)
+func (c *Client) ListSnapshots(vmid int) ([]Snapshot, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/snapshot", c.Node, vmid)
+
+	respBody, err := c.doRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []Snapshot `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	snapshots := make([]Snapshot, 0, len(response.Data))
+	for _, snap := range response.Data {
+		if snap.Name == "current" {
+			continue
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	return snapshots, nil
+}
+
+// DeleteSnapshot deletes a VM snapshot
+func (c *Client) DeleteSnapshot(vmid int, name string) (string, error) {
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/snapshot/%s", c.Node, vmid, url.PathEscape(name))
+
+	respBody, err := c.doRequest("DELETE", path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return response.Data, nil // Returns UPID (task ID)
+}
+
 // RRDData represents time-series data from Proxmox RRD
 type RRDData struct {
 	Time      float64 `json:"time"`
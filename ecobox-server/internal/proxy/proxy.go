@@ -0,0 +1,541 @@
+// Package proxy fronts configured services on dashboard ports and wakes their
+// server when someone connects, so clients see a slow connection instead of a timeout.
+package proxy
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/metrics"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// scanInterval is how often a waking service is probed
+	scanInterval = 2 * time.Second
+	// dialTimeout bounds connection attempts to a service that should be up
+	dialTimeout = 5 * time.Second
+	// readHeaderTimeout bounds how long HTTP-mode clients may take to send headers
+	readHeaderTimeout = 10 * time.Second
+	// httpIdleTimeout closes idle keep-alive connections of HTTP-mode clients, which
+	// would otherwise hold the server on
+	httpIdleTimeout = 60 * time.Second
+)
+
+// Manager runs a listener for every service with a proxy port. The first
+// connection takes a keep-awake lease on the server, so the reconciler wakes it
+// through PowerManager.WakeServer and keeps it on until the proxy has been idle
+// for the configured time. Sync brings the listeners in line with services
+// added, changed or removed at runtime.
+type Manager struct {
+	config      *config.Config
+	storage     storage.Storage
+	monitor     *monitor.Monitor
+	portScanner *monitor.PortScanner
+	logger      *logrus.Logger
+
+	wakeTimeout time.Duration
+	idleTimeout time.Duration
+
+	routes   map[string]*route // By server and service ID
+	mu       sync.Mutex        // Guards routes
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// route is a single proxied service
+type route struct {
+	serverID string
+	service  models.Service
+
+	listener   net.Listener  // TCP mode
+	httpServer *http.Server  // HTTP mode
+	closed     chan struct{} // Closed when the route is removed
+
+	mu        sync.Mutex
+	active    int          // Open client connections
+	lastLease time.Time    // Last time the keep-awake lease was renewed
+	ready     bool         // Service answered recently (HTTP mode)
+	wake      *wakeAttempt // In-progress wait for the service to come up
+}
+
+// wakeAttempt is shared by all connections waiting for the same service
+type wakeAttempt struct {
+	done chan struct{}
+	ok   bool
+}
+
+// NewManager creates a proxy manager for the services in storage
+func NewManager(cfg *config.Config, storage storage.Storage, monitor *monitor.Monitor) *Manager {
+	return &Manager{
+		config:      cfg,
+		storage:     storage,
+		monitor:     monitor,
+		portScanner: monitor.GetPortScanner(),
+		logger:      logrus.New(),
+		wakeTimeout: time.Duration(cfg.Dashboard.ProxyWakeTimeout) * time.Second,
+		idleTimeout: time.Duration(cfg.Dashboard.ProxyIdleTimeout) * time.Second,
+		routes:      make(map[string]*route),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// SetLogger sets a custom logger
+func (m *Manager) SetLogger(logger *logrus.Logger) {
+	m.logger = logger
+}
+
+// Start opens a listener for every service that has a proxy port
+func (m *Manager) Start() {
+	m.Sync()
+	go m.leaseRenewalLoop()
+}
+
+// Sync opens listeners for services that gained a proxy port and closes those of
+// services that lost it or were removed. A service whose proxy settings changed
+// gets a new listener. A listener that fails to open is logged and skipped so the
+// dashboard keeps running; the next change tries it again.
+func (m *Manager) Sync() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.stopChan:
+		return
+	default:
+	}
+
+	servers := m.storage.GetAllServers()
+	wanted := make(map[string]*route)
+	for _, server := range servers {
+		for _, service := range server.Services {
+			if service.ProxyPort != 0 {
+				wanted[server.ID+"/"+service.ID] = &route{serverID: server.ID, service: service}
+			}
+		}
+	}
+
+	// Close removed and changed routes first, so a port can move to another service
+	for key, rt := range m.routes {
+		if next, ok := wanted[key]; ok && sameProxy(rt.service, next.service) {
+			continue
+		}
+		rt.close()
+		delete(m.routes, key)
+		m.logger.Infof("Stopped proxy on port %d for %s", rt.service.ProxyPort, rt.service.Name)
+	}
+
+	for key, rt := range wanted {
+		if _, exists := m.routes[key]; exists {
+			continue
+		}
+		server := servers[rt.serverID]
+		if err := m.listen(rt); err != nil {
+			m.logger.Errorf("Failed to start proxy for %s/%s on port %d: %v", server.Name, rt.service.Name, rt.service.ProxyPort, err)
+			continue
+		}
+		m.routes[key] = rt
+		m.logger.Infof("Proxying port %d to %s:%d (%s, %s mode)", rt.service.ProxyPort, server.Hostname, rt.service.Port, rt.service.Name, rt.service.ProxyMode)
+	}
+}
+
+// sameProxy reports whether a running route can keep serving a service
+func sameProxy(running, next models.Service) bool {
+	return running.Name == next.Name && running.Port == next.Port &&
+		running.ProxyPort == next.ProxyPort && running.ProxyMode == next.ProxyMode
+}
+
+// Stop closes all listeners. Established TCP connections are left to finish.
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, rt := range m.routes {
+			rt.close()
+		}
+	})
+}
+
+// activeRoutes returns the running routes
+func (m *Manager) activeRoutes() []*route {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	routes := make([]*route, 0, len(m.routes))
+	for _, rt := range m.routes {
+		routes = append(routes, rt)
+	}
+	return routes
+}
+
+// listen opens the proxy port of a route and starts serving it
+func (m *Manager) listen(rt *route) error {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(rt.service.ProxyPort))
+	if err != nil {
+		return err
+	}
+	rt.closed = make(chan struct{})
+
+	if rt.service.ProxyMode == models.ProxyModeHTTP {
+		rt.httpServer = &http.Server{
+			Handler:           m.httpHandler(rt),
+			ConnState:         m.trackHTTPConn(rt),
+			ReadHeaderTimeout: readHeaderTimeout,
+			IdleTimeout:       httpIdleTimeout,
+		}
+		go func() {
+			if err := rt.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				m.logger.Errorf("Proxy for %s stopped: %v", rt.service.Name, err)
+			}
+		}()
+		return nil
+	}
+
+	rt.listener = listener
+	go m.serveTCP(rt, listener)
+	return nil
+}
+
+// serveTCP accepts connections for a TCP-mode route
+func (m *Manager) serveTCP(rt *route, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-rt.closed:
+				return
+			default:
+			}
+			m.logger.Errorf("Proxy for %s failed to accept connection: %v", rt.service.Name, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go m.handleTCP(rt, conn)
+	}
+}
+
+// handleTCP holds a client connection until the service is up, then pipes it through
+func (m *Manager) handleTCP(rt *route, client net.Conn) {
+	defer client.Close()
+	m.connectionOpened(rt)
+	defer m.connectionClosed(rt)
+
+	address, err := m.backendAddress(rt)
+	if err != nil {
+		m.logger.Errorf("Proxy for %s: %v", rt.service.Name, err)
+		return
+	}
+
+	backend, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		m.logger.Debugf("Proxy for %s: service not reachable, holding connection from %s", rt.service.Name, client.RemoteAddr())
+		if !m.awaitService(rt) {
+			return
+		}
+		if backend, err = net.DialTimeout("tcp", address, dialTimeout); err != nil {
+			m.logger.Warnf("Proxy for %s: failed to connect after wake: %v", rt.service.Name, err)
+			return
+		}
+	}
+	defer backend.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(backend, client)
+		closeWrite(backend)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, backend)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// closeWrite half-closes a TCP connection so the peer sees EOF
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+}
+
+// httpHandler reverse proxies to the service, or serves a "waking up" page while it starts
+func (m *Manager) httpHandler(rt *route) http.Handler {
+	reverseProxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			// The backend address is resolved per request, as the server may change
+			address, _ := m.backendAddress(rt)
+			r.URL.Scheme = "http"
+			r.URL.Host = address
+		},
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         (&net.Dialer{Timeout: dialTimeout}).DialContext,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConnsPerHost: 16,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			m.logger.Debugf("Proxy for %s: backend error: %v", rt.service.Name, err)
+			rt.setReady(false)
+			m.startWake(rt)
+			m.serveWakingPage(w, rt)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rt.isReady() {
+			if rt.isWaking() || !m.serviceUp(rt, time.Second) {
+				m.startWake(rt)
+				m.serveWakingPage(w, rt)
+				return
+			}
+			rt.setReady(true)
+		}
+
+		reverseProxy.ServeHTTP(w, r)
+	})
+}
+
+// trackHTTPConn counts client connections of an HTTP-mode route
+func (m *Manager) trackHTTPConn(rt *route) func(net.Conn, http.ConnState) {
+	return func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			m.connectionOpened(rt)
+		case http.StateClosed, http.StateHijacked:
+			m.connectionClosed(rt)
+		}
+	}
+}
+
+var wakingPage = template.Must(template.New("waking").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta http-equiv="refresh" content="5">
+    <title>Waking up {{.Server}}…</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: #f5f5f5; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+        .card { background: white; padding: 2rem 3rem; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); text-align: center; }
+        h1 { font-size: 1.4rem; color: #333; }
+        p { color: #666; }
+    </style>
+</head>
+<body>
+    <div class="card">
+        <h1>Waking up {{.Server}}…</h1>
+        <p>{{.Service}} will be available shortly. This page reloads automatically.</p>
+    </div>
+</body>
+</html>`))
+
+// serveWakingPage tells the client the service is starting and to retry shortly
+func (m *Manager) serveWakingPage(w http.ResponseWriter, rt *route) {
+	serverName := rt.serverID
+	if server, err := m.storage.GetServer(rt.serverID); err == nil {
+		serverName = server.Name
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	wakingPage.Execute(w, map[string]string{
+		"Server":  serverName,
+		"Service": rt.service.Name,
+	})
+}
+
+// awaitService blocks until the service accepts connections or the wake times out
+func (m *Manager) awaitService(rt *route) bool {
+	wake := m.startWake(rt)
+	select {
+	case <-wake.done:
+		return wake.ok
+	case <-m.stopChan:
+		return false
+	}
+}
+
+// startWake starts waiting for the service to come up, unless a wait is already running
+func (m *Manager) startWake(rt *route) *wakeAttempt {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.wake == nil {
+		rt.wake = &wakeAttempt{done: make(chan struct{})}
+		go m.waitForService(rt, rt.wake)
+	}
+	return rt.wake
+}
+
+// waitForService holds the server on and probes the service until it answers
+func (m *Manager) waitForService(rt *route, wake *wakeAttempt) {
+	defer func() {
+		rt.mu.Lock()
+		rt.wake = nil
+		rt.mu.Unlock()
+		close(wake.done)
+	}()
+
+	start := time.Now()
+	m.logger.Infof("Connection to %s is waiting for the service, waking server %s", rt.service.Name, rt.serverID)
+	m.recordMetric(rt, metrics.StandardMetrics.ProxyWakeTriggered, 1)
+	m.holdServer(rt, true)
+
+	deadline := start.Add(m.wakeTimeout)
+	for time.Now().Before(deadline) {
+		if m.serviceUp(rt, scanInterval) {
+			wake.ok = true
+			rt.setReady(true)
+			m.logger.Infof("Service %s is up after %s", rt.service.Name, time.Since(start).Round(time.Second))
+			m.recordMetric(rt, metrics.StandardMetrics.ProxyWakeDuration, time.Since(start).Seconds())
+			return
+		}
+
+		select {
+		case <-time.After(scanInterval):
+		case <-m.stopChan:
+			return
+		}
+	}
+
+	m.logger.Warnf("Service %s did not come up within %s", rt.service.Name, m.wakeTimeout)
+	m.recordMetric(rt, metrics.StandardMetrics.ProxyWakeTimeout, 1)
+}
+
+// connectionOpened records a new client connection and keeps the server on
+func (m *Manager) connectionOpened(rt *route) {
+	rt.mu.Lock()
+	rt.active++
+	active := rt.active
+	rt.mu.Unlock()
+
+	m.recordMetric(rt, metrics.StandardMetrics.ProxyConnection, 1)
+	m.recordMetric(rt, metrics.StandardMetrics.ProxyActiveConnections, float64(active))
+	m.holdServer(rt, false)
+}
+
+// connectionClosed records a closed client connection
+func (m *Manager) connectionClosed(rt *route) {
+	rt.mu.Lock()
+	rt.active--
+	active := rt.active
+	rt.mu.Unlock()
+
+	m.recordMetric(rt, metrics.StandardMetrics.ProxyActiveConnections, float64(active))
+}
+
+// holdServer takes or renews the proxy's keep-awake lease on the server. Renewals
+// are throttled unless forced, as every connection calls this.
+func (m *Manager) holdServer(rt *route, force bool) {
+	rt.mu.Lock()
+	if !force && time.Since(rt.lastLease) < m.idleTimeout/4 {
+		rt.mu.Unlock()
+		return
+	}
+	rt.lastLease = time.Now()
+	rt.mu.Unlock()
+
+	holder := fmt.Sprintf("proxy:%s", rt.service.Name)
+	reason := fmt.Sprintf("Connections to %s on port %d", rt.service.Name, rt.service.ProxyPort)
+	if _, err := m.monitor.AcquireLease(rt.serverID, holder, reason, m.idleTimeout); err != nil {
+		m.logger.Warnf("Proxy for %s could not hold server %s on: %v", rt.service.Name, rt.serverID, err)
+	}
+}
+
+// leaseRenewalLoop keeps the lease of routes with open connections from running out
+func (m *Manager) leaseRenewalLoop() {
+	ticker := time.NewTicker(m.idleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, rt := range m.activeRoutes() {
+				rt.mu.Lock()
+				active := rt.active
+				rt.mu.Unlock()
+				if active > 0 {
+					m.holdServer(rt, true)
+				}
+			}
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// backendAddress returns the host:port of the proxied service
+func (m *Manager) backendAddress(rt *route) (string, error) {
+	server, err := m.storage.GetServer(rt.serverID)
+	if err != nil {
+		return "", fmt.Errorf("server %s not found", rt.serverID)
+	}
+	return net.JoinHostPort(server.Hostname, strconv.Itoa(rt.service.Port)), nil
+}
+
+// serviceUp checks whether the proxied service accepts connections
+func (m *Manager) serviceUp(rt *route, timeout time.Duration) bool {
+	server, err := m.storage.GetServer(rt.serverID)
+	if err != nil {
+		return false
+	}
+	return m.portScanner.ScanPort(server.Hostname, rt.service.Port, timeout)
+}
+
+// recordMetric records a per-service proxy metric on the server
+func (m *Manager) recordMetric(rt *route, metricName string, value float64) {
+	metricsManager := m.monitor.GetMetricsManager()
+	if metricsManager == nil {
+		return // Metrics manager not available
+	}
+
+	name := metrics.ServiceMetric(metricName, rt.service.Port)
+	if err := metricsManager.Push(rt.serverID, name, value); err != nil {
+		m.logger.Errorf("Failed to record metric %s for server %s: %v", name, rt.serverID, err)
+	}
+}
+
+// close stops serving the route
+func (rt *route) close() {
+	close(rt.closed)
+	if rt.httpServer != nil {
+		rt.httpServer.Close()
+	} else {
+		rt.listener.Close()
+	}
+}
+
+func (rt *route) isReady() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.ready
+}
+
+func (rt *route) setReady(ready bool) {
+	rt.mu.Lock()
+	rt.ready = ready
+	rt.mu.Unlock()
+}
+
+func (rt *route) isWaking() bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.wake != nil
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/storage"
+)
+
+// freePort returns a local TCP port that is currently unused
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// newTestManager starts a proxy for a single service of an online server
+func newTestManager(t *testing.T, service models.Service) *Manager {
+	cfg := &config.Config{}
+	cfg.Dashboard.MetricsDataDir = t.TempDir()
+	cfg.SetDefaults()
+
+	store := storage.NewMemoryStorage()
+	server := &models.Server{
+		ID:           "nas",
+		Name:         "NAS",
+		Hostname:     "127.0.0.1",
+		MACAddress:   "AA:BB:CC:DD:EE:FF",
+		CurrentState: models.PowerStateOn,
+		DesiredState: models.PowerStateOn,
+		Services:     []models.Service{service},
+	}
+	if err := store.AddServer(server); err != nil {
+		t.Fatalf("Failed to add server: %v", err)
+	}
+
+	mon := monitor.NewMonitor(cfg, store, control.NewPowerManager(store))
+	manager := NewManager(cfg, store, mon)
+	manager.wakeTimeout = time.Second
+	manager.Start()
+	t.Cleanup(manager.Stop)
+	return manager
+}
+
+func TestTCPProxyForwardsAndLeases(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start backend: %v", err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	proxyPort := freePort(t)
+	manager := newTestManager(t, models.Service{
+		ID:        "nas-echo",
+		Name:      "echo",
+		Port:      backend.Addr().(*net.TCPAddr).Port,
+		ProxyPort: proxyPort,
+		ProxyMode: models.ProxyModeTCP,
+	})
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, "hello")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatalf("Expected echoed line, got %q (%v)", line, err)
+	}
+
+	server, err := manager.storage.GetServer("nas")
+	if err != nil {
+		t.Fatalf("Failed to get server: %v", err)
+	}
+	if leases := server.ActiveLeases(time.Now()); len(leases) != 1 || leases[0].Holder != "proxy:echo" {
+		t.Errorf("Expected a keep-awake lease held by the proxy, got %+v", leases)
+	}
+}
+
+func TestHTTPProxyServesWakingPage(t *testing.T) {
+	proxyPort := freePort(t)
+	newTestManager(t, models.Service{
+		ID:        "nas-web",
+		Name:      "web",
+		Port:      freePort(t), // Nothing listens here, so the service looks asleep
+		ProxyPort: proxyPort,
+		ProxyMode: models.ProxyModeHTTP,
+	})
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", proxyPort))
+	if err != nil {
+		t.Fatalf("Request to proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while waking, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on waking page")
+	}
+}
+
+func TestSyncFollowsServiceChanges(t *testing.T) {
+	first := models.Service{ID: "nas-web", Name: "web", Port: freePort(t), ProxyPort: freePort(t), ProxyMode: models.ProxyModeTCP}
+	manager := newTestManager(t, first)
+
+	// A service added at runtime gets a listener
+	added := models.Service{ID: "nas-ssh", Name: "ssh", Port: freePort(t), ProxyPort: freePort(t), ProxyMode: models.ProxyModeTCP}
+	if err := manager.storage.SetServerServices("nas", []models.Service{first, added}); err != nil {
+		t.Fatalf("Failed to set services: %v", err)
+	}
+	manager.Sync()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", added.ProxyPort))
+	if err != nil {
+		t.Fatalf("Expected a listener for the added service: %v", err)
+	}
+	conn.Close()
+
+	// Removed services and changed proxy ports close their listeners
+	moved := first
+	moved.ProxyPort = freePort(t)
+	if err := manager.storage.SetServerServices("nas", []models.Service{moved}); err != nil {
+		t.Fatalf("Failed to set services: %v", err)
+	}
+	manager.Sync()
+
+	for _, port := range []int{added.ProxyPort, first.ProxyPort} {
+		if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			conn.Close()
+			t.Errorf("Expected port %d to be closed", port)
+		}
+	}
+	conn, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", moved.ProxyPort))
+	if err != nil {
+		t.Fatalf("Expected a listener on the new proxy port: %v", err)
+	}
+	conn.Close()
+}
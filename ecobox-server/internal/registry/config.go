@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"fmt"
+	"reflect"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+)
+
+// ConfigChanges lists the configured servers changed by a configuration reload
+type ConfigChanges struct {
+	Added   []string `json:"added,omitempty"`
+	Updated []string `json:"updated,omitempty"`
+	Removed []string `json:"removed,omitempty"` // Including VMs discovered on removed Proxmox hosts
+}
+
+// Definitions returns the definitions of the servers added through the API
+func (r *Registry) Definitions() []config.ServerConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]config.ServerConfig(nil), r.servers...)
+}
+
+// ApplyConfig brings the configured servers in storage in line with a newly loaded
+// configuration. The new servers are validated together with the API servers before
+// anything changes, and when a change fails the ones made before it are undone.
+// Servers whose definition did not change are left alone, so their runtime changes
+// such as groups set through the API are kept.
+func (r *Registry) ApplyConfig(cfg *config.Config) (*ConfigChanges, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := make(map[string]config.ServerConfig, len(r.config.Servers))
+	for _, def := range r.config.Servers {
+		previous[def.ID] = def
+	}
+	current := make(map[string]config.ServerConfig, len(cfg.Servers))
+	for _, def := range cfg.Servers {
+		current[def.ID] = def
+	}
+
+	stored := r.storage.GetAllServers()
+	for _, def := range cfg.Servers {
+		if server, exists := stored[def.ID]; exists && server.Source != models.SourceConfig {
+			return nil, fmt.Errorf("server %s is already defined with source %s, remove it there before adding it to the configuration", def.ID, server.Source)
+		}
+	}
+
+	// VMs discovered on a removed host go with it
+	known := make(map[string]bool)
+	var orphans []string
+	for id, server := range stored {
+		if server.Source != models.SourceDiscovered {
+			continue
+		}
+		if hasServer(previous, server.ParentServerID) && !hasServer(current, server.ParentServerID) {
+			orphans = append(orphans, id)
+			continue
+		}
+		known[id] = true
+	}
+
+	candidate := cfg.Copy()
+	candidate.Servers = append(append([]config.ServerConfig(nil), cfg.Servers...), r.servers...)
+	if err := candidate.ValidateWithKnownServers(known); err != nil {
+		return nil, err
+	}
+
+	// Each change records how to restore the server it touched
+	var undo []*models.Server
+	var added []string
+	rollback := func(err error) (*ConfigChanges, error) {
+		for _, id := range added {
+			r.storage.DeleteServer(id)
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			r.storage.DeleteServer(undo[i].ID)
+			r.storage.AddServer(undo[i])
+		}
+		return nil, err
+	}
+
+	changes := &ConfigChanges{}
+	var removed []string
+	for _, def := range r.config.Servers {
+		if !hasServer(current, def.ID) {
+			removed = append(removed, def.ID)
+		}
+	}
+	for _, id := range append(removed, orphans...) {
+		if err := r.storage.DeleteServer(id); err != nil {
+			return rollback(fmt.Errorf("failed to remove server %s: %w", id, err))
+		}
+		undo = append(undo, stored[id])
+		changes.Removed = append(changes.Removed, id)
+	}
+
+	for _, def := range cfg.Servers {
+		old, existed := previous[def.ID]
+		switch {
+		case !existed:
+			if err := r.storage.AddServer(def.ToServer(models.SourceConfig)); err != nil {
+				return rollback(fmt.Errorf("failed to add server %s: %w", def.ID, err))
+			}
+			added = append(added, def.ID)
+			changes.Added = append(changes.Added, def.ID)
+		case !reflect.DeepEqual(old, def):
+			if server, exists := stored[def.ID]; exists {
+				undo = append(undo, server)
+			}
+			if err := r.applyDefinition(def, models.SourceConfig); err != nil {
+				return rollback(fmt.Errorf("failed to update server %s: %w", def.ID, err))
+			}
+			changes.Updated = append(changes.Updated, def.ID)
+		}
+	}
+
+	r.config.Servers = cfg.Servers
+	r.notifyLocked()
+	return changes, nil
+}
+
+// hasServer reports whether a definition with the ID exists
+func hasServer(defs map[string]config.ServerConfig, id string) bool {
+	_, exists := defs[id]
+	return exists
+}
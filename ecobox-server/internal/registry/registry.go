@@ -0,0 +1,415 @@
+// Package registry manages servers added at runtime through the API.
+//
+// Precedence: servers from the configuration file always win. The API cannot
+// create a server with the ID of a configured or discovered server, and cannot
+// change or delete either kind. If a server saved through the API later appears
+// in the configuration file, the saved entry is ignored at startup.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrServerNotFound  = errors.New("server not found")
+	ErrServiceNotFound = errors.New("service not found")
+	ErrServerExists    = errors.New("server already exists")
+	ErrReadOnly        = errors.New("server is not managed through the API")
+	ErrServerInUse     = errors.New("server is still depended on")
+	ErrSaveFailed      = errors.New("failed to save servers")
+	ErrAdminRequired   = errors.New("admin privileges required")
+)
+
+// Registry keeps the definitions of API-sourced servers, validates changes against
+// the whole inventory and saves them to the API servers file
+type Registry struct {
+	config  *config.Config
+	storage storage.Storage
+	path    string
+	servers []config.ServerConfig // API-sourced definitions in creation order
+	mu      sync.Mutex
+	logger  *logrus.Logger
+
+	listeners []func() // Called after changes, see AddChangeListener
+}
+
+// NewRegistry creates a registry saving to the configured API servers file
+func NewRegistry(cfg *config.Config, storage storage.Storage) *Registry {
+	return &Registry{
+		config:  cfg,
+		storage: storage,
+		path:    cfg.Dashboard.APIServersFile,
+		logger:  logrus.New(),
+	}
+}
+
+// SetLogger sets the logger for the registry
+func (r *Registry) SetLogger(logger *logrus.Logger) {
+	r.logger = logger
+}
+
+// AddChangeListener registers a function called after servers or their services
+// change through the API or a configuration reload. It runs with the registry
+// locked, so it must not call the registry.
+func (r *Registry) AddChangeListener(listener func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listeners = append(r.listeners, listener)
+}
+
+// notifyLocked calls the change listeners
+func (r *Registry) notifyLocked() {
+	for _, listener := range r.listeners {
+		listener()
+	}
+}
+
+// Load adds the saved API servers to storage. Configured servers must already be
+// loaded; saved entries that clash with them or fail validation are skipped.
+func (r *Registry) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, err := config.LoadServerFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	for _, def := range saved {
+		if _, err := r.storage.GetServer(def.ID); err == nil {
+			r.logger.Warnf("Ignoring API server %s from %s, the configuration defines a server with the same ID", def.ID, r.path)
+			continue
+		}
+
+		if err := r.validateLocked(append(r.servers, def)); err != nil {
+			r.logger.Errorf("Ignoring API server %s from %s: %v", def.ID, r.path, err)
+			continue
+		}
+
+		if err := r.storage.AddServer(def.ToServer(models.SourceAPI)); err != nil {
+			return fmt.Errorf("failed to add server %s: %w", def.ID, err)
+		}
+		r.servers = append(r.servers, def)
+		r.logger.Infof("Loaded API server: %s (%s)", def.Name, def.Hostname)
+	}
+
+	if len(r.servers) > 0 {
+		r.logger.Infof("Loaded %d servers from %s", len(r.servers), r.path)
+	}
+	return nil
+}
+
+// Get returns the definition of an API-sourced server
+func (r *Registry) Get(id string) (config.ServerConfig, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexLocked(id)
+	if err != nil {
+		return config.ServerConfig{}, err
+	}
+	return r.servers[i], nil
+}
+
+// Create validates and saves a new server, then adds it to storage
+func (r *Registry) Create(def config.ServerConfig) (*models.Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	def.SetDefaults()
+	if _, err := r.storage.GetServer(def.ID); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrServerExists, def.ID)
+	}
+
+	servers := append(append([]config.ServerConfig(nil), r.servers...), def)
+	if err := r.commitLocked(servers); err != nil {
+		return nil, err
+	}
+
+	server := def.ToServer(models.SourceAPI)
+	if err := r.storage.AddServer(server); err != nil {
+		return nil, err
+	}
+
+	r.logger.Infof("Added server %s (%s) through the API", def.Name, def.Hostname)
+	r.notifyLocked()
+	return r.storage.GetServer(def.ID)
+}
+
+// Update replaces the definition of an API-sourced server. Power state, intents,
+// leases and history are kept; the server is initialized again if its connection
+// details changed. Without admin, changes only admins may make are refused with
+// ErrAdminRequired.
+func (r *Registry) Update(id string, def config.ServerConfig, admin bool) (*models.Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.updateLocked(id, def, admin)
+}
+
+// SetService adds a service to an API-sourced server, or replaces the service with
+// the same name. created reports whether the service is new. Without admin,
+// command checks are refused with ErrAdminRequired.
+func (r *Registry) SetService(id string, service config.ServiceConfig, admin bool) (server *models.Server, created bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexLocked(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	def := r.servers[i]
+	def.Services = append([]config.ServiceConfig(nil), def.Services...)
+	created = true
+	for j := range def.Services {
+		if def.Services[j].Name == service.Name {
+			def.Services[j] = service
+			created = false
+		}
+	}
+	if created {
+		def.Services = append(def.Services, service)
+	}
+
+	server, err = r.updateLocked(id, def, admin)
+	return server, created, err
+}
+
+// DeleteService removes a service from an API-sourced server
+func (r *Registry) DeleteService(id, name string) (*models.Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexLocked(id)
+	if err != nil {
+		return nil, err
+	}
+
+	def := r.servers[i]
+	def.Services = nil
+	for _, service := range r.servers[i].Services {
+		if service.Name != name {
+			def.Services = append(def.Services, service)
+		}
+	}
+	if len(def.Services) == len(r.servers[i].Services) {
+		return nil, fmt.Errorf("%w: %s on %s", ErrServiceNotFound, name, id)
+	}
+
+	return r.updateLocked(id, def, true)
+}
+
+// updateLocked replaces the definition of an API-sourced server
+func (r *Registry) updateLocked(id string, def config.ServerConfig, admin bool) (*models.Server, error) {
+	i, err := r.indexLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	if def.ID == "" {
+		def.ID = id
+	}
+	if def.ID != id {
+		return nil, fmt.Errorf("server ID cannot be changed from %s to %s", id, def.ID)
+	}
+	def.SetDefaults()
+	if changes := adminOnlyChanges(r.servers[i], def); !admin && len(changes) > 0 {
+		return nil, fmt.Errorf("%w to change %s", ErrAdminRequired, strings.Join(changes, ", "))
+	}
+
+	servers := append([]config.ServerConfig(nil), r.servers...)
+	servers[i] = def
+	if err := r.commitLocked(servers); err != nil {
+		return nil, err
+	}
+
+	if err := r.applyDefinition(def, models.SourceAPI); err != nil {
+		return nil, err
+	}
+
+	r.logger.Infof("Updated server %s through the API", def.Name)
+	r.notifyLocked()
+	return r.storage.GetServer(id)
+}
+
+// adminOnlyChanges lists the changes to a definition only admins may make: where
+// the dashboard connects over SSH, the Proxmox token it sends there, and
+// command checks, which run on the server
+func adminOnlyChanges(old, def config.ServerConfig) []string {
+	var changes []string
+	if def.Hostname != old.Hostname {
+		changes = append(changes, "hostname")
+	}
+	if def.SSHUser != old.SSHUser {
+		changes = append(changes, "ssh_user")
+	}
+	if def.SSHPort != old.SSHPort {
+		changes = append(changes, "ssh_port")
+	}
+	if def.SSHKeyPath != old.SSHKeyPath {
+		changes = append(changes, "ssh_key_path")
+	}
+	// A reference can name any secret in the vault, which would then be sent
+	// to the host
+	if def.ProxmoxToken != old.ProxmoxToken {
+		changes = append(changes, "proxmox_token")
+	}
+
+	checks := make(map[string]*config.HealthCheckConfig)
+	for _, service := range old.Services {
+		checks[service.Name] = service.Check
+	}
+	for _, service := range def.Services {
+		if isCommandCheck(service.Check) && !reflect.DeepEqual(service.Check, checks[service.Name]) {
+			changes = append(changes, "the command check of "+service.Name)
+		}
+	}
+	return changes
+}
+
+// isCommandCheck reports whether a health check runs a command
+func isCommandCheck(check *config.HealthCheckConfig) bool {
+	return check != nil && models.HealthCheckType(check.Type) == models.HealthCheckCommand
+}
+
+// applyDefinition updates a stored server to a changed definition. Power state,
+// intents, leases and history are kept; the server is initialized again if its
+// connection details changed.
+func (r *Registry) applyDefinition(def config.ServerConfig, source models.Source) error {
+	existing, err := r.storage.GetServer(def.ID)
+	if err != nil {
+		return err
+	}
+	updated := def.ToServer(source)
+
+	if existing.Hostname != updated.Hostname || existing.SSHUser != updated.SSHUser ||
+		existing.SSHPort != updated.SSHPort || existing.SSHKeyPath != updated.SSHKeyPath {
+		existing.Initialized = false
+		existing.InitRetryCount = 0
+	}
+
+	existing.Name = updated.Name
+	existing.Hostname = updated.Hostname
+	existing.MACAddress = updated.MACAddress
+	existing.ParentServerID = updated.ParentServerID
+	existing.Dependencies = updated.Dependencies
+	existing.SSHUser = updated.SSHUser
+	existing.SSHPort = updated.SSHPort
+	existing.SSHKeyPath = updated.SSHKeyPath
+
+	if err := r.storage.UpdateServer(existing); err != nil {
+		return err
+	}
+	// A new reference has the token loaded again
+	if err := r.storage.SetProxmoxTokenRef(def.ID, updated.ProxmoxTokenRef); err != nil {
+		return err
+	}
+	// Services keep their last check result until the next check
+	if err := r.storage.SetServerServices(def.ID, updated.Services); err != nil {
+		return err
+	}
+	return r.storage.SetServerGroups(def.ID, updated.Groups)
+}
+
+// Delete removes an API-sourced server that no other server depends on
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.indexLocked(id)
+	if err != nil {
+		return err
+	}
+
+	var dependents []*models.Server
+	for _, server := range r.storage.GetAllServers() {
+		for _, dep := range server.GetDependencies() {
+			if dep.ServerID == id && server.ID != id {
+				dependents = append(dependents, server)
+				break
+			}
+		}
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("%w by %s", ErrServerInUse, control.ServerNames(dependents))
+	}
+
+	servers := append(append([]config.ServerConfig(nil), r.servers[:i]...), r.servers[i+1:]...)
+	if err := r.commitLocked(servers); err != nil {
+		return err
+	}
+
+	if err := r.storage.DeleteServer(id); err != nil {
+		return err
+	}
+
+	r.logger.Infof("Deleted server %s through the API", id)
+	r.notifyLocked()
+	return nil
+}
+
+// indexLocked finds an API-sourced definition, distinguishing servers that exist
+// but are managed elsewhere
+func (r *Registry) indexLocked(id string) (int, error) {
+	for i, def := range r.servers {
+		if def.ID == id {
+			return i, nil
+		}
+	}
+
+	server, err := r.storage.GetServer(id)
+	if err != nil {
+		return -1, fmt.Errorf("%w: %s", ErrServerNotFound, id)
+	}
+	if server.Source == models.SourceConfig {
+		return -1, fmt.Errorf("%w: %s is defined in the configuration file", ErrReadOnly, id)
+	}
+	return -1, fmt.Errorf("%w: %s was discovered automatically", ErrReadOnly, id)
+}
+
+// commitLocked validates a new set of API definitions and saves it
+func (r *Registry) commitLocked(servers []config.ServerConfig) error {
+	if err := r.validateLocked(servers); err != nil {
+		return err
+	}
+
+	if err := config.SaveServerFile(r.path, servers); err != nil {
+		return fmt.Errorf("%w: %v", ErrSaveFailed, err)
+	}
+	r.servers = servers
+	return nil
+}
+
+// validateLocked runs the configuration checks over the configured servers together
+// with the given API definitions. Discovered servers may be referenced as parents
+// or dependencies.
+func (r *Registry) validateLocked(servers []config.ServerConfig) error {
+	for _, def := range servers {
+		if len(def.VMPolicies) > 0 {
+			return fmt.Errorf("vm_policies for server %s can only be set in the configuration file", def.ID)
+		}
+	}
+
+	candidate := r.config.Copy()
+	candidate.Servers = append(append([]config.ServerConfig(nil), r.config.Servers...), servers...)
+
+	known := make(map[string]bool)
+	for id, server := range r.storage.GetAllServers() {
+		if server.Source == models.SourceDiscovered {
+			known[id] = true
+		}
+	}
+
+	return candidate.ValidateWithKnownServers(known)
+}
@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/storage"
+)
+
+// newTestRegistry creates a registry over one configured server, saving to a temp file
+func newTestRegistry(t *testing.T, path string) (*Registry, storage.Storage) {
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{ID: "nas", Name: "NAS", Hostname: "192.168.1.10", MACAddress: "AA:BB:CC:DD:EE:01"},
+		},
+	}
+	cfg.Dashboard.APIServersFile = path
+	cfg.SetDefaults()
+
+	store := storage.NewMemoryStorage()
+	if err := store.AddServer(cfg.Servers[0].ToServer(models.SourceConfig)); err != nil {
+		t.Fatalf("Failed to add configured server: %v", err)
+	}
+
+	reg := NewRegistry(cfg, store)
+	if err := reg.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return reg, store
+}
+
+func TestRegistryCreateUpdateDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-servers.toml")
+	reg, store := newTestRegistry(t, path)
+	changes := 0
+	reg.AddChangeListener(func() { changes++ })
+
+	app := config.ServerConfig{
+		ID:           "app",
+		Name:         "App",
+		Hostname:     "192.168.1.11",
+		MACAddress:   "AA:BB:CC:DD:EE:02",
+		Dependencies: []config.DependencyConfig{{Server: "nas", Port: 2049}},
+		Groups:       []string{"lab"},
+	}
+	server, err := reg.Create(app)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if server.Source != models.SourceAPI || server.SSHPort != 22 || !server.InGroup("lab") {
+		t.Errorf("Expected an API server with defaults and groups, got %+v", server)
+	}
+
+	// Same checks as the configuration file
+	if _, err := reg.Create(config.ServerConfig{ID: "bad", Name: "Bad", Hostname: "h", MACAddress: "not-a-mac"}); err == nil {
+		t.Error("Expected an invalid MAC address to be rejected")
+	}
+	if _, err := reg.Create(config.ServerConfig{ID: "vm", Name: "VM", Hostname: "h", MACAddress: "AA:BB:CC:DD:EE:03", ParentServerID: "missing"}); err == nil {
+		t.Error("Expected a missing parent to be rejected")
+	}
+	if _, err := reg.Create(config.ServerConfig{ID: "nas", Name: "NAS", Hostname: "h", MACAddress: "AA:BB:CC:DD:EE:04"}); !errors.Is(err, ErrServerExists) {
+		t.Errorf("Expected the configured ID to be taken, got %v", err)
+	}
+
+	// Configured servers win and can't be changed through the API
+	if _, err := reg.Update("nas", config.ServerConfig{Name: "Renamed"}, true); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected configured server to be read-only, got %v", err)
+	}
+
+	app.Name = "Application"
+	if server, err = reg.Update("app", app, false); err != nil || server.Name != "Application" {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// Only admins change where commands run, or what they run
+	moved := app
+	moved.SSHUser = "admin"
+	if _, err := reg.Update("app", moved, false); !errors.Is(err, ErrAdminRequired) {
+		t.Errorf("Expected an SSH user change to need an admin, got %v", err)
+	}
+	leaked := app
+	leaked.ProxmoxToken = "secret:smtp_password"
+	if _, err := reg.Update("app", leaked, false); !errors.Is(err, ErrAdminRequired) {
+		t.Errorf("Expected a Proxmox token change to need an admin, got %v", err)
+	}
+	command := config.ServiceConfig{Name: "disk", Port: 22, Check: &config.HealthCheckConfig{Type: "command", Command: "test -d /srv"}}
+	if _, _, err := reg.SetService("app", command, false); !errors.Is(err, ErrAdminRequired) {
+		t.Errorf("Expected a command check to need an admin, got %v", err)
+	}
+	if changes != 2 {
+		t.Errorf("Expected listeners to hear of the create and the update only, got %d changes", changes)
+	}
+
+	// Saved servers come back after a restart
+	restarted, restartedStore := newTestRegistry(t, path)
+	if saved, err := restartedStore.GetServer("app"); err != nil || saved.Name != "Application" {
+		t.Fatalf("Expected saved server after reload, got %v", err)
+	}
+
+	if err := restarted.Delete("app"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := restartedStore.GetServer("app"); err == nil {
+		t.Error("Expected deleted server to be gone")
+	}
+	if _, err := store.GetServer("app"); err != nil {
+		t.Error("Expected the first registry's storage to be unaffected")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	reg, store := newTestRegistry(t, filepath.Join(t.TempDir(), "api-servers.toml"))
+
+	if _, err := reg.Create(config.ServerConfig{
+		ID: "app", Name: "App", Hostname: "192.168.1.11", MACAddress: "AA:BB:CC:DD:EE:02",
+		Dependencies: []config.DependencyConfig{{Server: "nas"}},
+	}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := store.SetDesiredState("nas", models.PowerStateSuspended, nil); err != nil {
+		t.Fatalf("SetDesiredState failed: %v", err)
+	}
+
+	// Removing a server an API server depends on is rejected without changes
+	rejected := &config.Config{Servers: []config.ServerConfig{
+		{ID: "pve", Name: "Proxmox", Hostname: "192.168.1.20", MACAddress: "AA:BB:CC:DD:EE:05"},
+	}}
+	rejected.SetDefaults()
+	if _, err := reg.ApplyConfig(rejected); err == nil {
+		t.Fatal("Expected removing a depended-on server to be rejected")
+	}
+	if _, err := store.GetServer("pve"); err == nil {
+		t.Error("Expected a rejected configuration to change nothing")
+	}
+
+	next := &config.Config{Servers: []config.ServerConfig{
+		{ID: "nas", Name: "Storage", Hostname: "192.168.1.10", MACAddress: "AA:BB:CC:DD:EE:01"},
+		{ID: "pve", Name: "Proxmox", Hostname: "192.168.1.20", MACAddress: "AA:BB:CC:DD:EE:05"},
+	}}
+	next.SetDefaults()
+	changes, err := reg.ApplyConfig(next)
+	if err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	if len(changes.Added) != 1 || len(changes.Updated) != 1 || len(changes.Removed) != 0 {
+		t.Errorf("Expected one added and one updated server, got %+v", changes)
+	}
+
+	nas, _ := store.GetServer("nas")
+	if nas.Name != "Storage" || nas.DesiredState != models.PowerStateSuspended {
+		t.Errorf("Expected renamed server to keep its desired state, got %s %s", nas.Name, nas.DesiredState)
+	}
+
+	// An unchanged definition leaves runtime changes alone
+	if err := store.SetServerGroups("pve", []string{"lab"}); err != nil {
+		t.Fatalf("SetServerGroups failed: %v", err)
+	}
+	if changes, err = reg.ApplyConfig(next); err != nil || len(changes.Updated) != 0 {
+		t.Fatalf("Expected no changes, got %+v, %v", changes, err)
+	}
+	if pve, _ := store.GetServer("pve"); !pve.InGroup("lab") {
+		t.Error("Expected unchanged server to keep its runtime groups")
+	}
+
+	// A configured server cannot take the ID of an API server
+	clash := &config.Config{Servers: append(append([]config.ServerConfig(nil), next.Servers...),
+		config.ServerConfig{ID: "app", Name: "App", Hostname: "192.168.1.11", MACAddress: "AA:BB:CC:DD:EE:02"})}
+	clash.SetDefaults()
+	if _, err := reg.ApplyConfig(clash); err == nil {
+		t.Error("Expected a clash with an API server to be rejected")
+	}
+}
+
+// failingStorage fails to add the server with an ID
+type failingStorage struct {
+	storage.Storage
+	failAdd string
+}
+
+func (s *failingStorage) AddServer(server *models.Server) error {
+	if server.ID == s.failAdd {
+		return errors.New("storage failure")
+	}
+	return s.Storage.AddServer(server)
+}
+
+func TestApplyConfigIsAllOrNothing(t *testing.T) {
+	reg, store := newTestRegistry(t, filepath.Join(t.TempDir(), "api-servers.toml"))
+
+	first := &config.Config{Servers: []config.ServerConfig{
+		{ID: "nas", Name: "NAS", Hostname: "192.168.1.10", MACAddress: "AA:BB:CC:DD:EE:01"},
+		{ID: "old", Name: "Old", Hostname: "192.168.1.30", MACAddress: "AA:BB:CC:DD:EE:03"},
+	}}
+	first.SetDefaults()
+	if _, err := reg.ApplyConfig(first); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	if err := store.SetDesiredState("old", models.PowerStateSuspended, nil); err != nil {
+		t.Fatalf("SetDesiredState failed: %v", err)
+	}
+
+	// old is removed and nas renamed before adding pve fails
+	reg.storage = &failingStorage{Storage: store, failAdd: "pve"}
+	next := &config.Config{Servers: []config.ServerConfig{
+		{ID: "nas", Name: "Storage", Hostname: "192.168.1.10", MACAddress: "AA:BB:CC:DD:EE:01"},
+		{ID: "app", Name: "App", Hostname: "192.168.1.11", MACAddress: "AA:BB:CC:DD:EE:02"},
+		{ID: "pve", Name: "Proxmox", Hostname: "192.168.1.20", MACAddress: "AA:BB:CC:DD:EE:05"},
+	}}
+	next.SetDefaults()
+	if _, err := reg.ApplyConfig(next); err == nil {
+		t.Fatal("Expected ApplyConfig to fail")
+	}
+
+	if nas, _ := store.GetServer("nas"); nas == nil || nas.Name != "NAS" {
+		t.Errorf("Expected nas to keep its name, got %+v", nas)
+	}
+	if old, err := store.GetServer("old"); err != nil || old.DesiredState != models.PowerStateSuspended {
+		t.Errorf("Expected old to be restored with its desired state, got %+v, %v", old, err)
+	}
+	if _, err := store.GetServer("app"); err == nil {
+		t.Error("Expected the added app server to be removed again")
+	}
+
+	// The next reload starts from the configuration that was kept
+	reg.storage = store
+	changes, err := reg.ApplyConfig(next)
+	if err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	if len(changes.Added) != 2 || len(changes.Updated) != 1 || len(changes.Removed) != 1 {
+		t.Errorf("Expected two added, one updated and one removed server, got %+v", changes)
+	}
+}
@@ -0,0 +1,233 @@
+// Package reload applies changes to the configuration file without a restart.
+//
+// A reload loads and validates the file first and changes nothing if that fails.
+// Configured servers are added, updated or removed in storage; servers whose
+// definition did not change keep all runtime state. Dashboard settings that the
+// running components read on every use are applied, and the loops in the monitor
+// pick up new intervals. Alert rules and notifiers apply to the next observation.
+// Settings such as the web port or file locations only take
+// effect after a restart and are reported as such.
+package reload
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/registry"
+	"github.com/sirupsen/logrus"
+)
+
+// watchInterval is how often a watched configuration file is checked for changes
+const watchInterval = 5 * time.Second
+
+// liveSettings are the dashboard settings, by TOML key, applied without a restart
+var liveSettings = map[string]bool{
+	"update_interval":       true,
+	"wol_retry_interval":    true,
+	"wol_max_retries":       true,
+	"log_level":             true,
+	"system_check_interval": true,
+	"init_check_interval":   true,
+	"vm_discovery_interval": true,
+	"group_concurrency":     true,
+	"watch_config":          true,
+	"discovery_networks":    true,
+	"discovery_interval":    true,
+	"login_max_failures":    true,
+	"login_lockout":         true,
+	"cors_origins":          true,
+	"content_security_policy": true,
+	"secure_cookies":        true,
+}
+
+// Report describes what a reload changed
+type Report struct {
+	registry.ConfigChanges
+	Settings        []string `json:"settings,omitempty"`         // Dashboard settings applied
+	RestartRequired []string `json:"restart_required,omitempty"` // Changed settings that need a restart
+}
+
+// HasChanges reports whether the reload changed anything
+func (r *Report) HasChanges() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed)+len(r.Settings)+len(r.RestartRequired) > 0
+}
+
+// Reloader reloads the configuration file into the running dashboard
+type Reloader struct {
+	path     string
+	config   *config.Config // Running configuration, shared with the other components
+	registry *registry.Registry
+	monitor  *monitor.Monitor
+	modTime  time.Time // Modification time of the file at the last load
+	mu       sync.Mutex
+	logger   *logrus.Logger
+}
+
+// NewReloader creates a reloader for the configuration loaded from path
+func NewReloader(path string, cfg *config.Config, reg *registry.Registry, mon *monitor.Monitor) *Reloader {
+	r := &Reloader{
+		path:     path,
+		config:   cfg,
+		registry: reg,
+		monitor:  mon,
+		logger:   logrus.New(),
+	}
+	if info, err := os.Stat(path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// SetLogger sets the logger for the reloader. Its level follows log_level.
+func (r *Reloader) SetLogger(logger *logrus.Logger) {
+	r.logger = logger
+}
+
+// Reload loads the configuration file and applies the differences to the running
+// configuration. An invalid file is rejected without changing anything.
+func (r *Reloader) Reload() (*Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	loaded, err := config.LoadConfig(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := r.registry.ApplyConfig(loaded)
+	if err != nil {
+		return nil, fmt.Errorf("configuration rejected: %w", err)
+	}
+
+	// Other components read the live settings while they change, so they are
+	// changed on a copy and published together
+	report := &Report{ConfigChanges: *changes}
+	dashboard, alerts := r.config.LiveDashboard(), r.config.LiveAlerts()
+	report.Settings, report.RestartRequired = applySettings(&dashboard, loaded.Dashboard)
+	if !reflect.DeepEqual(alerts, loaded.Alerts) {
+		alerts = loaded.Alerts
+		report.Settings = append(report.Settings, "alerts")
+	}
+	r.config.SetLive(dashboard, alerts)
+	if level, err := logrus.ParseLevel(dashboard.LogLevel); err == nil {
+		r.logger.SetLevel(level)
+	}
+	if !reflect.DeepEqual(r.config.MQTT, loaded.MQTT) {
+		report.RestartRequired = append(report.RestartRequired, "mqtt")
+	}
+	if !reflect.DeepEqual(r.config.OIDC, loaded.OIDC) {
+		report.RestartRequired = append(report.RestartRequired, "oidc")
+	}
+	if !reflect.DeepEqual(r.config.TLS, loaded.TLS) {
+		report.RestartRequired = append(report.RestartRequired, "tls")
+	}
+	if !reflect.DeepEqual(r.config.Secrets, loaded.Secrets) {
+		report.RestartRequired = append(report.RestartRequired, "secrets")
+	}
+
+	if len(report.Settings) > 0 {
+		r.monitor.UpdateIntervals()
+	}
+	if len(report.Added)+len(report.Updated)+len(report.Removed) > 0 {
+		r.monitor.ForceReconcile()
+	}
+
+	r.logger.WithFields(logrus.Fields{
+		"added":            len(report.Added),
+		"updated":          len(report.Updated),
+		"removed":          len(report.Removed),
+		"settings":         strings.Join(report.Settings, ","),
+		"restart_required": strings.Join(report.RestartRequired, ","),
+	}).Infof("Reloaded configuration from %s", r.path)
+	return report, nil
+}
+
+// applySettings copies the changed live dashboard settings into the running
+// settings and returns their keys, along with the changed keys that need a restart
+func applySettings(dashboard *config.DashboardConfig, loaded config.DashboardConfig) (applied, restart []string) {
+	running := reflect.ValueOf(dashboard).Elem()
+	next := reflect.ValueOf(loaded)
+
+	for i := 0; i < running.NumField(); i++ {
+		if reflect.DeepEqual(running.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+
+		key := strings.Split(running.Type().Field(i).Tag.Get("toml"), ",")[0]
+		if !liveSettings[key] {
+			restart = append(restart, key)
+			continue
+		}
+		running.Field(i).Set(next.Field(i))
+		applied = append(applied, key)
+	}
+	return applied, restart
+}
+
+// Watch reloads the configuration whenever the file changes, until stop is closed.
+// Failed reloads are logged and retried on the next change.
+func (r *Reloader) Watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.watching() || !r.changed() {
+				continue
+			}
+			if _, err := r.Reload(); err != nil {
+				r.logger.Errorf("Failed to reload configuration from %s, keeping the running configuration: %v", r.path, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// watching reports whether watch_config is enabled in the running configuration
+func (r *Reloader) watching() bool {
+	return r.config.LiveDashboard().WatchConfig
+}
+
+// changed reports whether the file was modified since it was last loaded
+func (r *Reloader) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !info.ModTime().Equal(r.modTime)
+}
+
+// Export returns the running configuration as TOML. With includeAPIServers the
+// servers added through the API are included as ordinary [[servers]] entries.
+// Credentials are redacted, while secret references are kept.
+func (r *Reloader) Export(includeAPIServers bool) ([]byte, error) {
+	r.mu.Lock()
+	effective := r.config.Copy()
+	r.mu.Unlock()
+
+	if includeAPIServers {
+		effective.Servers = append(append([]config.ServerConfig(nil), effective.Servers...), r.registry.Definitions()...)
+	}
+
+	data, err := effective.Redacted().Encode()
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("# Effective EcoBox configuration exported %s\n\n", time.Now().Format(time.RFC3339))
+	return append([]byte(header), data...), nil
+}
@@ -0,0 +1,148 @@
+package reload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/registry"
+	"ecobox-server/internal/storage"
+)
+
+// configFile is a configuration with one server, formatted with the dashboard
+// settings and alert rules of a test
+const configFile = `[dashboard]
+port = %d
+update_interval = %d
+log_level = "info"
+metrics_data_dir = %q
+api_servers_file = %q
+
+[[servers]]
+id = "nas"
+name = "NAS"
+hostname = "192.168.1.10"
+mac_address = "AA:BB:CC:DD:EE:01"
+%s`
+
+// newTestReloader loads a configuration written to a temporary file and returns a
+// reloader for it, along with a function that rewrites the file
+func newTestReloader(t *testing.T) (*Reloader, *config.Config, storage.Storage, func(port, interval int, extra string)) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	write := func(port, interval int, extra string) {
+		t.Helper()
+		content := fmt.Sprintf(configFile, port, interval, filepath.Join(dir, "metrics"), filepath.Join(dir, "api-servers.toml"), extra)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	write(8080, 30, "")
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	store := storage.NewMemoryStorage()
+	for _, def := range cfg.Servers {
+		if err := store.AddServer(def.ToServer(models.SourceConfig)); err != nil {
+			t.Fatalf("Failed to add server: %v", err)
+		}
+	}
+	reg := registry.NewRegistry(cfg, store)
+	if err := reg.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	mon := monitor.NewMonitor(cfg, store, control.NewPowerManager(store))
+	t.Cleanup(func() {
+		if metrics := mon.GetMetricsManager(); metrics != nil {
+			metrics.Close()
+		}
+	})
+	return NewReloader(path, cfg, reg, mon), cfg, store, write
+}
+
+func TestReloadRejectsInvalidFile(t *testing.T) {
+	r, cfg, store, write := newTestReloader(t)
+
+	// A second server with the same ID fails validation
+	write(8080, 60, `
+[[servers]]
+id = "nas"
+name = "Backup"
+hostname = "192.168.1.11"
+mac_address = "AA:BB:CC:DD:EE:02"
+`)
+	if _, err := r.Reload(); err == nil {
+		t.Fatal("Expected an invalid configuration to be rejected")
+	}
+	if interval := cfg.LiveDashboard().UpdateInterval; interval != 30 {
+		t.Errorf("Expected the running update_interval 30 to be kept, got %d", interval)
+	}
+	if nas, err := store.GetServer("nas"); err != nil || nas.Name != "NAS" {
+		t.Errorf("Expected server nas to be kept unchanged, got %+v, %v", nas, err)
+	}
+}
+
+func TestReloadAppliesLiveSettings(t *testing.T) {
+	r, cfg, _, write := newTestReloader(t)
+
+	write(8080, 60, `
+[[alerts.notifiers]]
+name = "ops"
+type = "webhook"
+url = "https://hooks.example.com/ecobox"
+
+[[alerts.rules]]
+name = "nas-down"
+condition = "service_down"
+notifiers = ["ops"]
+`)
+	report, err := r.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	settings := make(map[string]bool)
+	for _, key := range report.Settings {
+		settings[key] = true
+	}
+	if !settings["update_interval"] || !settings["alerts"] || len(report.Settings) != 2 {
+		t.Errorf("Expected update_interval and alerts to be applied, got %v", report.Settings)
+	}
+	if len(report.RestartRequired) != 0 {
+		t.Errorf("Expected no restart, got %v", report.RestartRequired)
+	}
+	if interval := cfg.LiveDashboard().UpdateInterval; interval != 60 {
+		t.Errorf("Expected update_interval 60, got %d", interval)
+	}
+	if rules := cfg.LiveAlerts().Rules; len(rules) != 1 || rules[0].Name != "nas-down" {
+		t.Errorf("Expected the nas-down alert rule, got %+v", rules)
+	}
+}
+
+func TestReloadReportsRestartSettings(t *testing.T) {
+	r, cfg, _, write := newTestReloader(t)
+
+	write(9090, 30, "")
+	report, err := r.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if len(report.RestartRequired) != 1 || report.RestartRequired[0] != "port" {
+		t.Errorf("Expected port to need a restart, got %v", report.RestartRequired)
+	}
+	if len(report.Settings) != 0 {
+		t.Errorf("Expected no settings applied, got %v", report.Settings)
+	}
+	if port := cfg.LiveDashboard().Port; port != 8080 {
+		t.Errorf("Expected the running port 8080 to be kept, got %d", port)
+	}
+}
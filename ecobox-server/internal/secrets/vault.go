@@ -0,0 +1,518 @@
+// Package secrets keeps credentials in a vault file encrypted at rest.
+//
+// The secrets are encrypted together with AES-256-GCM under a key derived with
+// scrypt from a master key. The master key is read from the environment
+// variable named by key_env or, when that is unset, from key_file, which is
+// created with a random key on first run. Configuration fields that hold
+// credentials refer to secrets as "secret:<name>" and are resolved when they
+// are used, so a secret can be replaced without editing the configuration.
+//
+// Rotating the master key encrypts the vault again under a new key. The new
+// key is written next to key_file before the vault, so a rotation interrupted
+// in between is completed on the next start.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// vaultVersion is the layout of the vault file
+	vaultVersion = 1
+	// minKeyLength is the shortest master key accepted
+	minKeyLength = 16
+	// pendingKeySuffix names the new key file during a rotation
+	pendingKeySuffix = ".new"
+)
+
+// additionalData binds the ciphertext to the vault layout
+var additionalData = []byte("ecobox-vault-v1")
+
+var (
+	// ErrSecretNotFound is returned when no secret has the requested name
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrInvalidSecret is returned for bad names and empty values
+	ErrInvalidSecret = errors.New("invalid secret")
+	// ErrWrongKey is returned when the master key does not decrypt the vault
+	ErrWrongKey = errors.New("master key does not decrypt the vault")
+	// ErrKeyFromEnv is returned when asked to generate a new master key while
+	// the current one comes from the environment, where it cannot be written
+	ErrKeyFromEnv = errors.New("master key comes from the environment")
+)
+
+// Info describes a secret without its value
+type Info struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"` // Incremented whenever the value changes
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// entry is a secret as it is kept in the vault
+type entry struct {
+	Value     string    `json:"value"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// vaultFile is the layout of the vault on disk. Byte fields are base64.
+type vaultFile struct {
+	Version int       `json:"version"`
+	KDF     kdfParams `json:"kdf"`
+	Nonce   []byte    `json:"nonce"`
+	Data    []byte    `json:"data"` // The secrets by name, encrypted
+}
+
+// kdfParams are the scrypt parameters the vault key was derived with
+type kdfParams struct {
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// Listener is called with the name of every secret that is set or deleted
+type Listener func(name string)
+
+// Vault holds the secrets, decrypted in memory
+type Vault struct {
+	config    config.SecretsConfig
+	fromEnv   bool      // The master key comes from key_env
+	kdf       kdfParams // Of the current key
+	key       []byte    // Derived from the master key
+	secrets   map[string]*entry
+	listeners []Listener
+	mu        sync.RWMutex
+	logger    *logrus.Logger
+}
+
+// NewVault creates a vault for the [secrets] configuration
+func NewVault(cfg *config.Config) *Vault {
+	return &Vault{
+		config:  cfg.Secrets,
+		secrets: make(map[string]*entry),
+		logger:  logrus.New(),
+	}
+}
+
+// SetLogger sets a custom logger
+func (v *Vault) SetLogger(logger *logrus.Logger) {
+	v.logger = logger
+}
+
+// Open reads the master key and decrypts the vault. Without a vault file the
+// vault starts empty, and the file is written when the first secret is set.
+func (v *Vault) Open() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	data, err := os.ReadFile(v.config.File)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read secret vault: %w", err)
+	}
+	exists := err == nil
+
+	master, err := v.masterKey(!exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		kdf, err := newKDF()
+		if err != nil {
+			return err
+		}
+		v.kdf = kdf
+		v.key, err = deriveKey(master, kdf)
+		return err
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse secret vault %s: %w", v.config.File, err)
+	}
+	if file.Version != vaultVersion {
+		return fmt.Errorf("secret vault %s has unsupported version %d", v.config.File, file.Version)
+	}
+
+	key, secrets, err := decrypt(file, master)
+	if errors.Is(err, ErrWrongKey) {
+		key, secrets, err = v.completeRotation(file)
+	}
+	if err != nil {
+		return err
+	}
+	v.kdf = file.KDF
+	v.key = key
+	v.secrets = secrets
+	return nil
+}
+
+// masterKey returns the master key from key_env or key_file. With create, a
+// missing key file is created with a random key.
+func (v *Vault) masterKey(create bool) (string, error) {
+	if key := os.Getenv(v.config.KeyEnv); key != "" {
+		if len(key) < minKeyLength {
+			return "", fmt.Errorf("master key in $%s must be at least %d characters", v.config.KeyEnv, minKeyLength)
+		}
+		v.fromEnv = true
+		return key, nil
+	}
+
+	data, err := os.ReadFile(v.config.KeyFile)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read master key: %w", err)
+	}
+	if !create {
+		return "", fmt.Errorf("secret vault %s exists but its master key is missing: set $%s or restore %s", v.config.File, v.config.KeyEnv, v.config.KeyFile)
+	}
+
+	key, err := newMasterKey()
+	if err != nil {
+		return "", err
+	}
+	if err := writeFile(v.config.KeyFile, []byte(key+"\n")); err != nil {
+		return "", err
+	}
+	v.logger.Infof("Created master key %s for the secret vault; back it up, the vault cannot be read without it", v.config.KeyFile)
+	return key, nil
+}
+
+// completeRotation opens a vault that a rotation encrypted under a new key
+// before it could replace the old one: the key next to key_file, or the one in
+// key_env with a _NEW suffix
+func (v *Vault) completeRotation(file vaultFile) ([]byte, map[string]*entry, error) {
+	if v.fromEnv {
+		if next := os.Getenv(v.config.KeyEnv + "_NEW"); next != "" {
+			if key, secrets, err := decrypt(file, next); err == nil {
+				v.logger.Warnf("Secret vault is encrypted with $%s_NEW, set $%s to that key", v.config.KeyEnv, v.config.KeyEnv)
+				return key, secrets, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("%w %s: check $%s", ErrWrongKey, v.config.File, v.config.KeyEnv)
+	}
+
+	pending := v.config.KeyFile + pendingKeySuffix
+	if data, err := os.ReadFile(pending); err == nil {
+		if key, secrets, err := decrypt(file, strings.TrimSpace(string(data))); err == nil {
+			if err := os.Rename(pending, v.config.KeyFile); err != nil {
+				return nil, nil, fmt.Errorf("failed to complete master key rotation: %w", err)
+			}
+			v.logger.Warnf("Completed an interrupted master key rotation, %s holds the new key", v.config.KeyFile)
+			return key, secrets, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w %s: check %s", ErrWrongKey, v.config.File, v.config.KeyFile)
+}
+
+// AddListener registers a function called whenever a secret changes
+func (v *Vault) AddListener(listener Listener) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.listeners = append(v.listeners, listener)
+}
+
+// KeyFromEnv reports whether the master key comes from the environment
+func (v *Vault) KeyFromEnv() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.fromEnv
+}
+
+// Get returns the value of a secret
+func (v *Vault) Get(name string) (string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	secret, exists := v.secrets[name]
+	if !exists {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return secret.Value, nil
+}
+
+// Resolve returns the secret a "secret:<name>" reference refers to. Other
+// values are returned as they are, so it can be used on any credential field.
+// A nil vault resolves no references.
+func (v *Vault) Resolve(value string) (string, error) {
+	if !config.IsSecretRef(value) {
+		return value, nil
+	}
+	name := strings.TrimPrefix(value, config.SecretRefPrefix)
+	if v == nil {
+		return "", fmt.Errorf("secret %s cannot be read without the secret vault", name)
+	}
+	return v.Get(name)
+}
+
+// ResolveHeaders returns a copy of HTTP headers with secret references
+// resolved
+func (v *Vault) ResolveHeaders(headers map[string]string) (map[string]string, error) {
+	if headers == nil {
+		return nil, nil
+	}
+	resolved := make(map[string]string, len(headers))
+	for name, value := range headers {
+		value, err := v.Resolve(value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = value
+	}
+	return resolved, nil
+}
+
+// List describes the secrets, by name
+func (v *Vault) List() []Info {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	infos := make([]Info, 0, len(v.secrets))
+	for name, secret := range v.secrets {
+		infos = append(infos, secret.info(name))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Set creates a secret or replaces its value
+func (v *Vault) Set(name, value string) (Info, error) {
+	if !config.ValidSecretName(name) {
+		return Info{}, fmt.Errorf("%w: name '%s' must use letters, digits, '.', '_' and '-'", ErrInvalidSecret, name)
+	}
+	if value == "" {
+		return Info{}, fmt.Errorf("%w: value cannot be empty", ErrInvalidSecret)
+	}
+
+	v.mu.Lock()
+	previous := v.secrets[name]
+	now := time.Now().UTC()
+	secret := &entry{Value: value, Version: 1, CreatedAt: now, UpdatedAt: now}
+	if previous != nil {
+		secret.Version = previous.Version + 1
+		secret.CreatedAt = previous.CreatedAt
+	}
+	v.secrets[name] = secret
+	if err := v.saveLocked(); err != nil {
+		if previous != nil {
+			v.secrets[name] = previous
+		} else {
+			delete(v.secrets, name)
+		}
+		v.mu.Unlock()
+		return Info{}, err
+	}
+	info := secret.info(name)
+	listeners := v.listeners
+	v.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(name)
+	}
+	return info, nil
+}
+
+// Delete removes a secret
+func (v *Vault) Delete(name string) error {
+	v.mu.Lock()
+	previous, exists := v.secrets[name]
+	if !exists {
+		v.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	delete(v.secrets, name)
+	if err := v.saveLocked(); err != nil {
+		v.secrets[name] = previous
+		v.mu.Unlock()
+		return err
+	}
+	listeners := v.listeners
+	v.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(name)
+	}
+	return nil
+}
+
+// RotateKey encrypts the vault under a new master key. An empty newKey
+// generates one into key_file, which is not possible when the key comes from
+// the environment; then the caller passes the new key and updates key_env.
+func (v *Vault) RotateKey(newKey string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if newKey == "" {
+		if v.fromEnv {
+			return fmt.Errorf("%w: pass the new key and set $%s to it", ErrKeyFromEnv, v.config.KeyEnv)
+		}
+		generated, err := newMasterKey()
+		if err != nil {
+			return err
+		}
+		newKey = generated
+	}
+	if len(newKey) < minKeyLength {
+		return fmt.Errorf("%w: master key must be at least %d characters", ErrInvalidSecret, minKeyLength)
+	}
+
+	kdf, err := newKDF()
+	if err != nil {
+		return err
+	}
+	key, err := deriveKey(newKey, kdf)
+	if err != nil {
+		return err
+	}
+
+	pending := v.config.KeyFile + pendingKeySuffix
+	if !v.fromEnv {
+		if err := writeFile(pending, []byte(newKey+"\n")); err != nil {
+			return err
+		}
+	}
+
+	previousKDF, previousKey := v.kdf, v.key
+	v.kdf, v.key = kdf, key
+	if err := v.saveLocked(); err != nil {
+		v.kdf, v.key = previousKDF, previousKey
+		os.Remove(pending)
+		return err
+	}
+	if !v.fromEnv {
+		if err := os.Rename(pending, v.config.KeyFile); err != nil {
+			return fmt.Errorf("failed to replace master key, the new key is in %s: %w", pending, err)
+		}
+	}
+	v.logger.Infof("Rotated the master key of the secret vault")
+	return nil
+}
+
+// saveLocked encrypts the secrets and replaces the vault file
+func (v *Vault) saveLocked() error {
+	plaintext, err := json.Marshal(v.secrets)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets: %w", err)
+	}
+	aead, err := newAEAD(v.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	data, err := json.MarshalIndent(vaultFile{
+		Version: vaultVersion,
+		KDF:     v.kdf,
+		Nonce:   nonce,
+		Data:    aead.Seal(nil, nonce, plaintext, additionalData),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode secret vault: %w", err)
+	}
+	return writeFile(v.config.File, data)
+}
+
+func (e *entry) info(name string) Info {
+	return Info{Name: name, Version: e.Version, CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt}
+}
+
+// decrypt derives the key of a vault file from a master key and decrypts the
+// secrets with it
+func decrypt(file vaultFile, master string) ([]byte, map[string]*entry, error) {
+	key, err := deriveKey(master, file.KDF)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, nil, fmt.Errorf("secret vault has a bad nonce")
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Data, additionalData)
+	if err != nil {
+		return nil, nil, ErrWrongKey
+	}
+
+	secrets := make(map[string]*entry)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode secrets: %w", err)
+	}
+	return key, secrets, nil
+}
+
+// newKDF returns scrypt parameters with a fresh salt
+func newKDF() (kdfParams, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return kdfParams{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return kdfParams{Salt: salt, N: 1 << 15, R: 8, P: 1}, nil
+}
+
+func deriveKey(master string, kdf kdfParams) ([]byte, error) {
+	key, err := scrypt.Key([]byte(master), kdf.Salt, kdf.N, kdf.R, kdf.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newMasterKey returns a random master key
+func newMasterKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate master key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// writeFile replaces a file readable only by its owner, so readers never see
+// part of it
+func writeFile(path string, data []byte) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmpFile, err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
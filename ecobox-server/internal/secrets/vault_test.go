@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ecobox-server/internal/config"
+)
+
+func newTestVault(t *testing.T, dir string) *Vault {
+	cfg := &config.Config{}
+	cfg.Secrets.File = filepath.Join(dir, "secrets.vault")
+	cfg.Secrets.KeyFile = filepath.Join(dir, "secrets.key")
+	cfg.Secrets.KeyEnv = "ECOBOX_TEST_MASTER_KEY"
+	v := NewVault(cfg)
+	if err := v.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return v
+}
+
+func TestVaultEncryptsSecrets(t *testing.T) {
+	dir := t.TempDir()
+	v := newTestVault(t, dir)
+
+	var changed []string
+	v.AddListener(func(name string) { changed = append(changed, name) })
+	if _, err := v.Set("pve-token", "root@pam!ecobox=0123-4567"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := v.Set("pve-token", "root@pam!ecobox=89ab-cdef")
+	if err != nil || info.Version != 2 {
+		t.Fatalf("Expected version 2 after replacing the value, got %+v (%v)", info, err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("Expected listeners to hear of both changes, got %v", changed)
+	}
+	if _, err := v.Set("bad name", "value"); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("Expected an invalid name to be rejected, got %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "secrets.vault"))
+	if strings.Contains(string(data), "89ab-cdef") || strings.Contains(string(data), "pve-token") {
+		t.Error("Expected the vault file not to contain names or values")
+	}
+
+	// The vault is read again with the key file created on first run
+	again := newTestVault(t, dir)
+	if value, err := again.Resolve("secret:pve-token"); err != nil || value != "root@pam!ecobox=89ab-cdef" {
+		t.Errorf("Expected the saved secret, got %q (%v)", value, err)
+	}
+	if value, _ := again.Resolve("plain"); value != "plain" {
+		t.Errorf("Expected values that are not references to be kept, got %q", value)
+	}
+	if _, err := again.Resolve("secret:missing"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Expected a missing secret to fail, got %v", err)
+	}
+	var none *Vault
+	if _, err := none.Resolve("secret:pve-token"); err == nil {
+		t.Error("Expected references to fail without a vault")
+	}
+
+	// Another key does not open it
+	t.Setenv("ECOBOX_TEST_MASTER_KEY", "another-key-0123456789")
+	cfg := &config.Config{Secrets: again.config}
+	if err := NewVault(cfg).Open(); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected the wrong key to be refused, got %v", err)
+	}
+}
+
+func TestVaultRotateKey(t *testing.T) {
+	dir := t.TempDir()
+	v := newTestVault(t, dir)
+	if _, err := v.Set("mqtt-password", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	oldKey, _ := os.ReadFile(filepath.Join(dir, "secrets.key"))
+
+	if err := v.RotateKey(""); err != nil {
+		t.Fatal(err)
+	}
+	newKey, _ := os.ReadFile(filepath.Join(dir, "secrets.key"))
+	if string(newKey) == string(oldKey) {
+		t.Fatal("Expected a new key file")
+	}
+	if value, err := newTestVault(t, dir).Get("mqtt-password"); err != nil || value != "hunter2" {
+		t.Errorf("Expected the secret under the new key, got %q (%v)", value, err)
+	}
+
+	// A rotation interrupted after the vault was written is completed on open
+	if err := v.RotateKey(""); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := os.ReadFile(filepath.Join(dir, "secrets.key"))
+	os.WriteFile(filepath.Join(dir, "secrets.key"+pendingKeySuffix), rotated, 0600)
+	os.WriteFile(filepath.Join(dir, "secrets.key"), newKey, 0600)
+	if value, err := newTestVault(t, dir).Get("mqtt-password"); err != nil || value != "hunter2" {
+		t.Errorf("Expected the interrupted rotation to be completed, got %q (%v)", value, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "secrets.key")); string(data) != string(rotated) {
+		t.Error("Expected the pending key to replace the key file")
+	}
+
+	// Keys from the environment are not generated
+	t.Setenv("ECOBOX_TEST_MASTER_KEY", "environment-key-0123456789")
+	envDir := t.TempDir()
+	fromEnv := newTestVault(t, envDir)
+	if err := fromEnv.RotateKey(""); !errors.Is(err, ErrKeyFromEnv) {
+		t.Errorf("Expected rotation without a new key to fail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(envDir, "secrets.key")); !os.IsNotExist(err) {
+		t.Error("Expected no key file with a key from the environment")
+	}
+}
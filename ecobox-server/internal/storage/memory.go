@@ -12,23 +12,39 @@ import (
 type Storage interface {
 	GetServer(id string) (*models.Server, error)
 	GetAllServers() map[string]*models.Server
+	// UpdateServer replaces a stored server but keeps its desired state, intent,
+	// leases, groups, services and Proxmox token. Those only change through their
+	// own setters, so a stale copy cannot undo a newer request.
 	UpdateServer(server *models.Server) error
 	AddServer(server *models.Server) error
 	DeleteServer(id string) error
 
 	UpdateServerState(id string, state models.PowerState) error
+	SetDesiredState(id string, state models.PowerState, intent *models.PowerIntent) error
+	SetServerLeases(id string, leases []models.Lease) error
+	SetServerGroups(id string, groups []string) error
+	SetServerServices(id string, services []models.Service) error
+	SetProxmoxTokenRef(id string, tokenRef string) error
+	SetProxmoxAPIKey(id string, tokenRef string, apiKey *models.ProxmoxAPIKey) error
+	UpdateServiceResult(id string, service models.Service) error
+	UpdateServiceResults(id string, services []models.Service) error
 	UpdateServerTimes(id string) error
 	AddServerAction(id string, action models.ServerAction) error
+	AddActionListener(listener ActionListener)
 	
 	// SystemInfo operations
 	UpdateServerSystemInfo(id string, systemInfo *models.SystemInfo) error
 	GetServerSystemInfo(id string) (*models.SystemInfo, error)
 }
 
+// ActionListener is called with every action recorded for a server
+type ActionListener func(serverID string, action models.ServerAction)
+
 // MemoryStorage provides in-memory storage implementation
 type MemoryStorage struct {
-	servers map[string]*models.Server
-	mu      sync.RWMutex
+	servers   map[string]*models.Server
+	listeners []ActionListener
+	mu        sync.RWMutex
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
@@ -79,8 +95,15 @@ func (ms *MemoryStorage) UpdateServer(server *models.Server) error {
 		return fmt.Errorf("server with ID '%s' not found", server.ID)
 	}
 
-	// Create a copy before storing
+	// Create a copy before storing, keeping the fields that have their own setters
 	serverCopy := *server
+	serverCopy.DesiredState = ms.servers[server.ID].DesiredState
+	serverCopy.Intent = ms.servers[server.ID].Intent
+	serverCopy.Leases = ms.servers[server.ID].Leases
+	serverCopy.Groups = ms.servers[server.ID].Groups
+	serverCopy.Services = ms.servers[server.ID].Services
+	serverCopy.ProxmoxTokenRef = ms.servers[server.ID].ProxmoxTokenRef
+	serverCopy.ProxmoxAPIKey = ms.servers[server.ID].ProxmoxAPIKey
 	ms.servers[server.ID] = &serverCopy
 	return nil
 }
@@ -153,6 +176,179 @@ func (ms *MemoryStorage) UpdateServerState(id string, state models.PowerState) e
 	return nil
 }
 
+// SetDesiredState records the desired power state of a server and the intent behind it
+func (ms *MemoryStorage) SetDesiredState(id string, state models.PowerState, intent *models.PowerIntent) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	server.DesiredState = state
+	server.Intent = nil
+	if intent != nil {
+		intentCopy := *intent
+		server.Intent = &intentCopy
+	}
+
+	return nil
+}
+
+// SetServerLeases replaces the keep-awake leases of a server
+func (ms *MemoryStorage) SetServerLeases(id string, leases []models.Lease) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	server.Leases = append([]models.Lease(nil), leases...)
+	return nil
+}
+
+// SetServerGroups replaces the groups a server belongs to
+func (ms *MemoryStorage) SetServerGroups(id string, groups []string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	server.Groups = append([]string(nil), groups...)
+	return nil
+}
+
+// SetServerServices replaces the service definitions of a server. Services that
+// keep their ID keep their last check result.
+func (ms *MemoryStorage) SetServerServices(id string, services []models.Service) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	previous := make(map[string]models.Service, len(server.Services))
+	for _, service := range server.Services {
+		previous[service.ID] = service
+	}
+
+	replaced := make([]models.Service, len(services))
+	for i, service := range services {
+		if old, ok := previous[service.ID]; ok {
+			service.CopyCheckResult(old)
+		}
+		replaced[i] = service
+	}
+	server.Services = replaced
+	return nil
+}
+
+// SetProxmoxTokenRef sets the secret reference a Proxmox host's API token is
+// read from. A changed reference drops the loaded token, so it is loaded again.
+func (ms *MemoryStorage) SetProxmoxTokenRef(id string, tokenRef string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	if server.ProxmoxTokenRef != tokenRef {
+		server.ProxmoxTokenRef = tokenRef
+		server.ProxmoxAPIKey = nil
+	}
+	return nil
+}
+
+// SetProxmoxAPIKey stores the API token of a Proxmox host, or drops it when
+// apiKey is nil. A token loaded for a reference that has changed since is not
+// stored.
+func (ms *MemoryStorage) SetProxmoxAPIKey(id string, tokenRef string, apiKey *models.ProxmoxAPIKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	if server.ProxmoxTokenRef == tokenRef {
+		server.ProxmoxAPIKey = apiKey
+	}
+	return nil
+}
+
+// UpdateServiceResult stores the check result of one service of a server
+func (ms *MemoryStorage) UpdateServiceResult(id string, service models.Service) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	// Copies handed out share the slice, so replace it rather than changing it in place
+	for i := range server.Services {
+		if server.Services[i].ID == service.ID {
+			services := append([]models.Service(nil), server.Services...)
+			services[i].CopyCheckResult(service)
+			server.Services = services
+			return nil
+		}
+	}
+	return fmt.Errorf("service with ID '%s' not found", service.ID)
+}
+
+// UpdateServiceResults stores the results of a round of service checks. Defined
+// services take the result with their ID; definitions are not changed. The
+// discovered services of the server are replaced by the discovered ones among
+// the results, except on ports a defined service uses.
+func (ms *MemoryStorage) UpdateServiceResults(id string, services []models.Service) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	server, exists := ms.servers[id]
+	if !exists {
+		return fmt.Errorf("server with ID '%s' not found", id)
+	}
+
+	results := make(map[string]models.Service, len(services))
+	for _, service := range services {
+		results[service.ID] = service
+	}
+
+	updated := make([]models.Service, 0, len(services))
+	ports := make(map[int]bool)
+	for _, service := range server.Services {
+		if service.Source == models.SourceDiscovered {
+			continue
+		}
+		if result, ok := results[service.ID]; ok {
+			service.CopyCheckResult(result)
+		}
+		updated = append(updated, service)
+		ports[service.Port] = true
+	}
+	for _, service := range services {
+		if service.Source == models.SourceDiscovered && !ports[service.Port] {
+			updated = append(updated, service)
+		}
+	}
+
+	server.Services = updated
+	return nil
+}
+
 // UpdateServerTimes updates time tracking based on state changes
 func (ms *MemoryStorage) UpdateServerTimes(id string) error {
 	ms.mu.Lock()
@@ -178,7 +374,7 @@ func (ms *MemoryStorage) updateServerTimesLocked(server *models.Server) error {
 	switch server.CurrentState {
 	case models.PowerStateOn:
 		server.TotalOnTime += duration
-	case models.PowerStateSuspended:
+	case models.PowerStateSuspended, models.PowerStateHibernated:
 		server.TotalSuspendedTime += duration
 	case models.PowerStateOff:
 		server.TotalOffTime += duration
@@ -190,13 +386,13 @@ func (ms *MemoryStorage) updateServerTimesLocked(server *models.Server) error {
 	return nil
 }
 
-// AddServerAction adds an action to the server's recent actions list
+// AddServerAction adds an action to the server's recent actions list and passes
+// it to the action listeners
 func (ms *MemoryStorage) AddServerAction(id string, action models.ServerAction) error {
 	ms.mu.Lock()
-	defer ms.mu.Unlock()
-
 	server, exists := ms.servers[id]
 	if !exists {
+		ms.mu.Unlock()
 		return fmt.Errorf("server with ID '%s' not found", id)
 	}
 
@@ -207,10 +403,25 @@ func (ms *MemoryStorage) AddServerAction(id string, action models.ServerAction)
 	if len(server.RecentActions) > 50 {
 		server.RecentActions = server.RecentActions[len(server.RecentActions)-50:]
 	}
+	listeners := ms.listeners
+	ms.mu.Unlock()
 
+	// Listeners run outside the lock so they can read storage
+	for _, listener := range listeners {
+		listener(id, action)
+	}
 	return nil
 }
 
+// AddActionListener registers a function called with every recorded action. It
+// runs on the caller's goroutine and must not block.
+func (ms *MemoryStorage) AddActionListener(listener ActionListener) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.listeners = append(ms.listeners, listener)
+}
+
 // UpdateServerSystemInfo updates the system information for a server
 func (ms *MemoryStorage) UpdateServerSystemInfo(id string, systemInfo *models.SystemInfo) error {
 	ms.mu.Lock()
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"ecobox-server/internal/models"
+)
+
+func newTestStorage(t *testing.T) *MemoryStorage {
+	t.Helper()
+	store := NewMemoryStorage()
+	if err := store.AddServer(&models.Server{ID: "nas", Name: "NAS"}); err != nil {
+		t.Fatalf("Failed to add server: %v", err)
+	}
+	return store
+}
+
+func TestSetDesiredState(t *testing.T) {
+	store := newTestStorage(t)
+
+	server, _ := store.GetServer("nas")
+	if server.DesiredState != models.PowerStateUnknown {
+		t.Errorf("Expected new server to have desired state unknown, got %s", server.DesiredState)
+	}
+
+	intent := &models.PowerIntent{State: models.PowerStateOn, Reason: "backup", RequestedBy: "admin", RequestedAt: time.Now()}
+	if err := store.SetDesiredState("nas", models.PowerStateOn, intent); err != nil {
+		t.Fatalf("Failed to set desired state: %v", err)
+	}
+
+	// The stored intent is a copy
+	intent.Reason = "changed"
+
+	server, _ = store.GetServer("nas")
+	if server.DesiredState != models.PowerStateOn {
+		t.Errorf("Expected desired state on, got %s", server.DesiredState)
+	}
+	if server.Intent == nil || server.Intent.Reason != "backup" {
+		t.Errorf("Expected stored intent with reason 'backup', got %+v", server.Intent)
+	}
+
+	// Clearing the intent keeps the desired state
+	if err := store.SetDesiredState("nas", models.PowerStateOff, nil); err != nil {
+		t.Fatalf("Failed to set desired state: %v", err)
+	}
+	server, _ = store.GetServer("nas")
+	if server.DesiredState != models.PowerStateOff || server.Intent != nil {
+		t.Errorf("Expected desired state off without intent, got %s %+v", server.DesiredState, server.Intent)
+	}
+
+	if err := store.SetDesiredState("missing", models.PowerStateOn, nil); err == nil {
+		t.Error("Expected error for unknown server")
+	}
+}
+
+func TestUpdateServerKeepsIntent(t *testing.T) {
+	store := newTestStorage(t)
+
+	// A copy taken before the desired state changes
+	stale, _ := store.GetServer("nas")
+
+	intent := &models.PowerIntent{State: models.PowerStateOn, RequestedBy: "admin", RequestedAt: time.Now()}
+	if err := store.SetDesiredState("nas", models.PowerStateOn, intent); err != nil {
+		t.Fatalf("Failed to set desired state: %v", err)
+	}
+
+	stale.CurrentState = models.PowerStateOff
+	stale.DesiredState = models.PowerStateOff
+	stale.Intent = nil
+	if err := store.UpdateServer(stale); err != nil {
+		t.Fatalf("Failed to update server: %v", err)
+	}
+
+	server, _ := store.GetServer("nas")
+	if server.CurrentState != models.PowerStateOff {
+		t.Errorf("Expected current state to be written, got %s", server.CurrentState)
+	}
+	if server.DesiredState != models.PowerStateOn {
+		t.Errorf("Expected desired state on to be kept, got %s", server.DesiredState)
+	}
+	if server.Intent == nil || server.Intent.RequestedBy != "admin" {
+		t.Errorf("Expected intent to be kept, got %+v", server.Intent)
+	}
+}
+
+func TestUpdateServiceResults(t *testing.T) {
+	store := newTestStorage(t)
+
+	// A copy taken before the services are defined
+	stale, _ := store.GetServer("nas")
+
+	defined := []models.Service{
+		{ID: "nas-web", Name: "web", Port: 80, Source: models.SourceConfig},
+		{ID: "nas-smb", Name: "smb", Port: 445, Source: models.SourceAPI},
+	}
+	if err := store.SetServerServices("nas", defined); err != nil {
+		t.Fatalf("Failed to set services: %v", err)
+	}
+
+	// Updating the stale copy keeps the definitions
+	stale.CurrentState = models.PowerStateOn
+	if err := store.UpdateServer(stale); err != nil {
+		t.Fatalf("Failed to update server: %v", err)
+	}
+	server, _ := store.GetServer("nas")
+	if len(server.Services) != 2 {
+		t.Fatalf("Expected the 2 defined services to be kept, got %d", len(server.Services))
+	}
+
+	// Results of a check that began before web moved to another port
+	results := []models.Service{
+		{ID: "nas-web", Name: "web", Port: 8080, Source: models.SourceConfig, Status: models.ServiceStatusUp},
+		{ID: "nas-port-445", Name: "microsoft-ds", Port: 445, Source: models.SourceDiscovered, Status: models.ServiceStatusUp},
+		{ID: "nas-port-22", Name: "ssh", Port: 22, Source: models.SourceDiscovered, Status: models.ServiceStatusUp},
+	}
+	if err := store.UpdateServiceResults("nas", results); err != nil {
+		t.Fatalf("Failed to update service results: %v", err)
+	}
+
+	server, _ = store.GetServer("nas")
+	services := make(map[string]models.Service)
+	for _, service := range server.Services {
+		services[service.ID] = service
+	}
+	if len(services) != 3 {
+		t.Fatalf("Expected web, smb and the discovered ssh service, got %+v", server.Services)
+	}
+	if web := services["nas-web"]; web.Port != 80 || web.Status != models.ServiceStatusUp {
+		t.Errorf("Expected web on port 80 with the check result, got port %d %s", web.Port, web.Status)
+	}
+	if smb := services["nas-smb"]; smb.Source != models.SourceAPI || smb.Status != "" {
+		t.Errorf("Expected smb defined through the API without a result, got %+v", smb)
+	}
+	if _, ok := services["nas-port-22"]; !ok {
+		t.Error("Expected the discovered ssh service to be stored")
+	}
+
+	if err := store.UpdateServiceResults("missing", results); err == nil {
+		t.Error("Expected error for unknown server")
+	}
+}
+
+func TestSetProxmoxAPIKey(t *testing.T) {
+	store := newTestStorage(t)
+	if err := store.SetProxmoxTokenRef("nas", "secret:pve"); err != nil {
+		t.Fatalf("Failed to set token reference: %v", err)
+	}
+
+	// A copy taken before the token is loaded
+	stale, _ := store.GetServer("nas")
+
+	apiKey := &models.ProxmoxAPIKey{Username: "root", Realm: "pam", TokenID: "ecobox", Secret: "s1"}
+	if err := store.SetProxmoxAPIKey("nas", "secret:pve", apiKey); err != nil {
+		t.Fatalf("Failed to set API key: %v", err)
+	}
+	stale.CurrentState = models.PowerStateOn
+	if err := store.UpdateServer(stale); err != nil {
+		t.Fatalf("Failed to update server: %v", err)
+	}
+	server, _ := store.GetServer("nas")
+	if server.ProxmoxAPIKey == nil || server.CurrentState != models.PowerStateOn {
+		t.Errorf("Expected the API key to be kept and the state written, got %+v", server)
+	}
+
+	// A changed reference drops the token, and one loaded for the old
+	// reference is not stored
+	if err := store.SetProxmoxTokenRef("nas", "secret:pve-new"); err != nil {
+		t.Fatalf("Failed to set token reference: %v", err)
+	}
+	if err := store.SetProxmoxAPIKey("nas", "secret:pve", apiKey); err != nil {
+		t.Fatalf("Failed to set API key: %v", err)
+	}
+	server, _ = store.GetServer("nas")
+	if server.ProxmoxAPIKey != nil || server.ProxmoxTokenRef != "secret:pve-new" {
+		t.Errorf("Expected no API key for the new reference, got %+v", server.ProxmoxAPIKey)
+	}
+}
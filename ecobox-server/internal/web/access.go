@@ -0,0 +1,122 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+)
+
+// authorizeServer reports whether the requester may do something to a server,
+// answering 403 when they may not. Requests without a user are allowed, since
+// there are none before authentication is set up.
+func (ws *WebServer) authorizeServer(w http.ResponseWriter, r *http.Request, serverID string, permission auth.Permission) bool {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || user.Can(serverID, permission) {
+		return true
+	}
+
+	response := APIResponse{
+		Success: false,
+		Message: fmt.Sprintf("Permission %s required for server %s", permission, serverID),
+	}
+	ws.writeJSONResponse(w, http.StatusForbidden, response)
+	return false
+}
+
+// isAdmin reports whether the requester is an admin. Without a user in the
+// request everything is allowed, as in authorizeServer.
+func isAdmin(r *http.Request) bool {
+	user := auth.GetUserFromContext(r.Context())
+	return user == nil || user.HasRole(auth.RoleAdmin)
+}
+
+// authorizeServers is authorizeServer for several servers at once. The
+// response names every server the requester lacks the permission for.
+func (ws *WebServer) authorizeServers(w http.ResponseWriter, r *http.Request, serverIDs []string, permission auth.Permission) bool {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		return true
+	}
+
+	var denied []string
+	for _, id := range serverIDs {
+		if !user.Can(id, permission) {
+			denied = append(denied, id)
+		}
+	}
+	if len(denied) == 0 {
+		return true
+	}
+
+	sort.Strings(denied)
+	response := APIResponse{
+		Success: false,
+		Message: fmt.Sprintf("Permission %s required for servers %s", permission, strings.Join(denied, ", ")),
+	}
+	ws.writeJSONResponse(w, http.StatusForbidden, response)
+	return false
+}
+
+// visibleServers keeps the servers the requester may view
+func visibleServers(r *http.Request, servers map[string]*models.Server) map[string]*models.Server {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		return servers
+	}
+
+	visible := make(map[string]*models.Server)
+	for id, server := range servers {
+		if user.Can(id, auth.PermissionView) {
+			visible[id] = server
+		}
+	}
+	return visible
+}
+
+// statePermission returns the permission needed to request a desired state
+func statePermission(state models.PowerState) auth.Permission {
+	switch state {
+	case models.PowerStateOn:
+		return auth.PermissionWake
+	case models.PowerStateSuspended, models.PowerStateHibernated:
+		return auth.PermissionSuspend
+	default:
+		return auth.PermissionShutdown
+	}
+}
+
+// cascadeServers returns the running servers a cascading request takes down
+// along with the given ones: their hard dependents, those servers' dependents
+// and so on
+func (ws *WebServer) cascadeServers(serverIDs ...string) []string {
+	graph := control.NewDependencyGraph(ws.storage.GetAllServers())
+	seen := make(map[string]bool)
+	for _, id := range serverIDs {
+		seen[id] = true
+	}
+
+	var dependents []string
+	for queue := append([]string(nil), serverIDs...); len(queue) > 0; queue = queue[1:] {
+		for _, dependent := range graph.ActiveDependents(queue[0]) {
+			if !seen[dependent.ID] {
+				seen[dependent.ID] = true
+				dependents = append(dependents, dependent.ID)
+				queue = append(queue, dependent.ID)
+			}
+		}
+	}
+	return dependents
+}
+
+// canView reports whether a WebSocket client's user may view a server. The
+// user is looked up on every update so role changes apply at once; clients of
+// deleted users receive nothing.
+func (ws *WebServer) canView(username, serverID string) bool {
+	user, exists := ws.authManager.GetUser(username)
+	return exists && user.Can(serverID, auth.PermissionView)
+}
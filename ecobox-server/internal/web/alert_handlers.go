@@ -0,0 +1,73 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ecobox-server/internal/alerts"
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// handleGetAlerts returns the firing and recently resolved alerts, optionally for
+// one server (?server=id). Alerts of servers the requester may not view are left out.
+func (ws *WebServer) handleGetAlerts(w http.ResponseWriter, r *http.Request) {
+	status := ws.alerts.Status(r.URL.Query().Get("server"))
+	if user := auth.GetUserFromContext(r.Context()); user != nil {
+		status.Firing = visibleAlerts(user, status.Firing)
+		status.Resolved = visibleAlerts(user, status.Resolved)
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    status,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleTestNotifier sends a test notification through a notifier (admin only)
+func (ws *WebServer) handleTestNotifier(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := ws.alerts.Test(name); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, alerts.ErrNotifierNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Test notification failed: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Test notification sent through %s", name),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// visibleAlerts keeps the alerts of servers the user may view
+func visibleAlerts(user *auth.User, alertList []models.Alert) []models.Alert {
+	visible := make([]models.Alert, 0, len(alertList))
+	for _, alert := range alertList {
+		if user.Can(alert.ServerID, auth.PermissionView) {
+			visible = append(visible, alert)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,405 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/registry"
+	"github.com/gorilla/mux"
+)
+
+// V1Prefix is where the versioned API is served. Breaking changes get a new
+// version next to it; /api stays as it is for the web frontend.
+const V1Prefix = "/api/v1"
+
+// Error codes of the versioned API. Each code always comes with the same HTTP
+// status, see errorStatuses.
+const (
+	ErrCodeInvalidRequest     = "invalid_request"     // Malformed body or parameter
+	ErrCodeValidation         = "validation_failed"   // Well-formed but invalid values
+	ErrCodeUnsupported        = "unsupported"         // The server does not support the action
+	ErrCodeUnauthorized       = "unauthorized"        // No or invalid credentials
+	ErrCodeForbidden          = "forbidden"           // Role, grants or token scopes do not allow it
+	ErrCodeNotFound           = "not_found"           // No such route or object
+	ErrCodeMethodNotAllowed   = "method_not_allowed"  // The route exists for other methods
+	ErrCodeConflict           = "conflict"            // The current state does not allow the change
+	ErrCodeAlreadyExists      = "already_exists"      // An object with the ID exists
+	ErrCodeReadOnly           = "read_only"           // Defined in the configuration file
+	ErrCodeInUse              = "in_use"              // Other servers depend on it
+	ErrCodeServerLeased       = "server_leased"       // Keep-awake leases hold the server on
+	ErrCodeActiveDependents   = "active_dependents"   // Running dependents must go down first
+	ErrCodePreconditionFailed = "precondition_failed" // If-Match does not match the current ETag
+	ErrCodeUnavailable        = "unavailable"         // A subsystem is not running
+	ErrCodeInternal           = "internal_error"
+)
+
+// errorStatuses maps each error code to its HTTP status
+var errorStatuses = map[string]int{
+	ErrCodeInvalidRequest:     http.StatusBadRequest,
+	ErrCodeValidation:         http.StatusUnprocessableEntity,
+	ErrCodeUnsupported:        http.StatusUnprocessableEntity,
+	ErrCodeUnauthorized:       http.StatusUnauthorized,
+	ErrCodeForbidden:          http.StatusForbidden,
+	ErrCodeNotFound:           http.StatusNotFound,
+	ErrCodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	ErrCodeConflict:           http.StatusConflict,
+	ErrCodeAlreadyExists:      http.StatusConflict,
+	ErrCodeReadOnly:           http.StatusConflict,
+	ErrCodeInUse:              http.StatusConflict,
+	ErrCodeServerLeased:       http.StatusConflict,
+	ErrCodeActiveDependents:   http.StatusConflict,
+	ErrCodePreconditionFailed: http.StatusPreconditionFailed,
+	ErrCodeUnavailable:        http.StatusServiceUnavailable,
+	ErrCodeInternal:           http.StatusInternalServerError,
+}
+
+// commonErrors can be returned by every versioned endpoint
+var commonErrors = []string{ErrCodeUnauthorized, ErrCodeInternal}
+
+// Paging limits of list endpoints
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// APIError is a failed versioned API request: a stable code for programs and a
+// message for people
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Status returns the HTTP status of the error code
+func (e *APIError) Status() int {
+	if status, ok := errorStatuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorResponse is the body of every failed versioned API response
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// Pagination describes the page of a list response
+type Pagination struct {
+	Total  int `json:"total"` // Matches before paging
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// newAPIError returns an error with a code and a formatted message
+func newAPIError(code, format string, args ...interface{}) *APIError {
+	return &APIError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// asAPIError turns an error from the monitor, registry or other components
+// into an APIError. Errors without a known cause get the fallback code.
+func asAPIError(err error, fallback string) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	code := fallback
+	switch {
+	case errors.Is(err, registry.ErrServerNotFound), errors.Is(err, registry.ErrServiceNotFound), errors.Is(err, monitor.ErrLeaseNotFound):
+		code = ErrCodeNotFound
+	case errors.Is(err, registry.ErrServerExists):
+		code = ErrCodeAlreadyExists
+	case errors.Is(err, registry.ErrReadOnly):
+		code = ErrCodeReadOnly
+	case errors.Is(err, registry.ErrServerInUse):
+		code = ErrCodeInUse
+	case errors.Is(err, monitor.ErrServerLeased):
+		code = ErrCodeServerLeased
+	case errors.Is(err, control.ErrActiveDependents):
+		code = ErrCodeActiveDependents
+	case errors.Is(err, registry.ErrAdminRequired):
+		code = ErrCodeForbidden
+	case errors.Is(err, registry.ErrSaveFailed):
+		code = ErrCodeInternal
+	}
+	return &APIError{Code: code, Message: err.Error()}
+}
+
+// v1Param is a query parameter of a versioned endpoint
+type v1Param struct {
+	Name        string
+	Type        string // "string", "integer" or "boolean"
+	Description string
+}
+
+// v1Route describes a versioned endpoint. The route table both registers the
+// handlers and generates the OpenAPI document, so the two cannot disagree.
+type v1Route struct {
+	Method       string
+	Path         string // Relative to V1Prefix, with {name} variables
+	OperationID  string
+	Summary      string
+	Query        []v1Param
+	Request      interface{} // Zero value of the request body type, nil without a body
+	OptionalBody bool        // The request body may be left out
+	Response     interface{} // Zero value of the response type, nil for 204 No Content
+	Status       int         // Status of a successful response
+	Errors       []string    // Error codes besides commonErrors
+	IfMatch      bool        // Honors If-Match against the ETag of the server in the path
+	handle       func(r *http.Request) (interface{}, error)
+}
+
+// setupV1Routes registers the versioned API and its OpenAPI document
+func (ws *WebServer) setupV1Routes(api *mux.Router) {
+	v1 := api.PathPrefix("/v1").Subrouter()
+	routes := ws.v1Routes()
+	ws.openAPI = generateOpenAPI(routes)
+
+	// Each path gets one handler that picks the method, as mux reports a method
+	// mismatch in a subrouter as not found once a later route is tried
+	var paths []string
+	methods := make(map[string]map[string]http.HandlerFunc)
+	for _, route := range routes {
+		if methods[route.Path] == nil {
+			paths = append(paths, route.Path)
+			methods[route.Path] = make(map[string]http.HandlerFunc)
+		}
+		methods[route.Path][route.Method] = ws.serveV1(route)
+	}
+	paths = append(paths, "/openapi.json")
+	methods["/openapi.json"] = map[string]http.HandlerFunc{"GET": ws.handleOpenAPI}
+	for _, path := range paths {
+		v1.HandleFunc(path, ws.serveV1Methods(methods[path]))
+	}
+
+	v1.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws.writeAPIError(w, newAPIError(ErrCodeNotFound, "No such endpoint: %s %s", r.Method, r.URL.Path))
+	})
+}
+
+// serveV1Methods runs the handler of the request method, or answers 405 with
+// the methods the path allows
+func (ws *WebServer) serveV1Methods(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			ws.writeAPIError(w, newAPIError(ErrCodeMethodNotAllowed, "Method %s is not allowed on %s", r.Method, r.URL.Path))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handleOpenAPI serves the OpenAPI document of the versioned API
+func (ws *WebServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	ws.writeV1Body(w, r, http.StatusOK, ws.openAPI)
+}
+
+// serveV1 runs a versioned handler and writes its result or error
+func (ws *WebServer) serveV1(route v1Route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if route.IfMatch {
+			if err := ws.checkIfMatch(r); err != nil {
+				ws.writeAPIError(w, asAPIError(err, ErrCodeInternal))
+				return
+			}
+		}
+
+		result, err := route.handle(r)
+		if err != nil {
+			ws.writeAPIError(w, asAPIError(err, ErrCodeInternal))
+			return
+		}
+		if route.Response == nil {
+			w.WriteHeader(route.Status)
+			return
+		}
+
+		body, err := json.Marshal(result)
+		if err != nil {
+			ws.logger.Errorf("Failed to encode JSON response: %v", err)
+			ws.writeAPIError(w, newAPIError(ErrCodeInternal, "Failed to encode response"))
+			return
+		}
+		ws.writeV1Body(w, r, route.Status, body)
+	}
+}
+
+// writeV1Body writes a JSON body with its ETag, or 304 Not Modified when a
+// GET request already has it
+func (ws *WebServer) writeV1Body(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	etag := computeETag(body)
+	w.Header().Set("ETag", etag)
+	if r.Method == "GET" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// writeAPIError writes a versioned API error
+func (ws *WebServer) writeAPIError(w http.ResponseWriter, err *APIError) {
+	ws.writeJSONResponse(w, err.Status(), ErrorResponse{Error: *err})
+}
+
+// checkIfMatch refuses a change when If-Match names another version of the
+// server in the path than the current one. Missing servers are left for the
+// handler to report.
+func (ws *WebServer) checkIfMatch(r *http.Request) error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return nil
+	}
+	server, err := ws.storage.GetServer(mux.Vars(r)["id"])
+	if err != nil {
+		return nil
+	}
+	body, err := json.Marshal(server)
+	if err != nil {
+		return err
+	}
+	if !etagMatches(ifMatch, computeETag(body)) {
+		return newAPIError(ErrCodePreconditionFailed, "Server %s has changed since it was read", server.ID)
+	}
+	return nil
+}
+
+// computeETag returns the strong entity tag of a response body
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists an
+// entity tag. Weak tags compare equal to strong ones.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeV1Body decodes a JSON request body, refusing unknown fields. With
+// optional set an empty body leaves v as it is.
+func decodeV1Body(r *http.Request, v interface{}, optional bool) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return newAPIError(ErrCodeInvalidRequest, "Failed to read request body: %v", err)
+	}
+	if optional && len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return newAPIError(ErrCodeInvalidRequest, "Invalid request body: %v", err)
+	}
+	return nil
+}
+
+// parsePage reads the limit and offset query parameters
+func parsePage(r *http.Request) (Pagination, error) {
+	page := Pagination{Limit: defaultPageLimit}
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, newAPIError(ErrCodeInvalidRequest, "limit must be between 1 and %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return page, newAPIError(ErrCodeInvalidRequest, "offset must be a non-negative number")
+		}
+		page.Offset = offset
+	}
+	return page, nil
+}
+
+// bounds returns the slice bounds of the page within total items, and records
+// the total
+func (p *Pagination) bounds(total int) (start, end int) {
+	p.Total = total
+	start = min(p.Offset, total)
+	end = min(start+p.Limit, total)
+	return start, end
+}
+
+// pageParams are the query parameters of every list endpoint
+var pageParams = []v1Param{
+	{Name: "limit", Type: "integer", Description: fmt.Sprintf("Items per page, 1 to %d (default %d)", maxPageLimit, defaultPageLimit)},
+	{Name: "offset", Type: "integer", Description: "Items to skip"},
+}
+
+// requireV1Server returns the server in the path when the requester has a
+// permission on it
+func (ws *WebServer) requireV1Server(r *http.Request, permission auth.Permission) (*models.Server, error) {
+	serverID := mux.Vars(r)["id"]
+	if err := requireV1Permission(r, permission, serverID); err != nil {
+		return nil, err
+	}
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		return nil, newAPIError(ErrCodeNotFound, "Server not found: %s", serverID)
+	}
+	return server, nil
+}
+
+// requireV1Permission checks a permission on servers. Requests without a user
+// are allowed, as by authorizeServer.
+func requireV1Permission(r *http.Request, permission auth.Permission, serverIDs ...string) error {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		return nil
+	}
+	var denied []string
+	for _, id := range serverIDs {
+		if !user.Can(id, permission) {
+			denied = append(denied, id)
+		}
+	}
+	if len(denied) > 0 {
+		return newAPIError(ErrCodeForbidden, "Permission %s required for %s", permission, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// requireV1Admin refuses requesters that are not admins
+func requireV1Admin(r *http.Request) error {
+	if user := auth.GetUserFromContext(r.Context()); user != nil && !user.HasRole(auth.RoleAdmin) {
+		return newAPIError(ErrCodeForbidden, "Admin privileges required")
+	}
+	return nil
+}
@@ -0,0 +1,682 @@
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"github.com/gorilla/mux"
+)
+
+// ServerPage is a page of servers
+type ServerPage struct {
+	Items []*models.Server `json:"items"`
+	Pagination
+}
+
+// LeasePage is a page of keep-awake leases
+type LeasePage struct {
+	Items []models.Lease `json:"items"`
+	Pagination
+}
+
+// GroupPage is a page of server groups
+type GroupPage struct {
+	Items []GroupSummary `json:"items"`
+	Pagination
+}
+
+// AlertPage is a page of alerts, firing ones first
+type AlertPage struct {
+	Items []models.Alert `json:"items"`
+	Pagination
+}
+
+// UserPage is a page of users
+type UserPage struct {
+	Items []auth.User `json:"items"`
+	Pagination
+}
+
+// GroupDetail is a group with its member servers
+type GroupDetail struct {
+	Name    string           `json:"name"`
+	Servers []*models.Server `json:"servers"`
+}
+
+// ActionRequest is the optional body of a power action
+type ActionRequest struct {
+	Reason  string `json:"reason,omitempty"`
+	Cascade bool   `json:"cascade,omitempty"` // Take running dependents down first
+}
+
+// serverAction is a power action endpoint. Actions with a state record it as
+// the desired state; restart and reset are carried out directly.
+type serverAction struct {
+	name    string
+	summary string
+	state   models.PowerState
+	force   bool
+	hard    bool
+}
+
+var serverActions = []serverAction{
+	{name: "wake", summary: "Turn a server on", state: models.PowerStateOn},
+	{name: "suspend", summary: "Suspend a server to RAM", state: models.PowerStateSuspended},
+	{name: "hibernate", summary: "Suspend a server to disk", state: models.PowerStateHibernated},
+	{name: "shutdown", summary: "Shut a server down cleanly; physical hosts are suspended", state: models.PowerStateStopped},
+	{name: "stop", summary: "Force stop a Proxmox VM", state: models.PowerStateStopped, force: true},
+	{name: "restart", summary: "Reboot a server cleanly"},
+	{name: "reset", summary: "Hard reset a Proxmox VM", hard: true},
+}
+
+// v1Routes returns the versioned API
+func (ws *WebServer) v1Routes() []v1Route {
+	serverErrors := []string{ErrCodeForbidden, ErrCodeNotFound}
+	routes := []v1Route{
+		{
+			Method: "GET", Path: "/servers", OperationID: "listServers",
+			Summary: "List the servers the requester may view, ordered by ID",
+			Query: append([]v1Param{
+				{Name: "state", Type: "string", Description: "Current power state, such as on or suspended"},
+				{Name: "group", Type: "string", Description: "Group the servers belong to"},
+				{Name: "source", Type: "string", Description: "Where the servers come from: config, api or discovered"},
+				{Name: "q", Type: "string", Description: "Text the ID, name or hostname contains, ignoring case"},
+			}, pageParams...),
+			Response: ServerPage{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeInvalidRequest},
+			handle: ws.v1ListServers,
+		},
+		{
+			Method: "POST", Path: "/servers", OperationID: "createServer",
+			Summary: "Add a server, with the fields of a [[servers]] entry (admin only)",
+			Request: config.ServerConfig{}, Response: &models.Server{}, Status: http.StatusCreated,
+			Errors: []string{ErrCodeInvalidRequest, ErrCodeValidation, ErrCodeForbidden, ErrCodeAlreadyExists},
+			handle: ws.v1CreateServer,
+		},
+		{
+			Method: "GET", Path: "/servers/{id}", OperationID: "getServer",
+			Summary:  "Get a server",
+			Response: &models.Server{}, Status: http.StatusOK,
+			Errors: serverErrors,
+			handle: ws.v1GetServer,
+		},
+		{
+			Method: "PUT", Path: "/servers/{id}", OperationID: "replaceServer",
+			Summary: "Replace the definition of a server added through the API",
+			Request: config.ServerConfig{}, Response: &models.Server{}, Status: http.StatusOK,
+			Errors:  []string{ErrCodeInvalidRequest, ErrCodeValidation, ErrCodeForbidden, ErrCodeNotFound, ErrCodeReadOnly, ErrCodePreconditionFailed},
+			IfMatch: true,
+			handle:  ws.v1ReplaceServer,
+		},
+		{
+			Method: "PATCH", Path: "/servers/{id}", OperationID: "updateServer",
+			Summary: "Change the fields present in the body of a server added through the API",
+			Request: config.ServerConfig{}, Response: &models.Server{}, Status: http.StatusOK,
+			Errors:  []string{ErrCodeInvalidRequest, ErrCodeValidation, ErrCodeForbidden, ErrCodeNotFound, ErrCodeReadOnly, ErrCodePreconditionFailed},
+			IfMatch: true,
+			handle:  ws.v1UpdateServer,
+		},
+		{
+			Method: "DELETE", Path: "/servers/{id}", OperationID: "deleteServer",
+			Summary: "Remove a server added through the API",
+			Status:  http.StatusNoContent,
+			Errors:  []string{ErrCodeForbidden, ErrCodeNotFound, ErrCodeReadOnly, ErrCodeInUse, ErrCodePreconditionFailed},
+			IfMatch: true,
+			handle:  ws.v1DeleteServer,
+		},
+		{
+			Method: "PUT", Path: "/servers/{id}/desired-state", OperationID: "setDesiredState",
+			Summary: "Record the desired power state of a server; the reconciler carries it out",
+			Request: DesiredStateRequest{}, Response: &models.Operation{}, Status: http.StatusAccepted,
+			Errors:  []string{ErrCodeInvalidRequest, ErrCodeValidation, ErrCodeForbidden, ErrCodeNotFound, ErrCodeServerLeased, ErrCodeActiveDependents, ErrCodePreconditionFailed},
+			IfMatch: true,
+			handle:  ws.v1SetDesiredState,
+		},
+		{
+			Method: "DELETE", Path: "/servers/{id}/desired-state", OperationID: "clearDesiredState",
+			Summary:  "Drop the intent of a server and return to the desired state before it",
+			Response: &models.Operation{}, Status: http.StatusAccepted,
+			Errors:  []string{ErrCodeForbidden, ErrCodeNotFound, ErrCodeConflict, ErrCodeServerLeased, ErrCodePreconditionFailed},
+			IfMatch: true,
+			handle:  ws.v1ClearDesiredState,
+		},
+		{
+			Method: "GET", Path: "/servers/{id}/leases", OperationID: "listLeases",
+			Summary:  "List the active keep-awake leases of a server",
+			Query:    pageParams,
+			Response: LeasePage{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeInvalidRequest, ErrCodeForbidden, ErrCodeNotFound},
+			handle: ws.v1ListLeases,
+		},
+		{
+			Method: "POST", Path: "/servers/{id}/leases", OperationID: "acquireLease",
+			Summary: "Keep a server on until the lease expires or is released",
+			Request: LeaseRequest{}, Response: &models.Lease{}, Status: http.StatusCreated,
+			Errors: []string{ErrCodeInvalidRequest, ErrCodeValidation, ErrCodeForbidden, ErrCodeNotFound},
+			handle: ws.v1AcquireLease,
+		},
+		{
+			Method: "PUT", Path: "/servers/{id}/leases/{lease}", OperationID: "renewLease",
+			Summary: "Extend a lease by the duration from now",
+			Request: LeaseRequest{}, Response: &models.Lease{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeInvalidRequest, ErrCodeValidation, ErrCodeForbidden, ErrCodeNotFound},
+			handle: ws.v1RenewLease,
+		},
+		{
+			Method: "DELETE", Path: "/servers/{id}/leases/{lease}", OperationID: "releaseLease",
+			Summary: "Release a lease",
+			Status:  http.StatusNoContent,
+			Errors:  []string{ErrCodeForbidden, ErrCodeNotFound},
+			handle:  ws.v1ReleaseLease,
+		},
+		{
+			Method: "GET", Path: "/servers/{id}/metrics", OperationID: "getServerMetrics",
+			Summary: "Memory, CPU, network and power use of a server over a time range",
+			Query: []v1Param{
+				{Name: "start", Type: "string", Description: "RFC 3339 start time (default: an hour before end)"},
+				{Name: "end", Type: "string", Description: "RFC 3339 end time (default: now)"},
+			},
+			Response: MetricsResponse{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeInvalidRequest, ErrCodeForbidden, ErrCodeNotFound, ErrCodeUnavailable},
+			handle: ws.v1GetServerMetrics,
+		},
+		{
+			Method: "GET", Path: "/groups", OperationID: "listGroups",
+			Summary:  "List the groups with servers the requester may view, ordered by name",
+			Query:    pageParams,
+			Response: GroupPage{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeInvalidRequest},
+			handle: ws.v1ListGroups,
+		},
+		{
+			Method: "GET", Path: "/groups/{name}", OperationID: "getGroup",
+			Summary:  "Get a group with its servers",
+			Response: GroupDetail{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeNotFound},
+			handle: ws.v1GetGroup,
+		},
+		{
+			Method: "GET", Path: "/operations/{id}", OperationID: "getOperation",
+			Summary:  "Follow the progress of a power operation",
+			Response: &models.Operation{}, Status: http.StatusOK,
+			Errors: serverErrors,
+			handle: ws.v1GetOperation,
+		},
+		{
+			Method: "GET", Path: "/alerts", OperationID: "listAlerts",
+			Summary: "List firing alerts, oldest first, then recently resolved ones, newest first",
+			Query: append([]v1Param{
+				{Name: "server", Type: "string", Description: "Server ID"},
+				{Name: "status", Type: "string", Description: "firing or resolved"},
+			}, pageParams...),
+			Response: AlertPage{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeInvalidRequest},
+			handle: ws.v1ListAlerts,
+		},
+		{
+			Method: "GET", Path: "/users", OperationID: "listUsers",
+			Summary:  "List users, ordered by username (admin only)",
+			Query:    append([]v1Param{{Name: "role", Type: "string", Description: "viewer, operator or admin"}}, pageParams...),
+			Response: UserPage{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeInvalidRequest, ErrCodeForbidden},
+			handle: ws.v1ListUsers,
+		},
+		{
+			Method: "GET", Path: "/users/me", OperationID: "getCurrentUser",
+			Summary:  "Get the requesting user",
+			Response: &auth.User{}, Status: http.StatusOK,
+			Errors: []string{ErrCodeNotFound},
+			handle: ws.v1GetCurrentUser,
+		},
+	}
+
+	for _, action := range serverActions {
+		action := action
+		routes = append(routes, v1Route{
+			Method: "POST", Path: "/servers/{id}/" + action.name, OperationID: action.name + "Server",
+			Summary: action.summary, Request: ActionRequest{}, OptionalBody: true,
+			Response: &models.Operation{}, Status: http.StatusAccepted,
+			Errors: []string{ErrCodeInvalidRequest, ErrCodeUnsupported, ErrCodeForbidden, ErrCodeNotFound, ErrCodeServerLeased, ErrCodeActiveDependents},
+			handle: func(r *http.Request) (interface{}, error) {
+				return ws.v1ServerAction(r, action)
+			},
+		})
+	}
+	return routes
+}
+
+// v1ListServers returns the servers matching the filters
+func (ws *WebServer) v1ListServers(r *http.Request) (interface{}, error) {
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	state, source := models.PowerState(query.Get("state")), models.Source(query.Get("source"))
+	text := strings.ToLower(query.Get("q"))
+
+	servers := make([]*models.Server, 0)
+	for _, server := range visibleServers(r, filterByGroup(r, ws.storage.GetAllServers())) {
+		if state != "" && server.CurrentState != state {
+			continue
+		}
+		if source != "" && server.Source != source {
+			continue
+		}
+		if text != "" && !strings.Contains(strings.ToLower(server.ID+"\n"+server.Name+"\n"+server.Hostname), text) {
+			continue
+		}
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+
+	start, end := page.bounds(len(servers))
+	return ServerPage{Items: servers[start:end], Pagination: page}, nil
+}
+
+// v1CreateServer adds a server through the API (admin only)
+func (ws *WebServer) v1CreateServer(r *http.Request) (interface{}, error) {
+	if err := requireV1Admin(r); err != nil {
+		return nil, err
+	}
+	var def config.ServerConfig
+	if err := decodeV1Body(r, &def, false); err != nil {
+		return nil, err
+	}
+	server, err := ws.registry.Create(def)
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	return server, nil
+}
+
+// v1GetServer returns the server in the path
+func (ws *WebServer) v1GetServer(r *http.Request) (interface{}, error) {
+	return ws.requireV1Server(r, auth.PermissionView)
+}
+
+// v1ReplaceServer replaces the definition of a server added through the API
+func (ws *WebServer) v1ReplaceServer(r *http.Request) (interface{}, error) {
+	serverID := mux.Vars(r)["id"]
+	if err := requireV1Permission(r, auth.PermissionConfigure, serverID); err != nil {
+		return nil, err
+	}
+	var def config.ServerConfig
+	if err := decodeV1Body(r, &def, false); err != nil {
+		return nil, err
+	}
+	server, err := ws.registry.Update(serverID, def, isAdmin(r))
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	return server, nil
+}
+
+// v1UpdateServer changes the fields present in the body of a server added
+// through the API. Lists such as services are replaced as a whole.
+func (ws *WebServer) v1UpdateServer(r *http.Request) (interface{}, error) {
+	serverID := mux.Vars(r)["id"]
+	if err := requireV1Permission(r, auth.PermissionConfigure, serverID); err != nil {
+		return nil, err
+	}
+	def, err := ws.registry.Get(serverID)
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	// Decoding onto the current definition keeps fields the body leaves out
+	if err := decodeV1Body(r, &def, false); err != nil {
+		return nil, err
+	}
+	server, err := ws.registry.Update(serverID, def, isAdmin(r))
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	return server, nil
+}
+
+// v1DeleteServer removes a server added through the API
+func (ws *WebServer) v1DeleteServer(r *http.Request) (interface{}, error) {
+	serverID := mux.Vars(r)["id"]
+	if err := requireV1Permission(r, auth.PermissionConfigure, serverID); err != nil {
+		return nil, err
+	}
+	if err := ws.registry.Delete(serverID); err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	return nil, nil
+}
+
+// v1ServerAction carries out a power action on the server in the path
+func (ws *WebServer) v1ServerAction(r *http.Request, action serverAction) (interface{}, error) {
+	var req ActionRequest
+	if err := decodeV1Body(r, &req, true); err != nil {
+		return nil, err
+	}
+
+	if action.state == "" {
+		server, err := ws.requireV1Server(r, auth.PermissionRestart)
+		if err != nil {
+			return nil, err
+		}
+		capabilities := server.GetPowerCapabilities()
+		if (action.hard && !capabilities.Reset) || (!action.hard && !capabilities.Restart) {
+			return nil, newAPIError(ErrCodeUnsupported, "The %s action is not supported on %s", action.name, server.Name)
+		}
+		return ws.monitor.RestartServer(server, action.hard, requesterName(r))
+	}
+
+	// Physical hosts have no stopped state, so shutting them down suspends them
+	state := action.state
+	if server, err := ws.storage.GetServer(mux.Vars(r)["id"]); err == nil && action.name == "shutdown" && !server.IsProxmoxVM {
+		state = models.PowerStateSuspended
+	}
+	return ws.requestV1PowerState(r, DesiredStateRequest{
+		State:   state,
+		Reason:  req.Reason,
+		Force:   action.force,
+		Cascade: req.Cascade,
+	}, ErrCodeUnsupported)
+}
+
+// v1SetDesiredState records the desired state of the server in the path
+func (ws *WebServer) v1SetDesiredState(r *http.Request) (interface{}, error) {
+	var req DesiredStateRequest
+	if err := decodeV1Body(r, &req, false); err != nil {
+		return nil, err
+	}
+	return ws.requestV1PowerState(r, req, ErrCodeValidation)
+}
+
+// requestV1PowerState checks and records a desired state. Requests the server
+// cannot carry out fail with invalidCode.
+func (ws *WebServer) requestV1PowerState(r *http.Request, req DesiredStateRequest, invalidCode string) (*models.Operation, error) {
+	// A cascade also takes the server's running dependents down
+	serverID := mux.Vars(r)["id"]
+	affected := []string{serverID}
+	if req.Cascade {
+		affected = append(affected, ws.cascadeServers(serverID)...)
+	}
+	if err := requireV1Permission(r, statePermission(req.State), affected...); err != nil {
+		return nil, err
+	}
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		return nil, newAPIError(ErrCodeNotFound, "Server not found: %s", serverID)
+	}
+
+	expiresAt, err := parseIntentExpiry(req.Duration, req.ExpiresAt)
+	if err != nil {
+		return nil, newAPIError(ErrCodeValidation, "%v", err)
+	}
+	if err := monitor.ValidateDesiredState(server, req.State, req.Force); err != nil {
+		return nil, newAPIError(invalidCode, "%v", err)
+	}
+
+	op, err := ws.monitor.RequestPowerState(server.ID, monitor.PowerStateRequest{
+		State:       req.State,
+		Reason:      req.Reason,
+		RequestedBy: requesterName(r),
+		ExpiresAt:   expiresAt,
+		Force:       req.Force,
+		Cascade:     req.Cascade,
+	})
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeInternal)
+	}
+	return op, nil
+}
+
+// v1ClearDesiredState drops the intent of the server in the path
+func (ws *WebServer) v1ClearDesiredState(r *http.Request) (interface{}, error) {
+	serverID := mux.Vars(r)["id"]
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		return nil, newAPIError(ErrCodeNotFound, "Server not found: %s", serverID)
+	}
+	if server.Intent == nil {
+		return nil, newAPIError(ErrCodeConflict, "Server %s has no active intent", server.Name)
+	}
+	// Clearing the intent returns the server to the state it had before
+	if err := requireV1Permission(r, statePermission(server.Intent.RevertTo), serverID); err != nil {
+		return nil, err
+	}
+
+	op, err := ws.monitor.ClearPowerIntent(server.ID, requesterName(r))
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeInternal)
+	}
+	return op, nil
+}
+
+// v1ListLeases returns the active leases of the server in the path
+func (ws *WebServer) v1ListLeases(r *http.Request) (interface{}, error) {
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+	server, err := ws.requireV1Server(r, auth.PermissionView)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := server.ActiveLeases(time.Now())
+	start, end := page.bounds(len(leases))
+	return LeasePage{Items: leases[start:end], Pagination: page}, nil
+}
+
+// v1AcquireLease keeps the server in the path on
+func (ws *WebServer) v1AcquireLease(r *http.Request) (interface{}, error) {
+	server, err := ws.requireV1Server(r, auth.PermissionLease)
+	if err != nil {
+		return nil, err
+	}
+	req, duration, err := decodeV1LeaseRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	holder := req.Holder
+	if holder == "" {
+		holder = requesterName(r)
+	}
+	lease, err := ws.monitor.AcquireLease(server.ID, holder, req.Reason, duration)
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	return lease, nil
+}
+
+// v1RenewLease extends the lease in the path
+func (ws *WebServer) v1RenewLease(r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	if err := requireV1Permission(r, auth.PermissionLease, vars["id"]); err != nil {
+		return nil, err
+	}
+	_, duration, err := decodeV1LeaseRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := ws.monitor.RenewLease(vars["id"], vars["lease"], duration)
+	if err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	return lease, nil
+}
+
+// v1ReleaseLease drops the lease in the path
+func (ws *WebServer) v1ReleaseLease(r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	if err := requireV1Permission(r, auth.PermissionLease, vars["id"]); err != nil {
+		return nil, err
+	}
+	if err := ws.monitor.ReleaseLease(vars["id"], vars["lease"]); err != nil {
+		return nil, asAPIError(err, ErrCodeValidation)
+	}
+	return nil, nil
+}
+
+// decodeV1LeaseRequest parses a lease request body and its duration
+func decodeV1LeaseRequest(r *http.Request) (*LeaseRequest, time.Duration, error) {
+	var req LeaseRequest
+	if err := decodeV1Body(r, &req, false); err != nil {
+		return nil, 0, err
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		return nil, 0, newAPIError(ErrCodeValidation, "Invalid duration %q", req.Duration)
+	}
+	return &req, duration, nil
+}
+
+// v1GetServerMetrics returns the metrics of the server in the path
+func (ws *WebServer) v1GetServerMetrics(r *http.Request) (interface{}, error) {
+	server, err := ws.requireV1Server(r, auth.PermissionView)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	endTime := time.Now()
+	if value := query.Get("end"); value != "" {
+		if endTime, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, newAPIError(ErrCodeInvalidRequest, "Invalid end time, use RFC 3339")
+		}
+	}
+	startTime := endTime.Add(-time.Hour)
+	if value := query.Get("start"); value != "" {
+		if startTime, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, newAPIError(ErrCodeInvalidRequest, "Invalid start time, use RFC 3339")
+		}
+	}
+	if !endTime.After(startTime) {
+		return nil, newAPIError(ErrCodeInvalidRequest, "End time must be after start time")
+	}
+
+	metricsManager := ws.monitor.GetMetricsManager()
+	if metricsManager == nil {
+		return nil, newAPIError(ErrCodeUnavailable, "Metrics system not available")
+	}
+	return ws.fetchServerMetrics(metricsManager, server.ID, startTime, endTime, ws.calculateTimePeriod(endTime.Sub(startTime))), nil
+}
+
+// v1ListGroups returns the groups with servers the requester may view
+func (ws *WebServer) v1ListGroups(r *http.Request) (interface{}, error) {
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]string)
+	for _, server := range visibleServers(r, ws.storage.GetAllServers()) {
+		for _, group := range server.Groups {
+			members[group] = append(members[group], server.ID)
+		}
+	}
+	groups := make([]GroupSummary, 0, len(members))
+	for name, servers := range members {
+		sort.Strings(servers)
+		groups = append(groups, GroupSummary{Name: name, Servers: servers})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	start, end := page.bounds(len(groups))
+	return GroupPage{Items: groups[start:end], Pagination: page}, nil
+}
+
+// v1GetGroup returns the group in the path with the servers the requester may
+// view. Groups without such servers are not found.
+func (ws *WebServer) v1GetGroup(r *http.Request) (interface{}, error) {
+	group := mux.Vars(r)["name"]
+	user := auth.GetUserFromContext(r.Context())
+	members := make([]*models.Server, 0)
+	for _, server := range ws.monitor.GroupMembers(group) {
+		if user == nil || user.Can(server.ID, auth.PermissionView) {
+			members = append(members, server)
+		}
+	}
+	if len(members) == 0 {
+		return nil, newAPIError(ErrCodeNotFound, "Group not found: %s", group)
+	}
+	return GroupDetail{Name: group, Servers: members}, nil
+}
+
+// v1GetOperation returns the operation in the path
+func (ws *WebServer) v1GetOperation(r *http.Request) (interface{}, error) {
+	operationID := mux.Vars(r)["id"]
+	op, ok := ws.monitor.GetOperation(operationID)
+	if !ok {
+		return nil, newAPIError(ErrCodeNotFound, "Operation not found: %s", operationID)
+	}
+	if err := requireV1Permission(r, auth.PermissionView, op.ServerID); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// v1ListAlerts returns the alerts of servers the requester may view
+func (ws *WebServer) v1ListAlerts(r *http.Request) (interface{}, error) {
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	status := ws.alerts.Status(query.Get("server"))
+	var alertList []models.Alert
+	switch query.Get("status") {
+	case "":
+		alertList = append(status.Firing, status.Resolved...)
+	case string(models.AlertStatusFiring):
+		alertList = status.Firing
+	case string(models.AlertStatusResolved):
+		alertList = status.Resolved
+	default:
+		return nil, newAPIError(ErrCodeInvalidRequest, "status must be firing or resolved")
+	}
+	if user := auth.GetUserFromContext(r.Context()); user != nil {
+		alertList = visibleAlerts(user, alertList)
+	}
+
+	start, end := page.bounds(len(alertList))
+	return AlertPage{Items: alertList[start:end], Pagination: page}, nil
+}
+
+// v1ListUsers returns the users (admin only)
+func (ws *WebServer) v1ListUsers(r *http.Request) (interface{}, error) {
+	if err := requireV1Admin(r); err != nil {
+		return nil, err
+	}
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	role := auth.Role(r.URL.Query().Get("role"))
+	users := make([]auth.User, 0)
+	for _, user := range ws.authManager.ListUsers() {
+		if role == "" || user.Role == role {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	start, end := page.bounds(len(users))
+	return UserPage{Items: users[start:end], Pagination: page}, nil
+}
+
+// v1GetCurrentUser returns the requesting user
+func (ws *WebServer) v1GetCurrentUser(r *http.Request) (interface{}, error) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		return nil, newAPIError(ErrCodeNotFound, "No user is logged in")
+	}
+	return user, nil
+}
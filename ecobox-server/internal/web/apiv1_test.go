@@ -0,0 +1,487 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ecobox-server/internal/alerts"
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/registry"
+	"ecobox-server/internal/storage"
+)
+
+// v1TestServer is a web server with an admin and a viewer API token
+type v1TestServer struct {
+	ws     *WebServer
+	doc    map[string]interface{}
+	admin  string
+	viewer string
+	tested map[string]bool // Operations a documented response was seen for
+}
+
+func newV1TestServer(t *testing.T) *v1TestServer {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{ID: "nas", Name: "NAS", Hostname: "192.0.2.10", MACAddress: "AA:BB:CC:DD:EE:01", Groups: []string{"lab"}},
+			{ID: "media", Name: "Media", Hostname: "192.0.2.11", MACAddress: "AA:BB:CC:DD:EE:02", Groups: []string{"lab", "media"}},
+			{ID: "backup", Name: "Backup", Hostname: "192.0.2.12", MACAddress: "AA:BB:CC:DD:EE:04"},
+		},
+	}
+	cfg.Dashboard.PasswordFile = filepath.Join(dir, "passwd.conf")
+	cfg.Dashboard.SessionKeyFile = filepath.Join(dir, "sessionkey.conf")
+	cfg.Dashboard.TokensFile = filepath.Join(dir, "api-tokens.json")
+	cfg.Dashboard.SessionsFile = filepath.Join(dir, "sessions.json")
+	cfg.Dashboard.TwoFactorFile = filepath.Join(dir, "two-factor.json")
+	cfg.Dashboard.APIServersFile = filepath.Join(dir, "api-servers.toml")
+	cfg.Dashboard.MetricsDataDir = filepath.Join(dir, "metrics")
+	cfg.SetDefaults()
+
+	store := storage.NewMemoryStorage()
+	for _, server := range cfg.Servers {
+		if err := store.AddServer(server.ToServer(models.SourceConfig)); err != nil {
+			t.Fatalf("Failed to add server %s: %v", server.ID, err)
+		}
+		if err := store.UpdateServerState(server.ID, models.PowerStateOn); err != nil {
+			t.Fatalf("Failed to set state of %s: %v", server.ID, err)
+		}
+	}
+	if err := store.UpdateServerState("backup", models.PowerStateOff); err != nil {
+		t.Fatalf("Failed to set state of backup: %v", err)
+	}
+	reg := registry.NewRegistry(cfg, store)
+	if err := reg.Load(); err != nil {
+		t.Fatalf("Registry load failed: %v", err)
+	}
+
+	am := auth.NewManager(cfg)
+	if err := am.Initialize(); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if err := am.CompleteFirstTimeSetup("correct horse battery"); err != nil {
+		t.Fatalf("CompleteFirstTimeSetup failed: %v", err)
+	}
+	if _, _, err := am.CreateUser("guest", auth.RoleViewer, nil); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	_, admin, err := am.CreateToken("admin", "contract tests", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	_, viewer, err := am.CreateToken("guest", "contract tests", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	pm := control.NewPowerManager(store)
+	mon := monitor.NewMonitor(cfg, store, pm)
+	ws := NewWebServer(cfg, store, mon, pm, reg, nil, nil, alerts.NewManager(cfg), nil, nil, am)
+
+	ts := &v1TestServer{ws: ws, admin: admin, viewer: viewer, tested: make(map[string]bool)}
+	if err := json.Unmarshal(ws.openAPI, &ts.doc); err != nil {
+		t.Fatalf("OpenAPI document is not JSON: %v", err)
+	}
+	return ts
+}
+
+// request sends a request with a token and returns the response
+func (ts *v1TestServer) request(method, path, body, token string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.100:51000"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	ts.ws.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// call sends a request as admin to a route of the document, checks the status
+// and the body against it and returns the decoded body
+func (ts *v1TestServer) call(t *testing.T, method, route, path, body string, status int) interface{} {
+	t.Helper()
+	return ts.callAs(t, ts.admin, method, route, path, body, status, nil)
+}
+
+func (ts *v1TestServer) callAs(t *testing.T, token, method, route, path, body string, status int, header map[string]string) interface{} {
+	t.Helper()
+	rec := ts.request(method, V1Prefix+path, body, token, header)
+	if rec.Code != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, rec.Code, rec.Body.String())
+	}
+	return ts.checkResponse(t, method, route, rec)
+}
+
+// checkResponse checks a response against the operation of a route
+func (ts *v1TestServer) checkResponse(t *testing.T, method, route string, rec *httptest.ResponseRecorder) interface{} {
+	t.Helper()
+	paths := ts.doc["paths"].(map[string]interface{})
+	item, ok := paths[route].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Route %s is not in the OpenAPI document", route)
+	}
+	operation, ok := item[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s %s is not in the OpenAPI document", method, route)
+	}
+	response, ok := operation["responses"].(map[string]interface{})[strconv.Itoa(rec.Code)].(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s %s: status %d is not documented: %s", method, route, rec.Code, rec.Body.String())
+	}
+	if rec.Code < 300 {
+		ts.tested[method+" "+route] = true
+	}
+
+	content, ok := response["content"].(map[string]interface{})
+	if !ok {
+		if rec.Body.Len() > 0 {
+			t.Errorf("%s %s: expected no body with status %d, got %s", method, route, rec.Code, rec.Body.String())
+		}
+		return nil
+	}
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("%s %s: expected a JSON body, got %s", method, route, contentType)
+	}
+	var body interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: invalid JSON body: %v", method, route, err)
+	}
+	schema := content["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	for _, problem := range ts.validate(schema, body, "body") {
+		t.Errorf("%s %s: %s", method, route, problem)
+	}
+
+	// Error codes always come with their status, and are the ones documented
+	if rec.Code >= 400 {
+		code, _ := body.(map[string]interface{})["error"].(map[string]interface{})["code"].(string)
+		if errorStatuses[code] != rec.Code {
+			t.Errorf("%s %s: error code %q came with status %d", method, route, code, rec.Code)
+		}
+		if description, _ := response["description"].(string); !strings.Contains(description, code) {
+			t.Errorf("%s %s: error code %q is not documented in %q", method, route, code, description)
+		}
+	}
+	if rec.Code < 300 && rec.Header().Get("ETag") == "" {
+		t.Errorf("%s %s: expected an ETag", method, route)
+	}
+	return body
+}
+
+// validate returns how a decoded JSON value does not match a schema
+func (ts *v1TestServer) validate(schema map[string]interface{}, value interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := ts.doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved reference %s", at, ref)}
+		}
+		return ts.validate(resolved, value, at)
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schema["type"] == nil {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: null is not a %v", at, schema["type"])}
+	}
+
+	var problems []string
+	mismatch := func() []string {
+		return []string{fmt.Sprintf("%s: expected %v, got %T", at, schema["type"], value)}
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: required property %s is missing", at, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, property := range object {
+			propertySchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if additional == nil {
+					problems = append(problems, fmt.Sprintf("%s: property %s is not documented", at, name))
+					continue
+				}
+				propertySchema = additional
+			}
+			problems = append(problems, ts.validate(propertySchema, property, at+"."+name)...)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range items {
+			problems = append(problems, ts.validate(schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return mismatch()
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			return mismatch()
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch()
+		}
+	}
+	return problems
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	ts := newV1TestServer(t)
+
+	// The document is served without logging in
+	rec := ts.request("GET", V1Prefix+"/openapi.json", "", "", nil)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != string(ts.ws.openAPI) {
+		t.Fatalf("Expected the OpenAPI document, got %d: %.200s", rec.Code, rec.Body.String())
+	}
+	if ts.doc["openapi"] != "3.0.3" {
+		t.Errorf("Expected OpenAPI 3.0.3, got %v", ts.doc["openapi"])
+	}
+
+	// Every route is documented once, with a unique operation ID
+	paths := ts.doc["paths"].(map[string]interface{})
+	operationIDs := make(map[string]bool)
+	for _, route := range ts.ws.v1Routes() {
+		operation, ok := paths[route.Path].(map[string]interface{})[strings.ToLower(route.Method)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s %s is not documented", route.Method, route.Path)
+			continue
+		}
+		if operationIDs[route.OperationID] {
+			t.Errorf("Operation ID %s is used twice", route.OperationID)
+		}
+		operationIDs[route.OperationID] = true
+		if operation["operationId"] != route.OperationID {
+			t.Errorf("Expected operation ID %s for %s %s, got %v", route.OperationID, route.Method, route.Path, operation["operationId"])
+		}
+	}
+
+	// Every reference resolves
+	var checkRefs func(value interface{})
+	checkRefs = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, ok := ts.doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name]; !ok {
+					t.Errorf("Unresolved reference %s", ref)
+				}
+			}
+			for _, child := range v {
+				checkRefs(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				checkRefs(child)
+			}
+		}
+	}
+	checkRefs(ts.doc)
+
+	// Every error code has a status
+	for _, route := range ts.ws.v1Routes() {
+		for _, code := range route.Errors {
+			if errorStatuses[code] == 0 {
+				t.Errorf("Error code %s of %s %s has no status", code, route.Method, route.Path)
+			}
+		}
+	}
+}
+
+func TestV1Contract(t *testing.T) {
+	ts := newV1TestServer(t)
+
+	// Listing filters and pages
+	page := ts.call(t, "GET", "/servers", "/servers?group=lab&state=on", "", http.StatusOK).(map[string]interface{})
+	if ids := itemIDs(page, "id"); strings.Join(ids, ",") != "media,nas" {
+		t.Errorf("Expected media and nas in lab, got %v", ids)
+	}
+	page = ts.call(t, "GET", "/servers", "/servers?limit=1&offset=1", "", http.StatusOK).(map[string]interface{})
+	if ids := itemIDs(page, "id"); strings.Join(ids, ",") != "media" || page["total"] != 3.0 {
+		t.Errorf("Expected the second of 3 servers, got %v of %v", ids, page["total"])
+	}
+	ts.call(t, "GET", "/servers", "/servers?limit=-1", "", http.StatusBadRequest)
+
+	// Servers added through the API
+	ts.call(t, "POST", "/servers", "/servers", `{"id":"app","name":"App","hostname":"192.0.2.20","mac_address":"AA:BB:CC:DD:EE:03"}`, http.StatusCreated)
+	ts.call(t, "POST", "/servers", "/servers", `{"id":"app","name":"App","hostname":"192.0.2.20"}`, http.StatusConflict)
+	ts.call(t, "POST", "/servers", "/servers", `{"id":"bad","unknown":true}`, http.StatusBadRequest)
+	ts.call(t, "PUT", "/servers/{id}", "/servers/app", `{"id":"app","name":"App server","hostname":"192.0.2.20","mac_address":"AA:BB:CC:DD:EE:03"}`, http.StatusOK)
+	server := ts.call(t, "PATCH", "/servers/{id}", "/servers/app", `{"groups":["apps"]}`, http.StatusOK).(map[string]interface{})
+	if server["name"] != "App server" {
+		t.Errorf("Expected PATCH to keep the name, got %v", server["name"])
+	}
+	ts.call(t, "PATCH", "/servers/{id}", "/servers/nas", `{"name":"Renamed"}`, http.StatusConflict)
+
+	// ETags answer 304 and guard changes
+	rec := ts.request("GET", V1Prefix+"/servers/app", "", ts.admin, nil)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected the server with an ETag, got %d", rec.Code)
+	}
+	ts.checkResponse(t, "GET", "/servers/{id}", rec)
+	ts.callAs(t, ts.admin, "GET", "/servers/{id}", "/servers/app", "", http.StatusNotModified, map[string]string{"If-None-Match": etag})
+	ts.callAs(t, ts.admin, "DELETE", "/servers/{id}", "/servers/app", "", http.StatusPreconditionFailed, map[string]string{"If-Match": `"stale"`})
+	ts.callAs(t, ts.admin, "DELETE", "/servers/{id}", "/servers/app", "", http.StatusNoContent, map[string]string{"If-Match": etag})
+	ts.call(t, "GET", "/servers/{id}", "/servers/app", "", http.StatusNotFound)
+
+	// Power actions and desired states
+	op := ts.call(t, "POST", "/servers/{id}/wake", "/servers/nas/wake", "", http.StatusAccepted).(map[string]interface{})
+	ts.call(t, "GET", "/operations/{id}", "/operations/"+op["id"].(string), "", http.StatusOK)
+	ts.call(t, "GET", "/operations/{id}", "/operations/missing", "", http.StatusNotFound)
+	ts.call(t, "POST", "/servers/{id}/restart", "/servers/nas/restart", `{"reason":"kernel update"}`, http.StatusUnprocessableEntity)
+	ts.call(t, "POST", "/servers/{id}/stop", "/servers/nas/stop", "", http.StatusUnprocessableEntity)
+	ts.call(t, "PUT", "/servers/{id}/desired-state", "/servers/media/desired-state", `{"state":"on","duration":"1h","reason":"backup"}`, http.StatusAccepted)
+	ts.call(t, "PUT", "/servers/{id}/desired-state", "/servers/media/desired-state", `{"state":"exploded"}`, http.StatusUnprocessableEntity)
+	ts.call(t, "DELETE", "/servers/{id}/desired-state", "/servers/media/desired-state", "", http.StatusAccepted)
+	ts.call(t, "DELETE", "/servers/{id}/desired-state", "/servers/media/desired-state", "", http.StatusConflict)
+
+	// Leases
+	lease := ts.call(t, "POST", "/servers/{id}/leases", "/servers/nas/leases", `{"holder":"ci","duration":"30m"}`, http.StatusCreated).(map[string]interface{})
+	leaseID := lease["id"].(string)
+	ts.call(t, "POST", "/servers/{id}/leases", "/servers/nas/leases", `{"duration":"forever"}`, http.StatusUnprocessableEntity)
+	ts.call(t, "GET", "/servers/{id}/leases", "/servers/nas/leases", "", http.StatusOK)
+	ts.call(t, "POST", "/servers/{id}/suspend", "/servers/nas/suspend", "", http.StatusConflict)
+	ts.call(t, "PUT", "/servers/{id}/leases/{lease}", "/servers/nas/leases/"+leaseID, `{"duration":"1h"}`, http.StatusOK)
+	ts.call(t, "DELETE", "/servers/{id}/leases/{lease}", "/servers/nas/leases/"+leaseID, "", http.StatusNoContent)
+	ts.call(t, "DELETE", "/servers/{id}/leases/{lease}", "/servers/nas/leases/"+leaseID, "", http.StatusNotFound)
+
+	// Metrics, groups, alerts and users
+	ts.call(t, "GET", "/servers/{id}/metrics", "/servers/nas/metrics", "", http.StatusOK)
+	ts.call(t, "GET", "/servers/{id}/metrics", "/servers/nas/metrics?start=yesterday", "", http.StatusBadRequest)
+	groups := ts.call(t, "GET", "/groups", "/groups", "", http.StatusOK).(map[string]interface{})
+	if names := itemIDs(groups, "name"); strings.Join(names, ",") != "lab,media" {
+		t.Errorf("Expected groups lab and media, got %v", names)
+	}
+	ts.call(t, "GET", "/groups/{name}", "/groups/lab", "", http.StatusOK)
+	ts.call(t, "GET", "/groups/{name}", "/groups/none", "", http.StatusNotFound)
+	ts.call(t, "GET", "/alerts", "/alerts?status=firing", "", http.StatusOK)
+	ts.call(t, "GET", "/alerts", "/alerts?status=sleeping", "", http.StatusBadRequest)
+	users := ts.call(t, "GET", "/users", "/users?role=viewer", "", http.StatusOK).(map[string]interface{})
+	if names := itemIDs(users, "username"); strings.Join(names, ",") != "guest" {
+		t.Errorf("Expected the guest viewer, got %v", names)
+	}
+	ts.call(t, "GET", "/users/me", "/users/me", "", http.StatusOK)
+
+	// Viewers may look but not change
+	ts.callAs(t, ts.viewer, "GET", "/servers/{id}", "/servers/nas", "", http.StatusOK, nil)
+	ts.callAs(t, ts.viewer, "POST", "/servers/{id}/shutdown", "/servers/nas/shutdown", "", http.StatusForbidden, nil)
+	ts.callAs(t, ts.viewer, "GET", "/users", "/users", "", http.StatusForbidden, nil)
+
+	// Requests without credentials get a v1 error
+	ts.callAs(t, "", "GET", "/servers", "/servers", "", http.StatusUnauthorized, nil)
+
+	var untested []string
+	for _, route := range ts.ws.v1Routes() {
+		if !ts.tested[route.Method+" "+route.Path] && !isUntestedAction(route.Path) {
+			untested = append(untested, route.Method+" "+route.Path)
+		}
+	}
+	sort.Strings(untested)
+	if len(untested) > 0 {
+		t.Errorf("Expected a successful call of every route, missing %v", untested)
+	}
+}
+
+// isUntestedAction reports power actions the test cannot carry out without a
+// real server to act on
+func isUntestedAction(path string) bool {
+	for _, action := range []string{"suspend", "hibernate", "shutdown", "stop", "restart", "reset"} {
+		if path == "/servers/{id}/"+action {
+			return true
+		}
+	}
+	return false
+}
+
+func TestV1Errors(t *testing.T) {
+	ts := newV1TestServer(t)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{"GET", "/nothing/here", http.StatusNotFound, ErrCodeNotFound},
+		{"POST", "/groups", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{"GET", "/servers/missing", http.StatusNotFound, ErrCodeNotFound},
+	}
+	for _, test := range tests {
+		rec := ts.request(test.method, V1Prefix+test.path, "", ts.admin, nil)
+		var body ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s %s: expected an error body, got %s", test.method, test.path, rec.Body.String())
+			continue
+		}
+		if rec.Code != test.status || body.Error.Code != test.code {
+			t.Errorf("%s %s: expected %d %s, got %d %s", test.method, test.path, test.status, test.code, rec.Code, body.Error.Code)
+		}
+	}
+
+	// The legacy API keeps its error format
+	rec := ts.request("GET", "/api/servers", "", "", nil)
+	var legacy APIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &legacy); err != nil || rec.Code != http.StatusUnauthorized || legacy.Success {
+		t.Errorf("Expected a legacy 401, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// itemIDs returns a field of the items of a page
+func itemIDs(page map[string]interface{}, field string) []string {
+	var ids []string
+	items, _ := page["items"].([]interface{})
+	for _, item := range items {
+		if id, ok := item.(map[string]interface{})[field].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestHSTSBehindTrustedProxies(t *testing.T) {
+	ts := newV1TestServer(t)
+	forwarded := map[string]string{"X-Forwarded-Proto": "https"}
+
+	// Anyone can claim HTTPS, so only trusted proxies are believed
+	if rec := ts.request("GET", "/api/v1/servers", "", ts.admin, forwarded); rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("Expected no HSTS for X-Forwarded-Proto from an untrusted address")
+	}
+	ts.ws.config.Dashboard.TrustedProxies = []string{"192.0.2.100"}
+	if rec := ts.request("GET", "/api/v1/servers", "", ts.admin, forwarded); rec.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("Expected HSTS for X-Forwarded-Proto from a trusted proxy")
+	}
+}
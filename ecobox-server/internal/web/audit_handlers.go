@@ -0,0 +1,307 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecobox-server/internal/audit"
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/models"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultAuditLimit is the page size of audit queries without a limit
+	defaultAuditLimit = 100
+	// maxAuditLimit caps the page size of audit queries
+	maxAuditLimit = 1000
+	// auditErrorBody bounds how much of a failed response is kept for its message
+	auditErrorBody = 4096
+)
+
+// auditPage is the response of an audit query
+type auditPage struct {
+	Entries []audit.Entry `json:"entries"`
+	Total   int           `json:"total"` // Matching entries across all pages
+	Offset  int           `json:"offset"`
+	Limit   int           `json:"limit"`
+}
+
+// serverSnapshot is the part of a server recorded before and after a change
+type serverSnapshot struct {
+	Name         string              `json:"name"`
+	CurrentState models.PowerState   `json:"current_state"`
+	DesiredState models.PowerState   `json:"desired_state"`
+	Intent       *models.PowerIntent `json:"intent,omitempty"`
+	Groups       []string            `json:"groups,omitempty"`
+	Services     []string            `json:"services,omitempty"`
+}
+
+// handleGetAudit returns audit entries, newest first (admin only). Entries can
+// be filtered by actor, actor_type, action, target, since, until (RFC 3339) and
+// success, and paged with limit and offset. With format=csv every matching
+// entry is returned as a CSV download unless a limit is given.
+func (ws *WebServer) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	csvExport := r.URL.Query().Get("format") == "csv"
+	filter, err := parseAuditFilter(r, csvExport)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	entries, total, err := ws.audit.Query(filter)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read audit log: %v", err),
+		}
+		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+
+	if csvExport {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		if err := audit.WriteCSV(w, entries); err != nil {
+			ws.logger.Errorf("Failed to write audit CSV: %v", err)
+		}
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data: auditPage{
+			Entries: entries,
+			Total:   total,
+			Offset:  filter.Offset,
+			Limit:   filter.Limit,
+		},
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// parseAuditFilter reads an audit query's parameters. Without a limit, JSON
+// queries return the first page and exports return everything.
+func parseAuditFilter(r *http.Request, export bool) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:     query.Get("actor"),
+		ActorType: query.Get("actor_type"),
+		Action:    query.Get("action"),
+		Target:    query.Get("target"),
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s time format. Use ISO 8601 format.", name)
+			}
+			*t = parsed
+		}
+	}
+
+	if value := query.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("success must be true or false")
+		}
+		filter.Success = &success
+	}
+
+	if !export {
+		filter.Limit = defaultAuditLimit
+	}
+	for name, n := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return filter, fmt.Errorf("%s must be a non-negative number", name)
+			}
+			*n = parsed
+		}
+	}
+	if filter.Limit > maxAuditLimit && !export {
+		filter.Limit = maxAuditLimit
+	}
+
+	return filter, nil
+}
+
+// auditMiddleware records every API request that changes something: who made
+// it, the route, the response status and, for servers and users, the target
+// before and after the request
+func (ws *WebServer) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		target := auditTarget(r)
+		before := ws.auditSnapshot(route, target)
+
+		recorder := &auditRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		entry := ws.requestAuditEntry(r, r.Method+" "+route, target)
+		entry.Status = recorder.statusCode
+		entry.Success = recorder.statusCode < 400
+		entry.Before = before
+		if entry.Success {
+			entry.After = ws.auditSnapshot(route, target)
+		} else {
+			entry.Error = recorder.errorMessage()
+		}
+		ws.audit.Record(entry)
+	})
+}
+
+// requestAuditEntry starts an audit entry for a request, identifying the
+// actor from the authenticated user and how they were authenticated
+func (ws *WebServer) requestAuditEntry(r *http.Request, action, target string) audit.Entry {
+	entry := audit.Entry{
+		Actor:      requesterName(r),
+		ActorType:  audit.ActorAnonymous,
+		Action:     action,
+		Target:     target,
+		RemoteAddr: ws.authManager.RemoteIP(r),
+	}
+
+	switch auth.GetAuthSourceFromContext(r.Context()) {
+	case auth.AuthSourceIAP:
+		entry.ActorType = audit.ActorIAP
+	case auth.AuthSourceSession:
+		entry.ActorType = audit.ActorUser
+	case auth.AuthSourceToken:
+		entry.ActorType = audit.ActorToken
+	}
+	return entry
+}
+
+// auditTarget returns the server, user or other object a request acts on: the
+// route's id, username or name, or for creation requests the id or username in
+// the JSON body
+func auditTarget(r *http.Request) string {
+	vars := mux.Vars(r)
+	for _, key := range []string{"id", "username", "name"} {
+		if value := vars[key]; value != "" {
+			return value
+		}
+	}
+
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body)) // Leave the body for the handler
+	if err != nil {
+		return ""
+	}
+
+	var created struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	json.Unmarshal(body, &created)
+	if created.ID != "" {
+		return created.ID
+	}
+	return created.Username
+}
+
+// auditSnapshot describes the server or user a route acts on, or returns nil
+// for other routes and targets that do not exist
+func (ws *WebServer) auditSnapshot(route, target string) json.RawMessage {
+	if target == "" {
+		return nil
+	}
+
+	// Versioned routes act on the same objects
+	route = strings.Replace(route, V1Prefix+"/", "/api/", 1)
+	switch {
+	case route == "/api/servers" || strings.HasPrefix(route, "/api/servers/{id}"):
+		server, err := ws.storage.GetServer(target)
+		if err != nil {
+			return nil
+		}
+		snapshot := serverSnapshot{
+			Name:         server.Name,
+			CurrentState: server.CurrentState,
+			DesiredState: server.DesiredState,
+			Intent:       server.Intent,
+			Groups:       server.Groups,
+		}
+		for _, service := range server.Services {
+			snapshot.Services = append(snapshot.Services, service.Name)
+		}
+		return audit.Snapshot(snapshot)
+	case strings.HasPrefix(route, "/api/auth/users"):
+		user, exists := ws.authManager.GetUser(target)
+		if !exists {
+			return nil
+		}
+		return audit.Snapshot(user)
+	}
+	return nil
+}
+
+// auditRecorder captures the status of a response and the start of its body
+// when it fails, for the error message
+type auditRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *auditRecorder) WriteHeader(code int) {
+	rec.statusCode = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *auditRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode >= 400 && rec.body.Len() < auditErrorBody {
+		rec.body.Write(b[:min(len(b), auditErrorBody-rec.body.Len())])
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// errorMessage returns the message of a failed API response, or the status text
+func (rec *auditRecorder) errorMessage() string {
+	var response APIResponse
+	if err := json.Unmarshal(rec.body.Bytes(), &response); err == nil && response.Message != "" {
+		return response.Message
+	}
+	var v1Response ErrorResponse
+	if err := json.Unmarshal(rec.body.Bytes(), &v1Response); err == nil && v1Response.Error.Message != "" {
+		return v1Response.Error.Message
+	}
+	return http.StatusText(rec.statusCode)
+}
@@ -2,10 +2,14 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"text/template"
 	"time"
 
+	"ecobox-server/internal/audit"
 	"ecobox-server/internal/auth"
 	"github.com/gorilla/mux"
 )
@@ -23,6 +27,12 @@ func (ws *WebServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The second step of a login that needs a second factor
+	if loginToken := r.FormValue("login_token"); loginToken != "" {
+		ws.handleSecondFactor(w, r, loginToken)
+		return
+	}
+
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
@@ -32,15 +42,96 @@ func (ws *WebServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authenticate user
-	token, user, err := ws.authManager.Login(username, password)
+	entry := ws.requestAuditEntry(r, "auth.login", username)
+	entry.Actor = username
+	entry.ActorType = audit.ActorUser
+	tokens, user, err := ws.authManager.Login(username, password, ws.authManager.RemoteIP(r), r.UserAgent())
+	var secondFactor *auth.SecondFactorRequiredError
+	if errors.As(err, &secondFactor) {
+		// The login is audited once the second factor is checked
+		ws.renderSecondFactorPage(w, r, secondFactor.Pending, "")
+		return
+	}
+	if message, throttled := loginThrottled(w, err); throttled {
+		ws.logger.Warnf("Login for user %s from %s throttled: %v", username, ws.authManager.RemoteIP(r), err)
+		entry.Error = err.Error()
+		ws.audit.Record(entry)
+		ws.renderLoginPage(w, r, message)
+		return
+	}
 	if err != nil {
 		ws.logger.Warnf("Login failed for user %s: %v", username, err)
+		entry.Error = "Invalid username or password"
+		ws.audit.Record(entry)
 		ws.renderLoginPage(w, r, "Invalid username or password")
 		return
 	}
+	entry.Success = true
+	ws.audit.Record(entry)
+
+	ws.completeLogin(w, r, tokens, user)
+
+	// Redirect to main page
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handleSecondFactor checks the TOTP or recovery code of a login waiting for
+// one, or the first code of the TOTP enrollment the login asked for
+func (ws *WebServer) handleSecondFactor(w http.ResponseWriter, r *http.Request, loginToken string) {
+	pending, exists := ws.authManager.PendingLogin(loginToken)
+	if !exists {
+		ws.renderLoginPage(w, r, "Login expired, please enter your password again")
+		return
+	}
+
+	entry := ws.requestAuditEntry(r, "auth.login", pending.Username)
+	entry.Actor = pending.Username
+	entry.ActorType = audit.ActorUser
+	tokens, user, err := ws.authManager.CompleteLogin(loginToken, r.FormValue("code"), ws.authManager.RemoteIP(r), r.UserAgent())
+	if err != nil {
+		ws.logger.Warnf("Second factor failed for user %s: %v", pending.Username, err)
+		entry.Error = "Invalid authentication code"
+		message, throttled := loginThrottled(w, err)
+		if throttled {
+			entry.Error = err.Error()
+		} else {
+			message = "Invalid authentication code"
+		}
+		ws.audit.Record(entry)
+		if pending, exists := ws.authManager.PendingLogin(loginToken); exists {
+			ws.renderSecondFactorPage(w, r, pending, message)
+		} else {
+			ws.renderLoginPage(w, r, "Too many invalid codes, please enter your password again")
+		}
+		return
+	}
+	entry.Success = true
+	ws.audit.Record(entry)
+
+	ws.completeLogin(w, r, tokens, user)
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// loginThrottled returns the message for a login refused after too many
+// failures, and tells the client when to retry
+func loginThrottled(w http.ResponseWriter, err error) (string, bool) {
+	var throttled *auth.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return "", false
+	}
+	seconds := int(throttled.RetryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return fmt.Sprintf("Too many failed logins, try again in %s", throttled.RetryAfter.Round(time.Second)), true
+}
 
-	// Set authentication cookie
-	auth.SetAuthCookie(w, token)
+// completeLogin sets the session cookies of a new session and records the login
+func (ws *WebServer) completeLogin(w http.ResponseWriter, r *http.Request, tokens *auth.SessionTokens, user *auth.User) {
+	// Set authentication cookies
+	ws.authManager.SetSessionCookies(w, r, tokens)
 
 	// Update last login time
 	user.LastLogin = time.Now()
@@ -48,16 +139,24 @@ func (ws *WebServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		ws.logger.Errorf("Failed to update last login time: %v", err)
 	}
 
-	ws.logger.Infof("User %s logged in successfully", username)
-
-	// Redirect to main page
-	http.Redirect(w, r, "/", http.StatusFound)
+	ws.logger.Infof("User %s logged in successfully", user.Username)
 }
 
-// handleLogout handles logout requests
+// handleLogout handles logout requests, ending the session on the server so its
+// tokens stop working even if they were copied
 func (ws *WebServer) handleLogout(w http.ResponseWriter, r *http.Request) {
-	// Clear authentication cookie
-	auth.ClearAuthCookie(w)
+	if sessionID := auth.GetSessionIDFromContext(r.Context()); sessionID != "" {
+		if err := ws.authManager.RevokeSession(sessionID); err != nil {
+			ws.logger.Warnf("Failed to revoke session %s on logout: %v", sessionID, err)
+		}
+	}
+	
+	// Clear authentication cookies
+	ws.authManager.ClearAuthCookie(w, r)
+
+	entry := ws.requestAuditEntry(r, "auth.logout", requesterName(r))
+	entry.Success = true
+	ws.audit.Record(entry)
 
 	// For API requests, return JSON
 	if r.Header.Get("Accept") == "application/json" {
@@ -105,11 +204,16 @@ func (ws *WebServer) handleSetup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Complete first-time setup
+	entry := ws.requestAuditEntry(r, "auth.setup", "admin")
 	if err := ws.authManager.CompleteFirstTimeSetup(password); err != nil {
 		ws.logger.Errorf("First-time setup failed: %v", err)
+		entry.Error = err.Error()
+		ws.audit.Record(entry)
 		ws.renderSetupPage(w, r, "Setup failed. Please try again.")
 		return
 	}
+	entry.Success = true
+	ws.audit.Record(entry)
 
 	ws.logger.Info("First-time setup completed successfully")
 
@@ -155,7 +259,7 @@ func (ws *WebServer) handleChangePassword(w http.ResponseWriter, r *http.Request
 	}
 
 	// For IAP users, current password is not required
-	requireCurrentPassword := ws.config.Dashboard.IAPAuth == "none"
+	requireCurrentPassword := ws.config.LiveDashboard().IAPAuth == "none"
 
 	if err := ws.authManager.ChangePassword(user.Username, req.CurrentPassword, req.NewPassword, requireCurrentPassword); err != nil {
 		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
@@ -167,6 +271,14 @@ func (ws *WebServer) handleChangePassword(w http.ResponseWriter, r *http.Request
 
 	ws.logger.Infof("Password changed successfully for user %s", user.Username)
 
+	// Sign out everywhere else, in case the old password was compromised
+	revoked, err := ws.authManager.RevokeUserSessions(user.Username, auth.GetSessionIDFromContext(r.Context()))
+	if err != nil {
+		ws.logger.Errorf("Failed to revoke sessions of %s after a password change: %v", user.Username, err)
+	} else if revoked > 0 {
+		ws.logger.Infof("Revoked %d other sessions of %s after a password change", revoked, user.Username)
+	}
+
 	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Password changed successfully",
@@ -176,7 +288,7 @@ func (ws *WebServer) handleChangePassword(w http.ResponseWriter, r *http.Request
 // handleGetUsers returns a list of all users (admin only)
 func (ws *WebServer) handleGetUsers(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUserFromContext(r.Context())
-	if user == nil || user.Username != "admin" {
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
 		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
 			Success: false,
 			Message: "Admin privileges required",
@@ -197,7 +309,7 @@ func (ws *WebServer) handleGetUsers(w http.ResponseWriter, r *http.Request) {
 // handleCreateUser creates a new user (admin only)
 func (ws *WebServer) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUserFromContext(r.Context())
-	if user == nil || user.Username != "admin" {
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
 		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
 			Success: false,
 			Message: "Admin privileges required",
@@ -214,7 +326,12 @@ func (ws *WebServer) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newUser, password, err := ws.authManager.CreateUser(req.Username, req.IsAdmin)
+	role := req.Role
+	if role == "" && req.IsAdmin {
+		role = auth.RoleAdmin
+	}
+
+	newUser, password, err := ws.authManager.CreateUser(req.Username, role, req.Grants)
 	if err != nil {
 		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
@@ -235,10 +352,68 @@ func (ws *WebServer) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleSetUserAccess replaces the role and per-server grants of a user (admin
+// only). The change applies to the user's next request.
+func (ws *WebServer) handleSetUserAccess(w http.ResponseWriter, r *http.Request) {
+	currentUser := auth.GetUserFromContext(r.Context())
+	if currentUser == nil || !currentUser.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+
+	var req auth.UserAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	// Prevent locking yourself out of user management
+	if username == currentUser.Username && req.Role != auth.RoleAdmin {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Cannot remove your own admin role",
+		})
+		return
+	}
+
+	if _, exists := ws.authManager.GetUser(username); !exists {
+		ws.writeJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	user, err := ws.authManager.SetUserAccess(username, req.Role, req.Grants)
+	if err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ws.logger.Infof("Access of user %s set to %s with %d grants by %s", username, req.Role, len(req.Grants), currentUser.Username)
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "User access updated successfully",
+		Data:    user,
+	})
+}
+
 // handleDeleteUser deletes a user (admin only)
 func (ws *WebServer) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	currentUser := auth.GetUserFromContext(r.Context())
-	if currentUser == nil || currentUser.Username != "admin" {
+	if currentUser == nil || !currentUser.HasRole(auth.RoleAdmin) {
 		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
 			Success: false,
 			Message: "Admin privileges required",
@@ -285,7 +460,7 @@ func (ws *WebServer) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 // handleUsersPage renders the user management page
 func (ws *WebServer) handleUsersPage(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUserFromContext(r.Context())
-	if user == nil || user.Username != "admin" {
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
 		http.Error(w, "Admin privileges required", http.StatusForbidden)
 		return
 	}
@@ -307,6 +482,16 @@ func (ws *WebServer) handleChangePasswordPage(w http.ResponseWriter, r *http.Req
 // Template rendering methods
 
 func (ws *WebServer) renderLoginPage(w http.ResponseWriter, r *http.Request, errorMsg string) {
+	ws.renderLoginTemplate(w, r, nil, errorMsg)
+}
+
+// renderSecondFactorPage renders the second step of a login: a code form, a
+// passkey button, or TOTP enrollment for a user who must enroll first
+func (ws *WebServer) renderSecondFactorPage(w http.ResponseWriter, r *http.Request, pending *auth.PendingLogin, errorMsg string) {
+	ws.renderLoginTemplate(w, r, pending, errorMsg)
+}
+
+func (ws *WebServer) renderLoginTemplate(w http.ResponseWriter, r *http.Request, pending *auth.PendingLogin, errorMsg string) {
 	tmplContent := `<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -387,29 +572,114 @@ func (ws *WebServer) renderLoginPage(w http.ResponseWriter, r *http.Request, err
             margin-bottom: 1rem;
             text-align: center;
         }
+        .btn-passkey {
+            width: 100%;
+            padding: 0.75rem;
+            margin-top: 0.75rem;
+            background: white;
+            color: #667eea;
+            border: 2px solid #667eea;
+            border-radius: 5px;
+            font-size: 1rem;
+            font-weight: 600;
+            cursor: pointer;
+        }
+        .hint {
+            color: #555;
+            margin-bottom: 1rem;
+        }
+        .secret {
+            font-family: monospace;
+            word-break: break-all;
+            background: #f7fafc;
+            padding: 0.75rem;
+            border-radius: 5px;
+            margin-bottom: 1rem;
+        }
+        .recovery-codes {
+            display: grid;
+            grid-template-columns: 1fr 1fr;
+            gap: 0.25rem;
+            font-family: monospace;
+            margin-bottom: 1rem;
+        }
+        .btn-sso {
+            display: block;
+            box-sizing: border-box;
+            text-align: center;
+            text-decoration: none;
+        }
+        .start-over {
+            display: block;
+            text-align: center;
+            margin-top: 1rem;
+        }
     </style>
+    <script src="/static/js/passkeys.js"></script>
 </head>
 <body>
     <div class="login-container">
-        <form class="login-form" method="POST">
+        <form class="login-form" method="POST" action="/login">
             <h1>Network Dashboard</h1>
-            {{if .Error}}
-                <div class="error">{{.Error}}</div>
-            {{end}}
+            <div class="error" id="error"{{if not .Error}} hidden{{end}}>{{.Error}}</div>
             {{if .Message}}
                 <div class="success">{{.Message}}</div>
             {{end}}
+            {{if .Pending}}
+            <input type="hidden" name="login_token" value="{{.Pending.Token}}">
+            {{with .Pending.Enrollment}}
+            <p class="hint">Your account requires two-factor authentication. Add this key to an authenticator app, or <a href="{{.URI}}">open it in one</a>, then enter the code it shows.</p>
+            <div class="secret">{{.Secret}}</div>
+            <p class="hint">Save these recovery codes. Each one logs you in once if you lose your authenticator.</p>
+            <div class="recovery-codes">{{range .RecoveryCodes}}<span>{{.}}</span>{{end}}</div>
+            {{else}}
+            <p class="hint">{{if .CodeAllowed}}Enter the code from your authenticator app{{if .RecoveryAllowed}} or a recovery code{{end}}.{{else}}Confirm the login with your passkey.{{end}}</p>
+            {{end}}
+            {{if .CodeAllowed}}
+            <div class="form-group">
+                <label for="code">Authentication code</label>
+                <input type="text" id="code" name="code" inputmode="numeric" autocomplete="one-time-code" autofocus required>
+            </div>
+            <button type="submit" class="btn-login">Verify</button>
+            {{end}}
+            {{if .PasskeyAllowed}}
+            <button type="button" class="btn-passkey" onclick="loginWithPasskey('{{.Pending.Token}}')">Use a passkey</button>
+            {{end}}
+            <a href="/login" class="start-over">Start over</a>
+            {{else}}
             <div class="form-group">
                 <label for="username">Username</label>
-                <input type="text" id="username" name="username" required>
+                <input type="text" id="username" name="username" autocomplete="username webauthn" required>
             </div>
             <div class="form-group">
                 <label for="password">Password</label>
                 <input type="password" id="password" name="password" required>
             </div>
             <button type="submit" class="btn-login">Login</button>
+            <button type="button" class="btn-passkey" id="passkey-login" onclick="loginWithPasskey('')" hidden>Sign in with a passkey</button>
+            {{if .SSOLabel}}
+            <a href="/login/oidc" class="btn-passkey btn-sso">{{.SSOLabel}}</a>
+            {{end}}
+            {{end}}
         </form>
     </div>
+
+    <script>
+        if (passkeys.supported()) {
+            document.getElementById('passkey-login')?.removeAttribute('hidden');
+        }
+
+        async function loginWithPasskey(loginToken) {
+            try {
+                await passkeys.login(loginToken);
+                window.location.href = '/';
+            } catch (error) {
+                const errorArea = document.getElementById('error');
+                errorArea.textContent = error.message;
+                errorArea.hidden = false;
+            }
+        }
+    </script>
 </body>
 </html>`
 
@@ -421,11 +691,33 @@ func (ws *WebServer) renderLoginPage(w http.ResponseWriter, r *http.Request, err
 	}
 
 	data := struct {
-		Error   string
-		Message string
+		Error           string
+		Message         string
+		Pending         *auth.PendingLogin
+		CodeAllowed     bool
+		RecoveryAllowed bool
+		PasskeyAllowed  bool
+		SSOLabel        string // OIDC login button, when configured
 	}{
 		Error:   errorMsg,
 		Message: r.URL.Query().Get("message"),
+		Pending: pending,
+	}
+	if ws.authManager.OIDCEnabled() {
+		data.SSOLabel = ws.config.OIDC.ButtonLabel
+	}
+	if pending != nil {
+		for _, method := range pending.Methods {
+			switch method {
+			case "totp":
+				data.CodeAllowed = true
+			case "recovery":
+				data.CodeAllowed = true
+				data.RecoveryAllowed = true
+			case "passkey":
+				data.PasskeyAllowed = true
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -633,6 +925,7 @@ func (ws *WebServer) renderUsersPage(w http.ResponseWriter, r *http.Request, cur
             background: #4a5568;
         }
     </style>
+    <script src="/static/js/csrf.js"></script>
 </head>
 <body>
     <div class="user-management">
@@ -650,6 +943,7 @@ func (ws *WebServer) renderUsersPage(w http.ResponseWriter, r *http.Request, cur
                     <th>Username</th>
                     <th>Created</th>
                     <th>Last Login</th>
+                    <th>Two-Factor</th>
                     <th>Actions</th>
                 </tr>
             </thead>
@@ -692,6 +986,11 @@ func (ws *WebServer) renderUsersPage(w http.ResponseWriter, r *http.Request, cur
                     <td>${user.username}</td>
                     <td>${createdAt}</td>
                     <td>${lastLogin}</td>
+                    <td>
+                        ${user.two_factor_enabled ? 'Enabled' : 'Not enabled'}${user.two_factor_required ? ' (required)' : ''}
+                        <button onclick="setTwoFactor('${user.username}', { required: ${!user.two_factor_required} })" class="btn btn-secondary">${user.two_factor_required ? 'Stop Requiring' : 'Require'}</button>
+                        ${user.two_factor_enabled ? ` + "`" + `<button onclick="setTwoFactor('${user.username}', { reset: true })" class="btn btn-danger">Reset</button>` + "`" + ` : ''}
+                    </td>
                     <td>
                         ${user.username !== '{{.CurrentUser}}' && user.username !== 'admin' ? 
                             ` + "`" + `<button onclick="deleteUser('${user.username}')" class="btn btn-danger">Delete</button>` + "`" + ` : 
@@ -733,6 +1032,30 @@ func (ws *WebServer) renderUsersPage(w http.ResponseWriter, r *http.Request, cur
             }
         }
 
+        async function setTwoFactor(username, change) {
+            if (change.reset && !confirm(` + "`" + `Remove every second factor of "${username}"? They will log in with their password alone, or enroll again if required.` + "`" + `)) return;
+
+            try {
+                const response = await fetch(` + "`" + `/api/auth/users/${username}/2fa` + "`" + `, {
+                    method: 'PUT',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify(change)
+                });
+
+                const result = await response.json();
+                
+                if (result.success) {
+                    loadUsers();
+                } else {
+                    alert('Failed to update two-factor settings: ' + result.message);
+                }
+            } catch (error) {
+                alert('Error updating two-factor settings: ' + error.message);
+            }
+        }
+
         async function deleteUser(username) {
             if (!confirm(` + "`" + `Are you sure you want to delete user "${username}"?` + "`" + `)) return;
 
@@ -858,7 +1181,32 @@ func (ws *WebServer) renderChangePasswordPage(w http.ResponseWriter, r *http.Req
             justify-content: space-between;
             margin-top: 2rem;
         }
+        .two-factor {
+            margin-top: 2rem;
+            padding-top: 1rem;
+            border-top: 1px solid #e2e8f0;
+        }
+        .two-factor ul {
+            list-style: none;
+            padding: 0;
+        }
+        .two-factor li {
+            display: flex;
+            justify-content: space-between;
+            align-items: center;
+            padding: 0.5rem 0;
+        }
+        .secret, .recovery-codes {
+            font-family: monospace;
+            word-break: break-all;
+            background: #f7fafc;
+            padding: 0.75rem;
+            border-radius: 5px;
+            margin-bottom: 1rem;
+        }
     </style>
+    <script src="/static/js/csrf.js"></script>
+    <script src="/static/js/passkeys.js"></script>
 </head>
 <body>
     <div class="password-container">
@@ -886,6 +1234,33 @@ func (ws *WebServer) renderChangePasswordPage(w http.ResponseWriter, r *http.Req
                 <button type="submit" class="btn btn-primary">Change Password</button>
             </div>
         </form>
+
+        <div class="two-factor">
+            <h2>Two-Factor Authentication</h2>
+            <p id="two-factor-summary"></p>
+            <div id="totp-enrollment" hidden>
+                <p>Add this key to an authenticator app, or <a id="totp-uri">open it in one</a>, then enter the code it shows.</p>
+                <div class="secret" id="totp-secret"></div>
+                <div class="form-group">
+                    <label for="totp_code">Authentication code</label>
+                    <input type="text" id="totp_code" inputmode="numeric" autocomplete="one-time-code">
+                </div>
+                <button onclick="confirmTOTP()" class="btn btn-primary">Enable</button>
+            </div>
+            <div id="recovery-codes" hidden>
+                <p>Save these recovery codes. Each one logs you in once if you lose your second factors.</p>
+                <div class="recovery-codes" id="recovery-codes-list"></div>
+            </div>
+            <h3>Passkeys</h3>
+            <ul id="passkey-list"></ul>
+            <div class="actions">
+                <button onclick="toggleTOTP()" class="btn btn-secondary" id="totp-button"></button>
+                <button onclick="addPasskey()" class="btn btn-primary" id="passkey-button">Add Passkey</button>
+            </div>
+            <div class="actions">
+                <button onclick="regenerateRecoveryCodes()" class="btn btn-secondary" id="recovery-button">New Recovery Codes</button>
+            </div>
+        </div>
     </div>
 
     <script>
@@ -934,6 +1309,131 @@ func (ws *WebServer) renderChangePasswordPage(w http.ResponseWriter, r *http.Req
             const messageArea = document.getElementById('message-area');
             messageArea.innerHTML = ` + "`" + `<div class="${type}">${message}</div>` + "`" + `;
         }
+
+        let twoFactor = null;
+
+        async function api(method, url, body) {
+            const response = await fetch(url, {
+                method: method,
+                headers: { 'Content-Type': 'application/json' },
+                body: body ? JSON.stringify(body) : undefined
+            });
+            const result = await response.json();
+            if (!result.success) {
+                throw new Error(result.message);
+            }
+            return result.data;
+        }
+
+        function askPassword() {
+            return {{if .RequireCurrentPassword}}{ password: prompt('Enter your password to confirm:') || '' }{{else}}{}{{end}};
+        }
+
+        function showRecoveryCodes(codes) {
+            document.getElementById('recovery-codes-list').textContent = codes.join('  ');
+            document.getElementById('recovery-codes').hidden = false;
+        }
+
+        async function loadTwoFactor() {
+            try {
+                twoFactor = await api('GET', '/api/auth/2fa');
+            } catch (error) {
+                showMessage('Error loading two-factor settings: ' + error.message, 'error');
+                return;
+            }
+
+            const enabled = twoFactor.totp_enabled || twoFactor.passkeys.length > 0;
+            let summary = enabled ? 'Enabled. ' + twoFactor.recovery_codes_left + ' recovery codes left.' : 'Not enabled.';
+            if (twoFactor.required) {
+                summary += ' Required for your account by an administrator.';
+            }
+            document.getElementById('two-factor-summary').textContent = summary;
+            document.getElementById('totp-button').textContent = twoFactor.totp_enabled ? 'Disable Authenticator App' : 'Set Up Authenticator App';
+            document.getElementById('passkey-button').hidden = !passkeys.supported();
+            document.getElementById('recovery-button').hidden = !enabled;
+
+            const list = document.getElementById('passkey-list');
+            list.innerHTML = '';
+            twoFactor.passkeys.forEach(passkey => {
+                const item = document.createElement('li');
+                const name = document.createElement('span');
+                name.textContent = passkey.name + ' (added ' + new Date(passkey.created_at).toLocaleDateString() + ')';
+                const remove = document.createElement('button');
+                remove.className = 'btn btn-secondary';
+                remove.textContent = 'Remove';
+                remove.onclick = () => removePasskey(passkey.id);
+                item.append(name, remove);
+                list.appendChild(item);
+            });
+            if (twoFactor.passkeys.length === 0) {
+                list.innerHTML = '<li>No passkeys</li>';
+            }
+        }
+
+        async function toggleTOTP() {
+            try {
+                if (twoFactor.totp_enabled) {
+                    await api('DELETE', '/api/auth/2fa/totp', askPassword());
+                    showMessage('Authenticator app disabled', 'success');
+                    loadTwoFactor();
+                    return;
+                }
+                const enrollment = await api('POST', '/api/auth/2fa/totp');
+                document.getElementById('totp-secret').textContent = enrollment.secret;
+                document.getElementById('totp-uri').href = enrollment.uri;
+                document.getElementById('totp-enrollment').hidden = false;
+                showRecoveryCodes(enrollment.recovery_codes);
+            } catch (error) {
+                showMessage(error.message, 'error');
+            }
+        }
+
+        async function confirmTOTP() {
+            try {
+                await api('POST', '/api/auth/2fa/totp/confirm', { code: document.getElementById('totp_code').value });
+                document.getElementById('totp-enrollment').hidden = true;
+                showMessage('Authenticator app enabled', 'success');
+                loadTwoFactor();
+            } catch (error) {
+                showMessage(error.message, 'error');
+            }
+        }
+
+        async function addPasskey() {
+            const name = prompt('Name for this passkey:', 'Passkey');
+            if (name === null) return;
+            try {
+                const result = await passkeys.register(name);
+                if (result.recovery_codes) {
+                    showRecoveryCodes(result.recovery_codes);
+                }
+                showMessage('Passkey added', 'success');
+                loadTwoFactor();
+            } catch (error) {
+                showMessage('Error adding passkey: ' + error.message, 'error');
+            }
+        }
+
+        async function removePasskey(id) {
+            try {
+                await api('DELETE', '/api/auth/2fa/passkeys/' + encodeURIComponent(id), askPassword());
+                showMessage('Passkey removed', 'success');
+                loadTwoFactor();
+            } catch (error) {
+                showMessage(error.message, 'error');
+            }
+        }
+
+        async function regenerateRecoveryCodes() {
+            try {
+                const result = await api('POST', '/api/auth/2fa/recovery-codes', askPassword());
+                showRecoveryCodes(result.recovery_codes);
+            } catch (error) {
+                showMessage(error.message, 'error');
+            }
+        }
+
+        loadTwoFactor();
     </script>
 </body>
 </html>`
@@ -950,7 +1450,7 @@ func (ws *WebServer) renderChangePasswordPage(w http.ResponseWriter, r *http.Req
 		RequireCurrentPassword bool
 	}{
 		Error:                  errorMsg,
-		RequireCurrentPassword: ws.config.Dashboard.IAPAuth == "none",
+		RequireCurrentPassword: ws.config.LiveDashboard().IAPAuth == "none",
 	}
 
 	w.Header().Set("Content-Type", "text/html")
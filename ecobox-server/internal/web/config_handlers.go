@@ -0,0 +1,71 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"ecobox-server/internal/auth"
+)
+
+// handleExportConfig returns the effective configuration as TOML (admin only).
+// With include_api_servers=true the servers added through the API are included.
+func (ws *WebServer) handleExportConfig(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	data, err := ws.reloader.Export(r.URL.Query().Get("include_api_servers") == "true")
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to export configuration: %v", err),
+		}
+		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/toml")
+	w.Header().Set("Content-Disposition", `attachment; filename="config.toml"`)
+	w.Write(data)
+}
+
+// handleReloadConfig reloads the configuration file (admin only). An invalid file
+// is rejected with 400 and the running configuration is kept.
+func (ws *WebServer) handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	report, err := ws.reloader.Reload()
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to reload configuration: %v", err),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	message := "Configuration reloaded"
+	if !report.HasChanges() {
+		message = "Configuration reloaded, nothing changed"
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: message,
+		Data:    report,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,155 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/discovery"
+	"github.com/gorilla/mux"
+)
+
+// handleGetDiscovery returns the discovery state and the current candidates
+// (admin, or users who may configure a server)
+func (ws *WebServer) handleGetDiscovery(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !(user.HasRole(auth.RoleAdmin) || user.CanAny(auth.PermissionConfigure)) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Configure permission required",
+		})
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    ws.discovery.Status(),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleStartDiscovery starts a sweep of the configured networks, or of the
+// networks in the body (admin only). The sweep runs in the background.
+func (ws *WebServer) handleStartDiscovery(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	var req struct {
+		Networks []string `json:"networks"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	if err := ws.discovery.Scan(req.Networks); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, discovery.ErrScanRunning) {
+			status = http.StatusConflict
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to start discovery scan: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: "Discovery scan started",
+	}
+
+	ws.writeJSONResponse(w, http.StatusAccepted, response)
+}
+
+// handleAdoptCandidate adds a discovered candidate as a server (admin only). The
+// body is optional and overrides the values taken from the candidate.
+func (ws *WebServer) handleAdoptCandidate(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	candidateID := mux.Vars(r)["id"]
+
+	var req discovery.AdoptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	server, err := ws.discovery.Adopt(candidateID, req)
+	if err != nil {
+		status := registryErrorStatus(err)
+		if errors.Is(err, discovery.ErrCandidateNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to adopt candidate: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Server %s added", server.Name),
+		Data:    server,
+	}
+
+	ws.writeJSONResponse(w, http.StatusCreated, response)
+}
+
+// handleDismissCandidate removes a candidate and leaves it out of later sweeps
+// (admin only)
+func (ws *WebServer) handleDismissCandidate(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	candidateID := mux.Vars(r)["id"]
+	if err := ws.discovery.Dismiss(candidateID); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Candidate %s dismissed", candidateID),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
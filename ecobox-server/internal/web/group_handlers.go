@@ -0,0 +1,260 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"github.com/gorilla/mux"
+)
+
+// maxGroupWait caps how long a group action request may wait for its operations
+const maxGroupWait = 10 * time.Minute
+
+// GroupSummary lists the members of a server group
+type GroupSummary struct {
+	Name    string   `json:"name"`
+	Servers []string `json:"servers"` // Server IDs
+}
+
+// GroupsRequest replaces the groups of a server
+type GroupsRequest struct {
+	Groups []string `json:"groups"`
+}
+
+// GroupActionRequest represents a power action for every server in a group
+type GroupActionRequest struct {
+	Reason    string     `json:"reason"`
+	Duration  string     `json:"duration,omitempty"`   // Go duration such as "2h"; reverts afterwards
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Alternative to duration
+	Cascade   bool       `json:"cascade,omitempty"`    // Take running dependents outside the group down first
+	Wait      string     `json:"wait,omitempty"`       // Go duration to wait for the operations to finish
+}
+
+// handleGetGroups returns every group with its member servers
+func (ws *WebServer) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	members := make(map[string][]string)
+	for _, server := range visibleServers(r, ws.storage.GetAllServers()) {
+		for _, group := range server.Groups {
+			members[group] = append(members[group], server.ID)
+		}
+	}
+
+	groups := make([]GroupSummary, 0, len(members))
+	for name, servers := range members {
+		sort.Strings(servers)
+		groups = append(groups, GroupSummary{Name: name, Servers: servers})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	response := APIResponse{
+		Success: true,
+		Data:    groups,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleGetGroup returns the servers in a group
+func (ws *WebServer) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["name"]
+
+	user := auth.GetUserFromContext(r.Context())
+	var members []*models.Server
+	for _, server := range ws.monitor.GroupMembers(group) {
+		if user == nil || user.Can(server.ID, auth.PermissionView) {
+			members = append(members, server)
+		}
+	}
+	if len(members) == 0 {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Group not found: %s", group),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    members,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleSetServerGroups replaces the groups a server belongs to
+func (ws *WebServer) handleSetServerGroups(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionConfigure) {
+		return
+	}
+
+	var req GroupsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	existing, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server not found: %s", serverID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	// Servers added through the API keep their groups in the saved definition
+	if existing.Source == models.SourceAPI {
+		def, err := ws.registry.Get(serverID)
+		if err == nil {
+			def.Groups = req.Groups
+			_, err = ws.registry.Update(serverID, def, isAdmin(r))
+		}
+		if err != nil {
+			response := APIResponse{
+				Success: false,
+				Message: err.Error(),
+			}
+			ws.writeJSONResponse(w, registryErrorStatus(err), response)
+			return
+		}
+	}
+
+	server, err := ws.monitor.SetServerGroups(serverID, req.Groups)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Groups of %s updated", server.Name),
+		Data:    server,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleGroupAction wakes, suspends or shuts down every server in a group and
+// reports the outcome per server. The request body is optional.
+func (ws *WebServer) handleGroupAction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["name"]
+	action := monitor.GroupAction(vars["action"])
+
+	var req GroupActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	expiresAt, err := parseIntentExpiry(req.Duration, req.ExpiresAt)
+	var wait time.Duration
+	if err == nil && req.Wait != "" {
+		wait, err = time.ParseDuration(req.Wait)
+		if err == nil && (wait < 0 || wait > maxGroupWait) {
+			err = fmt.Errorf("wait must be between 0 and %s", maxGroupWait)
+		}
+	}
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	// Group actions are named after the permission they need on every member
+	// and, when cascading, on the dependents taken down with them
+	switch action {
+	case monitor.GroupActionWake, monitor.GroupActionSuspend, monitor.GroupActionShutdown:
+		var affected []string
+		for _, server := range ws.monitor.GroupMembers(group) {
+			affected = append(affected, server.ID)
+		}
+		if req.Cascade {
+			affected = append(affected, ws.cascadeServers(affected...)...)
+		}
+		if !ws.authorizeServers(w, r, affected, auth.Permission(action)) {
+			return
+		}
+	}
+
+	report, err := ws.monitor.RunGroupAction(group, monitor.GroupRequest{
+		Action:      action,
+		Reason:      req.Reason,
+		RequestedBy: requesterName(r),
+		ExpiresAt:   expiresAt,
+		Cascade:     req.Cascade,
+		Wait:        wait,
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, monitor.ErrGroupNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to %s group %s: %v", action, group, err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	// Partial failures are reported per server with 207 Multi-Status
+	status := http.StatusOK
+	if report.Failed > 0 || report.Skipped > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	response := APIResponse{
+		Success: report.Failed == 0 && report.Skipped == 0,
+		Message: fmt.Sprintf("Group %s %s: %d succeeded, %d failed, %d skipped", group, action, report.Succeeded, report.Failed, report.Skipped),
+		Data:    report,
+	}
+
+	ws.writeJSONResponse(w, status, response)
+}
+
+// filterByGroup keeps the servers in the group named by the request's group query
+// parameter; without one all servers are kept
+func filterByGroup(r *http.Request, servers map[string]*models.Server) map[string]*models.Server {
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		return servers
+	}
+
+	filtered := make(map[string]*models.Server)
+	for id, server := range servers {
+		if server.InGroup(group) {
+			filtered[id] = server
+		}
+	}
+	return filtered
+}
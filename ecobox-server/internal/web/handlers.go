@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"text/template"
 	"time"
 
@@ -36,6 +37,7 @@ func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
     <link rel="stylesheet" href="/static/css/dashboard.css">
     <link rel="stylesheet" href="/static/css/metrics.css">
 	    <script src="https://cdnjs.cloudflare.com/ajax/libs/d3/7.8.5/d3.min.js"></script>
+	    <script src="/static/js/csrf.js"></script>
 
 </head>
 <body>
@@ -49,7 +51,7 @@ func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
                     {{if .User}}
                     <div class="user-menu">
                         <span class="username">{{.User.Username}}</span>
-                        {{if eq .User.Username "admin"}}
+                        {{if .User.IsAdmin}}
                         <a href="/users" class="btn btn-sm">Manage Users</a>
                         {{end}}
                         <a href="/change-password" class="btn btn-sm">Change Password</a>
@@ -109,7 +111,7 @@ func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 // handleGetServers returns JSON list of all servers
 func (ws *WebServer) handleGetServers(w http.ResponseWriter, r *http.Request) {
-	servers := ws.storage.GetAllServers()
+	servers := visibleServers(r, filterByGroup(r, ws.storage.GetAllServers()))
 	
 	// Convert map to slice for JSON response
 	serverList := make([]*models.Server, 0, len(servers))
@@ -144,6 +146,10 @@ func (ws *WebServer) handleGetServer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	serverID := vars["id"]
 
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionView) {
+		return
+	}
+
 	server, err := ws.storage.GetServer(serverID)
 	if err != nil {
 		response := APIResponse{
@@ -162,171 +168,146 @@ func (ws *WebServer) handleGetServer(w http.ResponseWriter, r *http.Request) {
 	ws.writeJSONResponse(w, http.StatusOK, response)
 }
 
-// handleWakeServer handles wake requests for a server
+// handleWakeServer records a request to turn a server on
 func (ws *WebServer) handleWakeServer(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	serverID := vars["id"]
-
-	server, err := ws.storage.GetServer(serverID)
-	if err != nil {
-		response := APIResponse{
-			Success: false,
-			Message: fmt.Sprintf("Server not found: %s", serverID),
-		}
-		ws.writeJSONResponse(w, http.StatusNotFound, response)
-		return
-	}
+	ws.requestPowerState(w, r, models.PowerStateOn, false, "Wake")
+}
 
-	// Set desired state to "on"
-	server.DesiredState = models.PowerStateOn
-	if err := ws.storage.UpdateServer(server); err != nil {
-		ws.logger.Errorf("Failed to update server desired state: %v", err)
-	}
+// handleSuspendServer records a request to suspend a server
+func (ws *WebServer) handleSuspendServer(w http.ResponseWriter, r *http.Request) {
+	ws.requestPowerState(w, r, models.PowerStateSuspended, false, "Suspend")
+}
 
-	// Attempt to wake the server
-	if err := ws.powerManager.WakeServer(server); err != nil {
-		response := APIResponse{
-			Success: false,
-			Message: fmt.Sprintf("Failed to wake server: %v", err),
-		}
-		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
-		return
+// handleShutdownServer records a request for a clean shutdown of a server.
+// Physical hosts have no stopped state, so for them this is a suspend.
+func (ws *WebServer) handleShutdownServer(w http.ResponseWriter, r *http.Request) {
+	state := models.PowerStateSuspended
+	if server, err := ws.storage.GetServer(mux.Vars(r)["id"]); err == nil && server.IsProxmoxVM {
+		state = models.PowerStateStopped
 	}
+	ws.requestPowerState(w, r, state, false, "Shutdown")
+}
 
-	response := APIResponse{
-		Success: true,
-		Message: fmt.Sprintf("Wake signal sent to %s", server.Name),
-	}
+// handleStopServer records a request to force stop a server (Proxmox VMs only)
+func (ws *WebServer) handleStopServer(w http.ResponseWriter, r *http.Request) {
+	ws.requestPowerState(w, r, models.PowerStateStopped, true, "Stop")
+}
 
-	ws.writeJSONResponse(w, http.StatusOK, response)
+// handleHibernateServer records a request to suspend a server to disk
+func (ws *WebServer) handleHibernateServer(w http.ResponseWriter, r *http.Request) {
+	ws.requestPowerState(w, r, models.PowerStateHibernated, false, "Hibernate")
 }
 
-// handleSuspendServer handles suspend requests for a server
-func (ws *WebServer) handleSuspendServer(w http.ResponseWriter, r *http.Request) {
+// requestPowerState records a desired state for the server in the URL on behalf of
+// the legacy action endpoints. The reconciler carries it out; the response
+// contains the operation to follow.
+func (ws *WebServer) requestPowerState(w http.ResponseWriter, r *http.Request, state models.PowerState, force bool, label string) {
 	vars := mux.Vars(r)
 	serverID := vars["id"]
+	cascade := r.URL.Query().Get("cascade") == "true"
 
-	server, err := ws.storage.GetServer(serverID)
-	if err != nil {
-		response := APIResponse{
-			Success: false,
-			Message: fmt.Sprintf("Server not found: %s", serverID),
-		}
-		ws.writeJSONResponse(w, http.StatusNotFound, response)
+	if !ws.authorizeServer(w, r, serverID, statePermission(state)) {
 		return
 	}
-
-	// Set desired state to "suspended"
-	server.DesiredState = models.PowerStateSuspended
-	if err := ws.storage.UpdateServer(server); err != nil {
-		ws.logger.Errorf("Failed to update server desired state: %v", err)
-	}
-
-	// Attempt to suspend the server
-	if err := ws.powerManager.SuspendServer(server); err != nil {
-		response := APIResponse{
-			Success: false,
-			Message: fmt.Sprintf("Failed to suspend server: %v", err),
-		}
-		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
+	// Cascading takes dependents down too, which needs the same permission on them
+	if cascade && !ws.authorizeServers(w, r, ws.cascadeServers(serverID), statePermission(state)) {
 		return
 	}
 
-	response := APIResponse{
-		Success: true,
-		Message: fmt.Sprintf("Suspend command sent to %s", server.Name),
-	}
-
-	ws.writeJSONResponse(w, http.StatusOK, response)
-}
-
-// handleShutdownServer handles clean shutdown requests for a server
-func (ws *WebServer) handleShutdownServer(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	serverID := vars["id"]
-
 	server, err := ws.storage.GetServer(serverID)
 	if err != nil {
 		response := APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Server not found: %v", err),
+			Message: fmt.Sprintf("Server not found: %s", serverID),
 		}
 		ws.writeJSONResponse(w, http.StatusNotFound, response)
 		return
 	}
 
-	// Set desired state to "stopped"
-	server.DesiredState = models.PowerStateStopped
-
-	if err := ws.storage.UpdateServer(server); err != nil {
+	if err := monitor.ValidateDesiredState(server, state, force); err != nil {
 		response := APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to update server state: %v", err),
+			Message: err.Error(),
 		}
-		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
 		return
 	}
 
-	// Attempt to shutdown the server
-	if err := ws.powerManager.ShutdownServer(server); err != nil {
+	op, err := ws.monitor.RequestPowerState(server.ID, monitor.PowerStateRequest{
+		State:       state,
+		RequestedBy: requesterName(r),
+		Force:       force,
+		Cascade:     cascade,
+	})
+	if err != nil {
 		response := APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to shutdown server: %v", err),
+			Message: fmt.Sprintf("Failed to request %s: %v", strings.ToLower(label), err),
 		}
-		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
+		ws.writeJSONResponse(w, powerStateErrorStatus(err), response)
 		return
 	}
 
 	response := APIResponse{
 		Success: true,
-		Message: fmt.Sprintf("Shutdown command sent to %s", server.Name),
+		Message: fmt.Sprintf("%s requested for %s", label, server.Name),
+		Data:    op,
 	}
 
-	ws.writeJSONResponse(w, http.StatusOK, response)
+	ws.writeJSONResponse(w, http.StatusAccepted, response)
 }
 
-// handleStopServer handles force stop requests for a server (Proxmox VMs only)
-func (ws *WebServer) handleStopServer(w http.ResponseWriter, r *http.Request) {
+// handleRestartServer handles clean reboot requests for a server
+func (ws *WebServer) handleRestartServer(w http.ResponseWriter, r *http.Request) {
+	ws.handleRebootServer(w, r, false)
+}
+
+// handleResetServer handles hard reset requests for a server (Proxmox VMs only)
+func (ws *WebServer) handleResetServer(w http.ResponseWriter, r *http.Request) {
+	ws.handleRebootServer(w, r, true)
+}
+
+// handleRebootServer restarts a server through the monitor so the restart is tracked.
+// The desired state is left alone, as the server is expected to come back on.
+func (ws *WebServer) handleRebootServer(w http.ResponseWriter, r *http.Request, hard bool) {
 	vars := mux.Vars(r)
 	serverID := vars["id"]
 
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionRestart) {
+		return
+	}
+
 	server, err := ws.storage.GetServer(serverID)
 	if err != nil {
 		response := APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Server not found: %v", err),
+			Message: fmt.Sprintf("Server not found: %s", serverID),
 		}
 		ws.writeJSONResponse(w, http.StatusNotFound, response)
 		return
 	}
 
-	// Check if this is a Proxmox VM
-	if !server.IsProxmoxVM {
-		response := APIResponse{
-			Success: false,
-			Message: "Force stop is only supported for Proxmox VMs",
-		}
-		ws.writeJSONResponse(w, http.StatusBadRequest, response)
-		return
+	action, label := "restart", "Restart"
+	supported := server.GetPowerCapabilities().Restart
+	if hard {
+		action, label = "reset", "Reset"
+		supported = server.GetPowerCapabilities().Reset
 	}
 
-	// Set desired state to "stopped"
-	server.DesiredState = models.PowerStateStopped
-
-	if err := ws.storage.UpdateServer(server); err != nil {
+	if !supported {
 		response := APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to update server state: %v", err),
+			Message: fmt.Sprintf("The %s action is not supported on %s", action, server.Name),
 		}
-		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
 		return
 	}
 
-	// Attempt to force stop the server
-	if err := ws.powerManager.StopServer(server); err != nil {
+	op, err := ws.monitor.RestartServer(server, hard, requesterName(r))
+	if err != nil {
 		response := APIResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to stop server: %v", err),
+			Message: fmt.Sprintf("Failed to %s server: %v", action, err),
 		}
 		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
 		return
@@ -334,7 +315,8 @@ func (ws *WebServer) handleStopServer(w http.ResponseWriter, r *http.Request) {
 
 	response := APIResponse{
 		Success: true,
-		Message: fmt.Sprintf("Stop command sent to %s", server.Name),
+		Message: fmt.Sprintf("%s command sent to %s", label, server.Name),
+		Data:    op,
 	}
 
 	ws.writeJSONResponse(w, http.StatusOK, response)
@@ -342,8 +324,13 @@ func (ws *WebServer) handleStopServer(w http.ResponseWriter, r *http.Request) {
 
 // handleWebSocket upgrades connection to WebSocket and manages real-time updates
 func (ws *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Authenticate the WebSocket connection
-	user, err := ws.authManager.AuthenticateRequest(r)
+	// Authenticate the WebSocket connection. The auth middleware has usually
+	// done so already, possibly refreshing an expired session on the way.
+	user := auth.GetUserFromContext(r.Context())
+	var err error
+	if user == nil {
+		user, err = ws.authManager.AuthenticateRequest(r)
+	}
 	if err != nil {
 		ws.logger.Errorf("WebSocket authentication failed: %v", err)
 		http.Error(w, "Authentication required", http.StatusUnauthorized)
@@ -358,13 +345,13 @@ func (ws *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	ws.logger.WithField("user", user.Username).Info("New WebSocket connection established")
 
-	// Add client to the list
+	// Add client to the list; it only receives updates for servers the user may view
 	ws.mu.Lock()
-	ws.wsClients[conn] = true
+	ws.wsClients[conn] = user.Username
 	ws.mu.Unlock()
 
 	// Send current server states immediately
-	go ws.sendInitialData(conn)
+	go ws.sendInitialData(conn, user.Username)
 
 	// Handle connection cleanup when client disconnects
 	defer func() {
@@ -394,12 +381,17 @@ func (ws *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// sendInitialData sends current server states to a new WebSocket client
-func (ws *WebServer) sendInitialData(conn *websocket.Conn) {
+// sendInitialData sends the current states of the servers a user may view to a
+// new WebSocket client
+func (ws *WebServer) sendInitialData(conn *websocket.Conn, username string) {
 	servers := ws.storage.GetAllServers()
 	metricsManager := ws.monitor.GetMetricsManager()
 	
 	for _, server := range servers {
+		if !ws.canView(username, server.ID) {
+			continue
+		}
+
 		// Get current metrics for this server
 		var metrics map[string]float64
 		if metricsManager != nil {
@@ -462,18 +454,23 @@ type MetricsResponse struct {
 func (ws *WebServer) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
 	serverID := r.URL.Query().Get("server")
+	group := r.URL.Query().Get("group")
 	startTimeStr := r.URL.Query().Get("start")
 	endTimeStr := r.URL.Query().Get("end")
 	
-	if serverID == "" {
+	if serverID == "" && group == "" {
 		response := APIResponse{
 			Success: false,
-			Message: "Missing required parameter: server",
+			Message: "Missing required parameter: server or group",
 		}
 		ws.writeJSONResponse(w, http.StatusBadRequest, response)
 		return
 	}
 	
+	if serverID != "" && !ws.authorizeServer(w, r, serverID, auth.PermissionView) {
+		return
+	}
+	
 	if startTimeStr == "" || endTimeStr == "" {
 		response := APIResponse{
 			Success: false,
@@ -529,6 +526,32 @@ func (ws *WebServer) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
 	timeRange := endTime.Sub(startTime)
 	timePeriodSec := ws.calculateTimePeriod(timeRange)
 	
+	// A group returns the metrics of each member, keyed by server ID
+	if serverID == "" {
+		groupMetrics := make(map[string]MetricsResponse)
+		for id := range visibleServers(r, filterByGroup(r, ws.storage.GetAllServers())) {
+			groupMetrics[id] = ws.fetchServerMetrics(metricsManager, id, startTime, endTime, timePeriodSec)
+		}
+
+		response := APIResponse{
+			Success: true,
+			Data:    groupMetrics,
+		}
+
+		ws.writeJSONResponse(w, http.StatusOK, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    ws.fetchServerMetrics(metricsManager, serverID, startTime, endTime, timePeriodSec),
+	}
+	
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// fetchServerMetrics collects the standard metrics of a server for a time range
+func (ws *WebServer) fetchServerMetrics(metricsManager *metrics.Manager, serverID string, startTime, endTime time.Time, timePeriodSec int) MetricsResponse {
 	// Fetch metrics data for each metric type
 	metricsResponse := MetricsResponse{
 		Memory:  []MetricDataPoint{},
@@ -557,12 +580,7 @@ func (ws *WebServer) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
 		metricsResponse.Wattage = ws.convertSummaryToDataPoints(wattageData)
 	}
 	
-	response := APIResponse{
-		Success: true,
-		Data:    metricsResponse,
-	}
-	
-	ws.writeJSONResponse(w, http.StatusOK, response)
+	return metricsResponse
 }
 
 // handleGetAvailableMetrics returns all available metrics for a server
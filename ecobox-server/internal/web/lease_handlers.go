@@ -0,0 +1,196 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"github.com/gorilla/mux"
+)
+
+// LeaseRequest represents a request to acquire or renew a keep-awake lease
+type LeaseRequest struct {
+	Holder   string `json:"holder,omitempty"` // Defaults to the authenticated user
+	Reason   string `json:"reason,omitempty"`
+	Duration string `json:"duration"` // Go duration such as "30m"
+}
+
+// handleGetLeases returns the active keep-awake leases of a server
+func (ws *WebServer) handleGetLeases(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionView) {
+		return
+	}
+
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server not found: %s", serverID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	leases := server.ActiveLeases(time.Now())
+	if leases == nil {
+		leases = []models.Lease{}
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    leases,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleAcquireLease keeps a server on until the lease expires or is released
+func (ws *WebServer) handleAcquireLease(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionLease) {
+		return
+	}
+
+	req, duration, ok := ws.decodeLeaseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := ws.storage.GetServer(serverID); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server not found: %s", serverID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	holder := req.Holder
+	if holder == "" {
+		holder = requesterName(r)
+	}
+
+	lease, err := ws.monitor.AcquireLease(serverID, holder, req.Reason, duration)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to acquire lease: %v", err),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Lease held by %s until %s", lease.Holder, lease.ExpiresAt.Format(time.RFC3339)),
+		Data:    lease,
+	}
+
+	ws.writeJSONResponse(w, http.StatusCreated, response)
+}
+
+// handleRenewLease extends a lease by the requested duration from now
+func (ws *WebServer) handleRenewLease(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+	leaseID := vars["lease"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionLease) {
+		return
+	}
+
+	_, duration, ok := ws.decodeLeaseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	lease, err := ws.monitor.RenewLease(serverID, leaseID, duration)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to renew lease: %v", err),
+		}
+		ws.writeJSONResponse(w, leaseErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Lease renewed until %s", lease.ExpiresAt.Format(time.RFC3339)),
+		Data:    lease,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleReleaseLease drops a lease; the server returns to its previous desired
+// state once no leases are left
+func (ws *WebServer) handleReleaseLease(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+	leaseID := vars["lease"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionLease) {
+		return
+	}
+
+	if err := ws.monitor.ReleaseLease(serverID, leaseID); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to release lease: %v", err),
+		}
+		ws.writeJSONResponse(w, leaseErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Lease %s released", leaseID),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// decodeLeaseRequest parses a lease request body and its duration, writing an
+// error response if either is invalid
+func (ws *WebServer) decodeLeaseRequest(w http.ResponseWriter, r *http.Request) (*LeaseRequest, time.Duration, bool) {
+	var req LeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return nil, 0, false
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid duration %q", req.Duration),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return nil, 0, false
+	}
+
+	return &req, duration, true
+}
+
+// leaseErrorStatus maps a failed lease change to an HTTP status
+func leaseErrorStatus(err error) int {
+	if errors.Is(err, monitor.ErrLeaseNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
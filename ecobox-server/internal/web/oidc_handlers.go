@@ -0,0 +1,157 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"ecobox-server/internal/audit"
+	"ecobox-server/internal/auth"
+)
+
+// oidcStateCookie ties an OIDC callback to the browser that started the login
+const oidcStateCookie = "oidc_state"
+
+// handleBeginOIDCLogin sends the browser to the OIDC provider to log in
+func (ws *WebServer) handleBeginOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := ws.authManager.BeginOIDCLogin(r)
+	if err != nil {
+		ws.logger.Errorf("Failed to start OIDC login: %v", err)
+		ws.renderLoginPage(w, r, "Single sign-on is unavailable, please try again later")
+		return
+	}
+
+	// Lax, as the provider sends the browser back with a cross-site redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   ws.authManager.SecureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback finishes an OIDC login when the provider sends the
+// browser back with an authorization code
+func (ws *WebServer) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/oidc", MaxAge: -1})
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		ws.logger.Warnf("OIDC provider refused the login: %s %s", providerErr, query.Get("error_description"))
+		ws.renderLoginPage(w, r, "Single sign-on failed: "+providerErr)
+		return
+	}
+
+	// The state must be the one this browser was given, so nobody can log a
+	// victim into the attacker's account with a callback link
+	cookie, err := r.Cookie(oidcStateCookie)
+	state := query.Get("state")
+	if err != nil || state == "" || cookie.Value != state {
+		ws.renderLoginPage(w, r, "Single sign-on expired, please try again")
+		return
+	}
+
+	entry := ws.requestAuditEntry(r, "auth.login", "")
+	tokens, user, err := ws.authManager.FinishOIDCLogin(r.Context(), state, query.Get("code"), ws.authManager.RemoteIP(r), r.UserAgent())
+	if err != nil {
+		ws.logger.Warnf("OIDC login failed: %v", err)
+		entry.Error = err.Error()
+		ws.audit.Record(entry)
+		message := "Single sign-on failed"
+		switch {
+		case errors.Is(err, auth.ErrOIDCDenied):
+			message = "Your account is not allowed to use this dashboard"
+		case errors.Is(err, auth.ErrOIDCState):
+			message = "Single sign-on expired, please try again"
+		}
+		ws.renderLoginPage(w, r, message)
+		return
+	}
+	entry.Actor = user.Username
+	entry.ActorType = audit.ActorUser
+	entry.Target = user.Username
+	entry.Success = true
+	ws.audit.Record(entry)
+
+	ws.completeLogin(w, r, tokens, user)
+
+	// The session cookies are SameSite=Strict, so browsers leave them off a
+	// redirect that started on the provider's site. Navigating from a page on
+	// this site sends them.
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(`<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0;url=/"></head>` +
+		`<body><a href="/">Continue to the dashboard</a></body></html>`))
+}
+
+// handleSetUserOIDCLink links a user to the sub claim of an account at the OIDC
+// provider, or to an identity of the identity-aware proxy, or removes the link
+// (admin only). Only linked users can log in with OIDC or through the proxy.
+func (ws *WebServer) handleSetUserOIDCLink(w http.ResponseWriter, r *http.Request) {
+	currentUser := auth.GetUserFromContext(r.Context())
+	if currentUser == nil || !currentUser.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	if _, exists := ws.authManager.GetUser(username); !exists {
+		ws.writeJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	var req auth.OIDCLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if req.Provider == "" {
+		req.Provider = "oidc"
+	}
+	var user *auth.User
+	var err error
+	switch req.Provider {
+	case "oidc":
+		user, err = ws.authManager.LinkOIDC(username, req.Subject)
+	case "iap":
+		user, err = ws.authManager.LinkIAP(username, req.Subject)
+	default:
+		err = fmt.Errorf("unknown provider '%s', must be oidc or iap", req.Provider)
+	}
+	if err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if req.Subject == "" {
+		ws.logger.Infof("%s link of user %s removed by %s", strings.ToUpper(req.Provider), username, currentUser.Username)
+	} else {
+		ws.logger.Infof("User %s linked to %s subject %s by %s", username, strings.ToUpper(req.Provider), req.Subject, currentUser.Username)
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "OIDC link updated successfully",
+		Data:    user,
+	})
+}
@@ -0,0 +1,258 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecobox-server/internal/models"
+)
+
+// openAPIVersion is the version of the versioned API in its OpenAPI document
+const openAPIVersion = "1.0.0"
+
+// pathVariable matches the {name} variables of a route path
+var pathVariable = regexp.MustCompile(`\{([^}]+)\}`)
+
+// marshalerFields lists the properties types add in their MarshalJSON, which
+// reflection cannot see
+var marshalerFields = map[reflect.Type][]schemaField{
+	reflect.TypeOf(models.Server{}): {{name: "capabilities", typ: reflect.TypeOf(models.PowerCapabilities{})}},
+}
+
+// schemaField is a property added to the schema of a type
+type schemaField struct {
+	name string
+	typ  reflect.Type
+}
+
+// schemaBuilder collects the named schemas of an OpenAPI document
+type schemaBuilder struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+}
+
+// generateOpenAPI returns the OpenAPI 3 document of the versioned API
+func generateOpenAPI(routes []v1Route) []byte {
+	b := &schemaBuilder{schemas: make(map[string]interface{}), names: make(map[reflect.Type]string)}
+	errorRef := b.schema(reflect.TypeOf(ErrorResponse{}), false)
+
+	paths := make(map[string]map[string]interface{})
+	for _, route := range routes {
+		if paths[route.Path] == nil {
+			paths[route.Path] = make(map[string]interface{})
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = b.operation(route, errorRef)
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "EcoBox API",
+			"version":     openAPIVersion,
+			"description": "Versioned API of the EcoBox dashboard. Failed requests return an ErrorResponse whose code always comes with the same HTTP status. GET responses carry an ETag for If-None-Match, and changes to a server honor If-Match.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": V1Prefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Personal API token (ebx_...)"},
+				"session":  map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "auth_token"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"apiToken": []string{}},
+			map[string]interface{}{"session": []string{}},
+		},
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic("web: cannot encode the OpenAPI document: " + err.Error())
+	}
+	return data
+}
+
+// operation describes one route
+func (b *schemaBuilder) operation(route v1Route, errorRef map[string]interface{}) map[string]interface{} {
+	var parameters []interface{}
+	for _, match := range pathVariable.FindAllStringSubmatch(route.Path, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, param := range route.Query {
+		parameters = append(parameters, map[string]interface{}{
+			"name": param.Name, "in": "query", "description": param.Description, "schema": map[string]interface{}{"type": param.Type},
+		})
+	}
+
+	responses := make(map[string]interface{})
+	success := map[string]interface{}{"description": http.StatusText(route.Status)}
+	if route.Response != nil {
+		success["content"] = jsonContent(b.schema(reflect.TypeOf(route.Response), false))
+		success["headers"] = map[string]interface{}{
+			"ETag": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	}
+	responses[strconv.Itoa(route.Status)] = success
+
+	if route.Method == "GET" {
+		parameters = append(parameters, headerParameter("If-None-Match", "Answer 304 Not Modified when the ETag is unchanged"))
+		responses[strconv.Itoa(http.StatusNotModified)] = map[string]interface{}{"description": "The ETag in If-None-Match is current"}
+	}
+	if route.IfMatch {
+		parameters = append(parameters, headerParameter("If-Match", "Only change the server when its ETag is one of these"))
+	}
+
+	// Codes sharing a status share the response
+	codes := make(map[int][]string)
+	for _, code := range append(append([]string(nil), route.Errors...), commonErrors...) {
+		status := errorStatuses[code]
+		codes[status] = append(codes[status], code)
+	}
+	for status, list := range codes {
+		sort.Strings(list)
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": "Error codes: " + strings.Join(list, ", "),
+			"content":     jsonContent(errorRef),
+		}
+	}
+
+	op := map[string]interface{}{
+		"operationId": route.OperationID,
+		"summary":     route.Summary,
+		"tags":        []string{strings.Split(strings.TrimPrefix(route.Path, "/"), "/")[0]},
+		"responses":   responses,
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	if route.Request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": !route.OptionalBody,
+			"content":  jsonContent(b.schema(reflect.TypeOf(route.Request), true)),
+		}
+	}
+	return op
+}
+
+// schema returns the schema of a type, a reference for named structs. Request
+// schemas mark no property required, as requests may leave any out.
+func (b *schemaBuilder) schema(t reflect.Type, request bool) map[string]interface{} {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem(), request)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem(), request), "nullable": true}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem(), request), "nullable": true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t, request)
+		}
+		return b.ref(t, request)
+	}
+	return map[string]interface{}{}
+}
+
+// ref adds the schema of a named struct to the components once and refers to
+// it. Names taken by a type of another package get the package name in front.
+func (b *schemaBuilder) ref(t reflect.Type, request bool) map[string]interface{} {
+	name, ok := b.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := b.schemas[name]; taken {
+			pkg := path.Base(t.PkgPath())
+			name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+		}
+		b.names[t] = name
+		b.schemas[name] = map[string]interface{}{} // Placeholder for types that contain themselves
+		b.schemas[name] = b.object(t, request)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// object returns the schema of a struct with its JSON properties. Embedded
+// structs without a JSON name are flattened, as encoding/json does.
+func (b *schemaBuilder) object(t reflect.Type, request bool) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = b.schema(field.Type, request)
+			if !request && !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	for _, extra := range marshalerFields[t] {
+		properties[extra.name] = b.schema(extra.typ, request)
+		if !request {
+			required = append(required, extra.name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// jsonContent is the content of a JSON body with a schema
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// headerParameter describes an optional request header
+func headerParameter(name, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name, "in": "header", "description": description, "schema": map[string]interface{}{"type": "string"},
+	}
+}
@@ -0,0 +1,216 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/control"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"github.com/gorilla/mux"
+)
+
+// DesiredStateRequest represents a request to change the desired state of a server
+type DesiredStateRequest struct {
+	State     models.PowerState `json:"state"`
+	Reason    string            `json:"reason"`
+	Duration  string            `json:"duration,omitempty"`   // Go duration such as "2h"; reverts afterwards
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"` // Alternative to duration
+	Force     bool              `json:"force,omitempty"`      // Force stop instead of clean shutdown
+	Cascade   bool              `json:"cascade,omitempty"`    // Take running dependents down first
+}
+
+// requesterName returns the name recorded as the requester of an action
+func requesterName(r *http.Request) string {
+	if user := auth.GetUserFromContext(r.Context()); user != nil {
+		return user.Username
+	}
+	return "api"
+}
+
+// handleSetDesiredState records the desired state of a server. It only records
+// intent; the reconciler acts on it and the returned operation tracks progress.
+func (ws *WebServer) handleSetDesiredState(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	var req DesiredStateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	// A cascade also takes the server's running dependents down
+	affected := []string{serverID}
+	if req.Cascade {
+		affected = append(affected, ws.cascadeServers(serverID)...)
+	}
+	if !ws.authorizeServers(w, r, affected, statePermission(req.State)) {
+		return
+	}
+
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server not found: %s", serverID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	expiresAt, err := parseIntentExpiry(req.Duration, req.ExpiresAt)
+	if err == nil {
+		err = monitor.ValidateDesiredState(server, req.State, req.Force)
+	}
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	op, err := ws.monitor.RequestPowerState(server.ID, monitor.PowerStateRequest{
+		State:       req.State,
+		Reason:      req.Reason,
+		RequestedBy: requesterName(r),
+		ExpiresAt:   expiresAt,
+		Force:       req.Force,
+		Cascade:     req.Cascade,
+	})
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to set desired state: %v", err),
+		}
+		ws.writeJSONResponse(w, powerStateErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Desired state of %s set to %s", server.Name, req.State),
+		Data:    op,
+	}
+
+	ws.writeJSONResponse(w, http.StatusAccepted, response)
+}
+
+// handleClearDesiredState drops the current intent of a server and returns it to
+// the desired state it had before
+func (ws *WebServer) handleClearDesiredState(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server not found: %s", serverID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	if server.Intent == nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server %s has no active intent", server.Name),
+		}
+		ws.writeJSONResponse(w, http.StatusConflict, response)
+		return
+	}
+
+	// Clearing the intent returns the server to the state it had before
+	if !ws.authorizeServer(w, r, serverID, statePermission(server.Intent.RevertTo)) {
+		return
+	}
+
+	op, err := ws.monitor.ClearPowerIntent(server.ID, requesterName(r))
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to clear desired state: %v", err),
+		}
+		ws.writeJSONResponse(w, powerStateErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Desired state of %s returned to %s", server.Name, op.TargetState),
+		Data:    op,
+	}
+
+	ws.writeJSONResponse(w, http.StatusAccepted, response)
+}
+
+// handleGetOperation returns the current status of a requested operation
+func (ws *WebServer) handleGetOperation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	operationID := vars["id"]
+
+	op, ok := ws.monitor.GetOperation(operationID)
+	if !ok {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Operation not found: %s", operationID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	if !ws.authorizeServer(w, r, op.ServerID, auth.PermissionView) {
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    op,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// powerStateErrorStatus maps a failed desired-state request to an HTTP status
+func powerStateErrorStatus(err error) int {
+	if errors.Is(err, monitor.ErrServerLeased) || errors.Is(err, control.ErrActiveDependents) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// parseIntentExpiry turns a duration or absolute expiry into an expiry time (nil if neither is set)
+func parseIntentExpiry(duration string, expiresAt *time.Time) (*time.Time, error) {
+	if duration != "" && expiresAt != nil {
+		return nil, fmt.Errorf("specify either duration or expires_at, not both")
+	}
+
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q: %v", duration, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration must be positive")
+		}
+		expiry := time.Now().Add(d)
+		return &expiry, nil
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+
+	return expiresAt, nil
+}
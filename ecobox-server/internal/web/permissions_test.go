@@ -0,0 +1,80 @@
+package web
+
+import (
+	"net/http"
+	"testing"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/discovery"
+)
+
+// grantToken creates a viewer with grants and returns an API token of theirs
+func grantToken(t *testing.T, ts *v1TestServer, username string, grants []auth.Grant) string {
+	t.Helper()
+	if _, _, err := ts.ws.authManager.CreateUser(username, auth.RoleViewer, grants); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	_, token, err := ts.ws.authManager.CreateToken(username, "permission tests", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	return token
+}
+
+func TestDiscoveryNeedsConfigure(t *testing.T) {
+	ts := newV1TestServer(t)
+	ts.ws.discovery = discovery.NewScanner(ts.ws.config, ts.ws.storage, ts.ws.registry, ts.ws.monitor.GetPortScanner())
+	configurer := grantToken(t, ts, "carol", []auth.Grant{{Server: "nas", Permissions: []auth.Permission{auth.PermissionConfigure}}})
+
+	tokens := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"admin", ts.admin, http.StatusOK},
+		{"configurer", configurer, http.StatusOK},
+		{"viewer", ts.viewer, http.StatusForbidden},
+	}
+	for _, test := range tokens {
+		if rec := ts.request("GET", "/api/discovery", "", test.token, nil); rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.name, test.status, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestConfigureCannotChangeCommands(t *testing.T) {
+	ts := newV1TestServer(t)
+	if _, err := ts.ws.registry.Create(config.ServerConfig{
+		ID: "app", Name: "App", Hostname: "192.0.2.20", MACAddress: "AA:BB:CC:DD:EE:20",
+		Services: []config.ServiceConfig{{Name: "disk", Port: 22, Check: &config.HealthCheckConfig{Type: "command", Command: "test -d /srv"}}},
+	}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	configurer := grantToken(t, ts, "carol", []auth.Grant{{Server: "app", Permissions: []auth.Permission{auth.PermissionConfigure}}})
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"PATCH", "/api/servers/app", `{"name": "Application"}`, http.StatusOK},
+		{"PATCH", "/api/servers/app", `{"ssh_key_path": "/tmp/key"}`, http.StatusForbidden},
+		{"PATCH", "/api/servers/app", `{"ssh_user": "admin"}`, http.StatusForbidden},
+		{"PATCH", V1Prefix + "/servers/app", `{"hostname": "192.0.2.21"}`, http.StatusForbidden},
+		{"PUT", "/api/servers/app/services/uptime", `{"port": 22, "check": {"type": "command", "command": "uptime"}}`, http.StatusForbidden},
+		{"PUT", "/api/servers/app/services/web", `{"port": 80, "check": {"type": "http"}}`, http.StatusCreated},
+		{"POST", "/api/servers/app/services/disk/check", "", http.StatusForbidden},
+	}
+	for _, test := range requests {
+		if rec := ts.request(test.method, test.path, test.body, configurer, nil); rec.Code != test.status {
+			t.Errorf("%s %s %s: expected status %d, got %d: %s", test.method, test.path, test.body, test.status, rec.Code, rec.Body.String())
+		}
+	}
+
+	def, _ := ts.ws.registry.Get("app")
+	if def.Hostname != "192.0.2.20" || def.SSHKeyPath != "" || len(def.Services) != 2 {
+		t.Errorf("Expected only the name and the web service to change, got %+v", def)
+	}
+}
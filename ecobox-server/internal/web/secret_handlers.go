@@ -0,0 +1,209 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/secrets"
+	"github.com/gorilla/mux"
+)
+
+// secretInfo describes a secret and what uses it, never its value
+type secretInfo struct {
+	secrets.Info
+	UsedBy []string `json:"used_by,omitempty"` // Configuration fields, or servers whose created Proxmox token it is
+}
+
+// handleGetSecrets lists the secrets in the vault (admin only)
+func (ws *WebServer) handleGetSecrets(w http.ResponseWriter, r *http.Request) {
+	if !ws.requireSecretsAdmin(w, r) {
+		return
+	}
+
+	uses := ws.secretUses()
+	infos := make([]secretInfo, 0)
+	for _, info := range ws.secrets.List() {
+		infos = append(infos, secretInfo{Info: info, UsedBy: uses[info.Name]})
+	}
+
+	// Referenced secrets that are missing are listed too, so they can be set
+	var missing []string
+	for name := range uses {
+		if _, err := ws.secrets.Get(name); errors.Is(err, secrets.ErrSecretNotFound) && !strings.HasPrefix(name, monitor.ProxmoxKeySecret("")) {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+
+	response := APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"secrets":      infos,
+			"missing":      missing,
+			"key_from_env": ws.secrets.KeyFromEnv(),
+		},
+	}
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleSetSecret creates a secret or replaces its value (admin only). The
+// value is not returned.
+func (ws *WebServer) handleSetSecret(w http.ResponseWriter, r *http.Request) {
+	if !ws.requireSecretsAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	info, err := ws.secrets.Set(name, req.Value)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, secrets.ErrInvalidSecret) {
+			status = http.StatusBadRequest
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to set secret: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	status, message := http.StatusOK, fmt.Sprintf("Secret %s updated to version %d", name, info.Version)
+	if info.Version == 1 {
+		status, message = http.StatusCreated, fmt.Sprintf("Secret %s created", name)
+	}
+	response := APIResponse{
+		Success: true,
+		Message: message,
+		Data:    secretInfo{Info: info, UsedBy: ws.secretUses()[name]},
+	}
+	ws.writeJSONResponse(w, status, response)
+}
+
+// handleDeleteSecret removes a secret the configuration does not refer to
+// (admin only)
+func (ws *WebServer) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	if !ws.requireSecretsAdmin(w, r) {
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if fields := ws.configSecretRefs()[name]; len(fields) > 0 {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Secret %s is used by %s", name, strings.Join(fields, ", ")),
+		}
+		ws.writeJSONResponse(w, http.StatusConflict, response)
+		return
+	}
+
+	if err := ws.secrets.Delete(name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, secrets.ErrSecretNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to delete secret: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Secret %s deleted", name),
+	}
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleRotateSecretsKey encrypts the vault under a new master key written to
+// key_file (admin only). A master key from the environment is rotated from the
+// command line instead, where the new key can be passed.
+func (ws *WebServer) handleRotateSecretsKey(w http.ResponseWriter, r *http.Request) {
+	if !ws.requireSecretsAdmin(w, r) {
+		return
+	}
+
+	if err := ws.secrets.RotateKey(""); err != nil {
+		status := http.StatusInternalServerError
+		message := fmt.Sprintf("Failed to rotate master key: %v", err)
+		if errors.Is(err, secrets.ErrKeyFromEnv) {
+			status = http.StatusConflict
+			message = fmt.Sprintf("The master key comes from $%s; rotate it with: dashboard -rotate-master-key, with the new key in $%s_NEW", ws.config.Secrets.KeyEnv, ws.config.Secrets.KeyEnv)
+		}
+		response := APIResponse{
+			Success: false,
+			Message: message,
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Master key rotated, %s holds the new key", ws.config.Secrets.KeyFile),
+	}
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// requireSecretsAdmin answers 403 unless the requester is an admin, and 503
+// when there is no vault
+func (ws *WebServer) requireSecretsAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return false
+	}
+	if ws.secrets == nil {
+		ws.writeJSONResponse(w, http.StatusServiceUnavailable, APIResponse{
+			Success: false,
+			Message: "Secret vault is not available",
+		})
+		return false
+	}
+	return true
+}
+
+// configSecretRefs returns the secrets the running configuration and the
+// servers added through the API refer to, with the fields that refer to them
+func (ws *WebServer) configSecretRefs() map[string][]string {
+	effective := ws.config.Copy()
+	effective.Servers = append(append([]config.ServerConfig(nil), ws.config.Servers...), ws.registry.Definitions()...)
+	return effective.SecretRefs()
+}
+
+// secretUses is configSecretRefs with the Proxmox tokens the dashboard
+// created and saved for hosts
+func (ws *WebServer) secretUses() map[string][]string {
+	uses := ws.configSecretRefs()
+	for id, server := range ws.storage.GetAllServers() {
+		if server.ProxmoxAPIKey != nil && server.ProxmoxTokenRef == "" {
+			name := monitor.ProxmoxKeySecret(id)
+			uses[name] = append(uses[name], fmt.Sprintf("servers.%s (created Proxmox API token)", id))
+		}
+	}
+	return uses
+}
@@ -8,17 +8,26 @@ import (
 	"html/template"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"ecobox-server/internal/alerts"
+	"ecobox-server/internal/audit"
 	"ecobox-server/internal/auth"
+	"ecobox-server/internal/certs"
 	"ecobox-server/internal/config"
 	"ecobox-server/internal/control"
+	"ecobox-server/internal/discovery"
 	"ecobox-server/internal/monitor"
+	"ecobox-server/internal/registry"
+	"ecobox-server/internal/reload"
+	"ecobox-server/internal/secrets"
 	"ecobox-server/internal/storage"
+	"ecobox-server/internal/webhooks"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
@@ -30,34 +39,46 @@ type WebServer struct {
 	storage       storage.Storage
 	monitor       *monitor.Monitor
 	powerManager  *control.PowerManager
+	registry      *registry.Registry
+	reloader      *reload.Reloader
+	discovery     *discovery.Scanner
+	alerts        *alerts.Manager
+	webhooks      *webhooks.Dispatcher
+	audit         *audit.Log
 	authManager   *auth.Manager
 	authMiddleware *auth.Middleware
 	router        *mux.Router
 	server        *http.Server
+	redirectServer *http.Server // Plain HTTP redirects when serving HTTPS
+	certs         *certs.Manager
+	secrets       *secrets.Vault
+	openAPI       []byte // OpenAPI document of the versioned API
 	wsUpgrader    websocket.Upgrader
-	wsClients     map[*websocket.Conn]bool
+	wsClients     map[*websocket.Conn]string // Username of each connection
 	logger        *logrus.Logger
 	mu            sync.RWMutex
 }
 
 // NewWebServer creates a new web server instance
-func NewWebServer(cfg *config.Config, storage storage.Storage, monitor *monitor.Monitor, pm *control.PowerManager, am *auth.Manager) *WebServer {
+func NewWebServer(cfg *config.Config, storage storage.Storage, monitor *monitor.Monitor, pm *control.PowerManager, reg *registry.Registry, reloader *reload.Reloader, scanner *discovery.Scanner, alertManager *alerts.Manager, dispatcher *webhooks.Dispatcher, auditLog *audit.Log, am *auth.Manager) *WebServer {
 	ws := &WebServer{
 		config:        cfg,
 		storage:       storage,
 		monitor:       monitor,
 		powerManager:  pm,
+		registry:      reg,
+		reloader:      reloader,
+		discovery:     scanner,
+		alerts:        alertManager,
+		webhooks:      dispatcher,
+		audit:         auditLog,
 		authManager:   am,
 		authMiddleware: auth.NewMiddleware(am),
 		router:        mux.NewRouter(),
-		wsUpgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for simplicity
-			},
-		},
-		wsClients: make(map[*websocket.Conn]bool),
+		wsClients: make(map[*websocket.Conn]string),
 		logger:    logrus.New(),
 	}
+	ws.wsUpgrader = websocket.Upgrader{CheckOrigin: ws.allowedOrigin}
 
 	ws.setupRoutes()
 	return ws
@@ -65,8 +86,9 @@ func NewWebServer(cfg *config.Config, storage storage.Storage, monitor *monitor.
 
 // Start starts the web server and begins handling requests
 func (ws *WebServer) Start() error {
+	port := ws.config.LiveDashboard().Port
 	ws.server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", ws.config.Dashboard.Port),
+		Addr:         fmt.Sprintf(":%d", port),
 		Handler:      ws.router,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
@@ -76,10 +98,49 @@ func (ws *WebServer) Start() error {
 	// Start goroutine to forward monitor updates to WebSocket clients
 	go ws.handleMonitorUpdates()
 
-	ws.logger.Infof("Starting web server on port %d", ws.config.Dashboard.Port)
+	if ws.certs != nil && ws.config.TLS.Enabled {
+		return ws.startTLS(port)
+	}
+
+	ws.logger.Infof("Starting web server on port %d", port)
 	return ws.server.ListenAndServe()
 }
 
+// startTLS serves HTTPS on the TLS port and redirects the dashboard port to it
+func (ws *WebServer) startTLS(port int) error {
+	ws.server.Addr = fmt.Sprintf(":%d", ws.config.TLS.Port)
+	ws.server.TLSConfig = ws.certs.TLSConfig()
+
+	if !ws.config.TLS.DisableHTTP {
+		ws.redirectServer = &http.Server{
+			Addr:         fmt.Sprintf(":%d", port),
+			Handler:      http.HandlerFunc(ws.handleHTTPRedirect),
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		}
+		go func() {
+			ws.logger.Infof("Redirecting HTTP on port %d to HTTPS", port)
+			if err := ws.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				ws.logger.Errorf("HTTP redirect server failed: %v", err)
+			}
+		}()
+	}
+
+	ws.logger.Infof("Starting web server with HTTPS on port %d", ws.config.TLS.Port)
+	return ws.server.ListenAndServeTLS("", "")
+}
+
+// SetCertManager sets the certificates HTTPS is served with when [tls] is
+// enabled
+func (ws *WebServer) SetCertManager(cm *certs.Manager) {
+	ws.certs = cm
+}
+
+// SetSecrets sets the secret vault managed through the API
+func (ws *WebServer) SetSecrets(vault *secrets.Vault) {
+	ws.secrets = vault
+}
+
 // Stop gracefully shuts down the web server
 func (ws *WebServer) Stop(ctx context.Context) error {
 	ws.logger.Info("Shutting down web server")
@@ -89,9 +150,14 @@ func (ws *WebServer) Stop(ctx context.Context) error {
 	for conn := range ws.wsClients {
 		conn.Close()
 	}
-	ws.wsClients = make(map[*websocket.Conn]bool)
+	ws.wsClients = make(map[*websocket.Conn]string)
 	ws.mu.Unlock()
 
+	if ws.redirectServer != nil {
+		if err := ws.redirectServer.Shutdown(ctx); err != nil {
+			ws.logger.Warnf("Failed to shut down HTTP redirect server: %v", err)
+		}
+	}
 	return ws.server.Shutdown(ctx)
 }
 
@@ -111,10 +177,22 @@ func (ws *WebServer) handleVueApp(w http.ResponseWriter, r *http.Request) {
 
 // setupRoutes configures all HTTP routes
 func (ws *WebServer) setupRoutes() {
+	// Routes only match their methods, so OPTIONS gets its own route for
+	// corsMiddleware to answer preflights on
+	ws.router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	
 	// Authentication routes (public)
 	ws.router.HandleFunc("/login", ws.handleLogin).Methods("GET", "POST")
 	ws.router.HandleFunc("/logout", ws.handleLogout).Methods("POST")
 	ws.router.HandleFunc("/setup", ws.handleSetup).Methods("GET", "POST")
+	ws.router.HandleFunc("/api/auth/refresh", ws.handleRefreshSession).Methods("POST")
+	ws.router.HandleFunc("/login/passkey/begin", ws.handleBeginPasskeyLogin).Methods("POST")
+	ws.router.HandleFunc("/login/passkey/finish", ws.handleFinishPasskeyLogin).Methods("POST")
+	ws.router.HandleFunc("/login/oidc", ws.handleBeginOIDCLogin).Methods("GET")
+	ws.router.HandleFunc("/tls/ca.pem", ws.handleCACertificate).Methods("GET")
+	ws.router.HandleFunc("/login/oidc/callback", ws.handleOIDCCallback).Methods("GET")
 
 	// Static files (public)
 	// Serve Vue.js static files if they exist
@@ -135,11 +213,62 @@ func (ws *WebServer) setupRoutes() {
 	// API routes (protected)
 	api := ws.router.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/servers", ws.handleGetServers).Methods("GET")
+	api.HandleFunc("/servers", ws.handleCreateServer).Methods("POST")
 	api.HandleFunc("/servers/{id}/wake", ws.handleWakeServer).Methods("POST")
 	api.HandleFunc("/servers/{id}/suspend", ws.handleSuspendServer).Methods("POST")
 	api.HandleFunc("/servers/{id}/shutdown", ws.handleShutdownServer).Methods("POST")  // New: Clean shutdown
 	api.HandleFunc("/servers/{id}/stop", ws.handleStopServer).Methods("POST")          // New: Force stop (VMs only)
+	api.HandleFunc("/servers/{id}/hibernate", ws.handleHibernateServer).Methods("POST")
+	api.HandleFunc("/servers/{id}/restart", ws.handleRestartServer).Methods("POST")
+	api.HandleFunc("/servers/{id}/reset", ws.handleResetServer).Methods("POST")        // Hard reset (VMs only)
+	api.HandleFunc("/servers/{id}/desired-state", ws.handleSetDesiredState).Methods("PUT")
+	api.HandleFunc("/servers/{id}/desired-state", ws.handleClearDesiredState).Methods("DELETE")
+	api.HandleFunc("/servers/{id}/leases", ws.handleGetLeases).Methods("GET")
+	api.HandleFunc("/servers/{id}/leases", ws.handleAcquireLease).Methods("POST")
+	api.HandleFunc("/servers/{id}/leases/{lease}", ws.handleRenewLease).Methods("PUT")
+	api.HandleFunc("/servers/{id}/leases/{lease}", ws.handleReleaseLease).Methods("DELETE")
+	api.HandleFunc("/servers/{id}/groups", ws.handleSetServerGroups).Methods("PUT")
+	api.HandleFunc("/servers/{id}/services", ws.handleGetServices).Methods("GET")
+	api.HandleFunc("/servers/{id}/services/{name}", ws.handleSetService).Methods("PUT")
+	api.HandleFunc("/servers/{id}/services/{name}", ws.handleDeleteService).Methods("DELETE")
+	api.HandleFunc("/servers/{id}/services/{name}/check", ws.handleCheckService).Methods("POST")
 	api.HandleFunc("/servers/{id}", ws.handleGetServer).Methods("GET")
+	api.HandleFunc("/servers/{id}", ws.handleReplaceServer).Methods("PUT")
+	api.HandleFunc("/servers/{id}", ws.handleUpdateServer).Methods("PATCH")
+	api.HandleFunc("/servers/{id}", ws.handleDeleteServer).Methods("DELETE")
+	api.HandleFunc("/groups", ws.handleGetGroups).Methods("GET")
+	api.HandleFunc("/groups/{name}", ws.handleGetGroup).Methods("GET")
+	api.HandleFunc("/groups/{name}/{action:wake|suspend|shutdown}", ws.handleGroupAction).Methods("POST")
+	api.HandleFunc("/operations/{id}", ws.handleGetOperation).Methods("GET")
+	
+	// Configuration routes (protected, admin only)
+	api.HandleFunc("/admin/config", ws.handleExportConfig).Methods("GET")
+	api.HandleFunc("/admin/config/reload", ws.handleReloadConfig).Methods("POST")
+	
+	// Network discovery routes (protected, listing needs configure, changes admin only)
+	api.HandleFunc("/discovery", ws.handleGetDiscovery).Methods("GET")
+	api.HandleFunc("/discovery/scan", ws.handleStartDiscovery).Methods("POST")
+	api.HandleFunc("/discovery/candidates/{id}/adopt", ws.handleAdoptCandidate).Methods("POST")
+	api.HandleFunc("/discovery/candidates/{id}", ws.handleDismissCandidate).Methods("DELETE")
+	
+	// Alert routes (protected, notifier tests admin only)
+	api.HandleFunc("/alerts", ws.handleGetAlerts).Methods("GET")
+	api.HandleFunc("/alerts/notifiers/{name}/test", ws.handleTestNotifier).Methods("POST")
+	
+	// Outbound webhook routes (protected, admin only)
+	api.HandleFunc("/webhooks", ws.handleGetWebhooks).Methods("GET")
+	api.HandleFunc("/webhooks", ws.handleCreateWebhook).Methods("POST")
+	api.HandleFunc("/webhooks/dead-letters", ws.handleGetWebhookDeadLetters).Methods("GET")
+	api.HandleFunc("/webhooks/{id}", ws.handleDeleteWebhook).Methods("DELETE")
+	
+	// Audit log routes (protected, admin only)
+	api.HandleFunc("/audit", ws.handleGetAudit).Methods("GET")
+	
+	// Secret vault routes (protected, admin only; values are never returned)
+	api.HandleFunc("/secrets", ws.handleGetSecrets).Methods("GET")
+	api.HandleFunc("/secrets/rotate-key", ws.handleRotateSecretsKey).Methods("POST")
+	api.HandleFunc("/secrets/{name}", ws.handleSetSecret).Methods("PUT")
+	api.HandleFunc("/secrets/{name}", ws.handleDeleteSecret).Methods("DELETE")
 	
 	// Metrics API routes (protected)
 	api.HandleFunc("/metrics", ws.handleGetMetrics).Methods("GET")
@@ -159,7 +288,30 @@ func (ws *WebServer) setupRoutes() {
 	auth.HandleFunc("/password", ws.handleChangePassword).Methods("POST")
 	auth.HandleFunc("/users", ws.handleGetUsers).Methods("GET")
 	auth.HandleFunc("/users", ws.handleCreateUser).Methods("POST") 
+	auth.HandleFunc("/users/{username}", ws.handleSetUserAccess).Methods("PUT")
 	auth.HandleFunc("/users/{username}", ws.handleDeleteUser).Methods("DELETE")
+	auth.HandleFunc("/users/{username}/2fa", ws.handleSetUserTwoFactor).Methods("PUT")
+	auth.HandleFunc("/users/{username}/oidc", ws.handleSetUserOIDCLink).Methods("PUT")
+	auth.HandleFunc("/2fa", ws.handleGetTwoFactor).Methods("GET")
+	auth.HandleFunc("/2fa/totp", ws.handleBeginTOTP).Methods("POST")
+	auth.HandleFunc("/2fa/totp/confirm", ws.handleConfirmTOTP).Methods("POST")
+	auth.HandleFunc("/2fa/totp", ws.handleDisableTOTP).Methods("DELETE")
+	auth.HandleFunc("/2fa/recovery-codes", ws.handleRegenerateRecoveryCodes).Methods("POST")
+	auth.HandleFunc("/2fa/passkeys/begin", ws.handleBeginPasskeyRegistration).Methods("POST")
+	auth.HandleFunc("/2fa/passkeys", ws.handleRegisterPasskey).Methods("POST")
+	auth.HandleFunc("/2fa/passkeys/{id}", ws.handleDeletePasskey).Methods("DELETE")
+	auth.HandleFunc("/tokens", ws.handleGetTokens).Methods("GET")
+	auth.HandleFunc("/tokens", ws.handleCreateToken).Methods("POST")
+	auth.HandleFunc("/tokens/{id}", ws.handleRevokeToken).Methods("DELETE")
+	auth.HandleFunc("/sessions", ws.handleGetSessions).Methods("GET")
+	auth.HandleFunc("/sessions", ws.handleRevokeSessions).Methods("DELETE")
+	auth.HandleFunc("/sessions/{id}", ws.handleRevokeSession).Methods("DELETE")
+
+	// Versioned API with its OpenAPI document
+	ws.setupV1Routes(api)
+
+	// Record API changes in the audit log
+	api.Use(ws.auditMiddleware)
 
 	// WebSocket endpoint (protected)
 	ws.router.HandleFunc("/ws", ws.handleWebSocket)
@@ -204,6 +356,7 @@ func (ws *WebServer) setupRoutes() {
 
 	// Add middleware
 	ws.router.Use(ws.loggingMiddleware)
+	ws.router.Use(ws.securityHeadersMiddleware)
 	ws.router.Use(ws.corsMiddleware)
 	ws.router.Use(ws.authMiddleware.RequireAuth)
 }
@@ -226,8 +379,11 @@ func (ws *WebServer) broadcastUpdate(update monitor.ServerUpdate) {
 		return
 	}
 
-	// Send to all connected clients
-	for conn := range ws.wsClients {
+	// Send to the connected clients whose users may view the server
+	for conn, username := range ws.wsClients {
+		if !ws.canView(username, update.ServerID) {
+			continue
+		}
 		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
 			ws.logger.Warnf("Failed to send WebSocket message: %v", err)
 			conn.Close()
@@ -262,15 +418,26 @@ func (ws *WebServer) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// corsMiddleware adds CORS headers
+// corsMiddleware adds CORS headers for the origins in cors_origins. Other
+// sites get none, so browsers keep them from reading responses.
 func (ws *WebServer) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		origin := r.Header.Get("Origin")
+		listed := origin != "" && containsOrigin(ws.config.LiveDashboard().CORSOrigins, origin)
+		if listed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+auth.CSRFHeaderName)
+		}
+		w.Header().Add("Vary", "Origin")
 		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		// Answer preflights, which carry no credentials
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !listed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 		
@@ -278,6 +445,51 @@ func (ws *WebServer) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// securityHeadersMiddleware adds headers that keep browsers from framing the
+// dashboard, guessing content types and loading scripts from elsewhere
+func (ws *WebServer) securityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		if csp := ws.config.LiveDashboard().ContentSecurityPolicy; csp != "" {
+			header.Set("Content-Security-Policy", csp)
+		}
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "same-origin")
+		if ws.authManager.IsHTTPS(r) {
+			header.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin reports whether a WebSocket connection comes from the
+// dashboard itself or one of cors_origins. Browsers send cookies with
+// WebSocket connections from any site, so others are refused.
+func (ws *WebServer) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // Not a browser
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host) || containsOrigin(ws.config.LiveDashboard().CORSOrigins, origin)
+}
+
+// containsOrigin reports whether an origin is in a list, ignoring case and a
+// trailing slash
+func containsOrigin(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
 // responseRecorder wraps http.ResponseWriter to capture status code
 type responseRecorder struct {
 	http.ResponseWriter
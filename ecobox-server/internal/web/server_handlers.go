@@ -0,0 +1,173 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/registry"
+	"github.com/gorilla/mux"
+)
+
+// handleCreateServer adds a server through the API. The body uses the same fields
+// as a [[servers]] entry in the configuration file.
+func (ws *WebServer) handleCreateServer(w http.ResponseWriter, r *http.Request) {
+	if user := auth.GetUserFromContext(r.Context()); user != nil && !user.HasRole(auth.RoleAdmin) {
+		response := APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		}
+		ws.writeJSONResponse(w, http.StatusForbidden, response)
+		return
+	}
+
+	var def config.ServerConfig
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	server, err := ws.registry.Create(def)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to add server: %v", err),
+		}
+		ws.writeJSONResponse(w, registryErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Server %s added", server.Name),
+		Data:    server,
+	}
+
+	ws.writeJSONResponse(w, http.StatusCreated, response)
+}
+
+// handleReplaceServer replaces the definition of a server added through the API
+func (ws *WebServer) handleReplaceServer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionConfigure) {
+		return
+	}
+
+	var def config.ServerConfig
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	ws.updateServerDefinition(w, r, serverID, def)
+}
+
+// handleUpdateServer changes only the fields present in the request body of a
+// server added through the API. Lists such as services are replaced as a whole.
+func (ws *WebServer) handleUpdateServer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionConfigure) {
+		return
+	}
+
+	def, err := ws.registry.Get(serverID)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to update server: %v", err),
+		}
+		ws.writeJSONResponse(w, registryErrorStatus(err), response)
+		return
+	}
+
+	// Decoding onto the current definition keeps fields the body leaves out
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	ws.updateServerDefinition(w, r, serverID, def)
+}
+
+// updateServerDefinition stores a changed server definition and writes the
+// response. Only admins may change the SSH connection or command checks.
+func (ws *WebServer) updateServerDefinition(w http.ResponseWriter, r *http.Request, serverID string, def config.ServerConfig) {
+	server, err := ws.registry.Update(serverID, def, isAdmin(r))
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to update server: %v", err),
+		}
+		ws.writeJSONResponse(w, registryErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Server %s updated", server.Name),
+		Data:    server,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleDeleteServer removes a server added through the API
+func (ws *WebServer) handleDeleteServer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionConfigure) {
+		return
+	}
+
+	if err := ws.registry.Delete(serverID); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to delete server: %v", err),
+		}
+		ws.writeJSONResponse(w, registryErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Server %s deleted", serverID),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// registryErrorStatus maps a failed server change to an HTTP status. Anything
+// not listed is a validation error.
+func registryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, registry.ErrServerNotFound), errors.Is(err, registry.ErrServiceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, registry.ErrServerExists), errors.Is(err, registry.ErrReadOnly), errors.Is(err, registry.ErrServerInUse):
+		return http.StatusConflict
+	case errors.Is(err, registry.ErrAdminRequired):
+		return http.StatusForbidden
+	case errors.Is(err, registry.ErrSaveFailed):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
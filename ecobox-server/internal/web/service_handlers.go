@@ -0,0 +1,171 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"github.com/gorilla/mux"
+)
+
+// handleGetServices returns the services of a server with their last check results
+func (ws *WebServer) handleGetServices(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionView) {
+		return
+	}
+
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server not found: %s", serverID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    server.Services,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleSetService adds or replaces a service of a server added through the API.
+// The body uses the fields of a [[servers.services]] entry; the name comes from the
+// path. Only admins may set command checks.
+func (ws *WebServer) handleSetService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionConfigure) {
+		return
+	}
+
+	var service config.ServiceConfig
+	if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+	service.Name = vars["name"]
+
+	server, created, err := ws.registry.SetService(serverID, service, isAdmin(r))
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to save service: %v", err),
+		}
+		ws.writeJSONResponse(w, registryErrorStatus(err), response)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Service %s on %s saved", service.Name, server.Name),
+		Data:    server,
+	}
+
+	ws.writeJSONResponse(w, status, response)
+}
+
+// handleDeleteService removes a service from a server added through the API
+func (ws *WebServer) handleDeleteService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+	name := vars["name"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionConfigure) {
+		return
+	}
+
+	server, err := ws.registry.DeleteService(serverID, name)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to delete service: %v", err),
+		}
+		ws.writeJSONResponse(w, registryErrorStatus(err), response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Service %s on %s deleted", name, server.Name),
+		Data:    server,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleCheckService runs the health check of a service immediately. Command
+// checks run on the server, so only admins may run them.
+func (ws *WebServer) handleCheckService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+	name := vars["name"]
+
+	if !ws.authorizeServer(w, r, serverID, auth.PermissionView) {
+		return
+	}
+
+	server, err := ws.storage.GetServer(serverID)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Server not found: %s", serverID),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	for _, service := range server.Services {
+		if service.Name == name && service.Check != nil && service.Check.Type == models.HealthCheckCommand && !isAdmin(r) {
+			response := APIResponse{
+				Success: false,
+				Message: "Admin privileges required to run a command check",
+			}
+			ws.writeJSONResponse(w, http.StatusForbidden, response)
+			return
+		}
+	}
+
+	service, err := ws.monitor.CheckService(serverID, name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, monitor.ErrServiceNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to check service: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Service %s is %s", service.Name, service.Status),
+		Data:    service,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
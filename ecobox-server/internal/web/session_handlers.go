@@ -0,0 +1,193 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"ecobox-server/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// handleRefreshSession exchanges a refresh token, from the request body or the
+// refresh cookie, for a new access token and refresh token. It is public, since
+// the access token has usually expired by the time it is called.
+func (ws *WebServer) handleRefreshSession(w http.ResponseWriter, r *http.Request) {
+	var req auth.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie(auth.RefreshCookieName); err == nil {
+			req.RefreshToken = cookie.Value
+		}
+	}
+
+	tokens, user, err := ws.authManager.RefreshToken(req.RefreshToken, ws.authManager.RemoteIP(r), r.UserAgent())
+	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			// Whoever presented the token is unknown; the error names the session
+			entry := ws.requestAuditEntry(r, "auth.refresh_reused", "")
+			entry.Error = err.Error()
+			ws.audit.Record(entry)
+		}
+		ws.authManager.ClearAuthCookie(w, r)
+		response := APIResponse{
+			Success: false,
+			Message: "Session expired or revoked",
+		}
+		ws.writeJSONResponse(w, http.StatusUnauthorized, response)
+		return
+	}
+
+	ws.authManager.SetSessionCookies(w, r, tokens)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Session of %s refreshed", user.Username),
+		Data:    tokens,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleGetSessions returns the requester's active sessions, marking the one the
+// request was made with. Admins see every user's sessions with ?all=true.
+func (ws *WebServer) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return
+	}
+
+	username := user.Username
+	if r.URL.Query().Get("all") == "true" {
+		if !user.HasRole(auth.RoleAdmin) {
+			ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Message: "Admin privileges required",
+			})
+			return
+		}
+		username = ""
+	}
+
+	sessions := ws.authManager.ListSessions(username)
+	current := auth.GetSessionIDFromContext(r.Context())
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    sessions,
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleRevokeSessions signs the requester out everywhere, including the
+// current session. Admins sign another user out with ?user=name.
+func (ws *WebServer) handleRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return
+	}
+
+	username := user.Username
+	if other := r.URL.Query().Get("user"); other != "" && other != user.Username {
+		if !user.HasRole(auth.RoleAdmin) {
+			ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Message: "Admin privileges required",
+			})
+			return
+		}
+		username = other
+	}
+
+	revoked, err := ws.authManager.RevokeUserSessions(username, "")
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to revoke sessions: %v", err),
+		}
+		ws.writeJSONResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+	if username == user.Username {
+		ws.authManager.ClearAuthCookie(w, r)
+	}
+
+	ws.logger.Infof("Revoked %d sessions of %s by %s", revoked, username, user.Username)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Revoked %d sessions of %s", revoked, username),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleRevokeSession ends one session. Users end their own sessions; admins
+// end anyone's.
+func (ws *WebServer) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	session, exists := ws.authManager.GetSession(id)
+	if !exists || (session.Username != user.Username && !user.HasRole(auth.RoleAdmin)) {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Session not found: %s", id),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	if err := ws.authManager.RevokeSession(id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to revoke session: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+	if id == auth.GetSessionIDFromContext(r.Context()) {
+		ws.authManager.ClearAuthCookie(w, r)
+	}
+
+	ws.logger.Infof("Session %s of %s revoked by %s", id, session.Username, user.Username)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Session %s revoked", id),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
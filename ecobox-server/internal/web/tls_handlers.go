@@ -0,0 +1,51 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// handleHTTPRedirect sends plain HTTP requests to the same URL over HTTPS.
+// The self-signed CA is served as well, so devices can fetch it before they
+// trust it.
+func (ws *WebServer) handleHTTPRedirect(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/tls/ca.pem" && r.Method == http.MethodGet {
+		ws.handleCACertificate(w, r)
+		return
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if ws.config.TLS.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(ws.config.TLS.Port))
+	} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+		host = "[" + host + "]"
+	}
+
+	// 308 keeps the method and body of API requests
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+}
+
+// handleCACertificate serves the certificate of the self-signed CA, for
+// browsers and devices to trust
+func (ws *WebServer) handleCACertificate(w http.ResponseWriter, r *http.Request) {
+	var ca []byte
+	if ws.certs != nil {
+		ca = ws.certs.CACertificate()
+	}
+	if ca == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", `attachment; filename="ecobox-ca.pem"`)
+	w.Write(ca)
+}
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ecobox-server/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// handleGetTokens returns the requester's API tokens. Admins see every user's
+// tokens with ?all=true.
+func (ws *WebServer) handleGetTokens(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return
+	}
+
+	username := user.Username
+	if r.URL.Query().Get("all") == "true" {
+		if !user.HasRole(auth.RoleAdmin) {
+			ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Message: "Admin privileges required",
+			})
+			return
+		}
+		username = ""
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    ws.authManager.ListTokens(username),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleCreateToken creates an API token for the requester. The token is only
+// included in this response. Tokens cannot create other tokens, so a scoped
+// token cannot be used to get around its scopes.
+func (ws *WebServer) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return
+	}
+	if auth.GetAuthSourceFromContext(r.Context()) == auth.AuthSourceToken {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "API tokens cannot create tokens",
+		})
+		return
+	}
+
+	var req auth.TokenCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	expiresAt, err := parseIntentExpiry(req.Duration, req.ExpiresAt)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: err.Error(),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	token, secret, err := ws.authManager.CreateToken(user.Username, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to create token: %v", err),
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	ws.logger.Infof("API token %s (%s) created by %s", token.ID, token.Name, user.Username)
+
+	ws.writeJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Token created successfully",
+		Data: map[string]interface{}{
+			"token":  token,
+			"secret": secret,
+		},
+	})
+}
+
+// handleRevokeToken deletes an API token. Users revoke their own tokens; admins
+// revoke anyone's.
+func (ws *WebServer) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	token, exists := ws.authManager.GetToken(id)
+	if !exists || (token.Username != user.Username && !user.HasRole(auth.RoleAdmin)) {
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Token not found: %s", id),
+		}
+		ws.writeJSONResponse(w, http.StatusNotFound, response)
+		return
+	}
+
+	if err := ws.authManager.RevokeToken(id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to revoke token: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	ws.logger.Infof("API token %s (%s) of %s revoked by %s", token.ID, token.Name, token.Username, user.Username)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Token %s revoked", id),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
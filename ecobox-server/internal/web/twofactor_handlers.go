@@ -0,0 +1,404 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"ecobox-server/internal/audit"
+	"ecobox-server/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// handleBeginPasskeyLogin returns the options for a passkey login. It is public:
+// without a login token the passkey is the whole login, with one it completes
+// a password login waiting for a second factor.
+func (ws *WebServer) handleBeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req auth.PasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	options, err := ws.authManager.BeginPasskeyLogin(req.LoginToken, ws.authManager.RelyingParty(r))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, auth.ErrLoginExpired):
+			status = http.StatusUnauthorized
+		case errors.Is(err, auth.ErrPasskeyNotFound):
+			status = http.StatusBadRequest
+		}
+		ws.writeJSONResponse(w, status, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    options,
+	})
+}
+
+// handleFinishPasskeyLogin checks a passkey login and starts a session
+func (ws *WebServer) handleFinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req auth.CredentialAssertionResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	entry := ws.requestAuditEntry(r, "auth.login", "")
+	tokens, user, err := ws.authManager.FinishPasskeyLogin(&req, ws.authManager.RemoteIP(r), r.UserAgent())
+	if err != nil {
+		ws.logger.Warnf("Passkey login failed: %v", err)
+		entry.Error = "Passkey login failed"
+		ws.audit.Record(entry)
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Passkey login failed",
+		})
+		return
+	}
+	entry.Actor = user.Username
+	entry.ActorType = audit.ActorUser
+	entry.Target = user.Username
+	entry.Success = true
+	ws.audit.Record(entry)
+
+	ws.completeLogin(w, r, tokens, user)
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Logged in successfully",
+		Data:    tokens,
+	})
+}
+
+// twoFactorUser returns the user changing their own second factors. API tokens
+// cannot, so a leaked token cannot be used to take over the account.
+func (ws *WebServer) twoFactorUser(w http.ResponseWriter, r *http.Request) *auth.User {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return nil
+	}
+	if auth.GetAuthSourceFromContext(r.Context()) == auth.AuthSourceToken {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "API tokens cannot change second factors",
+		})
+		return nil
+	}
+	return user
+}
+
+// checkTwoFactorPassword requires the password before a second factor is
+// removed or recovery codes are replaced. Proxy-authenticated users have none.
+func (ws *WebServer) checkTwoFactorPassword(w http.ResponseWriter, r *http.Request, user *auth.User) bool {
+	if ws.config.LiveDashboard().IAPAuth != "none" {
+		return true
+	}
+
+	var req auth.TwoFactorChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return false
+	}
+	if err := ws.authManager.CheckPassword(user.Username, req.Password); err != nil {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Password is incorrect",
+		})
+		return false
+	}
+	return true
+}
+
+// handleGetTwoFactor returns the requester's second factors
+func (ws *WebServer) handleGetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		ws.writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "Authentication required",
+		})
+		return
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    ws.authManager.TwoFactorStatus(user.Username),
+	})
+}
+
+// handleBeginTOTP starts TOTP enrollment. The secret and recovery codes take
+// effect once confirmed with a code.
+func (ws *WebServer) handleBeginTOTP(w http.ResponseWriter, r *http.Request) {
+	user := ws.twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	enrollment, err := ws.authManager.BeginTOTPEnrollment(user.Username)
+	if err != nil {
+		ws.writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to start TOTP enrollment: %v", err),
+		})
+		return
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Add the secret to an authenticator app and confirm with a code",
+		Data:    enrollment,
+	})
+}
+
+// handleConfirmTOTP enables TOTP with the first code from the authenticator app
+func (ws *WebServer) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user := ws.twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var req auth.TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if err := ws.authManager.ConfirmTOTPEnrollment(user.Username, req.Code); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ws.logger.Infof("TOTP enabled for user %s", user.Username)
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Two-factor authentication enabled",
+		Data:    ws.authManager.TwoFactorStatus(user.Username),
+	})
+}
+
+// handleDisableTOTP removes the requester's TOTP secret
+func (ws *WebServer) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user := ws.twoFactorUser(w, r)
+	if user == nil || !ws.checkTwoFactorPassword(w, r, user) {
+		return
+	}
+
+	if err := ws.authManager.DisableTOTP(user.Username); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ws.logger.Infof("TOTP disabled for user %s", user.Username)
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "TOTP disabled",
+		Data:    ws.authManager.TwoFactorStatus(user.Username),
+	})
+}
+
+// handleRegenerateRecoveryCodes replaces the requester's recovery codes
+func (ws *WebServer) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := ws.twoFactorUser(w, r)
+	if user == nil || !ws.checkTwoFactorPassword(w, r, user) {
+		return
+	}
+
+	codes, err := ws.authManager.RegenerateRecoveryCodes(user.Username)
+	if err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Recovery codes replaced; the old ones no longer work",
+		Data:    map[string]interface{}{"recovery_codes": codes},
+	})
+}
+
+// handleBeginPasskeyRegistration returns the options to register a passkey
+func (ws *WebServer) handleBeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user := ws.twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	options, err := ws.authManager.BeginPasskeyRegistration(user.Username, ws.authManager.RelyingParty(r))
+	if err != nil {
+		ws.writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to start passkey registration: %v", err),
+		})
+		return
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    options,
+	})
+}
+
+// handleRegisterPasskey saves a passkey registered with the options from
+// handleBeginPasskeyRegistration
+func (ws *WebServer) handleRegisterPasskey(w http.ResponseWriter, r *http.Request) {
+	user := ws.twoFactorUser(w, r)
+	if user == nil {
+		return
+	}
+
+	var req auth.PasskeyRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	passkey, codes, err := ws.authManager.FinishPasskeyRegistration(user.Username, req.Name, &req.Credential)
+	if err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to register passkey: %v", err),
+		})
+		return
+	}
+
+	ws.logger.Infof("Passkey %s (%s) registered for user %s", passkey.ID, passkey.Name, user.Username)
+
+	data := map[string]interface{}{"passkey": passkey}
+	if len(codes) > 0 {
+		data["recovery_codes"] = codes
+	}
+	ws.writeJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Passkey registered",
+		Data:    data,
+	})
+}
+
+// handleDeletePasskey removes one of the requester's passkeys
+func (ws *WebServer) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	user := ws.twoFactorUser(w, r)
+	if user == nil || !ws.checkTwoFactorPassword(w, r, user) {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := ws.authManager.DeletePasskey(user.Username, id); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrPasskeyNotFound) {
+			status = http.StatusNotFound
+		}
+		ws.writeJSONResponse(w, status, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ws.logger.Infof("Passkey %s of user %s removed", id, user.Username)
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Passkey %s removed", id),
+	})
+}
+
+// handleSetUserTwoFactor requires or stops requiring a second factor for a user,
+// or resets their second factors (admin only)
+func (ws *WebServer) handleSetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	currentUser := auth.GetUserFromContext(r.Context())
+	if currentUser == nil || !currentUser.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	if _, exists := ws.authManager.GetUser(username); !exists {
+		ws.writeJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	var req auth.TwoFactorPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ws.writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if req.Reset {
+		if err := ws.authManager.ResetTwoFactor(username); err != nil {
+			ws.writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to reset second factors: %v", err),
+			})
+			return
+		}
+		ws.logger.Infof("Second factors of user %s reset by %s", username, currentUser.Username)
+	}
+
+	user, _ := ws.authManager.GetUser(username)
+	if req.Required != nil {
+		var err error
+		user, err = ws.authManager.SetTwoFactorRequired(username, *req.Required)
+		if err != nil {
+			ws.writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to update two-factor policy: %v", err),
+			})
+			return
+		}
+		ws.logger.Infof("Second factor for user %s set to required=%t by %s", username, *req.Required, currentUser.Username)
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Two-factor settings updated",
+		Data:    user,
+	})
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ecobox-server/internal/auth"
+	"ecobox-server/internal/webhooks"
+	"github.com/gorilla/mux"
+)
+
+// handleGetWebhooks returns the webhook subscriptions without their secrets
+// (admin only)
+func (ws *WebServer) handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    ws.webhooks.List(),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleCreateWebhook registers a webhook subscription (admin only). The secret
+// is only included in this response.
+func (ws *WebServer) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	var sub webhooks.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		response := APIResponse{
+			Success: false,
+			Message: "Invalid request body",
+		}
+		ws.writeJSONResponse(w, http.StatusBadRequest, response)
+		return
+	}
+
+	created, err := ws.webhooks.Create(sub)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, webhooks.ErrInvalidSubscription) {
+			status = http.StatusBadRequest
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to create webhook: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Webhook %s created", created.ID),
+		Data:    created,
+	}
+
+	ws.writeJSONResponse(w, http.StatusCreated, response)
+}
+
+// handleDeleteWebhook removes a webhook subscription (admin only)
+func (ws *WebServer) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := ws.webhooks.Delete(id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, webhooks.ErrSubscriptionNotFound) {
+			status = http.StatusNotFound
+		}
+		response := APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to delete webhook: %v", err),
+		}
+		ws.writeJSONResponse(w, status, response)
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Webhook %s deleted", id),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
+
+// handleGetWebhookDeadLetters returns the most recent undeliverable events,
+// newest first (admin only)
+func (ws *WebServer) handleGetWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil || !user.HasRole(auth.RoleAdmin) {
+		ws.writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: "Admin privileges required",
+		})
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    ws.webhooks.DeadLetters(),
+	}
+
+	ws.writeJSONResponse(w, http.StatusOK, response)
+}
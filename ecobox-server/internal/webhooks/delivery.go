@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// queueSize is the number of events waiting per subscription before new ones
+	// go straight to the dead-letter log
+	queueSize = 256
+	// maxRetries is the number of retries after the first failed attempt
+	maxRetries = 5
+	// deadLetterHistory is the number of dead letters kept in memory
+	deadLetterHistory = 100
+	// deliveryTimeout bounds one delivery attempt
+	deliveryTimeout = 10 * time.Second
+)
+
+// retryBackoff is the delay before the first retry; it doubles for each one after
+var retryBackoff = 2 * time.Second
+
+// DeadLetter records an event that could not be delivered
+type DeadLetter struct {
+	SubscriptionID string          `json:"subscription_id"`
+	URL            string          `json:"url"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Event          json.RawMessage `json:"event"` // The body that was POSTed
+	Attempts       int             `json:"attempts"`
+	Error          string          `json:"error"`
+	FailedAt       time.Time       `json:"failed_at"`
+}
+
+// delivery is a queued event with its encoded body
+type delivery struct {
+	event Event
+	body  []byte
+}
+
+// worker delivers the events of one subscription in order
+type worker struct {
+	dispatcher *Dispatcher
+	sub        Subscription
+	queue      chan delivery
+	stopChan   chan struct{}
+	client     *http.Client
+}
+
+// startWorker starts delivering to a subscription. The caller holds the lock.
+func (d *Dispatcher) startWorker(sub *Subscription) *worker {
+	w := &worker{
+		dispatcher: d,
+		sub:        *sub,
+		queue:      make(chan delivery, queueSize),
+		stopChan:   make(chan struct{}),
+		client:     &http.Client{Timeout: deliveryTimeout},
+	}
+	go w.run()
+	return w
+}
+
+// enqueue queues an event, or dead-letters it when the queue is full
+func (w *worker) enqueue(event Event, body []byte) {
+	select {
+	case w.queue <- delivery{event: event, body: body}:
+	default:
+		w.dispatcher.deadLetter(w.sub, delivery{event: event, body: body}, 0, "delivery queue full")
+	}
+}
+
+func (w *worker) stop() {
+	close(w.stopChan)
+}
+
+func (w *worker) run() {
+	for {
+		select {
+		case d := <-w.queue:
+			w.deliver(d)
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// deliver POSTs an event, retrying network errors, 429 and 5xx responses with
+// exponential backoff
+func (w *worker) deliver(d delivery) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(d)
+		if err == nil {
+			return
+		}
+		if !retry || attempt > maxRetries {
+			w.dispatcher.logger.Warnf("Webhook %s failed to deliver %s after %d attempts: %v", w.sub.ID, d.event.ID, attempt, err)
+			w.dispatcher.deadLetter(w.sub, d, attempt, err.Error())
+			return
+		}
+
+		w.dispatcher.logger.Debugf("Webhook %s delivery of %s failed, retrying in %v: %v", w.sub.ID, d.event.ID, backoff, err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying
+func (w *worker) post(d delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.sub.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "EcoBox-Webhooks")
+	req.Header.Set("X-EcoBox-Event", d.event.Type)
+	req.Header.Set("X-EcoBox-Delivery", d.event.ID)
+	req.Header.Set("X-EcoBox-Timestamp", timestamp)
+	req.Header.Set("X-EcoBox-Signature", Sign(w.sub.Secret, timestamp, d.body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("endpoint returned HTTP %d", resp.StatusCode)
+}
+
+// Sign returns the X-EcoBox-Signature header value for a delivery: the hex
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter records an undeliverable event in memory and in the dead-letter log
+func (d *Dispatcher) deadLetter(sub Subscription, failed delivery, attempts int, reason string) {
+	letter := DeadLetter{
+		SubscriptionID: sub.ID,
+		URL:            sub.URL,
+		EventID:        failed.event.ID,
+		EventType:      failed.event.Type,
+		Event:          failed.body,
+		Attempts:       attempts,
+		Error:          reason,
+		FailedAt:       time.Now(),
+	}
+
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+
+	d.deadLetters = append(d.deadLetters, letter)
+	if len(d.deadLetters) > deadLetterHistory {
+		d.deadLetters = d.deadLetters[len(d.deadLetters)-deadLetterHistory:]
+	}
+
+	if d.deadLetterPath == "" {
+		return
+	}
+	line, err := json.Marshal(letter)
+	if err != nil {
+		d.logger.Errorf("Failed to encode dead letter for %s: %v", letter.EventID, err)
+		return
+	}
+	file, err := os.OpenFile(d.deadLetterPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		d.logger.Errorf("Failed to open webhook dead-letter log: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		d.logger.Errorf("Failed to write webhook dead-letter log: %v", err)
+	}
+}
+
+// DeadLetters returns the most recent undeliverable events, newest first
+func (d *Dispatcher) DeadLetters() []DeadLetter {
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(d.deadLetters))
+	for i := len(d.deadLetters) - 1; i >= 0; i-- {
+		letters = append(letters, d.deadLetters[i])
+	}
+	return letters
+}
@@ -0,0 +1,340 @@
+// Package webhooks POSTs server events to URLs registered through the API.
+//
+// Every update the monitor publishes becomes a server.updated event, or a
+// server.state_changed event when the power state differs from the previous
+// update for the server. Every action recorded for a server becomes a
+// server.action event. Each subscription receives the events matching its server
+// and event filters in order, signed with its secret. Failed deliveries are
+// retried with exponential backoff; deliveries that fail every attempt are
+// written to the dead-letter log.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+	"github.com/sirupsen/logrus"
+)
+
+// Event types
+const (
+	EventServerUpdated      = "server.updated"
+	EventServerStateChanged = "server.state_changed"
+	EventServerAction       = "server.action"
+)
+
+// EventTypes lists the event types subscriptions can filter on
+var EventTypes = []string{EventServerUpdated, EventServerStateChanged, EventServerAction}
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrInvalidSubscription  = errors.New("invalid subscription")
+)
+
+// Subscription registers a URL for events
+type Subscription struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"` // Only returned when the subscription is created
+	Description string    `json:"description,omitempty"`
+	Servers     []string  `json:"servers,omitempty"` // Server IDs to send events for (default: all)
+	Events      []string  `json:"events,omitempty"`  // Event types to send (default: all)
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// matches reports whether the subscription wants an event
+func (s *Subscription) matches(event Event) bool {
+	return (len(s.Servers) == 0 || contains(s.Servers, event.ServerID)) &&
+		(len(s.Events) == 0 || contains(s.Events, event.Type))
+}
+
+// Event is the JSON body POSTed to subscribers
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	ServerID  string      `json:"server_id"`
+	Data      interface{} `json:"data"` // The monitor's server update, or the server action
+}
+
+// ActionEvent is the data of a server.action event
+type ActionEvent struct {
+	ServerID string              `json:"server_id"`
+	Action   models.ServerAction `json:"action"`
+}
+
+// Dispatcher delivers events to the registered subscriptions
+type Dispatcher struct {
+	path           string
+	deadLetterPath string
+	subscriptions  []*Subscription
+	workers        map[string]*worker // By subscription ID
+	lastStates     map[string]models.PowerState
+	mu             sync.Mutex
+	deadLetters    []DeadLetter // Newest last
+	deadLetterMu   sync.Mutex
+	stopChan       chan struct{}
+	stopOnce       sync.Once
+	logger         *logrus.Logger
+}
+
+// NewDispatcher creates a dispatcher saving subscriptions to the configured file
+func NewDispatcher(cfg *config.Config) *Dispatcher {
+	return &Dispatcher{
+		path:           cfg.Dashboard.WebhooksFile,
+		deadLetterPath: cfg.Dashboard.WebhookDeadLetterFile,
+		workers:        make(map[string]*worker),
+		lastStates:     make(map[string]models.PowerState),
+		stopChan:       make(chan struct{}),
+		logger:         logrus.New(),
+	}
+}
+
+// SetLogger sets the logger for the dispatcher
+func (d *Dispatcher) SetLogger(logger *logrus.Logger) {
+	d.logger = logger
+}
+
+// Load reads the saved subscriptions and starts delivering to them. A missing
+// file means there are none.
+func (d *Dispatcher) Load() error {
+	data, err := os.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhooks file: %w", err)
+	}
+
+	var subscriptions []*Subscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		return fmt.Errorf("failed to parse webhooks file %s: %w", d.path, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, sub := range subscriptions {
+		d.subscriptions = append(d.subscriptions, sub)
+		d.workers[sub.ID] = d.startWorker(sub)
+	}
+	if len(subscriptions) > 0 {
+		d.logger.Infof("Loaded %d webhook subscriptions from %s", len(subscriptions), d.path)
+	}
+	return nil
+}
+
+// Start turns monitor updates into events until Stop is called. Actions arrive
+// through HandleAction, registered as a storage action listener.
+func (d *Dispatcher) Start(updates <-chan monitor.ServerUpdate) {
+	go func() {
+		for {
+			select {
+			case update := <-updates:
+				d.HandleUpdate(update)
+			case <-d.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends delivery. Queued events that were not delivered are dropped.
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopChan)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for _, w := range d.workers {
+			w.stop()
+		}
+	})
+}
+
+// HandleUpdate sends a server.updated or server.state_changed event
+func (d *Dispatcher) HandleUpdate(update monitor.ServerUpdate) {
+	d.mu.Lock()
+	previous, seen := d.lastStates[update.ServerID]
+	d.lastStates[update.ServerID] = update.State
+	d.mu.Unlock()
+
+	eventType := EventServerUpdated
+	if seen && previous != update.State {
+		eventType = EventServerStateChanged
+	}
+	d.dispatch(newEvent(eventType, update.ServerID, update))
+}
+
+// HandleAction sends a server.action event
+func (d *Dispatcher) HandleAction(serverID string, action models.ServerAction) {
+	d.dispatch(newEvent(EventServerAction, serverID, ActionEvent{ServerID: serverID, Action: action}))
+}
+
+// dispatch queues an event for every matching subscription. The body is encoded
+// once, before the server it describes changes again.
+func (d *Dispatcher) dispatch(event Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var body []byte
+	for _, sub := range d.subscriptions {
+		if !sub.matches(event) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(event); err != nil {
+				d.logger.Errorf("Failed to encode webhook event %s: %v", event.ID, err)
+				return
+			}
+		}
+		d.workers[sub.ID].enqueue(event, body)
+	}
+}
+
+// List returns the subscriptions without their secrets
+func (d *Dispatcher) List() []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscriptions := make([]Subscription, 0, len(d.subscriptions))
+	for _, sub := range d.subscriptions {
+		copied := *sub
+		copied.Secret = ""
+		subscriptions = append(subscriptions, copied)
+	}
+	return subscriptions
+}
+
+// Create validates and saves a subscription. A secret is generated unless one is
+// given. The returned subscription includes the secret.
+func (d *Dispatcher) Create(sub Subscription) (*Subscription, error) {
+	if err := validateSubscription(sub); err != nil {
+		return nil, err
+	}
+
+	sub.ID = newID("wh_")
+	sub.CreatedAt = time.Now()
+	if sub.Secret == "" {
+		sub.Secret = newSecret()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscriptions := append(append([]*Subscription(nil), d.subscriptions...), &sub)
+	if err := d.saveLocked(subscriptions); err != nil {
+		return nil, err
+	}
+	d.subscriptions = subscriptions
+	d.workers[sub.ID] = d.startWorker(&sub)
+
+	d.logger.Infof("Added webhook subscription %s for %s", sub.ID, sub.URL)
+	created := sub
+	return &created, nil
+}
+
+// Delete removes a subscription and drops its queued events
+func (d *Dispatcher) Delete(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, sub := range d.subscriptions {
+		if sub.ID != id {
+			continue
+		}
+
+		subscriptions := append(append([]*Subscription(nil), d.subscriptions[:i]...), d.subscriptions[i+1:]...)
+		if err := d.saveLocked(subscriptions); err != nil {
+			return err
+		}
+		d.subscriptions = subscriptions
+		d.workers[id].stop()
+		delete(d.workers, id)
+
+		d.logger.Infof("Removed webhook subscription %s", id)
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrSubscriptionNotFound, id)
+}
+
+// saveLocked writes the subscriptions, including secrets, to the webhooks file
+func (d *Dispatcher) saveLocked(subscriptions []*Subscription) error {
+	data, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode webhooks: %w", err)
+	}
+
+	tmpFile := d.path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write temporary webhooks file: %w", err)
+	}
+	if err := os.Rename(tmpFile, d.path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to replace webhooks file: %w", err)
+	}
+	return nil
+}
+
+// validateSubscription checks the URL and filters of a new subscription
+func validateSubscription(sub Subscription) error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("%w: url must be an http or https URL", ErrInvalidSubscription)
+	}
+	for _, eventType := range sub.Events {
+		if !contains(EventTypes, eventType) {
+			return fmt.Errorf("%w: unknown event type '%s', must be one of: server.updated, server.state_changed, server.action", ErrInvalidSubscription, eventType)
+		}
+	}
+	if sub.Secret != "" && len(sub.Secret) < 16 {
+		return fmt.Errorf("%w: secret must be at least 16 characters", ErrInvalidSubscription)
+	}
+	return nil
+}
+
+// newEvent creates an event with a new ID
+func newEvent(eventType, serverID string, data interface{}) Event {
+	return Event{
+		ID:        newID("evt_"),
+		Type:      eventType,
+		Timestamp: time.Now(),
+		ServerID:  serverID,
+		Data:      data,
+	}
+}
+
+// newID returns a random identifier with the prefix
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return prefix + time.Now().Format("20060102150405.000000000")
+	}
+	return prefix + hex.EncodeToString(b)
+}
+
+// newSecret returns a random signing secret
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ecobox-server/internal/config"
+	"ecobox-server/internal/models"
+	"ecobox-server/internal/monitor"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Dashboard.WebhooksFile = filepath.Join(dir, "webhooks.json")
+	cfg.Dashboard.WebhookDeadLetterFile = filepath.Join(dir, "dead-letters.log")
+	d := NewDispatcher(cfg)
+	t.Cleanup(d.Stop)
+	return d
+}
+
+func waitFor(t *testing.T, deliveries <-chan received) received {
+	select {
+	case r := <-deliveries:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a delivery")
+		return received{}
+	}
+}
+
+func TestSignedDeliveryAndFilters(t *testing.T) {
+	deliveries := make(chan received, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- received{header: r.Header, body: body}
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher(t)
+	sub, err := d.Create(Subscription{URL: server.URL, Servers: []string{"nas"}, Events: []string{EventServerStateChanged, EventServerAction}})
+	if err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	if sub.Secret == "" {
+		t.Fatal("Expected a generated secret")
+	}
+	if listed := d.List(); len(listed) != 1 || listed[0].Secret != "" {
+		t.Errorf("Expected the listed subscription without its secret, got %+v", listed)
+	}
+
+	d.HandleUpdate(monitor.ServerUpdate{ServerID: "nas", State: models.PowerStateOff}) // First update is not a change
+	d.HandleUpdate(monitor.ServerUpdate{ServerID: "pve", State: models.PowerStateOff})
+	d.HandleUpdate(monitor.ServerUpdate{ServerID: "pve", State: models.PowerStateOn}) // Other server
+	d.HandleUpdate(monitor.ServerUpdate{ServerID: "nas", State: models.PowerStateOn})
+
+	r := waitFor(t, deliveries)
+	if r.header.Get("X-EcoBox-Event") != EventServerStateChanged {
+		t.Fatalf("Expected a state change event, got %q", r.header.Get("X-EcoBox-Event"))
+	}
+	if want := Sign(sub.Secret, r.header.Get("X-EcoBox-Timestamp"), r.body); r.header.Get("X-EcoBox-Signature") != want {
+		t.Errorf("Signature %q does not match %q", r.header.Get("X-EcoBox-Signature"), want)
+	}
+	var event Event
+	if err := json.Unmarshal(r.body, &event); err != nil || event.ServerID != "nas" || event.ID != r.header.Get("X-EcoBox-Delivery") {
+		t.Errorf("Unexpected event %s (%v)", r.body, err)
+	}
+
+	d.HandleAction("nas", models.ServerAction{Action: models.ActionTypeWakeUp, Success: true, InitiatedBy: "admin"})
+	if r := waitFor(t, deliveries); r.header.Get("X-EcoBox-Event") != EventServerAction {
+		t.Errorf("Expected an action event, got %q", r.header.Get("X-EcoBox-Event"))
+	}
+
+	select {
+	case r := <-deliveries:
+		t.Errorf("Unexpected delivery %s", r.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Subscriptions survive a restart
+	reloaded := NewDispatcher(&config.Config{Dashboard: config.DashboardConfig{WebhooksFile: d.path}})
+	defer reloaded.Stop()
+	if err := reloaded.Load(); err != nil || len(reloaded.List()) != 1 {
+		t.Errorf("Expected the saved subscription to load, got %+v (%v)", reloaded.List(), err)
+	}
+}
+
+func TestRetriesAndDeadLetters(t *testing.T) {
+	retryBackoff = 10 * time.Millisecond
+	defer func() { retryBackoff = 2 * time.Second }()
+
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/rejected":
+			w.WriteHeader(http.StatusBadRequest)
+		case atomic.AddInt32(&attempts, 1) <= 2:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher(t)
+	if _, err := d.Create(Subscription{URL: server.URL + "/flaky"}); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	if _, err := d.Create(Subscription{URL: server.URL + "/rejected"}); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+
+	d.HandleAction("nas", models.ServerAction{Action: models.ActionTypeWakeUp})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.DeadLetters()) == 0 || atomic.LoadInt32(&attempts) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out; %d attempts, dead letters %+v", atomic.LoadInt32(&attempts), d.DeadLetters())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	letters := d.DeadLetters()
+	if len(letters) != 1 || letters[0].URL != server.URL+"/rejected" || letters[0].Attempts != 1 || letters[0].EventType != EventServerAction {
+		t.Errorf("Expected only the rejected delivery to be dead-lettered, got %+v", letters)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("Expected the flaky endpoint to succeed on the third attempt, got %d attempts", got)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	d := newTestDispatcher(t)
+	for _, sub := range []Subscription{
+		{URL: "ftp://example.com/hook"},
+		{URL: "not a url"},
+		{URL: "https://example.com/hook", Events: []string{"server.deleted"}},
+		{URL: "https://example.com/hook", Secret: "short"},
+	} {
+		if _, err := d.Create(sub); err == nil {
+			t.Errorf("Expected %+v to be rejected", sub)
+		}
+	}
+	if err := d.Delete("wh_missing"); err == nil {
+		t.Error("Expected an error deleting an unknown subscription")
+	}
+}
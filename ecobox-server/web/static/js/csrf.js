@@ -0,0 +1,23 @@
+// Adds the CSRF token to the page's requests that change something. The
+// server sets it in the XSRF-TOKEN cookie and refuses changes made with the
+// session cookies unless the X-XSRF-TOKEN header repeats it.
+(() => {
+    const safeMethods = ['GET', 'HEAD', 'OPTIONS'];
+    const originalFetch = window.fetch;
+
+    function csrfToken() {
+        const match = document.cookie.match(/(?:^|;\s*)XSRF-TOKEN=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    window.fetch = (input, init = {}) => {
+        const url = new URL(input instanceof Request ? input.url : input, window.location.href);
+        const method = (init.method || (input instanceof Request ? input.method : 'GET')).toUpperCase();
+        if (url.origin === window.location.origin && !safeMethods.includes(method)) {
+            const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+            headers.set('X-XSRF-TOKEN', csrfToken());
+            init = { ...init, headers };
+        }
+        return originalFetch(input, init);
+    };
+})();
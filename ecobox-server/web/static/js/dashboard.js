@@ -124,16 +124,31 @@ class NetworkDashboard {
     }
 
     createPowerButtons(server) {
-        const canWake = server.current_state === 'off' || server.current_state === 'suspended';
+        const caps = server.capabilities || {};
+        const canWake = ['off', 'suspended', 'hibernated', 'stopped'].includes(server.current_state);
         const canSuspend = server.current_state === 'on';
+        const canRestart = server.current_state === 'on';
 
         return `
             <button class="btn btn-success" onclick="dashboard.wakeServer('${server.id}')" ${!canWake ? 'disabled' : ''}>
                 Wake Up
             </button>
+            ${caps.suspend !== false ? `
             <button class="btn btn-warning" onclick="dashboard.suspendServer('${server.id}')" ${!canSuspend ? 'disabled' : ''}>
                 Suspend
-            </button>
+            </button>` : ''}
+            ${caps.hibernate ? `
+            <button class="btn btn-warning" onclick="dashboard.powerAction('${server.id}', 'hibernate')" ${!canSuspend ? 'disabled' : ''}>
+                Hibernate
+            </button>` : ''}
+            ${caps.restart ? `
+            <button class="btn btn-secondary" onclick="dashboard.powerAction('${server.id}', 'restart')" ${!canRestart ? 'disabled' : ''}>
+                Restart
+            </button>` : ''}
+            ${caps.reset ? `
+            <button class="btn btn-danger" onclick="dashboard.powerAction('${server.id}', 'reset')">
+                Reset
+            </button>` : ''}
             <button class="btn btn-info" onclick="dashboard.showMetrics('${server.id}', '${this.escapeHtml(server.name)}')">
                 Show Metrics
             </button>
@@ -194,6 +209,25 @@ class NetworkDashboard {
         }
     }
 
+    async powerAction(serverId, action) {
+        if (!confirm(`Are you sure you want to ${action} this server?`)) {
+            return;
+        }
+
+        try {
+            const response = await fetch(`/api/servers/${serverId}/${action}`, {
+                method: 'POST'
+            });
+            const data = await response.json();
+            
+            if (!data.success) {
+                this.showError(data.message);
+            }
+        } catch (error) {
+            this.showError(`Failed to ${action} server: ` + error.message);
+        }
+    }
+
     showMetrics(serverId, serverName) {
         this.createMetricsModal(serverId, serverName);
     }
@@ -0,0 +1,76 @@
+// Passkey (WebAuthn) helpers for the login and account pages. The server sends
+// and expects binary values as base64url strings.
+const passkeys = (() => {
+    function toBuffer(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const padded = base64 + '==='.slice((base64.length + 3) % 4);
+        return Uint8Array.from(atob(padded), c => c.charCodeAt(0)).buffer;
+    }
+
+    function toBase64URL(buffer) {
+        const bytes = new Uint8Array(buffer);
+        let binary = '';
+        bytes.forEach(b => binary += String.fromCharCode(b));
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    async function post(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body || {})
+        });
+        const result = await response.json();
+        if (!result.success) {
+            throw new Error(result.message);
+        }
+        return result.data;
+    }
+
+    function supported() {
+        return !!(window.PublicKeyCredential && navigator.credentials);
+    }
+
+    // register creates a passkey for the logged-in user and returns the saved
+    // passkey and, for a first factor, recovery codes
+    async function register(name) {
+        const options = await post('/api/auth/2fa/passkeys/begin');
+        options.challenge = toBuffer(options.challenge);
+        options.user.id = toBuffer(options.user.id);
+        options.excludeCredentials.forEach(c => c.id = toBuffer(c.id));
+
+        const credential = await navigator.credentials.create({ publicKey: options });
+        return post('/api/auth/2fa/passkeys', {
+            name: name,
+            credential: {
+                id: credential.id,
+                response: {
+                    clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                    attestationObject: toBase64URL(credential.response.attestationObject)
+                }
+            }
+        });
+    }
+
+    // login signs in with a passkey, completing the password login of
+    // loginToken when given
+    async function login(loginToken) {
+        const options = await post('/login/passkey/begin', { login_token: loginToken || '' });
+        options.challenge = toBuffer(options.challenge);
+        options.allowCredentials.forEach(c => c.id = toBuffer(c.id));
+        delete options.login_token;
+
+        const credential = await navigator.credentials.get({ publicKey: options });
+        return post('/login/passkey/finish', {
+            id: credential.id,
+            response: {
+                clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                authenticatorData: toBase64URL(credential.response.authenticatorData),
+                signature: toBase64URL(credential.response.signature),
+                userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : ''
+            }
+        });
+    }
+
+    return { supported, register, login };
+})();